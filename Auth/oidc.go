@@ -0,0 +1,554 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdentityProvider signs users in with an external identity service.
+type IdentityProvider interface {
+	// Name identifies the provider in login URLs, like "azuread".
+	Name() string
+	// AuthCodeURL returns the URL that starts a login. state, nonce and
+	// the PKCE challenge are echoed back or bound into the ID token.
+	AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error)
+	// Exchange redeems the authorization code from the callback and
+	// returns the verified identity.
+	Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
+
+// Identity is a user as asserted by an identity provider. Only Issuer and
+// Subject identify the user; the other claims are theirs to change.
+type Identity struct {
+	Provider      string   `json:"provider"`
+	Issuer        string   `json:"issuer"`
+	Subject       string   `json:"subject"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	Groups        []string `json:"groups,omitempty"`
+}
+
+// Key identifies the user across logins, by the issuer and the subject it
+// assigned them.
+func (i *Identity) Key() string {
+	return i.Issuer + "#" + i.Subject
+}
+
+// OIDCConfig configures an OpenID Connect provider such as Azure AD,
+// Google or Keycloak.
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim names the ID token claim listing the user's groups:
+	// "groups" for Azure AD and Keycloak, or "roles" for Azure AD app
+	// roles. Google issues none.
+	GroupsClaim string
+	// UsernameClaim names the claim the local username is taken from,
+	// falling back to the email address.
+	UsernameClaim string
+	// Tenants are the Azure AD tenant IDs whose users may sign in through
+	// a multi-tenant issuer like https://login.microsoftonline.com/common/v2.0,
+	// which is refused without them.
+	Tenants []string
+}
+
+// OIDCConfigsFromEnv reads the providers listed in OIDC_PROVIDERS. Each
+// provider NAME is configured by OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID,
+// OIDC_NAME_CLIENT_SECRET and optionally OIDC_NAME_SCOPES,
+// OIDC_NAME_GROUPS_CLAIM, OIDC_NAME_USERNAME_CLAIM and OIDC_NAME_TENANTS, a
+// comma separated list. Callbacks go to baseURL/auth/<name>/callback.
+func OIDCConfigsFromEnv(baseURL string) ([]OIDCConfig, error) {
+	var configs []OIDCConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := OIDCConfig{
+			Name:          name,
+			Issuer:        strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:   baseURL + "/auth/" + name + "/callback",
+			Scopes:        strings.Fields(os.Getenv(prefix + "SCOPES")),
+			GroupsClaim:   os.Getenv(prefix + "GROUPS_CLAIM"),
+			UsernameClaim: os.Getenv(prefix + "USERNAME_CLAIM"),
+		}
+		for _, tenant := range strings.Split(os.Getenv(prefix+"TENANTS"), ",") {
+			if tenant = strings.TrimSpace(tenant); tenant != "" {
+				config.Tenants = append(config.Tenants, tenant)
+			}
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"openid", "email", "profile"}
+		}
+		if config.GroupsClaim == "" {
+			config.GroupsClaim = "groups"
+		}
+		if config.UsernameClaim == "" {
+			config.UsernameClaim = "preferred_username"
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// NewPKCE returns a PKCE verifier and its S256 challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns n random bytes, base64url encoded.
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+const (
+	oidcTimeout = 15 * time.Second
+	// clockSkew is allowed between this server and the provider when
+	// checking a token's validity period.
+	clockSkew = 2 * time.Minute
+)
+
+// OIDCProvider implements IdentityProvider with the authorization code
+// flow and PKCE, verifying ID tokens against the issuer's published keys.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mux       sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{config: config, client: &http.Client{Timeout: oidcTimeout}}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// discover fetches the issuer's metadata once it is first needed, so the
+// server starts even while the provider is unreachable.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %v", p.config.Name, err)
+	}
+	// Azure AD's multi-tenant "common" endpoint publishes a templated
+	// issuer, accepted for the configured tenants only; every other
+	// provider must publish the configured one.
+	templated := strings.Contains(discovery.Issuer, "{tenantid}")
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer && !templated {
+		return nil, fmt.Errorf("OIDC provider %s reports issuer %s, want %s", p.config.Name, discovery.Issuer, p.config.Issuer)
+	}
+	if templated && len(p.config.Tenants) == 0 {
+		return nil, fmt.Errorf("OIDC provider %s is multi-tenant; list the tenants allowed to sign in in OIDC_%s_TENANTS",
+			p.config.Name, strings.ToUpper(strings.ReplaceAll(p.config.Name, "-", "_")))
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider %s metadata is incomplete", p.config.Name)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := p.verify(ctx, token.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims)
+}
+
+// verify checks the ID token's signature, issuer, audience, validity period
+// and nonce, and returns its claims.
+func (p *OIDCProvider) verify(ctx context.Context, rawToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %v", err)
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	issuer, _ := claims["iss"].(string)
+	if strings.TrimSuffix(issuer, "/") != p.config.Issuer && !p.templatedIssuer(discovery, issuer, claims) {
+		return nil, fmt.Errorf("ID token issued by %s, want %s", issuer, p.config.Issuer)
+	}
+	if !audienceContains(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("ID token is not issued to client %s", p.config.ClientID)
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("ID token is not valid yet")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match the login")
+	}
+	return claims, nil
+}
+
+// templatedIssuer accepts the per-tenant issuer of a token from Azure AD's
+// multi-tenant endpoint, if the tenant is one of the configured ones.
+// discovery is the provider's metadata, as discover returned it.
+func (p *OIDCProvider) templatedIssuer(discovery *oidcDiscovery, issuer string, claims map[string]interface{}) bool {
+	tenant, _ := claims["tid"].(string)
+	if tenant == "" || !slices.Contains(p.config.Tenants, tenant) {
+		return false
+	}
+	return strings.Contains(discovery.Issuer, "{tenantid}") &&
+		strings.Replace(discovery.Issuer, "{tenantid}", tenant, 1) == issuer
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// identity maps verified claims to an Identity.
+func (p *OIDCProvider) identity(claims map[string]interface{}) (*Identity, error) {
+	identity := &Identity{Provider: p.config.Name}
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+
+	username, _ := claims[p.config.UsernameClaim].(string)
+	if username == "" {
+		username = identity.Email
+	}
+	if identity.Username = SanitizeUsername(username); identity.Username == "" {
+		return nil, fmt.Errorf("ID token has no %s or email claim", p.config.UsernameClaim)
+	}
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+	return identity, nil
+}
+
+// SanitizeUsername turns a claim like alice@example.com into a username
+// that is safe in deployment IDs and file names.
+func SanitizeUsername(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(value)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), ".-")
+}
+
+// key returns the signing key with kid, refetching the issuer's keys when
+// it is unknown so rotated keys are picked up.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// Rate-limit refetches triggered by tokens with unknown key IDs.
+	if p.keys != nil && time.Since(p.keysAt) < time.Minute {
+		return nil, fmt.Errorf("ID token signed with unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys, p.keysAt = keys, time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ID token signed with unknown key %q", kid)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, target string, v interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// verifySignature checks a JWS signature made with one of the algorithms
+// OIDC providers sign ID tokens with.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid ID token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid ID token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("ID token algorithm %s does not match its key", alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
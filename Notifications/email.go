@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPConfig is read from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD
+// and SMTP_FROM. Email is disabled when SMTP_HOST is not set.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func SMTPConfigFromEnv() SMTPConfig {
+	config := SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.From == "" {
+		config.From = config.Username
+	}
+	return config
+}
+
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != "" && c.From != ""
+}
+
+// SendEmail sends a plain-text message. net/smtp upgrades to STARTTLS when
+// the server offers it and refuses PLAIN auth over an unencrypted link.
+func (c SMTPConfig) SendEmail(to, subject, body string) error {
+	if !c.Enabled() {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header value")
+	}
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + c.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	addr := c.Host + ":" + c.Port
+	if err := smtp.SendMail(addr, auth, c.From, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", to, err)
+	}
+	return nil
+}
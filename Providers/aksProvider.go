@@ -0,0 +1,279 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AKSProvider runs the app on Kubernetes instead of a VM. It provisions an
+// AKS cluster and a container registry the cluster pulls from. With
+// Kubeconfig set it deploys to that existing cluster and pushes images to
+// Registry instead, and provisions nothing.
+type AKSProvider struct {
+	terraformRunner
+	ResourceGroup  string
+	Location       string
+	SubscriptionID string
+	ClusterName    string
+	// RegistryName must be globally unique and alphanumeric.
+	RegistryName string
+	NodeSize     string
+	NodeCount    int
+	// NodeDiskSizeGB is the OS disk size of each node, or AKS's default if
+	// zero.
+	NodeDiskSizeGB int
+	// Kubeconfig and Registry, a registry login server, select an
+	// existing cluster.
+	Kubeconfig string
+	Registry   string
+	// Credentials authenticate Terraform, or the server's from
+	// AzureCredentialsFromEnv if nil.
+	Credentials *AzureCredentials
+}
+
+// Existing reports whether the provider deploys to an existing cluster.
+func (k *AKSProvider) Existing() bool {
+	return k.Kubeconfig != ""
+}
+
+const aksTfTemplate = `
+terraform {
+  required_version = "` + TerraformRequiredVersion + `"
+
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "` + AzureRMVersion + `"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+  subscription_id = var.subscription_id
+{{ .AuthArguments -}}
+}
+
+variable "subscription_id" {
+  description = "Azure subscription ID"
+  type        = string
+}
+{{ .AuthVariables }}
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+resource "azurerm_resource_group" "main" {
+  name     = "{{ .ResourceGroup }}"
+  location = "{{ .Location }}"
+}
+
+resource "azurerm_container_registry" "main" {
+  name                = "{{ .RegistryName }}"
+  resource_group_name = azurerm_resource_group.main.name
+  location            = azurerm_resource_group.main.location
+  sku                 = "Basic"
+  admin_enabled       = false
+}
+
+resource "azurerm_kubernetes_cluster" "main" {
+  name                = "{{ .ClusterName }}"
+  location            = azurerm_resource_group.main.location
+  resource_group_name = azurerm_resource_group.main.name
+  dns_prefix          = "{{ .ClusterName }}"
+
+  default_node_pool {
+    name       = "default"
+    node_count = {{ .NodeCount }}
+    vm_size    = "{{ .NodeSize }}"
+{{- if .NodeDiskSizeGB }}
+    os_disk_size_gb = {{ .NodeDiskSizeGB }}
+{{- end }}
+  }
+
+  identity {
+    type = "SystemAssigned"
+  }
+
+  linux_profile {
+    admin_username = "azureuser"
+
+    ssh_key {
+      key_data = trimspace(var.public_key_content)
+    }
+  }
+
+  tags = {
+    environment = "django-vpc"
+  }
+}
+
+# Lets the cluster's nodes pull the images built into the registry
+resource "azurerm_role_assignment" "acr_pull" {
+  principal_id                     = azurerm_kubernetes_cluster.main.kubelet_identity[0].object_id
+  role_definition_name             = "AcrPull"
+  scope                            = azurerm_container_registry.main.id
+  skip_service_principal_aad_check = true
+}
+
+# Outputs
+output "vm_name" {
+  value = azurerm_kubernetes_cluster.main.name
+}
+
+output "resource_group" {
+  value = azurerm_resource_group.main.name
+}
+
+output "registry" {
+  value = azurerm_container_registry.main.login_server
+}
+
+output "kube_config" {
+  value     = azurerm_kubernetes_cluster.main.kube_config_raw
+  sensitive = true
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars for a new
+// cluster. An existing cluster needs no configuration.
+func (k *AKSProvider) GenerateTerraformConfig(path string) error {
+	if k.Existing() {
+		k.broadcastLog("info", "Using existing Kubernetes cluster, no Terraform configuration needed", "terraform")
+		return nil
+	}
+	k.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	subscriptionID := k.SubscriptionID
+	if subscriptionID == "" {
+		subscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
+	}
+	if subscriptionID == "" {
+		k.broadcastLog("error", "AZURE_SUBSCRIPTION_ID environment variable is not set", "terraform")
+		return fmt.Errorf("AZURE_SUBSCRIPTION_ID environment variable is not set")
+	}
+
+	publicKeyContent, privateKeyContent, err := k.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := k.writeMainTF(path, aksTfTemplate, k); err != nil {
+		return err
+	}
+	credentials := resolveAzureCredentials(k.Credentials)
+	k.env = credentials.terraformEnv()
+	if err := k.writeTFVars(path, publicKeyContent, privateKeyContent, fmt.Sprintf("subscription_id = %q\n", subscriptionID)+credentials.tfvars()); err != nil {
+		return err
+	}
+
+	k.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (k *AKSProvider) InitTerraform(path string) error {
+	if k.Existing() {
+		return nil
+	}
+	return k.terraformRunner.InitTerraform(path)
+}
+
+func (k *AKSProvider) PlanTerraform(path string) (string, error) {
+	if k.Existing() {
+		return "No infrastructure changes: deploying to an existing Kubernetes cluster.\n", nil
+	}
+	return k.terraformRunner.PlanTerraform(path)
+}
+
+func (k *AKSProvider) ApplyTerraform(path string) error {
+	if k.Existing() {
+		return nil
+	}
+	return k.terraformRunner.ApplyTerraform(path)
+}
+
+func (k *AKSProvider) ApplyTerraformPlan(path string) error {
+	if k.Existing() {
+		return nil
+	}
+	return k.terraformRunner.ApplyTerraformPlan(path)
+}
+
+// GetOutput adds kube_config and registry to the usual outputs. The
+// kubeconfig holds cluster credentials, so it is never logged. There is no
+// public_ip: the app is reached through the cluster's ingress controller.
+func (k *AKSProvider) GetOutput(path, key string) (string, error) {
+	if k.Existing() {
+		switch key {
+		case "kube_config":
+			return k.Kubeconfig, nil
+		case "registry":
+			return k.Registry, nil
+		case "vm_name":
+			return k.ClusterName, nil
+		}
+		return "", fmt.Errorf("existing cluster has no output %q", key)
+	}
+	if key == "public_ip" {
+		return "", fmt.Errorf("AKS deployments have no public_ip output")
+	}
+	if key != "kube_config" {
+		return k.terraformRunner.GetOutput(path, key)
+	}
+
+	cmd := terraformCommand(k.ctx, "output", "-raw", key)
+	cmd.Dir = path
+	if err := isolate(cmd); err != nil {
+		return "", err
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		k.broadcastLog("error", fmt.Sprintf("Failed to get Terraform output %s: %v", key, err), "terraform")
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// WriteInventory does nothing; the app runs in containers, not over SSH.
+func (k *AKSProvider) WriteInventory(path, ip string) error {
+	return nil
+}
+
+// Destroy deletes the cluster and registry. An existing cluster is left
+// alone.
+func (k *AKSProvider) Destroy(path string) error {
+	if k.Existing() {
+		k.broadcastLog("info", "Existing Kubernetes cluster is not managed by this tool, nothing to destroy", "terraform")
+		return nil
+	}
+	k.env = resolveAzureCredentials(k.Credentials).terraformEnv()
+	return k.terraformRunner.Destroy(path)
+}
+
+// RegistryToken returns an access token that pulls from the Azure container
+// registry at loginServer, for tools like trivy that cannot use the az
+// CLI's login. The token is valid for a few hours.
+func (k *AKSProvider) RegistryToken(loginServer string) (string, error) {
+	name, _, _ := strings.Cut(loginServer, ".")
+	configDir, err := azConfigDir(resolveAzureCredentials(k.Credentials))
+	if err != nil {
+		return "", err
+	}
+	args := []string{"acr", "login", "--name", name, "--expose-token", "--query", "accessToken", "-o", "tsv"}
+	if k.SubscriptionID != "" {
+		args = append(args, "--subscription", k.SubscriptionID)
+	}
+	output, err := runAzIn(configDir, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
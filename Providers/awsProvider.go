@@ -0,0 +1,345 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AWSProvider provisions an EC2 instance in its own VPC. Name prefixes the
+// resources it creates and is the instance's Name tag.
+type AWSProvider struct {
+	terraformRunner
+	Name         string
+	Region       string
+	InstanceType string
+	// VolumeSizeGB is the root volume size, or DefaultDiskSizeGB if zero.
+	VolumeSizeGB int
+}
+
+// RootVolumeSizeGB returns the root volume size the instance is
+// provisioned with.
+func (a *AWSProvider) RootVolumeSizeGB() int {
+	if a.VolumeSizeGB > 0 {
+		return a.VolumeSizeGB
+	}
+	return DefaultDiskSizeGB
+}
+
+// awsRegions are the regions this tool deploys to.
+var awsRegions = map[string]bool{
+	"us-east-1":      true,
+	"us-east-2":      true,
+	"us-west-1":      true,
+	"us-west-2":      true,
+	"ca-central-1":   true,
+	"eu-west-1":      true,
+	"eu-west-2":      true,
+	"eu-central-1":   true,
+	"eu-north-1":     true,
+	"ap-south-1":     true,
+	"ap-southeast-1": true,
+	"ap-southeast-2": true,
+	"ap-northeast-1": true,
+	"sa-east-1":      true,
+}
+
+// awsInstanceTypes are the instance types this tool deploys.
+var awsInstanceTypes = map[string]bool{
+	"t3.small":    true,
+	"t3.medium":   true,
+	"t3.large":    true,
+	"t3.xlarge":   true,
+	"m6i.large":   true,
+	"m6i.xlarge":  true,
+	"c6i.large":   true,
+	"c6i.xlarge":  true,
+	"t4g.small":   true,
+	"t4g.medium":  true,
+	"t4g.large":   true,
+	"t4g.xlarge":  true,
+	"m7g.large":   true,
+	"m7g.xlarge":  true,
+	"g4dn.xlarge": true,
+	"g5.xlarge":   true,
+}
+
+// IsKnownAWSRegion reports whether region is an AWS region this tool
+// deploys to.
+func IsKnownAWSRegion(region string) bool {
+	return awsRegions[region]
+}
+
+// IsKnownAWSInstanceType reports whether instanceType is an EC2 instance
+// type this tool deploys.
+func IsKnownAWSInstanceType(instanceType string) bool {
+	return awsInstanceTypes[instanceType]
+}
+
+// IsARM64InstanceType reports whether instanceType is a Graviton (arm64)
+// type. AWS marks these with a "g" after the generation, e.g. t4g.medium.
+func IsARM64InstanceType(instanceType string) bool {
+	family, _, _ := strings.Cut(instanceType, ".")
+	i := strings.IndexAny(family, "0123456789")
+	return i >= 0 && strings.Contains(family[i:], "g")
+}
+
+// IsGPUInstanceType reports whether instanceType is a G- or P-family
+// (NVIDIA GPU) type.
+func IsGPUInstanceType(instanceType string) bool {
+	return strings.HasPrefix(instanceType, "g") || strings.HasPrefix(instanceType, "p")
+}
+
+// AMIArchitecture returns the Ubuntu image architecture matching the
+// instance type.
+func (a *AWSProvider) AMIArchitecture() string {
+	if IsARM64InstanceType(a.InstanceType) {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// The generated playbook deploys as azureuser, so cloud-init creates that
+// account rather than relying on the AMI's default ubuntu user.
+const awsTfTemplate = `
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = "{{ .Region }}"
+}
+
+# Local file resources for SSH keys
+resource "local_file" "private_key" {
+  content         = var.private_key_content
+  filename        = "${path.module}/azure_vm_key"
+  file_permission = "0600"
+}
+
+resource "local_file" "public_key" {
+  content         = var.public_key_content
+  filename        = "${path.module}/azure_vm_key.pub"
+  file_permission = "0644"
+}
+
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+  owners      = ["099720109477"] # Canonical
+
+  filter {
+    name   = "name"
+    values = ["ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-{{ .AMIArchitecture }}-server-*"]
+  }
+
+  filter {
+    name   = "virtualization-type"
+    values = ["hvm"]
+  }
+}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+
+resource "aws_vpc" "main" {
+  cidr_block           = "10.0.0.0/16"
+  enable_dns_support   = true
+  enable_dns_hostnames = true
+
+  tags = {
+    Name = "{{ .Name }}-vpc"
+  }
+}
+
+resource "aws_internet_gateway" "main" {
+  vpc_id = aws_vpc.main.id
+
+  tags = {
+    Name = "{{ .Name }}-igw"
+  }
+}
+
+resource "aws_subnet" "main" {
+  vpc_id                  = aws_vpc.main.id
+  cidr_block              = "10.0.2.0/24"
+  availability_zone       = data.aws_availability_zones.available.names[0]
+  map_public_ip_on_launch = true
+
+  tags = {
+    Name = "{{ .Name }}-subnet"
+  }
+}
+
+resource "aws_route_table" "main" {
+  vpc_id = aws_vpc.main.id
+
+  route {
+    cidr_block = "0.0.0.0/0"
+    gateway_id = aws_internet_gateway.main.id
+  }
+
+  tags = {
+    Name = "{{ .Name }}-rt"
+  }
+}
+
+resource "aws_route_table_association" "main" {
+  subnet_id      = aws_subnet.main.id
+  route_table_id = aws_route_table.main.id
+}
+
+resource "aws_security_group" "main" {
+  name_prefix = "{{ .Name }}-"
+  description = "Django app access"
+  vpc_id      = aws_vpc.main.id
+
+  # SSH access
+  ingress {
+    from_port   = 22
+    to_port     = 22
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"] # Consider restricting to your IP range
+  }
+
+  # HTTP access
+  ingress {
+    from_port   = 80
+    to_port     = 80
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  # HTTPS access
+  ingress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  # Custom application port
+  ingress {
+    from_port   = 8000
+    to_port     = 8000
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_key_pair" "main" {
+  key_name_prefix = "{{ .Name }}-"
+  public_key      = var.public_key_content
+}
+
+resource "aws_instance" "main" {
+  ami                    = data.aws_ami.ubuntu.id
+  instance_type          = "{{ .InstanceType }}"
+  subnet_id              = aws_subnet.main.id
+  vpc_security_group_ids = [aws_security_group.main.id]
+  key_name               = aws_key_pair.main.key_name
+
+  user_data = <<-EOT
+    #cloud-config
+    users:
+      - default
+      - name: azureuser
+        shell: /bin/bash
+        sudo: ALL=(ALL) NOPASSWD:ALL
+        ssh_authorized_keys:
+          - ${trimspace(var.public_key_content)}
+  EOT
+
+  root_block_device {
+    volume_size = {{ .RootVolumeSizeGB }}
+    volume_type = "gp3"
+    encrypted   = true
+  }
+
+  tags = {
+    Name     = "{{ .Name }}"
+    Security = "SSH-Keys-Only"
+  }
+
+  # Ensure SSH keys are created before the instance
+  depends_on = [local_file.private_key, local_file.public_key]
+}
+
+resource "aws_eip" "main" {
+  instance = aws_instance.main.id
+  domain   = "vpc"
+
+  depends_on = [aws_internet_gateway.main]
+}
+
+# Outputs
+output "public_ip" {
+  value = aws_eip.main.public_ip
+}
+
+output "vm_name" {
+  value = aws_instance.main.tags["Name"]
+}
+
+output "instance_id" {
+  value = aws_instance.main.id
+}
+
+output "ssh_connection_command" {
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${aws_eip.main.public_ip}"
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars. Credentials
+// come from the standard AWS environment variables or shared config, which
+// Terraform reads itself.
+func (a *AWSProvider) GenerateTerraformConfig(path string) error {
+	a.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+		a.broadcastLog("error", "Neither AWS_ACCESS_KEY_ID nor AWS_PROFILE is set", "terraform")
+		return fmt.Errorf("neither AWS_ACCESS_KEY_ID nor AWS_PROFILE is set")
+	}
+
+	publicKeyContent, privateKeyContent, err := a.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := a.writeMainTF(path, awsTfTemplate, a); err != nil {
+		return err
+	}
+	if err := a.writeTFVars(path, publicKeyContent, privateKeyContent, ""); err != nil {
+		return err
+	}
+
+	a.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (a *AWSProvider) WriteInventory(path, ip string) error {
+	return a.writeInventory(path, "aws", ip)
+}
@@ -0,0 +1,147 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	armScope = "https://management.azure.com/.default"
+	// armOperationTimeout bounds the Resource Manager calls of a plan,
+	// apply or destroy, including waiting for long-running operations like
+	// creating a VM or deleting a resource group.
+	armOperationTimeout = 30 * time.Minute
+)
+
+// armCredentials caches a credential per set of credentials, so each
+// reuses its tokens across deployments.
+var armCredentials = struct {
+	sync.Mutex
+	credentials map[string]azcore.TokenCredential
+}{credentials: make(map[string]azcore.TokenCredential)}
+
+// azureCredential returns the azidentity credential of credentials: a
+// service principal's client secret, a managed identity, or otherwise the
+// default chain of the environment, workload identity, managed identity
+// and az CLI login.
+func azureCredential(credentials AzureCredentials) (azcore.TokenCredential, error) {
+	key := strings.Join([]string{credentials.Mode(), credentials.TenantID, credentials.ClientID, credentials.ClientSecret}, "\x00")
+	armCredentials.Lock()
+	defer armCredentials.Unlock()
+	if credential, ok := armCredentials.credentials[key]; ok {
+		return credential, nil
+	}
+
+	var credential azcore.TokenCredential
+	var err error
+	switch credentials.Mode() {
+	case AzureAuthServicePrincipal:
+		credential, err = azidentity.NewClientSecretCredential(credentials.TenantID, credentials.ClientID, credentials.ClientSecret, nil)
+	case AzureAuthManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if credentials.ClientID != "" {
+			options.ID = azidentity.ClientID(credentials.ClientID)
+		}
+		credential, err = azidentity.NewManagedIdentityCredential(options)
+	default:
+		credential, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %v", err)
+	}
+	armCredentials.credentials[key] = credential
+	return credential, nil
+}
+
+// armClients are the Resource Manager clients of the SDK backend, for one
+// subscription and set of credentials.
+type armClients struct {
+	credentials    AzureCredentials
+	credential     azcore.TokenCredential
+	subscriptionID string
+
+	groups         *armresources.ResourceGroupsClient
+	resources      *armresources.Client
+	networks       *armnetwork.VirtualNetworksClient
+	publicIPs      *armnetwork.PublicIPAddressesClient
+	securityGroups *armnetwork.SecurityGroupsClient
+	interfaces     *armnetwork.InterfacesClient
+	machines       *armcompute.VirtualMachinesClient
+}
+
+func newARMClients(credentials AzureCredentials, subscriptionID string) (*armClients, error) {
+	credential, err := azureCredential(credentials)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := armresources.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	network, err := armnetwork.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	compute, err := armcompute.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &armClients{
+		credentials:    credentials,
+		credential:     credential,
+		subscriptionID: subscriptionID,
+		groups:         resources.NewResourceGroupsClient(),
+		resources:      resources.NewClient(),
+		networks:       network.NewVirtualNetworksClient(),
+		publicIPs:      network.NewPublicIPAddressesClient(),
+		securityGroups: network.NewSecurityGroupsClient(),
+		interfaces:     network.NewInterfacesClient(),
+		machines:       compute.NewVirtualMachinesClient(),
+	}, nil
+}
+
+// token checks the credential can get a Resource Manager token. Its
+// failures are reported as InvalidAuthenticationToken, so terraformError
+// classifies them as authentication errors.
+func (c *armClients) token(ctx context.Context) error {
+	if _, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{armScope}}); err != nil {
+		return fmt.Errorf("InvalidAuthenticationToken: %v", err)
+	}
+	return nil
+}
+
+// armResourceID returns the ID of a resource in a subscription.
+func armResourceID(subscriptionID, resourceGroup, provider, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", subscriptionID, resourceGroup, provider, name)
+}
+
+// armNotFound reports whether err is Resource Manager's answer for a
+// resource that does not exist.
+func armNotFound(err error) bool {
+	var responseErr *azcore.ResponseError
+	return errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound
+}
+
+// armSubscriptionID returns the subscription a provider deploys into.
+func armSubscriptionID(subscriptionID string) (string, error) {
+	if subscriptionID == "" {
+		subscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
+	}
+	if subscriptionID == "" {
+		return "", fmt.Errorf("AZURE_SUBSCRIPTION_ID environment variable is not set")
+	}
+	return subscriptionID, nil
+}
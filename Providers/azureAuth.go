@@ -0,0 +1,226 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Azure authentication modes.
+const (
+	AzureAuthCLI              = "cli"
+	AzureAuthServicePrincipal = "service_principal"
+	AzureAuthManagedIdentity  = "managed_identity"
+)
+
+// AzureCredentials selects how Terraform and the az CLI authenticate to
+// Azure. The zero value uses the az CLI's own login.
+type AzureCredentials struct {
+	TenantID     string `json:"tenant_id,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ManagedIdentity authenticates as the managed identity of the Azure
+	// host this server runs on. ClientID, if set, selects a user-assigned
+	// identity.
+	ManagedIdentity bool `json:"managed_identity,omitempty"`
+}
+
+// AzureCredentialsFromEnv reads the server's credentials from
+// AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, or
+// AZURE_USE_MSI for a managed identity.
+func AzureCredentialsFromEnv() AzureCredentials {
+	useMSI, _ := strconv.ParseBool(os.Getenv("AZURE_USE_MSI"))
+	return AzureCredentials{
+		TenantID:        os.Getenv("AZURE_TENANT_ID"),
+		ClientID:        os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:    os.Getenv("AZURE_CLIENT_SECRET"),
+		ManagedIdentity: useMSI,
+	}
+}
+
+// Mode returns how the credentials authenticate.
+func (c AzureCredentials) Mode() string {
+	switch {
+	case c.ManagedIdentity:
+		return AzureAuthManagedIdentity
+	case c.ClientSecret != "":
+		return AzureAuthServicePrincipal
+	}
+	return AzureAuthCLI
+}
+
+// Validate reports credentials that name a mode but not all it needs.
+func (c AzureCredentials) Validate() error {
+	switch {
+	case c.ManagedIdentity && c.ClientSecret != "":
+		return fmt.Errorf("client_secret cannot be combined with managed_identity")
+	case c.Mode() == AzureAuthServicePrincipal && (c.TenantID == "" || c.ClientID == ""):
+		return fmt.Errorf("a service principal needs tenant_id, client_id and client_secret")
+	case c.Mode() == AzureAuthCLI && (c.TenantID != "" || c.ClientID != ""):
+		return fmt.Errorf("tenant_id and client_id need a client_secret or managed_identity")
+	}
+	return nil
+}
+
+// providerArguments returns the azurerm provider block's authentication
+// arguments. The client secret is a sensitive variable that is passed in
+// the environment, so it is never written to the configuration or tfvars.
+func (c AzureCredentials) providerArguments() string {
+	var lines strings.Builder
+	switch c.Mode() {
+	case AzureAuthServicePrincipal:
+		lines.WriteString("  tenant_id       = var.tenant_id\n")
+		lines.WriteString("  client_id       = var.client_id\n")
+		lines.WriteString("  client_secret   = var.client_secret\n")
+		lines.WriteString("  use_cli         = false\n")
+	case AzureAuthManagedIdentity:
+		lines.WriteString("  use_msi         = true\n")
+		lines.WriteString("  use_cli         = false\n")
+		if c.TenantID != "" {
+			lines.WriteString("  tenant_id       = var.tenant_id\n")
+		}
+		if c.ClientID != "" {
+			lines.WriteString("  client_id       = var.client_id\n")
+		}
+	}
+	return lines.String()
+}
+
+// variables declares the variables providerArguments uses.
+func (c AzureCredentials) variables() string {
+	var blocks strings.Builder
+	if c.Mode() == AzureAuthCLI {
+		return ""
+	}
+	if c.TenantID != "" {
+		blocks.WriteString("\nvariable \"tenant_id\" {\n  description = \"Azure AD tenant ID\"\n  type        = string\n}\n")
+	}
+	if c.ClientID != "" {
+		blocks.WriteString("\nvariable \"client_id\" {\n  description = \"Service principal or managed identity client ID\"\n  type        = string\n}\n")
+	}
+	if c.Mode() == AzureAuthServicePrincipal {
+		blocks.WriteString("\nvariable \"client_secret\" {\n  description = \"Service principal client secret, passed as TF_VAR_client_secret\"\n  type        = string\n  sensitive   = true\n}\n")
+	}
+	return blocks.String()
+}
+
+// tfvars returns the tfvars lines of the variables that are not secret.
+func (c AzureCredentials) tfvars() string {
+	if c.Mode() == AzureAuthCLI {
+		return ""
+	}
+	var lines strings.Builder
+	if c.TenantID != "" {
+		lines.WriteString(fmt.Sprintf("tenant_id = %q\n", c.TenantID))
+	}
+	if c.ClientID != "" {
+		lines.WriteString(fmt.Sprintf("client_id = %q\n", c.ClientID))
+	}
+	return lines.String()
+}
+
+// terraformEnv returns the environment of Terraform commands, with the
+// client secret for the client_secret variable.
+func (c AzureCredentials) terraformEnv() []string {
+	env := os.Environ()
+	if c.Mode() == AzureAuthServicePrincipal {
+		env = append(env, "TF_VAR_client_secret="+c.ClientSecret)
+	}
+	return env
+}
+
+// resolveAzureCredentials returns credentials, or the server's if nil.
+func resolveAzureCredentials(credentials *AzureCredentials) AzureCredentials {
+	if credentials != nil {
+		return *credentials
+	}
+	return AzureCredentialsFromEnv()
+}
+
+// AuthArguments returns the authentication arguments of the provider's
+// azurerm block.
+func (a *AzureProvider) AuthArguments() string {
+	return resolveAzureCredentials(a.Credentials).providerArguments()
+}
+
+// AuthVariables declares the variables AuthArguments uses.
+func (a *AzureProvider) AuthVariables() string {
+	return resolveAzureCredentials(a.Credentials).variables()
+}
+
+// AuthArguments returns the authentication arguments of the provider's
+// azurerm block.
+func (k *AKSProvider) AuthArguments() string {
+	return resolveAzureCredentials(k.Credentials).providerArguments()
+}
+
+// AuthVariables declares the variables AuthArguments uses.
+func (k *AKSProvider) AuthVariables() string {
+	return resolveAzureCredentials(k.Credentials).variables()
+}
+
+// CLIEnv returns the environment of az CLI commands run as the provider's
+// credentials, or nil for the server's own.
+func (k *AKSProvider) CLIEnv() ([]string, error) {
+	configDir, err := azConfigDir(resolveAzureCredentials(k.Credentials))
+	if err != nil || configDir == "" {
+		return nil, err
+	}
+	return append(os.Environ(), "AZURE_CONFIG_DIR="+configDir), nil
+}
+
+// azLogins maps credentials to az CLI configuration directories logged in
+// with them, so each set logs in once and the CLI's own login is left
+// alone.
+var azLogins = struct {
+	sync.Mutex
+	dirs map[string]string
+}{dirs: make(map[string]string)}
+
+// azConfigDir returns an AZURE_CONFIG_DIR logged in with credentials, or ""
+// to use the CLI's own login.
+func azConfigDir(credentials AzureCredentials) (string, error) {
+	mode := credentials.Mode()
+	if mode == AzureAuthCLI {
+		return "", nil
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{mode, credentials.TenantID, credentials.ClientID, credentials.ClientSecret}, "\x00")))
+	key := hex.EncodeToString(sum[:12])
+
+	azLogins.Lock()
+	defer azLogins.Unlock()
+	if dir, ok := azLogins.dirs[key]; ok {
+		return dir, nil
+	}
+
+	dir := filepath.Join(os.TempDir(), "django-vpc-az", key)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create az configuration directory: %v", err)
+	}
+	args := []string{"login", "--allow-no-subscriptions", "-o", "none"}
+	if mode == AzureAuthManagedIdentity {
+		args = append(args, "--identity")
+		if credentials.ClientID != "" {
+			args = append(args, "--username", credentials.ClientID)
+		}
+	} else {
+		// az reads an argument starting with @ from that file, which keeps
+		// the secret off the command line.
+		secretFile := filepath.Join(dir, "client-secret")
+		if err := os.WriteFile(secretFile, []byte(credentials.ClientSecret), 0600); err != nil {
+			return "", fmt.Errorf("failed to write client secret: %v", err)
+		}
+		defer os.Remove(secretFile)
+		args = append(args, "--service-principal", "--username", credentials.ClientID, "--password", "@"+secretFile, "--tenant", credentials.TenantID)
+	}
+	if _, err := runAzIn(dir, args...); err != nil {
+		return "", err
+	}
+	azLogins.dirs[key] = dir
+	return dir, nil
+}
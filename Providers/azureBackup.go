@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupResourceGroup is where backups for a location are kept. It lives
+// outside every deployment's resource group so destroying a deployment
+// never takes its backups with it.
+func BackupResourceGroup(location string) string {
+	return "django-vpc-backups-" + strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
+// CopySnapshot copies the snapshot sourceID into the provider's resource
+// group and location, creating the group if needed, and returns the new
+// snapshot's ID. The copy continues in the background on Azure's side; see
+// WaitForSnapshotCopy.
+func (a *AzureProvider) CopySnapshot(sourceID, name, deploymentID string) (string, error) {
+	a.broadcastLog("info", fmt.Sprintf("Copying snapshot to %s in %s...", a.ResourceGroup, a.Location), "backup")
+
+	if _, err := a.az("group", "create", "-n", a.ResourceGroup, "-l", a.Location, "-o", "none"); err != nil {
+		return "", err
+	}
+
+	output, err := a.az("snapshot", "create",
+		"-g", a.ResourceGroup,
+		"-n", name,
+		"-l", a.Location,
+		"--source", sourceID,
+		"--incremental", "true",
+		"--copy-start", "true",
+		"--tags", "deployment_id="+deploymentID, "created_by=django-vpc",
+		"-o", "json")
+	if err != nil {
+		return "", err
+	}
+
+	var created azSnapshot
+	if err := json.Unmarshal(output, &created); err != nil {
+		return "", fmt.Errorf("failed to decode snapshot response: %v", err)
+	}
+	return created.ID, nil
+}
+
+// WaitForSnapshotCopy polls a copied snapshot until Azure reports it
+// complete.
+func (a *AzureProvider) WaitForSnapshotCopy(name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := a.az("snapshot", "show",
+			"-g", a.ResourceGroup,
+			"-n", name,
+			"--query", "completionPercent",
+			"-o", "tsv")
+		if err != nil {
+			return err
+		}
+
+		percent := strings.TrimSpace(string(output))
+		if percent == "" {
+			return nil
+		}
+		if value, err := strconv.ParseFloat(percent, 64); err == nil && value >= 100 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("snapshot copy %s did not finish within %s (at %s%%)", name, timeout, percent)
+		}
+		a.broadcastLog("info", fmt.Sprintf("Snapshot copy %s%% complete...", percent), "backup")
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// AttachDiskFromSnapshot creates a managed disk from snapshotID and attaches
+// it to the VM at lun.
+func (a *AzureProvider) AttachDiskFromSnapshot(snapshotID, diskName string, lun int) error {
+	a.broadcastLog("info", fmt.Sprintf("Creating disk %s from backup snapshot...", diskName), "restore")
+	if _, err := a.az("disk", "create",
+		"-g", a.ResourceGroup,
+		"-n", diskName,
+		"-l", a.Location,
+		"--source", snapshotID,
+		"-o", "none"); err != nil {
+		return err
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Attaching disk %s to VM %s...", diskName, a.VMName), "restore")
+	_, err := a.az("vm", "disk", "attach",
+		"-g", a.ResourceGroup,
+		"--vm-name", a.VMName,
+		"--name", diskName,
+		"--lun", strconv.Itoa(lun),
+		"-o", "none")
+	return err
+}
+
+// drillVMSize is the size of the throwaway VM a restore drill runs on.
+const drillVMSize = "Standard_B1s"
+
+// CreateDrillVM creates the provider's resource group with a VM that has no
+// public address or open ports, and attaches a disk created from
+// snapshotID at lun. Drills inspect the disk with run-command, so the VM
+// is never reachable from outside. Delete the group with
+// DeleteResourceGroup when done.
+func (a *AzureProvider) CreateDrillVM(snapshotID, diskName string, lun int) error {
+	a.broadcastLog("info", fmt.Sprintf("Creating restore drill VM %s in %s...", a.VMName, a.ResourceGroup), "restore")
+	if _, err := a.az("group", "create", "-n", a.ResourceGroup, "-l", a.Location,
+		"--tags", "created_by=django-vpc", "purpose=restore-drill", "-o", "none"); err != nil {
+		return err
+	}
+
+	publicKey, _, err := generateSSHKeyPair()
+	if err != nil {
+		return err
+	}
+	if _, err := a.az("vm", "create",
+		"-g", a.ResourceGroup,
+		"-n", a.VMName,
+		"-l", a.Location,
+		"--image", "Ubuntu2204",
+		"--size", drillVMSize,
+		"--admin-username", "azureuser",
+		"--ssh-key-values", strings.TrimSpace(publicKey),
+		"--public-ip-address", "",
+		"--nsg", "",
+		"-o", "none"); err != nil {
+		return err
+	}
+	return a.AttachDiskFromSnapshot(snapshotID, diskName, lun)
+}
+
+// DeleteResourceGroup deletes the provider's resource group and everything
+// in it, without waiting for Azure to finish.
+func (a *AzureProvider) DeleteResourceGroup() error {
+	_, err := a.az("group", "delete", "-n", a.ResourceGroup, "--yes", "--no-wait")
+	return err
+}
+
+// deleteGroup deletes a resource group and everything in it, waiting for
+// Azure to finish. A group that does not exist is not an error.
+func (a *AzureProvider) deleteGroup(name string) error {
+	_, err := a.az("group", "delete", "-n", name, "--yes")
+	if err != nil && strings.Contains(err.Error(), "ResourceGroupNotFound") {
+		return nil
+	}
+	return err
+}
+
+// DetachAndDeleteDisk removes a disk attached with AttachDiskFromSnapshot.
+func (a *AzureProvider) DetachAndDeleteDisk(diskName string) error {
+	if _, err := a.az("vm", "disk", "detach",
+		"-g", a.ResourceGroup,
+		"--vm-name", a.VMName,
+		"--name", diskName,
+		"-o", "none"); err != nil {
+		return err
+	}
+	_, err := a.az("disk", "delete", "-g", a.ResourceGroup, "-n", diskName, "--yes")
+	return err
+}
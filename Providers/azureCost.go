@@ -0,0 +1,139 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const costManagementAPIVersion = "2023-03-01"
+
+// CostReport is the accrued cost of a resource group over a period.
+type CostReport struct {
+	ResourceGroup string    `json:"resource_group"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	FetchedAt     time.Time `json:"fetched_at"`
+}
+
+type costQueryResponse struct {
+	Properties struct {
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"properties"`
+}
+
+// azureAccessToken reuses the az CLI login of credentials that terraform
+// already relies on.
+func azureAccessToken(credentials AzureCredentials) (string, error) {
+	configDir, err := azConfigDir(credentials)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure access token: %v", err)
+	}
+	output, err := runAzIn(configDir, "account", "get-access-token",
+		"--resource", "https://management.azure.com/",
+		"--query", "accessToken",
+		"-o", "tsv")
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure access token: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetResourceGroupCost queries Azure Cost Management for the actual cost
+// accrued by the provider's resource group between from and to, in the
+// subscription and with the credentials the deployment was made with.
+func (a *AzureProvider) GetResourceGroupCost(from, to time.Time) (*CostReport, error) {
+	subscriptionID, err := armSubscriptionID(a.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := azureAccessToken(resolveAzureCredentials(a.Credentials))
+	if err != nil {
+		return nil, err
+	}
+
+	query := map[string]interface{}{
+		"type":      "ActualCost",
+		"timeframe": "Custom",
+		"timePeriod": map[string]string{
+			"from": from.UTC().Format(time.RFC3339),
+			"to":   to.UTC().Format(time.RFC3339),
+		},
+		"dataset": map[string]interface{}{
+			"granularity": "None",
+			"aggregation": map[string]interface{}{
+				"totalCost": map[string]string{"name": "Cost", "function": "Sum"},
+			},
+		},
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cost query: %v", err)
+	}
+
+	url := fmt.Sprintf("https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.CostManagement/query?api-version=%s",
+		subscriptionID, a.ResourceGroup, costManagementAPIVersion)
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cost management: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("cost management API error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result costQueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode cost response: %v", err)
+	}
+
+	report := &CostReport{
+		ResourceGroup: a.ResourceGroup,
+		From:          from,
+		To:            to,
+		FetchedAt:     time.Now(),
+	}
+
+	for _, row := range result.Properties.Rows {
+		for i, column := range result.Properties.Columns {
+			if i >= len(row) {
+				break
+			}
+			switch column.Name {
+			case "Cost", "PreTaxCost":
+				if amount, ok := row[i].(float64); ok {
+					report.Amount += amount
+				}
+			case "Currency":
+				if currency, ok := row[i].(string); ok {
+					report.Currency = currency
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DataDiskResourceGroupSuffix names the resource group that holds a
+// deployment's data disk, next to the deployment's own group. Like the
+// reserved public IP, the disk is kept out of the deployment's group and
+// Terraform state so it outlives the VM and is attached again when the VM
+// is rebuilt.
+const DataDiskResourceGroupSuffix = "-data"
+
+// DataDiskMountPoint is where the playbook mounts the data disk.
+const DataDiskMountPoint = "/data"
+
+// DataDiskLUN is the LUN the data disk is attached at. Azure's udev rules
+// link it as /dev/disk/azure/scsi1/lun0.
+const DataDiskLUN = 0
+
+// DataDiskResourceGroup returns the resource group of the provider's data
+// disk.
+func (a *AzureProvider) DataDiskResourceGroup() string {
+	return a.ResourceGroup + DataDiskResourceGroupSuffix
+}
+
+// ReserveDataDisk creates the provider's data disk unless it already
+// exists. An existing disk is reused as it is: its size is not changed to
+// DataDiskSizeGB, since it can only grow while detached and its filesystem
+// would need growing too. It must be in the VM's location.
+func (a *AzureProvider) ReserveDataDisk() error {
+	resourceGroup := a.DataDiskResourceGroup()
+	output, err := a.az("disk", "show",
+		"-g", resourceGroup,
+		"-n", a.DataDiskName,
+		"--query", "[location, diskSizeGB]",
+		"-o", "tsv")
+	if err == nil {
+		fields := strings.Fields(string(output))
+		if len(fields) == 2 {
+			location, size := fields[0], fields[1]
+			if !strings.EqualFold(location, strings.ReplaceAll(a.Location, " ", "")) {
+				return fmt.Errorf("data disk %s is in %s, not %s; move or delete it first", a.DataDiskName, location, a.Location)
+			}
+			if existing, _ := strconv.Atoi(size); existing != a.DataDiskSizeGB {
+				a.broadcastLog("warn", fmt.Sprintf("Data disk %s is %s GB, not %d GB; it is reused as it is", a.DataDiskName, size, a.DataDiskSizeGB), "storage")
+			}
+		}
+		a.broadcastLog("info", fmt.Sprintf("Reusing data disk %s", a.DataDiskName), "storage")
+		return nil
+	}
+	if !strings.Contains(err.Error(), "ResourceNotFound") && !strings.Contains(err.Error(), "ResourceGroupNotFound") {
+		return err
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Creating %d GB data disk %s in %s...", a.DataDiskSizeGB, a.DataDiskName, resourceGroup), "storage")
+	if _, err := a.az("group", "create",
+		"-n", resourceGroup,
+		"-l", a.Location,
+		"--tags", "environment=django-vpc", "purpose=data-disk",
+		"-o", "none"); err != nil {
+		return err
+	}
+	if _, err := a.az("disk", "create",
+		"-g", resourceGroup,
+		"-n", a.DataDiskName,
+		"-l", a.Location,
+		"--size-gb", strconv.Itoa(a.DataDiskSizeGB),
+		"--sku", "StandardSSD_LRS",
+		"-o", "none"); err != nil {
+		return err
+	}
+	a.broadcastLog("success", fmt.Sprintf("Created data disk %s", a.DataDiskName), "storage")
+	return nil
+}
+
+// DeleteDataDisk deletes the provider's data disk, and the data on it, with
+// its resource group. A disk that was never created is not an error.
+func (a *AzureProvider) DeleteDataDisk() error {
+	a.broadcastLog("info", fmt.Sprintf("Deleting data disk %s...", a.DataDiskName), "storage")
+	if err := a.deleteGroup(a.DataDiskResourceGroup()); err != nil {
+		return err
+	}
+	a.broadcastLog("success", fmt.Sprintf("Deleted data disk %s", a.DataDiskName), "storage")
+	return nil
+}
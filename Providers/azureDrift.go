@@ -0,0 +1,257 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Drift components group the resources a drift check reports on.
+const (
+	DriftComponentVM       = "vm"
+	DriftComponentNSG      = "nsg"
+	DriftComponentPublicIP = "public_ip"
+	DriftComponentOther    = "other"
+)
+
+// driftComponents maps the resource types of the Azure template to the
+// component they belong to; other azurerm resources are DriftComponentOther.
+var driftComponents = map[string]string{
+	"azurerm_linux_virtual_machine":                        DriftComponentVM,
+	"azurerm_linux_virtual_machine_scale_set":              DriftComponentVM,
+	"azurerm_virtual_machine_data_disk_attachment":         DriftComponentVM,
+	"azurerm_dev_test_global_vm_shutdown_schedule":         DriftComponentVM,
+	"azurerm_network_security_group":                       DriftComponentNSG,
+	"azurerm_network_security_rule":                        DriftComponentNSG,
+	"azurerm_network_interface_security_group_association": DriftComponentNSG,
+	"azurerm_public_ip":                                    DriftComponentPublicIP,
+}
+
+// AttributeChange is one top-level attribute of a resource that differs.
+// Sensitive values are replaced by "(sensitive)" and values Terraform only
+// learns on apply by "(known after apply)".
+type AttributeChange struct {
+	Attribute string      `json:"attribute"`
+	Before    interface{} `json:"before"`
+	After     interface{} `json:"after"`
+}
+
+// ResourceDrift is a resource that differs, with the actions Terraform
+// reports for it ("update", "delete", "create"...).
+type ResourceDrift struct {
+	Address   string            `json:"address"`
+	Type      string            `json:"type"`
+	Component string            `json:"component"`
+	Actions   []string          `json:"actions"`
+	Changes   []AttributeChange `json:"changes,omitempty"`
+}
+
+// TerraformDrift is the result of planning a deployment's configuration
+// against its recorded state. Drift is what changed in Azure outside
+// Terraform since the last apply; Changes is what applying the
+// configuration again would do to restore it.
+type TerraformDrift struct {
+	Drifted    bool            `json:"drifted"`
+	Components map[string]bool `json:"components"`
+	Drift      []ResourceDrift `json:"drift"`
+	Changes    []ResourceDrift `json:"changes"`
+}
+
+// planChange is a resource change in the JSON of `terraform show -json`.
+type planChange struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	Type    string `json:"type"`
+	Change  struct {
+		Actions         []string               `json:"actions"`
+		Before          map[string]interface{} `json:"before"`
+		After           map[string]interface{} `json:"after"`
+		AfterUnknown    map[string]interface{} `json:"after_unknown"`
+		BeforeSensitive interface{}            `json:"before_sensitive"`
+		AfterSensitive  interface{}            `json:"after_sensitive"`
+	} `json:"change"`
+}
+
+// PlanDrift plans the configuration in path, which must hold main.tf,
+// terraform.tfvars and the terraform.tfstate of the last apply, and reports
+// how Azure differs from both. It only plans; nothing is changed and the
+// state in path is not updated.
+func (a *AzureProvider) PlanDrift(ctx context.Context, path string) (*TerraformDrift, error) {
+	env := resolveAzureCredentials(a.Credentials).terraformEnv()
+
+	initCmd := terraformCommand(ctx, "init", "-no-color", "-input=false")
+	initCmd.Dir = path
+	initEnv, unlock := lockPluginCache(env)
+	initCmd.Env = initEnv
+	err := isolate(initCmd)
+	var output []byte
+	if err == nil {
+		output, err = initCmd.CombinedOutput()
+	}
+	unlock()
+	if err != nil {
+		return nil, terraformError(types.ErrCodeTerraformInit, err, output, "terraform init failed")
+	}
+
+	plan := terraformCommand(ctx, "plan", "-detailed-exitcode", "-lock=false", "-no-color", "-input=false", "-out=drift.tfplan")
+	plan.Dir = path
+	plan.Env = env
+	if err := isolate(plan); err != nil {
+		return nil, err
+	}
+	if output, err := plan.CombinedOutput(); err != nil {
+		// -detailed-exitcode exits 2 when the plan has changes.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+			return nil, terraformError(types.ErrCodeTerraformPlan, err, output, "terraform plan failed")
+		}
+	}
+
+	show := terraformCommand(ctx, "show", "-json", "drift.tfplan")
+	show.Dir = path
+	show.Env = env
+	if err := isolate(show); err != nil {
+		return nil, err
+	}
+	output, err = show.Output()
+	if err != nil {
+		return nil, fmt.Errorf("terraform show failed: %v", err)
+	}
+	return parseDriftPlan(output)
+}
+
+// parseDriftPlan reads the resource_drift and resource_changes of a plan's
+// JSON. Resources outside the azurerm provider, like the local key files,
+// only exist on the server that deployed and are left out.
+func parseDriftPlan(planJSON []byte) (*TerraformDrift, error) {
+	var plan struct {
+		ResourceDrift   []planChange `json:"resource_drift"`
+		ResourceChanges []planChange `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(planJSON, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode terraform plan: %v", err)
+	}
+
+	drift := &TerraformDrift{
+		Components: map[string]bool{DriftComponentVM: false, DriftComponentNSG: false, DriftComponentPublicIP: false},
+		Drift:      []ResourceDrift{},
+		Changes:    []ResourceDrift{},
+	}
+	collect := func(changes []planChange) []ResourceDrift {
+		resources := []ResourceDrift{}
+		for _, change := range changes {
+			if change.Mode != "managed" || !strings.HasPrefix(change.Type, "azurerm_") || isNoOp(change.Change.Actions) {
+				continue
+			}
+			component, ok := driftComponents[change.Type]
+			if !ok {
+				component = DriftComponentOther
+			}
+			resources = append(resources, ResourceDrift{
+				Address:   change.Address,
+				Type:      change.Type,
+				Component: component,
+				Actions:   change.Change.Actions,
+				Changes:   attributeChanges(change),
+			})
+			drift.Components[component] = true
+			drift.Drifted = true
+		}
+		return resources
+	}
+	drift.Drift = collect(plan.ResourceDrift)
+	drift.Changes = collect(plan.ResourceChanges)
+	return drift, nil
+}
+
+func isNoOp(actions []string) bool {
+	for _, action := range actions {
+		if action != "no-op" && action != "read" {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeChanges lists the top-level attributes whose values differ
+// between the change's before and after.
+func attributeChanges(change planChange) []AttributeChange {
+	names := map[string]bool{}
+	for name := range change.Change.Before {
+		names[name] = true
+	}
+	for name := range change.Change.After {
+		names[name] = true
+	}
+	for name := range change.Change.AfterUnknown {
+		names[name] = true
+	}
+
+	var changes []AttributeChange
+	for _, name := range sortedNames(names) {
+		before, after := change.Change.Before[name], change.Change.After[name]
+		unknown := isMarked(change.Change.AfterUnknown, name)
+		if !unknown && reflect.DeepEqual(before, after) {
+			continue
+		}
+		if isMarked(change.Change.BeforeSensitive, name) {
+			before = "(sensitive)"
+		}
+		if isMarked(change.Change.AfterSensitive, name) {
+			after = "(sensitive)"
+		}
+		if unknown {
+			after = "(known after apply)"
+		}
+		changes = append(changes, AttributeChange{Attribute: name, Before: before, After: after})
+	}
+	return changes
+}
+
+// isMarked reports whether the after_unknown or *_sensitive structure marks
+// the attribute, or any value inside it.
+func isMarked(marks interface{}, name string) bool {
+	switch marks := marks.(type) {
+	case bool:
+		return marks
+	case map[string]interface{}:
+		return containsTrue(marks[name])
+	}
+	return false
+}
+
+func containsTrue(value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case map[string]interface{}:
+		for _, nested := range value {
+			if containsTrue(nested) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, nested := range value {
+			if containsTrue(nested) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
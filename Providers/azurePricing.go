@@ -0,0 +1,135 @@
+package providers
+
+import "fmt"
+
+const hoursPerMonth = 730
+
+// Pay-as-you-go Linux prices in USD/hour for East US. They only need to be
+// close enough to catch an accidentally expensive SKU, not to bill anyone.
+var azureVMHourlyPrices = map[string]float64{
+	"Standard_B1ls":   0.0052,
+	"Standard_B1s":    0.0104,
+	"Standard_B1ms":   0.0207,
+	"Standard_B2s":    0.0416,
+	"Standard_B2ms":   0.0832,
+	"Standard_B4ms":   0.166,
+	"Standard_B8ms":   0.333,
+	"Standard_D2s_v3": 0.096,
+	"Standard_D4s_v3": 0.192,
+	"Standard_D8s_v3": 0.384,
+	"Standard_D2s_v5": 0.096,
+	"Standard_D4s_v5": 0.192,
+	"Standard_D8s_v5": 0.384,
+
+	// Ampere Altra (arm64)
+	"Standard_B2pls_v2": 0.0336,
+	"Standard_B2ps_v2":  0.0672,
+	"Standard_B4ps_v2":  0.1344,
+	"Standard_D2ps_v5":  0.077,
+	"Standard_D4ps_v5":  0.154,
+	"Standard_D8ps_v5":  0.308,
+
+	// NVIDIA GPU
+	"Standard_NC4as_T4_v3":  0.526,
+	"Standard_NC8as_T4_v3":  0.752,
+	"Standard_NC16as_T4_v3": 1.204,
+	"Standard_NC6s_v3":      3.06,
+}
+
+// Rough regional price multipliers relative to East US.
+var azureRegionMultipliers = map[string]float64{
+	"East US":        1.0,
+	"East US 2":      1.0,
+	"West US 2":      1.0,
+	"Central US":     1.05,
+	"North Europe":   1.05,
+	"West Europe":    1.1,
+	"UK South":       1.1,
+	"Central India":  1.0,
+	"Southeast Asia": 1.15,
+}
+
+// IsKnownVMSize reports whether size is a VM size this tool has pricing
+// data for.
+func IsKnownVMSize(size string) bool {
+	_, ok := azureVMHourlyPrices[size]
+	return ok
+}
+
+// IsKnownLocation reports whether location is a region this tool has
+// pricing data for.
+func IsKnownLocation(location string) bool {
+	_, ok := azureRegionMultipliers[location]
+	return ok
+}
+
+const (
+	// Standard HDD, priced per GB from the 32 GB tier.
+	standardLRSGBMonthly  = 1.54 / 32
+	staticPublicIPMonthly = 3.65
+	// Standard SSD, used for data disks, priced per GB from the 32 GB tier.
+	standardSSDGBMonthly = 2.40 / 32
+	// A Standard load balancer with up to five rules.
+	standardLoadBalancerMonthly = 18.25
+)
+
+// CostEstimate is the expected monthly cost of a deployment's resources.
+type CostEstimate struct {
+	VMSize          string  `json:"vm_size"`
+	Location        string  `json:"location"`
+	VMMonthly       float64 `json:"vm_monthly"`
+	DiskMonthly     float64 `json:"disk_monthly"`
+	PublicIPMonthly float64 `json:"public_ip_monthly"`
+	// DataDiskMonthly is set for VMs with a data disk.
+	DataDiskMonthly float64 `json:"data_disk_monthly,omitempty"`
+	// Instances and LoadBalancerMonthly are set for scale sets; the VM, disk
+	// and public IP figures then cover every instance.
+	Instances int `json:"instances,omitempty"`
+	// Spot is set for Spot VMs, which cost at most VMMonthly, usually far
+	// less.
+	Spot                bool    `json:"spot,omitempty"`
+	LoadBalancerMonthly float64 `json:"load_balancer_monthly,omitempty"`
+	Total               float64 `json:"total"`
+	Currency            string  `json:"currency"`
+}
+
+// EstimateMonthlyCost prices the provider's VM size in its location.
+func (a *AzureProvider) EstimateMonthlyCost() (*CostEstimate, error) {
+	hourly, ok := azureVMHourlyPrices[a.VMSize]
+	if !ok {
+		return nil, fmt.Errorf("no price data for VM size %s", a.VMSize)
+	}
+
+	multiplier, ok := azureRegionMultipliers[a.Location]
+	if !ok {
+		multiplier = 1.0
+	}
+
+	estimate := &CostEstimate{
+		VMSize:          a.VMSize,
+		Location:        a.Location,
+		VMMonthly:       hourly * hoursPerMonth * multiplier,
+		DiskMonthly:     standardLRSGBMonthly * float64(a.OSDiskSizeGB()) * multiplier,
+		PublicIPMonthly: staticPublicIPMonthly,
+		Currency:        "USD",
+	}
+	if a.Spot {
+		estimate.Spot = true
+		if a.SpotMaxPrice > 0 {
+			estimate.VMMonthly = min(estimate.VMMonthly, a.SpotMaxPrice*hoursPerMonth)
+		}
+	}
+	if a.DataDiskSizeGB > 0 {
+		estimate.DataDiskMonthly = standardSSDGBMonthly * float64(a.DataDiskSizeGB) * multiplier
+	}
+	if a.ScaleSet {
+		// Each instance has its own public IP next to the load balancer's.
+		estimate.Instances = a.Instances
+		estimate.VMMonthly *= float64(a.Instances)
+		estimate.DiskMonthly *= float64(a.Instances)
+		estimate.PublicIPMonthly *= float64(a.Instances + 1)
+		estimate.LoadBalancerMonthly = standardLoadBalancerMonthly * multiplier
+	}
+	estimate.Total = estimate.VMMonthly + estimate.DiskMonthly + estimate.PublicIPMonthly + estimate.DataDiskMonthly + estimate.LoadBalancerMonthly
+	return estimate, nil
+}
@@ -1,34 +1,24 @@
 package providers
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"	
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/crypto/ssh"
-)
-
-// LogMessage represents a log entry
-type LogMessage struct {
-	Level     string
-	Message   string
-	Timestamp string
-	Step      string
-}
 
-// LogBroadcaster interface for broadcasting logs
-type LogBroadcaster interface {
-	BroadcastLog(deploymentID string, logMsg LogMessage)
-}
+	"sathwikshetty33/Django-vpc/Types"
+)
 
 func init() {
 	if err := godotenv.Load(); err != nil {
@@ -39,22 +29,249 @@ func init() {
 type AzureProvider struct {
 	ResourceGroup    string
 	Location         string
+	SubscriptionID   string
 	VMSize           string
 	VMName           string
+	// DiskSizeGB is the OS disk size, or DefaultDiskSizeGB if zero.
+	DiskSizeGB       int
+	// ScaleSet provisions Instances VMs in a scale set behind a load
+	// balancer instead of a single VM.
+	ScaleSet         bool
+	Instances        int
+	// Spot provisions Spot VMs, which Azure can evict at any time, paying
+	// at most SpotMaxPrice an hour, or the on-demand price if it is zero.
+	// SpotEvictionPolicy is Deallocate, the default, or Delete.
+	Spot             bool
+	SpotMaxPrice     float64
+	SpotEvictionPolicy string
+	// ExistingResourceGroup deploys into ResourceGroup instead of creating
+	// it, and SubnetID attaches the VM to an existing subnet instead of a
+	// new network. Private leaves the VM without a public IP; it is then
+	// reached on its private address, from a host inside the network.
+	ExistingResourceGroup bool
+	SubnetID         string
+	Private          bool
+	// SSHSourcePrefixes are the CIDRs or service tags the NSG allows SSH
+	// from. SSH is open to any address if it is empty.
+	SSHSourcePrefixes []string
+	// StaticIPName names a public IP reserved with ReserveStaticIP that the
+	// VM uses instead of creating its own.
+	StaticIPName     string
+	// DataDiskName names a managed disk created with ReserveDataDisk that
+	// is attached to the VM at DataDiskLUN. DataDiskSizeGB is its size.
+	DataDiskName     string
+	DataDiskSizeGB   int
+	// Credentials authenticate Terraform and the az CLI, or the server's
+	// from AzureCredentialsFromEnv if nil.
+	Credentials      *AzureCredentials
+	// Backend is AzureBackendTerraform, the default, AzureBackendSDK to
+	// provision through the Resource Manager API without terraform, or
+	// AzureBackendPulumi to provision with Pulumi. Only Terraform
+	// provisions scale sets.
+	Backend          string
+	// Image is ImageUbuntu2204, the default, ImageUbuntu2404 or the
+	// resource ID of a custom image.
+	Image            string
+	ExtraPortRange   string
+	// OpenPorts are additional ports the NSG allows from any address.
+	OpenPorts        []int
+	// ShutdownTime is the VM's daily auto-shutdown time as HHMM in
+	// ShutdownTimezone, a Windows time zone ID. The VM is never shut down
+	// if it is empty.
+	ShutdownTime     string
+	ShutdownTimezone string
 	Path_            string
 	PublicKeyPath    string
 	PublicKeyContent string
-	broadcaster      LogBroadcaster
+	broadcaster      types.LogBroadcaster
 	deploymentID     string
+	ctx              context.Context
+}
+
+// IsARM64VMSize reports whether size is an Ampere Altra (arm64) size. Azure
+// marks these with a "p" feature letter, e.g. Standard_B2ps_v2.
+func IsARM64VMSize(size string) bool {
+	parts := strings.Split(size, "_")
+	if len(parts) < 2 {
+		return false
+	}
+	features := strings.TrimLeft(parts[1], "ABDEFLMN0123456789")
+	return strings.Contains(features, "p")
+}
+
+// IsGPUVMSize reports whether size is an N-series (NVIDIA GPU) size.
+func IsGPUVMSize(size string) bool {
+	return strings.HasPrefix(size, "Standard_N")
+}
+
+// VMVCPUs returns the vCPU count in an Azure VM size's name, like 4 for
+// Standard_D4s_v5, or 0 if the name has none.
+func VMVCPUs(size string) int {
+	parts := strings.Split(size, "_")
+	if len(parts) < 2 {
+		return 0
+	}
+	digits := strings.TrimLeft(parts[1], "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		digits = digits[:end]
+	}
+	vcpus, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0
+	}
+	return vcpus
+}
+
+// DefaultDiskSizeGB is the OS disk size of VMs that set none.
+const DefaultDiskSizeGB = 30
+
+// OSDiskSizeGB returns the OS disk size the VM is provisioned with.
+func (a *AzureProvider) OSDiskSizeGB() int {
+	if a.DiskSizeGB > 0 {
+		return a.DiskSizeGB
+	}
+	return DefaultDiskSizeGB
+}
+
+// SpotMaxBid returns the max_bid_price of a Spot VM, where -1 caps it at
+// the on-demand price.
+func (a *AzureProvider) SpotMaxBid() string {
+	if a.SpotMaxPrice <= 0 {
+		return "-1"
+	}
+	return strconv.FormatFloat(a.SpotMaxPrice, 'f', -1, 64)
+}
+
+// SpotEviction returns the eviction policy of a Spot VM.
+func (a *AzureProvider) SpotEviction() string {
+	if a.SpotEvictionPolicy == "" {
+		return "Deallocate"
+	}
+	return a.SpotEvictionPolicy
+}
+
+// ResourceGroupRef returns the Terraform address of the VM's resource
+// group.
+func (a *AzureProvider) ResourceGroupRef() string {
+	if a.ExistingResourceGroup {
+		return "data.azurerm_resource_group.example"
+	}
+	return "azurerm_resource_group.example"
+}
+
+// SubnetRef returns the subnet ID expression the VM's NIC is attached with.
+func (a *AzureProvider) SubnetRef() string {
+	if a.SubnetID != "" {
+		return strconv.Quote(a.SubnetID)
+	}
+	return "azurerm_subnet.example.id"
+}
+
+// OpenPortRanges returns the NSG's destination_port_ranges for OpenPorts.
+func (a *AzureProvider) OpenPortRanges() string {
+	quoted := make([]string, len(a.OpenPorts))
+	for i, port := range a.OpenPorts {
+		quoted[i] = strconv.Quote(strconv.Itoa(port))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// ShutdownTag returns the VM's auto-shutdown schedule, like "19:00 UTC",
+// or "off".
+func (a *AzureProvider) ShutdownTag() string {
+	if a.ShutdownTime == "" {
+		return "off"
+	}
+	return a.ShutdownTime[:2] + ":" + a.ShutdownTime[2:] + " " + a.ShutdownTimezone
+}
+
+// SSHSourceRule returns the source attribute of the NSG's SSH rule.
+func (a *AzureProvider) SSHSourceRule() string {
+	if len(a.SSHSourcePrefixes) == 0 {
+		return `source_address_prefix      = "*"`
+	}
+	quoted := make([]string, len(a.SSHSourcePrefixes))
+	for i, prefix := range a.SSHSourcePrefixes {
+		quoted[i] = strconv.Quote(prefix)
+	}
+	return "source_address_prefixes    = [" + strings.Join(quoted, ", ") + "]"
+}
+
+// AddressRef returns the Terraform expression of the address the VM is
+// reached on.
+func (a *AzureProvider) AddressRef() string {
+	if a.Private {
+		return "azurerm_network_interface.example.private_ip_address"
+	}
+	return a.PublicIPRef() + ".ip_address"
+}
+
+// ResourceName names the VM's network resources. A resource group of its
+// own needs no more than kind, while a shared existing one holds the
+// resources of several deployments, so they are prefixed with the VM name.
+func (a *AzureProvider) ResourceName(kind string) string {
+	if a.ExistingResourceGroup {
+		return a.VMName + "-" + kind
+	}
+	return "example-" + kind
+}
+
+// Marketplace images a VM can be provisioned from.
+const (
+	ImageUbuntu2204 = "ubuntu-22.04"
+	ImageUbuntu2404 = "ubuntu-24.04"
+)
+
+// IsCustomImage reports whether image is the resource ID of a managed or
+// gallery image rather than one of the marketplace images.
+func IsCustomImage(image string) bool {
+	return strings.HasPrefix(strings.ToLower(image), "/subscriptions/")
 }
 
-func (a *AzureProvider) SetLogger(broadcaster LogBroadcaster, deploymentID string) {
+// CustomImageID returns the VM's custom image, or "" if it runs a
+// marketplace image.
+func (a *AzureProvider) CustomImageID() string {
+	if IsCustomImage(a.Image) {
+		return a.Image
+	}
+	return ""
+}
+
+// ImageOffer returns the Canonical offer of the VM's Ubuntu release.
+func (a *AzureProvider) ImageOffer() string {
+	if a.Image == ImageUbuntu2404 {
+		return "ubuntu-24_04-lts"
+	}
+	return "0001-com-ubuntu-server-jammy"
+}
+
+// ImageSKU returns the image SKU of the VM's Ubuntu release matching the VM
+// architecture.
+func (a *AzureProvider) ImageSKU() string {
+	if a.Image == ImageUbuntu2404 {
+		if IsARM64VMSize(a.VMSize) {
+			return "server-arm64"
+		}
+		return "server"
+	}
+	if IsARM64VMSize(a.VMSize) {
+		return "22_04-lts-arm64"
+	}
+	return "22_04-lts-gen2"
+}
+
+func (a *AzureProvider) SetLogger(broadcaster types.LogBroadcaster, deploymentID string) {
 	a.broadcaster = broadcaster
 	a.deploymentID = deploymentID
 }
 
+func (a *AzureProvider) SetContext(ctx context.Context) {
+	a.ctx = ctx
+}
+
 func (a *AzureProvider) broadcastLog(level, message, step string) {
-	logMsg := LogMessage{
+	logMsg := types.LogMessage{
 		Level:     level,
 		Message:   message,
 		Step:      step,
@@ -62,7 +279,7 @@ func (a *AzureProvider) broadcastLog(level, message, step string) {
 	}
 	
 	// Print to console with color coding
-	a.printLog(logMsg)
+	printLog(logMsg)
 	
 	// Broadcast to external logger if available
 	if a.broadcaster != nil {
@@ -70,7 +287,7 @@ func (a *AzureProvider) broadcastLog(level, message, step string) {
 	}
 }
 
-func (a *AzureProvider) printLog(logMsg LogMessage) {
+func printLog(logMsg types.LogMessage) {
 	timestamp := time.Now().Format("15:04:05")
 	
 	// Color codes for different log levels
@@ -111,16 +328,32 @@ func (a *AzureProvider) printLog(logMsg LogMessage) {
 }
 
 const azureTfTemplate = `
+terraform {
+  required_version = "` + TerraformRequiredVersion + `"
+
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "` + AzureRMVersion + `"
+    }
+    local = {
+      source  = "hashicorp/local"
+      version = "` + LocalProviderVersion + `"
+    }
+  }
+}
+
 provider "azurerm" {
   features {}
   subscription_id = "${var.subscription_id}"
+{{ .AuthArguments -}}
 }
 
 variable "subscription_id" {
   description = "Azure subscription ID"
   type        = string
 }
-
+{{ .AuthVariables }}
 # Local file resources for SSH keys
 resource "local_file" "private_key" {
   content         = var.private_key_content
@@ -146,39 +379,54 @@ variable "public_key_content" {
   type        = string
 }
 
+{{- if .ExistingResourceGroup }}
+data "azurerm_resource_group" "example" {
+  name = "{{ .ResourceGroup }}"
+}
+{{- else }}
 resource "azurerm_resource_group" "example" {
   name     = "{{ .ResourceGroup }}"
   location = "{{ .Location }}"
 }
-
+{{- end }}
+{{ if not .SubnetID }}
 resource "azurerm_virtual_network" "example" {
-  name                = "example-network"
+  name                = "{{ .ResourceName "network" }}"
   address_space       = ["10.0.0.0/16"]
-  location            = azurerm_resource_group.example.location
-  resource_group_name = azurerm_resource_group.example.name
+  location            = {{ .ResourceGroupRef }}.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
 }
 
 resource "azurerm_subnet" "example" {
-  name                 = "example-subnet"
-  resource_group_name  = azurerm_resource_group.example.name
+  name                 = "{{ .ResourceName "subnet" }}"
+  resource_group_name  = {{ .ResourceGroupRef }}.name
   virtual_network_name = azurerm_virtual_network.example.name
   address_prefixes     = ["10.0.2.0/24"]
 }
-
+{{ end }}
+{{- if .Private }}
+{{- else if .StaticIPName }}
+# Reserved outside the resource group so it survives rebuilds of the VM
+data "azurerm_public_ip" "reserved" {
+  name                = "{{ .StaticIPName }}"
+  resource_group_name = "{{ .StaticIPResourceGroup }}"
+}
+{{- else }}
 resource "azurerm_public_ip" "example" {
-  name                = "example-public-ip"
-  location            = azurerm_resource_group.example.location
-  resource_group_name = azurerm_resource_group.example.name
+  name                = "{{ .ResourceName "public-ip" }}"
+  location            = {{ .ResourceGroupRef }}.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
   allocation_method   = "Static"
   sku                 = "Standard"
 }
+{{- end }}
 
 resource "azurerm_network_security_group" "example" {
-  name                = "example-security-group"
-  location            = azurerm_resource_group.example.location
-  resource_group_name = azurerm_resource_group.example.name
+  name                = "{{ .ResourceName "security-group" }}"
+  location            = {{ .ResourceGroupRef }}.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
 
-  # SSH access - restricted to specific port with rate limiting
+  # SSH access, from ssh_allowed_cidrs and this server when set
   security_rule {
     name                       = "SSH"
     priority                   = 1001
@@ -187,7 +435,7 @@ resource "azurerm_network_security_group" "example" {
     protocol                   = "Tcp"
     source_port_range          = "*"
     destination_port_range     = "22"
-    source_address_prefix      = "*"  # Consider restricting to your IP range
+    {{ .SSHSourceRule }}
     destination_address_prefix = "*"
   }
 
@@ -230,6 +478,48 @@ resource "azurerm_network_security_group" "example" {
     destination_address_prefix = "*"
   }
 
+{{- if .ExtraPortRange }}
+  # Pooled application ports
+  security_rule {
+    name                       = "PooledApps"
+    priority                   = 1005
+    direction                  = "Inbound"
+    access                     = "Allow"
+    protocol                   = "Tcp"
+    source_port_range          = "*"
+    destination_port_range     = "{{ .ExtraPortRange }}"
+    source_address_prefix      = "*"
+    destination_address_prefix = "*"
+  }
+{{ end }}
+{{- if .OpenPorts }}
+  # Additional ports from the request's open_ports
+  security_rule {
+    name                       = "OpenPorts"
+    priority                   = 1007
+    direction                  = "Inbound"
+    access                     = "Allow"
+    protocol                   = "Tcp"
+    source_port_range          = "*"
+    destination_port_ranges    = {{ .OpenPortRanges }}
+    source_address_prefix      = "*"
+    destination_address_prefix = "*"
+  }
+{{ end }}
+{{- if .ScaleSet }}
+  # Load balancer health probes, which the deny rule below would block
+  security_rule {
+    name                       = "AzureLoadBalancer"
+    priority                   = 1006
+    direction                  = "Inbound"
+    access                     = "Allow"
+    protocol                   = "*"
+    source_port_range          = "*"
+    destination_port_range     = "*"
+    source_address_prefix      = "AzureLoadBalancer"
+    destination_address_prefix = "*"
+  }
+{{ end }}
   # Deny all other inbound traffic
   security_rule {
     name                       = "DenyAllInbound"
@@ -244,16 +534,160 @@ resource "azurerm_network_security_group" "example" {
   }
 }
 
+{{ if .ScaleSet -}}
+resource "azurerm_lb" "example" {
+  name                = "{{ .ResourceName "lb" }}"
+  location            = {{ .ResourceGroupRef }}.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
+  sku                 = "Standard"
+
+  frontend_ip_configuration {
+    name                 = "public"
+    public_ip_address_id = {{ .PublicIPRef }}.id
+  }
+}
+
+resource "azurerm_lb_backend_address_pool" "example" {
+  name            = "example-backend"
+  loadbalancer_id = azurerm_lb.example.id
+}
+
+resource "azurerm_lb_probe" "example" {
+  name            = "http"
+  loadbalancer_id = azurerm_lb.example.id
+  protocol        = "Tcp"
+  port            = 80
+}
+
+resource "azurerm_lb_rule" "http" {
+  name                           = "HTTP"
+  loadbalancer_id                = azurerm_lb.example.id
+  protocol                       = "Tcp"
+  frontend_port                  = 80
+  backend_port                   = 80
+  frontend_ip_configuration_name = "public"
+  backend_address_pool_ids       = [azurerm_lb_backend_address_pool.example.id]
+  probe_id                       = azurerm_lb_probe.example.id
+  disable_outbound_snat          = true
+}
+
+resource "azurerm_lb_rule" "https" {
+  name                           = "HTTPS"
+  loadbalancer_id                = azurerm_lb.example.id
+  protocol                       = "Tcp"
+  frontend_port                  = 443
+  backend_port                   = 443
+  frontend_ip_configuration_name = "public"
+  backend_address_pool_ids       = [azurerm_lb_backend_address_pool.example.id]
+  probe_id                       = azurerm_lb_probe.example.id
+  disable_outbound_snat          = true
+}
+
+resource "azurerm_linux_virtual_machine_scale_set" "example" {
+  name                = "{{ .VMName }}"
+  resource_group_name = {{ .ResourceGroupRef }}.name
+  location            = {{ .ResourceGroupRef }}.location
+  sku                 = "{{ .VMSize }}"
+{{- if .Spot }}
+  priority            = "Spot"
+  eviction_policy     = "{{ .SpotEviction }}"
+  max_bid_price       = {{ .SpotMaxBid }}
+{{- end }}
+  instances           = {{ .Instances }}
+  admin_username      = "azureuser"
+  upgrade_mode        = "Manual"
+
+  disable_password_authentication = true
+
+  admin_ssh_key {
+    username   = "azureuser"
+    public_key = var.public_key_content
+  }
+
+  os_disk {
+    caching              = "ReadWrite"
+    storage_account_type = "Standard_LRS"
+    disk_size_gb         = {{ .OSDiskSizeGB }}
+  }
+
+{{- if .CustomImageID }}
+  source_image_id = "{{ .CustomImageID }}"
+{{- else }}
+  source_image_reference {
+    publisher = "Canonical"
+    offer     = "{{ .ImageOffer }}"
+    sku       = "{{ .ImageSKU }}"
+    version   = "latest"
+  }
+{{- end }}
+
+  network_interface {
+    name                      = "example-nic"
+    primary                   = true
+    network_security_group_id = azurerm_network_security_group.example.id
+
+    ip_configuration {
+      name                                   = "internal"
+      primary                                = true
+      subnet_id                              = {{ .SubnetRef }}
+      load_balancer_backend_address_pool_ids = [azurerm_lb_backend_address_pool.example.id]
+
+      # Each instance gets its own address so Ansible can reach it over SSH
+      public_ip_address {
+        name = "instance-ip"
+      }
+    }
+  }
+
+  tags = {
+    Environment = "Production"
+    Security = "SSH-Keys-Only"
+    Monitoring = "Enabled"
+  }
+
+  boot_diagnostics {
+    storage_account_uri = null  # Uses managed storage account
+  }
+
+  # Ensure SSH keys are created before the instances
+  depends_on = [local_file.private_key, local_file.public_key, azurerm_lb_rule.http, azurerm_lb_rule.https]
+}
+
+data "azurerm_virtual_machine_scale_set" "example" {
+  name                = azurerm_linux_virtual_machine_scale_set.example.name
+  resource_group_name = {{ .ResourceGroupRef }}.name
+}
+
+# Outputs
+output "public_ip" {
+  value = {{ .PublicIPRef }}.ip_address
+  depends_on = [azurerm_linux_virtual_machine_scale_set.example]
+}
+
+output "resource_group" {
+  value = {{ .ResourceGroupRef }}.name
+}
+
+output "vm_name" {
+  value = azurerm_linux_virtual_machine_scale_set.example.name
+}
+
+output "instance_ips" {
+  value = join(",", data.azurerm_virtual_machine_scale_set.example.instances[*].public_ip_address)
+}
+{{- else -}}
 resource "azurerm_network_interface" "example" {
-  name                = "example-nic"
-  location            = azurerm_resource_group.example.location
-  resource_group_name = azurerm_resource_group.example.name
+  name                = "{{ .ResourceName "nic" }}"
+  location            = {{ .ResourceGroupRef }}.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
 
   ip_configuration {
     name                          = "internal"
-    subnet_id                     = azurerm_subnet.example.id
+    subnet_id                     = {{ .SubnetRef }}
     private_ip_address_allocation = "Dynamic"
-    public_ip_address_id          = azurerm_public_ip.example.id
+{{- if not .Private }}
+    public_ip_address_id          = {{ .PublicIPRef }}.id
+{{- end }}
   }
 }
 
@@ -264,9 +698,14 @@ resource "azurerm_network_interface_security_group_association" "example" {
 
 resource "azurerm_linux_virtual_machine" "example" {
   name                = "{{ .VMName }}"
-  resource_group_name = azurerm_resource_group.example.name
-  location            = azurerm_resource_group.example.location
+  resource_group_name = {{ .ResourceGroupRef }}.name
+  location            = {{ .ResourceGroupRef }}.location
   size                = "{{ .VMSize }}"
+{{- if .Spot }}
+  priority            = "Spot"
+  eviction_policy     = "{{ .SpotEviction }}"
+  max_bid_price       = {{ .SpotMaxBid }}
+{{- end }}
   admin_username      = "azureuser"
   network_interface_ids = [azurerm_network_interface.example.id]
   
@@ -282,22 +721,26 @@ resource "azurerm_linux_virtual_machine" "example" {
   os_disk {
     caching              = "ReadWrite"
     storage_account_type = "Standard_LRS"
-    disk_size_gb         = 30
+    disk_size_gb         = {{ .OSDiskSizeGB }}
     # Enable encryption at host for additional security
     secure_vm_disk_encryption_set_id = null
   }
 
+{{- if .CustomImageID }}
+  source_image_id = "{{ .CustomImageID }}"
+{{- else }}
   source_image_reference {
     publisher = "Canonical"
-    offer     = "0001-com-ubuntu-server-jammy"
-    sku       = "22_04-lts-gen2"
+    offer     = "{{ .ImageOffer }}"
+    sku       = "{{ .ImageSKU }}"
     version   = "latest"
   }
+{{- end }}
 
   # Security and monitoring tags
   tags = {
     Environment = "Development"
-    AutoShutdown = "19:00"
+    AutoShutdown = "{{ .ShutdownTag }}"
     Security = "SSH-Keys-Only"
     Monitoring = "Enabled"
   }
@@ -311,14 +754,33 @@ resource "azurerm_linux_virtual_machine" "example" {
   depends_on = [local_file.private_key, local_file.public_key]
 }
 
+{{- if .DataDiskName }}
+
+# Data disk for media and databases, kept outside this configuration so it
+# survives the VM
+data "azurerm_managed_disk" "data" {
+  name                = "{{ .DataDiskName }}"
+  resource_group_name = "{{ .DataDiskResourceGroup }}"
+}
+
+resource "azurerm_virtual_machine_data_disk_attachment" "data" {
+  managed_disk_id    = data.azurerm_managed_disk.data.id
+  virtual_machine_id = azurerm_linux_virtual_machine.example.id
+  lun                = 0
+  caching            = "ReadOnly"
+}
+{{- end }}
+
+{{- if .ShutdownTime }}
+
 # Auto-shutdown schedule (helps save costs on free trial)
 resource "azurerm_dev_test_global_vm_shutdown_schedule" "example" {
   virtual_machine_id = azurerm_linux_virtual_machine.example.id
-  location           = azurerm_resource_group.example.location
+  location           = {{ .ResourceGroupRef }}.location
   enabled            = true
 
-  daily_recurrence_time = "1900"
-  timezone              = "UTC"
+  daily_recurrence_time = "{{ .ShutdownTime }}"
+  timezone              = "{{ .ShutdownTimezone }}"
 
   notification_settings {
     enabled = false
@@ -328,15 +790,16 @@ resource "azurerm_dev_test_global_vm_shutdown_schedule" "example" {
     Environment = "Development"
   }
 }
+{{- end }}
 
 # Outputs
 output "public_ip" {
-  value = azurerm_public_ip.example.ip_address
+  value = {{ .AddressRef }}
   depends_on = [azurerm_linux_virtual_machine.example]
 }
 
 output "resource_group" {
-  value = azurerm_resource_group.example.name
+  value = {{ .ResourceGroupRef }}.name
 }
 
 output "vm_name" {
@@ -344,9 +807,10 @@ output "vm_name" {
 }
 
 output "ssh_connection_command" {
-  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${azurerm_public_ip.example.ip_address}"
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${ {{ .AddressRef }} }"
   depends_on = [azurerm_linux_virtual_machine.example]
 }
+{{- end }}
 `
 
 func generateSSHKeyPair() (string, string, error) {
@@ -401,6 +865,12 @@ func (a *AzureProvider) GenerateSSHKeys(path string) (string, string, error) {
 }
 
 func (a *AzureProvider) GenerateTerraformConfig(path string) error {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkGenerateConfig(path)
+	case AzureBackendPulumi:
+		return a.pulumiGenerateConfig(path)
+	}
 	a.broadcastLog("info", "Generating Terraform configuration...", "terraform")
 
 	publicKeyContent, privateKeyContent, err := a.GenerateSSHKeys(path)
@@ -427,8 +897,11 @@ func (a *AzureProvider) GenerateTerraformConfig(path string) error {
 		return err
 	}
 
-	a.broadcastLog("info", "Reading Azure subscription ID from environment...", "terraform")
-	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	subscriptionID := a.SubscriptionID
+	if subscriptionID == "" {
+		a.broadcastLog("info", "Reading Azure subscription ID from environment...", "terraform")
+		subscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
+	}
 	if subscriptionID == "" {
 		a.broadcastLog("error", "AZURE_SUBSCRIPTION_ID environment variable is not set", "terraform")
 		return fmt.Errorf("AZURE_SUBSCRIPTION_ID environment variable is not set")
@@ -438,7 +911,7 @@ func (a *AzureProvider) GenerateTerraformConfig(path string) error {
 	tfvarsContent := fmt.Sprintf(`subscription_id = %q
 private_key_content = %q
 public_key_content = %q
-`, subscriptionID, privateKeyContent, publicKeyContent)
+`, subscriptionID, privateKeyContent, publicKeyContent) + resolveAzureCredentials(a.Credentials).tfvars()
 
 	if err := os.WriteFile(tfvarsPath, []byte(tfvarsContent), 0600); err != nil {
 		a.broadcastLog("error", fmt.Sprintf("Failed to write terraform.tfvars: %v", err), "terraform")
@@ -449,59 +922,142 @@ public_key_content = %q
 	return nil
 }
 
+// terraformError classifies a failed terraform run from the cloud error
+// codes in its output; anything unrecognised keeps code and is assumed
+// transient.
+func terraformError(code string, err error, output []byte, message string) error {
+	out := string(output)
+	retryable := true
+	switch {
+	case strings.Contains(out, "QuotaExceeded") || strings.Contains(out, "exceeding approved") || strings.Contains(out, "quota") ||
+		strings.Contains(out, "VcpuLimitExceeded") || strings.Contains(out, "AddressLimitExceeded") || strings.Contains(out, "droplet limit") ||
+		strings.Contains(out, "resource_limit_exceeded") || strings.Contains(out, "LimitExceeded"):
+		code, retryable = types.ErrCodeQuotaExceeded, false
+	case strings.Contains(out, "SkuNotAvailable") || strings.Contains(out, "Unsupported:"):
+		code, retryable = types.ErrCodeCapacityUnavailable, false
+	case strings.Contains(out, "AllocationFailed") || strings.Contains(out, "InsufficientInstanceCapacity") || strings.Contains(out, "resource_unavailable") ||
+		strings.Contains(out, "Out of host capacity"):
+		code = types.ErrCodeCapacityUnavailable
+	case strings.Contains(out, "AuthorizationFailed") || strings.Contains(out, "InvalidAuthenticationToken") || strings.Contains(out, "az login") ||
+		strings.Contains(out, "AuthFailure") || strings.Contains(out, "UnauthorizedOperation") || strings.Contains(out, "InvalidClientTokenId") ||
+		strings.Contains(out, "Unable to authenticate") || strings.Contains(out, "(unauthorized)") ||
+		strings.Contains(out, "Invalid Token") || strings.Contains(out, "NotAuthenticated"):
+		code, retryable = types.ErrCodeCloudAuth, false
+	}
+	return types.NewDeploymentError("terraform", code, retryable, err, message)
+}
+
 func (a *AzureProvider) InitTerraform(path string) error {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkInit()
+	case AzureBackendPulumi:
+		return a.pulumiInit(path)
+	}
 	a.broadcastLog("info", "Initializing Terraform...", "terraform")
-	cmd := exec.Command("terraform", "init")
+	cmd := terraformCommand(a.ctx, "init", "-no-color", "-input=false")
 	cmd.Dir = path
+	env, unlock := lockPluginCache(nil)
+	cmd.Env = env
 
-	output, err := cmd.CombinedOutput()
+	output, err := streamTerraform(cmd, TerraformPhaseInit, a.broadcaster, a.deploymentID)
+	unlock()
 	if err != nil {
-		a.broadcastLog("error", fmt.Sprintf("Terraform init failed: %v\nOutput: %s", err, string(output)), "terraform")
-		return fmt.Errorf("terraform init failed: %v", err)
+		a.broadcastLog("error", fmt.Sprintf("Terraform init failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformInit, err, output, "terraform init failed")
 	}
 
-	a.broadcastLog("debug", fmt.Sprintf("Terraform init output:\n%s", string(output)), "terraform")
 	a.broadcastLog("success", "Terraform initialized successfully", "terraform")
 	return nil
 }
 
 func (a *AzureProvider) ApplyTerraform(path string) error {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkApply(path)
+	case AzureBackendPulumi:
+		return a.pulumiApply(path)
+	}
 	a.broadcastLog("info", "Applying Terraform configuration (this may take a few minutes)...", "terraform")
-	
 
-	fmt.Print("Deploying resources")
-	go func() {
-		for i := 0; i < 30; i++ {
-			time.Sleep(2 * time.Second)
-			fmt.Print(".")
-		}
-	}()
-	
-	cmd := exec.Command("terraform", "apply", "-auto-approve")
+	cmd := terraformCommand(a.ctx, "apply", "-auto-approve", "-input=false", "-no-color")
 	cmd.Dir = path
+	cmd.Env = resolveAzureCredentials(a.Credentials).terraformEnv()
 
-	output, err := cmd.CombinedOutput()
-	fmt.Println() 
-	
+	output, err := streamTerraform(cmd, TerraformPhaseApply, a.broadcaster, a.deploymentID)
 	if err != nil {
-		a.broadcastLog("error", fmt.Sprintf("Terraform apply failed: %v\nOutput: %s", err, string(output)), "terraform")
-		return fmt.Errorf("terraform apply failed: %v", err)
+		a.broadcastLog("error", fmt.Sprintf("Terraform apply failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, output, "terraform apply failed")
 	}
 
-
-	outputStr := string(output)
-	if strings.Contains(outputStr, "Apply complete!") {
+	if strings.Contains(string(output), "Apply complete!") {
 		a.broadcastLog("success", "Infrastructure deployment completed successfully", "terraform")
 	}
-	
-	a.broadcastLog("debug", fmt.Sprintf("Terraform apply output:\n%s", outputStr), "terraform")
 	return nil
 }
 
-func (a *AzureProvider) GetTerraformOutput(path, key string) (string, error) {
+// PlanTerraform writes a saved plan to tfplan and returns the rendered plan
+// so it can be reviewed before ApplyTerraformPlan runs it. The SDK and
+// Pulumi backends save no plan; they show what ApplyTerraformPlan will
+// create or update.
+func (a *AzureProvider) PlanTerraform(path string) (string, error) {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkPlan()
+	case AzureBackendPulumi:
+		return a.pulumiPlan(path)
+	}
+	a.broadcastLog("info", "Planning Terraform changes...", "terraform")
+	cmd := terraformCommand(a.ctx, "plan", "-out=tfplan", "-no-color", "-input=false")
+	cmd.Dir = path
+	cmd.Env = resolveAzureCredentials(a.Credentials).terraformEnv()
+
+	output, err := streamTerraform(cmd, TerraformPhasePlan, a.broadcaster, a.deploymentID)
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Terraform plan failed: %v", err), "terraform")
+		return "", terraformError(types.ErrCodeTerraformPlan, err, output, "terraform plan failed")
+	}
+
+	a.broadcastLog("success", "Terraform plan created successfully", "terraform")
+	return string(output), nil
+}
+
+// ApplyTerraformPlan applies the plan saved by PlanTerraform, so exactly the
+// reviewed changes are made.
+func (a *AzureProvider) ApplyTerraformPlan(path string) error {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkApply(path)
+	case AzureBackendPulumi:
+		return a.pulumiApply(path)
+	}
+	a.broadcastLog("info", "Applying approved Terraform plan...", "terraform")
+	cmd := terraformCommand(a.ctx, "apply", "-auto-approve", "-input=false", "-no-color", "tfplan")
+	cmd.Dir = path
+	cmd.Env = resolveAzureCredentials(a.Credentials).terraformEnv()
+
+	output, err := streamTerraform(cmd, TerraformPhaseApply, a.broadcaster, a.deploymentID)
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Terraform apply failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, output, "terraform apply failed")
+	}
+
+	return nil
+}
+
+func (a *AzureProvider) GetOutput(path, key string) (string, error) {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkOutput(path, key)
+	case AzureBackendPulumi:
+		return a.pulumiOutput(path, key)
+	}
 	a.broadcastLog("info", fmt.Sprintf("Getting Terraform output for key: %s", key), "terraform")
-	cmd := exec.Command("terraform", "output", "-raw", key)
+	cmd := terraformCommand(a.ctx, "output", "-raw", key)
 	cmd.Dir = path
+	if err := isolate(cmd); err != nil {
+		return "", err
+	}
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -514,12 +1070,35 @@ func (a *AzureProvider) GetTerraformOutput(path, key string) (string, error) {
 	return value, nil
 }
 
+// Destroy deletes every resource created from the configuration in path.
+func (a *AzureProvider) Destroy(path string) error {
+	switch a.Backend {
+	case AzureBackendSDK:
+		return a.sdkDestroy()
+	case AzureBackendPulumi:
+		return a.pulumiDestroy(path)
+	}
+	a.broadcastLog("info", "Destroying Terraform resources (this may take a few minutes)...", "terraform")
+	cmd := terraformCommand(a.ctx, "destroy", "-auto-approve", "-input=false", "-no-color")
+	cmd.Dir = path
+	cmd.Env = resolveAzureCredentials(a.Credentials).terraformEnv()
+
+	output, err := streamTerraform(cmd, TerraformPhaseDestroy, a.broadcaster, a.deploymentID)
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Terraform destroy failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformDestroy, err, output, "terraform destroy failed")
+	}
+
+	a.broadcastLog("success", "Terraform resources destroyed", "terraform")
+	return nil
+}
+
 func (a *AzureProvider) WriteInventory(path, ip string) error {
 	a.broadcastLog("info", "Writing Ansible inventory file...", "ansible")
 	privateKeyPath := filepath.Join(path, "azure_vm_key")
 	content := fmt.Sprintf(`[azure]
-%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='-o StrictHostKeyChecking=no'
-`, ip, privateKeyPath)
+%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='-o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s -o HashKnownHosts=no'
+`, ip, privateKeyPath, filepath.Join(path, "known_hosts"))
 
 	if err := os.WriteFile(filepath.Join(path, "inventory.ini"), []byte(content), 0644); err != nil {
 		a.broadcastLog("error", fmt.Sprintf("Failed to write inventory file: %v", err), "ansible")
@@ -574,23 +1153,27 @@ echo "=== Audit Complete ==="
 func (a *AzureProvider) PrintDeploymentSummary(path string) error {
 	a.broadcastLog("info", "Generating deployment summary...", "summary")
 
-	publicIP, _ := a.GetTerraformOutput(path, "public_ip")
-	resourceGroup, _ := a.GetTerraformOutput(path, "resource_group")
-	vmName, _ := a.GetTerraformOutput(path, "vm_name")
-	sshCommand, _ := a.GetTerraformOutput(path, "ssh_connection_command")
+	publicIP, _ := a.GetOutput(path, "public_ip")
+	resourceGroup, _ := a.GetOutput(path, "resource_group")
+	vmName, _ := a.GetOutput(path, "vm_name")
+	sshCommand, _ := a.GetOutput(path, "ssh_connection_command")
 	
-	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
+	fmt.Print("\n" + strings.Repeat("=", 60) + "\n")
 	fmt.Printf("🚀 AZURE DEPLOYMENT SUMMARY\n")
-	fmt.Printf(strings.Repeat("=", 60) + "\n")
+	fmt.Print(strings.Repeat("=", 60) + "\n")
 	fmt.Printf("📍 Resource Group: %s\n", resourceGroup)
 	fmt.Printf("💻 VM Name: %s\n", vmName)
 	fmt.Printf("📍 Location: %s\n", a.Location)
 	fmt.Printf("📊 VM Size: %s\n", a.VMSize)
 	fmt.Printf("🌐 Public IP: %s\n", publicIP)
-	fmt.Printf(strings.Repeat("-", 60) + "\n")
+	fmt.Printf("⏻ Auto-shutdown: %s\n", a.ShutdownTag())
+	if a.DataDiskName != "" {
+		fmt.Printf("💾 Data Disk: %s (%d GB) at %s\n", a.DataDiskName, a.DataDiskSizeGB, DataDiskMountPoint)
+	}
+	fmt.Print(strings.Repeat("-", 60) + "\n")
 	fmt.Printf("🔑 SSH Connection:\n")
 	fmt.Printf("   %s\n", sshCommand)
-	fmt.Printf(strings.Repeat("-", 60) + "\n")
+	fmt.Print(strings.Repeat("-", 60) + "\n")
 	fmt.Printf("📁 Generated Files:\n")
 	fmt.Printf("   • main.tf (Terraform configuration)\n")
 	fmt.Printf("   • terraform.tfvars (Variables)\n")
@@ -598,7 +1181,7 @@ func (a *AzureProvider) PrintDeploymentSummary(path string) error {
 	fmt.Printf("   • azure_vm_key.pub (Public SSH key)\n")
 	fmt.Printf("   • inventory.ini (Ansible inventory)\n")
 	fmt.Printf("   • security_audit.sh (Security audit script)\n")
-	fmt.Printf(strings.Repeat("=", 60) + "\n\n")
+	fmt.Print(strings.Repeat("=", 60) + "\n\n")
 	
 	a.broadcastLog("success", "Deployment completed successfully!", "summary")
 	return nil
@@ -0,0 +1,562 @@
+//go:build pulumi
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-azure-native-sdk/compute/v2"
+	"github.com/pulumi/pulumi-azure-native-sdk/devtestlab/v2"
+	"github.com/pulumi/pulumi-azure-native-sdk/network/v2"
+	"github.com/pulumi/pulumi-azure-native-sdk/resources/v2"
+	azurenative "github.com/pulumi/pulumi-azure-native-sdk/v2"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// pulumiStack is the stack every Pulumi deployment runs in. Each
+// deployment has a project and local state backend of its own, so one
+// stack name suffices.
+const pulumiStack = "deploy"
+
+// PulumiBackendBuilt reports whether the server was built with the
+// Pulumi backend.
+const PulumiBackendBuilt = true
+
+// pulumiGenerateConfig writes the SSH keys and the passphrase of the
+// stack's secrets. The program is pulumiProgram, which the Automation API
+// runs in the server's process, so there is no program to render.
+func (a *AzureProvider) pulumiGenerateConfig(path string) error {
+	a.broadcastLog("info", "Preparing Pulumi stack...", "terraform")
+	if err := a.checkBackendSupport(); err != nil {
+		return err
+	}
+	if isolation, _ := WorkspaceIsolation(); isolation != "" {
+		err := fmt.Errorf("the pulumi backend runs its program in the server's process, which workspace isolation cannot confine; use the terraform or sdk backend")
+		a.broadcastLog("error", err.Error(), "terraform")
+		return err
+	}
+	if _, err := armSubscriptionID(a.SubscriptionID); err != nil {
+		a.broadcastLog("error", err.Error(), "terraform")
+		return err
+	}
+	if _, _, err := a.GenerateSSHKeys(path); err != nil {
+		return err
+	}
+
+	// The state holds the SSH public key only, but the local backend
+	// still needs a passphrase to encrypt secrets with.
+	passphrase := make([]byte, 16)
+	if _, err := rand.Read(passphrase); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(path, pulumiPassphraseFile), []byte(hex.EncodeToString(passphrase)), 0600); err != nil {
+		return fmt.Errorf("failed to write Pulumi passphrase: %v", err)
+	}
+
+	a.broadcastLog("success", "Pulumi stack prepared successfully", "terraform")
+	return nil
+}
+
+// pulumiProgram returns the inline program creating the same resources as
+// main.tf with the azure-native provider. References between resources
+// are outputs, so Pulumi orders and tracks them.
+func (a *AzureProvider) pulumiProgram(subscriptionID, path string) pulumi.RunFunc {
+	return func(ctx *pulumi.Context) error {
+		provider, err := azurenative.NewProvider(ctx, "azure", &azurenative.ProviderArgs{SubscriptionId: pulumi.StringPtr(subscriptionID)})
+		if err != nil {
+			return err
+		}
+		opts := pulumi.Provider(provider)
+
+		var location, resourceGroup pulumi.StringOutput
+		if a.ExistingResourceGroup {
+			group, err := resources.LookupResourceGroup(ctx, &resources.LookupResourceGroupArgs{ResourceGroupName: a.ResourceGroup}, opts)
+			if err != nil {
+				return err
+			}
+			location, resourceGroup = pulumi.String(group.Location).ToStringOutput(), pulumi.String(a.ResourceGroup).ToStringOutput()
+		} else {
+			group, err := resources.NewResourceGroup(ctx, "resourceGroup", &resources.ResourceGroupArgs{
+				ResourceGroupName: pulumi.String(a.ResourceGroup),
+				Location:          pulumi.String(armLocation(a.Location)),
+			}, opts)
+			if err != nil {
+				return err
+			}
+			location, resourceGroup = group.Location, group.Name
+		}
+
+		subnet := pulumi.String(a.SubnetID).ToStringPtrOutput()
+		if a.SubnetID == "" {
+			vnet, err := network.NewVirtualNetwork(ctx, "network", &network.VirtualNetworkArgs{
+				ResourceGroupName:  resourceGroup,
+				VirtualNetworkName: pulumi.String(a.ResourceName("network")),
+				Location:           location,
+				AddressSpace:       &network.AddressSpaceArgs{AddressPrefixes: pulumi.StringArray{pulumi.String("10.0.0.0/16")}},
+				Subnets: network.SubnetTypeArray{&network.SubnetTypeArgs{
+					Name:          pulumi.String(a.ResourceName("subnet")),
+					AddressPrefix: pulumi.String("10.0.2.0/24"),
+				}},
+			}, opts)
+			if err != nil {
+				return err
+			}
+			subnet = vnet.Subnets.Index(pulumi.Int(0)).Id()
+		}
+
+		var publicIP, address pulumi.StringPtrOutput
+		switch {
+		case a.Private:
+		case a.StaticIPName != "":
+			reserved, err := network.LookupPublicIPAddress(ctx, &network.LookupPublicIPAddressArgs{
+				ResourceGroupName:   a.StaticIPResourceGroup(),
+				PublicIpAddressName: a.StaticIPName,
+			}, opts)
+			if err != nil {
+				return err
+			}
+			publicIP = pulumi.String(armString(reserved.Id)).ToStringPtrOutput()
+			address = pulumi.String(armString(reserved.IpAddress)).ToStringPtrOutput()
+		default:
+			ip, err := network.NewPublicIPAddress(ctx, "publicIp", &network.PublicIPAddressArgs{
+				ResourceGroupName:        resourceGroup,
+				PublicIpAddressName:      pulumi.String(a.ResourceName("public-ip")),
+				Location:                 location,
+				Sku:                      &network.PublicIPAddressSkuArgs{Name: pulumi.String("Standard")},
+				PublicIPAllocationMethod: pulumi.String("Static"),
+			}, opts)
+			if err != nil {
+				return err
+			}
+			publicIP, address = ip.ID().ToStringOutput().ToStringPtrOutput(), ip.IpAddress
+		}
+
+		nsg, err := network.NewNetworkSecurityGroup(ctx, "securityGroup", &network.NetworkSecurityGroupArgs{
+			ResourceGroupName:        resourceGroup,
+			NetworkSecurityGroupName: pulumi.String(a.ResourceName("security-group")),
+			Location:                 location,
+			SecurityRules:            a.pulumiSecurityRules(),
+		}, opts)
+		if err != nil {
+			return err
+		}
+
+		ipConfiguration := &network.NetworkInterfaceIPConfigurationArgs{
+			Name:                      pulumi.String("internal"),
+			Subnet:                    &network.SubnetTypeArgs{Id: subnet},
+			PrivateIPAllocationMethod: pulumi.String("Dynamic"),
+		}
+		if !a.Private {
+			ipConfiguration.PublicIPAddress = &network.PublicIPAddressTypeArgs{Id: publicIP}
+		}
+		nic, err := network.NewNetworkInterface(ctx, "nic", &network.NetworkInterfaceArgs{
+			ResourceGroupName:    resourceGroup,
+			NetworkInterfaceName: pulumi.String(a.ResourceName("nic")),
+			Location:             location,
+			IpConfigurations:     network.NetworkInterfaceIPConfigurationArray{ipConfiguration},
+			NetworkSecurityGroup: &network.NetworkSecurityGroupTypeArgs{Id: nsg.ID().ToStringOutput()},
+		}, opts)
+		if err != nil {
+			return err
+		}
+		if a.Private {
+			address = nic.IpConfigurations.Index(pulumi.Int(0)).PrivateIPAddress()
+		}
+
+		vm, err := compute.NewVirtualMachine(ctx, "vm", a.pulumiVirtualMachine(subscriptionID, resourceGroup, location, nic.ID().ToStringOutput()), opts)
+		if err != nil {
+			return err
+		}
+
+		if a.ShutdownTime != "" {
+			_, err := devtestlab.NewGlobalSchedule(ctx, "shutdown", &devtestlab.GlobalScheduleArgs{
+				ResourceGroupName:    resourceGroup,
+				Name:                 pulumi.String("shutdown-computevm-" + a.VMName),
+				Location:             location,
+				Tags:                 pulumi.StringMap{"Environment": pulumi.String("Development")},
+				Status:               pulumi.String("Enabled"),
+				TaskType:             pulumi.String("ComputeVmShutdownTask"),
+				DailyRecurrence:      &devtestlab.DayDetailsArgs{Time: pulumi.String(a.ShutdownTime)},
+				TimeZoneId:           pulumi.String(a.ShutdownTimezone),
+				NotificationSettings: &devtestlab.NotificationSettingsArgs{Status: pulumi.String("Disabled")},
+				TargetResourceId:     vm.ID().ToStringOutput(),
+			}, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		keyPath := filepath.Join(path, "azure_vm_key")
+		ctx.Export("public_ip", address.Elem())
+		ctx.Export("resource_group", resourceGroup)
+		ctx.Export("vm_name", vm.Name)
+		ctx.Export("ssh_connection_command", pulumi.Sprintf("ssh -i %s azureuser@%s", keyPath, address.Elem()))
+		return nil
+	}
+}
+
+// pulumiVirtualMachine returns the VM's arguments, matching
+// azurerm_linux_virtual_machine in main.tf. The OS disk and NIC are
+// deleted with the VM.
+func (a *AzureProvider) pulumiVirtualMachine(subscriptionID string, resourceGroup, location, nic pulumi.StringOutput) *compute.VirtualMachineArgs {
+	image := &compute.ImageReferenceArgs{Id: pulumi.String(a.CustomImageID())}
+	if a.CustomImageID() == "" {
+		image = &compute.ImageReferenceArgs{
+			Publisher: pulumi.String("Canonical"),
+			Offer:     pulumi.String(a.ImageOffer()),
+			Sku:       pulumi.String(a.ImageSKU()),
+			Version:   pulumi.String("latest"),
+		}
+	}
+	storage := &compute.StorageProfileArgs{
+		ImageReference: image,
+		OsDisk: &compute.OSDiskArgs{
+			CreateOption: pulumi.String("FromImage"),
+			Caching:      compute.CachingTypesReadWrite,
+			ManagedDisk:  &compute.ManagedDiskParametersArgs{StorageAccountType: pulumi.String("Standard_LRS")},
+			DiskSizeGB:   pulumi.Int(a.OSDiskSizeGB()),
+			DeleteOption: pulumi.String("Delete"),
+		},
+	}
+	if a.DataDiskName != "" {
+		storage.DataDisks = compute.DataDiskArray{&compute.DataDiskArgs{
+			Lun:          pulumi.Int(DataDiskLUN),
+			CreateOption: pulumi.String("Attach"),
+			Caching:      compute.CachingTypesReadOnly,
+			ManagedDisk:  &compute.ManagedDiskParametersArgs{Id: pulumi.String(armResourceID(subscriptionID, a.DataDiskResourceGroup(), "Microsoft.Compute", "disks/"+a.DataDiskName))},
+		}}
+	}
+
+	args := &compute.VirtualMachineArgs{
+		ResourceGroupName: resourceGroup,
+		VmName:            pulumi.String(a.VMName),
+		Location:          location,
+		Tags: pulumi.StringMap{
+			"Environment":  pulumi.String("Development"),
+			"AutoShutdown": pulumi.String(a.ShutdownTag()),
+			"Security":     pulumi.String("SSH-Keys-Only"),
+			"Monitoring":   pulumi.String("Enabled"),
+		},
+		HardwareProfile: &compute.HardwareProfileArgs{VmSize: pulumi.String(a.VMSize)},
+		StorageProfile:  storage,
+		OsProfile: &compute.OSProfileArgs{
+			ComputerName:  pulumi.String(a.VMName),
+			AdminUsername: pulumi.String("azureuser"),
+			LinuxConfiguration: &compute.LinuxConfigurationArgs{
+				DisablePasswordAuthentication: pulumi.Bool(true),
+				Ssh: &compute.SshConfigurationArgs{PublicKeys: compute.SshPublicKeyTypeArray{&compute.SshPublicKeyTypeArgs{
+					Path:    pulumi.String("/home/azureuser/.ssh/authorized_keys"),
+					KeyData: pulumi.String(a.PublicKeyContent),
+				}}},
+			},
+		},
+		NetworkProfile: &compute.NetworkProfileArgs{NetworkInterfaces: compute.NetworkInterfaceReferenceArray{&compute.NetworkInterfaceReferenceArgs{
+			Id:           nic,
+			Primary:      pulumi.Bool(true),
+			DeleteOption: pulumi.String("Delete"),
+		}}},
+		DiagnosticsProfile: &compute.DiagnosticsProfileArgs{BootDiagnostics: &compute.BootDiagnosticsArgs{Enabled: pulumi.Bool(true)}},
+	}
+	if a.Spot {
+		maxPrice, _ := strconv.ParseFloat(a.SpotMaxBid(), 64)
+		args.Priority = pulumi.String("Spot")
+		args.EvictionPolicy = pulumi.String(a.SpotEviction())
+		args.BillingProfile = &compute.BillingProfileArgs{MaxPrice: pulumi.Float64(maxPrice)}
+	}
+	return args
+}
+
+// pulumiSecurityRules returns the SDK backend's security rules as
+// azure-native arguments, so both backends open the same ports.
+func (a *AzureProvider) pulumiSecurityRules() network.SecurityRuleTypeArray {
+	stringArray := func(values []*string) pulumi.StringArrayInput {
+		if len(values) == 0 {
+			return nil
+		}
+		array := make(pulumi.StringArray, len(values))
+		for i, value := range values {
+			array[i] = pulumi.String(armString(value))
+		}
+		return array
+	}
+	var rules network.SecurityRuleTypeArray
+	for _, rule := range a.securityRules() {
+		properties := rule.Properties
+		rules = append(rules, &network.SecurityRuleTypeArgs{
+			Name:                     pulumi.StringPtrFromPtr(rule.Name),
+			Priority:                 pulumi.Int(int(*properties.Priority)),
+			Direction:                pulumi.String(string(*properties.Direction)),
+			Access:                   pulumi.String(string(*properties.Access)),
+			Protocol:                 pulumi.String(string(*properties.Protocol)),
+			SourcePortRange:          pulumi.StringPtrFromPtr(properties.SourcePortRange),
+			SourceAddressPrefix:      pulumi.StringPtrFromPtr(properties.SourceAddressPrefix),
+			SourceAddressPrefixes:    stringArray(properties.SourceAddressPrefixes),
+			DestinationAddressPrefix: pulumi.StringPtrFromPtr(properties.DestinationAddressPrefix),
+			DestinationPortRange:     pulumi.StringPtrFromPtr(properties.DestinationPortRange),
+			DestinationPortRanges:    stringArray(properties.DestinationPortRanges),
+		})
+	}
+	return rules
+}
+
+// pulumiContext returns the context of the Automation API's commands,
+// which ends with the deployment's.
+func (a *AzureProvider) pulumiContext() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
+// selectPulumiStack selects the deployment's stack, creating it the first
+// time, with pulumiProgram as its inline program. Its state is kept in a
+// local backend in path, and the provider's credentials are passed in the
+// ARM_ variables azure-native reads.
+func (a *AzureProvider) selectPulumiStack(path string) (auto.Stack, error) {
+	subscriptionID, err := armSubscriptionID(a.SubscriptionID)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, pulumiStateDir), 0700); err != nil {
+		return auto.Stack{}, fmt.Errorf("failed to create Pulumi state directory: %v", err)
+	}
+	env := map[string]string{
+		"PULUMI_BACKEND_URL":            "file://" + filepath.Join(dir, pulumiStateDir),
+		"PULUMI_CONFIG_PASSPHRASE_FILE": filepath.Join(dir, pulumiPassphraseFile),
+		"PULUMI_SKIP_UPDATE_CHECK":      "true",
+	}
+	credentials := resolveAzureCredentials(a.Credentials)
+	switch credentials.Mode() {
+	case AzureAuthServicePrincipal:
+		env["ARM_TENANT_ID"], env["ARM_CLIENT_ID"], env["ARM_CLIENT_SECRET"] = credentials.TenantID, credentials.ClientID, credentials.ClientSecret
+	case AzureAuthManagedIdentity:
+		env["ARM_USE_MSI"] = "true"
+		if credentials.ClientID != "" {
+			env["ARM_CLIENT_ID"] = credentials.ClientID
+		}
+		if credentials.TenantID != "" {
+			env["ARM_TENANT_ID"] = credentials.TenantID
+		}
+	}
+	return auto.UpsertStackInlineSource(a.pulumiContext(), pulumiStack, "django-vpc-"+a.VMName, a.pulumiProgram(subscriptionID, dir),
+		auto.WorkDir(dir), auto.EnvVars(env))
+}
+
+// pulumiInit creates the deployment's stack and installs the azure-native
+// plugin, which pulumi keeps in a cache shared by every deployment.
+func (a *AzureProvider) pulumiInit(path string) error {
+	a.broadcastLog("info", "Initializing Pulumi stack...", "terraform")
+	stack, err := a.selectPulumiStack(path)
+	if err == nil {
+		err = stack.Workspace().InstallPlugin(a.pulumiContext(), "azure-native", "v"+PulumiAzureNativeVersion)
+	}
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Pulumi init failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformInit, err, []byte(err.Error()), "pulumi init failed")
+	}
+	a.broadcastLog("success", "Pulumi stack initialized successfully", "terraform")
+	return nil
+}
+
+// pulumiPlan previews the update with the property diffs, for review
+// before pulumiApply makes it.
+func (a *AzureProvider) pulumiPlan(path string) (string, error) {
+	a.broadcastLog("info", "Previewing Pulumi changes...", "terraform")
+	stack, err := a.selectPulumiStack(path)
+	if err != nil {
+		return "", terraformError(types.ErrCodeTerraformPlan, err, []byte(err.Error()), "pulumi preview failed")
+	}
+	preview, err := stack.Preview(a.pulumiContext(), optpreview.Diff(), optpreview.Color("never"))
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Pulumi preview failed: %v", err), "terraform")
+		return "", terraformError(types.ErrCodeTerraformPlan, err, []byte(err.Error()), "pulumi preview failed")
+	}
+	a.broadcastLog("success", "Pulumi preview created successfully", "terraform")
+	return preview.StdOut, nil
+}
+
+func (a *AzureProvider) pulumiApply(path string) error {
+	a.broadcastLog("info", "Running Pulumi update (this may take a few minutes)...", "terraform")
+	output, err := a.runPulumiUpdate(path, TerraformPhaseApply, func(ctx context.Context, stack auto.Stack, events chan<- events.EngineEvent, output *bytes.Buffer) error {
+		_, err := stack.Up(ctx, optup.EventStreams(events), optup.ProgressStreams(output), optup.Color("never"))
+		return err
+	})
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Pulumi update failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, output, "pulumi up failed")
+	}
+	a.broadcastLog("success", "Infrastructure deployment completed successfully", "terraform")
+	return nil
+}
+
+func (a *AzureProvider) pulumiDestroy(path string) error {
+	a.broadcastLog("info", "Destroying Pulumi resources (this may take a few minutes)...", "terraform")
+	output, err := a.runPulumiUpdate(path, TerraformPhaseDestroy, func(ctx context.Context, stack auto.Stack, events chan<- events.EngineEvent, output *bytes.Buffer) error {
+		_, err := stack.Destroy(ctx, optdestroy.EventStreams(events), optdestroy.ProgressStreams(output), optdestroy.Color("never"))
+		return err
+	})
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Pulumi destroy failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformDestroy, err, output, "pulumi destroy failed")
+	}
+	a.broadcastLog("success", "Pulumi resources destroyed", "terraform")
+	return nil
+}
+
+// pulumiOutput reads a stack output. Outputs are typed in the stack;
+// strings are returned as they are and anything else as JSON.
+func (a *AzureProvider) pulumiOutput(path, key string) (string, error) {
+	stack, err := a.selectPulumiStack(path)
+	if err != nil {
+		return "", err
+	}
+	outputs, err := stack.Outputs(a.pulumiContext())
+	if err != nil {
+		return "", fmt.Errorf("failed to read Pulumi outputs: %v", err)
+	}
+	output, ok := outputs[key]
+	if !ok {
+		return "", fmt.Errorf("output %q not found", key)
+	}
+	if value, ok := output.Value.(string); ok {
+		return value, nil
+	}
+	data, err := json.Marshal(output.Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode output %q: %v", key, err)
+	}
+	return string(data), nil
+}
+
+// pulumiResourceName returns a resource's name, the last part of its URN.
+func pulumiResourceName(urn string) string {
+	return urn[strings.LastIndex(urn, "::")+2:]
+}
+
+// pulumiOps are the progress and completion wording of the steps worth
+// logging; unchanged resources and reads are not.
+var pulumiOps = map[apitype.OpType][2]string{
+	"create":             {"Creating", "Created"},
+	"update":             {"Updating", "Updated"},
+	"delete":             {"Deleting", "Deleted"},
+	"replace":            {"Replacing", "Replaced"},
+	"create-replacement": {"Creating replacement", "Created replacement"},
+	"delete-replaced":    {"Deleting replaced", "Deleted replaced"},
+}
+
+// runPulumiUpdate runs an update or destroy through the Automation API and
+// streams its engine events as log messages with the resource, its type
+// and the operation in their data. The stream is delivered by the time
+// the operation returns. It returns the operation's progress output for
+// error classification.
+func (a *AzureProvider) runPulumiUpdate(path, phase string, operation func(context.Context, auto.Stack, chan<- events.EngineEvent, *bytes.Buffer) error) ([]byte, error) {
+	stack, err := a.selectPulumiStack(path)
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+	stream := make(chan events.EngineEvent)
+	go func() {
+		for event := range stream {
+			a.broadcastPulumiEvent(event.EngineEvent, phase)
+		}
+	}()
+	var output bytes.Buffer
+	if err := operation(a.pulumiContext(), stack, stream, &output); err != nil {
+		return append(output.Bytes(), err.Error()...), err
+	}
+	return output.Bytes(), nil
+}
+
+func (a *AzureProvider) broadcastPulumiEvent(event apitype.EngineEvent, phase string) {
+	logMsg := types.LogMessage{
+		Step:      "terraform",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Data:      map[string]interface{}{"phase": phase, "engine": "pulumi"},
+	}
+	step := func(s apitype.StepEventMetadata) {
+		logMsg.Data["resource"] = pulumiResourceName(s.URN)
+		logMsg.Data["type"] = s.Type
+		logMsg.Data["op"] = s.Op
+	}
+	switch {
+	case event.ResourcePreEvent != nil && !event.ResourcePreEvent.Planning:
+		s := event.ResourcePreEvent.Metadata
+		words, ok := pulumiOps[s.Op]
+		if !ok {
+			return
+		}
+		logMsg.Level, logMsg.Message = "info", fmt.Sprintf("%s %s %s...", words[0], s.Type, pulumiResourceName(s.URN))
+		step(s)
+	case event.ResOutputsEvent != nil && !event.ResOutputsEvent.Planning:
+		s := event.ResOutputsEvent.Metadata
+		words, ok := pulumiOps[s.Op]
+		if !ok {
+			return
+		}
+		logMsg.Level, logMsg.Message = "success", fmt.Sprintf("%s %s %s", words[1], s.Type, pulumiResourceName(s.URN))
+		step(s)
+	case event.ResOpFailedEvent != nil:
+		s := event.ResOpFailedEvent.Metadata
+		logMsg.Level, logMsg.Message = "error", fmt.Sprintf("Failed to %s %s %s", s.Op, s.Type, pulumiResourceName(s.URN))
+		step(s)
+	case event.DiagnosticEvent != nil && !event.DiagnosticEvent.Ephemeral:
+		switch event.DiagnosticEvent.Severity {
+		case "error":
+			logMsg.Level = "error"
+		case "warning":
+			logMsg.Level = "warn"
+		case "info":
+			logMsg.Level = "info"
+		default:
+			return
+		}
+		logMsg.Message = strings.TrimSpace(event.DiagnosticEvent.Message)
+		if logMsg.Message == "" {
+			return
+		}
+	case event.SummaryEvent != nil:
+		var changes []string
+		for op, count := range event.SummaryEvent.ResourceChanges {
+			if op != "same" && count > 0 {
+				changes = append(changes, fmt.Sprintf("%d %s", count, op))
+			}
+		}
+		sort.Strings(changes)
+		if len(changes) == 0 {
+			changes = []string{"no changes"}
+		}
+		logMsg.Level = "success"
+		logMsg.Message = fmt.Sprintf("Pulumi %s complete in %ds: %s", phase, event.SummaryEvent.DurationSeconds, strings.Join(changes, ", "))
+		logMsg.Data["changes"] = event.SummaryEvent.ResourceChanges
+	default:
+		return
+	}
+
+	printLog(logMsg)
+	if a.broadcaster != nil {
+		a.broadcaster.BroadcastLog(a.deploymentID, logMsg)
+	}
+}
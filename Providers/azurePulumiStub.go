@@ -0,0 +1,37 @@
+//go:build !pulumi
+
+package providers
+
+import "errors"
+
+// PulumiBackendBuilt reports whether the server was built with the
+// Pulumi backend. It is left out unless built with -tags pulumi, since
+// the Pulumi SDK is much larger than the rest of the server.
+const PulumiBackendBuilt = false
+
+var errPulumiNotBuilt = errors.New("this server was built without the pulumi backend; rebuild it with -tags pulumi or use the terraform or sdk backend")
+
+func (a *AzureProvider) pulumiGenerateConfig(path string) error {
+	a.broadcastLog("error", errPulumiNotBuilt.Error(), "terraform")
+	return errPulumiNotBuilt
+}
+
+func (a *AzureProvider) pulumiInit(path string) error {
+	return errPulumiNotBuilt
+}
+
+func (a *AzureProvider) pulumiPlan(path string) (string, error) {
+	return "", errPulumiNotBuilt
+}
+
+func (a *AzureProvider) pulumiApply(path string) error {
+	return errPulumiNotBuilt
+}
+
+func (a *AzureProvider) pulumiDestroy(path string) error {
+	return errPulumiNotBuilt
+}
+
+func (a *AzureProvider) pulumiOutput(path, key string) (string, error) {
+	return "", errPulumiNotBuilt
+}
@@ -0,0 +1,724 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Azure provisioning backends. The Terraform backend renders main.tf and
+// runs the terraform binary; the SDK backend creates the same resources
+// with the Azure SDK for Go, so the host needs no terraform binary;
+// the Pulumi backend runs a Pulumi program creating them through the
+// Automation API.
+const (
+	AzureBackendTerraform = "terraform"
+	AzureBackendSDK       = "sdk"
+	AzureBackendPulumi    = "pulumi"
+)
+
+// AzureBackendFromEnv returns the backend operators chose with
+// AZURE_BACKEND, or AzureBackendTerraform.
+func AzureBackendFromEnv() string {
+	if backend := strings.ToLower(os.Getenv("AZURE_BACKEND")); ValidAzureBackend(backend) {
+		return backend
+	}
+	return AzureBackendTerraform
+}
+
+// ValidAzureBackend reports whether backend names a provisioning backend.
+func ValidAzureBackend(backend string) bool {
+	return backend == AzureBackendTerraform || backend == AzureBackendSDK || backend == AzureBackendPulumi
+}
+
+// WorkspaceAzureBackend returns the backend whose files are in a
+// deployment's directory, or "" if it cannot tell: Pulumi keeps its stack's
+// passphrase there, the SDK backend its outputs and Terraform its state.
+func WorkspaceAzureBackend(path string) string {
+	for _, marker := range []struct{ file, backend string }{
+		{pulumiPassphraseFile, AzureBackendPulumi},
+		{sdkOutputsFile, AzureBackendSDK},
+		{"terraform.tfstate", AzureBackendTerraform},
+	} {
+		if _, err := os.Stat(filepath.Join(path, marker.file)); err == nil {
+			return marker.backend
+		}
+	}
+	return ""
+}
+
+// UsesTerraform reports whether the provider provisions with Terraform
+// rather than another backend.
+func (a *AzureProvider) UsesTerraform() bool {
+	return a.Backend == "" || a.Backend == AzureBackendTerraform
+}
+
+// sdkOutputsFile holds the SDK backend's outputs, the ones main.tf
+// declares, for GetOutput.
+const sdkOutputsFile = "azure_outputs.json"
+
+// Files the Pulumi backend keeps in a deployment's directory, besides the
+// project settings the Automation API writes there.
+const (
+	pulumiPassphraseFile = ".pulumi-passphrase"
+	pulumiStateDir       = ".pulumi"
+)
+
+// armDevTestLabAPI is the API version of the auto-shutdown schedule, which
+// has no client of its own and is created as a generic resource.
+const armDevTestLabAPI = "2018-09-15"
+
+func (a *AzureProvider) armClients() (*armClients, error) {
+	subscriptionID, err := armSubscriptionID(a.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	return newARMClients(resolveAzureCredentials(a.Credentials), subscriptionID)
+}
+
+// armContext returns the context of a Resource Manager call, which ends
+// with the deployment's or after armOperationTimeout.
+func (a *AzureProvider) armContext() (context.Context, context.CancelFunc) {
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, armOperationTimeout)
+}
+
+// armWait waits for the long-running operation begin started.
+func armWait[T any](ctx context.Context, poller *runtime.Poller[T], err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// armString returns the value of an optional string from the SDK.
+func armString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// armLocation returns a location display name like "East US" as the name
+// Resource Manager uses, eastus.
+func armLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
+// sdkResources are the IDs of the resources the SDK backend manages for a
+// provider, which the resources refer to each other by.
+type sdkResources struct {
+	subnet   string
+	publicIP string
+	nsg      string
+	nic      string
+	vm       string
+	shutdown string
+	dataDisk string
+}
+
+func (a *AzureProvider) sdkResources(subscriptionID string) sdkResources {
+	id := func(resourceGroup, provider, name string) string {
+		return armResourceID(subscriptionID, resourceGroup, provider, name)
+	}
+	r := sdkResources{
+		subnet: a.SubnetID,
+		nsg:    id(a.ResourceGroup, "Microsoft.Network", "networkSecurityGroups/"+a.ResourceName("security-group")),
+		nic:    id(a.ResourceGroup, "Microsoft.Network", "networkInterfaces/"+a.ResourceName("nic")),
+		vm:     id(a.ResourceGroup, "Microsoft.Compute", "virtualMachines/"+a.VMName),
+	}
+	if a.SubnetID == "" {
+		r.subnet = id(a.ResourceGroup, "Microsoft.Network", "virtualNetworks/"+a.ResourceName("network")) + "/subnets/" + a.ResourceName("subnet")
+	}
+	if !a.Private {
+		group, name := a.publicIPName()
+		r.publicIP = id(group, "Microsoft.Network", "publicIPAddresses/"+name)
+	}
+	if a.ShutdownTime != "" {
+		r.shutdown = id(a.ResourceGroup, "Microsoft.DevTestLab", "schedules/shutdown-computevm-"+a.VMName)
+	}
+	if a.DataDiskName != "" {
+		r.dataDisk = id(a.DataDiskResourceGroup(), "Microsoft.Compute", "disks/"+a.DataDiskName)
+	}
+	return r
+}
+
+// publicIPName returns the resource group and name of the VM's public IP:
+// the reserved one, or its own.
+func (a *AzureProvider) publicIPName() (string, string) {
+	if a.StaticIPName != "" {
+		return a.StaticIPResourceGroup(), a.StaticIPName
+	}
+	return a.ResourceGroup, a.ResourceName("public-ip")
+}
+
+// checkBackendSupport fails for VMs only the Terraform backend provisions.
+func (a *AzureProvider) checkBackendSupport() error {
+	if a.ScaleSet {
+		a.broadcastLog("error", "Scale sets are only provisioned by the terraform backend", "terraform")
+		return fmt.Errorf("scale sets are only provisioned by the terraform backend")
+	}
+	return nil
+}
+
+// sdkGenerateConfig prepares path for the SDK backend: there is no
+// configuration to render, only the SSH keys to write.
+func (a *AzureProvider) sdkGenerateConfig(path string) error {
+	a.broadcastLog("info", "Preparing Azure Resource Manager deployment...", "terraform")
+	if err := a.checkBackendSupport(); err != nil {
+		return err
+	}
+	if _, err := armSubscriptionID(a.SubscriptionID); err != nil {
+		a.broadcastLog("error", err.Error(), "terraform")
+		return err
+	}
+	if _, _, err := a.GenerateSSHKeys(path); err != nil {
+		return err
+	}
+	a.broadcastLog("success", "Azure Resource Manager deployment prepared", "terraform")
+	return nil
+}
+
+// sdkInit checks the provider's credentials can get a Resource Manager
+// token, so a deployment fails before anything is created if they cannot.
+func (a *AzureProvider) sdkInit() error {
+	a.broadcastLog("info", "Authenticating to Azure Resource Manager...", "terraform")
+	c, err := a.armClients()
+	if err == nil {
+		ctx, cancel := a.armContext()
+		err = c.token(ctx)
+		cancel()
+	}
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Azure authentication failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformInit, err, []byte(err.Error()), "azure authentication failed")
+	}
+	a.broadcastLog("success", fmt.Sprintf("Authenticated to Azure Resource Manager (%s)", c.credentials.Mode()), "terraform")
+	return nil
+}
+
+// sdkPlan lists the resources sdkApply would create (+) or update in
+// place (~).
+func (a *AzureProvider) sdkPlan() (string, error) {
+	a.broadcastLog("info", "Planning Azure Resource Manager changes...", "terraform")
+	c, err := a.armClients()
+	if err != nil {
+		return "", terraformError(types.ErrCodeTerraformPlan, err, []byte(err.Error()), "azure plan failed")
+	}
+	r := a.sdkResources(c.subscriptionID)
+	ctx, cancel := a.armContext()
+	defer cancel()
+
+	var plan strings.Builder
+	var planErr error
+	line := func(description string, get func() error) {
+		if planErr != nil {
+			return
+		}
+		err := get()
+		if err != nil && !armNotFound(err) {
+			planErr = err
+			return
+		}
+		marker := "~"
+		if err != nil {
+			marker = "+"
+		}
+		fmt.Fprintf(&plan, "  %s %s\n", marker, description)
+	}
+
+	if a.ExistingResourceGroup {
+		fmt.Fprintf(&plan, "  = resource group %s (existing)\n", a.ResourceGroup)
+	} else {
+		line(fmt.Sprintf("resource group %s in %s", a.ResourceGroup, armLocation(a.Location)), func() error {
+			_, err := c.groups.Get(ctx, a.ResourceGroup, nil)
+			return err
+		})
+	}
+	if a.SubnetID == "" {
+		line(fmt.Sprintf("virtual network %s 10.0.0.0/16, subnet %s 10.0.2.0/24", a.ResourceName("network"), a.ResourceName("subnet")), func() error {
+			_, err := c.networks.Get(ctx, a.ResourceGroup, a.ResourceName("network"), nil)
+			return err
+		})
+	} else {
+		fmt.Fprintf(&plan, "  = subnet %s (existing)\n", a.SubnetID)
+	}
+	switch {
+	case a.Private:
+	case a.StaticIPName != "":
+		fmt.Fprintf(&plan, "  = public IP %s (reserved)\n", a.StaticIPName)
+	default:
+		line(fmt.Sprintf("public IP %s (Standard, static)", a.ResourceName("public-ip")), func() error {
+			_, err := c.publicIPs.Get(ctx, a.ResourceGroup, a.ResourceName("public-ip"), nil)
+			return err
+		})
+	}
+	line(fmt.Sprintf("network security group %s (%d rules)", a.ResourceName("security-group"), len(a.securityRules())), func() error {
+		_, err := c.securityGroups.Get(ctx, a.ResourceGroup, a.ResourceName("security-group"), nil)
+		return err
+	})
+	line("network interface "+a.ResourceName("nic"), func() error {
+		_, err := c.interfaces.Get(ctx, a.ResourceGroup, a.ResourceName("nic"), nil)
+		return err
+	})
+	line(fmt.Sprintf("virtual machine %s (%s, %d GB OS disk)", a.VMName, a.VMSize, a.OSDiskSizeGB()), func() error {
+		_, err := c.machines.Get(ctx, a.ResourceGroup, a.VMName, nil)
+		return err
+	})
+	if r.dataDisk != "" {
+		fmt.Fprintf(&plan, "  = data disk %s attached at LUN %d\n", a.DataDiskName, DataDiskLUN)
+	}
+	if r.shutdown != "" {
+		line("auto-shutdown schedule "+a.ShutdownTag(), func() error {
+			_, err := c.resources.GetByID(ctx, r.shutdown, armDevTestLabAPI, nil)
+			return err
+		})
+	}
+	if planErr != nil {
+		a.broadcastLog("error", fmt.Sprintf("Azure plan failed: %v", planErr), "terraform")
+		return "", terraformError(types.ErrCodeTerraformPlan, planErr, []byte(planErr.Error()), "azure plan failed")
+	}
+
+	a.broadcastLog("success", "Azure Resource Manager plan created successfully", "terraform")
+	return "Azure Resource Manager plan:\n" + plan.String(), nil
+}
+
+// sdkApply creates or updates the VM and its network, then writes the
+// outputs main.tf would have to path. Resources are created or updated
+// whole, so applying again converges on the same resources; a VM whose
+// SSH key or image changed is replaced, as Terraform would.
+func (a *AzureProvider) sdkApply(path string) error {
+	a.broadcastLog("info", "Provisioning through Azure Resource Manager (this may take a few minutes)...", "terraform")
+	if err := a.sdkProvision(path); err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Azure provisioning failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, []byte(err.Error()), "azure provisioning failed")
+	}
+	a.broadcastLog("success", "Infrastructure deployment completed successfully", "terraform")
+	return nil
+}
+
+func (a *AzureProvider) sdkProvision(path string) error {
+	c, err := a.armClients()
+	if err != nil {
+		return err
+	}
+	r := a.sdkResources(c.subscriptionID)
+	ctx, cancel := a.armContext()
+	defer cancel()
+
+	location := armLocation(a.Location)
+	if a.ExistingResourceGroup {
+		group, err := c.groups.Get(ctx, a.ResourceGroup, nil)
+		if armNotFound(err) {
+			return fmt.Errorf("ResourceGroupNotFound: resource group %s does not exist", a.ResourceGroup)
+		}
+		if err != nil {
+			return err
+		}
+		location = armString(group.Location)
+	} else {
+		a.broadcastLog("info", fmt.Sprintf("Creating resource group %s...", a.ResourceGroup), "terraform")
+		if _, err := c.groups.CreateOrUpdate(ctx, a.ResourceGroup, armresources.ResourceGroup{Location: to.Ptr(location)}, nil); err != nil {
+			return err
+		}
+	}
+
+	if a.SubnetID == "" {
+		a.broadcastLog("info", fmt.Sprintf("Creating virtual network %s...", a.ResourceName("network")), "terraform")
+		poller, err := c.networks.BeginCreateOrUpdate(ctx, a.ResourceGroup, a.ResourceName("network"), a.virtualNetwork(location), nil)
+		if err := armWait(ctx, poller, err); err != nil {
+			return err
+		}
+	}
+
+	if !a.Private && a.StaticIPName == "" {
+		a.broadcastLog("info", fmt.Sprintf("Creating public IP %s...", a.ResourceName("public-ip")), "terraform")
+		poller, err := c.publicIPs.BeginCreateOrUpdate(ctx, a.ResourceGroup, a.ResourceName("public-ip"), publicIPAddress(location), nil)
+		if err := armWait(ctx, poller, err); err != nil {
+			return err
+		}
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Creating network security group %s...", a.ResourceName("security-group")), "terraform")
+	nsg := armnetwork.SecurityGroup{
+		Location:   to.Ptr(location),
+		Properties: &armnetwork.SecurityGroupPropertiesFormat{SecurityRules: a.securityRules()},
+	}
+	poller, err := c.securityGroups.BeginCreateOrUpdate(ctx, a.ResourceGroup, a.ResourceName("security-group"), nsg, nil)
+	if err := armWait(ctx, poller, err); err != nil {
+		return err
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Creating network interface %s...", a.ResourceName("nic")), "terraform")
+	nicPoller, err := c.interfaces.BeginCreateOrUpdate(ctx, a.ResourceGroup, a.ResourceName("nic"), networkInterface(location, r), nil)
+	if err := armWait(ctx, nicPoller, err); err != nil {
+		return err
+	}
+
+	if err := a.replaceChangedVM(ctx, c); err != nil {
+		return err
+	}
+	a.broadcastLog("info", fmt.Sprintf("Creating virtual machine %s (%s)...", a.VMName, a.VMSize), "terraform")
+	vmPoller, err := c.machines.BeginCreateOrUpdate(ctx, a.ResourceGroup, a.VMName, a.virtualMachine(location, r), nil)
+	if err := armWait(ctx, vmPoller, err); err != nil {
+		return err
+	}
+
+	if r.shutdown != "" {
+		a.broadcastLog("info", fmt.Sprintf("Scheduling auto-shutdown at %s...", a.ShutdownTag()), "terraform")
+		schedulePoller, err := c.resources.BeginCreateOrUpdateByID(ctx, r.shutdown, armDevTestLabAPI, a.shutdownSchedule(location, r), nil)
+		if err := armWait(ctx, schedulePoller, err); err != nil {
+			return err
+		}
+	}
+
+	return a.writeSDKOutputs(ctx, c, path)
+}
+
+func (a *AzureProvider) virtualNetwork(location string) armnetwork.VirtualNetwork {
+	return armnetwork.VirtualNetwork{
+		Location: to.Ptr(location),
+		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
+			AddressSpace: &armnetwork.AddressSpace{AddressPrefixes: []*string{to.Ptr("10.0.0.0/16")}},
+			Subnets: []*armnetwork.Subnet{{
+				Name:       to.Ptr(a.ResourceName("subnet")),
+				Properties: &armnetwork.SubnetPropertiesFormat{AddressPrefix: to.Ptr("10.0.2.0/24")},
+			}},
+		},
+	}
+}
+
+func publicIPAddress(location string) armnetwork.PublicIPAddress {
+	return armnetwork.PublicIPAddress{
+		Location:   to.Ptr(location),
+		SKU:        &armnetwork.PublicIPAddressSKU{Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard)},
+		Properties: &armnetwork.PublicIPAddressPropertiesFormat{PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic)},
+	}
+}
+
+func networkInterface(location string, r sdkResources) armnetwork.Interface {
+	ipConfiguration := &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+		Subnet:                    &armnetwork.Subnet{ID: to.Ptr(r.subnet)},
+		PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodDynamic),
+	}
+	if r.publicIP != "" {
+		ipConfiguration.PublicIPAddress = &armnetwork.PublicIPAddress{ID: to.Ptr(r.publicIP)}
+	}
+	return armnetwork.Interface{
+		Location: to.Ptr(location),
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			IPConfigurations:     []*armnetwork.InterfaceIPConfiguration{{Name: to.Ptr("internal"), Properties: ipConfiguration}},
+			NetworkSecurityGroup: &armnetwork.SecurityGroup{ID: to.Ptr(r.nsg)},
+		},
+	}
+}
+
+// shutdownSchedule returns the DevTestLab auto-shutdown schedule, matching
+// azurerm_dev_test_global_vm_shutdown_schedule in main.tf.
+func (a *AzureProvider) shutdownSchedule(location string, r sdkResources) armresources.GenericResource {
+	return armresources.GenericResource{
+		Location: to.Ptr(location),
+		Tags:     map[string]*string{"Environment": to.Ptr("Development")},
+		Properties: map[string]interface{}{
+			"status":               "Enabled",
+			"taskType":             "ComputeVmShutdownTask",
+			"dailyRecurrence":      map[string]interface{}{"time": a.ShutdownTime},
+			"timeZoneId":           a.ShutdownTimezone,
+			"notificationSettings": map[string]interface{}{"status": "Disabled"},
+			"targetResourceId":     r.vm,
+		},
+	}
+}
+
+// virtualMachine returns the VM resource, matching
+// azurerm_linux_virtual_machine in main.tf. The OS disk and NIC are
+// deleted with the VM.
+func (a *AzureProvider) virtualMachine(location string, r sdkResources) armcompute.VirtualMachine {
+	image := &armcompute.ImageReference{ID: to.Ptr(a.CustomImageID())}
+	if a.CustomImageID() == "" {
+		image = &armcompute.ImageReference{
+			Publisher: to.Ptr("Canonical"),
+			Offer:     to.Ptr(a.ImageOffer()),
+			SKU:       to.Ptr(a.ImageSKU()),
+			Version:   to.Ptr("latest"),
+		}
+	}
+	storage := &armcompute.StorageProfile{
+		ImageReference: image,
+		OSDisk: &armcompute.OSDisk{
+			CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
+			Caching:      to.Ptr(armcompute.CachingTypesReadWrite),
+			ManagedDisk:  &armcompute.ManagedDiskParameters{StorageAccountType: to.Ptr(armcompute.StorageAccountTypesStandardLRS)},
+			DiskSizeGB:   to.Ptr(int32(a.OSDiskSizeGB())),
+			DeleteOption: to.Ptr(armcompute.DiskDeleteOptionTypesDelete),
+		},
+	}
+	if r.dataDisk != "" {
+		storage.DataDisks = []*armcompute.DataDisk{{
+			Lun:          to.Ptr(int32(DataDiskLUN)),
+			CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesAttach),
+			Caching:      to.Ptr(armcompute.CachingTypesReadOnly),
+			ManagedDisk:  &armcompute.ManagedDiskParameters{ID: to.Ptr(r.dataDisk)},
+		}}
+	}
+
+	properties := &armcompute.VirtualMachineProperties{
+		HardwareProfile: &armcompute.HardwareProfile{VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(a.VMSize))},
+		StorageProfile:  storage,
+		OSProfile: &armcompute.OSProfile{
+			ComputerName:  to.Ptr(a.VMName),
+			AdminUsername: to.Ptr("azureuser"),
+			LinuxConfiguration: &armcompute.LinuxConfiguration{
+				DisablePasswordAuthentication: to.Ptr(true),
+				SSH: &armcompute.SSHConfiguration{PublicKeys: []*armcompute.SSHPublicKey{{
+					Path:    to.Ptr("/home/azureuser/.ssh/authorized_keys"),
+					KeyData: to.Ptr(a.PublicKeyContent),
+				}}},
+			},
+		},
+		NetworkProfile: &armcompute.NetworkProfile{NetworkInterfaces: []*armcompute.NetworkInterfaceReference{{
+			ID:         to.Ptr(r.nic),
+			Properties: &armcompute.NetworkInterfaceReferenceProperties{Primary: to.Ptr(true), DeleteOption: to.Ptr(armcompute.DeleteOptionsDelete)},
+		}}},
+		DiagnosticsProfile: &armcompute.DiagnosticsProfile{BootDiagnostics: &armcompute.BootDiagnostics{Enabled: to.Ptr(true)}},
+	}
+	if a.Spot {
+		maxPrice, _ := strconv.ParseFloat(a.SpotMaxBid(), 64)
+		properties.Priority = to.Ptr(armcompute.VirtualMachinePriorityTypesSpot)
+		properties.EvictionPolicy = to.Ptr(armcompute.VirtualMachineEvictionPolicyTypes(a.SpotEviction()))
+		properties.BillingProfile = &armcompute.BillingProfile{MaxPrice: to.Ptr(maxPrice)}
+	}
+
+	return armcompute.VirtualMachine{
+		Location: to.Ptr(location),
+		Tags: map[string]*string{
+			"Environment":  to.Ptr("Development"),
+			"AutoShutdown": to.Ptr(a.ShutdownTag()),
+			"Security":     to.Ptr("SSH-Keys-Only"),
+			"Monitoring":   to.Ptr("Enabled"),
+		},
+		Properties: properties,
+	}
+}
+
+// replaceChangedVM deletes the existing VM if it has another SSH key or
+// image, which Azure cannot change in place.
+func (a *AzureProvider) replaceChangedVM(ctx context.Context, c *armClients) error {
+	existing, err := c.machines.Get(ctx, a.ResourceGroup, a.VMName, nil)
+	if armNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var image armcompute.ImageReference
+	var keys []*armcompute.SSHPublicKey
+	if properties := existing.Properties; properties != nil {
+		if properties.StorageProfile != nil && properties.StorageProfile.ImageReference != nil {
+			image = *properties.StorageProfile.ImageReference
+		}
+		if properties.OSProfile != nil && properties.OSProfile.LinuxConfiguration != nil && properties.OSProfile.LinuxConfiguration.SSH != nil {
+			keys = properties.OSProfile.LinuxConfiguration.SSH.PublicKeys
+		}
+	}
+	sameImage := strings.EqualFold(armString(image.ID), a.CustomImageID())
+	if a.CustomImageID() == "" {
+		sameImage = armString(image.Offer) == a.ImageOffer() && armString(image.SKU) == a.ImageSKU()
+	}
+	sameKey := len(keys) == 1 && strings.TrimSpace(armString(keys[0].KeyData)) == strings.TrimSpace(a.PublicKeyContent)
+	if sameImage && sameKey {
+		return nil
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Replacing virtual machine %s, whose SSH key or image changed...", a.VMName), "terraform")
+	poller, err := c.machines.BeginDelete(ctx, a.ResourceGroup, a.VMName, nil)
+	return armWait(ctx, poller, err)
+}
+
+// securityRules returns the NSG's rules, matching
+// azurerm_network_security_group in main.tf.
+func (a *AzureProvider) securityRules() []*armnetwork.SecurityRule {
+	rule := func(name string, priority int32, access armnetwork.SecurityRuleAccess, protocol armnetwork.SecurityRuleProtocol, ports string) *armnetwork.SecurityRule {
+		return &armnetwork.SecurityRule{
+			Name: to.Ptr(name),
+			Properties: &armnetwork.SecurityRulePropertiesFormat{
+				Priority:                 to.Ptr(priority),
+				Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+				Access:                   to.Ptr(access),
+				Protocol:                 to.Ptr(protocol),
+				SourcePortRange:          to.Ptr("*"),
+				SourceAddressPrefix:      to.Ptr("*"),
+				DestinationAddressPrefix: to.Ptr("*"),
+				DestinationPortRange:     to.Ptr(ports),
+			},
+		}
+	}
+	allow, tcp := armnetwork.SecurityRuleAccessAllow, armnetwork.SecurityRuleProtocolTCP
+
+	ssh := rule("SSH", 1001, allow, tcp, "22")
+	if len(a.SSHSourcePrefixes) > 0 {
+		ssh.Properties.SourceAddressPrefix = nil
+		ssh.Properties.SourceAddressPrefixes = to.SliceOfPtrs(a.SSHSourcePrefixes...)
+	}
+	rules := []*armnetwork.SecurityRule{
+		ssh,
+		rule("HTTP_8000", 1002, allow, tcp, "8000"),
+		rule("HTTP", 1003, allow, tcp, "80"),
+		rule("HTTPS", 1004, allow, tcp, "443"),
+	}
+	if a.ExtraPortRange != "" {
+		rules = append(rules, rule("PooledApps", 1005, allow, tcp, a.ExtraPortRange))
+	}
+	if len(a.OpenPorts) > 0 {
+		open := rule("OpenPorts", 1007, allow, tcp, "")
+		open.Properties.DestinationPortRange = nil
+		for _, port := range a.OpenPorts {
+			open.Properties.DestinationPortRanges = append(open.Properties.DestinationPortRanges, to.Ptr(strconv.Itoa(port)))
+		}
+		rules = append(rules, open)
+	}
+	return append(rules, rule("DenyAllInbound", 4096, armnetwork.SecurityRuleAccessDeny, armnetwork.SecurityRuleProtocolAsterisk, "*"))
+}
+
+// writeSDKOutputs writes the outputs main.tf declares to path, for
+// GetOutput.
+func (a *AzureProvider) writeSDKOutputs(ctx context.Context, c *armClients, path string) error {
+	var address string
+	if !a.Private {
+		group, name := a.publicIPName()
+		publicIP, err := c.publicIPs.Get(ctx, group, name, nil)
+		if err != nil {
+			return err
+		}
+		if publicIP.Properties != nil {
+			address = armString(publicIP.Properties.IPAddress)
+		}
+	} else {
+		nic, err := c.interfaces.Get(ctx, a.ResourceGroup, a.ResourceName("nic"), nil)
+		if err != nil {
+			return err
+		}
+		if nic.Properties != nil && len(nic.Properties.IPConfigurations) > 0 && nic.Properties.IPConfigurations[0].Properties != nil {
+			address = armString(nic.Properties.IPConfigurations[0].Properties.PrivateIPAddress)
+		}
+	}
+	if address == "" {
+		return fmt.Errorf("the VM has no address yet")
+	}
+
+	keyPath, err := filepath.Abs(filepath.Join(path, "azure_vm_key"))
+	if err != nil {
+		return err
+	}
+	outputs := map[string]string{
+		"public_ip":              address,
+		"resource_group":         a.ResourceGroup,
+		"vm_name":                a.VMName,
+		"ssh_connection_command": fmt.Sprintf("ssh -i %s azureuser@%s", keyPath, address),
+	}
+	data, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(path, sdkOutputsFile), data, 0644)
+}
+
+func (a *AzureProvider) sdkOutput(path, key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(path, sdkOutputsFile))
+	if err != nil {
+		return "", fmt.Errorf("no outputs recorded; the deployment has not been applied: %v", err)
+	}
+	var outputs map[string]string
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		return "", fmt.Errorf("failed to decode %s: %v", sdkOutputsFile, err)
+	}
+	value, ok := outputs[key]
+	if !ok {
+		return "", fmt.Errorf("output %q not found", key)
+	}
+	return value, nil
+}
+
+// sdkDestroy deletes the provider's resources. A resource group of its own
+// is deleted whole; in an existing one only the deployment's resources
+// are, leaving the reserved public IP and data disk in their own groups.
+// It needs nothing from path, so it works without the original workspace.
+func (a *AzureProvider) sdkDestroy() error {
+	a.broadcastLog("info", "Destroying Azure resources (this may take a few minutes)...", "terraform")
+	c, err := a.armClients()
+	if err != nil {
+		return terraformError(types.ErrCodeTerraformDestroy, err, []byte(err.Error()), "azure destroy failed")
+	}
+	r := a.sdkResources(c.subscriptionID)
+	ctx, cancel := a.armContext()
+	defer cancel()
+
+	deletes := []func() error{func() error {
+		poller, err := c.groups.BeginDelete(ctx, a.ResourceGroup, nil)
+		return armWait(ctx, poller, err)
+	}}
+	if a.ExistingResourceGroup {
+		deletes = []func() error{
+			func() error {
+				if r.shutdown == "" {
+					return nil
+				}
+				poller, err := c.resources.BeginDeleteByID(ctx, r.shutdown, armDevTestLabAPI, nil)
+				return armWait(ctx, poller, err)
+			},
+			func() error {
+				poller, err := c.machines.BeginDelete(ctx, a.ResourceGroup, a.VMName, nil)
+				return armWait(ctx, poller, err)
+			},
+			func() error {
+				poller, err := c.interfaces.BeginDelete(ctx, a.ResourceGroup, a.ResourceName("nic"), nil)
+				return armWait(ctx, poller, err)
+			},
+			func() error {
+				poller, err := c.securityGroups.BeginDelete(ctx, a.ResourceGroup, a.ResourceName("security-group"), nil)
+				return armWait(ctx, poller, err)
+			},
+			func() error {
+				if a.SubnetID != "" {
+					return nil
+				}
+				poller, err := c.networks.BeginDelete(ctx, a.ResourceGroup, a.ResourceName("network"), nil)
+				return armWait(ctx, poller, err)
+			},
+			func() error {
+				if a.Private || a.StaticIPName != "" {
+					return nil
+				}
+				poller, err := c.publicIPs.BeginDelete(ctx, a.ResourceGroup, a.ResourceName("public-ip"), nil)
+				return armWait(ctx, poller, err)
+			},
+		}
+	}
+	for _, del := range deletes {
+		// Deleting a resource that does not exist succeeds.
+		if err := del(); err != nil && !armNotFound(err) {
+			a.broadcastLog("error", fmt.Sprintf("Azure destroy failed: %v", err), "terraform")
+			return terraformError(types.ErrCodeTerraformDestroy, err, []byte(err.Error()), "azure destroy failed")
+		}
+	}
+
+	a.broadcastLog("success", "Azure resources destroyed", "terraform")
+	return nil
+}
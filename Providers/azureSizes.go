@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+)
+
+// Memory in GiB of the sizes in azureVMHourlyPrices.
+var azureVMMemoryGiB = map[string]float64{
+	"Standard_B1ls":   0.5,
+	"Standard_B1s":    1,
+	"Standard_B1ms":   2,
+	"Standard_B2s":    4,
+	"Standard_B2ms":   8,
+	"Standard_B4ms":   16,
+	"Standard_B8ms":   32,
+	"Standard_D2s_v3": 8,
+	"Standard_D4s_v3": 16,
+	"Standard_D8s_v3": 32,
+	"Standard_D2s_v5": 8,
+	"Standard_D4s_v5": 16,
+	"Standard_D8s_v5": 32,
+
+	"Standard_B2pls_v2": 4,
+	"Standard_B2ps_v2":  8,
+	"Standard_B4ps_v2":  16,
+	"Standard_D2ps_v5":  8,
+	"Standard_D4ps_v5":  16,
+	"Standard_D8ps_v5":  32,
+
+	"Standard_NC4as_T4_v3":  28,
+	"Standard_NC8as_T4_v3":  56,
+	"Standard_NC16as_T4_v3": 110,
+	"Standard_NC6s_v3":      112,
+}
+
+// VMSizeSpec is the capacity and price of a VM size.
+type VMSizeSpec struct {
+	Name        string  `json:"name"`
+	VCPUs       int     `json:"vcpus"`
+	MemoryGiB   float64 `json:"memory_gib"`
+	HourlyPrice float64 `json:"hourly_price"`
+}
+
+// LookupVMSize returns the spec of a VM size this tool has data for.
+func LookupVMSize(size string) (VMSizeSpec, bool) {
+	price, ok := azureVMHourlyPrices[size]
+	memory, known := azureVMMemoryGiB[size]
+	if !ok || !known {
+		return VMSizeSpec{}, false
+	}
+	return VMSizeSpec{Name: size, VCPUs: VMVCPUs(size), MemoryGiB: memory, HourlyPrice: price}, true
+}
+
+// vmSeries identifies the series of a VM size, like "B" or "D_v5", and its
+// architecture: sizes of a series differ only in capacity, so a VM can be
+// resized between them without changing its image.
+func vmSeries(size string) string {
+	parts := strings.Split(size, "_")
+	if len(parts) < 2 {
+		return size
+	}
+	family := parts[1][:len(parts[1])-len(strings.TrimLeft(parts[1], "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))]
+	series := strings.Join(append([]string{family}, parts[2:]...), "_")
+	if IsARM64VMSize(size) {
+		series += "/arm64"
+	}
+	return series
+}
+
+// SimilarVMSizes returns the known sizes of size's series, size included,
+// cheapest first.
+func SimilarVMSizes(size string) []VMSizeSpec {
+	series := vmSeries(size)
+	var sizes []VMSizeSpec
+	for name := range azureVMHourlyPrices {
+		if vmSeries(name) != series {
+			continue
+		}
+		if spec, ok := LookupVMSize(name); ok {
+			sizes = append(sizes, spec)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].HourlyPrice != sizes[j].HourlyPrice {
+			return sizes[i].HourlyPrice < sizes[j].HourlyPrice
+		}
+		return sizes[i].Name < sizes[j].Name
+	})
+	return sizes
+}
@@ -0,0 +1,178 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrVMNotFound is returned when the provider's VM does not exist yet.
+var ErrVMNotFound = errors.New("virtual machine not found")
+
+// Snapshot is a managed disk snapshot of a deployment's OS disk.
+type Snapshot struct {
+	Name         string    `json:"name"`
+	ID           string    `json:"id"`
+	DeploymentID string    `json:"deployment_id"`
+	SourceDiskID string    `json:"source_disk_id"`
+	DiskSizeGB   int       `json:"disk_size_gb"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type azSnapshot struct {
+	Name         string            `json:"name"`
+	ID           string            `json:"id"`
+	DiskSizeGB   int               `json:"diskSizeGb"`
+	TimeCreated  time.Time         `json:"timeCreated"`
+	Tags         map[string]string `json:"tags"`
+	CreationData struct {
+		SourceResourceID string `json:"sourceResourceId"`
+	} `json:"creationData"`
+}
+
+func (s azSnapshot) toSnapshot() Snapshot {
+	return Snapshot{
+		Name:         s.Name,
+		ID:           s.ID,
+		DeploymentID: s.Tags["deployment_id"],
+		SourceDiskID: s.CreationData.SourceResourceID,
+		DiskSizeGB:   s.DiskSizeGB,
+		CreatedAt:    s.TimeCreated,
+	}
+}
+
+// az runs an az CLI command as the provider's credentials against its
+// subscription, or the CLI's default subscription if none is set.
+func (a *AzureProvider) az(args ...string) ([]byte, error) {
+	configDir, err := azConfigDir(resolveAzureCredentials(a.Credentials))
+	if err != nil {
+		return nil, err
+	}
+	if a.SubscriptionID != "" {
+		args = append(args, "--subscription", a.SubscriptionID)
+	}
+	return runAzIn(configDir, args...)
+}
+
+// runAzIn runs an az CLI command with configDir as its AZURE_CONFIG_DIR, or
+// the default one if empty, and returns its stdout, folding stderr into the
+// error so Azure's message reaches the caller.
+func runAzIn(configDir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("az", args...)
+	cmd.Stderr = &stderr
+	if configDir != "" {
+		cmd.Env = append(os.Environ(), "AZURE_CONFIG_DIR="+configDir)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("az %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// OSDiskID returns the managed OS disk of the provider's VM, or
+// ErrVMNotFound if the VM has not been created.
+func (a *AzureProvider) OSDiskID() (string, error) {
+	output, err := a.az("vm", "show",
+		"-g", a.ResourceGroup,
+		"-n", a.VMName,
+		"--query", "storageProfile.osDisk.managedDisk.id",
+		"-o", "tsv")
+	if err != nil {
+		if strings.Contains(err.Error(), "ResourceNotFound") || strings.Contains(err.Error(), "ResourceGroupNotFound") {
+			return "", ErrVMNotFound
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CreateSnapshot snapshots the VM's OS disk, tagging it with deploymentID so
+// it can be listed per deployment.
+func (a *AzureProvider) CreateSnapshot(name, deploymentID string) (*Snapshot, error) {
+	a.broadcastLog("info", fmt.Sprintf("Creating snapshot %s of VM %s...", name, a.VMName), "snapshot")
+
+	diskID, err := a.OSDiskID()
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Failed to find OS disk: %v", err), "snapshot")
+		return nil, err
+	}
+
+	output, err := a.az("snapshot", "create",
+		"-g", a.ResourceGroup,
+		"-n", name,
+		"--source", diskID,
+		"--incremental", "true",
+		"--tags", "deployment_id="+deploymentID, "created_by=django-vpc",
+		"-o", "json")
+	if err != nil {
+		a.broadcastLog("error", fmt.Sprintf("Failed to create snapshot: %v", err), "snapshot")
+		return nil, err
+	}
+
+	var created azSnapshot
+	if err := json.Unmarshal(output, &created); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot response: %v", err)
+	}
+
+	snapshot := created.toSnapshot()
+	a.broadcastLog("success", fmt.Sprintf("Snapshot %s created", name), "snapshot")
+	return &snapshot, nil
+}
+
+// ListSnapshots returns the snapshots in the resource group tagged with
+// deploymentID, oldest first.
+func (a *AzureProvider) ListSnapshots(deploymentID string) ([]Snapshot, error) {
+	output, err := a.az("snapshot", "list",
+		"-g", a.ResourceGroup,
+		"--query", fmt.Sprintf("[?tags.deployment_id=='%s'] | sort_by(@, &timeCreated)", deploymentID),
+		"-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []azSnapshot
+	if err := json.Unmarshal(output, &listed); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot list: %v", err)
+	}
+
+	snapshots := make([]Snapshot, 0, len(listed))
+	for _, s := range listed {
+		snapshots = append(snapshots, s.toSnapshot())
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot replaces the VM's OS disk with a new disk created from the
+// named snapshot. The previous disk is kept so the restore can be undone.
+func (a *AzureProvider) RestoreSnapshot(name string) error {
+	diskName := fmt.Sprintf("%s-restored-%s", a.VMName, time.Now().Format("20060102-150405"))
+
+	steps := []struct {
+		message string
+		args    []string
+	}{
+		{"Deallocating VM...", []string{"vm", "deallocate", "-g", a.ResourceGroup, "-n", a.VMName}},
+		{fmt.Sprintf("Creating disk %s from snapshot %s...", diskName, name), []string{"disk", "create", "-g", a.ResourceGroup, "-n", diskName, "--source", name, "-o", "none"}},
+		{"Swapping OS disk...", []string{"vm", "update", "-g", a.ResourceGroup, "-n", a.VMName, "--os-disk", diskName, "-o", "none"}},
+		{"Starting VM...", []string{"vm", "start", "-g", a.ResourceGroup, "-n", a.VMName}},
+	}
+
+	for _, step := range steps {
+		a.broadcastLog("info", step.message, "snapshot")
+		if _, err := a.az(step.args...); err != nil {
+			a.broadcastLog("error", fmt.Sprintf("Restore failed: %v", err), "snapshot")
+			return err
+		}
+	}
+
+	a.broadcastLog("success", fmt.Sprintf("VM %s restored from snapshot %s", a.VMName, name), "snapshot")
+	return nil
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StaticIPResourceGroupSuffix names the resource group that holds a
+// deployment's reserved public IP, next to the deployment's own group.
+// Keeping the IP out of the deployment's group and Terraform state lets it
+// outlive the VM and be attached again when the VM is rebuilt.
+const StaticIPResourceGroupSuffix = "-ip"
+
+// StaticIPResourceGroup returns the resource group of the provider's
+// reserved public IP.
+func (a *AzureProvider) StaticIPResourceGroup() string {
+	return a.ResourceGroup + StaticIPResourceGroupSuffix
+}
+
+// PublicIPRef returns the Terraform address of the VM's public IP: the
+// reserved IP when StaticIPName is set, the one created with the VM
+// otherwise.
+func (a *AzureProvider) PublicIPRef() string {
+	if a.StaticIPName != "" {
+		return "data.azurerm_public_ip.reserved"
+	}
+	return "azurerm_public_ip.example"
+}
+
+// ReserveStaticIP creates the provider's reserved public IP unless it
+// already exists, and returns its address. It must be in the VM's
+// location, so a deployment moved to another region gets a new one.
+func (a *AzureProvider) ReserveStaticIP() (string, error) {
+	resourceGroup := a.StaticIPResourceGroup()
+	output, err := a.az("network", "public-ip", "show",
+		"-g", resourceGroup,
+		"-n", a.StaticIPName,
+		"--query", "ipAddress",
+		"-o", "tsv")
+	if err == nil {
+		address := strings.TrimSpace(string(output))
+		a.broadcastLog("info", fmt.Sprintf("Reusing reserved public IP %s", address), "network")
+		return address, nil
+	}
+	if !strings.Contains(err.Error(), "ResourceNotFound") && !strings.Contains(err.Error(), "ResourceGroupNotFound") {
+		return "", err
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Reserving public IP %s in %s...", a.StaticIPName, resourceGroup), "network")
+	if _, err := a.az("group", "create",
+		"-n", resourceGroup,
+		"-l", a.Location,
+		"--tags", "environment=django-vpc", "purpose=reserved-ip",
+		"-o", "none"); err != nil {
+		return "", err
+	}
+	output, err = a.az("network", "public-ip", "create",
+		"-g", resourceGroup,
+		"-n", a.StaticIPName,
+		"-l", a.Location,
+		"--sku", "Standard",
+		"--allocation-method", "Static",
+		"--query", "publicIp.ipAddress",
+		"-o", "tsv")
+	if err != nil {
+		return "", err
+	}
+	address := strings.TrimSpace(string(output))
+	a.broadcastLog("success", fmt.Sprintf("Reserved public IP %s", address), "network")
+	return address, nil
+}
+
+// ReleaseStaticIP deletes the provider's reserved public IP with its
+// resource group. An IP that was never reserved is not an error.
+func (a *AzureProvider) ReleaseStaticIP() error {
+	a.broadcastLog("info", fmt.Sprintf("Releasing reserved public IP %s...", a.StaticIPName), "network")
+	if err := a.deleteGroup(a.StaticIPResourceGroup()); err != nil {
+		return err
+	}
+	a.broadcastLog("success", fmt.Sprintf("Released reserved public IP %s", a.StaticIPName), "network")
+	return nil
+}
@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RunShellScript runs script on the provider's VM through the Azure VM
+// agent, which needs no SSH key, and returns the script's combined output.
+func (a *AzureProvider) RunShellScript(script string) (string, error) {
+	output, err := a.az("vm", "run-command", "invoke",
+		"-g", a.ResourceGroup,
+		"-n", a.VMName,
+		"--command-id", "RunShellScript",
+		"--scripts", script,
+		"-o", "json")
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Value []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return "", fmt.Errorf("failed to decode run-command response: %v", err)
+	}
+
+	var messages []string
+	for _, value := range result.Value {
+		if value.Message != "" {
+			messages = append(messages, strings.TrimSpace(value.Message))
+		}
+	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// RestartVM reboots the provider's VM and waits for Azure to report it
+// running again.
+func (a *AzureProvider) RestartVM() error {
+	_, err := a.az("vm", "restart", "-g", a.ResourceGroup, "-n", a.VMName, "-o", "none")
+	return err
+}
+
+// ResizeVM deallocates the provider's VM, changes its size and starts it
+// again. Deallocating first lets Azure move the VM to hardware that offers
+// the new size. If the resize fails the VM is started with its old size.
+func (a *AzureProvider) ResizeVM(size string) error {
+	a.broadcastLog("info", fmt.Sprintf("Deallocating VM %s...", a.VMName), "resize")
+	if _, err := a.az("vm", "deallocate", "-g", a.ResourceGroup, "-n", a.VMName, "-o", "none"); err != nil {
+		return fmt.Errorf("failed to deallocate VM: %v", err)
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Resizing VM %s from %s to %s...", a.VMName, a.VMSize, size), "resize")
+	if _, err := a.az("vm", "resize", "-g", a.ResourceGroup, "-n", a.VMName, "--size", size, "-o", "none"); err != nil {
+		if _, startErr := a.az("vm", "start", "-g", a.ResourceGroup, "-n", a.VMName, "-o", "none"); startErr != nil {
+			return fmt.Errorf("failed to resize VM: %v; starting it again failed too: %v", err, startErr)
+		}
+		return fmt.Errorf("failed to resize VM: %v", err)
+	}
+
+	a.broadcastLog("info", fmt.Sprintf("Starting VM %s...", a.VMName), "resize")
+	if _, err := a.az("vm", "start", "-g", a.ResourceGroup, "-n", a.VMName, "-o", "none"); err != nil {
+		return fmt.Errorf("VM was resized but failed to start: %v", err)
+	}
+	a.VMSize = size
+	return nil
+}
+
+// PublicIPAddress returns the public IP currently attached to the
+// provider's VM.
+func (a *AzureProvider) PublicIPAddress() (string, error) {
+	output, err := a.az("vm", "show", "-d",
+		"-g", a.ResourceGroup,
+		"-n", a.VMName,
+		"--query", "publicIps",
+		"-o", "tsv")
+	if err != nil {
+		return "", err
+	}
+	ip := strings.TrimSpace(string(output))
+	if ip == "" {
+		return "", fmt.Errorf("VM %s has no public IP", a.VMName)
+	}
+	return ip, nil
+}
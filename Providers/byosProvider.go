@@ -0,0 +1,58 @@
+package providers
+
+import "fmt"
+
+// BYOSProvider deploys to a server the user already runs ("bring your own
+// server"), reached over SSH as User with PrivateKey. Nothing is
+// provisioned: the Terraform steps do nothing and Host stands in for the
+// public_ip and vm_name outputs. The deployment sets up the azureuser
+// account the generated playbook expects before running it.
+type BYOSProvider struct {
+	terraformRunner
+	Host       string
+	User       string
+	PrivateKey string
+}
+
+// GenerateTerraformConfig does nothing; there is no infrastructure to
+// describe.
+func (b *BYOSProvider) GenerateTerraformConfig(path string) error {
+	b.broadcastLog("info", fmt.Sprintf("Using existing server %s, no Terraform configuration needed", b.Host), "terraform")
+	return nil
+}
+
+func (b *BYOSProvider) InitTerraform(path string) error {
+	return nil
+}
+
+// PlanTerraform returns a plan that changes nothing.
+func (b *BYOSProvider) PlanTerraform(path string) (string, error) {
+	return fmt.Sprintf("No infrastructure changes: deploying to existing server %s as %s.\n", b.Host, b.User), nil
+}
+
+func (b *BYOSProvider) ApplyTerraform(path string) error {
+	return nil
+}
+
+func (b *BYOSProvider) ApplyTerraformPlan(path string) error {
+	return nil
+}
+
+// GetOutput answers public_ip and vm_name with the server's address.
+func (b *BYOSProvider) GetOutput(path, key string) (string, error) {
+	switch key {
+	case "public_ip", "vm_name":
+		return b.Host, nil
+	}
+	return "", fmt.Errorf("existing server has no output %q", key)
+}
+
+func (b *BYOSProvider) WriteInventory(path, ip string) error {
+	return b.writeInventory(path, "byos", ip)
+}
+
+// Destroy leaves the server alone; it was not created by this tool.
+func (b *BYOSProvider) Destroy(path string) error {
+	b.broadcastLog("info", fmt.Sprintf("Existing server %s is not managed by this tool, nothing to destroy", b.Host), "terraform")
+	return nil
+}
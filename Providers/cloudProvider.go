@@ -1,9 +1,43 @@
 package providers
 
+import (
+	"context"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// CloudProvider provisions a single VM with Terraform, working in a
+// directory that holds the generated configuration, state and SSH keys.
+// A deployment drives it through the same steps whichever cloud it targets.
 type CloudProvider interface {
+	SetLogger(broadcaster types.LogBroadcaster, deploymentID string)
+	// SetContext sets the context whose cancellation interrupts the
+	// provider's running commands.
+	SetContext(ctx context.Context)
+	// GenerateSSHKeys writes a new key pair to path and returns its public
+	// and private halves.
+	GenerateSSHKeys(path string) (string, string, error)
 	GenerateTerraformConfig(path string) error
 	InitTerraform(path string) error
+	// PlanTerraform saves a plan for ApplyTerraformPlan and returns it
+	// rendered for review.
+	PlanTerraform(path string) (string, error)
 	ApplyTerraform(path string) error
-	GenerateSSHKeys(path string) error
-
+	ApplyTerraformPlan(path string) error
+	// GetOutput returns a Terraform output; every provider has vm_name,
+	// and every provider that runs VMs has public_ip.
+	GetOutput(path, key string) (string, error)
+	WriteInventory(path, ip string) error
+	Destroy(path string) error
 }
+
+var (
+	_ CloudProvider = (*AzureProvider)(nil)
+	_ CloudProvider = (*AWSProvider)(nil)
+	_ CloudProvider = (*DigitalOceanProvider)(nil)
+	_ CloudProvider = (*HetznerProvider)(nil)
+	_ CloudProvider = (*LinodeProvider)(nil)
+	_ CloudProvider = (*OCIProvider)(nil)
+	_ CloudProvider = (*BYOSProvider)(nil)
+	_ CloudProvider = (*AKSProvider)(nil)
+)
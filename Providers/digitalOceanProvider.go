@@ -0,0 +1,221 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+)
+
+// DigitalOceanProvider provisions a droplet behind a cloud firewall. Name
+// is the droplet's name and prefixes the other resources it creates.
+type DigitalOceanProvider struct {
+	terraformRunner
+	Name   string
+	Region string
+	Size   string
+}
+
+// digitalOceanRegions are the regions this tool deploys to.
+var digitalOceanRegions = map[string]bool{
+	"nyc1": true,
+	"nyc3": true,
+	"sfo3": true,
+	"tor1": true,
+	"ams3": true,
+	"lon1": true,
+	"fra1": true,
+	"blr1": true,
+	"sgp1": true,
+	"syd1": true,
+}
+
+// digitalOceanSizes are the droplet sizes this tool deploys.
+var digitalOceanSizes = map[string]bool{
+	"s-1vcpu-1gb":  true,
+	"s-1vcpu-2gb":  true,
+	"s-2vcpu-2gb":  true,
+	"s-2vcpu-4gb":  true,
+	"s-4vcpu-8gb":  true,
+	"s-8vcpu-16gb": true,
+	"g-2vcpu-8gb":  true,
+	"c-2":          true,
+	"c-4":          true,
+}
+
+// IsKnownDigitalOceanRegion reports whether region is a DigitalOcean region
+// this tool deploys to.
+func IsKnownDigitalOceanRegion(region string) bool {
+	return digitalOceanRegions[region]
+}
+
+// IsKnownDigitalOceanSize reports whether size is a droplet size this tool
+// deploys.
+func IsKnownDigitalOceanSize(size string) bool {
+	return digitalOceanSizes[size]
+}
+
+// The generated playbook deploys as azureuser, so cloud-init creates that
+// account next to the image's root login.
+const digitalOceanTfTemplate = `
+terraform {
+  required_providers {
+    digitalocean = {
+      source  = "digitalocean/digitalocean"
+      version = "~> 2.0"
+    }
+  }
+}
+
+# The API token is read from DIGITALOCEAN_TOKEN
+provider "digitalocean" {}
+
+# Local file resources for SSH keys
+resource "local_file" "private_key" {
+  content         = var.private_key_content
+  filename        = "${path.module}/azure_vm_key"
+  file_permission = "0600"
+}
+
+resource "local_file" "public_key" {
+  content         = var.public_key_content
+  filename        = "${path.module}/azure_vm_key.pub"
+  file_permission = "0644"
+}
+
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+resource "digitalocean_ssh_key" "main" {
+  name       = "{{ .Name }}-key"
+  public_key = var.public_key_content
+}
+
+resource "digitalocean_droplet" "main" {
+  name       = "{{ .Name }}"
+  region     = "{{ .Region }}"
+  size       = "{{ .Size }}"
+  image      = "ubuntu-22-04-x64"
+  ssh_keys   = [digitalocean_ssh_key.main.fingerprint]
+  monitoring = true
+
+  user_data = <<-EOT
+    #cloud-config
+    users:
+      - default
+      - name: azureuser
+        shell: /bin/bash
+        sudo: ALL=(ALL) NOPASSWD:ALL
+        ssh_authorized_keys:
+          - ${trimspace(var.public_key_content)}
+  EOT
+
+  tags = ["django-vpc"]
+
+  # Ensure SSH keys are created before the droplet
+  depends_on = [local_file.private_key, local_file.public_key]
+}
+
+resource "digitalocean_firewall" "main" {
+  name        = "{{ .Name }}-fw"
+  droplet_ids = [digitalocean_droplet.main.id]
+
+  # SSH access
+  inbound_rule {
+    protocol         = "tcp"
+    port_range       = "22"
+    source_addresses = ["0.0.0.0/0", "::/0"] # Consider restricting to your IP range
+  }
+
+  # HTTP access
+  inbound_rule {
+    protocol         = "tcp"
+    port_range       = "80"
+    source_addresses = ["0.0.0.0/0", "::/0"]
+  }
+
+  # HTTPS access
+  inbound_rule {
+    protocol         = "tcp"
+    port_range       = "443"
+    source_addresses = ["0.0.0.0/0", "::/0"]
+  }
+
+  # Custom application port
+  inbound_rule {
+    protocol         = "tcp"
+    port_range       = "8000"
+    source_addresses = ["0.0.0.0/0", "::/0"]
+  }
+
+  outbound_rule {
+    protocol              = "tcp"
+    port_range            = "1-65535"
+    destination_addresses = ["0.0.0.0/0", "::/0"]
+  }
+
+  outbound_rule {
+    protocol              = "udp"
+    port_range            = "1-65535"
+    destination_addresses = ["0.0.0.0/0", "::/0"]
+  }
+
+  outbound_rule {
+    protocol              = "icmp"
+    destination_addresses = ["0.0.0.0/0", "::/0"]
+  }
+}
+
+# Outputs
+output "public_ip" {
+  value = digitalocean_droplet.main.ipv4_address
+}
+
+output "vm_name" {
+  value = digitalocean_droplet.main.name
+}
+
+output "droplet_id" {
+  value = digitalocean_droplet.main.id
+}
+
+output "ssh_connection_command" {
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${digitalocean_droplet.main.ipv4_address}"
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars. The API
+// token comes from DIGITALOCEAN_TOKEN, which Terraform reads itself.
+func (d *DigitalOceanProvider) GenerateTerraformConfig(path string) error {
+	d.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	if os.Getenv("DIGITALOCEAN_TOKEN") == "" {
+		d.broadcastLog("error", "DIGITALOCEAN_TOKEN environment variable is not set", "terraform")
+		return fmt.Errorf("DIGITALOCEAN_TOKEN environment variable is not set")
+	}
+
+	publicKeyContent, privateKeyContent, err := d.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := d.writeMainTF(path, digitalOceanTfTemplate, d); err != nil {
+		return err
+	}
+	if err := d.writeTFVars(path, publicKeyContent, privateKeyContent, ""); err != nil {
+		return err
+	}
+
+	d.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (d *DigitalOceanProvider) WriteInventory(path, ip string) error {
+	return d.writeInventory(path, "digitalocean", ip)
+}
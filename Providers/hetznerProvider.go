@@ -0,0 +1,221 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// HetznerProvider provisions a Hetzner Cloud server behind a cloud firewall.
+// Name is the server's name and prefixes the other resources it creates.
+type HetznerProvider struct {
+	terraformRunner
+	Name       string
+	Location   string
+	ServerType string
+}
+
+// hetznerLocations are the locations this tool deploys to.
+var hetznerLocations = map[string]bool{
+	"fsn1": true,
+	"nbg1": true,
+	"hel1": true,
+	"ash":  true,
+	"hil":  true,
+	"sin":  true,
+}
+
+// hetznerServerTypes are the server types this tool deploys.
+var hetznerServerTypes = map[string]bool{
+	"cx22":  true,
+	"cx32":  true,
+	"cx42":  true,
+	"cx52":  true,
+	"cpx11": true,
+	"cpx21": true,
+	"cpx31": true,
+	"cpx41": true,
+	"cax11": true,
+	"cax21": true,
+	"cax31": true,
+	"cax41": true,
+}
+
+// IsKnownHetznerLocation reports whether location is a Hetzner Cloud
+// location this tool deploys to.
+func IsKnownHetznerLocation(location string) bool {
+	return hetznerLocations[location]
+}
+
+// IsKnownHetznerServerType reports whether serverType is a Hetzner Cloud
+// server type this tool deploys.
+func IsKnownHetznerServerType(serverType string) bool {
+	return hetznerServerTypes[serverType]
+}
+
+// IsARM64ServerType reports whether serverType is an Ampere (arm64) type.
+// Hetzner names these cax.
+func IsARM64ServerType(serverType string) bool {
+	return strings.HasPrefix(serverType, "cax")
+}
+
+// The generated playbook deploys as azureuser, so cloud-init creates that
+// account next to the image's root login. Hetzner picks the image build
+// matching the server type's architecture.
+const hetznerTfTemplate = `
+terraform {
+  required_providers {
+    hcloud = {
+      source  = "hetznercloud/hcloud"
+      version = "~> 1.45"
+    }
+  }
+}
+
+# The API token is read from HCLOUD_TOKEN
+provider "hcloud" {}
+
+# Local file resources for SSH keys
+resource "local_file" "private_key" {
+  content         = var.private_key_content
+  filename        = "${path.module}/azure_vm_key"
+  file_permission = "0600"
+}
+
+resource "local_file" "public_key" {
+  content         = var.public_key_content
+  filename        = "${path.module}/azure_vm_key.pub"
+  file_permission = "0644"
+}
+
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+resource "hcloud_ssh_key" "main" {
+  name       = "{{ .Name }}-key"
+  public_key = var.public_key_content
+}
+
+resource "hcloud_firewall" "main" {
+  name = "{{ .Name }}-fw"
+
+  # SSH access
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "22"
+    source_ips = ["0.0.0.0/0", "::/0"] # Consider restricting to your IP range
+  }
+
+  # HTTP access
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "80"
+    source_ips = ["0.0.0.0/0", "::/0"]
+  }
+
+  # HTTPS access
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "443"
+    source_ips = ["0.0.0.0/0", "::/0"]
+  }
+
+  # Custom application port
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "8000"
+    source_ips = ["0.0.0.0/0", "::/0"]
+  }
+}
+
+resource "hcloud_server" "main" {
+  name         = "{{ .Name }}"
+  location     = "{{ .Location }}"
+  server_type  = "{{ .ServerType }}"
+  image        = "ubuntu-22.04"
+  ssh_keys     = [hcloud_ssh_key.main.id]
+  firewall_ids = [hcloud_firewall.main.id]
+
+  public_net {
+    ipv4_enabled = true
+    ipv6_enabled = true
+  }
+
+  user_data = <<-EOT
+    #cloud-config
+    users:
+      - default
+      - name: azureuser
+        shell: /bin/bash
+        sudo: ALL=(ALL) NOPASSWD:ALL
+        ssh_authorized_keys:
+          - ${trimspace(var.public_key_content)}
+  EOT
+
+  labels = {
+    managed_by = "django-vpc"
+  }
+
+  # Ensure SSH keys are created before the server
+  depends_on = [local_file.private_key, local_file.public_key]
+}
+
+# Outputs
+output "public_ip" {
+  value = hcloud_server.main.ipv4_address
+}
+
+output "vm_name" {
+  value = hcloud_server.main.name
+}
+
+output "server_id" {
+  value = hcloud_server.main.id
+}
+
+output "ssh_connection_command" {
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${hcloud_server.main.ipv4_address}"
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars. The API
+// token comes from HCLOUD_TOKEN, which Terraform reads itself.
+func (h *HetznerProvider) GenerateTerraformConfig(path string) error {
+	h.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	if os.Getenv("HCLOUD_TOKEN") == "" {
+		h.broadcastLog("error", "HCLOUD_TOKEN environment variable is not set", "terraform")
+		return fmt.Errorf("HCLOUD_TOKEN environment variable is not set")
+	}
+
+	publicKeyContent, privateKeyContent, err := h.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := h.writeMainTF(path, hetznerTfTemplate, h); err != nil {
+		return err
+	}
+	if err := h.writeTFVars(path, publicKeyContent, privateKeyContent, ""); err != nil {
+		return err
+	}
+
+	h.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (h *HetznerProvider) WriteInventory(path, ip string) error {
+	return h.writeInventory(path, "hetzner", ip)
+}
@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"sathwikshetty33/Django-vpc/Tools"
+)
+
+// isolatedEnvPrefixes are the environment variables passed on to isolated
+// tooling: cloud credentials, Terraform and Ansible settings and
+// the HOOK_ details of lifecycle hooks. Everything else the server has,
+// like its admin token or store keys, stays out of the container.
+var isolatedEnvPrefixes = []string{
+	"ARM_", "AZURE_", "TF_", "AWS_", "DIGITALOCEAN_", "HCLOUD_", "LINODE_", "OCI_", "ANSIBLE_", "HOOK_",
+}
+
+// WorkspaceIsolation returns the container engine WORKSPACE_ISOLATION runs
+// each deployment's Terraform, Ansible and hook commands in,
+// "docker" or "podman", or "" to run them as processes of the server's
+// user. Isolated tools see only the deployment's directory, so a templated
+// file or lookup cannot read the server's data, other deployments or its
+// credentials. Credentials kept in files, like az login or AWS profiles,
+// are not available there; use environment credentials instead.
+func WorkspaceIsolation() (string, error) {
+	switch isolation := os.Getenv("WORKSPACE_ISOLATION"); isolation {
+	case "", "none":
+		return "", nil
+	case "docker", "podman":
+		if runtime.GOOS == "windows" {
+			return "", fmt.Errorf("WORKSPACE_ISOLATION is not supported on Windows hosts")
+		}
+		return isolation, nil
+	default:
+		return "", fmt.Errorf("unsupported WORKSPACE_ISOLATION %q (expected none, docker or podman)", isolation)
+	}
+}
+
+// TerraformImage returns the image terraform runs in under workspace
+// isolation: TERRAFORM_IMAGE, or the official image of TERRAFORM_VERSION
+// or the pinned version.
+func TerraformImage() string {
+	if image := os.Getenv("TERRAFORM_IMAGE"); image != "" {
+		return image
+	}
+	version := os.Getenv("TERRAFORM_VERSION")
+	if version == "" {
+		version = tools.DefaultTerraformVersion
+	}
+	return "hashicorp/terraform:" + version
+}
+
+// ContainerIsolationArgs are the container engine arguments that confine
+// isolated tooling: no capabilities or privilege escalation, a bounded
+// number of processes and the server's user, so files written to the
+// mounted directory keep their owner.
+func ContainerIsolationArgs(engine string) []string {
+	args := []string{"--init", "--cap-drop=ALL", "--security-opt=no-new-privileges", "--pids-limit=1024", "-e", "HOME=/tmp"}
+	if engine == "podman" {
+		return append(args, "--userns=keep-id")
+	}
+	return append(args, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+}
+
+// Isolate rewrites cmd, with its directory, environment and cancellation
+// already set, to run in a scratch container of image when workspace
+// isolation is on, and leaves it as it is otherwise. The directory and
+// mounts are mounted at the same paths, so paths in arguments and
+// configuration stay valid. Only the names of the environment variables in
+// isolatedEnvPrefixes are passed, so their values never show up on the
+// engine's command line. A cancelled command is interrupted rather than
+// killed, since killing the engine's client leaves the container running.
+func Isolate(cmd *exec.Cmd, image string, mounts ...string) error {
+	engine, err := WorkspaceIsolation()
+	if err != nil || engine == "" {
+		return err
+	}
+	if cmd.Dir == "" {
+		return fmt.Errorf("cannot isolate %s without a working directory", filepath.Base(cmd.Args[0]))
+	}
+	enginePath, err := exec.LookPath(engine)
+	if err != nil {
+		return fmt.Errorf("workspace isolation needs %s: %v", engine, err)
+	}
+	dir, err := filepath.Abs(cmd.Dir)
+	if err != nil {
+		return err
+	}
+
+	args := []string{engine, "run", "--rm"}
+	args = append(args, ContainerIsolationArgs(engine)...)
+	args = append(args, "-v", dir+":"+dir, "-w", dir)
+	for _, mount := range mounts {
+		args = append(args, "-v", mount+":"+mount)
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	seen := make(map[string]bool)
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if !seen[name] && isolatedEnv(name) {
+			seen[name] = true
+			args = append(args, "-e", name)
+		}
+	}
+	// Tool images like hashicorp/terraform have the tool as entrypoint.
+	args = append(args, "--entrypoint", filepath.Base(cmd.Args[0]), image)
+	args = append(args, cmd.Args[1:]...)
+
+	cmd.Path = enginePath
+	cmd.Args = args
+	cmd.Env = env
+	cmd.Err = nil
+	if cmd.Cancel != nil {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+	}
+	return nil
+}
+
+func isolatedEnv(name string) bool {
+	for _, prefix := range isolatedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isolate isolates a terraform command in its image, with the provider
+// plugin cache mounted.
+func isolate(cmd *exec.Cmd) error {
+	return Isolate(cmd, TerraformImage(), pluginCacheMounts()...)
+}
+
+// pluginCachePaths are the directories and files of the plugin cache,
+// mounted into isolated terraform commands. It is kept apart from
+// pluginCache, which is held while terraform init runs.
+var pluginCachePaths struct {
+	sync.Mutex
+	paths []string
+}
+
+func pluginCacheMounts() []string {
+	pluginCachePaths.Lock()
+	defer pluginCachePaths.Unlock()
+	return pluginCachePaths.paths
+}
@@ -0,0 +1,219 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+)
+
+// LinodeProvider provisions a Linode instance behind a cloud firewall. Name
+// is the instance's label and prefixes the other resources it creates.
+type LinodeProvider struct {
+	terraformRunner
+	Name   string
+	Region string
+	Type   string
+}
+
+// linodeRegions are the regions this tool deploys to.
+var linodeRegions = map[string]bool{
+	"us-east":      true,
+	"us-central":   true,
+	"us-west":      true,
+	"us-southeast": true,
+	"ca-central":   true,
+	"eu-west":      true,
+	"eu-central":   true,
+	"ap-south":     true,
+	"ap-northeast": true,
+	"ap-west":      true,
+	"ap-southeast": true,
+}
+
+// linodeTypes are the instance types this tool deploys.
+var linodeTypes = map[string]bool{
+	"g6-nanode-1":    true,
+	"g6-standard-1":  true,
+	"g6-standard-2":  true,
+	"g6-standard-4":  true,
+	"g6-standard-6":  true,
+	"g6-dedicated-2": true,
+	"g6-dedicated-4": true,
+}
+
+// IsKnownLinodeRegion reports whether region is a Linode region this tool
+// deploys to.
+func IsKnownLinodeRegion(region string) bool {
+	return linodeRegions[region]
+}
+
+// IsKnownLinodeType reports whether linodeType is a Linode instance type
+// this tool deploys.
+func IsKnownLinodeType(linodeType string) bool {
+	return linodeTypes[linodeType]
+}
+
+// The instance is built from the plain image with no StackScript; cloud-init
+// user data creates azureuser, which the generated playbook deploys as, and
+// turns off password and root logins. No root_pass is set, so Linode
+// generates one that is never used.
+const linodeTfTemplate = `
+terraform {
+  required_providers {
+    linode = {
+      source  = "linode/linode"
+      version = "~> 2.0"
+    }
+  }
+}
+
+# The API token is read from LINODE_TOKEN
+provider "linode" {}
+
+# Local file resources for SSH keys
+resource "local_file" "private_key" {
+  content         = var.private_key_content
+  filename        = "${path.module}/azure_vm_key"
+  file_permission = "0600"
+}
+
+resource "local_file" "public_key" {
+  content         = var.public_key_content
+  filename        = "${path.module}/azure_vm_key.pub"
+  file_permission = "0644"
+}
+
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+resource "linode_instance" "main" {
+  label           = "{{ .Name }}"
+  region          = "{{ .Region }}"
+  type            = "{{ .Type }}"
+  image           = "linode/ubuntu22.04"
+  authorized_keys = [trimspace(var.public_key_content)]
+  booted          = true
+
+  metadata {
+    user_data = base64encode(<<-EOT
+      #cloud-config
+      ssh_pwauth: false
+      disable_root: true
+      users:
+        - default
+        - name: azureuser
+          shell: /bin/bash
+          sudo: ALL=(ALL) NOPASSWD:ALL
+          ssh_authorized_keys:
+            - ${trimspace(var.public_key_content)}
+    EOT
+    )
+  }
+
+  tags = ["django-vpc"]
+
+  # Ensure SSH keys are created before the instance
+  depends_on = [local_file.private_key, local_file.public_key]
+}
+
+resource "linode_firewall" "main" {
+  label           = "{{ .Name }}-fw"
+  inbound_policy  = "DROP"
+  outbound_policy = "ACCEPT"
+  linodes         = [linode_instance.main.id]
+
+  # SSH access
+  inbound {
+    label    = "ssh"
+    action   = "ACCEPT"
+    protocol = "TCP"
+    ports    = "22"
+    ipv4     = ["0.0.0.0/0"] # Consider restricting to your IP range
+    ipv6     = ["::/0"]
+  }
+
+  # HTTP access
+  inbound {
+    label    = "http"
+    action   = "ACCEPT"
+    protocol = "TCP"
+    ports    = "80"
+    ipv4     = ["0.0.0.0/0"]
+    ipv6     = ["::/0"]
+  }
+
+  # HTTPS access
+  inbound {
+    label    = "https"
+    action   = "ACCEPT"
+    protocol = "TCP"
+    ports    = "443"
+    ipv4     = ["0.0.0.0/0"]
+    ipv6     = ["::/0"]
+  }
+
+  # Custom application port
+  inbound {
+    label    = "app"
+    action   = "ACCEPT"
+    protocol = "TCP"
+    ports    = "8000"
+    ipv4     = ["0.0.0.0/0"]
+    ipv6     = ["::/0"]
+  }
+}
+
+# Outputs
+output "public_ip" {
+  value = linode_instance.main.ip_address
+}
+
+output "vm_name" {
+  value = linode_instance.main.label
+}
+
+output "linode_id" {
+  value = linode_instance.main.id
+}
+
+output "ssh_connection_command" {
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${linode_instance.main.ip_address}"
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars. The API
+// token comes from LINODE_TOKEN, which Terraform reads itself.
+func (l *LinodeProvider) GenerateTerraformConfig(path string) error {
+	l.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	if os.Getenv("LINODE_TOKEN") == "" {
+		l.broadcastLog("error", "LINODE_TOKEN environment variable is not set", "terraform")
+		return fmt.Errorf("LINODE_TOKEN environment variable is not set")
+	}
+
+	publicKeyContent, privateKeyContent, err := l.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := l.writeMainTF(path, linodeTfTemplate, l); err != nil {
+		return err
+	}
+	if err := l.writeTFVars(path, publicKeyContent, privateKeyContent, ""); err != nil {
+		return err
+	}
+
+	l.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (l *LinodeProvider) WriteInventory(path, ip string) error {
+	return l.writeInventory(path, "linode", ip)
+}
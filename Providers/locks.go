@@ -0,0 +1,96 @@
+package providers
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type resourceGroupLock struct {
+	sem      chan struct{}
+	holder   string
+	since    time.Time
+	refCount int
+}
+
+// ResourceGroupLockInfo describes a held lock for admin views.
+type ResourceGroupLockInfo struct {
+	ResourceGroup string    `json:"resource_group"`
+	Holder        string    `json:"holder"`
+	Since         time.Time `json:"since"`
+	Waiters       int       `json:"waiters"`
+}
+
+var (
+	resourceGroupLocks   = make(map[string]*resourceGroupLock)
+	resourceGroupLockMux sync.Mutex
+)
+
+// LockResourceGroup serializes Terraform operations on a resource group so
+// deploy, destroy and cleanup runs never race on the same state. onWait is
+// called with the current holder if the lock is busy. The returned function
+// releases the lock.
+func LockResourceGroup(resourceGroup, holder string, onWait func(holder string)) func() {
+	resourceGroupLockMux.Lock()
+	lock, exists := resourceGroupLocks[resourceGroup]
+	if !exists {
+		lock = &resourceGroupLock{sem: make(chan struct{}, 1)}
+		resourceGroupLocks[resourceGroup] = lock
+	}
+	lock.refCount++
+	currentHolder := lock.holder
+	resourceGroupLockMux.Unlock()
+
+	select {
+	case lock.sem <- struct{}{}:
+	default:
+		if onWait != nil {
+			onWait(currentHolder)
+		}
+		lock.sem <- struct{}{}
+	}
+
+	resourceGroupLockMux.Lock()
+	lock.holder = holder
+	lock.since = time.Now()
+	resourceGroupLockMux.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			resourceGroupLockMux.Lock()
+			defer resourceGroupLockMux.Unlock()
+
+			lock.holder = ""
+			lock.refCount--
+			if lock.refCount == 0 {
+				delete(resourceGroupLocks, resourceGroup)
+			}
+			<-lock.sem
+		})
+	}
+}
+
+// HeldResourceGroupLocks lists the locks that are currently held.
+func HeldResourceGroupLocks() []ResourceGroupLockInfo {
+	resourceGroupLockMux.Lock()
+	defer resourceGroupLockMux.Unlock()
+
+	var held []ResourceGroupLockInfo
+	for resourceGroup, lock := range resourceGroupLocks {
+		if lock.holder == "" {
+			continue
+		}
+		held = append(held, ResourceGroupLockInfo{
+			ResourceGroup: resourceGroup,
+			Holder:        lock.holder,
+			Since:         lock.since,
+			Waiters:       lock.refCount - 1,
+		})
+	}
+
+	sort.Slice(held, func(i, j int) bool {
+		return held[i].ResourceGroup < held[j].ResourceGroup
+	})
+	return held
+}
@@ -0,0 +1,325 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OCIProvider provisions an Oracle Cloud instance in its own VCN. Name is
+// the instance's display name and prefixes the other resources it creates.
+// The default shape, VM.Standard.A1.Flex at 2 OCPUs and 12 GB, fits in the
+// Always Free allowance.
+type OCIProvider struct {
+	terraformRunner
+	Name          string
+	Region        string
+	Shape         string
+	CompartmentID string
+	// BootVolumeSizeGB is the boot volume size, or DefaultOCIBootVolumeSizeGB
+	// if zero.
+	BootVolumeSizeGB int
+}
+
+// DefaultOCIBootVolumeSizeGB is the smallest boot volume OCI allows.
+const DefaultOCIBootVolumeSizeGB = 50
+
+// BootVolumeGB returns the boot volume size the instance is provisioned
+// with.
+func (o *OCIProvider) BootVolumeGB() int {
+	if o.BootVolumeSizeGB > 0 {
+		return o.BootVolumeSizeGB
+	}
+	return DefaultOCIBootVolumeSizeGB
+}
+
+// ociRegions are the regions this tool deploys to.
+var ociRegions = map[string]bool{
+	"us-ashburn-1":   true,
+	"us-phoenix-1":   true,
+	"us-sanjose-1":   true,
+	"ca-toronto-1":   true,
+	"sa-saopaulo-1":  true,
+	"uk-london-1":    true,
+	"eu-frankfurt-1": true,
+	"eu-amsterdam-1": true,
+	"ap-mumbai-1":    true,
+	"ap-tokyo-1":     true,
+	"ap-sydney-1":    true,
+}
+
+// ociShapes are the Always Free shapes this tool deploys, and whether each
+// is arm64.
+var ociShapes = map[string]bool{
+	"VM.Standard.A1.Flex":    true,
+	"VM.Standard.E2.1.Micro": false,
+}
+
+// IsKnownOCIRegion reports whether region is an Oracle Cloud region this
+// tool deploys to.
+func IsKnownOCIRegion(region string) bool {
+	return ociRegions[region]
+}
+
+// IsKnownOCIShape reports whether shape is an Oracle Cloud shape this tool
+// deploys.
+func IsKnownOCIShape(shape string) bool {
+	_, known := ociShapes[shape]
+	return known
+}
+
+// IsARM64OCIShape reports whether shape is an Ampere (arm64) shape.
+func IsARM64OCIShape(shape string) bool {
+	return ociShapes[shape]
+}
+
+// Flex reports whether the shape takes an OCPU and memory configuration.
+func (o *OCIProvider) Flex() bool {
+	return strings.HasSuffix(o.Shape, ".Flex")
+}
+
+// The generated playbook deploys as azureuser, so cloud-init creates that
+// account next to the image's ubuntu user. Oracle's Ubuntu images ship
+// iptables rules that reject everything but SSH, so cloud-init also opens
+// the web ports the security list allows.
+const ociTfTemplate = `
+terraform {
+  required_providers {
+    oci = {
+      source  = "oracle/oci"
+      version = "~> 5.0"
+    }
+  }
+}
+
+# API key credentials are read from ~/.oci/config or the provider's
+# environment variables
+provider "oci" {
+  region = "{{ .Region }}"
+}
+
+# Local file resources for SSH keys
+resource "local_file" "private_key" {
+  content         = var.private_key_content
+  filename        = "${path.module}/azure_vm_key"
+  file_permission = "0600"
+}
+
+resource "local_file" "public_key" {
+  content         = var.public_key_content
+  filename        = "${path.module}/azure_vm_key.pub"
+  file_permission = "0644"
+}
+
+variable "private_key_content" {
+  description = "Private SSH key content"
+  type        = string
+  sensitive   = true
+}
+
+variable "public_key_content" {
+  description = "Public SSH key content"
+  type        = string
+}
+
+locals {
+  compartment_id = "{{ .CompartmentID }}"
+}
+
+data "oci_identity_availability_domains" "available" {
+  compartment_id = local.compartment_id
+}
+
+data "oci_core_images" "ubuntu" {
+  compartment_id           = local.compartment_id
+  operating_system         = "Canonical Ubuntu"
+  operating_system_version = "22.04"
+  shape                    = "{{ .Shape }}"
+  sort_by                  = "TIMECREATED"
+  sort_order               = "DESC"
+}
+
+resource "oci_core_vcn" "main" {
+  compartment_id = local.compartment_id
+  cidr_blocks    = ["10.0.0.0/16"]
+  display_name   = "{{ .Name }}-vcn"
+}
+
+resource "oci_core_internet_gateway" "main" {
+  compartment_id = local.compartment_id
+  vcn_id         = oci_core_vcn.main.id
+  display_name   = "{{ .Name }}-igw"
+}
+
+resource "oci_core_route_table" "main" {
+  compartment_id = local.compartment_id
+  vcn_id         = oci_core_vcn.main.id
+  display_name   = "{{ .Name }}-rt"
+
+  route_rules {
+    destination       = "0.0.0.0/0"
+    destination_type  = "CIDR_BLOCK"
+    network_entity_id = oci_core_internet_gateway.main.id
+  }
+}
+
+resource "oci_core_security_list" "main" {
+  compartment_id = local.compartment_id
+  vcn_id         = oci_core_vcn.main.id
+  display_name   = "{{ .Name }}-sl"
+
+  egress_security_rules {
+    destination = "0.0.0.0/0"
+    protocol    = "all"
+  }
+
+  # SSH access
+  ingress_security_rules {
+    source   = "0.0.0.0/0" # Consider restricting to your IP range
+    protocol = "6"
+    tcp_options {
+      min = 22
+      max = 22
+    }
+  }
+
+  # HTTP access
+  ingress_security_rules {
+    source   = "0.0.0.0/0"
+    protocol = "6"
+    tcp_options {
+      min = 80
+      max = 80
+    }
+  }
+
+  # HTTPS access
+  ingress_security_rules {
+    source   = "0.0.0.0/0"
+    protocol = "6"
+    tcp_options {
+      min = 443
+      max = 443
+    }
+  }
+
+  # Custom application port
+  ingress_security_rules {
+    source   = "0.0.0.0/0"
+    protocol = "6"
+    tcp_options {
+      min = 8000
+      max = 8000
+    }
+  }
+}
+
+resource "oci_core_subnet" "main" {
+  compartment_id    = local.compartment_id
+  vcn_id            = oci_core_vcn.main.id
+  cidr_block        = "10.0.2.0/24"
+  display_name      = "{{ .Name }}-subnet"
+  route_table_id    = oci_core_route_table.main.id
+  security_list_ids = [oci_core_security_list.main.id]
+}
+
+resource "oci_core_instance" "main" {
+  compartment_id      = local.compartment_id
+  availability_domain = data.oci_identity_availability_domains.available.availability_domains[0].name
+  display_name        = "{{ .Name }}"
+  shape               = "{{ .Shape }}"
+{{ if .Flex }}
+  # Half of the Always Free A1 allowance, so a second deployment still fits
+  shape_config {
+    ocpus         = 2
+    memory_in_gbs = 12
+  }
+{{ end }}
+  source_details {
+    source_type             = "image"
+    source_id               = data.oci_core_images.ubuntu.images[0].id
+    boot_volume_size_in_gbs = {{ .BootVolumeGB }}
+  }
+
+  create_vnic_details {
+    subnet_id        = oci_core_subnet.main.id
+    assign_public_ip = true
+  }
+
+  metadata = {
+    ssh_authorized_keys = trimspace(var.public_key_content)
+    user_data = base64encode(<<-EOT
+      #cloud-config
+      users:
+        - default
+        - name: azureuser
+          shell: /bin/bash
+          sudo: ALL=(ALL) NOPASSWD:ALL
+          ssh_authorized_keys:
+            - ${trimspace(var.public_key_content)}
+      runcmd:
+        - iptables -I INPUT 6 -p tcp -m state --state NEW -m multiport --dports 80,443,8000 -j ACCEPT
+        - netfilter-persistent save
+    EOT
+    )
+  }
+
+  freeform_tags = {
+    Security = "SSH-Keys-Only"
+  }
+
+  # Ensure SSH keys are created before the instance
+  depends_on = [local_file.private_key, local_file.public_key]
+}
+
+# Outputs
+output "public_ip" {
+  value = oci_core_instance.main.public_ip
+}
+
+output "vm_name" {
+  value = oci_core_instance.main.display_name
+}
+
+output "instance_id" {
+  value = oci_core_instance.main.id
+}
+
+output "ssh_connection_command" {
+  value = "ssh -i ${path.cwd}/azure_vm_key azureuser@${oci_core_instance.main.public_ip}"
+}
+`
+
+// GenerateTerraformConfig writes main.tf and terraform.tfvars. Resources go
+// into CompartmentID, or OCI_COMPARTMENT_ID if it is not set; credentials
+// are left to the OCI provider's own configuration.
+func (o *OCIProvider) GenerateTerraformConfig(path string) error {
+	o.broadcastLog("info", "Generating Terraform configuration...", "terraform")
+
+	if o.CompartmentID == "" {
+		o.CompartmentID = os.Getenv("OCI_COMPARTMENT_ID")
+	}
+	if o.CompartmentID == "" {
+		o.broadcastLog("error", "OCI_COMPARTMENT_ID environment variable is not set", "terraform")
+		return fmt.Errorf("OCI_COMPARTMENT_ID environment variable is not set")
+	}
+
+	publicKeyContent, privateKeyContent, err := o.GenerateSSHKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err := o.writeMainTF(path, ociTfTemplate, o); err != nil {
+		return err
+	}
+	if err := o.writeTFVars(path, publicKeyContent, privateKeyContent, ""); err != nil {
+		return err
+	}
+
+	o.broadcastLog("success", "Terraform configuration generated successfully", "terraform")
+	return nil
+}
+
+func (o *OCIProvider) WriteInventory(path, ip string) error {
+	return o.writeInventory(path, "oci", ip)
+}
@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pluginCache is the provider plugin cache shared by every terraform init,
+// so each provider version is downloaded once instead of once per
+// deployment. Terraform does not make installing into the cache safe for
+// concurrent inits, so inits take turns while it is configured.
+var pluginCache struct {
+	sync.Mutex
+	env []string
+}
+
+// ConfigurePluginCache makes terraform init install providers through the
+// cache in dir. mirror, if not empty, is a directory laid out by
+// `terraform providers mirror`; providers found there are not downloaded
+// at all, and the newest version of any provider is still fetched from
+// the registry.
+func ConfigurePluginCache(dir, mirror string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create plugin cache directory: %v", err)
+	}
+	// Work directories start without a dependency lock file, and since
+	// Terraform 1.4 the cache is skipped for providers the lock file does
+	// not yet vouch for unless this is set.
+	env := []string{"TF_PLUGIN_CACHE_DIR=" + dir, "TF_PLUGIN_CACHE_MAY_BREAK_DEPENDENCY_LOCK_FILE=true"}
+	mounts := []string{dir}
+
+	if mirror != "" {
+		mirror, err = filepath.Abs(mirror)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(mirror); err != nil || !info.IsDir() {
+			return fmt.Errorf("provider mirror %s is not a directory", mirror)
+		}
+		config := fmt.Sprintf(`plugin_cache_dir = %q
+plugin_cache_may_break_dependency_lock_file = true
+
+provider_installation {
+  filesystem_mirror {
+    path = %q
+  }
+  direct {}
+}
+`, dir, mirror)
+		configPath := dir + ".tfrc"
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			return fmt.Errorf("failed to write Terraform CLI configuration: %v", err)
+		}
+		env = append(env, "TF_CLI_CONFIG_FILE="+configPath)
+		mounts = append(mounts, configPath, mirror)
+	}
+
+	pluginCache.Lock()
+	pluginCache.env = env
+	pluginCache.Unlock()
+	pluginCachePaths.Lock()
+	pluginCachePaths.paths = mounts
+	pluginCachePaths.Unlock()
+	return nil
+}
+
+// lockPluginCache waits for the cache and returns the environment of a
+// terraform init, base or the server's if nil, and the function releasing
+// the cache. Without a cache it neither waits nor changes the environment.
+func lockPluginCache(base []string) ([]string, func()) {
+	pluginCache.Lock()
+	if pluginCache.env == nil {
+		pluginCache.Unlock()
+		return base, func() {}
+	}
+	if base == nil {
+		base = os.Environ()
+	}
+	env := append(append([]string(nil), base...), pluginCache.env...)
+	return env, pluginCache.Unlock
+}
@@ -0,0 +1,338 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Tools"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// terraformRunner implements the CloudProvider methods that are the same on
+// every cloud: key generation, running the Terraform CLI and reading its
+// outputs. Providers embed it and add their configuration and inventory.
+type terraformRunner struct {
+	broadcaster  types.LogBroadcaster
+	deploymentID string
+	// env is the environment of Terraform commands that configure
+	// providers, or the server's if nil.
+	env []string
+	ctx context.Context
+}
+
+func (t *terraformRunner) SetLogger(broadcaster types.LogBroadcaster, deploymentID string) {
+	t.broadcaster = broadcaster
+	t.deploymentID = deploymentID
+}
+
+func (t *terraformRunner) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
+func (t *terraformRunner) broadcastLog(level, message, step string) {
+	logMsg := types.LogMessage{
+		Level:     level,
+		Message:   message,
+		Step:      step,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	printLog(logMsg)
+
+	if t.broadcaster != nil {
+		t.broadcaster.BroadcastLog(t.deploymentID, logMsg)
+	}
+}
+
+// GenerateSSHKeys writes the key pair under AzureProvider's file names,
+// which the Ansible runner and GitHub Actions setup look for.
+func (t *terraformRunner) GenerateSSHKeys(path string) (string, string, error) {
+	t.broadcastLog("info", "Generating SSH key pair...", "ssh")
+	publicKeyContent, privateKeyContent, err := generateSSHKeyPair()
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to generate SSH keys: %v", err), "ssh")
+		return "", "", fmt.Errorf("failed to generate SSH keys: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, "azure_vm_key.pub"), []byte(publicKeyContent), 0644); err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to write public key: %v", err), "ssh")
+		return "", "", fmt.Errorf("failed to write public key: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, "azure_vm_key"), []byte(privateKeyContent), 0600); err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to write private key: %v", err), "ssh")
+		return "", "", fmt.Errorf("failed to write private key: %v", err)
+	}
+
+	t.broadcastLog("success", fmt.Sprintf("SSH keys generated successfully at %s", path), "ssh")
+	return publicKeyContent, privateKeyContent, nil
+}
+
+// writeMainTF renders the Terraform template text with data into main.tf.
+func (t *terraformRunner) writeMainTF(path, text string, data interface{}) error {
+	file, err := os.Create(filepath.Join(path, "main.tf"))
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to create main.tf: %v", err), "terraform")
+		return err
+	}
+	defer file.Close()
+
+	tmpl, err := template.New("main.tf").Parse(text)
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to parse Terraform template: %v", err), "terraform")
+		return err
+	}
+
+	if err := tmpl.Execute(file, data); err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to execute Terraform template: %v", err), "terraform")
+		return err
+	}
+	return nil
+}
+
+// writeTFVars writes the SSH key variables every template declares, plus
+// any provider-specific lines in extra.
+func (t *terraformRunner) writeTFVars(path, publicKeyContent, privateKeyContent, extra string) error {
+	content := fmt.Sprintf(`private_key_content = %q
+public_key_content = %q
+`, privateKeyContent, publicKeyContent) + extra
+
+	if err := os.WriteFile(filepath.Join(path, "terraform.tfvars"), []byte(content), 0600); err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to write terraform.tfvars: %v", err), "terraform")
+		return fmt.Errorf("failed to write terraform.tfvars: %v", err)
+	}
+	return nil
+}
+
+// writeInventory writes an Ansible inventory with ip in group, connecting
+// as the azureuser account every provider's VM is set up with.
+func (t *terraformRunner) writeInventory(path, group, ip string) error {
+	t.broadcastLog("info", "Writing Ansible inventory file...", "ansible")
+	privateKeyPath := filepath.Join(path, "azure_vm_key")
+	content := fmt.Sprintf(`[%s]
+%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='-o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s -o HashKnownHosts=no'
+`, group, ip, privateKeyPath, filepath.Join(path, "known_hosts"))
+
+	if err := os.WriteFile(filepath.Join(path, "inventory.ini"), []byte(content), 0644); err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to write inventory file: %v", err), "ansible")
+		return err
+	}
+
+	t.broadcastLog("success", "Ansible inventory file created successfully", "ansible")
+	return nil
+}
+
+func (t *terraformRunner) InitTerraform(path string) error {
+	t.broadcastLog("info", "Initializing Terraform...", "terraform")
+	cmd := terraformCommand(t.ctx, "init", "-no-color", "-input=false")
+	cmd.Dir = path
+	env, unlock := lockPluginCache(t.env)
+	cmd.Env = env
+
+	output, err := streamTerraform(cmd, TerraformPhaseInit, t.broadcaster, t.deploymentID)
+	unlock()
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Terraform init failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformInit, err, output, "terraform init failed")
+	}
+
+	t.broadcastLog("success", "Terraform initialized successfully", "terraform")
+	return nil
+}
+
+func (t *terraformRunner) ApplyTerraform(path string) error {
+	t.broadcastLog("info", "Applying Terraform configuration (this may take a few minutes)...", "terraform")
+	cmd := terraformCommand(t.ctx, "apply", "-auto-approve", "-input=false", "-no-color")
+	cmd.Dir = path
+	cmd.Env = t.env
+
+	output, err := streamTerraform(cmd, TerraformPhaseApply, t.broadcaster, t.deploymentID)
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Terraform apply failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, output, "terraform apply failed")
+	}
+
+	t.broadcastLog("success", "Infrastructure deployment completed successfully", "terraform")
+	return nil
+}
+
+// PlanTerraform writes a saved plan to tfplan and returns the rendered plan
+// so it can be reviewed before ApplyTerraformPlan runs it.
+func (t *terraformRunner) PlanTerraform(path string) (string, error) {
+	t.broadcastLog("info", "Planning Terraform changes...", "terraform")
+	cmd := terraformCommand(t.ctx, "plan", "-out=tfplan", "-no-color", "-input=false")
+	cmd.Dir = path
+	cmd.Env = t.env
+
+	output, err := streamTerraform(cmd, TerraformPhasePlan, t.broadcaster, t.deploymentID)
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Terraform plan failed: %v", err), "terraform")
+		return "", terraformError(types.ErrCodeTerraformPlan, err, output, "terraform plan failed")
+	}
+
+	t.broadcastLog("success", "Terraform plan created successfully", "terraform")
+	return string(output), nil
+}
+
+// ApplyTerraformPlan applies the plan saved by PlanTerraform.
+func (t *terraformRunner) ApplyTerraformPlan(path string) error {
+	t.broadcastLog("info", "Applying approved Terraform plan...", "terraform")
+	cmd := terraformCommand(t.ctx, "apply", "-auto-approve", "-input=false", "-no-color", "tfplan")
+	cmd.Dir = path
+	cmd.Env = t.env
+
+	output, err := streamTerraform(cmd, TerraformPhaseApply, t.broadcaster, t.deploymentID)
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Terraform apply failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformApply, err, output, "terraform apply failed")
+	}
+
+	return nil
+}
+
+func (t *terraformRunner) GetOutput(path, key string) (string, error) {
+	t.broadcastLog("info", fmt.Sprintf("Getting Terraform output for key: %s", key), "terraform")
+	cmd := terraformCommand(t.ctx, "output", "-raw", key)
+	cmd.Dir = path
+	if err := isolate(cmd); err != nil {
+		return "", err
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Failed to get Terraform output %s: %v", key, err), "terraform")
+		return "", err
+	}
+
+	value := strings.TrimSpace(string(output))
+	t.broadcastLog("success", fmt.Sprintf("Retrieved %s: %s", key, value), "terraform")
+	return value, nil
+}
+
+// Destroy deletes every resource created from the configuration in path.
+func (t *terraformRunner) Destroy(path string) error {
+	t.broadcastLog("info", "Destroying Terraform resources (this may take a few minutes)...", "terraform")
+	cmd := terraformCommand(t.ctx, "destroy", "-auto-approve", "-input=false", "-no-color")
+	cmd.Dir = path
+	cmd.Env = t.env
+
+	output, err := streamTerraform(cmd, TerraformPhaseDestroy, t.broadcaster, t.deploymentID)
+	if err != nil {
+		t.broadcastLog("error", fmt.Sprintf("Terraform destroy failed: %v", err), "terraform")
+		return terraformError(types.ErrCodeTerraformDestroy, err, output, "terraform destroy failed")
+	}
+
+	t.broadcastLog("success", "Terraform resources destroyed", "terraform")
+	return nil
+}
+
+// terraformCancelGrace is how long an interrupted Terraform command has to
+// stop, saving the state of what it already created, before it is killed.
+const terraformCancelGrace = 2 * time.Minute
+
+// terraformCommand returns a terraform command that is interrupted when ctx
+// is cancelled, rather than killed, so the state stays usable to destroy
+// the partially created resources. A nil ctx is never cancelled.
+func terraformCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return tools.InterruptibleCommand(ctx, terraformCancelGrace, "terraform", args...)
+}
+
+// Terraform phases, reported as the "phase" data of streamed output lines.
+const (
+	TerraformPhaseInit    = "init"
+	TerraformPhasePlan    = "plan"
+	TerraformPhaseApply   = "apply"
+	TerraformPhaseDestroy = "destroy"
+)
+
+// maxTerraformLine bounds a line of Terraform output; plans of large
+// resources can render long lines.
+const maxTerraformLine = 1024 * 1024
+
+// streamTerraform runs a Terraform command, broadcasting each line of its
+// output under the "terraform" step as soon as it is written, so progress
+// like "Still creating... [1m0s elapsed]" reaches clients while the command
+// runs. It returns the combined output for error classification.
+func streamTerraform(cmd *exec.Cmd, phase string, broadcaster types.LogBroadcaster, deploymentID string) ([]byte, error) {
+	if err := isolate(cmd); err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var (
+		output bytes.Buffer
+		mux    sync.Mutex
+		wg     sync.WaitGroup
+	)
+	scan := func(r io.Reader, stream string) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxTerraformLine)
+		for scanner.Scan() {
+			line := scanner.Text()
+
+			mux.Lock()
+			output.WriteString(line)
+			output.WriteByte('\n')
+			mux.Unlock()
+
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			logMsg := types.LogMessage{
+				Level:     terraformLineLevel(line, stream),
+				Message:   line,
+				Step:      "terraform",
+				Timestamp: time.Now().Format(time.RFC3339),
+				Data:      map[string]interface{}{"phase": phase, "stream": stream},
+			}
+			printLog(logMsg)
+			if broadcaster != nil {
+				broadcaster.BroadcastLog(deploymentID, logMsg)
+			}
+		}
+	}
+	wg.Add(2)
+	go scan(stdout, "stdout")
+	go scan(stderr, "stderr")
+	wg.Wait()
+
+	err = cmd.Wait()
+	return output.Bytes(), err
+}
+
+// terraformLineLevel picks the log level of a line of -no-color output.
+func terraformLineLevel(line, stream string) string {
+	trimmed := strings.TrimLeft(line, " │╷╵")
+	switch {
+	case strings.HasPrefix(trimmed, "Error:"):
+		return "error"
+	case strings.HasPrefix(trimmed, "Warning:") || stream == "stderr":
+		return "warn"
+	case strings.Contains(line, "Creation complete") || strings.Contains(line, "Modifications complete") ||
+		strings.Contains(line, "Destruction complete") || strings.HasPrefix(trimmed, "Apply complete!") ||
+		strings.HasPrefix(trimmed, "Destroy complete!") || strings.HasPrefix(trimmed, "Terraform has been successfully initialized!"):
+		return "success"
+	}
+	return "info"
+}
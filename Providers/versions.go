@@ -0,0 +1,69 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// Versions the Azure configurations pin. A deployment keeps planning
+// against the same azurerm release until these are bumped, and bumping them
+// changes TemplateVersion so existing deployments show up as upgradable.
+const (
+	TerraformRequiredVersion = ">= 1.5.0"
+	AzureRMVersion           = "3.116.0"
+	LocalProviderVersion     = "2.5.2"
+	// PulumiAzureNativeVersion is the azure-native plugin the Pulumi
+	// backend installs, the version of the pulumi-azure-native-sdk modules
+	// its program is written with.
+	PulumiAzureNativeVersion = "2.90.0"
+)
+
+// TerraformVersions identifies the configuration a deployment was
+// provisioned with.
+type TerraformVersions struct {
+	// Template is the first 12 hex digits of the SHA-256 of the template
+	// the configuration was rendered from.
+	Template string `json:"template"`
+	// Providers maps each provider source to its version constraint.
+	Providers map[string]string `json:"providers,omitempty"`
+}
+
+// templated is implemented by providers that render a Terraform template.
+type templated interface {
+	terraformTemplate() string
+}
+
+func (a *AzureProvider) terraformTemplate() string        { return azureTfTemplate }
+func (k *AKSProvider) terraformTemplate() string          { return aksTfTemplate }
+func (a *AWSProvider) terraformTemplate() string          { return awsTfTemplate }
+func (d *DigitalOceanProvider) terraformTemplate() string { return digitalOceanTfTemplate }
+func (h *HetznerProvider) terraformTemplate() string      { return hetznerTfTemplate }
+func (l *LinodeProvider) terraformTemplate() string       { return linodeTfTemplate }
+func (o *OCIProvider) terraformTemplate() string          { return ociTfTemplate }
+
+var requiredProviderPattern = regexp.MustCompile(`source\s*=\s*"([^"]+)"\s*\n\s*version\s*=\s*"([^"]+)"`)
+
+// ConfigVersions returns the versions of the configuration cloud generates,
+// or false if it generates none, like an existing server or an Azure VM
+// provisioned by another backend than Terraform.
+func ConfigVersions(cloud CloudProvider) (TerraformVersions, bool) {
+	if azure, ok := cloud.(*AzureProvider); ok && !azure.UsesTerraform() {
+		return TerraformVersions{}, false
+	}
+	provider, ok := cloud.(templated)
+	if !ok {
+		return TerraformVersions{}, false
+	}
+	text := provider.terraformTemplate()
+	sum := sha256.Sum256([]byte(text))
+
+	versions := TerraformVersions{
+		Template:  hex.EncodeToString(sum[:])[:12],
+		Providers: make(map[string]string),
+	}
+	for _, match := range requiredProviderPattern.FindAllStringSubmatch(text, -1) {
+		versions.Providers[match[1]] = match[2]
+	}
+	return versions, true
+}
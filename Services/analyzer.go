@@ -0,0 +1,332 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+)
+
+const (
+	analyzerMaxFileSize    = 1 << 20
+	analyzerMaxArchiveSize = 200 << 20
+
+	analyzerDownloadTimeout = 5 * time.Minute
+)
+
+// AnalysisFinding is one problem the analyzer found in a repository.
+type AnalysisFinding struct {
+	Code    string `json:"code"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// AnalysisReport is the result of statically inspecting a repository.
+// Blockers will make the deployment fail; warnings might.
+type AnalysisReport struct {
+	ProjectPath    string `json:"project_path,omitempty"`
+	SettingsModule string `json:"settings_module,omitempty"`
+	ServerModule   string `json:"server_module,omitempty"`
+	// MissingEnv are the variables read without a default that the
+	// request does not provide.
+	MissingEnv []string          `json:"missing_env,omitempty"`
+	Blockers   []AnalysisFinding `json:"blockers"`
+	Warnings   []AnalysisFinding `json:"warnings"`
+}
+
+// Ready reports whether the analysis found no blockers.
+func (r *AnalysisReport) Ready() bool {
+	return len(r.Blockers) == 0
+}
+
+func (r *AnalysisReport) block(code, file string, line int, message string) {
+	r.Blockers = append(r.Blockers, AnalysisFinding{Code: code, File: file, Line: line, Message: message})
+}
+
+func (r *AnalysisReport) warn(code, file string, line int, message string) {
+	r.Warnings = append(r.Warnings, AnalysisFinding{Code: code, File: file, Line: line, Message: message})
+}
+
+// AnalyzeRepository downloads the repository's default branch through the
+// GitHub API and inspects it for deployment blockers.
+func (ds *DeploymentService) AnalyzeRepository(req *DeploymentRequest) (*AnalysisReport, error) {
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %v", err)
+	}
+
+	link, _, err := githubAPI(req.GithubToken).Repositories.GetArchiveLink(githubContext(), owner, repo, github.Tarball, nil, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository archive: %v", githubError(err))
+	}
+
+	client := &http.Client{Timeout: analyzerDownloadTimeout}
+	resp, err := client.Get(link.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to download repository archive: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download repository archive: %s", resp.Status)
+	}
+
+	files, err := readPythonFiles(io.LimitReader(resp.Body, analyzerMaxArchiveSize))
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzeProject(files, providedEnv(req), req.ASGI), nil
+}
+
+// readPythonFiles returns the .py files of a GitHub tarball keyed by their
+// path inside the repository.
+func readPythonFiles(r io.Reader) (map[string]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository archive: %v", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read repository archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > analyzerMaxFileSize || !strings.HasSuffix(header.Name, ".py") {
+			continue
+		}
+
+		// GitHub prefixes every entry with an owner-repo-sha/ directory.
+		_, name, found := strings.Cut(header.Name, "/")
+		if !found || skipAnalysisPath(name) {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from repository archive: %v", name, err)
+		}
+		files[name] = string(data)
+	}
+	return files, nil
+}
+
+func skipAnalysisPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "venv", ".venv", "env", "site-packages", "node_modules", ".git":
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	settingsModulePattern = regexp.MustCompile(`DJANGO_SETTINGS_MODULE["']\s*,\s*["']([\w.]+)["']`)
+	serverAppPattern      = regexp.MustCompile(`(WSGI|ASGI)_APPLICATION\s*=\s*["']([\w.]+)["']`)
+	localhostDBPattern    = regexp.MustCompile(`["']HOST["']\s*:\s*["'](localhost|127\.0\.0\.1)["']`)
+	sqliteNamePattern     = regexp.MustCompile(`["']NAME["']\s*:\s*["']([^"']+)["']`)
+	staticRootPattern     = regexp.MustCompile(`(?m)^\s*STATIC_ROOT\s*=`)
+
+	// Environment reads that raise when the variable is missing.
+	requiredEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`os\.environ\[\s*["'](\w+)["']\s*\]`),
+		regexp.MustCompile(`\bconfig\(\s*["'](\w+)["']\s*\)`),
+		regexp.MustCompile(`\benv\(\s*["'](\w+)["']\s*\)`),
+	}
+	// Environment reads that fall back to None when the variable is missing.
+	optionalEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`os\.getenv\(\s*["'](\w+)["']\s*\)`),
+		regexp.MustCompile(`os\.environ\.get\(\s*["'](\w+)["']\s*\)`),
+	}
+)
+
+// platformEnv is set on the VM by the deployment itself.
+var platformEnv = map[string]bool{
+	"DJANGO_SETTINGS_MODULE": true,
+	"PYTHONPATH":             true,
+	"HOME":                   true,
+	"USER":                   true,
+	"PATH":                   true,
+	"PORT":                   true,
+}
+
+// lineOf returns the 1-based line of offset in content.
+func lineOf(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// moduleFile resolves a dotted module path relative to the project
+// directory to a file in files, as a module or a package.
+func moduleFile(files map[string]string, projectPath, module string) (string, bool) {
+	base := path.Join(projectPath, strings.ReplaceAll(module, ".", "/"))
+	for _, candidate := range []string{base + ".py", base + "/__init__.py"} {
+		if _, exists := files[candidate]; exists {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// AnalyzeProject inspects a Django project's Python sources, keyed by path
+// inside the repository, for problems that would make a deployment fail.
+func AnalyzeProject(files map[string]string, envVariables map[string]string, asgi bool) *AnalysisReport {
+	report := &AnalysisReport{Blockers: []AnalysisFinding{}, Warnings: []AnalysisFinding{}}
+
+	var managePy string
+	for name := range files {
+		if path.Base(name) == "manage.py" && (managePy == "" || len(name) < len(managePy)) {
+			managePy = name
+		}
+	}
+	if managePy == "" {
+		report.block("MISSING_MANAGE_PY", "", 0, "No manage.py found; the repository does not look like a Django project")
+		return report
+	}
+	report.ProjectPath = path.Dir(managePy)
+	if report.ProjectPath == "." {
+		report.ProjectPath = ""
+	}
+
+	match := settingsModulePattern.FindStringSubmatch(files[managePy])
+	if match == nil {
+		report.block("MISSING_SETTINGS_MODULE", managePy, 0, "manage.py does not set DJANGO_SETTINGS_MODULE")
+		return report
+	}
+	report.SettingsModule = match[1]
+
+	// Settings may be a single module or a package of modules.
+	settingsFile, found := moduleFile(files, report.ProjectPath, report.SettingsModule)
+	if !found {
+		report.block("MISSING_SETTINGS_MODULE", managePy, 0, fmt.Sprintf("Settings module %s not found", report.SettingsModule))
+		return report
+	}
+	var settingsFiles []string
+	if strings.HasSuffix(settingsFile, "/__init__.py") {
+		dir := path.Dir(settingsFile)
+		for name := range files {
+			if path.Dir(name) == dir {
+				settingsFiles = append(settingsFiles, name)
+			}
+		}
+	} else {
+		settingsFiles = []string{settingsFile}
+	}
+	sort.Strings(settingsFiles)
+
+	analyzeServerModule(report, files, settingsFiles, asgi)
+	analyzeSettings(report, files, settingsFiles)
+	analyzeEnvReads(report, files, envVariables)
+
+	return report
+}
+
+// analyzeServerModule checks that the WSGI (or ASGI) application module the
+// generated server command will import exists.
+func analyzeServerModule(report *AnalysisReport, files map[string]string, settingsFiles []string, asgi bool) {
+	kind := "WSGI"
+	if asgi {
+		kind = "ASGI"
+	}
+
+	// The generated command imports <project package>.wsgi/asgi.
+	projectPackage := strings.TrimSuffix(report.SettingsModule, ".settings")
+	if i := strings.LastIndex(projectPackage, ".settings."); i >= 0 {
+		projectPackage = projectPackage[:i]
+	}
+	module := projectPackage + "." + strings.ToLower(kind)
+
+	for _, name := range settingsFiles {
+		for _, match := range serverAppPattern.FindAllStringSubmatch(files[name], -1) {
+			if match[1] == kind {
+				module = strings.TrimSuffix(match[2], ".application")
+			}
+		}
+	}
+
+	report.ServerModule = module
+	if _, found := moduleFile(files, report.ProjectPath, module); !found {
+		report.block("MISSING_"+kind+"_MODULE", "", 0, fmt.Sprintf("%s module %s not found", kind, module))
+	}
+}
+
+// analyzeSettings checks DATABASES and static files configuration.
+func analyzeSettings(report *AnalysisReport, files map[string]string, settingsFiles []string) {
+	hasStaticRoot := false
+	for _, name := range settingsFiles {
+		content := files[name]
+
+		for _, loc := range localhostDBPattern.FindAllStringIndex(content, -1) {
+			report.block("LOCALHOST_DATABASE", name, lineOf(content, loc[0]),
+				"DATABASES points at a database on localhost, which does not exist on the deployed VM; read the host from an environment variable")
+		}
+
+		if strings.Contains(content, "sqlite3") {
+			for _, loc := range sqliteNamePattern.FindAllStringSubmatchIndex(content, -1) {
+				dbPath := content[loc[2]:loc[3]]
+				if strings.HasPrefix(dbPath, "/") || strings.HasPrefix(dbPath, "~") || strings.HasPrefix(dbPath, "..") || strings.Contains(dbPath, ":\\") {
+					report.block("SQLITE_OUTSIDE_REPO", name, lineOf(content, loc[0]),
+						fmt.Sprintf("SQLite database path %s is outside the repository; use a path relative to BASE_DIR", dbPath))
+				}
+			}
+		}
+
+		if staticRootPattern.MatchString(content) {
+			hasStaticRoot = true
+		}
+	}
+
+	if !hasStaticRoot {
+		report.block("MISSING_STATIC_ROOT", settingsFiles[0], 0, "STATIC_ROOT is not set, so collectstatic cannot run and static files will not be served")
+	}
+}
+
+// analyzeEnvReads reports environment variables the code reads that the
+// request does not provide.
+func analyzeEnvReads(report *AnalysisReport, files map[string]string, envVariables map[string]string) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	check := func(patterns []*regexp.Regexp, required bool) {
+		for _, name := range names {
+			content := files[name]
+			for _, pattern := range patterns {
+				for _, loc := range pattern.FindAllStringSubmatchIndex(content, -1) {
+					variable := content[loc[2]:loc[3]]
+					if _, provided := envVariables[variable]; provided || platformEnv[variable] || seen[variable] {
+						continue
+					}
+					seen[variable] = true
+
+					if required {
+						report.MissingEnv = append(report.MissingEnv, variable)
+						report.block("MISSING_ENV_VAR", name, lineOf(content, loc[0]),
+							fmt.Sprintf("%s is read without a default but is not in env_variables or secrets", variable))
+					} else {
+						report.warn("MISSING_ENV_VAR", name, lineOf(content, loc[0]),
+							fmt.Sprintf("%s is read but is not in env_variables or secrets; it will be None", variable))
+					}
+				}
+			}
+		}
+	}
+	check(requiredEnvPatterns, true)
+	check(optionalEnvPatterns, false)
+}
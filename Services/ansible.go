@@ -3,21 +3,36 @@ package services
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
 )
 
-func (ds *DeploymentService) createAnsibleFiles(ansibleDir string, req *DeploymentRequest, publicIP string, privateKeyPath string) error {
-	// Use absolute path for the private key to avoid issues
-	absPrivateKeyPath, err := filepath.Abs(privateKeyPath)
+// createAnsibleFiles writes the inventory of hosts and the playbook for the
+// app served at publicIP. hosts is just publicIP unless the app runs on a
+// scale set.
+func (ds *DeploymentService) createAnsibleFiles(ansibleDir string, req *DeploymentRequest, publicIP string, hosts []string, privateKeyPath string) error {
+	// Reference the key relative to the ansible directory so the inventory is
+	// valid both on the host and inside the Ansible container.
+	relPrivateKeyPath, err := filepath.Rel(ansibleDir, privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path for private key: %v", err)
+	}
+	sshArgs, err := ansibleSSHArgs(ansibleDir, privateKeyPath)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for private key: %v", err)
+		return err
 	}
 
-	inventoryContent := fmt.Sprintf(`[django_servers]
-%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'
-`, publicIP, absPrivateKeyPath)
+	var inventory strings.Builder
+	inventory.WriteString("[django_servers]\n")
+	for _, host := range hosts {
+		inventory.WriteString(fmt.Sprintf("%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='%s'\n",
+			host, filepath.ToSlash(relPrivateKeyPath), sshArgs))
+	}
+	inventoryContent := inventory.String()
 
 	inventoryPath := filepath.Join(ansibleDir, "inventory.ini")
 	if err := os.WriteFile(inventoryPath, []byte(inventoryContent), 0644); err != nil {
@@ -33,6 +48,19 @@ func (ds *DeploymentService) createAnsibleFiles(ansibleDir string, req *Deployme
 	return nil
 }
 
+// ansibleSSHArgs returns the ssh options of an inventory in ansibleDir for
+// servers reached with the key at privateKeyPath. They check host keys
+// against the known_hosts file the deployer pins them in, referenced
+// relative to ansibleDir like the key, and pin any host Ansible reaches
+// first.
+func ansibleSSHArgs(ansibleDir, privateKeyPath string) (string, error) {
+	knownHosts, err := filepath.Rel(ansibleDir, KnownHostsPath(privateKeyPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path for known hosts: %v", err)
+	}
+	return fmt.Sprintf("-o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s -o HashKnownHosts=no", filepath.ToSlash(knownHosts)), nil
+}
+
 // func (ds *DeploymentService) setupAnsibleSSHKeys(ansibleDir string) (string, string, error) {
 // 	privateKey, publicKey, err := ds.generateSSHKeyPair()
 // 	if err != nil {
@@ -56,8 +84,8 @@ func (ds *DeploymentService) createAnsibleFiles(ansibleDir string, req *Deployme
 
 func (ds *DeploymentService) generatePlaybook(req *DeploymentRequest, publicIP string) string {
 	var envVars strings.Builder
-	for key, value := range req.EnvVariables {
-		envVars.WriteString(fmt.Sprintf("      %s: \"%s\"\n", key, value))
+	for _, key := range sortedKeys(req.EnvVariables) {
+		envVars.WriteString(fmt.Sprintf("      %s: %q\n", key, req.EnvVariables[key]))
 	}
 
 	var additionalTasks strings.Builder
@@ -74,7 +102,7 @@ for _, cmd := range req.AdditionalCommands {
       args:
         executable: /bin/bash
       become_user: azureuser
-      environment: "{{ env_vars }}"
+      environment: "{{ (env_vars or {}) | combine(secret_env) }}"
       ignore_errors: yes`, cmd))
 	}
 
@@ -95,8 +123,10 @@ for _, cmd := range req.AdditionalCommands {
     github_token: "` + req.GithubToken + `"
     public_ip: "` + publicIP + `"
     asgi: ` + fmt.Sprintf("%t", req.ASGI) + `
+    python_bin: ` + pythonBin(req) + `
+    gunicorn_workers: ` + strconv.Itoa(GunicornWorkers(req)) + `
     env_vars:
-` + envVars.String() + `
+` + envVars.String() + generateSecretVars(req.Secrets) + generateServiceVars(req.Services) + `
   tasks:
     - name: Setup SSH key authentication
       authorized_key:
@@ -125,6 +155,17 @@ for _, cmd := range req.AdditionalCommands {
           - default-libmysqlclient-dev
         state: present
 
+    - name: Install build dependencies for packages without arm64 wheels
+      apt:
+        name:
+          - libffi-dev
+          - libssl-dev
+          - libjpeg-dev
+          - zlib1g-dev
+          - cargo
+        state: present
+      when: ansible_architecture == "aarch64"
+` + generatePythonTasks(req) + generateRedisTasks(req) + ds.generateGPUTasks(req) + generateDataDiskMountTasks(req) + `
     - name: Create application directory
       file:
         path: /home/azureuser/app
@@ -157,7 +198,7 @@ for _, cmd := range req.AdditionalCommands {
         state: absent
 
     - name: Create fresh virtual environment
-      command: python3 -m venv venv
+      command: "{{ python_bin }} -m venv venv"
       args:
         chdir: /home/azureuser/app
         creates: /home/azureuser/app/venv/bin/python3
@@ -220,7 +261,7 @@ for _, cmd := range req.AdditionalCommands {
         chdir: /home/azureuser/app
         executable: /bin/bash
       become_user: azureuser
-      when: asgi
+      when: asgi` + ds.generateIncludedTasks(req, InsertAfterPackages) + `
 
     - name: Find Django manage.py file
       find:
@@ -402,13 +443,21 @@ for _, cmd := range req.AdditionalCommands {
       args:
         executable: /bin/bash
       become_user: azureuser
+` + ds.generateHostsTasks(req) + ds.generateChannelsTasks(req) + ds.generateURLPrefixTasks(req) + `
+    - name: Write the app's environment for start_server.sh
+      copy:
+        content: |
+` + indentLines(EnvExports(req.EnvVariables), "          ") + `        dest: ` + AppEnvFile + `
+        owner: azureuser
+        group: azureuser
+        mode: '0600'
 
     - name: Create .env file for environment variables
       copy:
         content: |
           {% if env_vars %}
           {% for key, value in env_vars.items() %}
-          {{ key }}={{ value }}
+          {{ key }}={{ value | to_json if '\n' in value else value }}
           {% endfor %}
           {% endif %}
         dest: /home/azureuser/app/.env
@@ -427,7 +476,7 @@ for _, cmd := range req.AdditionalCommands {
         - "{{ django_project_path }}/static"
         - "{{ django_project_path }}/media"
         - "{{ django_project_path }}/staticfiles"
-
+` + generateDataDiskRelocateTasks(req) + `
     - name: Create log directories and files with proper permissions
       file:
         path: "{{ item.path }}"
@@ -452,7 +501,7 @@ for _, cmd := range req.AdditionalCommands {
       args:
         executable: /bin/bash
       become_user: azureuser
-      environment: "{{ env_vars }}"
+      environment: "{{ (env_vars or {}) | combine(secret_env) }}"
 
     - name: Collect static files
       shell: |
@@ -464,12 +513,13 @@ for _, cmd := range req.AdditionalCommands {
       args:
         executable: /bin/bash
       become_user: azureuser
-      environment: "{{ env_vars }}"
+      environment: "{{ (env_vars or {}) | combine(secret_env) }}"
       ignore_errors: yes`)
 
 	if len(req.AdditionalCommands) > 0 {
 		playbookBuilder.WriteString(additionalTasks.String())
 	}
+	playbookBuilder.WriteString(ds.generateIncludedTasks(req, InsertAfterMigrate))
 
 	if req.ASGI {
 		playbookBuilder.WriteString(`
@@ -521,7 +571,7 @@ for _, cmd := range req.AdditionalCommands {
       args:
         executable: /bin/bash
       become_user: azureuser
-      environment: "{{ env_vars }}"
+      environment: "{{ (env_vars or {}) | combine(secret_env) }}"
 
     - name: Create ASGI startup script
       copy:
@@ -538,18 +588,12 @@ for _, cmd := range req.AdditionalCommands {
           # Set environment variables
           export DJANGO_SETTINGS_MODULE="{{ django_settings_module }}"
           export PYTHONPATH="/home/azureuser/app:$PYTHONPATH"
-          ` + func() string {
-			var envExports strings.Builder
-			for key, value := range req.EnvVariables {
-				envExports.WriteString(fmt.Sprintf("          export %s=\"%s\"\n", key, value))
-			}
-			return envExports.String()
-		}() + `
+          . ` + AppEnvFile + `
           
-          # Use absolute path to gunicorn with corrected arguments
+` + ds.generateServerExec(req, `          # Use absolute path to gunicorn with corrected arguments
           exec /home/azureuser/app/venv/bin/gunicorn {{ django_asgi_module }}:application \
             --bind 0.0.0.0:8000 \
-            --workers 3 \
+            --workers {{ gunicorn_workers }} \
             --worker-class uvicorn.workers.UvicornWorker \
             --worker-connections 1000 \
             --timeout 300 \
@@ -561,7 +605,7 @@ for _, cmd := range req.AdditionalCommands {
             --log-level info \
             --user azureuser \
             --group azureuser
-        dest: /home/azureuser/app/start_server.sh
+`) + `        dest: /home/azureuser/app/start_server.sh
         owner: azureuser
         group: azureuser
         mode: '0755'`)
@@ -583,18 +627,12 @@ for _, cmd := range req.AdditionalCommands {
           # Set environment variables
           export DJANGO_SETTINGS_MODULE="{{ django_settings_module }}"
           export PYTHONPATH="/home/azureuser/app:$PYTHONPATH"
-          ` + func() string {
-			var envExports strings.Builder
-			for key, value := range req.EnvVariables {
-				envExports.WriteString(fmt.Sprintf("          export %s=\"%s\"\n", key, value))
-			}
-			return envExports.String()
-		}() + `
+          . ` + AppEnvFile + `
           
-          # Use absolute path to gunicorn with corrected arguments
+` + ds.generateServerExec(req, `          # Use absolute path to gunicorn with corrected arguments
           exec /home/azureuser/app/venv/bin/gunicorn {{ django_wsgi_module }}:application \
             --bind 0.0.0.0:8000 \
-            --workers 3 \
+            --workers {{ gunicorn_workers }} \
             --worker-class sync \
             --worker-connections 1000 \
             --timeout 300 \
@@ -606,12 +644,13 @@ for _, cmd := range req.AdditionalCommands {
             --log-level info \
             --user azureuser \
             --group azureuser
-        dest: /home/azureuser/app/start_server.sh
+`) + `        dest: /home/azureuser/app/start_server.sh
         owner: azureuser
         group: azureuser
         mode: '0755'`)
 	}
 
+	upgradeMap, upgradeHeaders, wsLocation := ds.generateWebSocketNginx(req)
 	playbookBuilder.WriteString(`
 
     - name: Create supervisor configuration for Django
@@ -636,11 +675,15 @@ for _, cmd := range req.AdditionalCommands {
           stopwaitsecs=10
           startretries=3
           startsecs=10
-          environment=HOME="/home/azureuser",USER="azureuser",PATH="/home/azureuser/app/venv/bin:/usr/local/bin:/usr/bin:/bin"
+          environment=HOME="/home/azureuser",USER="azureuser",PATH="/home/azureuser/app/venv/bin:/usr/local/bin:/usr/bin:/bin"{% for key, value in secret_env.items() %},{{ key }}="{{ value | replace('%', '%%') | replace('"', '\\"') }}"{% endfor %}
         dest: /etc/supervisor/conf.d/django-server.conf
-        backup: yes
+        mode: '0600'
+      no_log: "{{ secret_env | length > 0 }}"
       notify: restart supervisor
 
+    - name: Remove backups of the supervisor configuration, which hold secrets
+      shell: rm -f /etc/supervisor/conf.d/django-server.conf.*~
+` + ds.generateServiceTasks(req) + `
     - name: Create nginx configuration with rate limiting
       copy:
         content: |
@@ -652,12 +695,12 @@ for _, cmd := range req.AdditionalCommands {
           
           # Connection limiting
           limit_conn_zone $binary_remote_addr zone=addr:10m;
-          
+          ` + upgradeMap + `
           server {
               listen 80;
               server_name _;
               client_max_body_size 100M;
-              
+` + ds.generateURLPrefixNginx(req) + `              
               # Security headers
               add_header X-Frame-Options "SAMEORIGIN" always;
               add_header X-XSS-Protection "1; mode=block" always;
@@ -720,14 +763,14 @@ for _, cmd := range req.AdditionalCommands {
                   add_header Content-Type text/plain;
               }
               
-              # Default location for all other requests
+` + wsLocation + ds.generateOpenPortsNginx(req) + `              # Default location for all other requests
               location / {
                   proxy_pass http://127.0.0.1:8000;
                   proxy_set_header Host $host;
                   proxy_set_header X-Real-IP $remote_addr;
                   proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
                   proxy_set_header X-Forwarded-Proto $scheme;
-                  proxy_redirect off;
+                  proxy_redirect off;` + upgradeHeaders + `
               }
               
               # Block common exploit attempts
@@ -776,7 +819,7 @@ for _, cmd := range req.AdditionalCommands {
 
     - name: Wait for supervisor to process config
       pause:
-        seconds: 5
+        seconds: 5` + ds.generateIncludedTasks(req, InsertBeforeRestart) + `
 
     - name: Stop any existing django-server process
       supervisorctl:
@@ -821,7 +864,7 @@ for _, cmd := range req.AdditionalCommands {
       debug:
         msg: "{{ debug_logs.stdout_lines }}"
       when: final_status.stdout is defined and 'RUNNING' not in final_status.stdout
-
+` + ds.generateChannelsCheckTasks(req) + `
     - name: Ensure nginx is running
       systemd:
         name: nginx
@@ -835,7 +878,11 @@ for _, cmd := range req.AdditionalCommands {
           - Django Project: {{ django_project_name }}
           - Settings Module: {{ django_settings_module }}
           - Server Type: ` + serverType + `
-          - Application URL: http://{{ ansible_host }}
+          - Application URL: http://{{ ansible_host }}` + req.URLPrefix + `/
+          {% if uses_channels | default(false) %}
+          - WebSocket URL: ws://{{ ansible_host }}/ws/
+          - Channel Layer: Redis (redis://127.0.0.1:6379/0)
+          {% endif %}
           - Logs: /home/azureuser/logs/
 
   handlers:
@@ -852,17 +899,334 @@ for _, cmd := range req.AdditionalCommands {
 
 	return playbookBuilder.String()
 }
-func (ds *DeploymentService) runAnsiblePlaybook(ansibleDir string) error {
-	cmd := exec.Command("ansible-playbook", "-i", "inventory.ini", "playbook.yml", "-v", "--timeout", "300")
-	cmd.Dir = ansibleDir
-	cmd.Stdout = os.Stdout
+
+// pythonBin returns the interpreter the app's virtualenvs are created with.
+func pythonBin(req *DeploymentRequest) string {
+	if req.PythonVersion == "" {
+		return "python3"
+	}
+	return "python" + req.PythonVersion
+}
+
+// systemPython returns the python3 version of the request's image, or ""
+// for a custom image.
+func systemPython(req *DeploymentRequest) string {
+	switch req.Image {
+	case "", providers.ImageUbuntu2204:
+		return "3.10"
+	case providers.ImageUbuntu2404:
+		return "3.12"
+	}
+	return ""
+}
+
+// generatePythonTasks installs the requested Python version, from the
+// image's own packages if it ships that version and from the deadsnakes
+// PPA otherwise. Without one the image's python3 is used.
+func generatePythonTasks(req *DeploymentRequest) string {
+	if req.PythonVersion == "" {
+		return ""
+	}
+	var ppa string
+	if req.PythonVersion != systemPython(req) {
+		ppa = fmt.Sprintf(`
+    - name: Add deadsnakes PPA for Python %s
+      apt_repository:
+        repo: ppa:deadsnakes/ppa
+        state: present
+        update_cache: yes
+`, req.PythonVersion)
+	}
+	return ppa + fmt.Sprintf(`
+    - name: Install Python %[1]s
+      apt:
+        name:
+          - python%[1]s
+          - python%[1]s-dev
+          - python%[1]s-venv
+        state: present
+`, req.PythonVersion)
+}
+
+// generateRedisTasks runs a local Redis on 127.0.0.1:6379 for apps that
+// use it as a cache or Celery broker.
+func generateRedisTasks(req *DeploymentRequest) string {
+	if !req.Redis {
+		return ""
+	}
+	return `
+    - name: Install Redis
+      apt:
+        name: redis-server
+        state: present
+
+    - name: Ensure Redis is running
+      service:
+        name: redis-server
+        state: started
+        enabled: yes
+`
+}
+
+// generateChannelsTasks sets up a Redis channel layer for ASGI apps that
+// depend on Django Channels: Redis is installed on the VM and CHANNEL_LAYERS
+// is pointed at it, overriding any layer the settings define.
+func (ds *DeploymentService) generateChannelsTasks(req *DeploymentRequest) string {
+	if !req.ASGI {
+		return ""
+	}
+	return `
+    - name: Check whether the app uses Django Channels
+      shell: grep -qiE '^[[:space:]]*channels([<>=!~;[:space:]]|\[|$)' "{{ actual_req_path }}"
+      register: channels_check
+      failed_when: false
+      changed_when: false
+
+    - name: Set Channels fact
+      set_fact:
+        uses_channels: "{{ channels_check.rc == 0 }}"
+
+    - name: Install Redis for the Channels layer
+      apt:
+        name: redis-server
+        state: present
+      when: uses_channels
+
+    - name: Ensure Redis is running
+      systemd:
+        name: redis-server
+        state: started
+        enabled: yes
+      when: uses_channels
+
+    - name: Install channels-redis
+      shell: |
+        source /home/azureuser/app/venv/bin/activate
+        python -m pip install channels-redis
+      args:
+        executable: /bin/bash
+      become_user: azureuser
+      when: uses_channels
+
+    - name: Point CHANNEL_LAYERS at the local Redis
+      shell: |
+        cd "{{ django_project_path }}"
+        SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}.py"
+        [ -f "$SETTINGS_FILE" ] || SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}/__init__.py"
+        if ! grep -q "django-vpc channel layer" "$SETTINGS_FILE"; then
+          cat >> "$SETTINGS_FILE" <<'EOF'
+
+        # django-vpc channel layer
+        import os as _django_vpc_os
+        CHANNEL_LAYERS = {
+            "default": {
+                "BACKEND": "channels_redis.core.RedisChannelLayer",
+                "CONFIG": {
+                    "hosts": [_django_vpc_os.environ.get("REDIS_URL", "redis://127.0.0.1:6379/0")],
+                },
+            },
+        }
+        EOF
+        fi
+      args:
+        executable: /bin/bash
+      become_user: azureuser
+      when: uses_channels
+`
+}
+
+// generateChannelsCheckTasks verifies after startup that a message sent
+// through the channel layer comes back, failing the deployment otherwise.
+func (ds *DeploymentService) generateChannelsCheckTasks(req *DeploymentRequest) string {
+	if !req.ASGI {
+		return ""
+	}
+	return `
+    - name: Verify the Channels layer round-trip
+      shell: |
+        cd "{{ django_project_path }}"
+        source /home/azureuser/app/venv/bin/activate
+        export DJANGO_SETTINGS_MODULE="{{ django_settings_module }}"
+        export PYTHONPATH="/home/azureuser/app:$PYTHONPATH"
+        python -c "
+        import django
+        django.setup()
+        from asgiref.sync import async_to_sync
+        from channels.layers import get_channel_layer
+        layer = get_channel_layer()
+        channel = async_to_sync(layer.new_channel)()
+        async_to_sync(layer.send)(channel, {'type': 'django_vpc.ping'})
+        message = async_to_sync(layer.receive)(channel)
+        assert message['type'] == 'django_vpc.ping', message
+        print('Channel layer round-trip OK')
+        "
+      args:
+        executable: /bin/bash
+      become_user: azureuser
+      environment: "{{ (env_vars or {}) | combine(secret_env) }}"
+      register: channels_roundtrip
+      when: uses_channels
+
+    - name: Display Channels layer check
+      debug:
+        msg: "{{ channels_roundtrip.stdout }}"
+      when: uses_channels
+`
+}
+
+// generateWebSocketNginx returns the nginx pieces that let websockets
+// through the proxy for ASGI deployments: the Connection header map, the
+// upgrade headers for the default location, and a /ws/ location with proxy
+// timeouts long enough for idle websockets. WSGI deployments get only the
+// map, and only if they proxy open_ports.
+func (ds *DeploymentService) generateWebSocketNginx(req *DeploymentRequest) (upgradeMap, upgradeHeaders, wsLocation string) {
+	if !req.ASGI && !proxiesOpenPorts(req) {
+		return "", "", ""
+	}
+
+	upgradeMap = `
+          # Close the upstream connection unless the client asked to upgrade
+          map $http_upgrade $connection_upgrade {
+              default upgrade;
+              '' close;
+          }
+`
+	if !req.ASGI {
+		// Only the open_ports locations upgrade connections.
+		return upgradeMap, "", ""
+	}
+	upgradeHeaders = `
+                  proxy_http_version 1.1;
+                  proxy_set_header Upgrade $http_upgrade;
+                  proxy_set_header Connection $connection_upgrade;`
+	wsLocation = `              # WebSocket endpoints (Django Channels routes under /ws/)
+              location /ws/ {
+                  proxy_pass http://127.0.0.1:8000;
+                  proxy_http_version 1.1;
+                  proxy_set_header Upgrade $http_upgrade;
+                  proxy_set_header Connection $connection_upgrade;
+                  proxy_set_header Host $host;
+                  proxy_set_header X-Real-IP $remote_addr;
+                  proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+                  proxy_set_header X-Forwarded-Proto $scheme;
+                  proxy_read_timeout 3600s;
+                  proxy_send_timeout 3600s;
+                  proxy_redirect off;
+              }
+              
+`
+	return upgradeMap, upgradeHeaders, wsLocation
+}
+
+// generateURLPrefixTasks points the app's generated URLs at the request's
+// url_prefix: FORCE_SCRIPT_NAME for reverse() and redirects, and
+// STATIC_URL and MEDIA_URL for assets. Settings are appended, so they
+// override the app's own values.
+func (ds *DeploymentService) generateURLPrefixTasks(req *DeploymentRequest) string {
+	if req.URLPrefix == "" {
+		return ""
+	}
+	return `
+    - name: Serve the app under ` + req.URLPrefix + `
+      shell: |
+        cd "{{ django_project_path }}"
+        SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}.py"
+        [ -f "$SETTINGS_FILE" ] || SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}/__init__.py"
+        if ! grep -q "django-vpc url prefix" "$SETTINGS_FILE"; then
+          cat >> "$SETTINGS_FILE" <<'EOF'
+
+        # django-vpc url prefix
+        FORCE_SCRIPT_NAME = "` + req.URLPrefix + `"
+        STATIC_URL = "` + req.URLPrefix + `/static/"
+        MEDIA_URL = "` + req.URLPrefix + `/media/"
+        EOF
+        fi
+      args:
+        executable: /bin/bash
+      become_user: azureuser
+`
+}
+
+// generateURLPrefixNginx strips the request's url_prefix before location
+// matching, so the locations below serve the prefixed paths unchanged and
+// the app sees paths relative to FORCE_SCRIPT_NAME. Unprefixed paths keep
+// working for health checks from the VM's own address.
+func (ds *DeploymentService) generateURLPrefixNginx(req *DeploymentRequest) string {
+	if req.URLPrefix == "" {
+		return ""
+	}
+	return `
+              # Serve the app under ` + req.URLPrefix + `
+              rewrite ^` + req.URLPrefix + `$ ` + req.URLPrefix + `/ permanent;
+              rewrite ^` + req.URLPrefix + `(/.*)$ $1 break;
+`
+}
+
+// generateServerExec returns the exec line of start_server.sh: the request's
+// start_command if it has one, otherwise the generated gunicorn command.
+// The custom command runs after the venv is activated and the environment
+// is exported, and must serve on $PORT, which nginx proxies to.
+func (ds *DeploymentService) generateServerExec(req *DeploymentRequest, gunicornExec string) string {
+	if req.StartCommand == "" {
+		return gunicornExec
+	}
+	return fmt.Sprintf(`          # Custom start command from the deployment request
+          export PORT=8000
+          exec %s
+`, req.StartCommand)
+}
+
+func (ds *DeploymentService) runAnsiblePlaybook(ansibleDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	cmd, err := ansibleCommandWithEnv(ds.context(), ansibleDir, ds.secretEnv, "-i", "inventory.ini", "playbook.yml", "-v", "--timeout", "300")
+	if err != nil {
+		return err
+	}
+	events := &ansibleEventWriter{
+		out: os.Stdout,
+		emit: func(task, host, line string) {
+			if len(line) > 500 {
+				line = line[:500] + "..."
+			}
+			ds.broadcastEvent(broadcaster, deploymentID, "error", EventAnsibleTaskFailed, fmt.Sprintf("Task %q failed: %s", task, line), "ansible",
+				map[string]interface{}{"task": task, "host": host})
+		},
+	}
+	defer events.Close()
+	cmd.Stdout = events
 	cmd.Stderr = os.Stderr
-	
-	cmd.Env = append(os.Environ(),
-		"ANSIBLE_HOST_KEY_CHECKING=False",
-		"ANSIBLE_SSH_RETRIES=3",
-		"ANSIBLE_TIMEOUT=300",
-	)
-	
+
 	return cmd.Run()
-}
\ No newline at end of file
+}
+
+// generateGPUTasks installs the NVIDIA server driver and the CUDA toolkit on
+// GPU deployments that ask for it, rebooting once so the driver loads.
+func (ds *DeploymentService) generateGPUTasks(req *DeploymentRequest) string {
+	if !req.GPU || !req.InstallCUDA {
+		return ""
+	}
+
+	return `
+    - name: Install NVIDIA driver and CUDA toolkit
+      apt:
+        name:
+          - nvidia-driver-535-server
+          - nvidia-utils-535-server
+          - nvidia-cuda-toolkit
+        state: present
+      register: nvidia_install
+
+    - name: Reboot to load the NVIDIA driver
+      reboot:
+        reboot_timeout: 600
+      when: nvidia_install.changed
+
+    - name: Verify GPU is visible
+      command: nvidia-smi
+      register: nvidia_smi
+      changed_when: false
+
+    - name: Display GPU status
+      debug:
+        msg: "{{ nvidia_smi.stdout_lines }}"
+`
+}
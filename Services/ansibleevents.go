@@ -0,0 +1,74 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	ansibleTaskPattern   = regexp.MustCompile(`^TASK \[(.*)\] \**$`)
+	ansibleFailedPattern = regexp.MustCompile(`^(?:fatal|failed): \[([^\]]+)\]`)
+)
+
+// ansibleEventWriter passes ansible-playbook output through to out and
+// broadcasts an ANSIBLE_TASK_FAILED event for every task failure that is not
+// ignored.
+type ansibleEventWriter struct {
+	out  io.Writer
+	emit func(task, host, line string)
+
+	buf  bytes.Buffer
+	task string
+	// pendingHost and pendingLine hold a failure until the next line shows
+	// whether the task ignores errors.
+	pendingHost string
+	pendingLine string
+}
+
+func (w *ansibleEventWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	w.buf.Write(p[:n])
+	for {
+		line, readErr := w.buf.ReadString('\n')
+		if readErr != nil {
+			// Keep the partial line for the next write.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.line(strings.TrimRight(line, "\r\n"))
+	}
+	return n, err
+}
+
+func (w *ansibleEventWriter) line(line string) {
+	if w.pendingLine != "" {
+		if strings.TrimSpace(line) != "...ignoring" {
+			w.flush()
+		}
+		w.pendingHost, w.pendingLine = "", ""
+	}
+
+	if match := ansibleTaskPattern.FindStringSubmatch(line); match != nil {
+		w.task = match[1]
+		return
+	}
+	if match := ansibleFailedPattern.FindStringSubmatch(line); match != nil {
+		w.pendingHost, w.pendingLine = match[1], line
+	}
+}
+
+// Close reports a failure still waiting on its "...ignoring" line.
+func (w *ansibleEventWriter) Close() error {
+	if w.pendingLine != "" {
+		w.flush()
+		w.pendingHost, w.pendingLine = "", ""
+	}
+	return nil
+}
+
+func (w *ansibleEventWriter) flush() {
+	w.emit(w.task, w.pendingHost, w.pendingLine)
+}
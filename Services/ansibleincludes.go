@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Insertion points for user-provided Ansible tasks in the generated
+// playbook.
+const (
+	InsertAfterPackages = "after_packages"
+	InsertAfterMigrate  = "after_migrate"
+	InsertBeforeRestart = "before_restart"
+)
+
+const maxIncludedTasks = 50
+
+// AnsibleInclude is a YAML list of Ansible tasks merged into the generated
+// playbook at InsertAt.
+type AnsibleInclude struct {
+	InsertAt string `json:"insert_at"`
+	Tasks    string `json:"tasks"`
+}
+
+// Task keywords and modules that act on the control node (the API server)
+// rather than the deployed VM, or that change which hosts the play targets.
+var (
+	forbiddenTaskKeys = map[string]bool{
+		"hosts":           true,
+		"delegate_to":     true,
+		"local_action":    true,
+		"connection":      true,
+		"vars_files":      true,
+		"import_playbook": true,
+	}
+	forbiddenModules = map[string]bool{
+		"include":       true,
+		"include_tasks": true,
+		"import_tasks":  true,
+		"include_role":  true,
+		"import_role":   true,
+		"include_vars":  true,
+		"template":      true,
+		"script":        true,
+		"fetch":         true,
+		"synchronize":   true,
+		"add_host":      true,
+		"group_by":      true,
+		"meta":          true,
+		"set_stats":     true,
+	}
+	// Modules whose src is read from the control node unless remote_src
+	// is set.
+	localSrcModules = map[string]bool{
+		"copy":      true,
+		"unarchive": true,
+	}
+
+	// Lookups run on the control node, so templates may not call them.
+	lookupPattern = regexp.MustCompile(`\b(lookup|query|q)\s*\(`)
+)
+
+// ValidateAnsibleIncludes parses and lints each include's tasks.
+func ValidateAnsibleIncludes(includes []AnsibleInclude) error {
+	for i, include := range includes {
+		switch include.InsertAt {
+		case InsertAfterPackages, InsertAfterMigrate, InsertBeforeRestart:
+		default:
+			return fmt.Errorf("ansible_includes[%d]: insert_at must be after_packages, after_migrate or before_restart", i)
+		}
+		if _, err := renderIncludedTasks(include.Tasks); err != nil {
+			return fmt.Errorf("ansible_includes[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// renderIncludedTasks lints tasks and re-serializes them indented as
+// entries of the playbook's task list. Re-serializing means the user's
+// indentation cannot escape the task list.
+func renderIncludedTasks(tasks string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(tasks), &doc); err != nil {
+		return "", fmt.Errorf("invalid YAML: %v", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("tasks must be a YAML list of tasks")
+	}
+	list := doc.Content[0]
+	if len(list.Content) == 0 || len(list.Content) > maxIncludedTasks {
+		return "", fmt.Errorf("tasks must contain between 1 and %d tasks", maxIncludedTasks)
+	}
+
+	for i, task := range list.Content {
+		if err := lintTask(task); err != nil {
+			return "", fmt.Errorf("task %d: %v", i+1, err)
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(list); err != nil {
+		return "", fmt.Errorf("failed to serialize tasks: %v", err)
+	}
+	encoder.Close()
+
+	var rendered strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		rendered.WriteString("\n    " + line)
+	}
+	return rendered.String(), nil
+}
+
+func lintTask(task *yaml.Node) error {
+	if task.Kind != yaml.MappingNode {
+		return fmt.Errorf("each task must be a mapping")
+	}
+
+	hasName := false
+	for i := 0; i+1 < len(task.Content); i += 2 {
+		key, value := task.Content[i].Value, task.Content[i+1]
+		module := strings.TrimPrefix(strings.TrimPrefix(key, "ansible.builtin."), "ansible.legacy.")
+		switch {
+		case key == "name":
+			hasName = value.Value != ""
+		case key == "block" || key == "rescue" || key == "always":
+			if value.Kind != yaml.SequenceNode {
+				return fmt.Errorf("%s must be a list of tasks", key)
+			}
+			for _, nested := range value.Content {
+				if err := lintTask(nested); err != nil {
+					return err
+				}
+			}
+		case forbiddenTaskKeys[key]:
+			return fmt.Errorf("%q is not allowed", key)
+		case forbiddenModules[module]:
+			return fmt.Errorf("module %q is not allowed", key)
+		case localSrcModules[module]:
+			remoteSrc := strings.ToLower(mappingValue(value, "remote_src"))
+			if mappingValue(value, "src") != "" && remoteSrc != "true" && remoteSrc != "yes" {
+				return fmt.Errorf("%s must use content or remote_src; files cannot be read from the deployment server", key)
+			}
+		}
+	}
+	if !hasName {
+		return fmt.Errorf("every task needs a name")
+	}
+	return checkNoLookups(task)
+}
+
+func mappingValue(node *yaml.Node, key string) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// checkNoLookups walks every node of a task for constructs that would run
+// on the control node.
+func checkNoLookups(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && lookupPattern.MatchString(node.Value) {
+		return fmt.Errorf("lookups are not allowed: %q", node.Value)
+	}
+	if node.Kind == yaml.AliasNode {
+		return fmt.Errorf("YAML aliases are not allowed")
+	}
+	// ansible_* variables control the connection, e.g. ansible_connection:
+	// local would run the task on the deployment server.
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if strings.HasPrefix(node.Content[i].Value, "ansible_") {
+				return fmt.Errorf("setting %s is not allowed", node.Content[i].Value)
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if err := checkNoLookups(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateIncludedTasks returns the request's tasks for insertAt, already
+// validated when the request was accepted.
+func (ds *DeploymentService) generateIncludedTasks(req *DeploymentRequest, insertAt string) string {
+	var tasks strings.Builder
+	for _, include := range req.AnsibleIncludes {
+		if include.InsertAt != insertAt {
+			continue
+		}
+		rendered, err := renderIncludedTasks(include.Tasks)
+		if err != nil {
+			continue
+		}
+		tasks.WriteString("\n" + rendered)
+	}
+	return tasks.String()
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Tools"
+)
+
+// DefaultAnsibleImage is the pinned image used when Ansible runs in a
+// container and ANSIBLE_IMAGE is not set.
+const DefaultAnsibleImage = "quay.io/ansible/creator-ee:v24.2.0"
+
+const (
+	containerWorkDir  = "/workspace"
+	containerKeySetup = `install -m 600 ../terraform/azure_vm_key /tmp/azure_vm_key && ` +
+		`install -m 644 ../terraform/azure_vm_key.pub /tmp/azure_vm_key.pub && ` +
+		`exec ansible-playbook -e ansible_ssh_private_key_file=/tmp/azure_vm_key "$@"`
+)
+
+var ansibleEnv = []string{
+	"ANSIBLE_HOST_KEY_CHECKING=True",
+	"ANSIBLE_SSH_RETRIES=3",
+	"ANSIBLE_TIMEOUT=300",
+}
+
+// ansibleRunner returns the container engine configured with ANSIBLE_RUNNER
+// ("docker" or "podman"), or "" to run the ansible-playbook on the host.
+// Ansible has no native Windows control node, so Windows hosts default to
+// docker. Under workspace isolation the playbook always runs in a
+// container, since lookups and templates run on the control node.
+func ansibleRunner() (string, error) {
+	isolation, err := providers.WorkspaceIsolation()
+	if err != nil {
+		return "", err
+	}
+	runner := os.Getenv("ANSIBLE_RUNNER")
+	if runner == "" && runtime.GOOS == "windows" {
+		runner = "docker"
+	}
+	if isolation != "" && (runner == "" || runner == "local") {
+		runner = isolation
+	}
+
+	switch runner {
+	case "", "local":
+		if runtime.GOOS == "windows" {
+			return "", fmt.Errorf("ansible-playbook cannot run natively on Windows; set ANSIBLE_RUNNER to docker or podman")
+		}
+		return "", nil
+	case "docker", "podman":
+		return runner, nil
+	default:
+		return "", fmt.Errorf("unsupported ANSIBLE_RUNNER %q (expected local, docker or podman)", runner)
+	}
+}
+
+// AnsibleRunsInContainer reports whether ansible-playbook is configured to
+// run in a container, in which case no host installation is needed.
+func AnsibleRunsInContainer() bool {
+	runner, err := ansibleRunner()
+	return err == nil && runner != ""
+}
+
+// AnsibleImage returns the image ansible-playbook runs in in container mode.
+// ANSIBLE_IMAGE may pin it by digest (image@sha256:...).
+func AnsibleImage() string {
+	if image := os.Getenv("ANSIBLE_IMAGE"); image != "" {
+		return image
+	}
+	return DefaultAnsibleImage
+}
+
+// ansibleCommand builds an ansible-playbook invocation in ansibleDir. In
+// container mode the deployment work directory is mounted at
+// containerWorkDir; inventories reference the key by relative path so they
+// resolve the same way inside the container. The key is copied out of the
+// bind mount first because mounts from Windows and macOS hosts do not keep
+// the 0600 mode ssh insists on. Cancelling ctx interrupts the playbook,
+// which the container engine passes on to the container.
+func ansibleCommand(ctx context.Context, ansibleDir string, args ...string) (*exec.Cmd, error) {
+	return ansibleCommandWithEnv(ctx, ansibleDir, nil, args...)
+}
+
+// ansibleCommandWithEnv is ansibleCommand with extra KEY=VALUE environment
+// entries. Containers are given only the names, so the values never show up
+// on the container engine's command line.
+func ansibleCommandWithEnv(ctx context.Context, ansibleDir string, extraEnv []string, args ...string) (*exec.Cmd, error) {
+	runner, err := ansibleRunner()
+	if err != nil {
+		return nil, err
+	}
+
+	if runner == "" {
+		cmd := tools.InterruptibleCommand(ctx, ansibleCancelGrace, "ansible-playbook", args...)
+		cmd.Dir = ansibleDir
+		cmd.Env = append(append(os.Environ(), ansibleEnv...), extraEnv...)
+		return cmd, nil
+	}
+
+	absAnsibleDir, err := filepath.Abs(ansibleDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ansible directory: %v", err)
+	}
+	workDir := filepath.Dir(absAnsibleDir)
+
+	containerArgs := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:%s", workDir, containerWorkDir),
+		"-w", path.Join(containerWorkDir, filepath.Base(absAnsibleDir)),
+	}
+	containerArgs = append(containerArgs, isolationArgs(runner)...)
+	for _, env := range ansibleEnv {
+		containerArgs = append(containerArgs, "-e", env)
+	}
+	for _, env := range extraEnv {
+		name, _, _ := strings.Cut(env, "=")
+		containerArgs = append(containerArgs, "-e", name)
+	}
+	containerArgs = append(containerArgs, AnsibleImage(), "sh", "-c", containerKeySetup, "ansible-playbook")
+	containerArgs = append(containerArgs, args...)
+
+	cmd := tools.InterruptibleCommand(ctx, ansibleCancelGrace, runner, containerArgs...)
+	cmd.Dir = ansibleDir
+	if len(extraEnv) > 0 {
+		cmd.Env = append(os.Environ(), extraEnv...)
+	}
+	return cmd, nil
+}
+
+// ansibleToolCommand builds an invocation of an Ansible tool, such as
+// ansible-lint, in dir, which is mounted on its own in container mode.
+// Unlike ansibleCommand it needs no SSH key, so it can check playbooks
+// before any server exists.
+func ansibleToolCommand(ctx context.Context, dir, tool string, args ...string) (*exec.Cmd, error) {
+	runner, err := ansibleRunner()
+	if err != nil {
+		return nil, err
+	}
+
+	if runner == "" {
+		cmd := tools.InterruptibleCommand(ctx, ansibleCancelGrace, tool, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), ansibleEnv...)
+		return cmd, nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s directory: %v", tool, err)
+	}
+	containerArgs := []string{"run", "--rm", "-v", fmt.Sprintf("%s:%s", absDir, containerWorkDir), "-w", containerWorkDir}
+	containerArgs = append(containerArgs, isolationArgs(runner)...)
+	for _, env := range ansibleEnv {
+		containerArgs = append(containerArgs, "-e", env)
+	}
+	containerArgs = append(containerArgs, AnsibleImage(), tool)
+
+	cmd := tools.InterruptibleCommand(ctx, ansibleCancelGrace, runner, append(containerArgs, args...)...)
+	cmd.Dir = dir
+	return cmd, nil
+}
+
+// isolationArgs confines Ansible containers the way providers.Isolate
+// confines Terraform when workspace isolation is on.
+func isolationArgs(runner string) []string {
+	if isolation, _ := providers.WorkspaceIsolation(); isolation == "" {
+		return nil
+	}
+	return providers.ContainerIsolationArgs(runner)
+}
+
+// ansibleCancelGrace is how long an interrupted playbook has to stop before
+// it is killed.
+const ansibleCancelGrace = 30 * time.Second
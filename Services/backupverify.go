@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// VerifyDiskLUN is where a restore drill attaches the disk restored from a
+// backup.
+const VerifyDiskLUN = 0
+
+// backupVerifiedMarker ends the output of a VerifyBackupScript that ran to
+// completion, whatever its checks found.
+const backupVerifiedMarker = "django-vpc: backup checks complete"
+
+// backupCheckPrefix starts each check line of VerifyBackupScript's output,
+// followed by the tab separated name, "ok" or "fail", and detail.
+const backupCheckPrefix = "django-vpc-check"
+
+// VerifyBackupScript checks a disk restored from a backup snapshot: the
+// root filesystem must pass e2fsck (replaying the journal of the
+// crash-consistent snapshot is expected), the app checkout and its .env
+// must be present, and every SQLite database must pass PRAGMA
+// integrity_check. Databases on a data disk are not in the snapshot and
+// fail the check, since restoring the backup would lose them.
+var VerifyBackupScript = fmt.Sprintf(`set -u
+LINK=/dev/disk/azure/scsi1/lun%d
+for i in $(seq 60); do [ -e "$LINK" ] && break; sleep 2; done
+check() { printf '%s\t%%s\t%%s\t%%s\n' "$1" "$2" "$3"; }
+DEV=$(readlink -f "$LINK")
+PART="${DEV}1"
+if [ ! -b "$PART" ]; then
+  check disk fail "restored disk has no partition at $PART"
+  echo "%s"
+  exit 0
+fi
+
+sudo e2fsck -fp "$PART" > /tmp/drill-fsck.log 2>&1
+rc=$?
+if [ $rc -le 1 ]; then
+  check filesystem ok "e2fsck exit $rc"
+else
+  check filesystem fail "e2fsck exit $rc: $(tail -n 1 /tmp/drill-fsck.log)"
+fi
+
+sudo mkdir -p /mnt/drill
+if ! sudo mount -o ro "$PART" /mnt/drill; then
+  check mount fail "could not mount the restored filesystem"
+  echo "%s"
+  exit 0
+fi
+trap 'cd / && sudo umount /mnt/drill' EXIT
+APP=/mnt/drill/home/azureuser/app
+
+if [ -n "$(sudo find "$APP" -maxdepth 3 -name manage.py -print -quit 2>/dev/null)" ]; then
+  check app ok "found manage.py under /home/azureuser/app"
+else
+  check app fail "no Django project under /home/azureuser/app"
+fi
+if sudo test -f "$APP/.env"; then
+  check env ok ".env present"
+else
+  check env fail ".env missing"
+fi
+
+sudo find "$APP" -maxdepth 3 -path "$APP/venv" -prune -o \( -name '*.sqlite3' -o -name '*.sqlite' \) \( -type f -o -type l \) -print > /tmp/drill-dbs 2>/dev/null
+found=0
+while IFS= read -r db; do
+  found=1
+  name=${db#$APP/}
+  if [ -L "$db" ]; then
+    check "sqlite:$name" fail "links to $(sudo readlink "$db"), which is not in the backup"
+    continue
+  fi
+  result=$(sudo python3 - "$db" <<'PY' 2>&1
+import sqlite3, sys
+conn = sqlite3.connect("file:" + sys.argv[1] + "?mode=ro&immutable=1", uri=True)
+status = conn.execute("PRAGMA integrity_check").fetchone()[0]
+tables = conn.execute("SELECT count(*) FROM sqlite_master WHERE type = 'table'").fetchone()[0]
+print(status, tables)
+PY
+)
+  case "$result" in
+    "ok "*) check "sqlite:$name" ok "integrity ok, ${result#ok } tables" ;;
+    *) check "sqlite:$name" fail "$(echo "$result" | tail -n 1)" ;;
+  esac
+done < /tmp/drill-dbs
+if [ $found -eq 0 ]; then
+  check sqlite ok "no SQLite databases; an external database is not covered by disk backups"
+fi
+
+echo "%s"
+`, VerifyDiskLUN, backupCheckPrefix, backupVerifiedMarker, backupVerifiedMarker, backupVerifiedMarker)
+
+// ParseBackupChecks returns the checks in the output of VerifyBackupScript
+// and whether the script ran to completion.
+func ParseBackupChecks(output string) ([]store.BackupCheck, bool) {
+	var checks []store.BackupCheck
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "\t", 4)
+		if len(fields) < 3 || fields[0] != backupCheckPrefix {
+			continue
+		}
+		check := store.BackupCheck{Name: fields[1], OK: fields[2] == "ok"}
+		if len(fields) == 4 {
+			check.Detail = fields[3]
+		}
+		checks = append(checks, check)
+	}
+	return checks, strings.Contains(output, backupVerifiedMarker)
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// The generated playbook and the SSH helpers work as azureuser, which cloud
+// VMs get from cloud-init. On an existing server the request's user creates
+// it instead, with passwordless sudo and the deployment's generated key.
+const byosBootstrapScript = `set -e
+id -u azureuser >/dev/null 2>&1 || useradd -m -s /bin/bash azureuser
+echo 'azureuser ALL=(ALL) NOPASSWD:ALL' > /etc/sudoers.d/90-django-vpc
+chmod 0440 /etc/sudoers.d/90-django-vpc
+install -d -m 0700 -o azureuser -g azureuser /home/azureuser/.ssh
+touch /home/azureuser/.ssh/authorized_keys
+grep -qxF %[1]s /home/azureuser/.ssh/authorized_keys || echo %[1]s >> /home/azureuser/.ssh/authorized_keys
+chown azureuser:azureuser /home/azureuser/.ssh/authorized_keys
+chmod 0600 /home/azureuser/.ssh/authorized_keys
+`
+
+// prepareExistingServer connects to a bring-your-own server as the request's
+// user and sets up azureuser with the public key GenerateSSHKeys wrote to
+// terraformDir. The user must be root or have passwordless sudo.
+func (ds *DeploymentService) prepareExistingServer(server *providers.BYOSProvider, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	publicKey, err := os.ReadFile(filepath.Join(terraformDir, "azure_vm_key.pub"))
+	if err != nil {
+		return fmt.Errorf("failed to read generated public key: %v", err)
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Connecting to %s as %s...", server.Host, server.User), "ssh")
+	client, err := dialSSHAs(server.Host, server.User, []byte(server.PrivateKey), KnownHostsPath(filepath.Join(terraformDir, "azure_vm_key")))
+	if err != nil {
+		return err
+	}
+
+	command := "sh -c " + singleQuote(fmt.Sprintf(byosBootstrapScript, singleQuote(strings.TrimSpace(string(publicKey)))))
+	if server.User != "root" {
+		command = "sudo -n " + command
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Setting up the azureuser account...", "ssh")
+	if output, err := runSSHCommand(client, server.Host, command, 2*time.Minute); err != nil {
+		if output != "" {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Server setup output:\n%s", output), "ssh")
+		}
+		return err
+	}
+	return nil
+}
+
+// singleQuote quotes value for a POSIX shell. shellQuote needs bash, which
+// may not be the login shell of the request's user.
+func singleQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// BYOSFields reports whether the request sets any of the existing server
+// fields.
+func BYOSFields(req *DeploymentRequest) bool {
+	return req.ServerHost != "" || req.ServerUser != "" || req.ServerSSHKey != ""
+}
+
+// ParseServerKey checks that key is a private key the deployment can log in
+// with.
+func ParseServerKey(key string) error {
+	_, err := ssh.ParsePrivateKey([]byte(key))
+	return err
+}
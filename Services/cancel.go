@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// context returns the context of the running deployment, which is never
+// cancelled outside Deploy.
+func (ds *DeploymentService) context() context.Context {
+	if ds.ctx == nil {
+		return context.Background()
+	}
+	return ds.ctx
+}
+
+// Cancelled reports whether err is the error of a cancelled deployment.
+func Cancelled(err error) bool {
+	var derr *types.DeploymentError
+	return errors.As(err, &derr) && derr.Code == types.ErrCodeCancelled
+}
+
+// cancelledError is the error Deploy returns once its context is cancelled,
+// whichever step was interrupted.
+func cancelledError(cause error) error {
+	return types.NewDeploymentError("cancel", types.ErrCodeCancelled, false, cause, "deployment cancelled")
+}
+
+// checkCancelled returns cancelledError if the deployment was cancelled, so
+// the pipeline stops before its next step.
+func (ds *DeploymentService) checkCancelled() error {
+	if err := ds.context().Err(); err != nil {
+		return cancelledError(err)
+	}
+	return nil
+}
+
+// sleep waits for d, or until the deployment is cancelled.
+func (ds *DeploymentService) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ds.context().Done():
+		return cancelledError(ds.context().Err())
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// ServicesDir holds the checkouts of a request's extra services on the VM.
+const ServicesDir = "/home/azureuser/services"
+
+// MainProgram is the supervisor program of a deployment's Django app.
+const MainProgram = "django-server"
+
+// ServiceSpec is an extra repository deployed next to the main app on the
+// same VM, such as a Celery worker. It gets its own checkout, virtualenv and
+// environment under ServicesDir and runs Command as its own supervisor
+// program. Auto-deploy only updates the main app.
+type ServiceSpec struct {
+	Name          string            `json:"name" yaml:"name"`
+	RepoURL       string            `json:"repo_url" yaml:"repo_url"`
+	EnvVariables  map[string]string `json:"env_variables,omitempty" yaml:"env_variables"`
+	SetupCommands []string          `json:"setup_commands,omitempty" yaml:"setup_commands"`
+	Command       string            `json:"command" yaml:"command"`
+}
+
+// ServiceProgram returns the supervisor program a service runs as.
+func ServiceProgram(name string) string {
+	return "svc-" + name
+}
+
+func serviceDir(name string) string {
+	return ServicesDir + "/" + name
+}
+
+// generateServiceVars returns the composite_services play variable, which
+// holds each service's repository and environment.
+func generateServiceVars(specs []ServiceSpec) string {
+	if len(specs) == 0 {
+		return ""
+	}
+
+	var vars strings.Builder
+	vars.WriteString("    composite_services:\n")
+	for _, spec := range specs {
+		vars.WriteString(fmt.Sprintf("      %s:\n", spec.Name))
+		vars.WriteString(fmt.Sprintf("        repo_url: %q\n", spec.RepoURL))
+		if len(spec.EnvVariables) == 0 {
+			vars.WriteString("        env: {}\n")
+			continue
+		}
+		vars.WriteString("        env:\n")
+		for _, key := range sortedKeys(spec.EnvVariables) {
+			vars.WriteString(fmt.Sprintf("          %s: %q\n", key, spec.EnvVariables[key]))
+		}
+	}
+	return vars.String()
+}
+
+// generateServiceTasks checks out and sets up each service and writes its
+// supervisor program. The programs are started by the supervisor reload
+// that follows.
+func (ds *DeploymentService) generateServiceTasks(req *DeploymentRequest) string {
+	if len(req.Services) == 0 {
+		return ""
+	}
+
+	var tasks strings.Builder
+	tasks.WriteString(`
+    - name: Create services directory
+      file:
+        path: ` + ServicesDir + `
+        state: directory
+        owner: azureuser
+        group: azureuser
+        mode: '0755'
+`)
+
+	for _, spec := range req.Services {
+		dir := serviceDir(spec.Name)
+		service := fmt.Sprintf("composite_services['%s']", spec.Name)
+
+		tasks.WriteString(fmt.Sprintf(`
+    - name: Clone service %[1]s
+      git:
+        repo: "https://{{ github_token }}@{{ %[3]s.repo_url | regex_replace('https://') }}"
+        dest: %[2]s
+        force: yes
+      become_user: azureuser
+
+    - name: Create virtual environment for service %[1]s
+      command: "{{ python_bin }} -m venv venv"
+      args:
+        chdir: %[2]s
+        creates: %[2]s/venv/bin/python3
+      become_user: azureuser
+
+    - name: Install dependencies for service %[1]s
+      shell: |
+        source %[2]s/venv/bin/activate
+        python -m pip install --upgrade pip
+        if [ -f requirements.txt ]; then
+          python -m pip install -r requirements.txt --no-cache-dir
+        fi
+      args:
+        chdir: %[2]s
+        executable: /bin/bash
+      become_user: azureuser
+`, spec.Name, dir, service))
+
+		for _, cmd := range spec.SetupCommands {
+			tasks.WriteString(fmt.Sprintf(`
+    - name: Run setup command for service %[1]s
+      shell: |
+        source %[2]s/venv/bin/activate
+        %[4]s
+      args:
+        chdir: %[2]s
+        executable: /bin/bash
+      become_user: azureuser
+      environment: "{{ %[3]s.env | combine(secret_env) }}"
+`, spec.Name, dir, service, cmd))
+		}
+
+		var envExports strings.Builder
+		for _, key := range sortedKeys(spec.EnvVariables) {
+			envExports.WriteString(fmt.Sprintf("          export %s=%s\n", key, shellQuote(spec.EnvVariables[key])))
+		}
+
+		program := ServiceProgram(spec.Name)
+		tasks.WriteString(fmt.Sprintf(`
+    - name: Create startup script for service %[1]s
+      copy:
+        content: |
+          #!/bin/bash
+          set -e
+          cd %[2]s
+          source %[2]s/venv/bin/activate
+%[4]s          exec %[5]s
+        dest: %[2]s/start_service.sh
+        owner: azureuser
+        group: azureuser
+        mode: '0755'
+
+    - name: Create supervisor configuration for service %[1]s
+      copy:
+        content: |
+          [program:%[3]s]
+          command=%[2]s/start_service.sh
+          directory=%[2]s
+          user=azureuser
+          autostart=true
+          autorestart=true
+          stdout_logfile=/home/azureuser/logs/%[3]s-stdout.log
+          stdout_logfile_maxbytes=50MB
+          stdout_logfile_backups=5
+          stderr_logfile=/home/azureuser/logs/%[3]s-stderr.log
+          stderr_logfile_maxbytes=50MB
+          stderr_logfile_backups=5
+          killasgroup=true
+          stopasgroup=true
+          stopsignal=TERM
+          stopwaitsecs=10
+          startretries=3
+          startsecs=10
+          environment=HOME="/home/azureuser",USER="azureuser",PATH="%[2]s/venv/bin:/usr/local/bin:/usr/bin:/bin"{%% for key, value in secret_env.items() %%},{{ key }}="{{ value | replace('%%', '%%%%') | replace('"', '\\"') }}"{%% endfor %%}
+        dest: /etc/supervisor/conf.d/%[3]s.conf
+        mode: '0600'
+        backup: yes
+      no_log: "{{ secret_env | length > 0 }}"
+      notify: restart supervisor
+`, spec.Name, dir, program, envExports.String(), spec.Command))
+	}
+	return tasks.String()
+}
+
+// checkServices reads the supervisor state of the main app and every
+// service and reports it with EventServicesStatus. Programs that are not
+// running are logged as warnings; the deployment still succeeds.
+func (ds *DeploymentService) checkServices(req *DeploymentRequest, publicIP, privateKeyPath string, broadcaster types.LogBroadcaster, deploymentID string) {
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Checking service status...", "services")
+
+	output, err := runRemoteCommand(publicIP, privateKeyPath, "sudo supervisorctl status", time.Minute)
+	if err != nil && output == "" {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to read service status: %v", err), "services")
+		return
+	}
+
+	statuses := serviceStatuses(req, output)
+	for _, status := range statuses {
+		if status.State != "RUNNING" {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Service %s is %s %s", status.Name, status.State, status.Detail), "services")
+		}
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventServicesStatus, fmt.Sprintf("Service status: %s", summarizeServices(statuses)), "services",
+		map[string]interface{}{"services": statuses})
+}
+
+// serviceStatuses picks the deployment's programs out of supervisorctl
+// status output. Programs missing from the output are reported as UNKNOWN.
+func serviceStatuses(req *DeploymentRequest, output string) []store.ServiceStatus {
+	seen := make(map[string]store.ServiceStatus)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		seen[fields[0]] = store.ServiceStatus{State: fields[1], Detail: strings.Join(fields[2:], " ")}
+	}
+
+	name, _ := extractRepoName(req.RepoURL)
+	statuses := []store.ServiceStatus{{Name: name, Program: MainProgram, RepoURL: req.RepoURL}}
+	for _, spec := range req.Services {
+		statuses = append(statuses, store.ServiceStatus{Name: spec.Name, Program: ServiceProgram(spec.Name), RepoURL: spec.RepoURL})
+	}
+	for i := range statuses {
+		state, found := seen[statuses[i].Program]
+		if !found {
+			statuses[i].State = "UNKNOWN"
+			continue
+		}
+		statuses[i].State, statuses[i].Detail = state.State, state.Detail
+	}
+	return statuses
+}
+
+func summarizeServices(statuses []store.ServiceStatus) string {
+	counts := make(map[string]int)
+	for _, status := range statuses {
+		counts[status.State]++
+	}
+	states := make([]string, 0, len(counts))
+	for state := range counts {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	parts := make([]string, 0, len(states))
+	for _, state := range states {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[state], state))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+)
+
+// MinDataDiskSizeGB is the smallest data_disk_size_gb Azure accepts.
+const MinDataDiskSizeGB = 4
+
+// dataDiskDevice is the data disk's device, linked by Azure's udev rules
+// from the LUN it is attached at.
+var dataDiskDevice = fmt.Sprintf("/dev/disk/azure/scsi1/lun%d", providers.DataDiskLUN)
+
+// HasDataDisk reports whether the request's VM gets a managed data disk,
+// which only single Azure VMs do.
+func HasDataDisk(req *DeploymentRequest) bool {
+	return req.DataDiskSizeGB > 0 && Cloud(req) == CloudAzure && !req.ScaleSet && !req.Pooled
+}
+
+// generateDataDiskMountTasks formats the data disk if it is blank and mounts
+// it at providers.DataDiskMountPoint. A disk that already has a filesystem,
+// as one reattached to a rebuilt VM does, is mounted as it is.
+func generateDataDiskMountTasks(req *DeploymentRequest) string {
+	if !HasDataDisk(req) {
+		return ""
+	}
+	return `
+    - name: Wait for the data disk
+      wait_for:
+        path: ` + dataDiskDevice + `
+        timeout: 300
+
+    - name: Create a filesystem on the data disk if it has none
+      filesystem:
+        fstype: ext4
+        dev: ` + dataDiskDevice + `
+
+    - name: Read the data disk's filesystem UUID
+      command: blkid -s UUID -o value ` + dataDiskDevice + `
+      register: data_disk_uuid
+      changed_when: false
+
+    - name: Mount the data disk
+      mount:
+        path: ` + providers.DataDiskMountPoint + `
+        src: "UUID={{ data_disk_uuid.stdout }}"
+        fstype: ext4
+        opts: defaults,nofail
+        state: mounted
+`
+}
+
+// generateDataDiskRelocateTasks moves the app's media/ directory and SQLite
+// databases onto the data disk and links them back into the checkout. Files
+// already on the disk win over those in the checkout, so a redeploy or a
+// rebuilt VM keeps the uploads and data of the previous one. Django's
+// default db.sqlite3 is linked even before it exists, so migrate creates it
+// on the disk.
+func generateDataDiskRelocateTasks(req *DeploymentRequest) string {
+	if !HasDataDisk(req) {
+		return ""
+	}
+	return `
+    - name: Move media and SQLite databases onto the data disk
+      shell: |
+        set -e
+        data="` + providers.DataDiskMountPoint + `"
+        mkdir -p "$data/media" "$data/db"
+        chown azureuser:azureuser "$data/media" "$data/db"
+
+        media="{{ django_project_path }}/media"
+        if [ -d "$media" ] && [ ! -L "$media" ]; then
+          cp -an "$media/." "$data/media/"
+          rm -rf "$media"
+        fi
+        ln -sfn "$data/media" "$media"
+
+        cd "{{ django_project_path }}"
+        { echo db.sqlite3; find . -maxdepth 3 -path ./venv -prune -o -type f \( -name '*.sqlite3' -o -name '*.sqlite' \) -print; } \
+          | sed 's#^\./##' | sort -u | while read -r db; do
+          target="$data/db/$(echo "$db" | tr / _)"
+          if [ -f "$db" ] && [ ! -L "$db" ]; then
+            if [ -e "$target" ]; then
+              rm -f "$db"
+            else
+              mv "$db" "$target"
+            fi
+          fi
+          ln -sfn "$target" "$db"
+          echo "$db -> $target"
+        done
+        chown -R azureuser:azureuser "$data/media" "$data/db"
+        chown -h azureuser:azureuser "$media"
+      args:
+        executable: /bin/bash
+      register: data_disk_relocate
+
+    - name: Display data disk links
+      debug:
+        msg: "{{ data_disk_relocate.stdout_lines }}"
+`
+}
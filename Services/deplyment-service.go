@@ -1,76 +1,396 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
 	"strings"
 	"time"
 )
 
-type LogBroadcaster interface {
-	BroadcastLog(deploymentID string, logMsg LogMessage)
+// ApprovalGate blocks the pipeline until a human approves the
+// infrastructure plan, returning an error if it is rejected or ctx is
+// cancelled first.
+type ApprovalGate interface {
+	AwaitApproval(ctx context.Context, deploymentID, plan string) error
 }
 
-type LogMessage struct {
-	Level     string `json:"level"`
-	Message   string `json:"message"`
-	Timestamp string `json:"timestamp"`
-	Step      string `json:"step,omitempty"`
+type DeploymentService struct {
+	approvals ApprovalGate
+	pool      *VMPool
+	recorder  ConfigRecorder
+	// infrastructure finds the VM an app-only deployment deploys to.
+	infrastructure InfrastructureSource
+
+	// secretEnv and redactor are set from the request's secrets when a
+	// deployment starts.
+	secretEnv []string
+	redactor  *strings.Replacer
+	// ctx is the running deployment's context. Cancelling it interrupts
+	// its Terraform and Ansible commands and stops it before the next step.
+	ctx context.Context
 }
 
-type DeploymentService struct{}
+const (
+	DefaultLocation = "East US"
+	DefaultVMSize   = "Standard_B4ms"
+
+	// DefaultARM64VMSize is used when a request asks for arm64.
+	DefaultARM64VMSize = "Standard_B2ps_v2"
+
+	// DefaultGPUVMSize is used when a request asks for a GPU.
+	DefaultGPUVMSize = "Standard_NC4as_T4_v3"
+
+	// MaxDiskSizeGB caps disk_size_gb on every cloud.
+	MaxDiskSizeGB = 1024
+)
+
+// Scale set sizes. A scale set deployment runs Instances VMs, or
+// DefaultScaleSetInstances when it sets none.
+const (
+	DefaultScaleSetInstances = 2
+	MaxScaleSetInstances     = 10
+)
+
+// Clouds built into the provider registry.
+const (
+	CloudAzure        = "azure"
+	CloudAWS          = "aws"
+	CloudDigitalOcean = "digitalocean"
+	CloudHetzner      = "hetzner"
+	CloudLinode       = "linode"
+	CloudOCI          = "oci"
+
+	// CloudBYOS deploys to an existing server instead of provisioning one.
+	CloudBYOS = "byos"
+
+	// CloudAKS runs the app on an AKS cluster, or on an existing Kubernetes
+	// cluster, instead of a VM.
+	CloudAKS = "aks"
+)
+
+// AKS defaults. An AKS location is an Azure location and vm_size the size
+// of the cluster's nodes.
+const (
+	DefaultAKSNodeSize  = "Standard_D2s_v3"
+	DefaultAKSNodeCount = 2
+)
+
+// AWS defaults, sized like their Azure counterparts.
+const (
+	DefaultAWSRegion            = "us-east-1"
+	DefaultAWSInstanceType      = "t3.xlarge"
+	DefaultAWSARM64InstanceType = "t4g.medium"
+	DefaultAWSGPUInstanceType   = "g4dn.xlarge"
+
+	DefaultDigitalOceanRegion = "nyc3"
+	DefaultDigitalOceanSize   = "s-2vcpu-4gb"
+
+	DefaultHetznerLocation        = "fsn1"
+	DefaultHetznerServerType      = "cx22"
+	DefaultHetznerARM64ServerType = "cax11"
+
+	DefaultLinodeRegion = "us-east"
+	DefaultLinodeType   = "g6-standard-2"
+
+	// OCI defaults to the Always Free Ampere shape; x64 requests get the
+	// Always Free micro shape.
+	DefaultOCIRegion   = "us-ashburn-1"
+	DefaultOCIShape    = "VM.Standard.A1.Flex"
+	DefaultOCIX64Shape = "VM.Standard.E2.1.Micro"
+)
 
 type DeploymentRequest struct {
-	RepoURL            string            `json:"repo_url"`
-	GithubToken        string            `json:"github_token"`
-	Username           string            `json:"username"`
-	AdditionalCommands []string          `json:"additional_commands"`
-	EnvVariables       map[string]string `json:"env_variables"`
-	ASGI               bool              `json:"asgi"`
-	AutoDeploy         bool              `json:"auto_deploy"`
+	RepoURL     string `json:"repo_url"`
+	Provider    string `json:"provider,omitempty"`
+	Cloud       string `json:"cloud,omitempty"` // older name of provider
+	GithubToken string `json:"github_token"`
+	Username    string `json:"username"`
+	// Organization and Team deploy on behalf of a team, using the
+	// organization's shared credentials and counting against the team's
+	// quota.
+	Organization         string            `json:"organization,omitempty"`
+	Team                 string            `json:"team,omitempty"`
+	AdditionalCommands   []string          `json:"additional_commands"`
+	EnvVariables         map[string]string `json:"env_variables"`
+	EnvFile              string            `json:"env_file,omitempty"`
+	Secrets              map[string]string `json:"secrets,omitempty"`
+	RequiredEnv          []string          `json:"required_env,omitempty"`
+	ASGI                 bool              `json:"asgi"`
+	AutoDeploy           bool              `json:"auto_deploy"`
+	MaxMonthlyBudget     float64           `json:"max_monthly_budget"`
+	BudgetOverride       bool              `json:"budget_override"`
+	ApprovalRequired     bool              `json:"approval_required"`
+	Architecture         string            `json:"architecture"`
+	GPU                  bool              `json:"gpu"`
+	InstallCUDA          bool              `json:"install_cuda"`
+	SnapshotBeforeDeploy bool              `json:"snapshot_before_deploy"`
+	Location             string            `json:"location,omitempty"`
+	// FallbackLocations are tried in order when Location has no capacity
+	// for the VM; see FallbackLocations.
+	FallbackLocations []string `json:"fallback_locations"`
+	SubscriptionID    string   `json:"subscription_id,omitempty"`
+	// AzureCredentials authenticate to Azure instead of the server's
+	// credentials; see providers.AzureCredentialsFromEnv.
+	AzureCredentials *providers.AzureCredentials `json:"azure_credentials,omitempty"`
+	// AzureBackend is the backend an Azure deployment was provisioned
+	// with, recorded when it starts so later operations on it use the same
+	// one; see AzureBackend.
+	AzureBackend      string `json:"azure_backend,omitempty"`
+	RestoreSnapshotID string `json:"restore_snapshot_id,omitempty"`
+	Environment       string `json:"environment,omitempty"`
+	// Priority is the deployment's queue class, PriorityHigh,
+	// PriorityNormal or PriorityLow; see Priority for the default.
+	Priority           string  `json:"priority,omitempty"`
+	VMSize             string  `json:"vm_size,omitempty"`
+	DiskSizeGB         int     `json:"disk_size_gb,omitempty"`
+	DataDiskSizeGB     int     `json:"data_disk_size_gb,omitempty"`
+	UseSpot            bool    `json:"use_spot"`
+	SpotMaxPrice       float64 `json:"spot_max_price,omitempty"`
+	SpotEvictionPolicy string  `json:"spot_eviction_policy,omitempty"`
+	Image              string  `json:"image,omitempty"`
+	StaticIP           bool    `json:"static_ip"`
+	// ExistingResourceGroup, SubnetID and PrivateNetworking place an Azure
+	// VM in an existing landing zone.
+	ExistingResourceGroup string `json:"existing_resource_group,omitempty"`
+	SubnetID              string `json:"subnet_id,omitempty"`
+	PrivateNetworking     bool   `json:"private_networking"`
+	// SSHAllowedCIDRs restricts SSH to an Azure VM to these CIDRs or
+	// addresses, and this server. The API defaults it to the caller's
+	// address.
+	SSHAllowedCIDRs []string   `json:"ssh_allowed_cidrs,omitempty"`
+	OpenPorts       []OpenPort `json:"open_ports,omitempty"`
+	// AutoShutdown is the Azure VM's daily shutdown time as HH:MM, or
+	// "off"; see AutoShutdown for the default.
+	AutoShutdown         string           `json:"auto_shutdown,omitempty"`
+	AutoShutdownTimezone string           `json:"auto_shutdown_timezone,omitempty"`
+	Pooled               bool             `json:"pooled"`
+	StartCommand         string           `json:"start_command,omitempty"`
+	URLPrefix            string           `json:"url_prefix,omitempty"`
+	Domains              []string         `json:"domains,omitempty"`
+	Hooks                []LifecycleHook  `json:"hooks,omitempty"`
+	LogSinks             []LogSink        `json:"log_sinks,omitempty"`
+	AnsibleIncludes      []AnsibleInclude `json:"ansible_includes,omitempty"`
+	Services             []ServiceSpec    `json:"services,omitempty"`
+	PythonVersion        string           `json:"python_version,omitempty"`
+	Redis                bool             `json:"redis"`
+	ScaleSet             bool             `json:"scale_set"`
+	Instances            int              `json:"instances,omitempty"`
+	ServerHost           string           `json:"server_host,omitempty"`
+	ServerUser           string           `json:"server_user,omitempty"`
+	ServerSSHKey         string           `json:"server_ssh_key,omitempty"`
+	Kubeconfig           string           `json:"kubeconfig,omitempty"`
+	ContainerRegistry    string           `json:"container_registry,omitempty"`
+	// Mode is ModeFull, ModeInfraOnly or ModeAppOnly; see Mode for the
+	// default. An app-only deployment deploys to the VM of the infra-only
+	// deployment InfraDeploymentID.
+	Mode              string `json:"mode,omitempty"`
+	InfraDeploymentID string `json:"infra_deployment_id,omitempty"`
 }
 
 func NewDeploymentService() *DeploymentService {
 	return &DeploymentService{}
 }
 
-func (ds *DeploymentService) broadcastLog(broadcaster LogBroadcaster, deploymentID, level, message, step string) {
+func (ds *DeploymentService) SetApprovalGate(approvals ApprovalGate) {
+	ds.approvals = approvals
+}
+
+func (ds *DeploymentService) SetPool(pool *VMPool) {
+	ds.pool = pool
+}
+
+// resourcePrefix is the user-repo[-environment] stem shared by a
+// deployment's Azure resource names.
+func resourcePrefix(req *DeploymentRequest) (string, error) {
+	repoName, err := extractRepoName(req.RepoURL)
+	if err != nil {
+		return "", err
+	}
+	if req.Environment != "" {
+		return fmt.Sprintf("%s-%s-%s", req.Username, repoName, req.Environment), nil
+	}
+	return fmt.Sprintf("%s-%s", req.Username, repoName), nil
+}
+
+// ResourceGroupName returns the Azure resource group a request deploys into.
+func ResourceGroupName(req *DeploymentRequest) (string, error) {
+	if req.ExistingResourceGroup != "" {
+		return req.ExistingResourceGroup, nil
+	}
+	prefix, err := resourcePrefix(req)
+	if err != nil {
+		return "", err
+	}
+	if location := Location(req); location != DefaultLocation {
+		return fmt.Sprintf("%s-%s-rg", prefix, strings.ToLower(strings.ReplaceAll(location, " ", ""))), nil
+	}
+	return prefix + "-rg", nil
+}
+
+// Cloud returns the cloud a request deploys to: its provider field, or the
+// older cloud field, or Azure.
+func Cloud(req *DeploymentRequest) string {
+	if req.Provider != "" {
+		return req.Provider
+	}
+	if req.Cloud != "" {
+		return req.Cloud
+	}
+	return CloudAzure
+}
+
+// Location returns the region a request deploys into.
+func Location(req *DeploymentRequest) string {
+	if req.Location != "" {
+		return req.Location
+	}
+	if registration, ok := lookupProvider(Cloud(req)); ok {
+		return registration.DefaultLocation
+	}
+	return DefaultLocation
+}
+
+// AzureBackend returns the backend the request's deployment was
+// provisioned with, or, for one from before backends were recorded, the
+// one operators chose with AZURE_BACKEND.
+func AzureBackend(req *DeploymentRequest) string {
+	if providers.ValidAzureBackend(req.AzureBackend) {
+		return req.AzureBackend
+	}
+	return providers.AzureBackendFromEnv()
+}
+
+// ScaleSetInstances returns the number of VMs a scale set request runs.
+func ScaleSetInstances(req *DeploymentRequest) int {
+	if req.Instances > 0 {
+		return req.Instances
+	}
+	return DefaultScaleSetInstances
+}
+
+// VMName returns the VM name a request deploys.
+func VMName(req *DeploymentRequest) (string, error) {
+	prefix, err := resourcePrefix(req)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "-vm", nil
+}
+
+// VMSize returns the requested VM size, or the default for the requested
+// cloud, architecture and GPU option. On AWS it is an EC2 instance type, on
+// DigitalOcean a droplet size, on Hetzner a server type, on Linode an
+// instance type, on OCI a shape and on AKS the size of the cluster's nodes.
+func VMSize(req *DeploymentRequest) string {
+	if req.VMSize != "" {
+		return req.VMSize
+	}
+	if registration, ok := lookupProvider(Cloud(req)); ok && registration.DefaultVMSize != nil {
+		return registration.DefaultVMSize(req)
+	}
+	return DefaultVMSize
+}
+
+// EstimateMonthlyCost prices the resources a request would provision. Only
+// Azure pricing is known.
+func EstimateMonthlyCost(req *DeploymentRequest) (*providers.CostEstimate, error) {
+	if Cloud(req) != CloudAzure {
+		return nil, fmt.Errorf("no pricing data for %s", Cloud(req))
+	}
+	azure := providers.AzureProvider{
+		Location:           Location(req),
+		VMSize:             VMSize(req),
+		DiskSizeGB:         req.DiskSizeGB,
+		DataDiskSizeGB:     req.DataDiskSizeGB,
+		Spot:               req.UseSpot,
+		SpotMaxPrice:       req.SpotMaxPrice,
+		SpotEvictionPolicy: req.SpotEvictionPolicy,
+	}
+	if req.ScaleSet {
+		azure.ScaleSet = true
+		azure.Instances = ScaleSetInstances(req)
+	}
+	return azure.EstimateMonthlyCost()
+}
+
+func (ds *DeploymentService) broadcastLog(broadcaster types.LogBroadcaster, deploymentID, level, message, step string) {
+	ds.broadcastEvent(broadcaster, deploymentID, level, "", message, step, nil)
+}
+
+// broadcastEvent is broadcastLog for milestone messages with an event code.
+func (ds *DeploymentService) broadcastEvent(broadcaster types.LogBroadcaster, deploymentID, level, code, message, step string, data map[string]interface{}) {
 	if broadcaster != nil {
-		broadcaster.BroadcastLog(deploymentID, LogMessage{
+		broadcaster.BroadcastLog(deploymentID, types.LogMessage{
 			Level:     level,
-			Message:   message,
+			Message:   ds.redact(message),
 			Timestamp: time.Now().Format(time.RFC3339),
 			Step:      step,
+			Code:      code,
+			Data:      data,
 		})
 	}
 }
 
-func (ds *DeploymentService) Deploy(req *DeploymentRequest, deploymentID string, broadcaster LogBroadcaster) (string, error) {
+// Deploy provisions and configures the request's app and returns its
+// address. Failures are returned as a *types.DeploymentError, with the
+// ErrCodeCancelled code if ctx was cancelled. Secret values are redacted
+// from its logs and returned error.
+func (ds *DeploymentService) Deploy(ctx context.Context, req *DeploymentRequest, deploymentID string, broadcaster types.LogBroadcaster) (string, error) {
+	ds.ctx = ctx
+	ds.secretEnv = secretProcessEnv(req.Secrets)
+	ds.redactor = newSecretRedactor(redactedSecrets(req))
+
+	publicIP, err := ds.deploy(req, deploymentID, broadcaster)
+	if err == nil {
+		return publicIP, nil
+	}
+	if ctx.Err() != nil && !Cancelled(err) {
+		err = cancelledError(err)
+	}
+
+	return "", ds.redactError(err, "deploy")
+}
+
+func (ds *DeploymentService) deploy(req *DeploymentRequest, deploymentID string, broadcaster types.LogBroadcaster) (_ string, deployErr error) {
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Extracting repository name...", "setup")
 
 	repoName, err := extractRepoName(req.RepoURL)
 	if err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to extract repo name: %v", err), "setup")
-		return "", fmt.Errorf("failed to extract repo name: %v", err)
+		return "", types.NewDeploymentError("setup", types.ErrCodeInvalidRequest, false, err, "failed to extract repo name")
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Repository name: %s", repoName), "setup")
 
-	basePath := filepath.Join("deployments", req.Username, repoName)
+	if err := ds.checkRequiredEnv(req, broadcaster, deploymentID); err != nil {
+		return "", err
+	}
+
+	basePath := filepath.Join(WorkspaceDir, req.Username, repoName)
 	timestamp := time.Now().Format("20060102-150405")
 	workDir := filepath.Join(basePath, timestamp)
+	terraformDir := filepath.Join(workDir, "terraform")
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Creating deployment directory: %s", workDir), "setup")
 
-	if err := os.MkdirAll(workDir, 0755); err != nil {
+	release, err := claimWorkDir(workDir)
+	if errors.Is(err, errWorkspaceFull) {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Not enough workspace disk: %v", err), "setup")
+		return "", types.NewDeploymentError("setup", types.ErrCodeWorkspaceQuota, true, err, "workspace quota exceeded")
+	}
+	if err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to create work directory: %v", err), "setup")
-		return "", fmt.Errorf("failed to create work directory: %v", err)
+		return "", types.NewDeploymentError("setup", types.ErrCodeWorkspace, false, err, "failed to create work directory")
 	}
+	defer release()
 
 	defer func() {
+		ds.keepWorkspace(terraformDir, broadcaster, deploymentID)
 		ds.broadcastLog(broadcaster, deploymentID, "info", "Cleaning up deployment directory...", "cleanup")
 		if err := os.RemoveAll(workDir); err != nil {
 			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to cleanup directory %s: %v", workDir, err), "cleanup")
@@ -78,111 +398,170 @@ func (ds *DeploymentService) Deploy(req *DeploymentRequest, deploymentID string,
 			ds.broadcastLog(broadcaster, deploymentID, "info", "Cleanup completed successfully", "cleanup")
 		}
 	}()
+	// Deferred after the cleanup, so it runs first and cleanup playbooks
+	// still find the inventory and SSH key.
+	defer func() {
+		if deployErr != nil {
+			ds.runFailureHooks(req, deploymentID, workDir, deployErr, broadcaster)
+		}
+	}()
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Creating terraform directory...", "setup")
-	terraformDir := filepath.Join(workDir, "terraform")
 	if err := os.MkdirAll(terraformDir, 0755); err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to create terraform directory: %v", err), "setup")
-		return "", fmt.Errorf("failed to create terraform directory: %v", err)
+		return "", types.NewDeploymentError("setup", types.ErrCodeWorkspace, false, err, "failed to create terraform directory")
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Creating ansible directory...", "setup")
 	ansibleDir := filepath.Join(workDir, "ansible")
 	if err := os.MkdirAll(ansibleDir, 0755); err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to create ansible directory: %v", err), "setup")
-		return "", fmt.Errorf("failed to create ansible directory: %v", err)
+		return "", types.NewDeploymentError("setup", types.ErrCodeWorkspace, false, err, "failed to create ansible directory")
 	}
 
-	azure := providers.AzureProvider{
-		ResourceGroup: fmt.Sprintf("%s-%s-rg", req.Username, repoName),
-		Location:      "East US",
-		VMSize:        "Standard_B4ms",
-		VMName:        fmt.Sprintf("%s-%s-vm", req.Username, repoName),
+	if err := ds.runHooks(req, HookPreProvision, deploymentID, workDir, "", broadcaster); err != nil {
+		return "", err
+	}
+
+	if req.Pooled {
+		return ds.deployPooled(req, deploymentID, workDir, broadcaster)
 	}
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Generating SSH keys...", "ssh")
-	_, _, err = azure.GenerateSSHKeys(terraformDir)
+	cloud, lockName, err := newCloudProvider(req)
 	if err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to generate SSH keys: %v", err), "ssh")
-		return "", fmt.Errorf("failed to generate SSH keys: %v", err)
+		return "", types.NewDeploymentError("setup", types.ErrCodeInvalidRequest, false, err, "failed to derive resource names")
 	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "SSH keys generated successfully", "ssh")
+	cloud.SetContext(ds.ctx)
+	// Snapshots and data restores are Azure-only.
+	azure, _ := cloud.(*providers.AzureProvider)
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Generating Terraform configuration...", "terraform")
-	if err := azure.GenerateTerraformConfig(terraformDir); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to generate terraform config: %v", err), "terraform")
-		return "", fmt.Errorf("failed to generate terraform config: %v", err)
+	if cluster, ok := cloud.(*providers.AKSProvider); ok {
+		return ds.deployKubernetes(req, cluster, lockName, workDir, terraformDir, broadcaster, deploymentID)
 	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "Terraform configuration generated", "terraform")
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Initializing Terraform...", "terraform")
-	if err := azure.InitTerraform(terraformDir); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to initialize terraform: %v", err), "terraform")
-		return "", fmt.Errorf("failed to initialize terraform: %v", err)
+	mode := Mode(req)
+	if mode != ModeInfraOnly {
+		if err := ds.checkPlaybook(req, workDir, broadcaster, deploymentID); err != nil {
+			return "", err
+		}
 	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "Terraform initialized successfully", "terraform")
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Applying Terraform (this may take a few minutes)...", "terraform")
-	if err := azure.ApplyTerraform(terraformDir); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to apply terraform: %v", err), "terraform")
-		return "", fmt.Errorf("failed to apply terraform: %v", err)
+	if mode != ModeAppOnly {
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Generating SSH keys...", "ssh")
+		_, _, err = cloud.GenerateSSHKeys(terraformDir)
+		if err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to generate SSH keys: %v", err), "ssh")
+			return "", types.NewDeploymentError("ssh", types.ErrCodeSSHKeys, true, err, "failed to generate SSH keys")
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "success", "SSH keys generated successfully", "ssh")
 	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "Terraform applied successfully", "terraform")
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Retrieving public IP address...", "network")
-	publicIP, err := azure.GetTerraformOutput(terraformDir, "public_ip")
-	if err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to get public IP: %v", err), "network")
-		return "", fmt.Errorf("failed to get public IP: %v", err)
+	var publicIP string
+	server, existing := cloud.(*providers.BYOSProvider)
+	if mode == ModeAppOnly {
+		publicIP, err = ds.useInfrastructure(req, terraformDir, broadcaster, deploymentID)
+		if err != nil {
+			return "", err
+		}
+		existing = true
+	} else if existing {
+		if err := ds.prepareExistingServer(server, terraformDir, broadcaster, deploymentID); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to prepare server %s: %v", server.Host, err), "ssh")
+			return "", types.NewDeploymentError("ssh", types.ErrCodeSSHUnreachable, true, err, "failed to prepare existing server")
+		}
+		publicIP = server.Host
+	} else {
+		publicIP, err = ds.provision(req, cloud, lockName, azure, terraformDir, broadcaster, deploymentID)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	publicIP = strings.TrimSpace(publicIP)
 	if publicIP == "" {
 		ds.broadcastLog(broadcaster, deploymentID, "error", "Public IP is empty", "network")
-		return "", fmt.Errorf("public IP is empty")
+		return "", types.NewDeploymentError("network", types.ErrCodeTerraformOutput, true, nil, "public IP is empty")
+	}
+
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventPublicIPAssigned, fmt.Sprintf("Retrieved public IP: %s", publicIP), "network",
+		map[string]interface{}{"public_ip": publicIP})
+
+	// A scale set is reached through its load balancer at publicIP, while
+	// the playbook runs on every instance over the instance's own address.
+	hosts := []string{publicIP}
+	if req.ScaleSet {
+		hosts, err = ds.scaleSetHosts(cloud, terraformDir, broadcaster, deploymentID)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Retrieved public IP: %s", publicIP), "network")
+	if err := ds.checkCancelled(); err != nil {
+		return "", err
+	}
+	if err := ds.runHooks(req, HookPostProvision, deploymentID, workDir, publicIP, broadcaster); err != nil {
+		return "", err
+	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Verifying SSH keys...", "ssh")
-	azurePrivateKeyPath := filepath.Join(terraformDir, "azure_vm_key")
-	azurePublicKeyPath := filepath.Join(terraformDir, "azure_vm_key.pub")
+	privateKeyPath := filepath.Join(terraformDir, "azure_vm_key")
+	publicKeyPath := filepath.Join(terraformDir, "azure_vm_key.pub")
 
-	if _, err := os.Stat(azurePrivateKeyPath); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Private key not found at %s: %v", azurePrivateKeyPath, err), "ssh")
-		return "", fmt.Errorf("Private key not found at %s: %v", azurePrivateKeyPath, err)
+	if _, err := os.Stat(privateKeyPath); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Private key not found at %s: %v", privateKeyPath, err), "ssh")
+		return "", types.NewDeploymentError("ssh", types.ErrCodeSSHKeys, false, err, fmt.Sprintf("Private key not found at %s", privateKeyPath))
 	}
-	if _, err := os.Stat(azurePublicKeyPath); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Public key not found at %s: %v", azurePublicKeyPath, err), "ssh")
-		return "", fmt.Errorf("Public key not found at %s: %v", azurePublicKeyPath, err)
+	if _, err := os.Stat(publicKeyPath); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Public key not found at %s: %v", publicKeyPath, err), "ssh")
+		return "", types.NewDeploymentError("ssh", types.ErrCodeSSHKeys, false, err, fmt.Sprintf("Public key not found at %s", publicKeyPath))
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "success", "SSH keys verified successfully", "ssh")
 
+	if mode == ModeInfraOnly {
+		if err := ds.awaitSSH(hosts, privateKeyPath, existing, broadcaster, deploymentID); err != nil {
+			return "", err
+		}
+		ds.reportHostKey(hosts[0], privateKeyPath, broadcaster, deploymentID)
+		ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Infrastructure is ready at %s; deploy the app to it with mode %s", publicIP, ModeAppOnly), "completed")
+		return publicIP, nil
+	}
+
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Creating Ansible configuration files...", "ansible")
-	if err := ds.createAnsibleFiles(ansibleDir, req, publicIP, azurePrivateKeyPath); err != nil {
+	if err := ds.createAnsibleFiles(ansibleDir, req, publicIP, hosts, privateKeyPath); err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to create ansible files: %v", err), "ansible")
-		return "", fmt.Errorf("failed to create ansible files: %v", err)
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to create ansible files")
 	}
 	ds.broadcastLog(broadcaster, deploymentID, "success", "Ansible files created successfully", "ansible")
+	ds.recordPlaybook(req, publicIP, broadcaster, deploymentID)
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Waiting for VM to be ready (60 seconds)...", "vm")
-	time.Sleep(60 * time.Second)
+	if err := ds.awaitSSH(hosts, privateKeyPath, existing, broadcaster, deploymentID); err != nil {
+		return "", err
+	}
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Testing SSH connectivity...", "ssh")
-	if err := ds.testSSHConnectivity(publicIP, azurePrivateKeyPath, broadcaster, deploymentID); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("SSH connectivity test failed, but continuing: %v", err), "ssh")
-		time.Sleep(30 * time.Second)
-	} else {
-		ds.broadcastLog(broadcaster, deploymentID, "success", "SSH connectivity test passed", "ssh")
+	if err := ds.checkCancelled(); err != nil {
+		return "", err
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventAnsibleStarted, "Running Ansible playbook (this may take several minutes)...", "ansible", nil)
+	if err := ds.runAnsiblePlaybook(ansibleDir, broadcaster, deploymentID); err != nil {
+		ds.broadcastEvent(broadcaster, deploymentID, "error", EventAnsibleFailed, fmt.Sprintf("Failed to run ansible playbook: %v", err), "ansible", nil)
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsiblePlaybook, false, err, "failed to run ansible playbook")
 	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventAnsibleSucceeded, "Ansible playbook execution completed successfully", "ansible", nil)
+	ds.reportHostKey(hosts[0], privateKeyPath, broadcaster, deploymentID)
+	ds.reportRevision(hosts[0], privateKeyPath, broadcaster, deploymentID)
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Running Ansible playbook (this may take several minutes)...", "ansible")
-	if err := ds.runAnsiblePlaybook(ansibleDir); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to run ansible playbook: %v", err), "ansible")
-		return "", fmt.Errorf("failed to run ansible playbook: %v", err)
+	if len(req.Services) > 0 {
+		ds.checkServices(req, hosts[0], privateKeyPath, broadcaster, deploymentID)
+	}
+
+	if req.RestoreSnapshotID != "" && azure != nil {
+		if err := ds.restoreAppData(azure, req.RestoreSnapshotID, publicIP, privateKeyPath, broadcaster, deploymentID); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to restore application data: %v", err), "restore")
+			return "", types.NewDeploymentError("restore", types.ErrCodeRestore, true, err, "failed to restore application data")
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "success", "Application data restored from backup", "restore")
 	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "Ansible playbook execution completed successfully", "ansible")
 
 	if req.AutoDeploy {
 		ds.broadcastLog(broadcaster, deploymentID, "info", "Setting up GitHub Actions auto-deployment...", "github")
@@ -198,26 +577,216 @@ func (ds *DeploymentService) Deploy(req *DeploymentRequest, deploymentID string,
 		} else {
 			ds.broadcastLog(broadcaster, deploymentID, "success", "Additional GitHub Actions tasks completed", "github")
 		}
+
+		ds.recordGitHubDeployment(req, publicIP, broadcaster, deploymentID)
+	}
+
+	if err := ds.runHooks(req, HookPostDeploy, deploymentID, workDir, publicIP, broadcaster); err != nil {
+		return "", err
+	}
+
+	if len(req.Domains) > 0 && !ds.verifyDomains(req, publicIP, broadcaster, deploymentID) {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Deployment completed at http://%s, but its domains are not serving it yet", publicIP), "completed")
+		return publicIP, nil
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "success", "Deployment completed successfully!", "completed")
 	return publicIP, nil
 }
 
-func (ds *DeploymentService) testSSHConnectivity(publicIP, privateKeyPath string, broadcaster LogBroadcaster, deploymentID string) error {
-	cmd := exec.Command("ssh",
-		"-i", privateKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "ConnectTimeout=10",
-		fmt.Sprintf("azureuser@%s", publicIP),
-		"echo 'SSH test successful'")
+// provision creates the request's VM with Terraform and returns its public
+// IP. The Terraform lock on lockName is held until the IP is read, except
+// while the plan waits for approval. An Azure
+// VM whose region has no capacity is created in the request's fallback
+// locations instead, in order.
+func (ds *DeploymentService) provision(req *DeploymentRequest, cloud providers.CloudProvider, lockName string, azure *providers.AzureProvider, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	lock := &terraformLock{name: lockName, unlock: ds.lockTerraform(lockName, broadcaster, deploymentID)}
+	defer func() { lock.unlock() }()
+
+	err := ds.applyTerraform(req, cloud, azure, lock, terraformDir, broadcaster, deploymentID)
+	if azure != nil {
+		for _, location := range FallbackLocations(req) {
+			if !capacityUnavailable(err) {
+				break
+			}
+			err = ds.applyInLocation(req, azure, location, lock, terraformDir, err, broadcaster, deploymentID)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
 
-	output, err := cmd.CombinedOutput()
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Retrieving public IP address...", "network")
+	publicIP, err := cloud.GetOutput(terraformDir, "public_ip")
 	if err != nil {
-		return fmt.Errorf("SSH test failed: %v, output: %s", err, string(output))
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to get public IP: %v", err), "network")
+		return "", types.NewDeploymentError("network", types.ErrCodeTerraformOutput, true, err, "failed to get public IP")
+	}
+
+	return publicIP, nil
+}
+
+// lockTerraform waits for the Terraform lock on lockName and returns its
+// unlock function.
+func (ds *DeploymentService) lockTerraform(lockName string, broadcaster types.LogBroadcaster, deploymentID string) func() {
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Acquiring Terraform lock for %s...", lockName), "terraform")
+	return providers.LockResourceGroup(lockName, deploymentID, func(holder string) {
+		ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Waiting for Terraform operation %s on %s to finish...", holder, lockName), "terraform")
+	})
+}
+
+// terraformLock is a held Terraform lock, which applyTerraform lets go of
+// while it waits for approval.
+type terraformLock struct {
+	name   string
+	unlock func()
+}
+
+// applyTerraform generates, initializes and applies the provider's
+// configuration, waiting for approval of its plan if the request asks for
+// it. The caller holds lock.
+func (ds *DeploymentService) applyTerraform(req *DeploymentRequest, cloud providers.CloudProvider, azure *providers.AzureProvider, lock *terraformLock, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	if req.SnapshotBeforeDeploy && azure != nil {
+		ds.snapshotBeforeDeploy(azure, "pre-deploy-"+deploymentID, broadcaster, deploymentID)
 	}
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("SSH test output: %s", string(output)), "ssh")
+	if azure != nil && len(req.SSHAllowedCIDRs) > 0 && !req.PrivateNetworking {
+		if _, err := ControllerAddress(); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("SSH is limited to %s, but %v; set CONTROLLER_ADDRESS if Ansible cannot connect", strings.Join(req.SSHAllowedCIDRs, ", "), err), "network")
+		}
+	}
+
+	if azure != nil && azure.StaticIPName != "" {
+		if _, err := azure.ReserveStaticIP(); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to reserve static public IP: %v", err), "network")
+			return types.NewDeploymentError("network", types.ErrCodeStaticIP, true, err, "failed to reserve static public IP")
+		}
+	}
+
+	if azure != nil && azure.DataDiskName != "" {
+		if err := azure.ReserveDataDisk(); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to create data disk: %v", err), "storage")
+			return types.NewDeploymentError("storage", types.ErrCodeDataDisk, true, err, "failed to create data disk")
+		}
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Generating Terraform configuration...", "terraform")
+	if err := cloud.GenerateTerraformConfig(terraformDir); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to generate terraform config: %v", err), "terraform")
+		return types.NewDeploymentError("terraform", types.ErrCodeTerraformConfig, false, err, "failed to generate terraform config")
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Terraform configuration generated", "terraform")
+	ds.recordTerraform(cloud, terraformDir, broadcaster, deploymentID)
+
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventTFInitStarted, "Initializing Terraform...", "terraform", nil)
+	if err := cloud.InitTerraform(terraformDir); err != nil {
+		ds.broadcastEvent(broadcaster, deploymentID, "error", EventTFInitFailed, fmt.Sprintf("Failed to initialize terraform: %v", err), "terraform", nil)
+		return types.NewDeploymentError("terraform", types.ErrCodeTerraformInit, true, err, "failed to initialize terraform")
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Terraform initialized successfully", "terraform")
+
+	if req.ApprovalRequired {
+		if ds.approvals == nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", "Approval required but no approval gate is configured", "approval")
+			return types.NewDeploymentError("approval", types.ErrCodeApprovalUnavailable, false, nil, "approval required but no approval gate is configured")
+		}
+
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Generating Terraform plan for approval...", "terraform")
+		plan, err := cloud.PlanTerraform(terraformDir)
+		if err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to plan terraform: %v", err), "terraform")
+			return types.NewDeploymentError("terraform", types.ErrCodeTerraformPlan, true, err, "failed to plan terraform")
+		}
+
+		// Approval can take hours, so Terraform does not hold the lock
+		// meanwhile: it refuses to apply the saved plan if another
+		// operation changed the state in the meantime. The SDK and Pulumi
+		// backends save no plan and provision afresh once approved, so
+		// they keep the lock for what they apply to be what was reviewed.
+		release := azure == nil || azure.UsesTerraform()
+		if release {
+			lock.unlock()
+		}
+		if err := ds.approvals.AwaitApproval(ds.context(), deploymentID, plan); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Infrastructure plan not approved: %v", err), "approval")
+			return types.NewDeploymentError("approval", types.ErrCodeApprovalRejected, false, err, "infrastructure plan not approved")
+		}
+		if release {
+			lock.unlock = ds.lockTerraform(lock.name, broadcaster, deploymentID)
+		}
+
+		ds.broadcastEvent(broadcaster, deploymentID, "info", EventTFApplyStarted, "Applying approved Terraform plan (this may take a few minutes)...", "terraform", nil)
+		if err := cloud.ApplyTerraformPlan(terraformDir); err != nil {
+			ds.broadcastEvent(broadcaster, deploymentID, "error", EventTFApplyFailed, fmt.Sprintf("Failed to apply terraform: %v", err), "terraform", nil)
+			return types.NewDeploymentError("terraform", types.ErrCodeTerraformApply, true, err, "failed to apply terraform")
+		}
+	} else {
+		ds.broadcastEvent(broadcaster, deploymentID, "info", EventTFApplyStarted, "Applying Terraform (this may take a few minutes)...", "terraform", nil)
+		if err := cloud.ApplyTerraform(terraformDir); err != nil {
+			ds.broadcastEvent(broadcaster, deploymentID, "error", EventTFApplyFailed, fmt.Sprintf("Failed to apply terraform: %v", err), "terraform", nil)
+			return types.NewDeploymentError("terraform", types.ErrCodeTerraformApply, true, err, "failed to apply terraform")
+		}
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventTFApplySucceeded, "Terraform applied successfully", "terraform", nil)
+	if azure != nil {
+		ds.recordState(terraformDir, broadcaster, deploymentID)
+	}
+	if azure != nil && !req.ScaleSet {
+		if shutdown := AutoShutdownSummary(req); shutdown == AutoShutdownOff {
+			ds.broadcastLog(broadcaster, deploymentID, "info", "Auto-shutdown is off", "terraform")
+		} else {
+			ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("The VM shuts down daily at %s; set auto_shutdown to change or disable it", shutdown), "terraform")
+		}
+	}
+	return nil
+}
+
+// awaitSSH gives a new VM time to boot, unless the server already existed,
+// and tests SSH to each host, which pins its host key. A failed test only
+// delays the run; Ansible retries the connection itself.
+func (ds *DeploymentService) awaitSSH(hosts []string, privateKeyPath string, existing bool, broadcaster types.LogBroadcaster, deploymentID string) error {
+	if !existing {
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Waiting for VM to be ready (60 seconds)...", "vm")
+		if err := ds.sleep(60 * time.Second); err != nil {
+			return err
+		}
+	}
+
+	for _, host := range hosts {
+		ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Testing SSH connectivity to %s...", host), "ssh")
+		if err := ds.testSSHConnectivity(host, privateKeyPath, broadcaster, deploymentID); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("SSH connectivity test failed, but continuing: %v", err), "ssh")
+			if err := ds.sleep(30 * time.Second); err != nil {
+				return err
+			}
+		} else {
+			ds.broadcastLog(broadcaster, deploymentID, "success", "SSH connectivity test passed", "ssh")
+		}
+	}
 	return nil
 }
+
+func (ds *DeploymentService) testSSHConnectivity(publicIP, privateKeyPath string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	output, err := runRemoteCommand(publicIP, privateKeyPath, "echo 'SSH test successful'", 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("SSH test failed: %v", err)
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("SSH test output: %s", output), "ssh")
+	return nil
+}
+
+// snapshotBeforeDeploy snapshots the existing VM's OS disk as name before
+// it is changed. A first deployment has nothing to snapshot.
+func (ds *DeploymentService) snapshotBeforeDeploy(azure *providers.AzureProvider, name string, broadcaster types.LogBroadcaster, deploymentID string) {
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Snapshotting existing VM before changing it...", "snapshot")
+	snapshot, err := azure.CreateSnapshot(name, deploymentID)
+	switch {
+	case errors.Is(err, providers.ErrVMNotFound):
+		ds.broadcastLog(broadcaster, deploymentID, "info", "No existing VM, skipping snapshot", "snapshot")
+	case err != nil:
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to snapshot existing VM, continuing: %v", err), "snapshot")
+	default:
+		ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Created snapshot %s", snapshot.Name), "snapshot")
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v74/github"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// DestroyOptions choose what Destroy leaves behind.
+type DestroyOptions struct {
+	// PublicIP is the address the deployment was served on, which its
+	// auto-deploy workflow targets.
+	PublicIP string
+	// KeepDataDisk keeps the VM's data disk and the data on it.
+	KeepDataDisk bool
+	// KeepGitHub leaves the auto-deploy workflow and its secrets in the
+	// repository.
+	KeepGitHub bool
+}
+
+// Destroy tears down what a deployment provisioned, from the Terraform
+// directory kept after its run, and removes its auto-deploy workflow and
+// secrets from the repository. A reserved static IP and a data disk are
+// kept out of the Terraform state so they outlive the VM; they are deleted
+// here too.
+func (ds *DeploymentService) Destroy(req *DeploymentRequest, terraformDir string, opts DestroyOptions, broadcaster types.LogBroadcaster, deploymentID string) error {
+	cloud, lockName, err := newCloudProvider(req)
+	if err != nil {
+		return types.NewDeploymentError("destroy", types.ErrCodeInvalidRequest, false, err, "failed to derive resource names")
+	}
+	cloud.SetLogger(broadcaster, deploymentID)
+	if _, err := os.Stat(terraformDir); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Terraform directory is missing: %v", err), "destroy")
+		return types.NewDeploymentError("destroy", types.ErrCodeWorkspace, false, err, "terraform directory is missing")
+	}
+	// A deployment from before backends were recorded is destroyed with
+	// the backend whose state its directory holds, so a Pulumi stack or
+	// SDK deployment is not left running after AZURE_BACKEND changed.
+	if azure, ok := cloud.(*providers.AzureProvider); ok && req.AzureBackend == "" {
+		if backend := providers.WorkspaceAzureBackend(terraformDir); backend != "" {
+			azure.Backend = backend
+		}
+	}
+
+	unlock := ds.lockTerraform(lockName, broadcaster, deploymentID)
+	defer unlock()
+
+	// Provider plugins are not kept with the directory.
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Initializing Terraform...", "destroy")
+	if err := cloud.InitTerraform(terraformDir); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to initialize terraform: %v", err), "destroy")
+		return types.NewDeploymentError("destroy", types.ErrCodeTerraformInit, true, err, "failed to initialize terraform")
+	}
+	if err := cloud.Destroy(terraformDir); err != nil {
+		return types.NewDeploymentError("destroy", types.ErrCodeTerraformDestroy, true, err, "failed to destroy infrastructure")
+	}
+
+	if azure, ok := cloud.(*providers.AzureProvider); ok {
+		if azure.StaticIPName != "" {
+			if err := azure.ReleaseStaticIP(); err != nil {
+				ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to release reserved public IP: %v", err), "network")
+				return types.NewDeploymentError("destroy", types.ErrCodeStaticIP, true, err, "failed to release reserved public IP")
+			}
+		}
+		if azure.DataDiskName != "" {
+			if opts.KeepDataDisk {
+				ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Keeping data disk %s in %s", azure.DataDiskName, azure.DataDiskResourceGroup()), "storage")
+			} else if err := azure.DeleteDataDisk(); err != nil {
+				ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to delete data disk: %v", err), "storage")
+				return types.NewDeploymentError("destroy", types.ErrCodeDataDisk, true, err, "failed to delete data disk")
+			}
+		}
+	}
+
+	if req.AutoDeploy && req.GithubToken != "" {
+		if opts.KeepGitHub {
+			ds.broadcastLog(broadcaster, deploymentID, "info", "Keeping the auto-deploy workflow and its GitHub secrets", "github")
+		} else {
+			ds.removeAutoDeploy(req, opts.PublicIP, broadcaster, deploymentID)
+		}
+	}
+	return nil
+}
+
+// removeAutoDeploy deletes the auto-deploy workflow, which would keep
+// deploying to the destroyed VM's address, and its secrets, which hold the
+// VM's SSH key. A workflow that no longer is the one generated for
+// publicIP was changed by hand or by a later deployment of the repository,
+// whose secrets these now are, so both are left alone. Failures are logged:
+// the infrastructure is already gone.
+func (ds *DeploymentService) removeAutoDeploy(req *DeploymentRequest, publicIP string, broadcaster types.LogBroadcaster, deploymentID string) {
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to extract repository info, the auto-deploy workflow and secrets were not removed: %v", err), "github")
+		return
+	}
+	client := githubAPI(req.GithubToken)
+	ctx := githubContext()
+
+	existing, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, repoWorkflowPath, nil)
+	switch {
+	case err == nil && existing != nil:
+		if current, err := existing.GetContent(); err != nil || current != ds.workflowContent(req, publicIP) {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("%s in %s/%s has changed since this deployment; leaving it and its secrets in place", repoWorkflowPath, owner, repo), "github")
+			return
+		}
+		_, _, err = client.Repositories.DeleteFile(ctx, owner, repo, repoWorkflowPath, &github.RepositoryContentFileOptions{
+			Message: github.Ptr("Remove auto-deployment GitHub Actions workflow"),
+			SHA:     existing.SHA,
+			Committer: &github.CommitAuthor{
+				Name:  github.Ptr("Auto Deploy Bot"),
+				Email: github.Ptr("deploy@auto-deploy.local"),
+			},
+		})
+		if err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to remove %s from %s/%s: %v", repoWorkflowPath, owner, repo, githubError(err)), "github")
+		} else {
+			ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Removed %s from %s/%s", repoWorkflowPath, owner, repo), "github")
+		}
+	case resp == nil || resp.StatusCode != http.StatusNotFound:
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to read %s from %s/%s, leaving it and its secrets in place: %v", repoWorkflowPath, owner, repo, githubError(err)), "github")
+		return
+	}
+
+	names := []string{"SSH_PRIVATE_KEY"}
+	for _, key := range sortedKeys(workflowEnv(req)) {
+		names = append(names, fmt.Sprintf("ENV_%s", strings.ToUpper(key)))
+	}
+	var failed []string
+	for _, name := range names {
+		resp, err := client.Actions.DeleteRepoSecret(ctx, owner, repo, name)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to delete GitHub secrets %s from %s/%s; remove them by hand", strings.Join(failed, ", "), owner, repo), "github")
+		return
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Deleted GitHub secrets from %s/%s", owner, repo), "github")
+}
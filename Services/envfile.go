@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidEnvKey reports whether key can be used as an environment variable
+// name.
+func ValidEnvKey(key string) bool {
+	return envKeyPattern.MatchString(key)
+}
+
+// ParseEnvFile parses the contents of a .env file. It follows the common
+// dotenv rules: blank lines and # comments are skipped, an "export " prefix
+// is allowed, unquoted values end at an inline " #" comment, single-quoted
+// values are literal, and double-quoted values understand \n, \r, \t, \"
+// and \\ escapes. Both quoted forms may span several lines.
+func ParseEnvFile(content string) (map[string]string, error) {
+	vars := make(map[string]string)
+	content = strings.ReplaceAll(strings.TrimPrefix(content, "\ufeff"), "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		key, rest, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found {
+			return nil, fmt.Errorf("env_file line %d: expected KEY=VALUE", lineNo)
+		}
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("env_file line %d: invalid variable name %q", lineNo, key)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			if idx := strings.Index(rest, " #"); idx >= 0 {
+				rest = rest[:idx]
+			}
+			vars[key] = strings.TrimSpace(rest)
+			continue
+		}
+
+		// Quoted values run until the matching unescaped quote, which may
+		// be on a later line.
+		quote := rest[0]
+		raw := rest[1:]
+		for {
+			if end := closingQuote(raw, quote); end >= 0 {
+				if trailing := strings.TrimSpace(raw[end+1:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+					return nil, fmt.Errorf("env_file line %d: unexpected text after closing quote", lineNo)
+				}
+				raw = raw[:end]
+				break
+			}
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("env_file line %d: unterminated quoted value for %s", lineNo, key)
+			}
+			raw += "\n" + lines[i]
+		}
+
+		if quote == '"' {
+			raw = unescapeDoubleQuoted(raw)
+		}
+		vars[key] = raw
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the first quote in s that is not
+// escaped with a backslash (double quotes only), or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDoubleQuoted(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// shellQuote quotes value for bash with ANSI-C quoting so it stays on one
+// line even when it contains newlines.
+func shellQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return "$'" + replacer.Replace(value) + "'"
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AppEnvFile holds the app's env variables as shell exports. start_server.sh
+// sources it, so rewriting it and restarting the app changes them without a
+// redeploy.
+const AppEnvFile = "/home/azureuser/app/django-vpc-env.sh"
+
+// appDotEnvFile is the .env file written for apps that read one.
+const appDotEnvFile = "/home/azureuser/app/.env"
+
+// EnvExports returns the contents of AppEnvFile for env.
+func EnvExports(env map[string]string) string {
+	var exports strings.Builder
+	exports.WriteString("# Written by django-vpc, sourced by start_server.sh\n")
+	for _, key := range sortedKeys(env) {
+		exports.WriteString(fmt.Sprintf("export %s=%s\n", key, shellQuote(env[key])))
+	}
+	return exports.String()
+}
+
+// dotEnv returns the contents of the app's .env file for env, in the format
+// the playbook writes it: values with newlines are JSON strings.
+func dotEnv(env map[string]string) string {
+	var content strings.Builder
+	for _, key := range sortedKeys(env) {
+		value := env[key]
+		if strings.Contains(value, "\n") {
+			var quoted bytes.Buffer
+			encoder := json.NewEncoder(&quoted)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(value)
+			value = strings.TrimSuffix(quoted.String(), "\n")
+		}
+		content.WriteString(key + "=" + value + "\n")
+	}
+	return content.String()
+}
+
+func indentLines(text, prefix string) string {
+	var indented strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			indented.WriteString(prefix + line)
+		}
+	}
+	return indented.String()
+}
+
+// envUpdatedMarker ends the output of an UpdateEnvScript that succeeded.
+// Run commands report no exit status, so its output is checked instead.
+const envUpdatedMarker = "django-vpc: env updated"
+
+// EnvUpdated reports whether output is that of an UpdateEnvScript that
+// succeeded.
+func EnvUpdated(output string) bool {
+	return strings.Contains(output, envUpdatedMarker)
+}
+
+// UpdateEnvScript rewrites AppEnvFile and the .env file with env and
+// restarts the app's supervisor programs. Supervisor stops gunicorn with
+// TERM, which lets in-flight requests finish first. VMs provisioned before
+// start_server.sh sourced AppEnvFile must be redeployed once; the script
+// changes nothing on them.
+func UpdateEnvScript(env map[string]string) string {
+	exports := base64.StdEncoding.EncodeToString([]byte(EnvExports(env)))
+	dotenv := base64.StdEncoding.EncodeToString([]byte(dotEnv(env)))
+	return fmt.Sprintf(`set -e
+if ! grep -qF %[1]s /home/azureuser/app/start_server.sh; then
+  echo "start_server.sh does not read %[1]s; redeploy once to enable env updates"
+  exit 3
+fi
+echo %[2]s | base64 -d > %[1]s.tmp
+chown azureuser:azureuser %[1]s.tmp
+chmod 600 %[1]s.tmp
+mv %[1]s.tmp %[1]s
+echo %[3]s | base64 -d > %[4]s
+chown azureuser:azureuser %[4]s
+supervisorctl restart all
+supervisorctl status || true
+echo %[5]q
+`, AppEnvFile, exports, dotenv, appDotEnvFile, envUpdatedMarker)
+}
+
+// UpdateWorkflowSecrets sets the auto-deploy workflow's ENV_ secrets for the
+// set keys of the request's env variables and deletes those of removed. The
+// workflow only passes the variables it was generated with, so new
+// variables reach its migrations after the next full deploy; the app itself
+// reads AppEnvFile.
+func UpdateWorkflowSecrets(req *DeploymentRequest, set, removed []string) error {
+	ds := NewDeploymentService()
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		return fmt.Errorf("failed to extract owner and repo from URL: %v", err)
+	}
+	publicKey, err := ds.getGitHubPublicKey(owner, repo, req.GithubToken)
+	if err != nil {
+		return fmt.Errorf("failed to get GitHub public key: %v", err)
+	}
+
+	var failed []string
+	for _, key := range set {
+		secretName := fmt.Sprintf("ENV_%s", strings.ToUpper(key))
+		if err := ds.setGitHubSecret(owner, repo, secretName, req.EnvVariables[key], req.GithubToken, publicKey); err != nil {
+			failed = append(failed, secretName)
+		}
+	}
+	for _, key := range removed {
+		secretName := fmt.Sprintf("ENV_%s", strings.ToUpper(key))
+		resp, err := githubAPI(req.GithubToken).Actions.DeleteRepoSecret(githubContext(), owner, repo, secretName)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			failed = append(failed, secretName)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update secrets %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
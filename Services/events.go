@@ -0,0 +1,72 @@
+package services
+
+// Event codes mark log messages that frontends and integrations can react
+// to. Codes and their data keys are stable; message text is not.
+const (
+	EventDeploymentStarted   = "DEPLOYMENT_STARTED"
+	EventDeploymentSucceeded = "DEPLOYMENT_SUCCEEDED" // data: public_ip
+	EventDeploymentFailed    = "DEPLOYMENT_FAILED"    // data: error
+	EventDeploymentCancelled = "DEPLOYMENT_CANCELLED"
+	// EventDeploymentComplete is the last message of a deployment's log
+	// stream, whatever the outcome.
+	EventDeploymentComplete = "DEPLOYMENT_COMPLETE"
+
+	EventApprovalRequired = "APPROVAL_REQUIRED" // data: plan_summary
+	EventApprovalGranted  = "APPROVAL_GRANTED"  // data: approver
+
+	EventTFInitStarted    = "TF_INIT_STARTED"
+	EventTFInitFailed     = "TF_INIT_FAILED"
+	EventTFApplyStarted   = "TF_APPLY_STARTED"
+	EventTFApplySucceeded = "TF_APPLY_SUCCEEDED"
+	EventTFApplyFailed    = "TF_APPLY_FAILED"
+	EventPublicIPAssigned = "PUBLIC_IP_ASSIGNED" // data: public_ip
+	EventRegionFallback   = "REGION_FALLBACK"    // data: from, location, error
+	EventImageBuilt       = "IMAGE_BUILT"        // data: image, commit
+
+	EventPoolPlaced = "POOL_PLACED" // data: vm, unix_user, port
+
+	EventAnsibleStarted    = "ANSIBLE_PLAYBOOK_STARTED"
+	EventAnsibleSucceeded  = "ANSIBLE_PLAYBOOK_SUCCEEDED"
+	EventAnsibleFailed     = "ANSIBLE_PLAYBOOK_FAILED"
+	EventAnsibleTaskFailed = "ANSIBLE_TASK_FAILED" // data: task, host
+
+	EventPlaybookCheckFailed  = "PLAYBOOK_CHECK_FAILED"  // data: check, output
+	EventPlaybookLintFindings = "PLAYBOOK_LINT_FINDINGS" // data: findings
+
+	EventServicesStatus = "SERVICES_STATUS" // data: services
+
+	EventDomainsReady   = "DOMAINS_READY"   // data: domains
+	EventDomainsPending = "DOMAINS_PENDING" // data: domains
+
+	EventHookStarted   = "HOOK_STARTED"   // data: stage, index
+	EventHookSucceeded = "HOOK_SUCCEEDED" // data: stage, index
+	EventHookFailed    = "HOOK_FAILED"    // data: stage, index, error, continued
+
+	EventHealthcheckFailed = "HEALTHCHECK_FAILED" // data: status_code, error
+	EventHealthcheckPassed = "HEALTHCHECK_PASSED" // data: status_code, latency_ms
+	EventAutoHealStarted   = "AUTOHEAL_STARTED"   // data: failures
+	EventAutoHealFailed    = "AUTOHEAL_FAILED"
+
+	EventDestroyStarted   = "DESTROY_STARTED"
+	EventDestroySucceeded = "DESTROY_SUCCEEDED"
+	EventDestroyFailed    = "DESTROY_FAILED" // data: error
+
+	EventRedeployStarted   = "REDEPLOY_STARTED"
+	EventRedeploySucceeded = "REDEPLOY_SUCCEEDED" // data: commit
+	EventRedeployFailed    = "REDEPLOY_FAILED"    // data: error, error_code
+	EventRollbackStarted   = "ROLLBACK_STARTED"
+	EventRollbackSucceeded = "ROLLBACK_SUCCEEDED" // data: commit
+	EventRollbackFailed    = "ROLLBACK_FAILED"    // data: error, error_code
+	// EventAppRevision reports the commit the app is at after a deploy,
+	// redeploy or rollback, and for Kubernetes the image by digest.
+	EventAppRevision = "APP_REVISION" // data: commit, action, image
+
+	// EventHostKeyPinned reports the host key pinned for the deployment's
+	// server on first contact, which later SSH connections must present.
+	EventHostKeyPinned = "HOST_KEY_PINNED" // data: host, fingerprint
+
+	EventVulnerabilitiesFound = "VULNERABILITIES_FOUND" // data: findings, severities
+
+	EventCapacityAlert     = "CAPACITY_ALERT"     // data: condition, value, threshold, vm_size, scale_set, disk_size_gb
+	EventCapacityRecovered = "CAPACITY_RECOVERED" // data: condition
+)
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// FallbackLocations returns the regions, in order, that an Azure deployment
+// retries in when its own region has no capacity for its VM: the request's
+// fallback_locations, or AZURE_FALLBACK_LOCATIONS, a comma-separated list.
+// An empty fallback_locations turns the fallback off. Deployments tied to
+// their region, by an existing resource group or subnet or by a static IP or
+// data disk reserved in it, have none.
+func FallbackLocations(req *DeploymentRequest) []string {
+	if Cloud(req) != CloudAzure || req.Pooled || req.ExistingResourceGroup != "" || req.SubnetID != "" || req.StaticIP || HasDataDisk(req) {
+		return nil
+	}
+	candidates := req.FallbackLocations
+	if candidates == nil {
+		candidates = strings.Split(os.Getenv("AZURE_FALLBACK_LOCATIONS"), ",")
+	}
+	primary := Location(req)
+	var locations []string
+	for _, location := range candidates {
+		location = strings.TrimSpace(location)
+		if location == "" || location == primary || !providers.IsKnownLocation(location) || slices.Contains(locations, location) {
+			continue
+		}
+		locations = append(locations, location)
+	}
+	return locations
+}
+
+// capacityUnavailable reports whether err is a region running out of
+// capacity for the VM size, which another region may have.
+func capacityUnavailable(err error) bool {
+	var derr *types.DeploymentError
+	return errors.As(err, &derr) && derr.Code == types.ErrCodeCapacityUnavailable
+}
+
+// applyInLocation removes what the apply that failed for lack of capacity
+// created and applies the configuration again in location. The caller holds
+// lock.
+func (ds *DeploymentService) applyInLocation(req *DeploymentRequest, azure *providers.AzureProvider, location string, lock *terraformLock, terraformDir string, cause error, broadcaster types.LogBroadcaster, deploymentID string) error {
+	from := azure.Location
+	ds.broadcastEvent(broadcaster, deploymentID, "warn", EventRegionFallback, fmt.Sprintf("%s has no capacity for %s, retrying in %s", from, azure.VMSize, location), "terraform",
+		map[string]interface{}{"from": from, "location": location, "error": cause.Error()})
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Removing resources created in %s...", from), "terraform")
+	if err := azure.Destroy(terraformDir); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to remove resources created in %s: %v", from, err), "terraform")
+		return types.NewDeploymentError("terraform", types.ErrCodeTerraformDestroy, true, err, fmt.Sprintf("failed to remove resources created in %s before retrying in %s", from, location))
+	}
+
+	azure.Location = location
+	return ds.applyTerraform(req, azure, azure, lock, terraformDir, broadcaster, deploymentID)
+}
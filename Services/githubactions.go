@@ -2,37 +2,48 @@ package services
 
 import (
 	"bufio"
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/google/go-github/v74/github"
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/ssh"
+
+	"sathwikshetty33/Django-vpc/Types"
 )
 
-type GitHubSecret struct {
-	EncryptedValue string `json:"encrypted_value"`
-	KeyID          string `json:"key_id"`
+// WorkflowAction is a third-party action used by the generated workflow. It
+// is pinned by commit, since the step runs with the server's SSH key and a
+// tag can be moved to other code.
+type WorkflowAction struct {
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// Ref returns the action's uses: reference.
+func (a WorkflowAction) Ref() string {
+	return a.Repo + "@" + a.Commit
 }
 
-type GitHubPublicKey struct {
-	KeyID string `json:"key_id"`
-	Key   string `json:"key"`
+var sshAction = WorkflowAction{Repo: "appleboy/ssh-action", Version: "v1.0.3", Commit: "029f5b4aeeeb58fdfe1410a5d17f967dacf36262"}
+
+// WorkflowActions returns the actions the generated workflow uses.
+func WorkflowActions() []WorkflowAction {
+	return []WorkflowAction{sshAction}
 }
 
 func extractRepoName(repoURL string) (string, error) {
@@ -91,35 +102,12 @@ func (ds *DeploymentService) generateSSHKeyPair() (string, string, error) {
 	return string(privateKeyBytes), string(publicKeyBytes), nil
 }
 
-func (ds *DeploymentService) getGitHubPublicKey(owner, repo, token string) (*GitHubPublicKey, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/secrets/public-key", owner, repo)
-
-	req, err := http.NewRequest("GET", url, nil)
+func (ds *DeploymentService) getGitHubPublicKey(owner, repo, token string) (*github.PublicKey, error) {
+	publicKey, _, err := githubAPI(token).Actions.GetRepoPublicKey(githubContext(), owner, repo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch public key: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GitHub API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, githubError(err)
 	}
-
-	var publicKey GitHubPublicKey
-	if err := json.NewDecoder(resp.Body).Decode(&publicKey); err != nil {
-		return nil, fmt.Errorf("failed to decode public key response: %v", err)
-	}
-
-	return &publicKey, nil
+	return publicKey, nil
 }
 
 func (ds *DeploymentService) encryptSecret(secretValue, publicKeyStr string) (string, error) {
@@ -156,50 +144,136 @@ func (ds *DeploymentService) encryptSecret(secretValue, publicKeyStr string) (st
 	return base64.StdEncoding.EncodeToString(encrypted), nil
 }
 
-func (ds *DeploymentService) setGitHubSecret(owner, repo, secretName, secretValue, token string, publicKey *GitHubPublicKey) error {
-	encryptedValue, err := ds.encryptSecret(secretValue, publicKey.Key)
+func (ds *DeploymentService) setGitHubSecret(owner, repo, secretName, secretValue, token string, publicKey *github.PublicKey) error {
+	encryptedValue, err := ds.encryptSecret(secretValue, publicKey.GetKey())
 	if err != nil {
 		return fmt.Errorf("failed to encrypt secret: %v", err)
 	}
 
-	secret := GitHubSecret{
+	secret := &github.EncryptedSecret{
+		Name:           secretName,
+		KeyID:          publicKey.GetKeyID(),
 		EncryptedValue: encryptedValue,
-		KeyID:          publicKey.KeyID,
 	}
+	if _, err := githubAPI(token).Actions.CreateOrUpdateRepoSecret(githubContext(), owner, repo, secret); err != nil {
+		return fmt.Errorf("failed to set secret %s: %v", secretName, githubError(err))
+	}
+
+	log.Printf("Successfully set GitHub secret: %s", secretName)
+	return nil
+}
 
-	secretJSON, err := json.Marshal(secret)
+// repoWorkflowPath is where the auto-deploy workflow is committed.
+const repoWorkflowPath = ".github/workflows/deploy.yml"
+
+// commitWorkflowFile creates or updates a file on the repository's default
+// branch through the contents API.
+func (ds *DeploymentService) commitWorkflowFile(owner, repo, token, path string, content []byte) error {
+	client := githubAPI(token)
+	ctx := githubContext()
+
+	opts := &github.RepositoryContentFileOptions{
+		Message: github.Ptr("Add auto-deployment GitHub Actions workflow"),
+		Content: content,
+		Committer: &github.CommitAuthor{
+			Name:  github.Ptr("Auto Deploy Bot"),
+			Email: github.Ptr("deploy@auto-deploy.local"),
+		},
+	}
+
+	existing, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, path, nil)
+	switch {
+	case err == nil && existing != nil:
+		current, decodeErr := existing.GetContent()
+		if decodeErr == nil && current == string(content) {
+			return nil
+		}
+		opts.Message = github.Ptr("Update auto-deployment GitHub Actions workflow")
+		opts.SHA = existing.SHA
+		_, _, err = client.Repositories.UpdateFile(ctx, owner, repo, path, opts)
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+		_, _, err = client.Repositories.CreateFile(ctx, owner, repo, path, opts)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal secret: %v", err)
+		return githubError(err)
 	}
+	return nil
+}
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/secrets/%s", owner, repo, secretName)
-
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(secretJSON))
+// recordGitHubDeployment registers the finished deployment of the default
+// branch's head commit with the GitHub deployments API, so the repository
+// shows the live environment URL, and reports it as a check run on that
+// commit. GitHub only lets GitHub Apps create check runs, so with a
+// personal access token the check run is skipped with a warning.
+func (ds *DeploymentService) recordGitHubDeployment(req *DeploymentRequest, publicIP string, broadcaster types.LogBroadcaster, deploymentID string) {
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("Content-Type", "application/json")
+	client := githubAPI(req.GithubToken)
+	ctx := githubContext()
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
 	if err != nil {
-		return fmt.Errorf("failed to set secret: %v", err)
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record GitHub deployment: %v", githubError(err)), "github")
+		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("GitHub API error setting secret %s (status %d): %s", secretName, resp.StatusCode, string(body))
+	branch, _, err := client.Repositories.GetBranch(ctx, owner, repo, repository.GetDefaultBranch(), 1)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record GitHub deployment: %v", githubError(err)), "github")
+		return
+	}
+	sha := branch.GetCommit().GetSHA()
+	environmentURL := fmt.Sprintf("http://%s", publicIP)
+	description := fmt.Sprintf("Deployed to %s", Cloud(req))
+
+	deployment, _, err := client.Repositories.CreateDeployment(ctx, owner, repo, &github.DeploymentRequest{
+		Ref:              github.Ptr(sha),
+		Environment:      github.Ptr("production"),
+		Description:      github.Ptr(fmt.Sprintf("Django VPC deployment %s", deploymentID)),
+		AutoMerge:        github.Ptr(false),
+		RequiredContexts: &[]string{},
+	})
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record GitHub deployment: %v", githubError(err)), "github")
+		return
 	}
 
-	log.Printf("Successfully set GitHub secret: %s", secretName)
-	return nil
+	_, _, err = client.Repositories.CreateDeploymentStatus(ctx, owner, repo, deployment.GetID(), &github.DeploymentStatusRequest{
+		State:          github.Ptr("success"),
+		EnvironmentURL: github.Ptr(environmentURL),
+		Description:    github.Ptr(description),
+	})
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to set GitHub deployment status: %v", githubError(err)), "github")
+		return
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Recorded GitHub deployment %d for %s/%s", deployment.GetID(), owner, repo), "github")
+
+	_, _, err = client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+		Name:        "Django VPC deployment",
+		HeadSHA:     sha,
+		DetailsURL:  github.Ptr(environmentURL),
+		ExternalID:  github.Ptr(deploymentID),
+		Status:      github.Ptr("completed"),
+		Conclusion:  github.Ptr("success"),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:   github.Ptr(description),
+			Summary: github.Ptr(fmt.Sprintf("Deployment %s is live at %s.", deploymentID, environmentURL)),
+		},
+	})
+	var respErr *github.ErrorResponse
+	switch {
+	case errors.As(err, &respErr) && respErr.Response.StatusCode == http.StatusForbidden:
+		ds.broadcastLog(broadcaster, deploymentID, "warn", "Skipped the GitHub check run: only GitHub App tokens can create check runs", "github")
+	case err != nil:
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to create GitHub check run: %v", githubError(err)), "github")
+	}
 }
 
-func (ds *DeploymentService) setupGitHubSecrets(req *DeploymentRequest, privateKey string, broadcaster LogBroadcaster, deploymentID string) error {
+func (ds *DeploymentService) setupGitHubSecrets(req *DeploymentRequest, privateKey string, broadcaster types.LogBroadcaster, deploymentID string) error {
 	if req.GithubToken == "" {
 		ds.broadcastLog(broadcaster, deploymentID, "error", "GitHub token is required for setting up secrets", "github")
 		return fmt.Errorf("GitHub token is required for setting up secrets")
@@ -228,26 +302,31 @@ func (ds *DeploymentService) setupGitHubSecrets(req *DeploymentRequest, privateK
 	}
 	ds.broadcastLog(broadcaster, deploymentID, "success", "SSH private key secret configured", "github")
 
-	if len(req.EnvVariables) > 0 {
+	if envSecrets := workflowEnv(req); len(envSecrets) > 0 {
 		ds.broadcastLog(broadcaster, deploymentID, "info", "Setting up environment variable secrets...", "github")
-		for key, value := range req.EnvVariables {
+		var failed []string
+		for key, value := range envSecrets {
 			secretName := fmt.Sprintf("ENV_%s", strings.ToUpper(key))
 			ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Setting secret: %s", secretName), "github")
 			if err := ds.setGitHubSecret(owner, repo, secretName, value, req.GithubToken, publicKey); err != nil {
 				msg := fmt.Sprintf("Failed to set environment variable secret %s: %v", secretName, err)
-				ds.broadcastLog(broadcaster, deploymentID, "warn", msg, "github")
-				log.Printf("Warning: %s", msg)
+				ds.broadcastLog(broadcaster, deploymentID, "error", msg, "github")
+				log.Printf("Error: %s", msg)
+				failed = append(failed, secretName)
 			} else {
 				ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Secret %s configured successfully", secretName), "github")
 			}
 		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to set %d environment variable secrets: %s", len(failed), strings.Join(failed, ", "))
+		}
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("GitHub secrets configured for %s/%s", owner, repo), "github")
 	return nil
 }
 
-func (ds *DeploymentService) createGitHubActionsWorkflow(workDir string, req *DeploymentRequest, publicIP string, broadcaster LogBroadcaster, deploymentID string) error {
+func (ds *DeploymentService) createGitHubActionsWorkflow(workDir string, req *DeploymentRequest, publicIP string, broadcaster types.LogBroadcaster, deploymentID string) error {
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Creating GitHub Actions workflow directory...", "github")
 	workflowDir := filepath.Join(workDir, "github-actions")
 	if err := os.MkdirAll(workflowDir, 0755); err != nil {
@@ -256,13 +335,31 @@ func (ds *DeploymentService) createGitHubActionsWorkflow(workDir string, req *De
 	}
 	ds.broadcastLog(broadcaster, deploymentID, "success", "Workflow directory created successfully", "github")
 
+	workflowContent := ds.workflowContent(req, publicIP)
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Writing GitHub Actions workflow file...", "github")
+	workflowPath := filepath.Join(workflowDir, "deploy.yml")
+	if err := os.WriteFile(workflowPath, []byte(workflowContent), 0644); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to write workflow file: %v", err), "github")
+		return fmt.Errorf("failed to write workflow file: %v", err)
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "GitHub Actions workflow file created successfully", "github")
+
+	return nil
+}
+
+// workflowContent returns the auto-deploy workflow for req's server at
+// publicIP. Environment values are read from GitHub secrets, not written
+// into it.
+func (ds *DeploymentService) workflowContent(req *DeploymentRequest, publicIP string) string {
 	// Generate env section - only include if there are environment variables
 	envSection := ""
-	if len(req.EnvVariables) > 0 {
-		envSection = fmt.Sprintf("      env:\n%s", ds.generateEnvSecrets(req.EnvVariables))
+	envVars := workflowEnv(req)
+	if len(envVars) > 0 {
+		envSection = fmt.Sprintf("      env:\n%s", ds.generateEnvSecrets(envVars))
 	}
 
-	workflowContent := fmt.Sprintf(`name: Auto Deploy Django Application
+	return fmt.Sprintf(`name: Auto Deploy Django Application
 
 on:
   push:
@@ -278,7 +375,7 @@ jobs:
     
     steps:
     - name: Deploy to server
-      uses: appleboy/ssh-action@v1.0.3
+      uses: `+sshAction.Ref()+` # `+sshAction.Version+`
       with:
         host: %s
         username: azureuser
@@ -332,22 +429,31 @@ jobs:
           sudo supervisorctl status django-server
           
           echo "Auto-deployment completed!"
-%s`, publicIP, ds.generateEnvExports(req.EnvVariables), ds.generateAdditionalCommands(req.AdditionalCommands), envSection)
+%s`, publicIP, ds.generateEnvExports(envVars), ds.generateAdditionalCommands(req.AdditionalCommands), envSection)
+}
 
-	ds.broadcastLog(broadcaster, deploymentID, "info", "Writing GitHub Actions workflow file...", "github")
-	workflowPath := filepath.Join(workflowDir, "deploy.yml")
-	if err := os.WriteFile(workflowPath, []byte(workflowContent), 0644); err != nil {
-		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to write workflow file: %v", err), "github")
-		return fmt.Errorf("failed to write workflow file: %v", err)
-	}
-	ds.broadcastLog(broadcaster, deploymentID, "success", "GitHub Actions workflow file created successfully", "github")
+// RenderWorkflow generates the auto-deploy workflow this release would
+// commit to req's repository for its server at publicIP.
+func RenderWorkflow(req *DeploymentRequest, publicIP string) []byte {
+	return []byte(NewDeploymentService().workflowContent(req, publicIP))
+}
 
-	return nil
+// workflowEnv returns the variables the workflow passes to the server as
+// GitHub encrypted secrets: the env variables and the secrets.
+func workflowEnv(req *DeploymentRequest) map[string]string {
+	env := make(map[string]string, len(req.EnvVariables)+len(req.Secrets))
+	for key, value := range req.EnvVariables {
+		env[key] = value
+	}
+	for key, value := range req.Secrets {
+		env[key] = value
+	}
+	return env
 }
+
 func (ds *DeploymentService) generateEnvExports(envVars map[string]string) string {
 	var exports strings.Builder
-	for key, _ := range envVars {
-
+	for _, key := range sortedKeys(envVars) {
 		exports.WriteString(fmt.Sprintf("          export %s=\"$%s\"\n", key, key))
 	}
 	return exports.String()
@@ -359,7 +465,7 @@ func (ds *DeploymentService) generateEnvSecrets(envVars map[string]string) strin
 	}
 	
 	var secrets strings.Builder
-	for key, _ := range envVars {
+	for _, key := range sortedKeys(envVars) {
 		secretName := fmt.Sprintf("ENV_%s", strings.ToUpper(key))
 		secrets.WriteString(fmt.Sprintf("        %s: ${{ secrets.%s }}\n", key, secretName))
 	}
@@ -379,7 +485,7 @@ func (ds *DeploymentService) generateAdditionalCommands(additionalCommands []str
 	return commands.String()
 }
 
-func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *DeploymentRequest, publicIP string, terraformDir string, broadcaster LogBroadcaster, deploymentID string) error {
+func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *DeploymentRequest, publicIP string, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
 	if !req.AutoDeploy {
 		return nil
 	}
@@ -415,6 +521,21 @@ func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *
 		return fmt.Errorf("failed to create GitHub Actions workflow: %v", err)
 	}
 
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Committing GitHub Actions workflow to repository...", "github")
+	workflowContent, err := os.ReadFile(filepath.Join(workDir, "github-actions", "deploy.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to read workflow file: %v", err)
+	}
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		return fmt.Errorf("failed to extract owner and repo from URL: %v", err)
+	}
+	if err := ds.commitWorkflowFile(owner, repo, req.GithubToken, repoWorkflowPath, workflowContent); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to commit workflow file: %v", err), "github")
+		return fmt.Errorf("failed to commit workflow file: %v", err)
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "GitHub Actions workflow committed to repository", "github")
+
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Creating GitHub Actions Ansible tasks file...", "github")
 	additionalTasksPath := filepath.Join(ansibleDir, "github-actions-setup.yml")
 
@@ -424,8 +545,6 @@ func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *
   become: yes
   vars:
     public_key: "%s"
-    repo_url: "%s"
-    github_token: "%s"
   tasks:
     - name: Add GitHub Actions public key to authorized_keys (already exists but ensuring)
       authorized_key:
@@ -435,46 +554,6 @@ func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *
         comment: "GitHub Actions Deploy Key (same as Azure VM key)"
       become_user: azureuser
 
-    - name: Create .github/workflows directory in repository
-      file:
-        path: /home/azureuser/app/.github/workflows
-        state: directory
-        owner: azureuser
-        group: azureuser
-        mode: '0755'
-      become_user: azureuser
-
-    - name: Copy GitHub Actions workflow to repository
-      copy:
-        src: ../github-actions/deploy.yml
-        dest: /home/azureuser/app/.github/workflows/deploy.yml
-        owner: azureuser
-        group: azureuser
-        mode: '0644'
-      become_user: azureuser
-
-    - name: Configure git for commits
-      shell: |
-        cd /home/azureuser/app
-        git config user.name "Auto Deploy Bot"
-        git config user.email "deploy@auto-deploy.local"
-      become_user: azureuser
-
-    - name: Add and commit GitHub Actions workflow
-      shell: |
-        cd /home/azureuser/app
-        git add .github/workflows/deploy.yml
-        git commit -m "Add auto-deployment GitHub Actions workflow" || echo "No changes to commit"
-      become_user: azureuser
-      ignore_errors: yes
-
-    - name: Push GitHub Actions workflow to repository
-      shell: |
-        cd /home/azureuser/app
-        git push https://{{ github_token }}@{{ repo_url | regex_replace('https://') }} || echo "Failed to push - may need manual intervention"
-      become_user: azureuser
-      ignore_errors: yes
-
     - name: Set up log rotation for deployment logs
       copy:
         content: |
@@ -510,7 +589,7 @@ func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *
           
           You can monitor deployments in the "Actions" tab of your GitHub repository.
           ============================================
-`, strings.TrimSpace(publicKey), req.RepoURL, req.GithubToken)
+`, strings.TrimSpace(publicKey))
 
 	if err := os.WriteFile(additionalTasksPath, []byte(additionalTasksContent), 0644); err != nil {
 		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to write GitHub Actions setup tasks: %v", err), "github")
@@ -536,7 +615,7 @@ func (ds *DeploymentService) setupGitHubActionsOnServer(ansibleDir string, req *
 // 	return strings.Join(result, "\n")
 // }
 
-func (ds *DeploymentService) runAdditionalAnsibleTasks(ansibleDir string, broadcaster LogBroadcaster, deploymentID string) error {
+func (ds *DeploymentService) runAdditionalAnsibleTasks(ansibleDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
 	additionalTasksPath := filepath.Join(ansibleDir, "github-actions-setup.yml")
 	if _, err := os.Stat(additionalTasksPath); os.IsNotExist(err) {
 		ds.broadcastLog(broadcaster, deploymentID, "info", "No additional GitHub Actions tasks found", "github")
@@ -544,8 +623,10 @@ func (ds *DeploymentService) runAdditionalAnsibleTasks(ansibleDir string, broadc
 	}
 
 	ds.broadcastLog(broadcaster, deploymentID, "info", "Running GitHub Actions setup tasks...", "github")
-	cmd := exec.Command("ansible-playbook", "-i", "inventory.ini", "github-actions-setup.yml", "-v")
-	cmd.Dir = ansibleDir
+	cmd, err := ansibleCommand(ds.context(), ansibleDir, "-i", "inventory.ini", "github-actions-setup.yml", "-v")
+	if err != nil {
+		return err
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+)
+
+const (
+	githubMaxRetries     = 5
+	githubMaxWait        = 10 * time.Minute
+	githubWriteInterval  = time.Second
+	githubDefaultBackoff = time.Minute
+)
+
+// rateLimitedTransport paces and retries GitHub API calls for one token. It
+// follows GitHub's guidance: mutating requests are sent one at a time at
+// least a second apart, X-RateLimit-* headers are honored before sending, and
+// primary/secondary limit responses are retried after Retry-After or the
+// reset time instead of being surfaced as failures.
+type rateLimitedTransport struct {
+	base http.RoundTripper
+
+	writeMux  sync.Mutex
+	lastWrite time.Time
+
+	stateMux  sync.Mutex
+	remaining int
+	resetAt   time.Time
+}
+
+var (
+	githubClients   = make(map[string]*http.Client)
+	githubClientMux sync.Mutex
+)
+
+// githubHTTPClient returns the shared client for a token so concurrent
+// deployments using the same token share one rate-limit budget.
+func githubHTTPClient(token string) *http.Client {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	githubClientMux.Lock()
+	defer githubClientMux.Unlock()
+
+	if client, exists := githubClients[key]; exists {
+		return client
+	}
+
+	client := &http.Client{
+		Timeout: 15 * time.Minute,
+		Transport: &rateLimitedTransport{
+			base:      http.DefaultTransport,
+			remaining: -1,
+		},
+	}
+	githubClients[key] = client
+	return client
+}
+
+// githubAPI returns a go-github client for token that sends its requests
+// through the shared rate-limited transport.
+func githubAPI(token string) *github.Client {
+	return github.NewClient(githubHTTPClient(token)).WithAuthToken(token)
+}
+
+// githubContext skips go-github's fail-fast rate limit check so that
+// rateLimitedTransport can wait for the reset and retry instead.
+func githubContext() context.Context {
+	return context.WithValue(context.Background(), github.BypassRateLimitCheck, true)
+}
+
+// githubError turns go-github's typed errors into a readable message.
+func githubError(err error) error {
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	var respErr *github.ErrorResponse
+
+	switch {
+	case errors.As(err, &rateErr):
+		return fmt.Errorf("GitHub rate limit exceeded until %s", rateErr.Rate.Reset.Format(time.RFC3339))
+	case errors.As(err, &abuseErr):
+		return fmt.Errorf("GitHub secondary rate limit exceeded, retry after %s", abuseErr.GetRetryAfter())
+	case errors.As(err, &respErr):
+		return fmt.Errorf("GitHub API error (status %d): %s", respErr.Response.StatusCode, respErr.Message)
+	}
+	return err
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isWriteMethod(req.Method) {
+		t.writeMux.Lock()
+		defer t.writeMux.Unlock()
+
+		if wait := time.Until(t.lastWrite.Add(githubWriteInterval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		defer func() { t.lastWrite = time.Now() }()
+	}
+
+	for attempt := 0; ; attempt++ {
+		t.waitForBudget(req)
+
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("failed to rewind request body: %v", err)
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		t.recordLimits(resp)
+
+		wait, limited := t.rateLimitWait(resp, attempt)
+		if !limited || attempt >= githubMaxRetries || wait > githubMaxWait {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		log.Printf("GitHub rate limit hit on %s %s, retrying in %s (attempt %d/%d)",
+			req.Method, req.URL.Path, wait.Round(time.Second), attempt+1, githubMaxRetries)
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// waitForBudget sleeps until the primary limit resets when it is exhausted.
+func (t *rateLimitedTransport) waitForBudget(req *http.Request) {
+	t.stateMux.Lock()
+	remaining, resetAt := t.remaining, t.resetAt
+	t.stateMux.Unlock()
+
+	if remaining != 0 {
+		return
+	}
+	wait := time.Until(resetAt)
+	if wait <= 0 || wait > githubMaxWait {
+		return
+	}
+
+	log.Printf("GitHub rate limit exhausted, waiting %s for reset before %s %s", wait.Round(time.Second), req.Method, req.URL.Path)
+	select {
+	case <-time.After(wait):
+	case <-req.Context().Done():
+	}
+}
+
+func (t *rateLimitedTransport) recordLimits(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.stateMux.Lock()
+	defer t.stateMux.Unlock()
+
+	t.remaining = remaining
+	t.resetAt = time.Unix(reset, 0)
+}
+
+// rateLimitWait reports whether resp is a rate-limit rejection and how long
+// to wait before retrying it. The body is restored for the caller.
+func (t *rateLimitedTransport) rateLimitWait(resp *http.Response, attempt int) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)) + time.Second, true
+		}
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if strings.Contains(strings.ToLower(string(body)), "rate limit") {
+		return githubDefaultBackoff * time.Duration(1<<attempt), true
+	}
+	return 0, false
+}
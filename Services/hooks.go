@@ -0,0 +1,291 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Lifecycle hook stages, in the order they run. HookOnFailure hooks run
+// instead of the remaining stages once a deployment has failed.
+const (
+	HookPreProvision  = "pre_provision"
+	HookPostProvision = "post_provision"
+	HookPostDeploy    = "post_deploy"
+	HookOnFailure     = "on_failure"
+)
+
+const (
+	DefaultHookTimeout = 5 * time.Minute
+	MaxHookTimeout     = 30 * time.Minute
+)
+
+// LifecycleHook runs a command on the API server or calls a webhook at a
+// stage of the deployment. A failing hook fails the deployment unless
+// OnFailure is "continue". HookOnFailure hooks may instead run Playbook, an
+// Ansible cleanup play, against the deployment's servers; their own
+// failures are only logged.
+type LifecycleHook struct {
+	Stage          string `json:"stage" yaml:"stage"`
+	Command        string `json:"command,omitempty" yaml:"command,omitempty"`
+	URL            string `json:"url,omitempty" yaml:"url,omitempty"`
+	Playbook       string `json:"playbook,omitempty" yaml:"playbook,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" yaml:"timeout_seconds,omitempty"`
+	OnFailure      string `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
+}
+
+// HookCommandsEnabled reports whether hooks may run commands or playbooks
+// on the API server. Commands run with the server's privileges, and a
+// playbook can delegate tasks to it, so they are off unless the operator
+// sets LIFECYCLE_HOOK_COMMANDS. Webhooks are always allowed, to the
+// addresses OutboundClient reaches.
+func HookCommandsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("LIFECYCLE_HOOK_COMMANDS"))
+	return enabled
+}
+
+type hookPayload struct {
+	Stage        string `json:"stage"`
+	DeploymentID string `json:"deployment_id"`
+	Username     string `json:"username"`
+	RepoURL      string `json:"repo_url"`
+	PublicIP     string `json:"public_ip,omitempty"`
+	Error        string `json:"error,omitempty"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorStage   string `json:"error_stage,omitempty"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// runHooks runs the request's hooks for stage in order, each logged under
+// its own "hook:<stage>" step. publicIP is empty before provisioning.
+func (ds *DeploymentService) runHooks(req *DeploymentRequest, stage, deploymentID, workDir, publicIP string, broadcaster types.LogBroadcaster) error {
+	step := "hook:" + stage
+	payload := hookPayload{
+		Stage:        stage,
+		DeploymentID: deploymentID,
+		Username:     req.Username,
+		RepoURL:      req.RepoURL,
+		PublicIP:     publicIP,
+	}
+
+	for i, hook := range req.Hooks {
+		if hook.Stage != stage {
+			continue
+		}
+		name := fmt.Sprintf("%s hook %d", stage, i+1)
+		data := map[string]interface{}{"stage": stage, "index": i}
+
+		err := ds.runHook(hook, i, name, workDir, payload, broadcaster, deploymentID)
+		if err == nil {
+			ds.broadcastEvent(broadcaster, deploymentID, "success", EventHookSucceeded, fmt.Sprintf("%s completed", name), step, data)
+			continue
+		}
+		data["error"] = ds.redact(err.Error())
+		data["continued"] = hook.OnFailure == "continue"
+		if hook.OnFailure == "continue" {
+			ds.broadcastEvent(broadcaster, deploymentID, "warn", EventHookFailed, fmt.Sprintf("%s failed, continuing: %v", name, err), step, data)
+			continue
+		}
+		ds.broadcastEvent(broadcaster, deploymentID, "error", EventHookFailed, fmt.Sprintf("%s failed: %v", name, err), step, data)
+		return types.NewDeploymentError(step, types.ErrCodeHook, false, err, name+" failed")
+	}
+	return nil
+}
+
+// runFailureHooks runs the request's on_failure hooks after the deployment
+// failed with err, before its work directory is removed. Every hook runs
+// whatever the others do, and their failures do not change the
+// deployment's error.
+func (ds *DeploymentService) runFailureHooks(req *DeploymentRequest, deploymentID, workDir string, err error, broadcaster types.LogBroadcaster) {
+	step := "hook:" + HookOnFailure
+	derr := types.AsDeploymentError(err, "deploy")
+	payload := hookPayload{
+		Stage:        HookOnFailure,
+		DeploymentID: deploymentID,
+		Username:     req.Username,
+		RepoURL:      req.RepoURL,
+		Error:        ds.redact(derr.Error()),
+		ErrorCode:    derr.Code,
+		ErrorStage:   derr.Stage,
+	}
+
+	for i, hook := range req.Hooks {
+		if hook.Stage != HookOnFailure {
+			continue
+		}
+		name := fmt.Sprintf("%s hook %d", HookOnFailure, i+1)
+		data := map[string]interface{}{"stage": HookOnFailure, "index": i}
+
+		if err := ds.runHook(hook, i, name, workDir, payload, broadcaster, deploymentID); err != nil {
+			data["error"] = ds.redact(err.Error())
+			ds.broadcastEvent(broadcaster, deploymentID, "warn", EventHookFailed, fmt.Sprintf("%s failed: %v", name, err), step, data)
+			continue
+		}
+		ds.broadcastEvent(broadcaster, deploymentID, "success", EventHookSucceeded, fmt.Sprintf("%s completed", name), step, data)
+	}
+}
+
+// runHook runs one hook, announcing it and logging its output under the
+// "hook:<stage>" step.
+func (ds *DeploymentService) runHook(hook LifecycleHook, index int, name, workDir string, payload hookPayload, broadcaster types.LogBroadcaster, deploymentID string) error {
+	step := "hook:" + hook.Stage
+	timeout := DefaultHookTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	data := map[string]interface{}{"stage": hook.Stage, "index": index}
+	logLine := func(line string) {
+		ds.broadcastLog(broadcaster, deploymentID, "info", line, step)
+	}
+
+	switch {
+	case hook.URL != "":
+		ds.broadcastEvent(broadcaster, deploymentID, "info", EventHookStarted, fmt.Sprintf("Running %s: calling webhook %s", name, hook.URL), step, data)
+		payload.Timestamp = time.Now().Format(time.RFC3339)
+		return callHookWebhook(ds.context(), hook.URL, payload, timeout)
+	case hook.Playbook != "":
+		ds.broadcastEvent(broadcaster, deploymentID, "info", EventHookStarted, fmt.Sprintf("Running %s: cleanup playbook", name), step, data)
+		return runHookPlaybook(ds.context(), hook.Playbook, index, workDir, payload, timeout, logLine)
+	default:
+		ds.broadcastEvent(broadcaster, deploymentID, "info", EventHookStarted, fmt.Sprintf("Running %s: %s", name, hook.Command), step, data)
+		return runHookCommand(ds.context(), hook.Command, workDir, payload, timeout, logLine)
+	}
+}
+
+// hookEnv returns the HOOK_* variables describing the deployment.
+func hookEnv(payload hookPayload) []string {
+	return []string{
+		"HOOK_STAGE=" + payload.Stage,
+		"HOOK_DEPLOYMENT_ID=" + payload.DeploymentID,
+		"HOOK_USERNAME=" + payload.Username,
+		"HOOK_REPO_URL=" + payload.RepoURL,
+		"HOOK_PUBLIC_IP=" + payload.PublicIP,
+		"HOOK_ERROR=" + payload.Error,
+		"HOOK_ERROR_CODE=" + payload.ErrorCode,
+		"HOOK_ERROR_STAGE=" + payload.ErrorStage,
+	}
+}
+
+// logHookOutput passes each non-empty line of a hook's output to logLine.
+func logHookOutput(output *bytes.Buffer, logLine func(string)) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			logLine(line)
+		}
+	}
+}
+
+// runHookPlaybook runs an on_failure cleanup play against the inventory of
+// the deployment's servers, with the deployment details as HOOK_* variables
+// (lookup('env', 'HOOK_ERROR') in the play). A deployment that failed
+// before its servers were known has no inventory and nothing to clean up.
+// Cancelling ctx or running out of time interrupts ansible-playbook, which
+// stops its SSH connections before it exits.
+func runHookPlaybook(ctx context.Context, playbook string, index int, workDir string, payload hookPayload, timeout time.Duration, logLine func(string)) error {
+	ansibleDir := filepath.Join(workDir, "ansible")
+	if _, err := os.Stat(filepath.Join(ansibleDir, "inventory.ini")); err != nil {
+		return fmt.Errorf("the deployment failed before its servers were known, so there is nothing to run the playbook on")
+	}
+	name := fmt.Sprintf("%s-%d.yml", HookOnFailure, index+1)
+	if err := os.WriteFile(filepath.Join(ansibleDir, name), []byte(playbook), 0644); err != nil {
+		return fmt.Errorf("failed to write playbook: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd, err := ansibleCommandWithEnv(ctx, ansibleDir, hookEnv(payload), "-i", "inventory.ini", name)
+	if err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+
+	logHookOutput(&output, logLine)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// runHookCommand runs command with sh, or cmd on Windows hosts, in the
+// deployment work directory. Only PATH and HOME (and SystemRoot on Windows)
+// are inherited from the server, so its credentials are not exposed to the
+// hook; deployment details are passed as HOOK_* variables.
+// Under workspace isolation it runs in the Ansible image instead, with only
+// the work directory. Cancelling ctx stops it, as running out of time does.
+func runHookCommand(ctx context.Context, command, workDir string, payload hookPayload, timeout time.Duration, logLine func(string)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME")}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		env = append(env, "SystemRoot="+os.Getenv("SystemRoot"))
+	}
+	cmd.Dir = workDir
+	// Background children may keep the output pipe open after the shell is
+	// killed.
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Env = append(env, hookEnv(payload)...)
+	if err := providers.Isolate(cmd, AnsibleImage()); err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	logHookOutput(&output, logLine)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// callHookWebhook POSTs the payload as JSON; any non-2xx response fails the
+// hook. Cancelling ctx abandons the call. It only reaches public addresses;
+// see OutboundClient.
+func callHookWebhook(ctx context.Context, url string, payload hookPayload, timeout time.Duration) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := OutboundClient(timeout).Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The response body is not reported: the deployment's log is the
+	// caller's to read, and the URL is theirs to choose.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
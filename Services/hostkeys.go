@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// knownHostsMux serializes pinning, so first connections to hosts sharing
+// a known_hosts file, like a scale set's, do not interleave their writes.
+var knownHostsMux sync.Mutex
+
+// KnownHostsPath returns the known_hosts file pinning the host keys of the
+// servers reached with the private key at keyPath. It is kept next to the
+// key, in the deployment's Terraform directory, so it lasts as long as the
+// key does.
+func KnownHostsPath(keyPath string) string {
+	return filepath.Join(filepath.Dir(keyPath), "known_hosts")
+}
+
+// pinningHostKeyCallback checks host keys against the known_hosts file at
+// path. A host that is not in it yet is trusted on this first contact and
+// its key recorded; after that a different key is rejected.
+func pinningHostKeyCallback(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMux.Lock()
+		defer knownHostsMux.Unlock()
+
+		if _, err := os.Stat(path); err == nil {
+			check, err := knownhosts.New(path)
+			if err != nil {
+				return fmt.Errorf("failed to read known hosts: %v", err)
+			}
+			err = check(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if err == nil || !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to pin host key: %v", err)
+		}
+		defer file.Close()
+		if _, err := fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			return fmt.Errorf("failed to pin host key: %v", err)
+		}
+		return nil
+	}
+}
+
+// hostKeyChanged reports whether err is a host presenting a different key
+// than the one pinned for it.
+func hostKeyChanged(err error) bool {
+	var keyErr *knownhosts.KeyError
+	return errors.As(err, &keyErr) && len(keyErr.Want) > 0
+}
+
+// PinnedHostKey returns the SHA256 fingerprint of the key pinned for host
+// in the known_hosts file at path, or "" if none is.
+func PinnedHostKey(path, host string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	address := knownhosts.Normalize(host)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		_, hosts, key, _, _, err := ssh.ParseKnownHosts(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		for _, pattern := range hosts {
+			if pattern == address {
+				return ssh.FingerprintSHA256(key), nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+// reportHostKey announces the host key pinned for host, the deployment's
+// server, so it is recorded with the deployment.
+func (ds *DeploymentService) reportHostKey(host, privateKeyPath string, broadcaster types.LogBroadcaster, deploymentID string) {
+	fingerprint, err := PinnedHostKey(KnownHostsPath(privateKeyPath), host)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to read the pinned host key of %s: %v", host, err), "ssh")
+		return
+	}
+	if fingerprint == "" {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("No host key was pinned for %s", host), "ssh")
+		return
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventHostKeyPinned, fmt.Sprintf("Pinned host key %s of %s", fingerprint, host), "ssh",
+		map[string]interface{}{"host": host, "fingerprint": fingerprint})
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// AppHostsFile lists, one per line, the addresses the app answers on. The
+// settings block from generateHostsTasks reads it at startup, so rewriting
+// it and restarting the app changes ALLOWED_HOSTS without a redeploy.
+const AppHostsFile = "/home/azureuser/app/django-vpc-hosts"
+
+// MaxDomains is how many domains a request can add to its hosts.
+const MaxDomains = 20
+
+// AllowedHosts returns the deployment's address followed by the request's
+// domains, without duplicates.
+func AllowedHosts(publicIP string, req *DeploymentRequest) []string {
+	hosts := []string{publicIP}
+	for _, domain := range req.Domains {
+		domain = strings.ToLower(domain)
+		if !slices.Contains(hosts, domain) {
+			hosts = append(hosts, domain)
+		}
+	}
+	return hosts
+}
+
+// hostsSettings is appended to the app's settings once. On top of the
+// app's own values it allows every host in AppHostsFile and in the
+// DJANGO_VPC_HOSTS env variable, over http and https for CSRF and CORS.
+const hostsSettings = `
+# django-vpc hosts
+import os as _djangovpc_os
+_djangovpc_hosts = [_h.strip() for _h in _djangovpc_os.environ.get("DJANGO_VPC_HOSTS", "").split(",") if _h.strip()]
+if _djangovpc_os.path.exists("` + AppHostsFile + `"):
+    with open("` + AppHostsFile + `") as _djangovpc_file:
+        _djangovpc_hosts += [_h.strip() for _h in _djangovpc_file if _h.strip()]
+_djangovpc_origins = [_s + "://" + _h for _h in _djangovpc_hosts for _s in ("http", "https")]
+ALLOWED_HOSTS = list(globals().get("ALLOWED_HOSTS", [])) + _djangovpc_hosts
+CSRF_TRUSTED_ORIGINS = list(globals().get("CSRF_TRUSTED_ORIGINS", [])) + _djangovpc_origins
+if not globals().get("CORS_ALLOW_ALL_ORIGINS"):
+    CORS_ALLOWED_ORIGINS = list(globals().get("CORS_ALLOWED_ORIGINS", [])) + _djangovpc_origins
+`
+
+// generateHostsTasks writes AppHostsFile with the VM's address and the
+// request's domains and adds the settings block that reads it.
+func (ds *DeploymentService) generateHostsTasks(req *DeploymentRequest) string {
+	var content strings.Builder
+	for _, host := range AllowedHosts("{{ public_ip }}", req) {
+		content.WriteString("          " + host + "\n")
+	}
+
+	var settings strings.Builder
+	for _, line := range strings.Split(strings.TrimPrefix(hostsSettings, "\n"), "\n") {
+		if line == "" {
+			settings.WriteString("\n")
+		} else {
+			settings.WriteString("        " + line + "\n")
+		}
+	}
+
+	return `
+    - name: Write the app's allowed hosts
+      copy:
+        content: |
+` + content.String() + `        dest: ` + AppHostsFile + `
+        owner: azureuser
+        group: azureuser
+        mode: '0644'
+
+    - name: Read allowed hosts from ` + AppHostsFile + `
+      shell: |
+        cd "{{ django_project_path }}"
+        SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}.py"
+        [ -f "$SETTINGS_FILE" ] || SETTINGS_FILE="{{ django_settings_module | replace('.', '/') }}/__init__.py"
+        if ! grep -q "django-vpc hosts" "$SETTINGS_FILE"; then
+          cat >> "$SETTINGS_FILE" <<'EOF'
+
+` + settings.String() + `        EOF
+        fi
+      args:
+        executable: /bin/bash
+      become_user: azureuser
+`
+}
+
+// ReconcileHostsScript rewrites AppHostsFile with hosts and restarts the
+// supervisor programs so the app reloads its settings. Hosts must already
+// be validated as IP addresses or hostnames.
+func ReconcileHostsScript(hosts []string) string {
+	return fmt.Sprintf(`set -e
+printf '%%s\n' %s > %s
+chown azureuser:azureuser %s
+supervisorctl restart all
+supervisorctl status
+`, strings.Join(hosts, " "), AppHostsFile, AppHostsFile)
+}
@@ -0,0 +1,746 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+const (
+	// ingressNginxManifest installs the ingress controller that exposes
+	// every app on the cluster through one load balancer.
+	ingressNginxManifest = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v1.10.1/deploy/static/provider/cloud/deploy.yaml"
+
+	kubernetesWebReplicas = 2
+	kubernetesAppPort     = 8000
+	kubernetesSecretName  = "app-env"
+
+	imageBuildTimeout   = 30 * time.Minute
+	kubectlTimeout      = 2 * time.Minute
+	rolloutTimeout      = 10 * time.Minute
+	ingressAddressWait  = 5 * time.Minute
+	repositoryMaxSize   = 500 << 20
+	defaultImagePython  = "3.12"
+	managedByLabelValue = "django-vpc"
+)
+
+var dnsLabelInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dnsLabel turns name into a DNS label of at most max characters, as
+// Kubernetes and AKS require for most names.
+func dnsLabel(name string, max int) string {
+	label := strings.Trim(dnsLabelInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(label) > max {
+		label = strings.TrimRight(label[:max], "-")
+	}
+	return label
+}
+
+// AKSRegistryName returns the container registry name for a cluster in
+// resourceGroup. Registry names are global, so a hash of the resource group
+// follows its alphanumeric prefix.
+func AKSRegistryName(resourceGroup string) string {
+	var name strings.Builder
+	for _, r := range strings.ToLower(resourceGroup) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			name.WriteRune(r)
+		}
+	}
+	prefix := name.String()
+	if len(prefix) > 40 {
+		prefix = prefix[:40]
+	}
+	sum := sha256.Sum256([]byte(resourceGroup))
+	return prefix + hex.EncodeToString(sum[:])[:10]
+}
+
+// runTool runs a CLI with stdin as its input and returns its combined
+// output, which is folded into the error when it fails.
+func runTool(dir string, stdin []byte, timeout time.Duration, name string, args ...string) (string, error) {
+	return runToolEnv(dir, nil, stdin, timeout, name, args...)
+}
+
+// runToolEnv is runTool with env as the command's environment, or the
+// server's if nil.
+func runToolEnv(dir string, env []string, stdin []byte, timeout time.Duration, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(output), fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	if err != nil {
+		return string(output), fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// deployKubernetes deploys the request to an AKS or existing cluster: it
+// builds the repository into an image in the cluster's registry and applies
+// generated Deployment, Service and Ingress manifests that run it by its
+// digest. It returns the address of the cluster's ingress controller.
+func (ds *DeploymentService) deployKubernetes(req *DeploymentRequest, cluster *providers.AKSProvider, lockName, workDir, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	lock := &terraformLock{name: lockName, unlock: ds.lockTerraform(lockName, broadcaster, deploymentID)}
+	err := ds.applyTerraform(req, cluster, nil, lock, terraformDir, broadcaster, deploymentID)
+	lock.unlock()
+	if err != nil {
+		return "", err
+	}
+
+	access, err := ds.openCluster(req, cluster, terraformDir, workDir, broadcaster, deploymentID)
+	if err != nil {
+		return "", err
+	}
+	commit, image, err := ds.buildImage(req, cluster, access, filepath.Join(workDir, "app"), broadcaster, deploymentID)
+	if err != nil {
+		return "", err
+	}
+
+	publicIP, err := ds.ensureIngressController(access.kubeconfig, broadcaster, deploymentID)
+	if err != nil {
+		return "", err
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventPublicIPAssigned, fmt.Sprintf("Ingress address: %s", publicIP), "network",
+		map[string]interface{}{"public_ip": publicIP})
+
+	if err := ds.runHooks(req, HookPostProvision, deploymentID, workDir, publicIP, broadcaster); err != nil {
+		return "", err
+	}
+
+	if err := ds.applyManifests(req, access, image, broadcaster, deploymentID); err != nil {
+		return "", err
+	}
+	ds.reportImageRevision(commit, image, store.RevisionDeploy, "kubernetes", broadcaster, deploymentID)
+
+	if err := ds.runHooks(req, HookPostDeploy, deploymentID, workDir, publicIP, broadcaster); err != nil {
+		return "", err
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Deployment completed successfully!", "completed")
+	return publicIP, nil
+}
+
+// RedeployKubernetes builds the latest commit of a Kubernetes deployment's
+// repository and rolls its app out to it, streaming its progress under the
+// "redeploy" step. It returns the commit the app is now at.
+func (ds *DeploymentService) RedeployKubernetes(req *DeploymentRequest, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	return ds.updateKubernetes(req, terraformDir, "", "", "redeploy", store.RevisionRedeploy, broadcaster, deploymentID)
+}
+
+// RollbackKubernetes rolls a Kubernetes deployment's app back to image, by
+// its digest, which was built from commit. Nothing is rebuilt, so the app
+// runs exactly what it ran before. The migration Job runs from image too,
+// which does not reverse migrations newer than it.
+func (ds *DeploymentService) RollbackKubernetes(req *DeploymentRequest, terraformDir, commit, image string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	if !PinnedImage(image) {
+		return "", types.NewDeploymentError("rollback", types.ErrCodeInvalidRequest, false, nil, fmt.Sprintf("image %q is not pinned by digest", image))
+	}
+	return ds.updateKubernetes(req, terraformDir, commit, image, "rollback", store.RevisionRollback, broadcaster, deploymentID)
+}
+
+// updateKubernetes rolls a Kubernetes deployment's app out to image, or to
+// a new build if image is "", under the Terraform lock, and announces the
+// revision of the app made by action. Secret values are redacted from its
+// logs and returned error.
+func (ds *DeploymentService) updateKubernetes(req *DeploymentRequest, terraformDir, commit, image, step, action string, broadcaster types.LogBroadcaster, deploymentID string) (_ string, updateErr error) {
+	ds.redactor = newSecretRedactor(redactedSecrets(req))
+	defer func() {
+		if updateErr != nil {
+			updateErr = ds.redactError(updateErr, step)
+		}
+	}()
+
+	cloud, lockName, err := newCloudProvider(req)
+	cluster, ok := cloud.(*providers.AKSProvider)
+	if err != nil || !ok {
+		return "", types.NewDeploymentError(step, types.ErrCodeInvalidRequest, false, err, "deployment does not run on Kubernetes")
+	}
+	cluster.SetContext(ds.context())
+
+	repoName, err := extractRepoName(req.RepoURL)
+	if err != nil {
+		return "", types.NewDeploymentError(step, types.ErrCodeInvalidRequest, false, err, "failed to extract repo name")
+	}
+	workDir := filepath.Join(WorkspaceDir, req.Username, repoName, time.Now().Format("20060102-150405")+"-"+step)
+	release, err := claimWorkDir(workDir)
+	if err != nil {
+		return "", types.NewDeploymentError(step, types.ErrCodeWorkspace, true, err, "failed to create work directory")
+	}
+	defer release()
+	defer os.RemoveAll(workDir)
+
+	unlock := ds.lockTerraform(lockName, broadcaster, deploymentID)
+	defer unlock()
+
+	access, err := ds.openCluster(req, cluster, terraformDir, workDir, broadcaster, deploymentID)
+	if err != nil {
+		return "", err
+	}
+	if image == "" {
+		if commit, image, err = ds.buildImage(req, cluster, access, filepath.Join(workDir, "app"), broadcaster, deploymentID); err != nil {
+			return "", err
+		}
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Rolling out %s...", image), step)
+	if err := ds.applyManifests(req, access, image, broadcaster, deploymentID); err != nil {
+		return "", err
+	}
+	ds.reportImageRevision(commit, image, action, step, broadcaster, deploymentID)
+	return commit, nil
+}
+
+// reportImageRevision announces the commit and image a Kubernetes
+// deployment's app runs after action.
+func (ds *DeploymentService) reportImageRevision(commit, image, action, step string, broadcaster types.LogBroadcaster, deploymentID string) {
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventAppRevision, fmt.Sprintf("App is at commit %s, image %s", commit, image), step,
+		map[string]interface{}{"commit": commit, "action": action, "image": image})
+}
+
+// clusterAccess is what rolling out to a Kubernetes deployment's cluster
+// takes: the path of its kubeconfig, its registry's login server and the
+// namespace of the deployment's app.
+type clusterAccess struct {
+	kubeconfig string
+	registry   string
+	namespace  string
+}
+
+// openCluster reads the cluster's credentials and registry from its
+// Terraform outputs and writes the kubeconfig into workDir.
+func (ds *DeploymentService) openCluster(req *DeploymentRequest, cluster *providers.AKSProvider, terraformDir, workDir string, broadcaster types.LogBroadcaster, deploymentID string) (*clusterAccess, error) {
+	kubeconfig, err := cluster.GetOutput(terraformDir, "kube_config")
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to get cluster credentials: %v", err), "kubernetes")
+		return nil, types.NewDeploymentError("kubernetes", types.ErrCodeTerraformOutput, true, err, "failed to get cluster credentials")
+	}
+	kubeconfigPath := filepath.Join(workDir, "kubeconfig")
+	if err := os.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		return nil, types.NewDeploymentError("kubernetes", types.ErrCodeWorkspace, false, err, "failed to write kubeconfig")
+	}
+
+	registry, err := cluster.GetOutput(terraformDir, "registry")
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to get container registry: %v", err), "image")
+		return nil, types.NewDeploymentError("image", types.ErrCodeTerraformOutput, true, err, "failed to get container registry")
+	}
+
+	prefix, err := resourcePrefix(req)
+	if err != nil {
+		return nil, types.NewDeploymentError("setup", types.ErrCodeInvalidRequest, false, err, "failed to derive resource names")
+	}
+	return &clusterAccess{kubeconfig: kubeconfigPath, registry: registry, namespace: dnsLabel(prefix, 63)}, nil
+}
+
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// PinnedImage reports whether image names an image by its digest, as the
+// images of Kubernetes deployments are recorded.
+func PinnedImage(image string) bool {
+	_, digest, found := strings.Cut(image, "@")
+	return found && imageDigestPattern.MatchString(digest)
+}
+
+// buildImage downloads the head commit of the repository's default branch
+// into dir, adds a Dockerfile if it has none and builds it in the cluster's
+// registry with az acr build, so no local Docker daemon is needed. It
+// returns the commit and the image by the digest the registry reports for
+// the push, so a tag pushed again later cannot change what the deployment,
+// or a rollback to it, runs.
+func (ds *DeploymentService) buildImage(req *DeploymentRequest, cluster *providers.AKSProvider, access *clusterAccess, dir string, broadcaster types.LogBroadcaster, deploymentID string) (string, string, error) {
+	cliEnv, err := cluster.CLIEnv()
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to log in to Azure: %v", err), "image")
+		return "", "", types.NewDeploymentError("image", types.ErrCodeCloudAuth, false, err, "failed to log in to Azure")
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Downloading repository...", "image")
+	commit, err := ds.downloadRepository(req, dir)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to download repository: %v", err), "image")
+		return "", "", types.NewDeploymentError("image", types.ErrCodeImageBuild, true, err, "failed to download repository")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err == nil {
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Using the repository's Dockerfile", "image")
+	} else {
+		dockerfile, err := generateDockerfile(req, dir)
+		if err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to generate Dockerfile: %v", err), "image")
+			return "", "", types.NewDeploymentError("image", types.ErrCodeImageBuild, false, err, "failed to generate Dockerfile")
+		}
+		if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+			return "", "", types.NewDeploymentError("image", types.ErrCodeWorkspace, false, err, "failed to write Dockerfile")
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Generated a Dockerfile for the project", "image")
+	}
+
+	registryName, _, _ := strings.Cut(access.registry, ".")
+	tag := fmt.Sprintf("%s:%s", access.namespace, commit[:12])
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Building image %s/%s (this may take several minutes)...", access.registry, tag), "image")
+	output, err := runToolEnv(dir, cliEnv, nil, imageBuildTimeout, "az", "acr", "build", "--registry", registryName, "--image", tag, "--only-show-errors", ".")
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Image build failed: %v", err), "image")
+		return "", "", types.NewDeploymentError("image", types.ErrCodeImageBuild, true, err, "image build failed")
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "debug", fmt.Sprintf("Image build output:\n%s", output), "image")
+
+	output, err = runToolEnv(dir, cliEnv, nil, kubectlTimeout, "az", "acr", "repository", "show", "--name", registryName, "--image", tag, "--query", "digest", "--output", "tsv", "--only-show-errors")
+	digest := strings.TrimSpace(output)
+	if err == nil && !imageDigestPattern.MatchString(digest) {
+		err = fmt.Errorf("unexpected digest %q", digest)
+	}
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to read the image digest: %v", err), "image")
+		return "", "", types.NewDeploymentError("image", types.ErrCodeImageBuild, true, err, "failed to read the image digest")
+	}
+
+	image := fmt.Sprintf("%s/%s@%s", access.registry, access.namespace, digest)
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventImageBuilt, fmt.Sprintf("Built image %s", image), "image",
+		map[string]interface{}{"image": image, "commit": commit})
+	return commit, image, nil
+}
+
+// downloadRepository extracts the head commit of the repository's default
+// branch into dir through the GitHub API, like AnalyzeRepository, and
+// returns the commit.
+func (ds *DeploymentService) downloadRepository(req *DeploymentRequest, dir string) (string, error) {
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse repository URL: %v", err)
+	}
+
+	repositories := githubAPI(req.GithubToken).Repositories
+	ctx := githubContext()
+	repository, _, err := repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository: %v", githubError(err))
+	}
+	branch, _, err := repositories.GetBranch(ctx, owner, repo, repository.GetDefaultBranch(), 1)
+	if err != nil {
+		return "", fmt.Errorf("failed to get default branch: %v", githubError(err))
+	}
+	commit := branch.GetCommit().GetSHA()
+	if !ValidCommit(commit) || len(commit) < 12 {
+		return "", fmt.Errorf("unexpected commit %q for %s", commit, repository.GetDefaultBranch())
+	}
+
+	link, _, err := repositories.GetArchiveLink(ctx, owner, repo, github.Tarball, &github.RepositoryContentGetOptions{Ref: commit}, 3)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository archive: %v", githubError(err))
+	}
+
+	client := &http.Client{Timeout: analyzerDownloadTimeout}
+	resp, err := client.Get(link.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to download repository archive: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download repository archive: %s", resp.Status)
+	}
+
+	gz, err := gzip.NewReader(io.LimitReader(resp.Body, repositoryMaxSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read repository archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return commit, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read repository archive: %v", err)
+		}
+
+		// GitHub prefixes every entry with an owner-repo-sha/ directory.
+		_, name, found := strings.Cut(header.Name, "/")
+		if !found || name == "" || !filepath.IsLocal(name) {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return "", err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0755|0644)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return "", fmt.Errorf("failed to extract %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// generateDockerfile writes a Dockerfile that serves the project the way
+// the generated playbook does: gunicorn (with uvicorn workers for ASGI) on
+// port 8000, from the directory holding manage.py.
+func generateDockerfile(req *DeploymentRequest, dir string) (string, error) {
+	files := make(map[string]string)
+	var requirements []string
+	err := filepath.WalkDir(dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, _ := filepath.Rel(dir, file)
+		name = filepath.ToSlash(name)
+		if skipAnalysisPath(name) {
+			return nil
+		}
+		switch {
+		case path.Base(name) == "requirements.txt":
+			requirements = append(requirements, name)
+		case strings.HasSuffix(name, ".py"):
+			info, err := entry.Info()
+			if err != nil || info.Size() > analyzerMaxFileSize {
+				return err
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			files[name] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read repository: %v", err)
+	}
+
+	report := AnalyzeProject(files, providedEnv(req), req.ASGI)
+	if report.SettingsModule == "" {
+		return "", fmt.Errorf("%s", report.Blockers[0].Message)
+	}
+
+	python := req.PythonVersion
+	if python == "" {
+		python = defaultImagePython
+	}
+
+	var install strings.Builder
+	install.WriteString("RUN pip install --no-cache-dir gunicorn uvicorn")
+	if len(requirements) > 0 {
+		// The playbook installs the first requirements.txt it finds; the
+		// shallowest is the most likely to be the project's.
+		sort.Slice(requirements, func(i, j int) bool {
+			return strings.Count(requirements[i], "/") < strings.Count(requirements[j], "/")
+		})
+		install.WriteString(" && pip install --no-cache-dir -r " + requirements[0])
+	}
+
+	command := fmt.Sprintf(`["gunicorn", "%s:application", "--bind", "0.0.0.0:%d", "--workers", "3", "--timeout", "300"]`, report.ServerModule, kubernetesAppPort)
+	if req.ASGI {
+		command = fmt.Sprintf(`["gunicorn", "%s:application", "--bind", "0.0.0.0:%d", "--workers", "3", "--timeout", "300", "--worker-class", "uvicorn.workers.UvicornWorker"]`, report.ServerModule, kubernetesAppPort)
+	}
+	if req.StartCommand != "" {
+		command = fmt.Sprintf(`["sh", "-c", %q]`, "exec "+req.StartCommand)
+	}
+
+	return fmt.Sprintf(`FROM python:%s-slim
+
+ENV PYTHONDONTWRITEBYTECODE=1 \
+    PYTHONUNBUFFERED=1 \
+    PYTHONPATH=/app \
+    DJANGO_SETTINGS_MODULE=%s \
+    PORT=%d
+
+WORKDIR /app
+COPY . .
+%s
+WORKDIR /app/%s
+
+EXPOSE %d
+CMD %s
+`, python, report.SettingsModule, kubernetesAppPort, install.String(), report.ProjectPath, kubernetesAppPort, command), nil
+}
+
+// ensureIngressController installs ingress-nginx if the cluster does not
+// run it yet and returns the address of its load balancer.
+func (ds *DeploymentService) ensureIngressController(kubeconfig string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Installing ingress controller...", "kubernetes")
+	if _, err := runTool("", nil, kubectlTimeout, "kubectl", "--kubeconfig", kubeconfig, "apply", "-f", ingressNginxManifest); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to install ingress controller: %v", err), "kubernetes")
+		return "", types.NewDeploymentError("kubernetes", types.ErrCodeKubernetes, true, err, "failed to install ingress controller")
+	}
+	if _, err := runTool("", nil, rolloutTimeout, "kubectl", "--kubeconfig", kubeconfig, "-n", "ingress-nginx", "rollout", "status", "deployment/ingress-nginx-controller", "--timeout="+rolloutTimeout.String()); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Ingress controller did not start: %v", err), "kubernetes")
+		return "", types.NewDeploymentError("kubernetes", types.ErrCodeKubernetes, true, err, "ingress controller did not start")
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Waiting for the ingress load balancer address...", "network")
+	deadline := time.Now().Add(ingressAddressWait)
+	for {
+		output, err := runTool("", nil, kubectlTimeout, "kubectl", "--kubeconfig", kubeconfig, "-n", "ingress-nginx", "get", "service", "ingress-nginx-controller",
+			"-o", "jsonpath={.status.loadBalancer.ingress[0].ip}")
+		if address := strings.TrimSpace(output); err == nil && address != "" {
+			return address, nil
+		}
+		if time.Now().After(deadline) {
+			ds.broadcastLog(broadcaster, deploymentID, "error", "Ingress load balancer has no address", "network")
+			return "", types.NewDeploymentError("network", types.ErrCodeKubernetes, true, err, "ingress load balancer has no address")
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// applyManifests runs the migrations as a Job and then rolls out the app.
+// The Secret with the request's environment goes through kubectl's stdin,
+// so it is never written to disk.
+func (ds *DeploymentService) applyManifests(req *DeploymentRequest, access *clusterAccess, image string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	kubeconfig, namespace := access.kubeconfig, access.namespace
+	kubectl := func(stdin []byte, timeout time.Duration, args ...string) error {
+		_, err := runTool("", stdin, timeout, "kubectl", append([]string{"--kubeconfig", kubeconfig, "-n", namespace}, args...)...)
+		return err
+	}
+	fail := func(err error, message string) error {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("%s: %v", message, err), "kubernetes")
+		return types.NewDeploymentError("kubernetes", types.ErrCodeKubernetes, false, err, strings.ToLower(message))
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Applying environment to namespace %s...", namespace), "kubernetes")
+	base, err := kubernetesList(kubernetesNamespace(namespace), kubernetesSecret(req, namespace))
+	if err != nil {
+		return fail(err, "Failed to generate manifests")
+	}
+	if err := kubectl(base, kubectlTimeout, "apply", "-f", "-"); err != nil {
+		return fail(err, "Failed to apply environment")
+	}
+
+	job := "migrate-" + time.Now().Format("20060102-150405")
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Running Django migrations...", "kubernetes")
+	migrate, err := kubernetesList(kubernetesMigrateJob(namespace, job, image))
+	if err != nil {
+		return fail(err, "Failed to generate manifests")
+	}
+	if err := kubectl(migrate, kubectlTimeout, "apply", "-f", "-"); err != nil {
+		return fail(err, "Failed to start migrations")
+	}
+	if err := kubectl(nil, rolloutTimeout, "wait", "--for=condition=complete", "job/"+job, "--timeout="+rolloutTimeout.String()); err != nil {
+		return fail(err, "Migrations did not complete")
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Migrations completed", "kubernetes")
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Applying Deployment, Service and Ingress...", "kubernetes")
+	objects := []map[string]interface{}{
+		kubernetesDeployment(namespace, "web", image, kubernetesWebReplicas, nil, nil),
+		kubernetesService(namespace),
+		kubernetesIngress(namespace),
+	}
+	for _, service := range req.Services {
+		objects = append(objects, kubernetesDeployment(namespace, dnsLabel(service.Name, 63), image, 1, serviceCommand(service), service.EnvVariables))
+	}
+	app, err := kubernetesList(objects...)
+	if err != nil {
+		return fail(err, "Failed to generate manifests")
+	}
+	if err := kubectl(app, kubectlTimeout, "apply", "-f", "-"); err != nil {
+		return fail(err, "Failed to apply manifests")
+	}
+
+	for _, object := range objects {
+		if object["kind"] != "Deployment" {
+			continue
+		}
+		name := object["metadata"].(map[string]interface{})["name"].(string)
+		if err := kubectl(nil, rolloutTimeout, "rollout", "status", "deployment/"+name, "--timeout="+rolloutTimeout.String()); err != nil {
+			return fail(err, fmt.Sprintf("Deployment %s did not become ready", name))
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Deployment %s is ready", name), "kubernetes")
+	}
+	return nil
+}
+
+// serviceCommand runs a composite service's setup commands and then its
+// command in the app image.
+func serviceCommand(service ServiceSpec) []string {
+	script := "exec " + service.Command
+	if len(service.SetupCommands) > 0 {
+		script = strings.Join(service.SetupCommands, " && ") + " && " + script
+	}
+	return []string{"sh", "-c", script}
+}
+
+func kubernetesList(items ...map[string]interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
+
+func kubernetesMetadata(namespace, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      name,
+		"namespace": namespace,
+		"labels":    map[string]string{"app.kubernetes.io/managed-by": managedByLabelValue},
+	}
+}
+
+func kubernetesNamespace(namespace string) map[string]interface{} {
+	metadata := kubernetesMetadata("", namespace)
+	delete(metadata, "namespace")
+	return map[string]interface{}{"apiVersion": "v1", "kind": "Namespace", "metadata": metadata}
+}
+
+// kubernetesSecret holds the request's env variables and secrets, which
+// every container loads with envFrom.
+func kubernetesSecret(req *DeploymentRequest, namespace string) map[string]interface{} {
+	data := make(map[string]string, len(req.EnvVariables)+len(req.Secrets))
+	for key, value := range req.EnvVariables {
+		data[key] = value
+	}
+	for key, value := range req.Secrets {
+		data[key] = value
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   kubernetesMetadata(namespace, kubernetesSecretName),
+		"type":       "Opaque",
+		"stringData": data,
+	}
+}
+
+func kubernetesContainer(name, image string, command []string, env map[string]string) map[string]interface{} {
+	container := map[string]interface{}{
+		"name":    name,
+		"image":   image,
+		"envFrom": []interface{}{map[string]interface{}{"secretRef": map[string]string{"name": kubernetesSecretName}}},
+	}
+	if command != nil {
+		container["command"] = command
+	}
+	if len(env) > 0 {
+		var vars []map[string]string
+		for _, key := range sortedKeys(env) {
+			vars = append(vars, map[string]string{"name": key, "value": env[key]})
+		}
+		container["env"] = vars
+	}
+	return container
+}
+
+func kubernetesMigrateJob(namespace, name, image string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   kubernetesMetadata(namespace, name),
+		"spec": map[string]interface{}{
+			"backoffLimit":            1,
+			"ttlSecondsAfterFinished": 3600,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []interface{}{
+						kubernetesContainer("migrate", image, []string{"python", "manage.py", "migrate", "--noinput"}, nil),
+					},
+				},
+			},
+		},
+	}
+}
+
+// kubernetesDeployment runs the image's default command as the web
+// Deployment, or command for a composite service.
+func kubernetesDeployment(namespace, name, image string, replicas int, command []string, env map[string]string) map[string]interface{} {
+	container := kubernetesContainer(name, image, command, env)
+	if command == nil {
+		container["ports"] = []interface{}{map[string]int{"containerPort": kubernetesAppPort}}
+		container["readinessProbe"] = map[string]interface{}{
+			"tcpSocket":           map[string]int{"port": kubernetesAppPort},
+			"initialDelaySeconds": 5,
+			"periodSeconds":       10,
+		}
+	}
+	labels := map[string]string{"app": name}
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   kubernetesMetadata(namespace, name),
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"selector": map[string]interface{}{"matchLabels": labels},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": labels},
+				"spec":     map[string]interface{}{"containers": []interface{}{container}},
+			},
+		},
+	}
+}
+
+func kubernetesService(namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   kubernetesMetadata(namespace, "web"),
+		"spec": map[string]interface{}{
+			"selector": map[string]string{"app": "web"},
+			"ports":    []interface{}{map[string]int{"port": 80, "targetPort": kubernetesAppPort}},
+		},
+	}
+}
+
+func kubernetesIngress(namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "Ingress",
+		"metadata":   kubernetesMetadata(namespace, "web"),
+		"spec": map[string]interface{}{
+			"ingressClassName": "nginx",
+			"rules": []interface{}{map[string]interface{}{
+				"http": map[string]interface{}{
+					"paths": []interface{}{map[string]interface{}{
+						"path":     "/",
+						"pathType": "Prefix",
+						"backend": map[string]interface{}{
+							"service": map[string]interface{}{
+								"name": "web",
+								"port": map[string]int{"number": 80},
+							},
+						},
+					}},
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Log sink types.
+const (
+	LogSinkLoki     = "loki"
+	LogSinkHTTP     = "http"
+	LogSinkEventHub = "eventhub"
+)
+
+// MaxLogSinks caps a request's log_sinks.
+const MaxLogSinks = 5
+
+// LogSink is an external endpoint that receives a copy of every log message
+// of a deployment run.
+type LogSink struct {
+	Type string `json:"type"`
+	// URL is the Loki push endpoint, like https://loki/loki/api/v1/push,
+	// the URL JSON batches are posted to, or the Event Hub's
+	// https://<namespace>.servicebus.windows.net/<hub>.
+	URL string `json:"url"`
+	// Headers are sent with every request, for example Authorization or
+	// Loki's X-Scope-OrgID.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Labels are added to the labels of Loki streams.
+	Labels map[string]string `json:"labels,omitempty"`
+	// SASKeyName and SASKey sign Event Hub requests with a shared access
+	// signature.
+	SASKeyName string `json:"sas_key_name,omitempty"`
+	SASKey     string `json:"sas_key,omitempty"`
+}
+
+const (
+	logShipperQueue     = 1000
+	logShipperBatch     = 100
+	logShipperInterval  = 2 * time.Second
+	logShipperTimeout   = 10 * time.Second
+	logShipperCloseWait = 15 * time.Second
+)
+
+// LogShipper delivers a deployment's log messages to its log sinks in
+// batches. Delivery never holds up the deployment: messages are dropped
+// when the queue is full, and a batch a sink rejects is retried once and
+// then dropped. Sinks are only reached on public addresses; see
+// OutboundClient.
+type LogShipper struct {
+	deploymentID string
+	sinks        []LogSink
+	client       *http.Client
+	queue        chan types.LogMessage
+	done         chan struct{}
+}
+
+// NewLogShipper starts shipping to sinks. Close flushes and stops it.
+func NewLogShipper(deploymentID string, sinks []LogSink) *LogShipper {
+	s := &LogShipper{
+		deploymentID: deploymentID,
+		sinks:        sinks,
+		client:       OutboundClient(logShipperTimeout),
+		queue:        make(chan types.LogMessage, logShipperQueue),
+		done:         make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Ship queues a message, reporting false if the queue was full and the
+// message was dropped.
+func (s *LogShipper) Ship(msg types.LogMessage) bool {
+	select {
+	case s.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close delivers the queued messages and stops the shipper, giving up on
+// sinks that are still slow after logShipperCloseWait.
+func (s *LogShipper) Close() {
+	close(s.queue)
+	select {
+	case <-s.done:
+	case <-time.After(logShipperCloseWait):
+		log.Printf("Gave up flushing log sinks of deployment %s", s.deploymentID)
+	}
+}
+
+func (s *LogShipper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(logShipperInterval)
+	defer ticker.Stop()
+
+	batch := make([]types.LogMessage, 0, logShipperBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, sink := range s.sinks {
+			err := s.deliver(sink, batch)
+			if err != nil {
+				err = s.deliver(sink, batch)
+			}
+			if err != nil {
+				log.Printf("Failed to ship %d log messages of deployment %s to %s sink: %v", len(batch), s.deploymentID, sink.Type, err)
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= logShipperBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *LogShipper) deliver(sink LogSink, batch []types.LogMessage) error {
+	var body []byte
+	var err error
+	contentType := "application/json"
+	switch sink.Type {
+	case LogSinkLoki:
+		body, err = lokiPush(s.deploymentID, sink.Labels, batch)
+	case LogSinkEventHub:
+		body, err = eventHubBatch(batch)
+		contentType = "application/vnd.microsoft.servicebus.json"
+	default:
+		body, err = json.Marshal(batch)
+	}
+	if err != nil {
+		return err
+	}
+
+	target := sink.URL
+	if sink.Type == LogSinkEventHub {
+		target = strings.TrimSuffix(sink.URL, "/") + "/messages"
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	for name, value := range sink.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	if sink.Type == LogSinkEventHub {
+		httpReq.Header.Set("Authorization", eventHubSAS(sink.URL, sink.SASKeyName, sink.SASKey, time.Now().Add(time.Hour)))
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The sink's URL is the caller's to choose, so its response is not
+	// reported.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// lokiPush returns a Loki push request with a stream per level. Each line
+// is the message as JSON, so Loki's json parser can extract its fields.
+func lokiPush(deploymentID string, labels map[string]string, batch []types.LogMessage) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make(map[string]*stream)
+	var levels []string
+	for _, msg := range batch {
+		entry, ok := streams[msg.Level]
+		if !ok {
+			streamLabels := map[string]string{"job": "django-vpc", "deployment_id": deploymentID, "level": msg.Level}
+			for key, value := range labels {
+				streamLabels[key] = value
+			}
+			entry = &stream{Stream: streamLabels}
+			streams[msg.Level] = entry
+			levels = append(levels, msg.Level)
+		}
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		entry.Values = append(entry.Values, [2]string{strconv.FormatInt(messageTime(msg).UnixNano(), 10), string(line)})
+	}
+
+	sort.Strings(levels)
+	push := struct {
+		Streams []*stream `json:"streams"`
+	}{}
+	for _, level := range levels {
+		push.Streams = append(push.Streams, streams[level])
+	}
+	return json.Marshal(push)
+}
+
+// eventHubBatch returns an Event Hub batch with an event per message.
+func eventHubBatch(batch []types.LogMessage) ([]byte, error) {
+	type event struct {
+		Body string `json:"Body"`
+	}
+	events := make([]event, len(batch))
+	for i, msg := range batch {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = event{Body: string(body)}
+	}
+	return json.Marshal(events)
+}
+
+// eventHubSAS returns a shared access signature for resource valid until
+// expiry.
+func eventHubSAS(resource, keyName, key string, expiry time.Time) string {
+	encoded := url.QueryEscape(strings.TrimSuffix(resource, "/"))
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(encoded + "\n" + se))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", encoded, url.QueryEscape(signature), se, url.QueryEscape(keyName))
+}
+
+func messageTime(msg types.LogMessage) time.Time {
+	if parsed, err := time.Parse(time.RFC3339, msg.Timestamp); err == nil {
+		return parsed
+	}
+	return time.Now()
+}
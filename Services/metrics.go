@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// metricsPrefix starts the line MetricsScript prints, followed by the tab
+// separated CPU percent, 1-minute load, core count, memory total and
+// available, OS disk size and use, and gunicorn process count and memory.
+const metricsPrefix = "django-vpc-metrics"
+
+// MetricsScript samples the VM's CPU, memory and OS disk use and the
+// memory of the app's gunicorn processes. It reads /proc and runs only
+// tools every Ubuntu image has, so nothing is installed on the VM. CPU use
+// is measured over two seconds, since a single read of /proc/stat only
+// gives the average since boot.
+var MetricsScript = fmt.Sprintf(`set -u
+read -r _ u1 n1 s1 i1 w1 q1 sq1 st1 _ < /proc/stat
+sleep 2
+read -r _ u2 n2 s2 i2 w2 q2 sq2 st2 _ < /proc/stat
+busy=$(( (u2+n2+s2+q2+sq2+st2) - (u1+n1+s1+q1+sq1+st1) ))
+idle=$(( (i2+w2) - (i1+w1) ))
+cpu=$(awk -v b="$busy" -v i="$idle" 'BEGIN { if (b+i > 0) printf "%%.1f", 100*b/(b+i); else print 0 }')
+mem=$(awk '/^MemTotal:/ {t=$2} /^MemAvailable:/ {a=$2} END {printf "%%.0f\t%%.0f", t*1024, a*1024}' /proc/meminfo)
+disk=$(df -P -B1 / | awk 'NR==2 {printf "%%.0f\t%%.0f", $2, $3}')
+gunicorn=$(ps -eo rss=,args= | awk '$2 ~ /gunicorn/ || $3 ~ /gunicorn/ {n++; s+=$1} END {printf "%%d\t%%.0f", n, s*1024}')
+printf '%s\t%%s\t%%s\t%%s\t%%s\t%%s\t%%s\n' "$cpu" "$(cut -d' ' -f1 /proc/loadavg)" "$(nproc)" "$mem" "$disk" "$gunicorn"
+`, metricsPrefix)
+
+// ParseMetrics returns the sample in the output of MetricsScript, taken
+// at now.
+func ParseMetrics(output string, now time.Time) (store.MetricSample, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if fields[0] != metricsPrefix {
+			continue
+		}
+		if len(fields) != 10 {
+			return store.MetricSample{}, fmt.Errorf("malformed metrics line %q", line)
+		}
+
+		var numbers [9]float64
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return store.MetricSample{}, fmt.Errorf("malformed metrics line %q", line)
+			}
+			numbers[i] = value
+		}
+		total, available := int64(numbers[3]), int64(numbers[4])
+		return store.MetricSample{
+			Time:                now,
+			CPUPercent:          numbers[0],
+			Load1:               numbers[1],
+			CPUs:                int(numbers[2]),
+			MemoryTotalBytes:    total,
+			MemoryUsedBytes:     total - available,
+			DiskTotalBytes:      int64(numbers[5]),
+			DiskUsedBytes:       int64(numbers[6]),
+			GunicornProcesses:   int(numbers[7]),
+			GunicornMemoryBytes: int64(numbers[8]),
+		}, nil
+	}
+	return store.MetricSample{}, fmt.Errorf("metrics script printed no sample")
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Deployment modes. A full deployment provisions a VM and deploys the app
+// to it. An infra-only one stops once the VM is reachable, and an app-only
+// one deploys the app to the VM of an earlier infra-only deployment without
+// provisioning anything.
+const (
+	ModeFull      = "full"
+	ModeInfraOnly = "infra-only"
+	ModeAppOnly   = "app-only"
+)
+
+// ValidMode reports whether value is a deployment mode.
+func ValidMode(value string) bool {
+	return value == ModeFull || value == ModeInfraOnly || value == ModeAppOnly
+}
+
+// Mode returns the request's deployment mode, ModeFull unless it sets one.
+func Mode(req *DeploymentRequest) string {
+	if req.Mode != "" {
+		return req.Mode
+	}
+	return ModeFull
+}
+
+// Infrastructure is the VM an infra-only deployment created: its address
+// and the kept Terraform directory holding its SSH key.
+type Infrastructure struct {
+	PublicIP     string
+	TerraformDir string
+}
+
+// InfrastructureSource looks up the VM of a finished infra-only deployment
+// for an app-only deployment to deploy to.
+type InfrastructureSource interface {
+	Infrastructure(deploymentID string) (*Infrastructure, error)
+}
+
+func (ds *DeploymentService) SetInfrastructureSource(source InfrastructureSource) {
+	ds.infrastructure = source
+}
+
+// useInfrastructure copies the SSH key and pinned host keys of the request's
+// infra-only deployment into terraformDir, so the rest of the run reaches
+// its VM like one it provisioned, and returns the VM's address.
+func (ds *DeploymentService) useInfrastructure(req *DeploymentRequest, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	if ds.infrastructure == nil {
+		return "", types.NewDeploymentError("setup", types.ErrCodeInfrastructure, false, nil, "no infrastructure source configured")
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Using the VM of infra-only deployment %s...", req.InfraDeploymentID), "setup")
+	infra, err := ds.infrastructure.Infrastructure(req.InfraDeploymentID)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Cannot deploy to deployment %s: %v", req.InfraDeploymentID, err), "setup")
+		return "", types.NewDeploymentError("setup", types.ErrCodeInfrastructure, false, err, "infrastructure deployment is not available")
+	}
+
+	keyPath := filepath.Join(terraformDir, "azure_vm_key")
+	source := filepath.Join(infra.TerraformDir, "azure_vm_key")
+	for _, suffix := range []string{"", ".pub"} {
+		data, err := os.ReadFile(source + suffix)
+		if err != nil {
+			return "", types.NewDeploymentError("ssh", types.ErrCodeSSHKeys, false, err, "failed to read the infrastructure's SSH key")
+		}
+		if err := os.WriteFile(keyPath+suffix, data, 0600); err != nil {
+			return "", types.NewDeploymentError("ssh", types.ErrCodeWorkspace, false, err, "failed to copy the infrastructure's SSH key")
+		}
+	}
+	if data, err := os.ReadFile(KnownHostsPath(source)); err == nil {
+		if err := os.WriteFile(KnownHostsPath(keyPath), data, 0600); err != nil {
+			return "", types.NewDeploymentError("ssh", types.ErrCodeWorkspace, false, err, "failed to copy the infrastructure's host key")
+		}
+	}
+	return infra.PublicIP, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxOpenPorts caps a request's open_ports.
+const MaxOpenPorts = 10
+
+// OpenPort is an additional port a deployment serves on, such as Flower on
+// 5555 or a separate WebSocket server on 8001, usually run as one of the
+// request's services.
+type OpenPort struct {
+	Port int `json:"port" yaml:"port"`
+	// Path, if set, is also proxied to Port by nginx, with WebSocket
+	// upgrades, so the port is reachable through port 80 and the app's
+	// domains. The service must expect to be served under Path.
+	Path string `json:"path,omitempty" yaml:"path"`
+}
+
+// OpenPortNumbers returns the ports of the request's open_ports.
+func OpenPortNumbers(req *DeploymentRequest) []int {
+	ports := make([]int, len(req.OpenPorts))
+	for i, open := range req.OpenPorts {
+		ports[i] = open.Port
+	}
+	return ports
+}
+
+// proxiesOpenPorts reports whether nginx proxies any of the request's
+// open_ports.
+func proxiesOpenPorts(req *DeploymentRequest) bool {
+	for _, open := range req.OpenPorts {
+		if open.Path != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// generateOpenPortsNginx returns the nginx locations of the request's
+// open_ports that set a path. They need the $connection_upgrade map of
+// generateWebSocketNginx.
+func (ds *DeploymentService) generateOpenPortsNginx(req *DeploymentRequest) string {
+	var locations strings.Builder
+	for _, open := range req.OpenPorts {
+		if open.Path == "" {
+			continue
+		}
+		locations.WriteString(fmt.Sprintf(`              # open_ports: port %d
+              location %s {
+                  proxy_pass http://127.0.0.1:%d;
+                  proxy_http_version 1.1;
+                  proxy_set_header Upgrade $http_upgrade;
+                  proxy_set_header Connection $connection_upgrade;
+                  proxy_set_header Host $host;
+                  proxy_set_header X-Real-IP $remote_addr;
+                  proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+                  proxy_set_header X-Forwarded-Proto $scheme;
+                  proxy_read_timeout 3600s;
+                  proxy_send_timeout 3600s;
+                  proxy_redirect off;
+              }
+
+`, open.Port, open.Path, open.Port))
+	}
+	return locations.String()
+}
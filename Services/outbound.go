@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// outboundAllowedNetworks are the non-public networks operators let
+// request-supplied URLs reach with OUTBOUND_ALLOWED_CIDRS, a comma
+// separated list such as 10.0.5.0/24 for an internal Loki.
+func outboundAllowedNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, value := range strings.Split(os.Getenv("OUTBOUND_ALLOWED_CIDRS"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			log.Printf("Ignoring invalid CIDR %q in OUTBOUND_ALLOWED_CIDRS", value)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// publicAddress reports whether ip may be reached by a URL from a
+// deployment request: a public address, or one in OUTBOUND_ALLOWED_CIDRS.
+// Loopback, private, link-local (the cloud metadata service among them)
+// and other special addresses are refused.
+func publicAddress(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// OutboundClient returns an HTTP client for URLs a deployment request
+// supplies, like webhook hooks and log sinks. It checks the address of
+// every connection it makes, redirects included, after resolving the host,
+// so a name cannot be pointed at the server's own network once it passed
+// validation.
+func OutboundClient(timeout time.Duration) *http.Client {
+	allowed := outboundAllowedNetworks()
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip, allowed) {
+				return fmt.Errorf("connections to %s are not allowed; set OUTBOUND_ALLOWED_CIDRS to allow it", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the only address checked.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// checkHost stands in for the server's address in the playbook checked
+// before it is known. It is in TEST-NET-3, so it is never a real server.
+const checkHost = "203.0.113.10"
+
+// playbookCheckTimeout bounds each of the syntax check and the lint.
+const playbookCheckTimeout = 3 * time.Minute
+
+// fatalLintRules are the ansible-lint rules whose findings mean Ansible
+// cannot load the playbook at all; any other finding is a warning.
+var fatalLintRules = []string{"syntax-check", "load-failure", "parser-error", "internal-error", "yaml[indentation]", "yaml[syntax]"}
+
+// checkPlaybook renders the request's playbook for checkHost and runs
+// ansible-playbook --syntax-check and ansible-lint on it before anything is
+// provisioned, so a playbook that Ansible cannot load, e.g. one broken by an
+// environment value, fails the deployment up front instead of after the VM
+// was created. ansible-lint is skipped if it is not installed or
+// ANSIBLE_LINT is "false", and only its load and syntax findings fail the
+// deployment; the others are logged as warnings.
+func (ds *DeploymentService) checkPlaybook(req *DeploymentRequest, workDir string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	checkDir := filepath.Join(workDir, "playbook-check")
+	if err := os.MkdirAll(checkDir, 0755); err != nil {
+		return types.NewDeploymentError("ansible", types.ErrCodeWorkspace, false, err, "failed to create playbook check directory")
+	}
+	defer os.RemoveAll(checkDir)
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Checking the generated Ansible playbook...", "ansible")
+	if err := os.WriteFile(filepath.Join(checkDir, "inventory.ini"), []byte("[django_servers]\n"+checkHost+"\n"), 0644); err != nil {
+		return types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to write inventory file")
+	}
+	if err := os.WriteFile(filepath.Join(checkDir, "playbook.yml"), []byte(ds.generatePlaybook(req, checkHost)), 0644); err != nil {
+		return types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to write playbook file")
+	}
+
+	output, err := ds.runPlaybookCheck(checkDir, "ansible-playbook", "--syntax-check", "-i", "inventory.ini", "playbook.yml")
+	if err != nil {
+		return ds.playbookCheckFailed("ansible-playbook --syntax-check", output, err, broadcaster, deploymentID)
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Playbook syntax check passed", "ansible")
+
+	if os.Getenv("ANSIBLE_LINT") == "false" {
+		return nil
+	}
+	if !AnsibleRunsInContainer() {
+		if _, err := exec.LookPath("ansible-lint"); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "info", "ansible-lint is not installed, skipping the playbook lint", "ansible")
+			return nil
+		}
+	}
+	// ansible-lint exits non-zero whenever it has findings, so its exit
+	// status is only an error when it printed none.
+	output, err = ds.runPlaybookCheck(checkDir, "ansible-lint", "--nocolor", "--offline", "-p", "playbook.yml")
+	findings := lintFindings(output)
+	if err != nil && len(findings) == 0 {
+		if ds.context().Err() != nil {
+			return cancelledError(ds.context().Err())
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("ansible-lint did not run, skipping the playbook lint: %v", err), "ansible")
+		return nil
+	}
+
+	var fatal []string
+	for _, finding := range findings {
+		if isFatalLintFinding(finding) {
+			fatal = append(fatal, finding)
+		}
+	}
+	if len(fatal) > 0 {
+		return ds.playbookCheckFailed("ansible-lint", strings.Join(fatal, "\n"), fmt.Errorf("%d load or syntax findings", len(fatal)), broadcaster, deploymentID)
+	}
+	if len(findings) > 0 {
+		ds.broadcastEvent(broadcaster, deploymentID, "warn", EventPlaybookLintFindings, fmt.Sprintf("ansible-lint reported %d findings in the generated playbook", len(findings)), "ansible",
+			map[string]interface{}{"findings": findings})
+		return nil
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "Playbook lint passed", "ansible")
+	return nil
+}
+
+// runPlaybookCheck runs an Ansible tool in checkDir and returns its
+// combined output.
+func (ds *DeploymentService) runPlaybookCheck(checkDir, tool string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ds.context(), playbookCheckTimeout)
+	defer cancel()
+
+	cmd, err := ansibleToolCommand(ctx, checkDir, tool, args...)
+	if err != nil {
+		return "", err
+	}
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(output), fmt.Errorf("%s timed out after %s", tool, playbookCheckTimeout)
+	}
+	return string(output), err
+}
+
+// playbookCheckFailed reports a failed check with its output and returns
+// the deployment's pre-flight failure.
+func (ds *DeploymentService) playbookCheckFailed(check, output string, err error, broadcaster types.LogBroadcaster, deploymentID string) error {
+	if ds.context().Err() != nil {
+		return cancelledError(ds.context().Err())
+	}
+	output = strings.TrimSpace(output)
+	if len(output) > 4000 {
+		output = "..." + output[len(output)-4000:]
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "error", EventPlaybookCheckFailed, fmt.Sprintf("%s failed on the generated playbook: %v", check, err), "ansible",
+		map[string]interface{}{"check": check, "output": output})
+	return types.NewDeploymentError("ansible", types.ErrCodeAnsibleCheck, false, fmt.Errorf("%v: %s", err, output), check+" failed on the generated playbook")
+}
+
+// lintFindings returns the findings in ansible-lint's parseable output,
+// one "file:line: rule: message" line each.
+func lintFindings(output string) []string {
+	var findings []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "playbook.yml:") {
+			findings = append(findings, line)
+		}
+	}
+	return findings
+}
+
+func isFatalLintFinding(finding string) bool {
+	parts := strings.SplitN(finding, ": ", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	for _, rule := range fatalLintRules {
+		if parts[1] == rule || strings.HasPrefix(parts[1], rule+"[") {
+			return true
+		}
+	}
+	return false
+}
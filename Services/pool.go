@@ -0,0 +1,252 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+const (
+	poolBasePort       = 8001
+	poolMaxCapacity    = 99
+	defaultPoolVMSize  = "Standard_B2ms"
+	defaultPoolVMSlots = 10
+)
+
+// VMPool places small deployments onto shared VMs, provisioning another VM
+// when every existing one is full. Each app gets its own Unix user, venv,
+// gunicorn port and nginx server block on its VM.
+type VMPool struct {
+	mux   sync.Mutex
+	store *store.PoolStore
+	dir   string
+}
+
+// NewVMPool keeps pool state in st and each pool VM's Terraform workspace
+// and SSH keys under dir.
+func NewVMPool(st *store.PoolStore, dir string) *VMPool {
+	return &VMPool{store: st, dir: dir}
+}
+
+// PoolPlacement is where an app was placed and how to reach its VM.
+type PoolPlacement struct {
+	VM         string
+	PublicIP   string
+	KeyPath    string
+	UnixUser   string
+	Port       int
+	Deployment string
+	New        bool
+}
+
+func poolVMSize() string {
+	if size := os.Getenv("POOL_VM_SIZE"); size != "" {
+		return size
+	}
+	return defaultPoolVMSize
+}
+
+func poolVMCapacity() int {
+	if capacity, err := strconv.Atoi(os.Getenv("POOL_VM_CAPACITY")); err == nil && capacity > 0 {
+		if capacity > poolMaxCapacity {
+			return poolMaxCapacity
+		}
+		return capacity
+	}
+	return defaultPoolVMSlots
+}
+
+// poolUnixUser derives a stable, valid Unix user name for an app.
+func poolUnixUser(app string) string {
+	sum := sha256.Sum256([]byte(app))
+	return "app-" + hex.EncodeToString(sum[:])[:10]
+}
+
+func (p *VMPool) terraformDir(vmName string) string {
+	return filepath.Join(p.dir, vmName, "terraform")
+}
+
+// Place returns the app's existing slot, or a free slot on a pool VM,
+// provisioning a new VM if all are full.
+func (p *VMPool) Place(app, deploymentID string, log func(level, message string)) (*PoolPlacement, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	vms, err := p.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var target *store.PoolVM
+	for _, vm := range vms {
+		if _, exists := vm.Slots[app]; exists {
+			target = vm
+			break
+		}
+	}
+	if target == nil {
+		for _, vm := range vms {
+			if len(vm.Slots) < vm.Capacity {
+				target = vm
+				break
+			}
+		}
+	}
+	if target == nil {
+		log("info", "All pool VMs are full, provisioning a new one...")
+		target, err = p.provision(len(vms)+1, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slot, exists := target.Slots[app]
+	placedNew := !exists
+	if !exists {
+		slot = &store.PoolSlot{
+			App:      app,
+			UnixUser: poolUnixUser(app),
+			Port:     freePoolPort(target),
+			PlacedAt: time.Now(),
+		}
+		target.Slots[app] = slot
+	}
+	slot.DeploymentID = deploymentID
+
+	if err := p.store.Save(target); err != nil {
+		return nil, err
+	}
+
+	return &PoolPlacement{
+		VM:         target.Name,
+		PublicIP:   target.PublicIP,
+		KeyPath:    filepath.Join(p.terraformDir(target.Name), "azure_vm_key"),
+		UnixUser:   slot.UnixUser,
+		Port:       slot.Port,
+		Deployment: deploymentID,
+		New:        placedNew,
+	}, nil
+}
+
+func freePoolPort(vm *store.PoolVM) int {
+	used := make(map[int]bool, len(vm.Slots))
+	for _, slot := range vm.Slots {
+		used[slot.Port] = true
+	}
+	port := poolBasePort
+	for used[port] {
+		port++
+	}
+	return port
+}
+
+// Release frees the app's slot if it is still held by deploymentID.
+func (p *VMPool) Release(app, deploymentID string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	vms, err := p.store.List()
+	if err != nil {
+		return err
+	}
+	for _, vm := range vms {
+		if slot, exists := vm.Slots[app]; exists && slot.DeploymentID == deploymentID {
+			delete(vm.Slots, app)
+			return p.store.Save(vm)
+		}
+	}
+	return nil
+}
+
+// VMs lists the pool VMs and their slots.
+func (p *VMPool) VMs() ([]*store.PoolVM, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.store.List()
+}
+
+func (p *VMPool) provision(index int, log func(level, message string)) (*store.PoolVM, error) {
+	name := fmt.Sprintf("django-vpc-pool-%d", index)
+	capacity := poolVMCapacity()
+
+	terraformDir := p.terraformDir(name)
+	if err := os.MkdirAll(terraformDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create pool workspace: %v", err)
+	}
+
+	azure := providers.AzureProvider{
+		ResourceGroup:  name + "-rg",
+		Location:       DefaultLocation,
+		VMSize:         poolVMSize(),
+		VMName:         name,
+		ExtraPortRange: fmt.Sprintf("%d-%d", poolBasePort, poolBasePort+capacity-1),
+		Backend:        providers.AzureBackendFromEnv(),
+	}
+	setShutdownSchedule(&azure, defaultAutoShutdown(), defaultAutoShutdownTimezone())
+
+	unlock := providers.LockResourceGroup(azure.ResourceGroup, "pool-"+name, nil)
+	defer unlock()
+
+	log("info", fmt.Sprintf("Provisioning pool VM %s (%s, %d slots)...", name, azure.VMSize, capacity))
+	if err := azure.GenerateTerraformConfig(terraformDir); err != nil {
+		return nil, fmt.Errorf("failed to generate pool VM terraform config: %v", err)
+	}
+	if err := azure.InitTerraform(terraformDir); err != nil {
+		return nil, fmt.Errorf("failed to initialize pool VM terraform: %v", err)
+	}
+	if err := azure.ApplyTerraform(terraformDir); err != nil {
+		return nil, fmt.Errorf("failed to provision pool VM: %v", err)
+	}
+	publicIP, err := azure.GetOutput(terraformDir, "public_ip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool VM public IP: %v", err)
+	}
+
+	vm := &store.PoolVM{
+		Name:          name,
+		ResourceGroup: azure.ResourceGroup,
+		PublicIP:      strings.TrimSpace(publicIP),
+		Capacity:      capacity,
+		Slots:         make(map[string]*store.PoolSlot),
+		CreatedAt:     time.Now(),
+	}
+	if err := p.store.Save(vm); err != nil {
+		return nil, err
+	}
+	log("success", fmt.Sprintf("Pool VM %s ready at %s", name, vm.PublicIP))
+	return vm, nil
+}
+
+// RestartApp restarts the supervisor program of the request's app on its
+// pool VM.
+func (p *VMPool) RestartApp(req *DeploymentRequest) (string, error) {
+	app, err := resourcePrefix(req)
+	if err != nil {
+		return "", err
+	}
+
+	p.mux.Lock()
+	vms, err := p.store.List()
+	p.mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	for _, vm := range vms {
+		if slot, exists := vm.Slots[app]; exists {
+			keyPath := filepath.Join(p.terraformDir(vm.Name), "azure_vm_key")
+			return runRemoteCommand(vm.PublicIP, keyPath, "sudo supervisorctl restart "+slot.UnixUser, 2*time.Minute)
+		}
+	}
+	return "", fmt.Errorf("app %s is not placed on any pool VM", app)
+}
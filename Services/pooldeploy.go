@@ -0,0 +1,320 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// deployPooled places the app on a shared pool VM and deploys it there
+// under its own Unix user instead of provisioning a dedicated VM.
+func (ds *DeploymentService) deployPooled(req *DeploymentRequest, deploymentID, workDir string, broadcaster types.LogBroadcaster) (string, error) {
+	if ds.pool == nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", "Pooled mode requested but no VM pool is configured", "pool")
+		return "", types.NewDeploymentError("pool", types.ErrCodePoolUnavailable, false, nil, "pooled mode requested but no VM pool is configured")
+	}
+
+	app, err := resourcePrefix(req)
+	if err != nil {
+		return "", types.NewDeploymentError("pool", types.ErrCodeInvalidRequest, false, err, "failed to derive app name")
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Placing app on a pooled VM...", "pool")
+	placement, err := ds.pool.Place(app, deploymentID, func(level, message string) {
+		ds.broadcastLog(broadcaster, deploymentID, level, message, "pool")
+	})
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to place app: %v", err), "pool")
+		return "", types.NewDeploymentError("pool", types.ErrCodePoolPlacement, true, err, "failed to place app on pool VM")
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventPoolPlaced, fmt.Sprintf("Placed on %s as %s, port %d", placement.VM, placement.UnixUser, placement.Port), "pool",
+		map[string]interface{}{"vm": placement.VM, "unix_user": placement.UnixUser, "port": placement.Port})
+
+	succeeded := false
+	defer func() {
+		if !succeeded && placement.New {
+			if err := ds.pool.Release(app, deploymentID); err != nil {
+				ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to release pool slot: %v", err), "pool")
+			}
+		}
+	}()
+
+	// Copy the pool VM's key into this deployment's workspace so the
+	// inventory can reference it the same way as for a dedicated VM, along
+	// with the host key pinned for the VM by earlier deployments.
+	terraformDir := filepath.Join(workDir, "terraform")
+	ansibleDir := filepath.Join(workDir, "ansible")
+	keyPath := filepath.Join(terraformDir, "azure_vm_key")
+	for _, suffix := range []string{"", ".pub"} {
+		data, err := os.ReadFile(placement.KeyPath + suffix)
+		if err != nil {
+			return "", types.NewDeploymentError("pool", types.ErrCodePoolPlacement, false, err, "failed to read pool VM key")
+		}
+		if err := os.WriteFile(keyPath+suffix, data, 0600); err != nil {
+			return "", types.NewDeploymentError("pool", types.ErrCodeWorkspace, false, err, "failed to copy pool VM key")
+		}
+	}
+	if data, err := os.ReadFile(KnownHostsPath(placement.KeyPath)); err == nil {
+		if err := os.WriteFile(KnownHostsPath(keyPath), data, 0600); err != nil {
+			return "", types.NewDeploymentError("pool", types.ErrCodeWorkspace, false, err, "failed to copy pool VM host key")
+		}
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Waiting for SSH on pool VM...", "ssh")
+	if err := waitForSSH(placement.PublicIP, keyPath, 10, 30*time.Second); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Pool VM is not reachable: %v", err), "ssh")
+		return "", types.NewDeploymentError("ssh", types.ErrCodeSSHUnreachable, true, err, "pool VM is not reachable")
+	}
+
+	if err := ds.runHooks(req, HookPostProvision, deploymentID, workDir, placement.PublicIP, broadcaster); err != nil {
+		return "", err
+	}
+
+	relKeyPath, err := filepath.Rel(ansibleDir, keyPath)
+	if err != nil {
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to get relative path for private key")
+	}
+	sshArgs, err := ansibleSSHArgs(ansibleDir, keyPath)
+	if err != nil {
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to get relative path for known hosts")
+	}
+	inventory := fmt.Sprintf(`[django_servers]
+%s ansible_user=azureuser ansible_ssh_private_key_file=%s ansible_connection=ssh ansible_ssh_common_args='%s'
+`, placement.PublicIP, filepath.ToSlash(relKeyPath), sshArgs)
+	if err := os.WriteFile(filepath.Join(ansibleDir, "inventory.ini"), []byte(inventory), 0644); err != nil {
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to write inventory file")
+	}
+	playbook := ds.generatePooledPlaybook(req, placement)
+	if err := os.WriteFile(filepath.Join(ansibleDir, "playbook.yml"), []byte(playbook), 0644); err != nil {
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsibleConfig, false, err, "failed to write playbook file")
+	}
+
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventAnsibleStarted, "Running Ansible playbook on pool VM...", "ansible", nil)
+	if err := ds.runAnsiblePlaybook(ansibleDir, broadcaster, deploymentID); err != nil {
+		ds.broadcastEvent(broadcaster, deploymentID, "error", EventAnsibleFailed, fmt.Sprintf("Failed to run ansible playbook: %v", err), "ansible", nil)
+		return "", types.NewDeploymentError("ansible", types.ErrCodeAnsiblePlaybook, false, err, "failed to run ansible playbook")
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventAnsibleSucceeded, "Ansible playbook execution completed successfully", "ansible", nil)
+	ds.reportHostKey(placement.PublicIP, keyPath, broadcaster, deploymentID)
+
+	if err := ds.runHooks(req, HookPostDeploy, deploymentID, workDir, placement.PublicIP, broadcaster); err != nil {
+		return "", err
+	}
+
+	succeeded = true
+	address := fmt.Sprintf("%s:%d", placement.PublicIP, placement.Port)
+	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Deployment completed successfully! App is at http://%s", address), "completed")
+	return address, nil
+}
+
+func waitForSSH(host, keyPath string, attempts int, interval time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if _, err = runRemoteCommand(host, keyPath, "true", 30*time.Second); err == nil {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+	return err
+}
+
+// generatePooledPlaybook deploys one app on a pool VM: its own Unix user,
+// checkout and venv, a gunicorn bound to localhost under supervisor, and an
+// nginx server block on the app's public port.
+func (ds *DeploymentService) generatePooledPlaybook(req *DeploymentRequest, placement *PoolPlacement) string {
+	var envVars strings.Builder
+	for key, value := range req.EnvVariables {
+		envVars.WriteString(fmt.Sprintf("      %s: %q\n", key, value))
+	}
+	if envVars.Len() == 0 {
+		envVars.WriteString("      {}\n")
+	}
+
+	workerClass := "sync"
+	serverModule := "wsgi"
+	extraPackages := ""
+	upgradeHeaders := ""
+	if req.ASGI {
+		// Server blocks of pooled apps share the http context, so the
+		// client's Connection header is passed through rather than mapped.
+		upgradeHeaders = `
+                  proxy_http_version 1.1;
+                  proxy_set_header Upgrade $http_upgrade;
+                  proxy_set_header Connection $http_connection;
+                  proxy_read_timeout 3600s;`
+		workerClass = "uvicorn.workers.UvicornWorker"
+		serverModule = "asgi"
+		extraPackages = ` "uvicorn[standard]"`
+	}
+
+	return fmt.Sprintf(`---
+- name: Deploy pooled Django application
+  hosts: django_servers
+  become: yes
+  vars:
+    repo_url: %q
+    github_token: %q
+    public_ip: %q
+    app_user: %q
+    app_port: %d
+    internal_port: %d
+    app_home: "/home/{{ app_user }}"
+    env_vars:
+%s%s
+  tasks:
+    - name: Install shared packages
+      apt:
+        name:
+          - python3
+          - python3-pip
+          - python3-dev
+          - python3-venv
+          - git
+          - nginx
+          - supervisor
+          - build-essential
+          - libpq-dev
+          - pkg-config
+          - default-libmysqlclient-dev
+        state: present
+        update_cache: yes
+        cache_valid_time: 3600
+
+    - name: Create app user
+      user:
+        name: "{{ app_user }}"
+        shell: /bin/bash
+        create_home: yes
+
+    - name: Restrict app home directory
+      file:
+        path: "{{ app_home }}"
+        mode: '0750'
+
+    - name: Clone repository
+      git:
+        repo: "https://{{ github_token }}@{{ repo_url | regex_replace('https://') }}"
+        dest: "{{ app_home }}/app"
+        force: yes
+      become_user: "{{ app_user }}"
+
+    - name: Create virtual environment
+      command: python3 -m venv "{{ app_home }}/venv"
+      args:
+        creates: "{{ app_home }}/venv/bin/python3"
+      become_user: "{{ app_user }}"
+
+    - name: Install Python dependencies
+      shell: |
+        source "{{ app_home }}/venv/bin/activate"
+        python -m pip install --upgrade pip
+        REQ=$(find "{{ app_home }}/app" -name requirements.txt -not -path "*/venv/*" | head -1)
+        if [ -n "$REQ" ]; then python -m pip install -r "$REQ" --no-cache-dir; fi
+        python -m pip install gunicorn whitenoise%s
+      args:
+        executable: /bin/bash
+      become_user: "{{ app_user }}"
+
+    - name: Detect Django project
+      shell: |
+        MANAGE_PY=$(find "{{ app_home }}/app" -name manage.py -not -path "*/venv/*" | head -1)
+        SETTINGS=$(grep -oE "[\"'][A-Za-z0-9_.]+\.settings[\"']" "$MANAGE_PY" | head -1 | tr -d "\"'")
+        echo "$(dirname "$MANAGE_PY")|$SETTINGS"
+      args:
+        executable: /bin/bash
+      register: django_detect
+      become_user: "{{ app_user }}"
+
+    - name: Set Django facts
+      set_fact:
+        django_project_path: "{{ django_detect.stdout.split('|')[0] }}"
+        django_settings_module: "{{ django_detect.stdout.split('|')[1] }}"
+        django_server_module: "{{ django_detect.stdout.split('|')[1] | regex_replace('\\.settings$', '.%s') }}"
+
+    - name: Allow the pool VM address in ALLOWED_HOSTS
+      shell: |
+        SETTINGS_FILE="{{ django_project_path }}/{{ django_settings_module | replace('.', '/') }}.py"
+        if [ -f "$SETTINGS_FILE" ] && ! grep -q "django-vpc pooled host" "$SETTINGS_FILE"; then
+          printf '\n# django-vpc pooled host\nALLOWED_HOSTS = list(ALLOWED_HOSTS) + ["{{ public_ip }}", "localhost"]\n' >> "$SETTINGS_FILE"
+        fi
+      args:
+        executable: /bin/bash
+      become_user: "{{ app_user }}"
+
+    - name: Write environment file
+      copy:
+        content: |
+          {%% for key, value in env_vars.items() %%}
+          {{ key }}={{ value | to_json if '\n' in value else value }}
+          {%% endfor %%}
+        dest: "{{ app_home }}/app/.env"
+        owner: "{{ app_user }}"
+        group: "{{ app_user }}"
+        mode: '0600'
+
+    - name: Run migrations and collect static files
+      shell: |
+        cd "{{ django_project_path }}"
+        source "{{ app_home }}/venv/bin/activate"
+        export DJANGO_SETTINGS_MODULE="{{ django_settings_module }}"
+        python manage.py migrate --noinput
+        python manage.py collectstatic --noinput || true
+      args:
+        executable: /bin/bash
+      become_user: "{{ app_user }}"
+      environment: "{{ env_vars | combine(secret_env) }}"
+
+    - name: Create supervisor program
+      copy:
+        content: |
+          [program:{{ app_user }}]
+          command={{ app_home }}/venv/bin/gunicorn {{ django_server_module }}:application --bind 127.0.0.1:{{ internal_port }} --workers 2 --worker-class %s --timeout 120
+          directory={{ django_project_path }}
+          user={{ app_user }}
+          autostart=true
+          autorestart=true
+          stopasgroup=true
+          killasgroup=true
+          stdout_logfile=/var/log/supervisor/{{ app_user }}.log
+          stderr_logfile=/var/log/supervisor/{{ app_user }}.err.log
+          environment=HOME="{{ app_home }}",DJANGO_SETTINGS_MODULE="{{ django_settings_module }}"{%% for key, value in env_vars.items() %%},{{ key }}="{{ value }}"{%% endfor %%}{%% for key, value in secret_env.items() %%},{{ key }}="{{ value | replace('%%', '%%%%') | replace('"', '\\"') }}"{%% endfor %%}
+        dest: "/etc/supervisor/conf.d/{{ app_user }}.conf"
+        mode: '0600'
+      no_log: "{{ secret_env | length > 0 }}"
+
+    - name: Reload supervisor programs
+      shell: supervisorctl reread && supervisorctl update && supervisorctl restart {{ app_user }}
+
+    - name: Create nginx server block
+      copy:
+        content: |
+          server {
+              listen {{ app_port }};
+              server_name _;
+              client_max_body_size 20M;
+
+              location / {
+                  proxy_pass http://127.0.0.1:{{ internal_port }};
+                  proxy_set_header Host $host;
+                  proxy_set_header X-Real-IP $remote_addr;
+                  proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+                  proxy_set_header X-Forwarded-Proto $scheme;%s
+              }
+          }
+        dest: "/etc/nginx/sites-available/{{ app_user }}"
+
+    - name: Enable nginx server block
+      file:
+        src: "/etc/nginx/sites-available/{{ app_user }}"
+        dest: "/etc/nginx/sites-enabled/{{ app_user }}"
+        state: link
+
+    - name: Reload nginx
+      shell: nginx -t && systemctl reload nginx
+`, req.RepoURL, req.GithubToken, placement.PublicIP, placement.UnixUser, placement.Port, placement.Port+1000,
+		envVars.String(), generateSecretVars(req.Secrets), extraPackages, serverModule, workerClass, upgradeHeaders)
+}
@@ -0,0 +1,33 @@
+package services
+
+import "strings"
+
+// Priority classes of queued deployments. A higher class runs first when
+// workers are scarce.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// ValidPriority reports whether value is a priority class.
+func ValidPriority(value string) bool {
+	return value == PriorityHigh || value == PriorityNormal || value == PriorityLow
+}
+
+// Priority returns the request's priority class. Without one, deployments
+// to a "production" or "prod" environment are high, preview environments,
+// named "preview" or starting with "preview-" or "pr-", are low and the
+// rest normal.
+func Priority(req *DeploymentRequest) string {
+	if req.Priority != "" {
+		return req.Priority
+	}
+	switch environment := req.Environment; {
+	case environment == "production" || environment == "prod":
+		return PriorityHigh
+	case environment == "preview" || strings.HasPrefix(environment, "preview-") || strings.HasPrefix(environment, "pr-"):
+		return PriorityLow
+	}
+	return PriorityNormal
+}
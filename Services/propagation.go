@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// DNS states of a DomainCheck.
+const (
+	DNSPropagated = "propagated"
+	// DNSPending means the domain has no A record yet.
+	DNSPending = "pending"
+	// DNSMismatch means the domain resolves, but not to the deployment.
+	DNSMismatch = "mismatch"
+)
+
+// TLS states of a DomainCheck. The playbook sets up no certificate, so
+// TLSNone is expected until one is added in front of the app.
+const (
+	TLSValid   = "valid"
+	TLSInvalid = "invalid"
+	TLSNone    = "none"
+)
+
+// Domain verification retries for DomainCheckAttempts times, waiting
+// DomainCheckInterval between attempts, before the deployment finishes with
+// the domains reported as pending. Certificates expiring within
+// certExpiryWarning are reported.
+const (
+	DomainCheckAttempts = 10
+	DomainCheckInterval = 30 * time.Second
+	certExpiryWarning   = 14 * 24 * time.Hour
+	domainCheckTimeout  = 10 * time.Second
+)
+
+// DomainCheck is the state of one of a deployment's domains, as seen from
+// the API host.
+type DomainCheck struct {
+	Domain    string   `json:"domain"`
+	DNS       string   `json:"dns"`
+	Addresses []string `json:"addresses,omitempty"`
+	// HTTPStatus is the status of GET http://<domain>/ once DNS points at
+	// the deployment.
+	HTTPStatus    int        `json:"http_status,omitempty"`
+	TLS           string     `json:"tls,omitempty"`
+	CertExpiresAt *time.Time `json:"cert_expires_at,omitempty"`
+	// Ready is set once the domain resolves to the deployment, the app
+	// answers it below 400 and any certificate it serves is valid.
+	Ready   bool   `json:"ready"`
+	Message string `json:"message"`
+}
+
+// CheckDomain resolves domain, and if it points at address, requests the
+// app through it and inspects the certificate served on port 443. address
+// may be a hostname, as it is for existing servers.
+func CheckDomain(domain, address string) DomainCheck {
+	check := DomainCheck{Domain: domain}
+	ctx, cancel := context.WithTimeout(context.Background(), domainCheckTimeout)
+	defer cancel()
+
+	expected := []string{address}
+	if net.ParseIP(address) == nil {
+		if resolved, err := net.DefaultResolver.LookupHost(ctx, address); err == nil {
+			expected = resolved
+		}
+	}
+
+	addresses, err := net.DefaultResolver.LookupIP(ctx, "ip4", domain)
+	for _, ip := range addresses {
+		check.Addresses = append(check.Addresses, ip.String())
+	}
+	switch {
+	case err != nil || len(check.Addresses) == 0:
+		check.DNS = DNSPending
+		check.Message = "DNS not propagated yet: no A record"
+		return check
+	case !slices.ContainsFunc(check.Addresses, func(ip string) bool { return slices.Contains(expected, ip) }):
+		check.DNS = DNSMismatch
+		check.Message = fmt.Sprintf("DNS not propagated yet: resolves to %s, not %s", strings.Join(check.Addresses, ", "), address)
+		return check
+	}
+	check.DNS = DNSPropagated
+
+	client := &http.Client{
+		Timeout: domainCheckTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + domain + "/")
+	if err != nil {
+		check.Message = fmt.Sprintf("DNS propagated, but the site is unreachable: %v", err)
+		return check
+	}
+	resp.Body.Close()
+	check.HTTPStatus = resp.StatusCode
+
+	checkCertificate(&check)
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		check.Message = "DNS propagated, but the app answers 400; the domain may be missing from ALLOWED_HOSTS"
+	case resp.StatusCode >= http.StatusBadRequest:
+		check.Message = fmt.Sprintf("DNS propagated, but the site answers %s", resp.Status)
+	case check.TLS == TLSInvalid:
+		check.Message = "Site is up, but its certificate is not valid"
+	default:
+		check.Ready = true
+		check.Message = "Site is up"
+		if check.TLS == TLSNone {
+			check.Message += " over HTTP; no certificate is served"
+		} else if check.CertExpiresAt != nil && time.Until(*check.CertExpiresAt) < certExpiryWarning {
+			check.Message += fmt.Sprintf("; its certificate expires %s", check.CertExpiresAt.Format(time.RFC3339))
+		}
+	}
+	return check
+}
+
+// checkCertificate sets the TLS state of check from the certificate served
+// for its domain on port 443.
+func checkCertificate(check *DomainCheck) {
+	dialer := &net.Dialer{Timeout: domainCheckTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(check.Domain, "443"), &tls.Config{ServerName: check.Domain})
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			check.TLS = TLSInvalid
+		} else {
+			check.TLS = TLSNone
+		}
+		return
+	}
+	defer conn.Close()
+
+	check.TLS = TLSValid
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		expires := certs[0].NotAfter
+		check.CertExpiresAt = &expires
+	}
+}
+
+// CheckDomains checks each of the request's domains against address.
+func CheckDomains(req *DeploymentRequest, address string) []DomainCheck {
+	checks := make([]DomainCheck, 0, len(req.Domains))
+	for _, domain := range req.Domains {
+		checks = append(checks, CheckDomain(strings.ToLower(domain), address))
+	}
+	return checks
+}
+
+// verifyDomains waits for the request's domains to reach the deployment,
+// reporting what is still missing after each attempt. Domains that are not
+// ready after DomainCheckAttempts do not fail the deployment, since DNS can
+// take hours to propagate, but the deployment no longer reports plain
+// success for them. It returns whether every domain is ready.
+func (ds *DeploymentService) verifyDomains(req *DeploymentRequest, address string, broadcaster types.LogBroadcaster, deploymentID string) bool {
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Verifying domains %s...", strings.Join(req.Domains, ", ")), "domains")
+
+	var checks []DomainCheck
+	for attempt := 1; ; attempt++ {
+		checks = CheckDomains(req, address)
+		var pending []string
+		for _, check := range checks {
+			if !check.Ready {
+				pending = append(pending, fmt.Sprintf("%s: %s", check.Domain, check.Message))
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if attempt == DomainCheckAttempts {
+			ds.broadcastEvent(broadcaster, deploymentID, "warn", EventDomainsPending,
+				fmt.Sprintf("Domains are not serving the app yet, check again later: %s", strings.Join(pending, "; ")), "domains",
+				map[string]interface{}{"domains": checks})
+			return false
+		}
+		ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("%s, retrying in %s (%d/%d)", strings.Join(pending, "; "), DomainCheckInterval, attempt, DomainCheckAttempts), "domains")
+		time.Sleep(DomainCheckInterval)
+	}
+
+	for _, check := range checks {
+		ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("%s: %s", check.Domain, check.Message), "domains")
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "success", EventDomainsReady, "All domains are serving the app", "domains",
+		map[string]interface{}{"domains": checks})
+	return true
+}
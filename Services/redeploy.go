@@ -0,0 +1,193 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// appScriptTimeout bounds a redeploy or rollback script on the server; most
+// of it is installing requirements.
+const appScriptTimeout = 30 * time.Minute
+
+// appUpdatedMarker is printed last by the redeploy and rollback scripts, so
+// a script cut short is not taken for a successful update.
+const appUpdatedMarker = "DJANGO_VPC_APP_UPDATED"
+
+// commitMarker prefixes the line the scripts print with the commit the app
+// is at once its code is updated.
+const commitMarker = "DJANGO_VPC_COMMIT "
+
+// appScriptSetup reads the request's secrets from the script's input, as
+// from appScriptSecrets, then enters the app's checkout and activates its
+// venv and env variables, so manage.py commands run as the app does.
+const appScriptSetup = `set -e
+SECRETS=()
+while IFS= read -r SECRET; do
+  SECRETS+=("$SECRET")
+done
+cd /home/azureuser/app
+source venv/bin/activate
+if [ -f ` + AppEnvFile + ` ]; then
+  source ` + AppEnvFile + `
+fi
+export PYTHONPATH="/home/azureuser/app:$PYTHONPATH"
+`
+
+// appScriptFinish migrates, collects static files and runs the request's
+// additional commands with its secrets in their environment, as a deploy
+// does, restarts the supervisor programs and prints appUpdatedMarker.
+func appScriptFinish(req *DeploymentRequest) string {
+	var commands strings.Builder
+	for _, command := range req.AdditionalCommands {
+		commands.WriteString("  " + command + "\n")
+	}
+	return fmt.Sprintf(`
+MANAGE_PY=$(find . -path ./venv -prune -o -name manage.py -print | head -1)
+if [ -n "$MANAGE_PY" ]; then
+  if [ ${#SECRETS[@]} -gt 0 ]; then
+    export "${SECRETS[@]}"
+  fi
+  cd "$(dirname "$MANAGE_PY")"
+  echo "Running migrations..."
+  python manage.py migrate --noinput
+  echo "Collecting static files..."
+  python manage.py collectstatic --noinput || echo "collectstatic failed, continuing"
+%s  cd /home/azureuser/app
+fi
+
+echo "Restarting the app..."
+sudo supervisorctl restart all
+sudo supervisorctl status || true
+echo %s
+`, commands.String(), appUpdatedMarker)
+}
+
+// encodeScript wraps a bash script in a command that runs it whatever the
+// user's login shell is, leaving the command's input to the script.
+func encodeScript(script string) string {
+	return fmt.Sprintf(`bash -c "$(echo %s | base64 -d)"`, base64.StdEncoding.EncodeToString([]byte(script)))
+}
+
+// appScriptSecrets is the input of the redeploy and rollback scripts: the
+// request's secrets as KEY=VALUE lines. Secrets are single lines, and
+// passing them over the SSH session keeps them out of the script, which
+// shows up in the server's process list, and off its disk.
+func appScriptSecrets(req *DeploymentRequest) []byte {
+	var input strings.Builder
+	for _, key := range sortedKeys(req.Secrets) {
+		input.WriteString(key + "=" + req.Secrets[key] + "\n")
+	}
+	return []byte(input.String())
+}
+
+// RedeployScript updates the app in /home/azureuser/app to the latest commit
+// of its branch, installs its requirements into the venv, runs migrate and
+// collectstatic with the app's env variables and the request's additional
+// commands, and restarts the supervisor programs. It runs as the VM user,
+// like the auto-deploy workflow.
+func RedeployScript(req *DeploymentRequest) string {
+	return encodeScript(appScriptSetup + `
+echo "Pulling the latest code..."
+git fetch --prune origin
+git reset --hard "@{upstream}"
+git log -1 --format="Now at %h %s"
+echo "` + commitMarker + `$(git rev-parse HEAD)"
+
+REQUIREMENTS=$(find . -path ./venv -prune -o -name requirements.txt -print | head -1)
+if [ -n "$REQUIREMENTS" ]; then
+  echo "Installing requirements from $REQUIREMENTS..."
+  python -m pip install -r "$REQUIREMENTS" --no-cache-dir
+fi
+` + appScriptFinish(req))
+}
+
+// Redeploy updates the app on a deployment's existing VM at publicIP without
+// provisioning anything: it runs RedeployScript over SSH with the key kept
+// in terraformDir, streaming its output under the "redeploy" step. It
+// returns the commit the app is now at.
+func (ds *DeploymentService) Redeploy(req *DeploymentRequest, publicIP, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	return ds.runAppScript(req, publicIP, terraformDir, "redeploy", store.RevisionRedeploy, RedeployScript(req), types.ErrCodeRedeploy, req.SnapshotBeforeDeploy, broadcaster, deploymentID)
+}
+
+// runAppScript runs a script that updates the app on the VM at publicIP
+// over SSH with the key kept in terraformDir, streaming its output under
+// step. The Terraform lock is held meanwhile, so no other operation
+// changes the VM, and the VM is snapshotted first if snapshot is set. The
+// commit the script reports is announced as a revision of the app made by
+// action, and returned. Secret values are redacted from its logs and
+// returned error, as they are from Deploy's.
+func (ds *DeploymentService) runAppScript(req *DeploymentRequest, publicIP, terraformDir, step, action, script, code string, snapshot bool, broadcaster types.LogBroadcaster, deploymentID string) (_ string, runErr error) {
+	ds.redactor = newSecretRedactor(redactedSecrets(req))
+	defer func() {
+		if runErr != nil {
+			runErr = ds.redactError(runErr, step)
+		}
+	}()
+
+	cloud, lockName, err := newCloudProvider(req)
+	if err != nil {
+		return "", types.NewDeploymentError(step, types.ErrCodeInvalidRequest, false, err, "failed to derive resource names")
+	}
+	cloud.SetContext(ds.context())
+	privateKeyPath := filepath.Join(terraformDir, "azure_vm_key")
+	if _, err := os.Stat(privateKeyPath); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Private key not found at %s: %v", privateKeyPath, err), step)
+		return "", types.NewDeploymentError(step, types.ErrCodeSSHKeys, false, err, "the deployment's SSH key is missing")
+	}
+
+	unlock := ds.lockTerraform(lockName, broadcaster, deploymentID)
+	defer unlock()
+
+	// An app-only deployment's VM is its infra-only deployment's, which
+	// the request does not address.
+	if azure, ok := cloud.(*providers.AzureProvider); ok && snapshot && Mode(req) != ModeAppOnly {
+		ds.snapshotBeforeDeploy(azure, fmt.Sprintf("pre-%s-%s-%s", step, deploymentID, time.Now().Format("20060102-150405")), broadcaster, deploymentID)
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Updating the app on %s...", publicIP), step)
+	var commit string
+	output, err := streamRemoteCommand(publicIP, privateKeyPath, script, appScriptSecrets(req), appScriptTimeout, func(line string) {
+		switch {
+		case strings.HasPrefix(line, commitMarker):
+			commit = strings.TrimPrefix(line, commitMarker)
+			ds.broadcastEvent(broadcaster, deploymentID, "info", EventAppRevision, fmt.Sprintf("App is at commit %s", commit), step,
+				map[string]interface{}{"commit": commit, "action": action})
+		case line != appUpdatedMarker:
+			ds.broadcastLog(broadcaster, deploymentID, "info", line, step)
+		}
+	})
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Updating the app failed: %v", err), step)
+		var sshErr *SSHError
+		if errors.As(err, &sshErr) && sshErr.Stage != "command" && sshErr.Stage != "timeout" {
+			return commit, types.NewDeploymentError(step, types.ErrCodeSSHUnreachable, true, err, "failed to reach the server")
+		}
+		return commit, types.NewDeploymentError(step, code, false, err, step+" script failed")
+	}
+	if !strings.Contains(output, appUpdatedMarker) {
+		return commit, types.NewDeploymentError(step, code, false, nil, step+" script did not finish")
+	}
+	return commit, nil
+}
+
+// reportRevision announces the commit the app on host is at after a
+// deployment. Failing to read it does not fail the deployment.
+func (ds *DeploymentService) reportRevision(host, privateKeyPath string, broadcaster types.LogBroadcaster, deploymentID string) {
+	output, err := runRemoteCommand(host, privateKeyPath, "git -C /home/azureuser/app rev-parse HEAD", 30*time.Second)
+	commit := strings.TrimSpace(output)
+	if err != nil || commit == "" {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to read the deployed commit: %v", err), "ansible")
+		return
+	}
+	ds.broadcastEvent(broadcaster, deploymentID, "info", EventAppRevision, fmt.Sprintf("App is at commit %s", commit), "ansible",
+		map[string]interface{}{"commit": commit, "action": store.RevisionDeploy})
+}
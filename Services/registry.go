@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+)
+
+// ProviderRegistration describes a cloud a request can deploy to.
+type ProviderRegistration struct {
+	// DefaultLocation is used when a request sets no location.
+	DefaultLocation string
+	// DefaultVMSize picks the size used when a request sets no vm_size,
+	// usually from its architecture and GPU options.
+	DefaultVMSize func(req *DeploymentRequest) string
+	// MinDiskSizeGB is the smallest disk_size_gb the cloud accepts. Zero
+	// means its disk size follows vm_size and disk_size_gb is refused.
+	MinDiskSizeGB int
+	// New builds the provider for a request whose VM is named vmName. It
+	// also returns the name the provider's Terraform runs are locked under.
+	New func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error)
+}
+
+var (
+	providerRegistry    = make(map[string]ProviderRegistration)
+	providerRegistryMux sync.RWMutex
+)
+
+// RegisterProvider makes a cloud selectable by name with the provider field
+// of a request. Registering a name again replaces it.
+func RegisterProvider(name string, registration ProviderRegistration) {
+	providerRegistryMux.Lock()
+	defer providerRegistryMux.Unlock()
+	providerRegistry[name] = registration
+}
+
+// SupportedProviders returns the names of the registered clouds, sorted.
+func SupportedProviders() []string {
+	providerRegistryMux.RLock()
+	defer providerRegistryMux.RUnlock()
+
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsSupportedProvider reports whether name is a registered cloud.
+func IsSupportedProvider(name string) bool {
+	_, ok := lookupProvider(name)
+	return ok
+}
+
+// DiskSizeRange returns the disk_size_gb values a cloud accepts, or false
+// if its disk size follows vm_size.
+func DiskSizeRange(name string) (int, int, bool) {
+	registration, ok := lookupProvider(name)
+	if !ok || registration.MinDiskSizeGB == 0 {
+		return 0, 0, false
+	}
+	return registration.MinDiskSizeGB, MaxDiskSizeGB, true
+}
+
+func lookupProvider(name string) (ProviderRegistration, bool) {
+	providerRegistryMux.RLock()
+	defer providerRegistryMux.RUnlock()
+	registration, ok := providerRegistry[name]
+	return registration, ok
+}
+
+// newCloudProvider returns the provider for the request's cloud and the name
+// its Terraform runs are locked under.
+func newCloudProvider(req *DeploymentRequest) (providers.CloudProvider, string, error) {
+	registration, ok := lookupProvider(Cloud(req))
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported provider %q", Cloud(req))
+	}
+	vmName, err := VMName(req)
+	if err != nil {
+		return nil, "", err
+	}
+	return registration.New(req, vmName)
+}
+
+// The built-in clouds. Only Azure and AKS have resource groups; the others
+// lock their Terraform runs on the VM name, which is unique per target as
+// well. An existing server has no Terraform runs and is locked on its
+// address.
+func init() {
+	RegisterProvider(CloudAzure, ProviderRegistration{
+		DefaultLocation: DefaultLocation,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			switch {
+			case req.GPU:
+				return DefaultGPUVMSize
+			case req.Architecture == "arm64":
+				return DefaultARM64VMSize
+			}
+			return DefaultVMSize
+		},
+		MinDiskSizeGB: providers.DefaultDiskSizeGB,
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			resourceGroup, err := ResourceGroupName(req)
+			if err != nil {
+				return nil, "", err
+			}
+			azure := &providers.AzureProvider{
+				ResourceGroup:      resourceGroup,
+				Location:           Location(req),
+				SubscriptionID:     req.SubscriptionID,
+				Credentials:        req.AzureCredentials,
+				VMSize:             VMSize(req),
+				VMName:             vmName,
+				DiskSizeGB:         req.DiskSizeGB,
+				Spot:               req.UseSpot,
+				SpotMaxPrice:       req.SpotMaxPrice,
+				SpotEvictionPolicy: req.SpotEvictionPolicy,
+				Image:              req.Image,
+				Backend:            AzureBackend(req),
+			}
+			if req.StaticIP {
+				azure.StaticIPName = vmName + "-ip"
+			}
+			if HasDataDisk(req) {
+				azure.DataDiskName = vmName + "-data"
+				azure.DataDiskSizeGB = req.DataDiskSizeGB
+			}
+			if req.ExistingResourceGroup != "" {
+				azure.ExistingResourceGroup = true
+			}
+			azure.SubnetID = req.SubnetID
+			azure.Private = req.PrivateNetworking
+			azure.SSHSourcePrefixes = SSHSourcePrefixes(req)
+			azure.OpenPorts = OpenPortNumbers(req)
+			setShutdownSchedule(azure, AutoShutdown(req), AutoShutdownTimezone(req))
+			if req.ScaleSet {
+				azure.ScaleSet = true
+				azure.Instances = ScaleSetInstances(req)
+			}
+			return azure, resourceGroup, nil
+		},
+	})
+
+	RegisterProvider(CloudAWS, ProviderRegistration{
+		DefaultLocation: DefaultAWSRegion,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			switch {
+			case req.GPU:
+				return DefaultAWSGPUInstanceType
+			case req.Architecture == "arm64":
+				return DefaultAWSARM64InstanceType
+			}
+			return DefaultAWSInstanceType
+		},
+		MinDiskSizeGB: providers.DefaultDiskSizeGB,
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.AWSProvider{
+				Name:         vmName,
+				Region:       Location(req),
+				InstanceType: VMSize(req),
+				VolumeSizeGB: req.DiskSizeGB,
+			}, vmName, nil
+		},
+	})
+
+	RegisterProvider(CloudDigitalOcean, ProviderRegistration{
+		DefaultLocation: DefaultDigitalOceanRegion,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			return DefaultDigitalOceanSize
+		},
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.DigitalOceanProvider{
+				Name:   vmName,
+				Region: Location(req),
+				Size:   VMSize(req),
+			}, vmName, nil
+		},
+	})
+
+	RegisterProvider(CloudHetzner, ProviderRegistration{
+		DefaultLocation: DefaultHetznerLocation,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			if req.Architecture == "arm64" {
+				return DefaultHetznerARM64ServerType
+			}
+			return DefaultHetznerServerType
+		},
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.HetznerProvider{
+				Name:       vmName,
+				Location:   Location(req),
+				ServerType: VMSize(req),
+			}, vmName, nil
+		},
+	})
+
+	RegisterProvider(CloudLinode, ProviderRegistration{
+		DefaultLocation: DefaultLinodeRegion,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			return DefaultLinodeType
+		},
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.LinodeProvider{
+				Name:   vmName,
+				Region: Location(req),
+				Type:   VMSize(req),
+			}, vmName, nil
+		},
+	})
+
+	RegisterProvider(CloudOCI, ProviderRegistration{
+		DefaultLocation: DefaultOCIRegion,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			if req.Architecture == "x64" {
+				return DefaultOCIX64Shape
+			}
+			return DefaultOCIShape
+		},
+		MinDiskSizeGB: providers.DefaultOCIBootVolumeSizeGB,
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.OCIProvider{
+				Name:             vmName,
+				Region:           Location(req),
+				Shape:            VMSize(req),
+				BootVolumeSizeGB: req.DiskSizeGB,
+			}, vmName, nil
+		},
+	})
+
+	RegisterProvider(CloudBYOS, ProviderRegistration{
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			return &providers.BYOSProvider{
+				Host:       req.ServerHost,
+				User:       req.ServerUser,
+				PrivateKey: req.ServerSSHKey,
+			}, req.ServerHost, nil
+		},
+	})
+
+	RegisterProvider(CloudAKS, ProviderRegistration{
+		DefaultLocation: DefaultLocation,
+		DefaultVMSize: func(req *DeploymentRequest) string {
+			return DefaultAKSNodeSize
+		},
+		MinDiskSizeGB: providers.DefaultDiskSizeGB,
+		New: func(req *DeploymentRequest, vmName string) (providers.CloudProvider, string, error) {
+			resourceGroup, err := ResourceGroupName(req)
+			if err != nil {
+				return nil, "", err
+			}
+			return &providers.AKSProvider{
+				ResourceGroup:  resourceGroup,
+				Location:       Location(req),
+				SubscriptionID: req.SubscriptionID,
+				Credentials:    req.AzureCredentials,
+				ClusterName:    dnsLabel(strings.TrimSuffix(vmName, "-vm")+"-aks", 54),
+				RegistryName:   AKSRegistryName(resourceGroup),
+				NodeSize:       VMSize(req),
+				NodeCount:      DefaultAKSNodeCount,
+				NodeDiskSizeGB: req.DiskSizeGB,
+				Kubeconfig:     req.Kubeconfig,
+				Registry:       req.ContainerRegistry,
+			}, resourceGroup, nil
+		},
+	})
+}
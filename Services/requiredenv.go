@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// providedEnv returns the variables the app will see from env_variables
+// and secrets. Only the names matter to its callers.
+func providedEnv(req *DeploymentRequest) map[string]string {
+	provided := make(map[string]string, len(req.EnvVariables)+len(req.Secrets))
+	for key, value := range req.EnvVariables {
+		provided[key] = value
+	}
+	for key := range req.Secrets {
+		provided[key] = ""
+	}
+	return provided
+}
+
+// MissingEnv returns the names in required that the request provides
+// neither in env_variables nor in secrets, sorted. Variables the deployment
+// sets itself, like DJANGO_SETTINGS_MODULE, never count as missing.
+func MissingEnv(req *DeploymentRequest, required []string) []string {
+	provided := providedEnv(req)
+	seen := make(map[string]bool)
+	var missing []string
+	for _, name := range required {
+		if _, ok := provided[name]; ok || platformEnv[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// MissingEnvError describes the missing variables for a client to act on.
+func MissingEnvError(missing []string) error {
+	return fmt.Errorf("missing required env variables: %s", strings.Join(missing, ", "))
+}
+
+// checkRequiredEnv fails the deployment before anything is provisioned if
+// it omits variables the project's djangovpc.yaml requires or its code
+// reads without a default, which would otherwise crash the app at start.
+// The code is only checked if the repository can be analyzed.
+func (ds *DeploymentService) checkRequiredEnv(req *DeploymentRequest, broadcaster types.LogBroadcaster, deploymentID string) error {
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Checking required env variables...", "setup")
+	required := req.RequiredEnv
+
+	report, err := ds.AnalyzeRepository(req)
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Could not analyze repository for required env variables, continuing: %v", err), "setup")
+	} else {
+		required = append(append([]string(nil), required...), report.MissingEnv...)
+	}
+
+	if missing := MissingEnv(req, required); len(missing) > 0 {
+		err := MissingEnvError(missing)
+		ds.broadcastEvent(broadcaster, deploymentID, "error", "", err.Error(), "setup", map[string]interface{}{"missing_env": missing})
+		return types.NewDeploymentError("setup", types.ErrCodeMissingEnv, false, err, err.Error())
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "success", "All required env variables are set", "setup")
+	return nil
+}
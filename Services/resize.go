@@ -0,0 +1,56 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+)
+
+const (
+	// defaultGunicornWorkers is used when the VM's core count is unknown.
+	defaultGunicornWorkers = 3
+	// maxGunicornWorkers caps the workers of large VMs, whose memory would
+	// otherwise be the limit.
+	maxGunicornWorkers = 17
+)
+
+// ResizeDowntime is how long the app is typically unreachable while its VM
+// is deallocated, resized and started again.
+const ResizeDowntime = 5 * time.Minute
+
+// GunicornWorkers returns the number of gunicorn workers for the request's
+// VM: gunicorn's recommended 2 per core plus one on Azure, where the size
+// names the core count, and 3 elsewhere.
+func GunicornWorkers(req *DeploymentRequest) int {
+	if Cloud(req) != CloudAzure {
+		return defaultGunicornWorkers
+	}
+	vcpus := providers.VMVCPUs(VMSize(req))
+	if vcpus == 0 {
+		return defaultGunicornWorkers
+	}
+	return min(2*vcpus+1, maxGunicornWorkers)
+}
+
+// workersResizedMarker ends the output of a ResizeWorkersScript that
+// succeeded.
+const workersResizedMarker = "django-vpc: workers resized"
+
+// WorkersResized reports whether output is that of a ResizeWorkersScript
+// that succeeded.
+func WorkersResized(output string) bool {
+	return strings.Contains(output, workersResizedMarker)
+}
+
+// ResizeWorkersScript sets the gunicorn worker count in start_server.sh and
+// restarts the app's supervisor programs.
+func ResizeWorkersScript(workers int) string {
+	return fmt.Sprintf(`set -e
+sed -i -E 's/--workers [0-9]+/--workers %d/' /home/azureuser/app/start_server.sh
+supervisorctl restart all
+supervisorctl status || true
+echo %q
+`, workers, workersResizedMarker)
+}
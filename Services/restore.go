@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+const restoreDiskLUN = 10
+
+// restoreDataScript mounts the backup disk read-only and copies the files
+// git does not track (SQLite databases, uploaded media and the like) into
+// the freshly deployed app. Code, the virtualenv and the generated .env and
+// start script come from the new deployment, so the restored data picks up
+// the new host's settings.
+var restoreDataScript = fmt.Sprintf(`set -e
+DEV=$(readlink -f /dev/disk/azure/scsi1/lun%d)
+sudo mkdir -p /mnt/restore
+sudo mount -o ro,noload "${DEV}1" /mnt/restore
+trap 'cd / && sudo umount /mnt/restore' EXIT
+cd /mnt/restore/home/azureuser/app
+sudo git -c safe.directory='*' ls-files --others -z | grep -zvE '^(venv/|\.env$|start_server\.sh$)' > /tmp/restore-files || true
+sudo supervisorctl stop django-server || true
+sudo rsync -a --from0 --files-from=/tmp/restore-files /mnt/restore/home/azureuser/app/ /home/azureuser/app/
+sudo chown -R azureuser:azureuser /home/azureuser/app
+sudo supervisorctl start django-server
+echo "Restored $(tr -cd '\0' < /tmp/restore-files | wc -c) files"
+`, restoreDiskLUN)
+
+// restoreAppData attaches a disk created from a backup snapshot to the new
+// VM, copies the application's data files off it and removes it again.
+func (ds *DeploymentService) restoreAppData(azure *providers.AzureProvider, snapshotID, publicIP, privateKeyPath string, broadcaster types.LogBroadcaster, deploymentID string) error {
+	diskName := fmt.Sprintf("%s-restore-%s", azure.VMName, time.Now().Format("20060102-150405"))
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Attaching backup disk...", "restore")
+	if err := azure.AttachDiskFromSnapshot(snapshotID, diskName, restoreDiskLUN); err != nil {
+		return fmt.Errorf("failed to attach backup disk: %v", err)
+	}
+	defer func() {
+		ds.broadcastLog(broadcaster, deploymentID, "info", "Removing backup disk...", "restore")
+		if err := azure.DetachAndDeleteDisk(diskName); err != nil {
+			ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to remove backup disk %s: %v", diskName, err), "restore")
+		}
+	}()
+
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Copying application data from backup disk...", "restore")
+	output, err := runRemoteCommand(publicIP, privateKeyPath, restoreDataScript, 30*time.Minute)
+	if err != nil {
+		return err
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", output, "restore")
+	return nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// commitPattern matches an abbreviated or full commit SHA.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// ValidCommit reports whether commit is a commit SHA, full or abbreviated.
+func ValidCommit(commit string) bool {
+	return commitPattern.MatchString(commit)
+}
+
+// RollbackScript checks out commit in /home/azureuser/app, or without one
+// the commit the app was at before, reinstalls the requirements if they
+// differ, migrates and restarts the app. The database is left alone unless
+// the migrations allow it: if the current code has migrations that commit
+// does not, the script stops before changing anything, or with
+// unapplyMigrations first reverses them with the current code, app by app,
+// down to the last migration commit has.
+func RollbackScript(req *DeploymentRequest, commit string, unapplyMigrations bool) string {
+	return encodeScript(appScriptSetup + fmt.Sprintf(`
+git fetch --prune origin || echo "Failed to fetch, using the commits on the server"
+CURRENT=$(git rev-parse HEAD)
+TARGET='%s'
+if [ -z "$TARGET" ]; then
+  TARGET=$(git rev-parse --verify -q 'HEAD@{1}' || git rev-parse --verify -q 'HEAD~1' || true)
+fi
+if [ -z "$TARGET" ] || ! TARGET=$(git rev-parse --verify -q "$TARGET^{commit}"); then
+  echo "Commit to roll back to was not found"
+  exit 4
+fi
+echo "Rolling back from $CURRENT to $TARGET"
+
+MANAGE_PY=$(find . -path ./venv -prune -o -name manage.py -print | head -1)
+NEWER=$(git diff --name-only --diff-filter=A "$TARGET" "$CURRENT" -- '*migrations/[0-9]*.py')
+if [ -n "$NEWER" ] && [ -n "$MANAGE_PY" ]; then
+  echo "Migrations added after $TARGET:"
+  echo "$NEWER"
+  if [ "%t" != true ]; then
+    echo "Not rolling back past these migrations; retry with unapply_migrations to reverse them first"
+    exit 5
+  fi
+  if [ ${#SECRETS[@]} -gt 0 ]; then
+    export "${SECRETS[@]}"
+  fi
+  for DIR in $(echo "$NEWER" | xargs -n1 dirname | sort -u); do
+    APP=$(basename "$(dirname "$DIR")")
+    LAST=$(git ls-tree --name-only "$TARGET" "$DIR/" | grep -E '/[0-9]+_[^/]*\.py$' | sort | tail -1 || true)
+    NAME=zero
+    if [ -n "$LAST" ]; then
+      NAME=$(basename "$LAST" .py)
+    fi
+    echo "Reversing the migrations of $APP to $NAME..."
+    (cd "$(dirname "$MANAGE_PY")" && python manage.py migrate "$APP" "$NAME" --noinput)
+  done
+fi
+
+git reset --hard "$TARGET"
+git log -1 --format="Now at %%h %%s"
+echo "%s$(git rev-parse HEAD)"
+
+REQUIREMENTS=$(find . -path ./venv -prune -o -name requirements.txt -print | head -1)
+if [ -n "$REQUIREMENTS" ] && ! git diff --quiet "$CURRENT" "$TARGET" -- "$REQUIREMENTS"; then
+  echo "Requirements changed, reinstalling from $REQUIREMENTS..."
+  python -m pip install -r "$REQUIREMENTS" --no-cache-dir
+fi
+`, commit, unapplyMigrations, commitMarker) + appScriptFinish(req))
+}
+
+// Rollback checks out commit, or the commit before the current one if it is
+// empty, on a deployment's VM at publicIP with RollbackScript, streaming its
+// output under the "rollback" step. It returns the commit the app is now at.
+// The script migrates the database to an older commit's migrations, so the
+// VM is always snapshotted first.
+func (ds *DeploymentService) Rollback(req *DeploymentRequest, publicIP, terraformDir, commit string, unapplyMigrations bool, broadcaster types.LogBroadcaster, deploymentID string) (string, error) {
+	if commit != "" && !ValidCommit(commit) {
+		return "", types.NewDeploymentError("rollback", types.ErrCodeInvalidRequest, false, nil, fmt.Sprintf("invalid commit %q", commit))
+	}
+	return ds.runAppScript(req, publicIP, terraformDir, "rollback", store.RevisionRollback, RollbackScript(req, commit, unapplyMigrations), types.ErrCodeRollback, true, broadcaster, deploymentID)
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// scaleSetHosts returns the public addresses of a scale set's instances
+// from the instance_ips Terraform output.
+func (ds *DeploymentService) scaleSetHosts(cloud providers.CloudProvider, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) ([]string, error) {
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Retrieving scale set instance addresses...", "network")
+	output, err := cloud.GetOutput(terraformDir, "instance_ips")
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "error", fmt.Sprintf("Failed to get instance addresses: %v", err), "network")
+		return nil, types.NewDeploymentError("network", types.ErrCodeTerraformOutput, true, err, "failed to get scale set instance addresses")
+	}
+
+	var hosts []string
+	for _, ip := range strings.Split(output, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			hosts = append(hosts, ip)
+		}
+	}
+	if len(hosts) == 0 {
+		ds.broadcastLog(broadcaster, deploymentID, "error", "Scale set has no instance addresses", "network")
+		return nil, types.NewDeploymentError("network", types.ErrCodeTerraformOutput, true, nil, "scale set has no instance addresses")
+	}
+
+	ds.broadcastLog(broadcaster, deploymentID, "success", fmt.Sprintf("Scale set instances: %s", strings.Join(hosts, ", ")), "network")
+	return hosts, nil
+}
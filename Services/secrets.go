@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// secretEnvPrefix prefixes the environment variables that carry secrets
+// into ansible-playbook. The playbook only holds env lookups of these, so
+// secret values are never written to disk.
+const secretEnvPrefix = "DJANGO_VPC_SECRET_"
+
+const redactedValue = "[REDACTED]"
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// generateSecretVars renders the secret_env playbook variable, which maps
+// each secret to a lookup of its ansible-playbook environment variable.
+func generateSecretVars(secrets map[string]string) string {
+	if len(secrets) == 0 {
+		return "    secret_env: {}\n"
+	}
+
+	var vars strings.Builder
+	vars.WriteString("    secret_env:\n")
+	for _, key := range sortedKeys(secrets) {
+		vars.WriteString(fmt.Sprintf("      %s: \"{{ lookup('env', '%s%s') }}\"\n", key, secretEnvPrefix, key))
+	}
+	return vars.String()
+}
+
+// secretProcessEnv returns the environment entries that pass secrets to
+// ansible-playbook.
+func secretProcessEnv(secrets map[string]string) []string {
+	env := make([]string, 0, len(secrets))
+	for _, key := range sortedKeys(secrets) {
+		env = append(env, secretEnvPrefix+key+"="+secrets[key])
+	}
+	return env
+}
+
+// redactedSecrets returns the request's secrets and the other values that
+// must never appear in its logs.
+func redactedSecrets(req *DeploymentRequest) map[string]string {
+	if req.AzureCredentials == nil || req.AzureCredentials.ClientSecret == "" {
+		return req.Secrets
+	}
+	secrets := make(map[string]string, len(req.Secrets)+1)
+	for key, value := range req.Secrets {
+		secrets[key] = value
+	}
+	secrets["azure_credentials.client_secret"] = req.AzureCredentials.ClientSecret
+	return secrets
+}
+
+// newSecretRedactor replaces secret values with a placeholder. Longer
+// values come first so a secret containing another is redacted whole.
+func newSecretRedactor(secrets map[string]string) *strings.Replacer {
+	values := make([]string, 0, len(secrets))
+	for _, value := range secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, redactedValue)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (ds *DeploymentService) redact(message string) string {
+	if ds.redactor == nil {
+		return message
+	}
+	return ds.redactor.Replace(message)
+}
+
+// redactError returns err as a *types.DeploymentError of stage, with secret
+// values redacted from its message and cause.
+func (ds *DeploymentService) redactError(err error, stage string) *types.DeploymentError {
+	derr := types.AsDeploymentError(err, stage)
+	if ds.redactor == nil {
+		return derr
+	}
+	redacted := *derr
+	redacted.Message = ds.redact(derr.Message)
+	if derr.Cause != nil {
+		redacted.Cause = errors.New(ds.redact(derr.Cause.Error()))
+	}
+	return &redacted
+}
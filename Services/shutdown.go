@@ -0,0 +1,92 @@
+package services
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+)
+
+// Azure VMs shut down daily at DefaultAutoShutdown in
+// DefaultAutoShutdownTimezone unless the request or AUTO_SHUTDOWN and
+// AUTO_SHUTDOWN_TIMEZONE say otherwise. AutoShutdownOff disables it, which
+// apps serving production traffic want.
+const (
+	DefaultAutoShutdown         = "19:00"
+	DefaultAutoShutdownTimezone = "UTC"
+	AutoShutdownOff             = "off"
+)
+
+var (
+	shutdownTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+	// Azure takes Windows time zone IDs, like "W. Europe Standard Time".
+	shutdownTimezonePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 .()+-]{0,63}$`)
+)
+
+// ValidAutoShutdown reports whether value is an HH:MM time or
+// AutoShutdownOff.
+func ValidAutoShutdown(value string) bool {
+	return value == AutoShutdownOff || shutdownTimePattern.MatchString(value)
+}
+
+// ValidAutoShutdownTimezone reports whether value looks like a Windows time
+// zone ID.
+func ValidAutoShutdownTimezone(value string) bool {
+	return shutdownTimezonePattern.MatchString(value)
+}
+
+// AutoShutdown returns the request's auto-shutdown time, HH:MM or
+// AutoShutdownOff. Scale sets have no shutdown schedule.
+func AutoShutdown(req *DeploymentRequest) string {
+	if req.ScaleSet {
+		return AutoShutdownOff
+	}
+	if req.AutoShutdown != "" {
+		return req.AutoShutdown
+	}
+	return defaultAutoShutdown()
+}
+
+func defaultAutoShutdown() string {
+	if value := strings.ToLower(os.Getenv("AUTO_SHUTDOWN")); ValidAutoShutdown(value) {
+		return value
+	}
+	return DefaultAutoShutdown
+}
+
+// AutoShutdownTimezone returns the time zone of the request's auto-shutdown
+// time.
+func AutoShutdownTimezone(req *DeploymentRequest) string {
+	if req.AutoShutdownTimezone != "" {
+		return req.AutoShutdownTimezone
+	}
+	return defaultAutoShutdownTimezone()
+}
+
+func defaultAutoShutdownTimezone() string {
+	if value := os.Getenv("AUTO_SHUTDOWN_TIMEZONE"); ValidAutoShutdownTimezone(value) {
+		return value
+	}
+	return DefaultAutoShutdownTimezone
+}
+
+// AutoShutdownSummary describes the request's auto-shutdown schedule, like
+// "19:00 UTC" or "off".
+func AutoShutdownSummary(req *DeploymentRequest) string {
+	shutdown := AutoShutdown(req)
+	if shutdown == AutoShutdownOff {
+		return AutoShutdownOff
+	}
+	return shutdown + " " + AutoShutdownTimezone(req)
+}
+
+// setShutdownSchedule gives azure the schedule of shutdown, HH:MM or
+// AutoShutdownOff, in timezone.
+func setShutdownSchedule(azure *providers.AzureProvider, shutdown, timezone string) {
+	if shutdown == AutoShutdownOff {
+		return
+	}
+	azure.ShutdownTime = strings.Replace(shutdown, ":", "", 1)
+	azure.ShutdownTimezone = timezone
+}
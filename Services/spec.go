@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SpecFile is the deployment spec a repository can keep at its root.
+const SpecFile = "djangovpc.yaml"
+
+const maxSpecSize = 64 << 10
+
+var pythonVersionPattern = regexp.MustCompile(`^3\.\d{1,2}$`)
+
+// ProjectSpec is the deployment config a project versions in SpecFile:
+//
+//	python: "3.11"
+//	asgi: true
+//	redis: true
+//	required_env: [SECRET_KEY, DATABASE_URL]
+//	services:
+//	  - name: worker
+//	    command: celery -A myproject worker
+//	ports:
+//	  - port: 5555
+//	    path: /flower/
+//
+// Services without a repo_url run from the project's own repository. Ports
+// are the request's open_ports and are checked like them once applied.
+type ProjectSpec struct {
+	Python      string        `yaml:"python" json:"python,omitempty"`
+	ASGI        bool          `yaml:"asgi" json:"asgi,omitempty"`
+	Redis       bool          `yaml:"redis" json:"redis,omitempty"`
+	RequiredEnv []string      `yaml:"required_env" json:"required_env,omitempty"`
+	Services    []ServiceSpec `yaml:"services" json:"services,omitempty"`
+	Ports       []OpenPort    `yaml:"ports" json:"ports,omitempty"`
+}
+
+// ValidPythonVersion reports whether version is a Python 3 release the
+// playbook can install, like 3.11.
+func ValidPythonVersion(version string) bool {
+	return pythonVersionPattern.MatchString(version)
+}
+
+// ParseProjectSpec parses and checks the contents of a SpecFile.
+func ParseProjectSpec(data []byte) (*ProjectSpec, error) {
+	var spec ProjectSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", SpecFile, err)
+	}
+	if spec.Python != "" && !ValidPythonVersion(spec.Python) {
+		return nil, fmt.Errorf("invalid %s: python must be a version like 3.11", SpecFile)
+	}
+	for _, name := range spec.RequiredEnv {
+		if !envKeyPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s: %q is not an environment variable name", SpecFile, name)
+		}
+	}
+	return &spec, nil
+}
+
+// LoadProjectSpec reads SpecFile from the default branch of the request's
+// repository. It returns nil if the repository has none.
+func (ds *DeploymentService) LoadProjectSpec(req *DeploymentRequest) (*ProjectSpec, error) {
+	owner, repo, err := ds.extractOwnerAndRepo(req.RepoURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %v", err)
+	}
+
+	file, _, resp, err := githubAPI(req.GithubToken).Repositories.GetContents(githubContext(), owner, repo, SpecFile, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", SpecFile, githubError(err))
+	}
+	if file == nil || file.GetSize() > maxSpecSize {
+		return nil, fmt.Errorf("%s must be a file of at most %d bytes", SpecFile, maxSpecSize)
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", SpecFile, err)
+	}
+	return ParseProjectSpec([]byte(content))
+}
+
+// ApplyProjectSpec fills in the request from the project's spec. Anything
+// the request sets itself wins: its python_version, services and open_ports
+// replace the spec's, and asgi and redis are on if either turns them on. The
+// spec's required_env is added to the request's.
+func ApplyProjectSpec(req *DeploymentRequest, spec *ProjectSpec) {
+	if req.PythonVersion == "" {
+		req.PythonVersion = spec.Python
+	}
+	for _, name := range spec.RequiredEnv {
+		if !slices.Contains(req.RequiredEnv, name) {
+			req.RequiredEnv = append(req.RequiredEnv, name)
+		}
+	}
+	req.ASGI = req.ASGI || spec.ASGI
+	req.Redis = req.Redis || spec.Redis
+	if len(req.Services) == 0 {
+		for _, service := range spec.Services {
+			if service.RepoURL == "" {
+				service.RepoURL = req.RepoURL
+			}
+			req.Services = append(req.Services, service)
+		}
+	}
+	if len(req.OpenPorts) == 0 {
+		req.OpenPorts = spec.Ports
+	}
+}
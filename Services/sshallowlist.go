@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controllerAddressURL answers with the public address requests from this
+// server come from. CONTROLLER_ADDRESS overrides it, e.g. when the server
+// reaches deployments through a NAT gateway with a known address.
+const controllerAddressURL = "https://api.ipify.org"
+
+var controllerAddress struct {
+	sync.Mutex
+	address string
+}
+
+// ControllerAddress returns the public address this server connects to
+// deployments from, which must be allowed to SSH to them for Ansible to
+// run. A successful lookup is cached for the life of the process.
+func ControllerAddress() (string, error) {
+	controllerAddress.Lock()
+	defer controllerAddress.Unlock()
+
+	if controllerAddress.address != "" {
+		return controllerAddress.address, nil
+	}
+	address := strings.TrimSpace(os.Getenv("CONTROLLER_ADDRESS"))
+	if address == "" {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(controllerAddressURL)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up this server's public address: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+		if err != nil {
+			return "", fmt.Errorf("failed to look up this server's public address: %v", err)
+		}
+		address = strings.TrimSpace(string(body))
+	}
+	if net.ParseIP(address) == nil {
+		return "", fmt.Errorf("this server's public address %q is not an IP address", address)
+	}
+	controllerAddress.address = address
+	return address, nil
+}
+
+// HostCIDR returns the single-address CIDR of ip.
+func HostCIDR(ip string) string {
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
+// SSHSourcePrefixes returns the sources an Azure VM's NSG allows SSH from:
+// the request's ssh_allowed_cidrs, plus this server so it can configure the
+// VM, or the virtual network for private VMs, which this server reaches on
+// their private address. It returns nil, leaving SSH open, if the request
+// allows no CIDRs.
+func SSHSourcePrefixes(req *DeploymentRequest) []string {
+	if len(req.SSHAllowedCIDRs) == 0 {
+		return nil
+	}
+	var prefixes []string
+	add := func(prefix string) {
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	for _, cidr := range req.SSHAllowedCIDRs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			cidr = HostCIDR(ip.String())
+		} else if _, network, err := net.ParseCIDR(cidr); err == nil {
+			// Azure rejects prefixes with host bits set.
+			cidr = network.String()
+		}
+		add(cidr)
+	}
+	if req.PrivateNetworking {
+		add("VirtualNetwork")
+	} else if address, err := ControllerAddress(); err == nil {
+		add(HostCIDR(address))
+	}
+	return prefixes
+}
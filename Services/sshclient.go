@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshUser        = "azureuser"
+	sshDialTimeout = 10 * time.Second
+)
+
+// SSHError describes which stage of a remote command failed so callers can
+// tell an unreachable host from a rejected key or a failing command.
+type SSHError struct {
+	Stage    string // "key", "dial", "host_key", "auth", "session", "timeout" or "command"
+	Host     string
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func (e *SSHError) Error() string {
+	switch e.Stage {
+	case "command":
+		return fmt.Sprintf("ssh command on %s exited with status %d: %s", e.Host, e.ExitCode, e.Output)
+	case "timeout":
+		return fmt.Sprintf("ssh command on %s timed out: %v", e.Host, e.Err)
+	case "host_key":
+		return fmt.Sprintf("host key of %s does not match the one pinned on first connection; refusing to connect: %v", e.Host, e.Err)
+	}
+	return fmt.Sprintf("ssh %s error for %s: %v", e.Stage, e.Host, e.Err)
+}
+
+func (e *SSHError) Unwrap() error {
+	return e.Err
+}
+
+// sshConfig loads a private key into a client config for user, checking
+// host keys with hostKeys.
+func sshConfig(user string, keyBytes []byte, hostKeys ssh.HostKeyCallback) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// dialSSH opens an SSH connection to host on port 22 as the VM user with the
+// key at keyPath, pinning its host key in the known_hosts file next to it.
+func dialSSH(host, keyPath string) (*ssh.Client, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, &SSHError{Stage: "key", Host: host, Err: err}
+	}
+	return dialSSHAs(host, sshUser, keyBytes, KnownHostsPath(keyPath))
+}
+
+// dialSSHAs opens an SSH connection to host on port 22 as user with the
+// given private key. The host key is pinned in the known_hosts file at
+// knownHosts on first contact and checked against it after that.
+func dialSSHAs(host, user string, keyBytes []byte, knownHosts string) (*ssh.Client, error) {
+	config, err := sshConfig(user, keyBytes, pinningHostKeyCallback(knownHosts))
+	if err != nil {
+		return nil, &SSHError{Stage: "key", Host: host, Err: err}
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(host, "22"), config)
+	if err != nil {
+		stage := "dial"
+		if hostKeyChanged(err) {
+			stage = "host_key"
+		} else if _, ok := err.(net.Error); !ok {
+			stage = "auth"
+		}
+		return nil, &SSHError{Stage: stage, Host: host, Err: err}
+	}
+	return client, nil
+}
+
+// runRemoteCommand runs command on host and returns its combined output.
+func runRemoteCommand(host, keyPath, command string, timeout time.Duration) (string, error) {
+	client, err := dialSSH(host, keyPath)
+	if err != nil {
+		return "", err
+	}
+	return runSSHCommand(client, host, command, timeout)
+}
+
+// streamRemoteCommand runs command on host like runRemoteCommand with stdin
+// as its input, passing each line of its output to logLine as soon as it is
+// written.
+func streamRemoteCommand(host, keyPath, command string, stdin []byte, timeout time.Duration, logLine func(string)) (string, error) {
+	client, err := dialSSH(host, keyPath)
+	if err != nil {
+		return "", err
+	}
+	lines := &lineWriter{emit: logLine}
+	defer lines.Flush()
+	return runSSHCommandTo(client, host, command, stdin, timeout, lines)
+}
+
+// runSSHCommand runs command over client, which it closes, and returns its
+// combined output.
+func runSSHCommand(client *ssh.Client, host, command string, timeout time.Duration) (string, error) {
+	return runSSHCommandTo(client, host, command, nil, timeout, nil)
+}
+
+// runSSHCommandTo is runSSHCommand with stdin as the command's input that
+// also copies the output to w, if it is not nil, as it is written.
+func runSSHCommandTo(client *ssh.Client, host, command string, stdin []byte, timeout time.Duration, w io.Writer) (string, error) {
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", &SSHError{Stage: "session", Host: host, Err: err}
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	var out io.Writer = &output
+	if w != nil {
+		out = io.MultiWriter(&output, w)
+	}
+	// Stdout and stderr are copied concurrently.
+	out = &lockedWriter{w: out}
+	session.Stdout = out
+	session.Stderr = out
+	if stdin != nil {
+		session.Stdin = bytes.NewReader(stdin)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		client.Close()
+		return "", &SSHError{Stage: "timeout", Host: host, Err: fmt.Errorf("no result after %s", timeout)}
+	}
+
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return output.String(), &SSHError{Stage: "command", Host: host, ExitCode: exitErr.ExitStatus(), Output: output.String(), Err: err}
+		}
+		return output.String(), &SSHError{Stage: "session", Host: host, Output: output.String(), Err: err}
+	}
+	return output.String(), nil
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.w.Write(p)
+}
+
+// lineWriter passes each complete line written to it to emit.
+type lineWriter struct {
+	mux     sync.Mutex
+	pending []byte
+	emit    func(string)
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			break
+		}
+		l.emitLine(l.pending[:i])
+		l.pending = l.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits the last line if it did not end with a newline.
+func (l *lineWriter) Flush() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.emitLine(l.pending)
+	l.pending = nil
+}
+
+func (l *lineWriter) emitLine(line []byte) {
+	if text := strings.TrimSpace(string(line)); text != "" {
+		l.emit(text)
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// ConfigRecorder keeps the main.tf and playbook each deployment was
+// provisioned with, so they can be compared with what a later release
+// generates or with another deployment's, and the Terraform state and
+// variables of its last apply, so its infrastructure can be checked for
+// drift. The state and variables hold the deployment's SSH private key.
+type ConfigRecorder interface {
+	RecordTerraform(deploymentID string, versions providers.TerraformVersions, mainTF []byte) error
+	RecordPlaybook(deploymentID string, playbook []byte) error
+	RecordState(deploymentID string, state, tfvars []byte) error
+	RecordWorkspace(deploymentID, terraformDir string) error
+}
+
+func (ds *DeploymentService) SetConfigRecorder(recorder ConfigRecorder) {
+	ds.recorder = recorder
+}
+
+// ConfigHash returns the hex SHA-256 of a generated file.
+func ConfigHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordTerraform hands the generated main.tf in terraformDir to the
+// recorder. Failing to record it does not fail the deployment.
+func (ds *DeploymentService) recordTerraform(cloud providers.CloudProvider, terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) {
+	versions, ok := providers.ConfigVersions(cloud)
+	if ds.recorder == nil || !ok {
+		return
+	}
+	mainTF, err := os.ReadFile(filepath.Join(terraformDir, "main.tf"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Existing clusters generate no configuration.
+		return
+	}
+	if err == nil {
+		err = ds.recorder.RecordTerraform(deploymentID, versions, mainTF)
+	}
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record Terraform configuration: %v", err), "terraform")
+		return
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Terraform template %s, providers %s", versions.Template, formatProviderVersions(versions)), "terraform")
+}
+
+// recordState hands the state and variables left in terraformDir by a
+// successful apply to the recorder. Failing to record them does not fail
+// the deployment, but it cannot be checked for drift.
+func (ds *DeploymentService) recordState(terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) {
+	if ds.recorder == nil {
+		return
+	}
+	state, err := os.ReadFile(filepath.Join(terraformDir, "terraform.tfstate"))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var tfvars []byte
+	if err == nil {
+		tfvars, err = os.ReadFile(filepath.Join(terraformDir, "terraform.tfvars"))
+	}
+	if err == nil {
+		err = ds.recorder.RecordState(deploymentID, state, tfvars)
+	}
+	if err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record Terraform state: %v", err), "terraform")
+	}
+}
+
+// keepWorkspace hands terraformDir to the recorder to keep once the run
+// ends, if anything was provisioned from it, since the work directory is
+// then removed and the infrastructure could no longer be destroyed or
+// re-applied with its state.
+func (ds *DeploymentService) keepWorkspace(terraformDir string, broadcaster types.LogBroadcaster, deploymentID string) {
+	if ds.recorder == nil || !provisionedFrom(terraformDir) {
+		return
+	}
+	if err := ds.recorder.RecordWorkspace(deploymentID, terraformDir); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to keep the Terraform directory: %v", err), "cleanup")
+		return
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", "Kept the Terraform directory and state for later destroy or re-apply", "cleanup")
+}
+
+// provisionedFrom reports whether dir holds a configuration or state,
+// which it does not for pooled deployments, existing servers and runs that
+// failed before provisioning.
+func provisionedFrom(dir string) bool {
+	for _, name := range []string{"main.tf", "terraform.tfstate", "Pulumi.yaml", "azure_outputs.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func formatProviderVersions(versions providers.TerraformVersions) string {
+	var parts []string
+	for _, source := range sortedKeys(versions.Providers) {
+		parts = append(parts, source+" "+versions.Providers[source])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RenderPlaybook generates the playbook this release would configure req's
+// server at publicIP with. The GitHub token and environment values are
+// redacted, so the playbook can be stored and shown; they come from the
+// request, not from the tool.
+func RenderPlaybook(req *DeploymentRequest, publicIP string) []byte {
+	redacted := *req
+	if redacted.GithubToken != "" {
+		redacted.GithubToken = redactedValue
+	}
+	redacted.EnvVariables = redactValues(req.EnvVariables)
+	redacted.Services = make([]ServiceSpec, len(req.Services))
+	for i, spec := range req.Services {
+		spec.EnvVariables = redactValues(spec.EnvVariables)
+		redacted.Services[i] = spec
+	}
+	return []byte(NewDeploymentService().generatePlaybook(&redacted, publicIP))
+}
+
+func redactValues(values map[string]string) map[string]string {
+	redacted := make(map[string]string, len(values))
+	for key := range values {
+		redacted[key] = redactedValue
+	}
+	return redacted
+}
+
+// recordPlaybook hands the redacted playbook for the server at publicIP to
+// the recorder. Failing to record it does not fail the deployment.
+func (ds *DeploymentService) recordPlaybook(req *DeploymentRequest, publicIP string, broadcaster types.LogBroadcaster, deploymentID string) {
+	if ds.recorder == nil {
+		return
+	}
+	playbook := RenderPlaybook(req, publicIP)
+	if err := ds.recorder.RecordPlaybook(deploymentID, playbook); err != nil {
+		ds.broadcastLog(broadcaster, deploymentID, "warn", fmt.Sprintf("Failed to record playbook: %v", err), "ansible")
+		return
+	}
+	ds.broadcastLog(broadcaster, deploymentID, "info", fmt.Sprintf("Playbook sha256 %s", ConfigHash(playbook)[:12]), "ansible")
+}
+
+// RenderTerraformConfig generates the configuration this release would
+// provision req with and returns its versions and main.tf. It returns false
+// for clouds that generate none.
+func RenderTerraformConfig(req *DeploymentRequest) (providers.TerraformVersions, []byte, bool, error) {
+	cloud, _, err := newCloudProvider(req)
+	if err != nil {
+		return providers.TerraformVersions{}, nil, false, err
+	}
+	versions, ok := providers.ConfigVersions(cloud)
+	if !ok {
+		return versions, nil, false, nil
+	}
+
+	dir, err := os.MkdirTemp("", "django-vpc-render-")
+	if err != nil {
+		return versions, nil, false, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := cloud.GenerateTerraformConfig(dir); err != nil {
+		return versions, nil, false, err
+	}
+	mainTF, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if errors.Is(err, os.ErrNotExist) {
+		return versions, nil, false, nil
+	}
+	if err != nil {
+		return versions, nil, false, err
+	}
+	return versions, mainTF, true, nil
+}
+
+// DiffLines returns a unified-style diff of two texts, with unchanged lines
+// prefixed by a space, removed ones by - and added ones by +. Runs of
+// unchanged lines are cut down to context lines around each change. It
+// returns "" if the texts are equal.
+func DiffLines(oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+
+	// Keep changed lines and the context around them.
+	keep := make([]bool, len(lines))
+	for n, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+		for k := max(0, n-context); k <= min(len(lines)-1, n+context); k++ {
+			keep[k] = true
+		}
+	}
+	var out strings.Builder
+	skipped := false
+	for n, line := range lines {
+		if !keep[n] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			out.WriteString("@@\n")
+			skipped = false
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
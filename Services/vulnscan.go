@@ -0,0 +1,291 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// Vulnerability scanners, the Source of the findings they report.
+const (
+	ScannerPipAudit = "pip-audit"
+	ScannerTrivy    = "trivy"
+)
+
+const (
+	imageScanTimeout = 15 * time.Minute
+	osvTimeout       = 10 * time.Second
+	osvVulnURL       = "https://api.osv.dev/v1/vulns/"
+)
+
+// pipAuditMarker ends the output of a PipAuditScript that ran to
+// completion, followed by the number of findings.
+const pipAuditMarker = "django-vpc: pip-audit complete"
+
+// pipAuditPrefix starts each finding line of PipAuditScript's output,
+// followed by the tab separated package, version, advisory ID, aliases and
+// fix versions. Errors are reported on a pipAuditPrefix-error line.
+const pipAuditPrefix = "django-vpc-vuln"
+
+// PipAuditScript audits the packages installed in the app's virtualenv
+// against the PyPI advisory database. pip-audit is installed into its own
+// virtualenv on first use, so it never changes the app's. Run-command
+// keeps only the end of the output, so findings are printed one short line
+// each and the total is printed last to detect a truncated list.
+var PipAuditScript = fmt.Sprintf(`set -u
+APP=/home/azureuser/app
+AUDIT=/opt/django-vpc/pip-audit
+fail() { printf '%[1]s-error\t%%s\n' "$1"; echo "%[2]s 0"; exit 0; }
+[ -x "$APP/venv/bin/pip" ] || fail "no virtualenv at $APP/venv"
+if [ ! -x "$AUDIT/bin/pip-audit" ]; then
+  mkdir -p /opt/django-vpc
+  { python3 -m venv "$AUDIT" && "$AUDIT/bin/pip" install -q pip-audit; } > /tmp/pip-audit-install.log 2>&1 ||
+    fail "could not install pip-audit: $(tail -n 1 /tmp/pip-audit-install.log)"
+fi
+"$APP/venv/bin/pip" freeze --exclude-editable 2>/dev/null | grep -v ' @ ' > /tmp/pip-audit-reqs.txt
+rm -f /tmp/pip-audit.json
+"$AUDIT/bin/pip-audit" -r /tmp/pip-audit-reqs.txt --no-deps --disable-pip --progress-spinner off -f json -o /tmp/pip-audit.json > /tmp/pip-audit.log 2>&1
+[ -s /tmp/pip-audit.json ] || fail "pip-audit failed: $(tail -n 1 /tmp/pip-audit.log)"
+"$AUDIT/bin/python" - <<'PY'
+import json
+data = json.load(open("/tmp/pip-audit.json"))
+deps = data["dependencies"] if isinstance(data, dict) else data
+count = 0
+for dep in deps:
+    for vuln in dep.get("vulns", []):
+        count += 1
+        print("\t".join(["%[1]s", dep["name"], dep.get("version", ""), vuln["id"],
+                         ",".join(vuln.get("aliases", [])), ",".join(vuln.get("fix_versions", []))]))
+print("%[2]s", count)
+PY
+`, pipAuditPrefix, pipAuditMarker)
+
+// ParsePipAudit returns the findings in the output of PipAuditScript, and
+// whether some were lost because run-command truncated the output. Their
+// severity is SeverityUnknown until ResolveSeverities looks it up.
+func ParsePipAudit(output string) ([]store.Vulnerability, bool, error) {
+	var findings []store.Vulnerability
+	total := -1
+	for _, line := range strings.Split(output, "\n") {
+		// Trailing tabs are empty aliases or fix versions.
+		line = strings.Trim(line, " \r")
+		if rest, ok := strings.CutPrefix(line, pipAuditMarker); ok {
+			total, _ = strconv.Atoi(strings.TrimSpace(rest))
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		switch fields[0] {
+		case pipAuditPrefix + "-error":
+			if len(fields) > 1 {
+				return nil, false, fmt.Errorf("%s", fields[1])
+			}
+			return nil, false, fmt.Errorf("pip-audit failed")
+		case pipAuditPrefix:
+			if len(fields) < 6 {
+				continue
+			}
+			findings = append(findings, store.Vulnerability{
+				ID:          fields[3],
+				Aliases:     splitList(fields[4]),
+				Package:     fields[1],
+				Version:     fields[2],
+				FixVersions: splitList(fields[5]),
+				Severity:    store.SeverityUnknown,
+				Source:      ScannerPipAudit,
+			})
+		}
+	}
+	if total < 0 {
+		return nil, false, fmt.Errorf("pip-audit did not complete")
+	}
+	return findings, len(findings) < total, nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// TrivyAvailable reports whether trivy is installed on the API server.
+func TrivyAvailable() bool {
+	_, err := exec.LookPath("trivy")
+	return err == nil
+}
+
+// ScanImage scans a container image with trivy, pulling it with the
+// registry credentials in env if set (TRIVY_USERNAME, TRIVY_PASSWORD).
+func ScanImage(image string, env []string) ([]store.Vulnerability, error) {
+	dir, err := os.MkdirTemp("", "trivy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	report := filepath.Join(dir, "report.json")
+	if _, err := runToolEnv(dir, env, nil, imageScanTimeout, "trivy", "image", "--quiet", "--scanners", "vuln", "--format", "json", "--output", report, image); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrivyReport(data)
+}
+
+func parseTrivyReport(data []byte) ([]store.Vulnerability, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string `json:"VulnerabilityID"`
+				PkgName          string `json:"PkgName"`
+				InstalledVersion string `json:"InstalledVersion"`
+				FixedVersion     string `json:"FixedVersion"`
+				Severity         string `json:"Severity"`
+				Title            string `json:"Title"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode trivy report: %v", err)
+	}
+
+	var findings []store.Vulnerability
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			findings = append(findings, store.Vulnerability{
+				ID:          vuln.VulnerabilityID,
+				Package:     vuln.PkgName,
+				Version:     vuln.InstalledVersion,
+				FixVersions: splitList(strings.ReplaceAll(vuln.FixedVersion, ", ", ",")),
+				Severity:    normalizeSeverity(vuln.Severity),
+				Summary:     vuln.Title,
+				Source:      ScannerTrivy,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// normalizeSeverity maps the severities of trivy and GitHub advisories
+// onto ours.
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "low":
+		return store.SeverityLow
+	case "medium", "moderate":
+		return store.SeverityMedium
+	case "high":
+		return store.SeverityHigh
+	case "critical":
+		return store.SeverityCritical
+	}
+	return store.SeverityUnknown
+}
+
+// SeverityRank orders severities, SeverityUnknown lowest, and returns -1
+// for anything else.
+func SeverityRank(severity string) int {
+	switch severity {
+	case store.SeverityUnknown:
+		return 0
+	case store.SeverityLow:
+		return 1
+	case store.SeverityMedium:
+		return 2
+	case store.SeverityHigh:
+		return 3
+	case store.SeverityCritical:
+		return 4
+	}
+	return -1
+}
+
+// osvAdvisory is what ResolveSeverities keeps of an OSV record.
+type osvAdvisory struct {
+	Severity string
+	Summary  string
+}
+
+// osvCache holds looked up advisories for the life of the process; they
+// rarely change and every deployment of the same stack shares them.
+var osvCache = struct {
+	sync.Mutex
+	advisories map[string]osvAdvisory
+}{advisories: make(map[string]osvAdvisory)}
+
+var osvClient = &http.Client{Timeout: osvTimeout}
+
+// ResolveSeverities fills in the severity and summary of findings from
+// OSV. PyPI advisories carry no severity of their own, so the GitHub
+// advisory among their aliases is used. Findings whose advisories cannot
+// be looked up keep SeverityUnknown.
+func ResolveSeverities(findings []store.Vulnerability) {
+	for i := range findings {
+		if findings[i].Severity != store.SeverityUnknown {
+			continue
+		}
+		ids := []string{findings[i].ID}
+		for _, alias := range findings[i].Aliases {
+			if strings.HasPrefix(alias, "GHSA-") {
+				ids = append([]string{alias}, ids...)
+			}
+		}
+		for _, id := range ids {
+			advisory, err := lookupOSV(id)
+			if err != nil {
+				continue
+			}
+			if findings[i].Summary == "" {
+				findings[i].Summary = advisory.Summary
+			}
+			if advisory.Severity != store.SeverityUnknown {
+				findings[i].Severity = advisory.Severity
+				break
+			}
+		}
+	}
+}
+
+func lookupOSV(id string) (osvAdvisory, error) {
+	osvCache.Lock()
+	advisory, ok := osvCache.advisories[id]
+	osvCache.Unlock()
+	if ok {
+		return advisory, nil
+	}
+
+	resp, err := osvClient.Get(osvVulnURL + id)
+	if err != nil {
+		return osvAdvisory{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return osvAdvisory{}, fmt.Errorf("OSV returned %s for %s", resp.Status, id)
+	}
+	var record struct {
+		Summary          string `json:"summary"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return osvAdvisory{}, fmt.Errorf("failed to decode OSV record %s: %v", id, err)
+	}
+
+	advisory = osvAdvisory{Severity: normalizeSeverity(record.DatabaseSpecific.Severity), Summary: record.Summary}
+	osvCache.Lock()
+	osvCache.advisories[id] = advisory
+	osvCache.Unlock()
+	return advisory, nil
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkspaceDir holds the deployments' work directories, laid out as
+// <username>/<repo>/<timestamp>.
+const WorkspaceDir = "deployments"
+
+// DefaultWorkspaceQuotaMB is used when WORKSPACE_QUOTA_MB is not set.
+const DefaultWorkspaceQuotaMB = 5120
+
+// WorkspaceQuota is the most disk WorkspaceDir may use, in bytes, from
+// WORKSPACE_QUOTA_MB. Zero disables the quota.
+func WorkspaceQuota() int64 {
+	quotaMB := int64(DefaultWorkspaceQuotaMB)
+	if value := os.Getenv("WORKSPACE_QUOTA_MB"); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed >= 0 {
+			quotaMB = parsed
+		}
+	}
+	return quotaMB << 20
+}
+
+// WorkDirUsage is the disk used by one entry of the workspace, normally a
+// work directory. LastUsed is its most recent modification.
+type WorkDirUsage struct {
+	Path     string    `json:"path"`
+	Bytes    int64     `json:"bytes"`
+	LastUsed time.Time `json:"last_used"`
+	Active   bool      `json:"active"`
+}
+
+// WorkspaceUsage is the disk used by WorkspaceDir.
+type WorkspaceUsage struct {
+	TotalBytes int64          `json:"total_bytes"`
+	QuotaBytes int64          `json:"quota_bytes"`
+	WorkDirs   []WorkDirUsage `json:"work_dirs"`
+}
+
+var errWorkspaceFull = errors.New("workspace quota exceeded")
+
+var (
+	// activeWorkDirs holds the work directories of running deployments,
+	// which cleanup never touches.
+	activeWorkDirs   = make(map[string]bool)
+	activeWorkDirMux sync.Mutex
+
+	// workspaceMux serializes quota checks and cleanups.
+	workspaceMux sync.Mutex
+)
+
+// claimWorkDir creates workDir for a running deployment and protects it
+// from cleanup. If the workspace is over quota, idle work directories are
+// cleaned up first; if that is not enough the claim fails with
+// errWorkspaceFull. The returned function releases the claim.
+func claimWorkDir(workDir string) (func(), error) {
+	workspaceMux.Lock()
+	defer workspaceMux.Unlock()
+
+	if quota := WorkspaceQuota(); quota > 0 {
+		if _, err := cleanupWorkspace(quota); err != nil {
+			return nil, err
+		}
+		usage, err := workspaceUsage()
+		if err != nil {
+			return nil, err
+		}
+		if usage.TotalBytes >= quota {
+			return nil, fmt.Errorf("%w: %d MB in use by running deployments, quota is %d MB", errWorkspaceFull, usage.TotalBytes>>20, quota>>20)
+		}
+	}
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, err
+	}
+
+	key := filepath.Clean(workDir)
+	activeWorkDirMux.Lock()
+	activeWorkDirs[key] = true
+	activeWorkDirMux.Unlock()
+
+	return func() {
+		activeWorkDirMux.Lock()
+		delete(activeWorkDirs, key)
+		activeWorkDirMux.Unlock()
+	}, nil
+}
+
+// GetWorkspaceUsage reports the disk used by WorkspaceDir, least recently
+// used entries first.
+func GetWorkspaceUsage() (*WorkspaceUsage, error) {
+	workspaceMux.Lock()
+	defer workspaceMux.Unlock()
+	return workspaceUsage()
+}
+
+// CleanupWorkspace removes idle work directories, least recently used
+// first, until WorkspaceDir uses at most target bytes. A target of zero
+// removes every idle work directory. It returns what was removed.
+func CleanupWorkspace(target int64) ([]WorkDirUsage, error) {
+	workspaceMux.Lock()
+	defer workspaceMux.Unlock()
+	return cleanupWorkspace(target)
+}
+
+func cleanupWorkspace(target int64) ([]WorkDirUsage, error) {
+	usage, err := workspaceUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []WorkDirUsage{}
+	total := usage.TotalBytes
+	for _, entry := range usage.WorkDirs {
+		if total <= target && target > 0 {
+			break
+		}
+		if entry.Active {
+			continue
+		}
+		if err := os.RemoveAll(entry.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %v", entry.Path, err)
+		}
+		total -= entry.Bytes
+		removed = append(removed, entry)
+	}
+
+	removeEmptyDirs(WorkspaceDir)
+	return removed, nil
+}
+
+// workspaceUsage lists the entries at work directory depth, plus any stray
+// files above it. Callers must hold workspaceMux.
+func workspaceUsage() (*WorkspaceUsage, error) {
+	usage := &WorkspaceUsage{QuotaBytes: WorkspaceQuota(), WorkDirs: []WorkDirUsage{}}
+
+	activeWorkDirMux.Lock()
+	active := make(map[string]bool, len(activeWorkDirs))
+	for path := range activeWorkDirs {
+		active[path] = true
+	}
+	activeWorkDirMux.Unlock()
+
+	err := filepath.WalkDir(WorkspaceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == WorkspaceDir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		rel, err := filepath.Rel(WorkspaceDir, path)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if rel == "." || (d.IsDir() && depth < 3) {
+			return nil
+		}
+
+		entry := WorkDirUsage{Path: path, Active: active[filepath.Clean(path)]}
+		if err := measure(path, &entry); err != nil {
+			return err
+		}
+		usage.TotalBytes += entry.Bytes
+		usage.WorkDirs = append(usage.WorkDirs, entry)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure %s: %v", WorkspaceDir, err)
+	}
+
+	sort.Slice(usage.WorkDirs, func(i, j int) bool {
+		return usage.WorkDirs[i].LastUsed.Before(usage.WorkDirs[j].LastUsed)
+	})
+	return usage, nil
+}
+
+// measure adds up the size of everything under path and records its most
+// recent modification time.
+func measure(path string, entry *WorkDirUsage) error {
+	return filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			entry.Bytes += info.Size()
+		}
+		if info.ModTime().After(entry.LastUsed) {
+			entry.LastUsed = info.ModTime()
+		}
+		return nil
+	})
+}
+
+// removeEmptyDirs removes the user and repo directories left empty by a
+// cleanup. Errors are ignored; a directory that is not empty stays.
+func removeEmptyDirs(root string) {
+	users, _ := os.ReadDir(root)
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		userDir := filepath.Join(root, user.Name())
+		repos, _ := os.ReadDir(userDir)
+		for _, repo := range repos {
+			if repo.IsDir() {
+				os.Remove(filepath.Join(userDir, repo.Name()))
+			}
+		}
+		os.Remove(userDir)
+	}
+}
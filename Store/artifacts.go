@@ -0,0 +1,248 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrArtifactNotFound is returned by ArtifactStore.Get for a missing key.
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactStore keeps files that outlive a deployment's records, such as the
+// log of a purged deployment. Keys are slash-separated paths like
+// logs/<deployment>.jsonl.
+type ArtifactStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+var (
+	_ ArtifactStore = (*LocalArtifactStore)(nil)
+	_ ArtifactStore = (*AzureBlobArtifactStore)(nil)
+	_ ArtifactStore = (*S3ArtifactStore)(nil)
+)
+
+// validKey reports whether every element of key is a valid name, so keys
+// cannot climb out of a directory or bucket prefix.
+func validKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if !validName(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalArtifactStore keeps artifacts under a directory on local disk.
+type LocalArtifactStore struct {
+	dir string
+}
+
+func NewLocalArtifactStore(dir string) (*LocalArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %v", err)
+	}
+	return &LocalArtifactStore{dir: dir}, nil
+}
+
+func (s *LocalArtifactStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid artifact key: %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalArtifactStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write artifact: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *LocalArtifactStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact: %v", err)
+	}
+	return data, nil
+}
+
+func (s *LocalArtifactStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete artifact: %v", err)
+	}
+	return nil
+}
+
+// runCLI runs a cloud CLI with stdin as its input and returns its stdout,
+// folding stderr into the error so the cloud's message reaches the caller.
+func runCLI(stdin []byte, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v: %s", name, strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// AzureBlobArtifactStore keeps artifacts as blobs in an Azure Storage
+// container, through the az CLI login Terraform already relies on.
+type AzureBlobArtifactStore struct {
+	Account   string
+	Container string
+	// dir holds the files az uploads from and downloads to.
+	dir string
+}
+
+func NewAzureBlobArtifactStore(account, container, scratchDir string) (*AzureBlobArtifactStore, error) {
+	if account == "" || container == "" {
+		return nil, fmt.Errorf("azure artifact store needs a storage account and container")
+	}
+	if err := os.MkdirAll(scratchDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact scratch directory: %v", err)
+	}
+	return &AzureBlobArtifactStore{Account: account, Container: container, dir: scratchDir}, nil
+}
+
+func (s *AzureBlobArtifactStore) blob(args ...string) ([]byte, error) {
+	args = append([]string{"storage", "blob"}, args...)
+	args = append(args, "--account-name", s.Account, "--container-name", s.Container, "--auth-mode", "login", "--only-show-errors")
+	return runCLI(nil, "az", args...)
+}
+
+func (s *AzureBlobArtifactStore) Put(key string, data []byte) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid artifact key: %q", key)
+	}
+	file, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to stage artifact: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stage artifact: %v", err)
+	}
+	file.Close()
+
+	_, err = s.blob("upload", "--name", key, "--file", file.Name(), "--overwrite")
+	return err
+}
+
+func (s *AzureBlobArtifactStore) Get(key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid artifact key: %q", key)
+	}
+	file, err := os.CreateTemp(s.dir, "download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage artifact: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if _, err := s.blob("download", "--name", key, "--file", file.Name(), "--overwrite"); err != nil {
+		if strings.Contains(err.Error(), "BlobNotFound") {
+			return nil, ErrArtifactNotFound
+		}
+		return nil, err
+	}
+	return os.ReadFile(file.Name())
+}
+
+func (s *AzureBlobArtifactStore) Delete(key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid artifact key: %q", key)
+	}
+	if _, err := s.blob("delete", "--name", key); err != nil && !strings.Contains(err.Error(), "BlobNotFound") {
+		return err
+	}
+	return nil
+}
+
+// S3ArtifactStore keeps artifacts as objects in an S3 bucket under Prefix,
+// through the aws CLI and its usual credential chain.
+type S3ArtifactStore struct {
+	Bucket string
+	Prefix string
+}
+
+func NewS3ArtifactStore(bucket, prefix string) (*S3ArtifactStore, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 artifact store needs a bucket")
+	}
+	return &S3ArtifactStore{Bucket: bucket, Prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (s *S3ArtifactStore) url(key string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid artifact key: %q", key)
+	}
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + key
+	}
+	return "s3://" + s.Bucket + "/" + key, nil
+}
+
+func (s *S3ArtifactStore) Put(key string, data []byte) error {
+	url, err := s.url(key)
+	if err != nil {
+		return err
+	}
+	_, err = runCLI(data, "aws", "s3", "cp", "-", url, "--only-show-errors")
+	return err
+}
+
+func (s *S3ArtifactStore) Get(key string) ([]byte, error) {
+	url, err := s.url(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := runCLI(nil, "aws", "s3", "cp", url, "-", "--only-show-errors")
+	if err != nil {
+		if strings.Contains(err.Error(), "(404)") || strings.Contains(err.Error(), "Not Found") {
+			return nil, ErrArtifactNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Delete removes the object; S3 reports success for a missing key.
+func (s *S3ArtifactStore) Delete(key string) error {
+	url, err := s.url(key)
+	if err != nil {
+		return err
+	}
+	_, err = runCLI(nil, "aws", "s3", "rm", url, "--only-show-errors")
+	return err
+}
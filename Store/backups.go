@@ -0,0 +1,139 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backup is the manifest of a cross-region backup: where the deployment
+// came from, the snapshot copy that holds its disk, and the request needed
+// to rebuild its infrastructure and environment.
+type Backup struct {
+	ID                  string          `json:"id"`
+	DeploymentID        string          `json:"deployment_id"`
+	Username            string          `json:"username"`
+	RepoURL             string          `json:"repo_url"`
+	SourceResourceGroup string          `json:"source_resource_group"`
+	TargetLocation      string          `json:"target_location"`
+	SubscriptionID      string          `json:"subscription_id,omitempty"`
+	ResourceGroup       string          `json:"resource_group"`
+	SnapshotName        string          `json:"snapshot_name"`
+	SnapshotID          string          `json:"snapshot_id,omitempty"`
+	Status              string          `json:"status"`
+	Error               string          `json:"error,omitempty"`
+	CreatedAt           time.Time       `json:"created_at"`
+	CompletedAt         *time.Time      `json:"completed_at,omitempty"`
+	Request             json.RawMessage `json:"request"`
+	// Verification is the outcome of the latest restore drill.
+	Verification *BackupVerification `json:"verification,omitempty"`
+}
+
+// BackupVerification records a restore drill: the backup restored onto a
+// throwaway VM and checked for a usable app and intact data.
+type BackupVerification struct {
+	Status      string        `json:"status"`
+	Checks      []BackupCheck `json:"checks,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt *time.Time    `json:"completed_at,omitempty"`
+}
+
+// BackupCheck is one integrity check of a restore drill.
+type BackupCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// BackupStore keeps one JSON manifest per backup under a directory. The
+// manifests embed the deployment request, so the files are private.
+type BackupStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewBackupStore(dir string) (*BackupStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup store directory: %v", err)
+	}
+	return &BackupStore{dir: dir}, nil
+}
+
+func (s *BackupStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid backup id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *BackupStore) Save(b *Backup) error {
+	path, err := s.path(b.ID)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, b, 0600)
+}
+
+func (s *BackupStore) Get(id string) (*Backup, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to decode backup manifest %s: %v", id, err)
+	}
+	return &b, nil
+}
+
+// List returns every backup ordered by creation time.
+func (s *BackupStore) List() ([]*Backup, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %v", err)
+	}
+
+	var backups []*Backup
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup manifest %s: %v", entry.Name(), err)
+		}
+
+		var b Backup
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("failed to decode backup manifest %s: %v", entry.Name(), err)
+		}
+		backups = append(backups, &b)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.Before(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HealthCheck is one probe of a deployed application.
+type HealthCheck struct {
+	Time       time.Time `json:"time"`
+	OK         bool      `json:"ok"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// HealthStore appends each deployment's health checks to a JSON-lines file.
+type HealthStore struct {
+	dir string
+	mux sync.Mutex
+}
+
+func NewHealthStore(dir string) (*HealthStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create health directory: %v", err)
+	}
+	return &HealthStore{dir: dir}, nil
+}
+
+func (s *HealthStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".jsonl"), nil
+}
+
+func (s *HealthStore) Append(id string, check HealthCheck) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(check)
+	if err != nil {
+		return fmt.Errorf("failed to marshal health check: %v", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open health file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append health check: %v", err)
+	}
+	return nil
+}
+
+// List returns the deployment's checks taken at or after since, oldest
+// first. A deployment that was never checked has no checks.
+func (s *HealthStore) List(id string, since time.Time) ([]HealthCheck, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open health file: %v", err)
+	}
+	defer file.Close()
+
+	var checks []HealthCheck
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var check HealthCheck
+		if err := json.Unmarshal(scanner.Bytes(), &check); err != nil {
+			continue
+		}
+		if !check.Time.Before(since) {
+			checks = append(checks, check)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read health file: %v", err)
+	}
+	return checks, nil
+}
+
+func (s *HealthStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete health file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LogStore appends each deployment's log messages to a JSON-lines file so
+// they outlive the SSE stream.
+type LogStore struct {
+	dir string
+	mux sync.Mutex
+}
+
+func NewLogStore(dir string) (*LogStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	return &LogStore{dir: dir}, nil
+}
+
+func (s *LogStore) Path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".jsonl"), nil
+}
+
+func (s *LogStore) Append(id string, entry interface{}) error {
+	path, err := s.Path(id)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %v", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append log entry: %v", err)
+	}
+	return nil
+}
+
+// Count returns the number of entries in a deployment's log.
+func (s *LogStore) Count(id string) (int64, error) {
+	path, err := s.Path(id)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log file: %v", err)
+	}
+	return int64(bytes.Count(data, []byte{'\n'})), nil
+}
+
+func (s *LogStore) Delete(id string) error {
+	path, err := s.Path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete log file: %v", err)
+	}
+	return nil
+}
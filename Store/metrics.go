@@ -0,0 +1,177 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MetricSample is one reading of a deployment VM's resource usage.
+// CPUPercent is the share of all cores busy over a couple of seconds;
+// memory counts what the kernel cannot reclaim as used. Gunicorn is the
+// master and worker processes of the app server, with their resident
+// memory summed.
+type MetricSample struct {
+	Time                time.Time `json:"time"`
+	CPUPercent          float64   `json:"cpu_percent"`
+	Load1               float64   `json:"load_1m"`
+	CPUs                int       `json:"cpus"`
+	MemoryTotalBytes    int64     `json:"memory_total_bytes"`
+	MemoryUsedBytes     int64     `json:"memory_used_bytes"`
+	DiskTotalBytes      int64     `json:"disk_total_bytes"`
+	DiskUsedBytes       int64     `json:"disk_used_bytes"`
+	GunicornProcesses   int       `json:"gunicorn_processes"`
+	GunicornMemoryBytes int64     `json:"gunicorn_memory_bytes"`
+}
+
+// MemoryPercent returns the share of the VM's memory in use.
+func (s MetricSample) MemoryPercent() float64 {
+	if s.MemoryTotalBytes == 0 {
+		return 0
+	}
+	return 100 * float64(s.MemoryUsedBytes) / float64(s.MemoryTotalBytes)
+}
+
+// DiskPercent returns the share of the OS disk in use.
+func (s MetricSample) DiskPercent() float64 {
+	if s.DiskTotalBytes == 0 {
+		return 0
+	}
+	return 100 * float64(s.DiskUsedBytes) / float64(s.DiskTotalBytes)
+}
+
+// MetricsStore appends each deployment's samples to a JSON-lines file and
+// keeps a rolling window of them.
+type MetricsStore struct {
+	dir string
+	mux sync.Mutex
+}
+
+func NewMetricsStore(dir string) (*MetricsStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create metrics directory: %v", err)
+	}
+	return &MetricsStore{dir: dir}, nil
+}
+
+func (s *MetricsStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".jsonl"), nil
+}
+
+func (s *MetricsStore) Append(id string, sample MetricSample) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric sample: %v", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append metric sample: %v", err)
+	}
+	return nil
+}
+
+// List returns the deployment's samples taken at or after since, oldest
+// first.
+func (s *MetricsStore) List(id string, since time.Time) ([]MetricSample, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return readMetrics(path, since)
+}
+
+// Prune drops the deployment's samples taken before before.
+func (s *MetricsStore) Prune(id string, before time.Time) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	samples, err := readMetrics(path, before)
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	for _, sample := range samples {
+		line, err := json.Marshal(sample)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric sample: %v", err)
+		}
+		data.Write(append(line, '\n'))
+	}
+	return writeFileAtomic(path, data.Bytes(), 0644)
+}
+
+func readMetrics(path string, since time.Time) ([]MetricSample, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metrics file: %v", err)
+	}
+	defer file.Close()
+
+	var samples []MetricSample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample MetricSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		if !sample.Time.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics file: %v", err)
+	}
+	return samples, nil
+}
+
+func (s *MetricsStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete metrics file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PoolSlot is one application's placement on a pooled VM.
+type PoolSlot struct {
+	App          string    `json:"app"`
+	DeploymentID string    `json:"deployment_id"`
+	UnixUser     string    `json:"unix_user"`
+	Port         int       `json:"port"`
+	PlacedAt     time.Time `json:"placed_at"`
+}
+
+// PoolVM is a shared VM that hosts several small applications.
+type PoolVM struct {
+	Name          string               `json:"name"`
+	ResourceGroup string               `json:"resource_group"`
+	PublicIP      string               `json:"public_ip"`
+	Capacity      int                  `json:"capacity"`
+	Slots         map[string]*PoolSlot `json:"slots"`
+	CreatedAt     time.Time            `json:"created_at"`
+}
+
+// PoolStore keeps one JSON document per pooled VM under a directory.
+type PoolStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewPoolStore(dir string) (*PoolStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pool store directory: %v", err)
+	}
+	return &PoolStore{dir: dir}, nil
+}
+
+func (s *PoolStore) path(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("invalid pool VM name: %q", name)
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+func (s *PoolStore) Save(vm *PoolVM) error {
+	path, err := s.path(vm.Name)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, vm, 0644)
+}
+
+// List returns every pooled VM ordered by creation time.
+func (s *PoolStore) List() ([]*PoolVM, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool directory: %v", err)
+	}
+
+	var vms []*PoolVM
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pool VM record %s: %v", entry.Name(), err)
+		}
+
+		var vm PoolVM
+		if err := json.Unmarshal(data, &vm); err != nil {
+			return nil, fmt.Errorf("failed to decode pool VM record %s: %v", entry.Name(), err)
+		}
+		if vm.Slots == nil {
+			vm.Slots = make(map[string]*PoolSlot)
+		}
+		vms = append(vms, &vm)
+	}
+
+	sort.Slice(vms, func(i, j int) bool {
+		return vms[i].CreatedAt.Before(vms[j].CreatedAt)
+	})
+	return vms, nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// encryptedMagic starts records sealed with a RequestStore key. Plain
+// records are JSON objects, so they never start with it.
+var encryptedMagic = []byte("DJVPC-AESGCM1\n")
+
+// RequestStore keeps the original request of each deployment so it can be
+// replayed later. Requests carry tokens and environment values, so the
+// files are private to the server user.
+type RequestStore struct {
+	dir  string
+	mux  sync.RWMutex
+	aead cipher.AEAD
+}
+
+func NewRequestStore(dir string) (*RequestStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create request store directory: %v", err)
+	}
+	return &RequestStore{dir: dir}, nil
+}
+
+// SetKey seals records saved from now on with AES-256-GCM under key, which
+// must be 32 bytes. Records saved before stay readable until rewritten.
+func (s *RequestStore) SetKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid request store key: want 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.aead = aead
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *RequestStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *RequestStore) Save(id string, req interface{}) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.aead == nil {
+		return writeJSONAtomic(path, req, 0600)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %v", err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := append(append([]byte{}, encryptedMagic...), nonce...)
+	sealed = s.aead.Seal(sealed, nonce, data, []byte(id))
+	return writeFileAtomic(path, sealed, 0600)
+}
+
+// Get decodes the stored request into req. It returns os.ErrNotExist if no
+// request was recorded for id.
+func (s *RequestStore) Get(id string, req interface{}) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, encryptedMagic) {
+		if data, err = s.open(id, data[len(encryptedMagic):]); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("failed to decode request record %s: %v", id, err)
+	}
+	return nil
+}
+
+func (s *RequestStore) open(id string, sealed []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, fmt.Errorf("request record %s is encrypted and no key is set", id)
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("request record %s is truncated", id)
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt request record %s: %v", id, err)
+	}
+	return data, nil
+}
+
+func (s *RequestStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete request record: %v", err)
+	}
+	return nil
+}
+
+// IDs returns the ids of the stored records.
+func (s *RequestStore) IDs() ([]string, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request store directory: %v", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return ids, nil
+}
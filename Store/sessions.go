@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Session is a single sign-on login. It is stored under the SHA-256 of its
+// bearer token; the token itself is never stored. Roles are mapped from
+// the identity provider's groups when the user logs in.
+type Session struct {
+	Username  string    `json:"username"`
+	Email     string    `json:"email,omitempty"`
+	Provider  string    `json:"provider"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Groups    []string  `json:"groups,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionStore keeps one JSON document per session under a directory.
+type SessionStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewSessionStore(dir string) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %v", err)
+	}
+	return &SessionStore{dir: dir}, nil
+}
+
+func (s *SessionStore) path(tokenHash string) (string, error) {
+	if !validName(tokenHash) {
+		return "", fmt.Errorf("invalid session id")
+	}
+	return filepath.Join(s.dir, tokenHash+".json"), nil
+}
+
+func (s *SessionStore) Save(tokenHash string, session *Session) error {
+	path, err := s.path(tokenHash)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, session, 0600)
+}
+
+// Get returns the session, or nil if there is none or it has expired.
+func (s *SessionStore) Get(tokenHash string) (*Session, error) {
+	path, err := s.path(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.RLock()
+	data, err := os.ReadFile(path)
+	s.mux.RUnlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		s.Delete(tokenHash)
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *SessionStore) Delete(tokenHash string) error {
+	path, err := s.path(tokenHash)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes sessions that expired before now and returns how
+// many it deleted.
+func (s *SessionStore) PurgeExpired(now time.Time) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, entry := range entries {
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil || now.After(session.ExpiresAt) {
+			if os.Remove(path) == nil {
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
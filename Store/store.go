@@ -0,0 +1,259 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StepTiming records one contiguous stretch of a pipeline step.
+type StepTiming struct {
+	Name  string     `json:"name"`
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Timeline events mark the milestones of a deployment's life.
+const (
+	TimelineCreated        = "created"
+	TimelineQueued         = "queued"
+	TimelineStarted        = "started"
+	TimelineApproval       = "approval_requested"
+	TimelineApproved       = "approved"
+	TimelineInfraReady     = "infra_ready"
+	TimelineAppStarted     = "app_started"
+	TimelineCompleted      = "completed"
+	TimelineFailed         = "failed"
+	TimelineHealthPassed   = "health_passed"
+	TimelineHealthFailed   = "health_failed"
+	TimelineCancelled      = "cancelled"
+	TimelineRedeployed     = "redeployed"
+	TimelineRolledBack     = "rolled_back"
+	TimelineRedeployFailed = "redeploy_failed"
+	TimelineRollbackFailed = "rollback_failed"
+	TimelineDestroyed      = "destroyed"
+)
+
+// Revision actions, the operations that change the commit a deployment's
+// app is at.
+const (
+	RevisionDeploy   = "deploy"
+	RevisionRedeploy = "redeploy"
+	RevisionRollback = "rollback"
+)
+
+// Revision is a commit a deployment's app was put at, and by which action.
+type Revision struct {
+	Commit string `json:"commit"`
+	Action string `json:"action"`
+	// Image is the image, by digest, a Kubernetes deployment ran the
+	// commit from.
+	Image string    `json:"image,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// TimelineEvent is one milestone of a deployment, such as its VM becoming
+// reachable at an address.
+type TimelineEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// ServiceStatus is the supervisor state of one program of a deployment, as
+// last seen at the end of its run.
+type ServiceStatus struct {
+	Name    string `json:"name"`
+	Program string `json:"program"`
+	RepoURL string `json:"repo_url"`
+	State   string `json:"state"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// TerraformVersions is the template and provider versions a deployment was
+// provisioned with.
+type TerraformVersions struct {
+	Template  string            `json:"template"`
+	Providers map[string]string `json:"providers,omitempty"`
+}
+
+// Deployment is the persisted form of a deployment run.
+type Deployment struct {
+	ID            string             `json:"id"`
+	Username      string             `json:"username"`
+	Organization  string             `json:"organization,omitempty"`
+	Team          string             `json:"team,omitempty"`
+	RepoURL       string             `json:"repo_url"`
+	ResourceGroup string             `json:"resource_group,omitempty"`
+	Location      string             `json:"location,omitempty"`
+	AutoShutdown  string             `json:"auto_shutdown,omitempty"`
+	PublicIP      string             `json:"public_ip,omitempty"`
+	Image         string             `json:"image,omitempty"`
+	Status        string             `json:"status"`
+	StartTime     time.Time          `json:"start_time"`
+	EndTime       *time.Time         `json:"end_time,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	ErrorCode     string             `json:"error_code,omitempty"`
+	ErrorStage    string             `json:"error_stage,omitempty"`
+	Retryable     bool               `json:"retryable,omitempty"`
+	Steps         []StepTiming       `json:"steps,omitempty"`
+	ArchivedAt    *time.Time         `json:"archived_at,omitempty"`
+	PlanSummary   string             `json:"plan_summary,omitempty"`
+	ApprovedBy    string             `json:"approved_by,omitempty"`
+	Services      []ServiceStatus    `json:"services,omitempty"`
+	Terraform     *TerraformVersions `json:"terraform,omitempty"`
+	Timeline      []TimelineEvent    `json:"timeline,omitempty"`
+	// Revisions are the commits the app was put at, oldest first.
+	Revisions []Revision `json:"revisions,omitempty"`
+	// HostKey is the SHA256 fingerprint of the SSH host key pinned for the
+	// deployment's server on first contact.
+	HostKey string `json:"host_key,omitempty"`
+	// Mode is the deployment's mode if it is not a full deployment:
+	// "infra-only" or "app-only".
+	Mode string `json:"mode,omitempty"`
+	// TerraformDir is where the deployment's Terraform directory, with
+	// its state, was kept after the run, for destroying or re-applying it.
+	TerraformDir string `json:"terraform_dir,omitempty"`
+	// PriorStatus is the status a deployment whose app is being redeployed
+	// or rolled back returns to when that ends, whatever its outcome.
+	PriorStatus string `json:"prior_status,omitempty"`
+}
+
+// FileStore keeps one JSON document per deployment under a directory.
+type FileStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// validName rejects ids that could escape the store directory.
+func validName(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && id != "." && id != ".."
+}
+
+// ValidName reports whether id names a single entry of a directory, as
+// every store requires of its ids.
+func ValidName(id string) bool {
+	return validName(id)
+}
+
+// writeJSONAtomic writes v next to path and renames it into place so a
+// crash never leaves a truncated document behind.
+func writeJSONAtomic(path string, v interface{}, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %v", err)
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return fmt.Errorf("failed to write record: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to commit record: %v", err)
+	}
+	return nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileStore) Save(d *Deployment) error {
+	path, err := s.path(d.ID)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, d, 0644)
+}
+
+func (s *FileStore) Get(id string) (*Deployment, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment record %s: %v", id, err)
+	}
+	return &d, nil
+}
+
+// List returns every stored deployment ordered by start time.
+func (s *FileStore) List() ([]*Deployment, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %v", err)
+	}
+
+	var deployments []*Deployment
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deployment record %s: %v", entry.Name(), err)
+		}
+
+		var d Deployment
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("failed to decode deployment record %s: %v", entry.Name(), err)
+		}
+		deployments = append(deployments, &d)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].StartTime.Before(deployments[j].StartTime)
+	})
+	return deployments, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete deployment record: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// User is a registered account. VerificationHash is the SHA-256 of the
+// emailed verification token; the token itself is never stored.
+type User struct {
+	Username         string     `json:"username"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"email_verified"`
+	VerificationHash string     `json:"verification_hash,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	VerifiedAt       *time.Time `json:"verified_at,omitempty"`
+	// VerificationExpires is when the link VerificationHash belongs to
+	// stops working.
+	VerificationExpires *time.Time `json:"verification_expires,omitempty"`
+	// SSOIdentity is the issuer and subject of the single sign-on identity
+	// the account belongs to, if it was created by a login.
+	SSOIdentity string `json:"sso_identity,omitempty"`
+}
+
+// UserStore keeps one JSON document per user under a directory.
+type UserStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewUserStore(dir string) (*UserStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create user store directory: %v", err)
+	}
+	return &UserStore{dir: dir}, nil
+}
+
+func (s *UserStore) path(username string) (string, error) {
+	if !validName(username) {
+		return "", fmt.Errorf("invalid username: %q", username)
+	}
+	return filepath.Join(s.dir, username+".json"), nil
+}
+
+func (s *UserStore) Save(u *User) error {
+	path, err := s.path(u.Username)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, u, 0600)
+}
+
+// Get returns the user, or nil if no such user is registered.
+func (s *UserStore) Get(username string) (*User, error) {
+	path, err := s.path(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("failed to decode user record %s: %v", username, err)
+	}
+	return &u, nil
+}
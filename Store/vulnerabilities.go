@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Vulnerability severities, lowest first. Advisories without a severity
+// are SeverityUnknown.
+const (
+	SeverityUnknown  = "unknown"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Vulnerability is an advisory affecting a package a deployment runs.
+// Source is the scanner that reported it: "pip-audit" for the packages in
+// the app's virtualenv, "trivy" for those in its container image.
+type Vulnerability struct {
+	ID          string   `json:"id"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Package     string   `json:"package"`
+	Version     string   `json:"version"`
+	FixVersions []string `json:"fix_versions,omitempty"`
+	Severity    string   `json:"severity"`
+	Summary     string   `json:"summary,omitempty"`
+	Source      string   `json:"source"`
+}
+
+// VulnerabilityScan is the latest scan of a deployment's dependencies.
+// Truncated is set when the scanner reported more findings than reached
+// the API server.
+type VulnerabilityScan struct {
+	DeploymentID string          `json:"deployment_id"`
+	Status       string          `json:"status"`
+	Scanners     []string        `json:"scanners,omitempty"`
+	Findings     []Vulnerability `json:"findings"`
+	Truncated    bool            `json:"truncated,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	StartedAt    time.Time       `json:"started_at"`
+	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+}
+
+// VulnerabilityStore keeps each deployment's latest scan as a JSON document
+// under a directory.
+type VulnerabilityStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+func NewVulnerabilityStore(dir string) (*VulnerabilityStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create vulnerability directory: %v", err)
+	}
+	return &VulnerabilityStore{dir: dir}, nil
+}
+
+func (s *VulnerabilityStore) path(id string) (string, error) {
+	if !validName(id) {
+		return "", fmt.Errorf("invalid deployment id: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *VulnerabilityStore) Save(scan *VulnerabilityScan) error {
+	path, err := s.path(scan.DeploymentID)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return writeJSONAtomic(path, scan, 0644)
+}
+
+// Get returns the deployment's latest scan, or nil if it was never scanned.
+func (s *VulnerabilityStore) Get(id string) (*VulnerabilityScan, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.RLock()
+	data, err := os.ReadFile(path)
+	s.mux.RUnlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var scan VulnerabilityScan
+	if err := json.Unmarshal(data, &scan); err != nil {
+		return nil, fmt.Errorf("failed to decode vulnerability scan: %v", err)
+	}
+	return &scan, nil
+}
+
+func (s *VulnerabilityStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete vulnerability scan: %v", err)
+	}
+	return nil
+}
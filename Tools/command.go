@@ -0,0 +1,28 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// InterruptibleCommand returns a command that is sent an interrupt when ctx
+// is cancelled, so it can stop cleanly, and killed grace later if it has
+// not exited. Windows cannot interrupt a process, so there it is killed
+// straight away. A nil ctx is never cancelled.
+func InterruptibleCommand(ctx context.Context, grace time.Duration, name string, args ...string) *exec.Cmd {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		if runtime.GOOS == "windows" {
+			return cmd.Process.Kill()
+		}
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = grace
+	return cmd
+}
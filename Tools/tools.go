@@ -0,0 +1,371 @@
+package tools
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	DefaultTerraformVersion   = "1.9.8"
+	DefaultAnsibleCoreVersion = "2.17.5"
+
+	terraformReleasesURL = "https://releases.hashicorp.com/terraform"
+
+	// HashiCorpKeyFingerprint pins the PGP key HashiCorp signs release
+	// checksums with. The key is fetched from hashicorp.com, not the
+	// releases host, and only used if its fingerprint matches.
+	HashiCorpKeyFingerprint = "C874011F0AB405110D02105534365D9472D7468F"
+	hashicorpKeyURL         = "https://www.hashicorp.com/.well-known/pgp-key.txt"
+)
+
+// Config selects the tool versions to install and where to keep them.
+type Config struct {
+	Dir                string
+	TerraformVersion   string
+	AnsibleCoreVersion string
+	SkipAnsible        bool
+	// TerraformSHA256 pins the checksum of the Terraform archive for this
+	// platform, on top of the signed SHA256SUMS.
+	TerraformSHA256 string
+	// TerraformPGPKey is a file with HashiCorp's armored PGP key, for hosts
+	// that cannot reach hashicorp.com. It must still have the pinned
+	// fingerprint.
+	TerraformPGPKey string
+	// AnsibleRequirements is a requirements file with --hash entries for
+	// ansible-core and its dependencies, installed with --require-hashes.
+	AnsibleRequirements string
+	// AllowUnhashedAnsible installs ansible-core==AnsibleCoreVersion
+	// without hash checks when there is no requirements file.
+	AllowUnhashedAnsible bool
+}
+
+// ConfigFromEnv reads TERRAFORM_VERSION, TERRAFORM_SHA256,
+// TERRAFORM_PGP_KEY, ANSIBLE_CORE_VERSION, ANSIBLE_REQUIREMENTS and
+// ANSIBLE_ALLOW_UNHASHED, falling back to the pinned defaults.
+func ConfigFromEnv(dir string) Config {
+	cfg := Config{
+		Dir:                  dir,
+		TerraformVersion:     DefaultTerraformVersion,
+		AnsibleCoreVersion:   DefaultAnsibleCoreVersion,
+		TerraformSHA256:      strings.ToLower(os.Getenv("TERRAFORM_SHA256")),
+		TerraformPGPKey:      os.Getenv("TERRAFORM_PGP_KEY"),
+		AnsibleRequirements:  os.Getenv("ANSIBLE_REQUIREMENTS"),
+		AllowUnhashedAnsible: os.Getenv("ANSIBLE_ALLOW_UNHASHED") == "true",
+	}
+	if v := os.Getenv("TERRAFORM_VERSION"); v != "" {
+		cfg.TerraformVersion = v
+	}
+	if v := os.Getenv("ANSIBLE_CORE_VERSION"); v != "" {
+		cfg.AnsibleCoreVersion = v
+	}
+	return cfg
+}
+
+// How an installed tool was verified.
+const (
+	// VerifiedPinned means the download matched a checksum pinned in the
+	// configuration as well as the signed checksums.
+	VerifiedPinned = "pinned"
+	// VerifiedSigned means the download matched the checksums its
+	// publisher signed with the pinned key.
+	VerifiedSigned = "signed"
+	// VerifiedHashes means pip installed every package with
+	// --require-hashes.
+	VerifiedHashes = "hashes"
+	// VerifiedNone means pip installed the release without hash checks,
+	// which ANSIBLE_ALLOW_UNHASHED must opt into.
+	VerifiedNone = "none"
+)
+
+// Tool describes an installed tool.
+type Tool struct {
+	Version  string `json:"version"`
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256,omitempty"`
+	Verified string `json:"verified"`
+}
+
+// Manifest lists the tools Bootstrap installed. Ansible is nil if it was
+// skipped.
+type Manifest struct {
+	Terraform *Tool `json:"terraform,omitempty"`
+	Ansible   *Tool `json:"ansible,omitempty"`
+}
+
+// Bootstrap makes sure the pinned Terraform and Ansible versions are
+// installed under cfg.Dir and puts them first on PATH, so every later
+// exec of terraform or ansible-playbook uses them. The manifest lists what
+// was installed before any failure.
+func Bootstrap(cfg Config) (Manifest, error) {
+	var manifest Manifest
+	terraform, err := ensureTerraform(filepath.Join(cfg.Dir, "terraform", cfg.TerraformVersion), cfg.TerraformVersion, cfg.TerraformSHA256, cfg.TerraformPGPKey)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to install terraform %s: %v", cfg.TerraformVersion, err)
+	}
+	manifest.Terraform = terraform
+	prependPath(terraform.Path)
+	log.Printf("Using terraform %s from %s (sha256 %s, verified: %s)", terraform.Version, terraform.Path, terraform.SHA256, terraform.Verified)
+
+	if cfg.SkipAnsible {
+		return manifest, nil
+	}
+
+	ansible, err := ensureAnsible(filepath.Join(cfg.Dir, "ansible", cfg.AnsibleCoreVersion), cfg.AnsibleCoreVersion, cfg.AnsibleRequirements, cfg.AllowUnhashedAnsible)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to install ansible-core %s: %v", cfg.AnsibleCoreVersion, err)
+	}
+	manifest.Ansible = ansible
+	prependPath(ansible.Path)
+	log.Printf("Using ansible-core %s from %s (verified: %s)", ansible.Version, ansible.Path, ansible.Verified)
+	return manifest, nil
+}
+
+func prependPath(dir string) {
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func executable(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// toolFile records how the tool installed in a directory was verified.
+const toolFile = "tool.json"
+
+// installedTool returns the record of the tool installed in dir if
+// executable exists there.
+func installedTool(dir, executable string) (*Tool, bool) {
+	if _, err := os.Stat(filepath.Join(dir, executable)); err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, toolFile))
+	if err != nil {
+		return nil, false
+	}
+	var tool Tool
+	if err := json.Unmarshal(data, &tool); err != nil {
+		return nil, false
+	}
+	return &tool, true
+}
+
+func recordTool(dir string, tool *Tool) error {
+	data, err := json.MarshalIndent(tool, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, toolFile), data, 0644)
+}
+
+// ensureTerraform downloads the release zip for this platform, checks it
+// against the SHA256SUMS HashiCorp signed and pinned, if set, and unpacks
+// the binary into dir. Installs made before the signature was checked or a
+// pin was set, or with another archive, are replaced.
+func ensureTerraform(dir, version, pinned, keyFile string) (*Tool, error) {
+	binary := filepath.Join(dir, executable("terraform"))
+	if tool, ok := installedTool(dir, executable("terraform")); ok && (tool.Verified == VerifiedSigned || tool.Verified == VerifiedPinned) && (pinned == "" || tool.SHA256 == pinned) {
+		return tool, nil
+	}
+
+	keyring, err := hashicorpKeyring(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	archiveName := fmt.Sprintf("terraform_%s_%s_%s.zip", version, runtime.GOOS, runtime.GOARCH)
+	log.Printf("Downloading %s...", archiveName)
+
+	sumsURL := fmt.Sprintf("%s/%s/terraform_%s_SHA256SUMS", terraformReleasesURL, version, version)
+	sums, err := download(sumsURL)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := download(sumsURL + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sums), bytes.NewReader(signature), nil); err != nil {
+		return nil, fmt.Errorf("SHA256SUMS of terraform %s is not signed by HashiCorp's key %s: %v", version, HashiCorpKeyFingerprint, err)
+	}
+	expected, err := checksumFor(sums, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := download(fmt.Sprintf("%s/%s/%s", terraformReleasesURL, version, archiveName))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expected {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveName, expected, actual)
+	}
+	verified := VerifiedSigned
+	if pinned != "" {
+		if actual != pinned {
+			return nil, fmt.Errorf("checksum mismatch for %s: TERRAFORM_SHA256 is %s, got %s", archiveName, pinned, actual)
+		}
+		verified = VerifiedPinned
+	}
+
+	if err := unzipFile(archive, executable("terraform"), binary); err != nil {
+		return nil, err
+	}
+	tool := &Tool{Version: version, Path: dir, SHA256: actual, Verified: verified}
+	return tool, recordTool(dir, tool)
+}
+
+// ensureAnsible creates a virtualenv in dir with the packages of a
+// hash-pinned requirements file and returns it with its bin directory
+// (Scripts on Windows) as Path. Without one, ansible-core==version is
+// installed unchecked only if allowUnhashed. An install made without
+// hashes is replaced once a requirements file is set or unchecked installs
+// are no longer allowed.
+func ensureAnsible(dir, version, requirements string, allowUnhashed bool) (*Tool, error) {
+	binName := "bin"
+	if runtime.GOOS == "windows" {
+		binName = "Scripts"
+	}
+	binDir := filepath.Join(dir, binName)
+	if tool, ok := installedTool(dir, filepath.Join(binName, executable("ansible-playbook"))); ok && (tool.Verified == VerifiedHashes || (requirements == "" && allowUnhashed)) {
+		return tool, nil
+	}
+	if requirements == "" && !allowUnhashed {
+		return nil, fmt.Errorf("ANSIBLE_REQUIREMENTS is not set: set it to a requirements file with --hash entries, or ANSIBLE_ALLOW_UNHASHED=true to install ansible-core without hash checks")
+	}
+	os.RemoveAll(dir)
+
+	python := "python3"
+	if runtime.GOOS == "windows" {
+		python = "python"
+	}
+
+	log.Printf("Creating ansible-core %s virtualenv in %s...", version, dir)
+	if output, err := exec.Command(python, "-m", "venv", dir).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to create virtualenv: %v, output: %s", err, string(output))
+	}
+
+	args := []string{"install", "--quiet", "ansible-core==" + version}
+	verified := VerifiedNone
+	if requirements != "" {
+		args = []string{"install", "--quiet", "--require-hashes", "-r", requirements}
+		verified = VerifiedHashes
+	}
+	pip := filepath.Join(binDir, executable("pip"))
+	if output, err := exec.Command(pip, args...).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to install ansible-core: %v, output: %s", err, string(output))
+	}
+	tool := &Tool{Version: version, Path: binDir, Verified: verified}
+	return tool, recordTool(dir, tool)
+}
+
+// hashicorpKeyring returns HashiCorp's release signing key, read from
+// keyFile or downloaded from hashicorp.com, once it has checked the key's
+// fingerprint is HashiCorpKeyFingerprint.
+func hashicorpKeyring(keyFile string) (openpgp.EntityList, error) {
+	var armored []byte
+	var err error
+	if keyFile != "" {
+		armored, err = os.ReadFile(keyFile)
+	} else {
+		armored, err = download(hashicorpKeyURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HashiCorp's PGP key: %v", err)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HashiCorp's PGP key: %v", err)
+	}
+	for _, entity := range entities {
+		if strings.EqualFold(hex.EncodeToString(entity.PrimaryKey.Fingerprint), HashiCorpKeyFingerprint) {
+			return openpgp.EntityList{entity}, nil
+		}
+	}
+	return nil, fmt.Errorf("HashiCorp's PGP key does not have the pinned fingerprint %s", HashiCorpKeyFingerprint)
+}
+
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func checksumFor(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+func unzipFile(archive []byte, name, dest string) error {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		tmp := dest + ".tmp"
+		out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, src); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if err := out.Close(); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return os.Rename(tmp, dest)
+	}
+	return fmt.Errorf("%s not found in archive", name)
+}
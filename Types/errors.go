@@ -0,0 +1,86 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes of DeploymentError. They are stable so clients can branch on
+// them.
+const (
+	ErrCodeInvalidRequest      = "INVALID_REQUEST"
+	ErrCodeMissingEnv          = "MISSING_ENV_VARS"
+	ErrCodeWorkspace           = "WORKSPACE_FAILED"
+	ErrCodeWorkspaceQuota      = "WORKSPACE_QUOTA_EXCEEDED"
+	ErrCodeSSHKeys             = "SSH_KEYS_FAILED"
+	ErrCodeSSHUnreachable      = "SSH_UNREACHABLE"
+	ErrCodeTerraformConfig     = "TERRAFORM_CONFIG_FAILED"
+	ErrCodeTerraformInit       = "TERRAFORM_INIT_FAILED"
+	ErrCodeTerraformPlan       = "TERRAFORM_PLAN_FAILED"
+	ErrCodeTerraformApply      = "TERRAFORM_APPLY_FAILED"
+	ErrCodeTerraformOutput     = "TERRAFORM_OUTPUT_FAILED"
+	ErrCodeTerraformDestroy    = "TERRAFORM_DESTROY_FAILED"
+	ErrCodeQuotaExceeded       = "QUOTA_EXCEEDED"
+	ErrCodeCapacityUnavailable = "CAPACITY_UNAVAILABLE"
+	ErrCodeCloudAuth           = "CLOUD_AUTH_FAILED"
+	ErrCodeApprovalRejected    = "APPROVAL_REJECTED"
+	ErrCodeApprovalUnavailable = "APPROVAL_UNAVAILABLE"
+	ErrCodeAnsibleConfig       = "ANSIBLE_CONFIG_FAILED"
+	ErrCodeAnsiblePlaybook     = "ANSIBLE_PLAYBOOK_FAILED"
+	ErrCodeAnsibleCheck        = "ANSIBLE_CHECK_FAILED"
+	ErrCodeRestore             = "RESTORE_FAILED"
+	ErrCodeRedeploy            = "REDEPLOY_FAILED"
+	ErrCodeRollback            = "ROLLBACK_FAILED"
+	ErrCodePoolUnavailable     = "POOL_UNAVAILABLE"
+	ErrCodePoolPlacement       = "POOL_PLACEMENT_FAILED"
+	ErrCodeHook                = "HOOK_FAILED"
+	ErrCodeImageBuild          = "IMAGE_BUILD_FAILED"
+	ErrCodeKubernetes          = "KUBERNETES_DEPLOY_FAILED"
+	ErrCodeStaticIP            = "STATIC_IP_FAILED"
+	ErrCodeDataDisk            = "DATA_DISK_FAILED"
+	ErrCodeCancelled           = "CANCELLED"
+	ErrCodeInfrastructure      = "INFRASTRUCTURE_UNAVAILABLE"
+	ErrCodeInternal            = "INTERNAL_ERROR"
+)
+
+// DeploymentError is a pipeline failure classified by the stage it happened
+// in, a stable code, and whether running the same request again may succeed.
+type DeploymentError struct {
+	Stage     string `json:"stage"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Cause     error  `json:"-"`
+}
+
+func (e *DeploymentError) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+}
+
+func (e *DeploymentError) Unwrap() error {
+	return e.Cause
+}
+
+// NewDeploymentError classifies cause, which may be nil. If cause already
+// carries a DeploymentError, its code and retryability win, since the
+// layer that produced it knew more about the failure.
+func NewDeploymentError(stage, code string, retryable bool, cause error, message string) *DeploymentError {
+	var inner *DeploymentError
+	if errors.As(cause, &inner) {
+		code, retryable = inner.Code, inner.Retryable
+	}
+	return &DeploymentError{Stage: stage, Code: code, Message: message, Retryable: retryable, Cause: cause}
+}
+
+// AsDeploymentError returns the DeploymentError in err's chain, or wraps err
+// as a non-retryable internal error of stage.
+func AsDeploymentError(err error, stage string) *DeploymentError {
+	var derr *DeploymentError
+	if errors.As(err, &derr) {
+		return derr
+	}
+	return &DeploymentError{Stage: stage, Code: ErrCodeInternal, Message: err.Error()}
+}
@@ -0,0 +1,25 @@
+package types
+
+// LogMessage is one entry of a deployment's log, as streamed over SSE and
+// persisted to the log store.
+type LogMessage struct {
+	// DeploymentID and Sequence are filled in when the message is
+	// broadcast. Sequence numbers a deployment's messages from 1 so
+	// clients can order them and detect gaps after reconnecting.
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Sequence     int64  `json:"sequence,omitempty"`
+
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+	Step      string `json:"step,omitempty"`
+	// Code and Data are set on milestone messages; see the Event constants
+	// in the Services package.
+	Code string                 `json:"code,omitempty"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// LogBroadcaster delivers a deployment's log messages to its listeners.
+type LogBroadcaster interface {
+	BroadcastLog(deploymentID string, logMsg LogMessage)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Tools"
+)
+
+// toolManifest lists the tools bootstrapTools installed. It is empty if the
+// bootstrap was skipped or failed, when the host's tools are used.
+var toolManifest tools.Manifest
+
+// handleAbout reports the pinned versions of everything deployments
+// download or run: Terraform and Ansible with how they were verified, the
+// Terraform providers the Azure configurations pin, the backend Azure VMs
+// are provisioned with, the Ansible image in container mode, the images of
+// workspace isolation and the actions of the generated workflow.
+func handleAbout(c *gin.Context) {
+	about := gin.H{
+		"tools": toolManifest,
+		"terraform_providers": gin.H{
+			"terraform":         providers.TerraformRequiredVersion,
+			"hashicorp/azurerm": providers.AzureRMVersion,
+			"hashicorp/local":   providers.LocalProviderVersion,
+		},
+		"workflow_actions": services.WorkflowActions(),
+		"azure_backend":    providers.AzureBackendFromEnv(),
+	}
+	if services.AnsibleRunsInContainer() {
+		about["ansible_image"] = services.AnsibleImage()
+	}
+	if isolation, _ := providers.WorkspaceIsolation(); isolation != "" {
+		about["workspace_isolation"] = gin.H{
+			"engine":          isolation,
+			"terraform_image": providers.TerraformImage(),
+		}
+	}
+	c.JSON(http.StatusOK, about)
+}
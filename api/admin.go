@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// requireAdmin guards the admin API with the ADMIN_TOKEN bearer token or an
+// SSO session mapped to the admin role. Without either configured the API
+// is disabled.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isAdminRequest(c) {
+			c.Next()
+			return
+		}
+		if os.Getenv("ADMIN_TOKEN") == "" && len(identityProviders) == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled (ADMIN_TOKEN not set)"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+	}
+}
+
+// isAdminRequest reports whether the request carries ADMIN_TOKEN or an SSO
+// session mapped to the admin role.
+func isAdminRequest(c *gin.Context) bool {
+	if session := requestSession(c); session != nil && sessionHasRole(session, roleAdmin) {
+		return true
+	}
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(adminToken)) == 1
+}
+
+func deploymentSummary(status DeploymentStatus) gin.H {
+	summary := gin.H{
+		"deployment_id": status.ID,
+		"username":      status.Username,
+		"repo_url":      status.RepoURL,
+		"status":        status.Status,
+		"start_time":    status.StartTime.Format(time.RFC3339),
+	}
+	if status.PublicIP != "" {
+		summary["public_ip"] = status.PublicIP
+	}
+	if status.Mode != "" {
+		summary["mode"] = status.Mode
+	}
+	if status.Team != "" {
+		summary["organization"] = status.Organization
+		summary["team"] = status.Team
+	}
+	if status.EndTime != nil {
+		summary["end_time"] = status.EndTime.Format(time.RFC3339)
+	}
+	if status.Error != nil {
+		summary["error"] = status.Error.Error()
+		summary["error_code"] = status.ErrorCode
+	}
+	if status.ArchivedAt != nil {
+		summary["archived_at"] = status.ArchivedAt.Format(time.RFC3339)
+	}
+	return summary
+}
+
+func handleAdminListDeployments(c *gin.Context) {
+	deployments := deploymentManager.ListDeployments()
+	includeArchived := c.Query("include_archived") == "true"
+
+	response := make([]gin.H, 0, len(deployments))
+	for _, deployment := range deployments {
+		if deployment.ArchivedAt != nil && !includeArchived {
+			continue
+		}
+		response = append(response, deploymentSummary(deployment))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployments": response,
+		"total":       len(response),
+	})
+}
+
+func handleAdminForceFail(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	reason := "force-failed by admin"
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err == nil && body.Reason != "" {
+		reason = fmt.Sprintf("force-failed by admin: %s", body.Reason)
+	}
+
+	if err := deploymentManager.ForceFail(deploymentID, reason); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	deploymentQueue.Remove(deploymentID)
+	deploymentManager.decideApproval(deploymentID, approvalDecision{Approved: false, Approver: "admin", Reason: reason})
+
+	deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     "error",
+		Message:   reason,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "error",
+		Code:      services.EventDeploymentFailed,
+		Data:      map[string]interface{}{"error": reason},
+	})
+	deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     "system",
+		Message:   "DEPLOYMENT_COMPLETE",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "system",
+		Code:      services.EventDeploymentComplete,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"deployment_id": deploymentID,
+		"status":        "failed",
+	})
+}
+
+func handleAdminKickClients(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	kicked := deploymentManager.KickClients(deploymentID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"deployment_id":  deploymentID,
+		"clients_kicked": kicked,
+	})
+}
+
+// handleAdminPurgeDeployment removes a finished deployment for good.
+// released=true purges one whose infrastructure still exists, once its
+// Terraform state has been handed over, as after an export.
+func handleAdminPurgeDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	if err := deploymentManager.Purge(deploymentID, c.Query("released") == "true"); err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	deploymentManager.KickClients(deploymentID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"deployment_id": deploymentID,
+	})
+}
+
+func handleAdminLocks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"locks": providers.HeldResourceGroupLocks()})
+}
+
+func handleAdminPool(c *gin.Context) {
+	vms, err := vmPool.VMs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if vms == nil {
+		vms = []*store.PoolVM{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vms": vms, "count": len(vms)})
+}
+
+func handleAdminQueue(c *gin.Context) {
+	pending, active := deploymentQueue.Snapshot()
+
+	c.JSON(http.StatusOK, gin.H{
+		"workers": deploymentQueue.workers,
+		"aging":   deploymentQueue.aging.String(),
+		"pending": pending,
+		"active":  active,
+	})
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Notifications"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// Conditions the capacity advisor alerts on.
+const (
+	conditionCPU    = "cpu"
+	conditionMemory = "memory"
+	conditionDisk   = "disk"
+)
+
+const (
+	// Utilisation a recommended size should bring the load down to,
+	// leaving room for peaks.
+	targetCPUPercent    = 60
+	targetMemoryPercent = 70
+	targetDiskPercent   = 70
+	// minSustainedSamples is how many samples the alert window needs
+	// before CPU or memory pressure counts as sustained.
+	minSustainedSamples = 3
+	// recoveryMargin is how far below its threshold a condition has to
+	// drop to clear, so a load hovering at the threshold does not alert
+	// on every sample.
+	recoveryMargin = 10
+)
+
+var advisor *capacityAdvisor
+
+// capacityAlertWindow is how long CPU or memory has to stay above its
+// threshold to raise an alert, an hour unless CAPACITY_ALERT_WINDOW says
+// otherwise. Zero disables alerts; the metrics report still advises.
+func capacityAlertWindow() time.Duration {
+	if value := os.Getenv("CAPACITY_ALERT_WINDOW"); value != "" {
+		if value == "0" {
+			return 0
+		}
+		if window, err := time.ParseDuration(value); err == nil && window >= time.Minute {
+			return window
+		}
+		log.Printf("Invalid CAPACITY_ALERT_WINDOW value %q, using default", value)
+	}
+	return time.Hour
+}
+
+// recommendVMSize returns the cheapest size of vmSize's series that would
+// run a load using cpu and memory percent of vmSize at no more than the
+// target utilisation, or "" if vmSize already is that size, no known size
+// is big enough or vmSize is not one this tool has data for.
+func recommendVMSize(vmSize string, cpu, memory float64) string {
+	current, ok := providers.LookupVMSize(vmSize)
+	if !ok {
+		return ""
+	}
+	cpus := float64(current.VCPUs) * cpu / targetCPUPercent
+	memoryGiB := current.MemoryGiB * memory / targetMemoryPercent
+	for _, size := range providers.SimilarVMSizes(vmSize) {
+		if float64(size.VCPUs) >= cpus && size.MemoryGiB >= memoryGiB {
+			if size.Name == vmSize {
+				return ""
+			}
+			return size.Name
+		}
+	}
+	return ""
+}
+
+// recommendDiskSizeGB returns an OS disk size, in steps of 8 GB, that
+// holds usedBytes at the target utilisation.
+func recommendDiskSizeGB(usedBytes int64) int {
+	gb := float64(usedBytes) / (1 << 30) * 100 / targetDiskPercent
+	return int(math.Ceil(gb/8)) * 8
+}
+
+// adviseSize sets the size advice should resize to: for scale_up the
+// size that relieves cpu and memory percent or, if the series has none
+// and CPU is the limit, a scale set; for scale_down a smaller size, or
+// keep if there is none.
+func adviseSize(advice *CapacityAdvice, vmSize string, cpu, memory float64) {
+	switch advice.Action {
+	case "scale_up":
+		if advice.VMSize = recommendVMSize(vmSize, cpu, memory); advice.VMSize != "" {
+			advice.Reasons = append(advice.Reasons, fmt.Sprintf("%s would run this load at about %.0f%% CPU", advice.VMSize, projectedCPU(vmSize, advice.VMSize, cpu)))
+		} else if _, known := providers.LookupVMSize(vmSize); known && cpu >= scaleUpCPUPercent {
+			advice.ScaleSet = true
+			advice.Reasons = append(advice.Reasons, fmt.Sprintf("no larger size of %s's series would do; a scale set (scale_set: true) spreads requests over several VMs", vmSize))
+		}
+	case "scale_down":
+		if advice.VMSize = recommendVMSize(vmSize, cpu, memory); advice.VMSize != "" {
+			advice.Reasons = append(advice.Reasons, fmt.Sprintf("%s would run this load at about %.0f%% CPU", advice.VMSize, projectedCPU(vmSize, advice.VMSize, cpu)))
+		} else if _, known := providers.LookupVMSize(vmSize); known {
+			advice.Action = "keep"
+			advice.Reasons = []string{fmt.Sprintf("the VM is lightly used, but %s is the smallest size of its series", vmSize)}
+		}
+	}
+}
+
+// projectedCPU returns the CPU percent a load using cpu percent of from
+// would use of to.
+func projectedCPU(from, to string, cpu float64) float64 {
+	current, _ := providers.LookupVMSize(from)
+	target, _ := providers.LookupVMSize(to)
+	if target.VCPUs == 0 {
+		return cpu
+	}
+	return cpu * float64(current.VCPUs) / float64(target.VCPUs)
+}
+
+// CapacityAlert is a condition the advisor raised: CPU or memory above its
+// threshold for the whole alert window, or a nearly full OS disk. Value is
+// the lowest CPU or memory percent in the window, or the disk's latest
+// use. VMSize, ScaleSet and DiskSizeGB say what would relieve it.
+type CapacityAlert struct {
+	Condition  string    `json:"condition"`
+	Value      float64   `json:"value"`
+	Threshold  float64   `json:"threshold"`
+	Since      time.Time `json:"since"`
+	VMSize     string    `json:"vm_size,omitempty"`
+	ScaleSet   bool      `json:"scale_set,omitempty"`
+	DiskSizeGB int       `json:"disk_size_gb,omitempty"`
+}
+
+func (a CapacityAlert) message() string {
+	var message string
+	switch a.Condition {
+	case conditionCPU:
+		message = fmt.Sprintf("CPU has stayed above %.0f%% (lowest %.0f%%)", a.Threshold, a.Value)
+	case conditionMemory:
+		message = fmt.Sprintf("Memory use has stayed above %.0f%% (lowest %.0f%%)", a.Threshold, a.Value)
+	case conditionDisk:
+		message = fmt.Sprintf("The OS disk is %.0f%% full", a.Value)
+	}
+	switch {
+	case a.VMSize != "":
+		message += fmt.Sprintf("; resize the VM to %s", a.VMSize)
+	case a.ScaleSet:
+		message += "; no larger size would do, deploy it as a scale set"
+	case a.DiskSizeGB > 0:
+		message += fmt.Sprintf("; raise disk_size_gb to %d", a.DiskSizeGB)
+	}
+	return message
+}
+
+// sustainedConditions returns the alerts samples, the deployment's
+// samples over the alert window, call for on a VM of vmSize.
+func sustainedConditions(samples []store.MetricSample, vmSize string) map[string]CapacityAlert {
+	alerts := make(map[string]CapacityAlert)
+	if len(samples) == 0 {
+		return alerts
+	}
+	latest := samples[len(samples)-1]
+	if latest.DiskPercent() >= diskFullPercent {
+		alerts[conditionDisk] = CapacityAlert{
+			Condition:  conditionDisk,
+			Value:      latest.DiskPercent(),
+			Threshold:  diskFullPercent,
+			DiskSizeGB: recommendDiskSizeGB(latest.DiskUsedBytes),
+		}
+	}
+	if len(samples) < minSustainedSamples {
+		return alerts
+	}
+
+	cpu, memory := 100.0, 100.0
+	for _, sample := range samples {
+		cpu = min(cpu, sample.CPUPercent)
+		memory = min(memory, sample.MemoryPercent())
+	}
+	pressure := func(condition string, value, threshold float64) {
+		if value < threshold {
+			return
+		}
+		advice := CapacityAdvice{Action: "scale_up"}
+		adviseSize(&advice, vmSize, cpu, memory)
+		alerts[condition] = CapacityAlert{Condition: condition, Value: value, Threshold: threshold, VMSize: advice.VMSize, ScaleSet: advice.ScaleSet}
+	}
+	pressure(conditionCPU, cpu, scaleUpCPUPercent)
+	pressure(conditionMemory, memory, scaleUpMemoryPercent)
+	return alerts
+}
+
+// cleared reports whether an active alert's condition has eased enough in
+// the latest sample to clear.
+func cleared(alert CapacityAlert, latest store.MetricSample) bool {
+	value := latest.CPUPercent
+	switch alert.Condition {
+	case conditionMemory:
+		value = latest.MemoryPercent()
+	case conditionDisk:
+		value = latest.DiskPercent()
+	}
+	return value < alert.Threshold-recoveryMargin
+}
+
+// capacityAdvisor raises an alert when a deployment's VM comes under
+// sustained pressure and clears it once the pressure eases, notifying the
+// owner of new alerts.
+type capacityAdvisor struct {
+	window time.Duration
+
+	mux    sync.Mutex
+	active map[string]map[string]CapacityAlert
+}
+
+func newCapacityAdvisor(window time.Duration) *capacityAdvisor {
+	return &capacityAdvisor{window: window, active: make(map[string]map[string]CapacityAlert)}
+}
+
+// Alerts returns the deployment's active alerts.
+func (a *capacityAdvisor) Alerts(deploymentID string) []CapacityAlert {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	alerts := []CapacityAlert{}
+	for _, alert := range a.active[deploymentID] {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Condition < alerts[j].Condition })
+	return alerts
+}
+
+func (a *capacityAdvisor) forget(deploymentID string) {
+	a.mux.Lock()
+	delete(a.active, deploymentID)
+	a.mux.Unlock()
+}
+
+// evaluate checks the deployment's samples since the start of the alert
+// window, taken at now.
+func (a *capacityAdvisor) evaluate(status *DeploymentStatus, vmSize string, now time.Time) error {
+	if a.window == 0 {
+		return nil
+	}
+	samples, err := metricsStore.List(status.ID, now.Add(-a.window))
+	if err != nil || len(samples) == 0 {
+		return err
+	}
+	current := sustainedConditions(samples, vmSize)
+	latest := samples[len(samples)-1]
+
+	var raised, eased []CapacityAlert
+	a.mux.Lock()
+	for condition, alert := range a.active[status.ID] {
+		if update, ok := current[condition]; ok {
+			update.Since = alert.Since
+			current[condition] = update
+		} else if cleared(alert, latest) {
+			eased = append(eased, alert)
+		} else {
+			current[condition] = alert
+		}
+	}
+	for condition, alert := range current {
+		if _, ok := a.active[status.ID][condition]; !ok {
+			alert.Since = now
+			current[condition] = alert
+			raised = append(raised, alert)
+		}
+	}
+	if len(current) == 0 {
+		delete(a.active, status.ID)
+	} else {
+		a.active[status.ID] = current
+	}
+	a.mux.Unlock()
+
+	for _, alert := range eased {
+		capacityEvent(status.ID, "success", services.EventCapacityRecovered, fmt.Sprintf("Capacity alert cleared: %s is back under %.0f%%", alert.Condition, alert.Threshold-recoveryMargin),
+			map[string]interface{}{"condition": alert.Condition})
+	}
+	for _, alert := range raised {
+		capacityEvent(status.ID, "warn", services.EventCapacityAlert, alert.message(), map[string]interface{}{
+			"condition":    alert.Condition,
+			"value":        alert.Value,
+			"threshold":    alert.Threshold,
+			"vm_size":      alert.VMSize,
+			"scale_set":    alert.ScaleSet,
+			"disk_size_gb": alert.DiskSizeGB,
+		})
+	}
+	if len(raised) > 0 {
+		notifyCapacity(status, raised)
+	}
+	return nil
+}
+
+func capacityEvent(deploymentID, level, code, message string, data map[string]interface{}) {
+	deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     level,
+		Message:   message,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "capacity",
+		Code:      code,
+		Data:      data,
+	})
+}
+
+// notifyCapacity emails new alerts to the deploying user if they have a
+// verified address, and posts them to CAPACITY_WEBHOOK_URL if set.
+func notifyCapacity(status *DeploymentStatus, alerts []CapacityAlert) {
+	reportURL := fmt.Sprintf("%s/deploy/%s/metrics", publicBaseURL(), status.ID)
+	if smtpConfig := notifications.SMTPConfigFromEnv(); smtpConfig.Enabled() {
+		if user, err := userStore.Get(status.Username); err == nil && user != nil && user.EmailVerified {
+			var body strings.Builder
+			body.WriteString(fmt.Sprintf("Deployment: %s\n", status.ID))
+			body.WriteString(fmt.Sprintf("Repository: %s\n\n", status.RepoURL))
+			body.WriteString("The deployment's VM is short of capacity:\n")
+			for _, alert := range alerts {
+				body.WriteString(fmt.Sprintf("  %s\n", alert.message()))
+			}
+			body.WriteString(fmt.Sprintf("\nResizing: POST %s/deploy/%s/resize\n", publicBaseURL(), status.ID))
+			body.WriteString(fmt.Sprintf("Metrics: %s\n", reportURL))
+
+			subject := fmt.Sprintf("Capacity alert: %s", status.ID)
+			if err := smtpConfig.SendEmail(user.Email, subject, body.String()); err != nil {
+				log.Printf("Failed to send capacity alert for %s: %v", status.ID, err)
+			}
+		}
+	}
+
+	webhookURL := os.Getenv("CAPACITY_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	postWebhook(webhookURL, "capacity", status.ID, gin.H{
+		"deployment_id": status.ID,
+		"username":      status.Username,
+		"repo_url":      status.RepoURL,
+		"alerts":        alerts,
+		"report_url":    reportURL,
+	})
+}
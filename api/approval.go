@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+type approvalDecision struct {
+	Approved bool
+	Approver string
+	Reason   string
+}
+
+type pendingApproval struct {
+	Plan        string
+	RequestedAt time.Time
+	decision    chan approvalDecision
+}
+
+func approvalTimeout() time.Duration {
+	if value := os.Getenv("APPROVAL_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("Invalid APPROVAL_TIMEOUT value %q, using default", value)
+	}
+	return 24 * time.Hour
+}
+
+// approverForToken maps a bearer token to an approver name using APPROVERS,
+// a comma separated list of name:token pairs.
+func approverForToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	for _, entry := range strings.Split(os.Getenv("APPROVERS"), ",") {
+		name, approverToken, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if found && subtle.ConstantTimeCompare([]byte(token), []byte(approverToken)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// planSummary extracts terraform's "Plan: N to add, ..." line.
+func planSummary(plan string) string {
+	for _, line := range strings.Split(plan, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Plan:") || strings.HasPrefix(line, "No changes.") {
+			return line
+		}
+	}
+	return "Plan generated"
+}
+
+// AwaitApproval implements services.ApprovalGate. It parks the deployment in
+// pending_approval until an approver decides, the deployment is cancelled or
+// force-failed or the approval window runs out. Its worker is free for
+// other deployments meanwhile; however the wait ends, it waits for one
+// again.
+func (dm *DeploymentManager) AwaitApproval(ctx context.Context, deploymentID, plan string) error {
+	pending := &pendingApproval{
+		Plan:        plan,
+		RequestedAt: time.Now(),
+		decision:    make(chan approvalDecision, 1),
+	}
+
+	dm.approvalMux.Lock()
+	dm.approvals[deploymentID] = pending
+	dm.approvalMux.Unlock()
+
+	defer func() {
+		dm.approvalMux.Lock()
+		delete(dm.approvals, deploymentID)
+		dm.approvalMux.Unlock()
+	}()
+
+	summary := planSummary(plan)
+
+	dm.deployMux.Lock()
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.Status = "pending_approval"
+		deployment.PlanSummary = summary
+		dm.persist(deployment)
+	}
+	dm.deployMux.Unlock()
+
+	dm.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     "info",
+		Message:   fmt.Sprintf("Infrastructure plan ready (%s). Waiting for approval via POST /deploy/%s/approve", summary, deploymentID),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "approval",
+		Code:      services.EventApprovalRequired,
+		Data:      map[string]interface{}{"plan_summary": summary},
+	})
+	notifyApprovers(deploymentID, summary)
+
+	// Whatever ends the wait, the run goes on, if only to fail, cancel or
+	// destroy, so it needs its worker back.
+	deploymentQueue.Park(deploymentID)
+	defer deploymentQueue.Resume(deploymentID)
+	timeout := approvalTimeout()
+	select {
+	case decision := <-pending.decision:
+		dm.deployMux.Lock()
+		if deployment, exists := dm.deployments[deploymentID]; exists && deployment.Status == "pending_approval" {
+			deployment.Status = "running"
+			if decision.Approved {
+				deployment.ApprovedBy = decision.Approver
+			}
+			dm.persist(deployment)
+		}
+		dm.deployMux.Unlock()
+
+		if !decision.Approved {
+			return fmt.Errorf("rejected by %s: %s", decision.Approver, decision.Reason)
+		}
+
+		dm.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     "success",
+			Message:   fmt.Sprintf("Infrastructure plan approved by %s", decision.Approver),
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      "approval",
+			Code:      services.EventApprovalGranted,
+			Data:      map[string]interface{}{"approver": decision.Approver},
+		})
+		return nil
+
+	case <-ctx.Done():
+		// Back to running, so runDeployment finishes the cancelled run
+		// rather than discarding its result; a force-fail already moved it
+		// on and keeps its status.
+		dm.deployMux.Lock()
+		if deployment, exists := dm.deployments[deploymentID]; exists && deployment.Status == "pending_approval" {
+			deployment.Status = "running"
+			dm.persist(deployment)
+		}
+		dm.deployMux.Unlock()
+		return ctx.Err()
+
+	case <-time.After(timeout):
+		dm.deployMux.Lock()
+		if deployment, exists := dm.deployments[deploymentID]; exists && deployment.Status == "pending_approval" {
+			deployment.Status = "running"
+			dm.persist(deployment)
+		}
+		dm.deployMux.Unlock()
+		return fmt.Errorf("no decision within %s", timeout)
+	}
+}
+
+// decideApproval delivers a decision to a waiting deployment.
+func (dm *DeploymentManager) decideApproval(deploymentID string, decision approvalDecision) bool {
+	dm.approvalMux.Lock()
+	defer dm.approvalMux.Unlock()
+
+	pending, exists := dm.approvals[deploymentID]
+	if !exists {
+		return false
+	}
+
+	select {
+	case pending.decision <- decision:
+		return true
+	default:
+		return false
+	}
+}
+
+func (dm *DeploymentManager) pendingPlan(deploymentID string) (*pendingApproval, bool) {
+	dm.approvalMux.Lock()
+	defer dm.approvalMux.Unlock()
+
+	pending, exists := dm.approvals[deploymentID]
+	return pending, exists
+}
+
+// notifyApprovers posts the pending plan to APPROVAL_WEBHOOK_URL if set.
+func notifyApprovers(deploymentID, summary string) {
+	webhookURL := os.Getenv("APPROVAL_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		return
+	}
+
+	postWebhook(webhookURL, "approval", deploymentID, gin.H{
+		"deployment_id": deploymentID,
+		"username":      status.Username,
+		"repo_url":      status.RepoURL,
+		"plan_summary":  summary,
+		"plan_url":      fmt.Sprintf("/deploy/%s/plan", deploymentID),
+		"approve_url":   fmt.Sprintf("/deploy/%s/approve", deploymentID),
+	})
+}
+
+func authorizeApprover(c *gin.Context, status *DeploymentStatus) (string, bool) {
+	approver := approverForToken(bearerToken(c))
+	if approver == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not an authorized approver"})
+		return "", false
+	}
+	if approver == status.Username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Approvers cannot approve their own deployments"})
+		return "", false
+	}
+	return approver, true
+}
+
+// handleDeploymentPlan shows the plan a deployment awaits approval of to
+// approvers and to callers who may see the deployment.
+func handleDeploymentPlan(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if approverForToken(bearerToken(c)) == "" && !authorizeDeploymentView(c, status) {
+		return
+	}
+
+	pending, exists := deploymentManager.pendingPlan(deploymentID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No plan is awaiting approval for this deployment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": deploymentID,
+		"summary":       planSummary(pending.Plan),
+		"plan":          pending.Plan,
+		"requested_at":  pending.RequestedAt.Format(time.RFC3339),
+	})
+}
+
+func handleApproveDeployment(c *gin.Context) {
+	handleApprovalDecision(c, true)
+}
+
+func handleRejectDeployment(c *gin.Context) {
+	handleApprovalDecision(c, false)
+}
+
+func handleApprovalDecision(c *gin.Context, approved bool) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	approver, ok := authorizeApprover(c, status)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&body)
+	if body.Reason == "" && !approved {
+		body.Reason = "no reason given"
+	}
+
+	decision := approvalDecision{Approved: approved, Approver: approver, Reason: body.Reason}
+	if !deploymentManager.decideApproval(deploymentID, decision) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deployment is not awaiting approval"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"deployment_id": deploymentID,
+		"approved":      approved,
+		"approver":      approver,
+	})
+}
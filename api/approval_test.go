@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// TestCancelWhileAwaitingApproval cancels a deployment parked for approval
+// and checks that it leaves pending_approval, so runDeployment can finish
+// it as cancelled instead of discarding the result.
+func TestCancelWhileAwaitingApproval(t *testing.T) {
+	dm := newTestManager(t)
+	deploymentQueue = NewDeploymentQueue(1, time.Hour, func(*deploymentJob) {})
+	const deploymentID = "parked"
+
+	dm.CreateDeployment(deploymentID, &services.DeploymentRequest{Username: "alice"})
+	ctx, ok := dm.BeginRun(deploymentID)
+	if !ok {
+		t.Fatal("BeginRun did not start the queued deployment")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- dm.AwaitApproval(ctx, deploymentID, "Plan: 3 to add, 0 to change, 0 to destroy.") }()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, pending := dm.pendingPlan(deploymentID); pending && dm.GetDeploymentStatus(deploymentID).Status == "pending_approval" {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("deployment never awaited approval")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	state, err := dm.Cancel(deploymentID, false)
+	if err != nil || state != "cancelling" {
+		t.Fatalf("Cancel = %q, %v; want cancelling", state, err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("AwaitApproval returned nil for a cancelled deployment")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AwaitApproval did not return after the deployment was cancelled")
+	}
+
+	if status := dm.GetDeploymentStatus(deploymentID).Status; status != "running" {
+		t.Fatalf("status after cancelling is %q, want running for runDeployment to finish", status)
+	}
+	if _, pending := dm.pendingPlan(deploymentID); pending {
+		t.Fatal("plan still pending after the deployment was cancelled")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// errHoldsInfrastructure is returned for deployments whose cloud resources
+// still exist.
+var errHoldsInfrastructure = errors.New("deployment still has infrastructure; destroy it first")
+
+func archiveRetention() time.Duration {
+	if value := os.Getenv("ARCHIVE_RETENTION_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+		log.Printf("Invalid ARCHIVE_RETENTION_DAYS value %q, using default", value)
+	}
+	return 30 * 24 * time.Hour
+}
+
+// Archive hides a finished deployment from default listings. The record is
+// kept until the purge job removes it after the retention window. A
+// deployment whose infrastructure has not been destroyed cannot be archived.
+func (dm *DeploymentManager) Archive(deploymentID string) (*time.Time, error) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return nil, errDeploymentNotFound
+	}
+	if isActive(deployment.Status) {
+		return nil, fmt.Errorf("deployment is still %s", deployment.Status)
+	}
+	if holdsInfrastructure(deployment) {
+		return nil, errHoldsInfrastructure
+	}
+
+	if deployment.ArchivedAt == nil {
+		now := time.Now()
+		deployment.ArchivedAt = &now
+		dm.persist(deployment)
+	}
+	return deployment.ArchivedAt, nil
+}
+
+// PurgeArchived permanently removes deployments archived before the cutoff.
+// With an artifact store configured, each deployment's log is archived
+// there first; a deployment whose log cannot be archived is kept until the
+// next run.
+func (dm *DeploymentManager) PurgeArchived(cutoff time.Time) []string {
+	dm.deployMux.RLock()
+	var expired []string
+	for id, deployment := range dm.deployments {
+		if deployment.ArchivedAt != nil && !deployment.ArchivedAt.After(cutoff) && !holdsInfrastructure(deployment) {
+			expired = append(expired, id)
+		}
+	}
+	dm.deployMux.RUnlock()
+
+	var purged []string
+	for _, id := range expired {
+		if err := dm.archiveLog(id); err != nil {
+			log.Printf("Failed to archive logs of deployment %s, keeping it: %v", id, err)
+			continue
+		}
+		if err := dm.Purge(id, false); err != nil {
+			log.Printf("Failed to purge archived deployment %s: %v", id, err)
+			continue
+		}
+		purged = append(purged, id)
+	}
+	return purged
+}
+
+// archiveLog copies a deployment's log to the artifact store, if one is
+// configured.
+func (dm *DeploymentManager) archiveLog(deploymentID string) error {
+	if artifactStore == nil {
+		return nil
+	}
+	path, err := dm.logs.Path(deploymentID)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return artifactStore.Put(archivedLogKey(deploymentID), data)
+}
+
+func archivedLogKey(deploymentID string) string {
+	return "logs/" + deploymentID + ".jsonl"
+}
+
+func runArchivePurger(retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged := deploymentManager.PurgeArchived(time.Now().Add(-retention))
+		if len(purged) > 0 {
+			log.Printf("Purged %d archived deployments older than %s", len(purged), retention)
+		}
+		<-ticker.C
+	}
+}
+
+func handleArchiveDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+
+	archivedAt, err := deploymentManager.Archive(deploymentID)
+	if err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"deployment_id": deploymentID,
+		"archived_at":   archivedAt.Format(time.RFC3339),
+		"purge_after":   archivedAt.Add(archiveRetention()).Format(time.RFC3339),
+	})
+}
+
+// handleAdminArchivedLog serves the JSON-lines log of a purged deployment
+// from the artifact store.
+func handleAdminArchivedLog(c *gin.Context) {
+	if artifactStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No artifact store is configured"})
+		return
+	}
+
+	deploymentID := c.Param("deploymentId")
+	data, err := artifactStore.Get(archivedLogKey(deploymentID))
+	if errors.Is(err, store.ErrArtifactNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No archived log for this deployment"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", deploymentID+".jsonl"))
+	c.Data(http.StatusOK, "application/x-ndjson", data)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// artifactStore archives the logs of purged deployments; the log archive is
+// its only user. It is nil unless ARTIFACT_STORE is set.
+var artifactStore store.ArtifactStore
+
+// newArtifactStore opens the artifact store chosen by ARTIFACT_STORE:
+//
+//	local  files under ARTIFACT_DIR (default <data dir>/artifacts)
+//	azure  blobs in ARTIFACT_AZURE_CONTAINER of ARTIFACT_AZURE_ACCOUNT
+//	s3     objects in ARTIFACT_S3_BUCKET under ARTIFACT_S3_PREFIX
+//
+// It returns nil when ARTIFACT_STORE is not set. Apart from ARTIFACT_DIR, a
+// path on the host, these can come from the server config file like the
+// other portable settings.
+func newArtifactStore() (store.ArtifactStore, error) {
+	switch driver := os.Getenv("ARTIFACT_STORE"); driver {
+	case "":
+		return nil, nil
+	case "local":
+		dir := os.Getenv("ARTIFACT_DIR")
+		if dir == "" {
+			dir = filepath.Join(dataDir(), "artifacts")
+		}
+		return store.NewLocalArtifactStore(dir)
+	case "azure":
+		return store.NewAzureBlobArtifactStore(os.Getenv("ARTIFACT_AZURE_ACCOUNT"), os.Getenv("ARTIFACT_AZURE_CONTAINER"),
+			filepath.Join(dataDir(), "artifacts-scratch"))
+	case "s3":
+		return store.NewS3ArtifactStore(os.Getenv("ARTIFACT_S3_BUCKET"), os.Getenv("ARTIFACT_S3_PREFIX"))
+	default:
+		return nil, fmt.Errorf("unknown ARTIFACT_STORE %q; use local, azure or s3", driver)
+	}
+}
+
+// validArtifactDriver reports whether driver is an ARTIFACT_STORE value.
+func validArtifactDriver(driver string) bool {
+	switch driver {
+	case "", "local", "azure", "s3":
+		return true
+	}
+	return false
+}
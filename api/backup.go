@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+const backupCopyTimeout = 4 * time.Hour
+
+// staleDrillAge is when a running restore drill is assumed lost to a
+// restart and may be started again.
+const staleDrillAge = 2 * time.Hour
+
+var backupStore *store.BackupStore
+
+// handleCreateBackup snapshots a finished deployment and copies the snapshot
+// to another region (and optionally subscription). The copy runs in the
+// background; poll GET /backups/:backupId for its status.
+func handleCreateBackup(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	if status.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed deployments can be backed up"})
+		return
+	}
+
+	var body struct {
+		Location       string `json:"location"`
+		SubscriptionID string `json:"subscription_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !providers.IsKnownLocation(body.Location) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported location %q", body.Location)})
+		return
+	}
+
+	req, err := deploymentManager.Request(status.ID)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no recorded request to back up"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rawRequest, err := json.Marshal(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	backupID := fmt.Sprintf("%s-backup-%s", status.ID, time.Now().Format("20060102-150405"))
+	backup := &store.Backup{
+		ID:                  backupID,
+		DeploymentID:        status.ID,
+		Username:            status.Username,
+		RepoURL:             status.RepoURL,
+		SourceResourceGroup: status.ResourceGroup,
+		TargetLocation:      body.Location,
+		SubscriptionID:      body.SubscriptionID,
+		ResourceGroup:       providers.BackupResourceGroup(body.Location),
+		SnapshotName:        backupID,
+		Status:              "running",
+		CreatedAt:           time.Now(),
+		Request:             rawRequest,
+	}
+	if err := backupStore.Save(backup); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	go runBackup(backup, azure)
+
+	c.JSON(http.StatusAccepted, gin.H{"backup_id": backupID, "status": backup.Status})
+}
+
+func runBackup(backup *store.Backup, source *providers.AzureProvider) {
+	fail := func(err error) {
+		log.Printf("Backup %s failed: %v", backup.ID, err)
+		backup.Status = "failed"
+		backup.Error = err.Error()
+		if err := backupStore.Save(backup); err != nil {
+			log.Printf("Failed to save backup %s: %v", backup.ID, err)
+		}
+	}
+
+	unlock := providers.LockResourceGroup(source.ResourceGroup, "backup-"+backup.DeploymentID, nil)
+	snapshot, err := source.CreateSnapshot(backup.SnapshotName, backup.DeploymentID)
+	unlock()
+	if err != nil {
+		fail(fmt.Errorf("failed to snapshot deployment: %v", err))
+		return
+	}
+
+	target := &providers.AzureProvider{
+		ResourceGroup:  backup.ResourceGroup,
+		Location:       backup.TargetLocation,
+		SubscriptionID: backup.SubscriptionID,
+	}
+	snapshotID, err := target.CopySnapshot(snapshot.ID, backup.SnapshotName, backup.DeploymentID)
+	if err != nil {
+		fail(fmt.Errorf("failed to copy snapshot to %s: %v", backup.TargetLocation, err))
+		return
+	}
+	backup.SnapshotID = snapshotID
+	if err := backupStore.Save(backup); err != nil {
+		log.Printf("Failed to save backup %s: %v", backup.ID, err)
+	}
+
+	if err := target.WaitForSnapshotCopy(backup.SnapshotName, backupCopyTimeout); err != nil {
+		fail(err)
+		return
+	}
+
+	now := time.Now()
+	backup.Status = "completed"
+	backup.CompletedAt = &now
+	if err := backupStore.Save(backup); err != nil {
+		log.Printf("Failed to save backup %s: %v", backup.ID, err)
+	}
+}
+
+// backupSummary is a backup manifest without the embedded request, which
+// carries the GitHub token and environment values.
+func backupSummary(backup *store.Backup) gin.H {
+	summary := gin.H{
+		"id":                    backup.ID,
+		"deployment_id":         backup.DeploymentID,
+		"username":              backup.Username,
+		"repo_url":              backup.RepoURL,
+		"source_resource_group": backup.SourceResourceGroup,
+		"target_location":       backup.TargetLocation,
+		"resource_group":        backup.ResourceGroup,
+		"snapshot_name":         backup.SnapshotName,
+		"status":                backup.Status,
+		"created_at":            backup.CreatedAt.Format(time.RFC3339),
+	}
+	if backup.SubscriptionID != "" {
+		summary["subscription_id"] = backup.SubscriptionID
+	}
+	if backup.Error != "" {
+		summary["error"] = backup.Error
+	}
+	if backup.CompletedAt != nil {
+		summary["completed_at"] = backup.CompletedAt.Format(time.RFC3339)
+	}
+	if backup.Verification != nil {
+		summary["verification"] = backup.Verification
+	}
+	return summary
+}
+
+// backupOwner decodes a backup's request and stands a status in for the
+// deployment it was taken of, so access to the backup is decided like
+// access to the deployment, even once the deployment is gone.
+func backupOwner(backup *store.Backup) (*DeploymentStatus, *services.DeploymentRequest, error) {
+	var req services.DeploymentRequest
+	if err := json.Unmarshal(backup.Request, &req); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode backed up request: %v", err)
+	}
+	return &DeploymentStatus{Deployment: store.Deployment{
+		ID:           backup.DeploymentID,
+		Username:     backup.Username,
+		Organization: req.Organization,
+		Team:         req.Team,
+	}}, &req, nil
+}
+
+// authorizeBackup responds with the error and returns false unless the
+// caller is the team of the backup's deployment, or for a personal
+// deployment its owner or an admin. It returns the backed up request.
+func authorizeBackup(c *gin.Context, backup *store.Backup) (*services.DeploymentRequest, bool) {
+	owner, req, err := backupOwner(backup)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !authorizeRequestOwner(c, owner, req) {
+		return nil, false
+	}
+	return req, true
+}
+
+// handleListBackups lists the backups of the deployments the caller may
+// see, as GET /deployments does.
+func handleListBackups(c *gin.Context) {
+	backups, err := backupStore.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deploymentID := c.Query("deployment_id")
+	visibility := newDeploymentVisibility(c)
+	summaries := []gin.H{}
+	for _, backup := range backups {
+		if deploymentID != "" && backup.DeploymentID != deploymentID {
+			continue
+		}
+		if owner, _, err := backupOwner(backup); err != nil || !visibility.sees(owner) {
+			continue
+		}
+		summaries = append(summaries, backupSummary(backup))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"backups": summaries, "count": len(summaries)})
+}
+
+// handleGetBackup returns a backup's manifest to those authorizeBackup
+// lets see it.
+func handleGetBackup(c *gin.Context) {
+	backup, err := backupStore.Get(c.Param("backupId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	if _, ok := authorizeBackup(c, backup); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, backupSummary(backup))
+}
+
+// handleRestoreBackup queues a new deployment in the backup's region that
+// rebuilds the infrastructure from the recorded request and then restores
+// the application data from the backup snapshot. The restore is admitted
+// like a new deployment, against today's validation, quotas and budget.
+func handleRestoreBackup(c *gin.Context) {
+	backup, err := backupStore.Get(c.Param("backupId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	req, ok := authorizeBackup(c, backup)
+	if !ok {
+		return
+	}
+	if backup.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Backup is %s", backup.Status)})
+		return
+	}
+
+	req.Location = backup.TargetLocation
+	req.SnapshotBeforeDeploy = false
+	ownInfrastructure(req)
+	// validateRequest refuses these from callers, so they are set once
+	// the request is admitted.
+	req.SubscriptionID = ""
+	req.RestoreSnapshotID = ""
+	if !admitDeployment(c, req) {
+		return
+	}
+	req.SubscriptionID = backup.SubscriptionID
+	req.RestoreSnapshotID = backup.SnapshotID
+
+	deploymentID := startDeployment(req)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Restore queued",
+		"backup_id":     backup.ID,
+		"deployment_id": deploymentID,
+		"location":      backup.TargetLocation,
+	})
+}
+
+// drillResourceGroup is the throwaway resource group a backup's restore
+// drill runs in, within Azure's 90 character limit.
+func drillResourceGroup(backupID string) string {
+	const suffix = "-drill"
+	if len(backupID)+len(suffix) > 90 {
+		backupID = backupID[:90-len(suffix)]
+	}
+	return backupID + suffix
+}
+
+// handleVerifyBackup starts a restore drill of a completed backup: the
+// backup's snapshot is restored onto a throwaway VM in the backup's region
+// and checked for an intact filesystem, app and databases. The drill runs
+// in the background; poll GET /backups/:backupId for its verification.
+func handleVerifyBackup(c *gin.Context) {
+	backup, err := backupStore.Get(c.Param("backupId"))
+	if err != nil || backup.DeploymentID != c.Param("deploymentId") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	req, ok := authorizeBackup(c, backup)
+	if !ok {
+		return
+	}
+	if backup.Status != "completed" || backup.SnapshotID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Backup is %s", backup.Status)})
+		return
+	}
+	if backup.Verification != nil && backup.Verification.Status == "running" &&
+		time.Since(backup.Verification.StartedAt) < staleDrillAge {
+		c.JSON(http.StatusConflict, gin.H{"error": "Backup verification is already running"})
+		return
+	}
+
+	backup.Verification = &store.BackupVerification{Status: "running", StartedAt: time.Now()}
+	if err := backupStore.Save(backup); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	drill := &providers.AzureProvider{
+		ResourceGroup:  drillResourceGroup(backup.ID),
+		Location:       backup.TargetLocation,
+		SubscriptionID: backup.SubscriptionID,
+		VMName:         "restore-drill",
+		Credentials:    req.AzureCredentials,
+	}
+	go runBackupVerification(backup, drill)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"backup_id":      backup.ID,
+		"verification":   backup.Verification.Status,
+		"resource_group": drill.ResourceGroup,
+	})
+}
+
+func runBackupVerification(backup *store.Backup, drill *providers.AzureProvider) {
+	verification := backup.Verification
+	finish := func(status string, err error) {
+		now := time.Now()
+		verification.Status = status
+		verification.CompletedAt = &now
+		if err != nil {
+			log.Printf("Verification of backup %s failed: %v", backup.ID, err)
+			verification.Error = err.Error()
+		}
+		if err := backupStore.Save(backup); err != nil {
+			log.Printf("Failed to save backup %s: %v", backup.ID, err)
+		}
+	}
+	defer func() {
+		if err := drill.DeleteResourceGroup(); err != nil {
+			log.Printf("Failed to delete restore drill resource group %s: %v", drill.ResourceGroup, err)
+		}
+	}()
+
+	if err := drill.CreateDrillVM(backup.SnapshotID, "restored-disk", services.VerifyDiskLUN); err != nil {
+		finish("failed", fmt.Errorf("failed to restore backup onto a drill VM: %v", err))
+		return
+	}
+
+	output, err := drill.RunShellScript(services.VerifyBackupScript)
+	if err != nil {
+		finish("failed", fmt.Errorf("failed to run backup checks: %v", err))
+		return
+	}
+	checks, complete := services.ParseBackupChecks(output)
+	verification.Checks = checks
+	if !complete {
+		finish("failed", fmt.Errorf("backup checks did not finish: %s", output))
+		return
+	}
+	for _, check := range checks {
+		if !check.OK {
+			finish("failed", nil)
+			return
+		}
+	}
+	finish("passed", nil)
+}
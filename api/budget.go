@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// userBudget looks the user up in USER_MONTHLY_BUDGETS, a comma separated
+// list of username=amount pairs set by the operator.
+func userBudget(username string) float64 {
+	for _, entry := range strings.Split(os.Getenv("USER_MONTHLY_BUDGETS"), ",") {
+		name, amount, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || name != username {
+			continue
+		}
+		budget, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			log.Printf("Invalid budget %q for user %s in USER_MONTHLY_BUDGETS", amount, username)
+			return 0
+		}
+		return budget
+	}
+	return 0
+}
+
+// budgetUser is whose USER_MONTHLY_BUDGETS entry applies to a request:
+// the caller's if they have a session, as a request can name any username.
+// Without a session there is only the request's username to go by, so the
+// per-user budget is advisory for callers who do not sign in.
+func budgetUser(c *gin.Context, req *services.DeploymentRequest) string {
+	if session := requestSession(c); session != nil {
+		return session.Username
+	}
+	return req.Username
+}
+
+// imposedBudget is the tighter of the operator's limit for the caller and
+// the team's limit, which no request can lift.
+func imposedBudget(c *gin.Context, req *services.DeploymentRequest) float64 {
+	budget := userBudget(budgetUser(c, req))
+	if quota := teamQuota(req); quota != nil {
+		if limit := quota.MaxMonthlyBudget; limit > 0 && (budget <= 0 || limit < budget) {
+			budget = limit
+		}
+	}
+	return budget
+}
+
+// checkBudget rejects requests whose estimated monthly cost exceeds the
+// tightest of the request's, the operator's and the team's limit.
+// budget_override only lifts the request's own max_monthly_budget.
+func checkBudget(c *gin.Context, req *services.DeploymentRequest) (*providers.CostEstimate, error) {
+	budget, own := imposedBudget(c, req), false
+	if limit := req.MaxMonthlyBudget; limit > 0 && !req.BudgetOverride && (budget <= 0 || limit < budget) {
+		budget, own = limit, true
+	}
+	// App-only deployments provision nothing.
+	if budget <= 0 || services.Mode(req) == services.ModeAppOnly {
+		return nil, nil
+	}
+	hint := ""
+	if own {
+		hint = " (set budget_override to deploy anyway)"
+	}
+
+	estimate, err := services.EstimateMonthlyCost(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify budget of $%.2f/month: %v%s", budget, err, hint)
+	}
+
+	if estimate.Total > budget {
+		return estimate, fmt.Errorf("estimated cost of $%.2f/month for %s in %s exceeds budget of $%.2f/month%s",
+			estimate.Total, estimate.VMSize, estimate.Location, budget, hint)
+	}
+	return estimate, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// runningDeployment is a deployment whose pipeline is running.
+type runningDeployment struct {
+	cancel context.CancelFunc
+	// destroy is set by a cancel that asked for what the run already
+	// provisioned to be destroyed.
+	destroy bool
+}
+
+// BeginRun marks a queued deployment as running and returns the context its
+// pipeline runs with, which Cancel cancels. It returns false if the
+// deployment is no longer queued.
+func (dm *DeploymentManager) BeginRun(deploymentID string) (context.Context, bool) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists || deployment.Status != "queued" {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dm.running[deploymentID] = &runningDeployment{cancel: cancel}
+	deployment.Status = "running"
+	deployment.Error = nil
+	dm.persist(deployment)
+	return ctx, true
+}
+
+// EndRun forgets the running pipeline of a deployment once Deploy returns,
+// so it can no longer be cancelled, and returns it.
+func (dm *DeploymentManager) EndRun(deploymentID string) *runningDeployment {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	run := dm.running[deploymentID]
+	delete(dm.running, deploymentID)
+	if run != nil {
+		run.cancel()
+	}
+	return run
+}
+
+// Cancel stops a deployment. A queued deployment is cancelled at once and
+// Cancel returns "cancelled"; a running one has its pipeline's context
+// cancelled and Cancel returns "cancelling", leaving runDeployment to
+// finish it.
+func (dm *DeploymentManager) Cancel(deploymentID string, destroy bool) (string, error) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return "", errDeploymentNotFound
+	}
+	if run, running := dm.running[deploymentID]; running {
+		run.destroy = run.destroy || destroy
+		run.cancel()
+		return "cancelling", nil
+	}
+	if deployment.Status != "queued" {
+		return "", fmt.Errorf("deployment is %s", deployment.Status)
+	}
+
+	now := time.Now()
+	deployment.Status = "cancelled"
+	deployment.Error = nil
+	deployment.EndTime = &now
+	appendTimeline(deployment, store.TimelineCancelled, "cancelled while queued")
+	dm.persist(deployment)
+	return "cancelled", nil
+}
+
+// handleCancelDeployment stops a queued or running deployment. A queued
+// deployment is taken off the queue. A running one has its Terraform or
+// Ansible command interrupted, or its wait for approval ended, and ends
+// as "cancelled" on its log stream; with destroy=true whatever it already
+// provisioned is destroyed first. Only its team, or for a personal
+// deployment its owner or an admin, can cancel it.
+func handleCancelDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+
+	state, err := deploymentManager.Cancel(deploymentID, c.Query("destroy") == "true")
+	if errors.Is(err, errDeploymentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if state == "cancelled" {
+		deploymentQueue.Remove(deploymentID)
+		deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     "warn",
+			Message:   "Deployment cancelled before it started",
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      "cancelled",
+			Code:      services.EventDeploymentCancelled,
+		})
+		deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     "system",
+			Message:   "DEPLOYMENT_COMPLETE",
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      "system",
+			Code:      services.EventDeploymentComplete,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"deployment_id": deploymentID,
+			"status":        "cancelled",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment_id": deploymentID,
+		"status":        "cancelling",
+		"logs_url":      fmt.Sprintf("/deploy/%s/logs", deploymentID),
+	})
+}
+
+// destroyCancelled destroys what a cancelled run provisioned, from the
+// Terraform directory it kept, and returns the destroy's error.
+func destroyCancelled(deploymentID string, req *services.DeploymentRequest) error {
+	logEvent := func(level, code, message, step string, data map[string]interface{}) {
+		log.Printf("[%s] %s: %s", level, step, message)
+		deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     level,
+			Message:   message,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      step,
+			Code:      code,
+			Data:      data,
+		})
+	}
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil || status.TerraformDir == "" || req.Pooled || services.Cloud(req) == services.CloudBYOS {
+		logEvent("info", "", "Nothing was provisioned, so there is nothing to destroy", "destroy", nil)
+		return nil
+	}
+
+	deploymentManager.SetDeploymentStatus(deploymentID, "destroying", nil)
+	logEvent("info", services.EventDestroyStarted, "Destroying the partially created infrastructure...", "destroy", nil)
+	opts := services.DestroyOptions{PublicIP: status.PublicIP}
+	if err := services.NewDeploymentService().Destroy(req, status.TerraformDir, opts, deploymentManager, deploymentID); err != nil {
+		logEvent("error", services.EventDestroyFailed, fmt.Sprintf("Destroy failed: %v", err), "destroy", map[string]interface{}{"error": err.Error()})
+		return err
+	}
+	logEvent("success", services.EventDestroySucceeded, "Partially created infrastructure destroyed", "destroy", nil)
+	return nil
+}
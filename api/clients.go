@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	types "sathwikshetty33/Django-vpc/Types"
+)
+
+// DefaultClientQueueSize is used when LOG_CLIENT_QUEUE is not set.
+const DefaultClientQueueSize = 100
+
+// clientQueueSize is how many undelivered messages an SSE client may have
+// queued, from LOG_CLIENT_QUEUE.
+func clientQueueSize() int {
+	if value := os.Getenv("LOG_CLIENT_QUEUE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			return size
+		}
+		log.Printf("Invalid LOG_CLIENT_QUEUE %q, using %d", value, DefaultClientQueueSize)
+	}
+	return DefaultClientQueueSize
+}
+
+// logClient is one SSE listener. Broadcasts only append to its queue, and
+// the client's own goroutine hands queued messages to Messages, so a slow
+// reader never holds up a deployment. When the queue is full the oldest
+// message is dropped; the reader notices the gap in sequence numbers and
+// can fetch the missing entries from the logs endpoint.
+//
+// Only the delivery goroutine sends on or closes the messages channel,
+// which is what makes removing a client during a broadcast safe.
+type logClient struct {
+	messages chan types.LogMessage
+
+	mux    sync.Mutex
+	queue  []types.LogMessage
+	limit  int
+	closed bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newLogClient(limit int) *logClient {
+	c := &logClient{
+		messages: make(chan types.LogMessage),
+		limit:    limit,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Messages delivers the client's log messages in order. It is closed once
+// the client is removed or kicked.
+func (c *logClient) Messages() <-chan types.LogMessage {
+	return c.messages
+}
+
+// enqueue queues msg for delivery without blocking. It reports whether an
+// older message had to be dropped to make room.
+func (c *logClient) enqueue(msg types.LogMessage) bool {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return false
+	}
+	dropped := false
+	if len(c.queue) >= c.limit {
+		c.queue[0] = types.LogMessage{}
+		c.queue = c.queue[1:]
+		dropped = true
+	}
+	c.queue = append(c.queue, msg)
+	c.mux.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// close stops delivery and closes Messages. It is safe to call more than
+// once.
+func (c *logClient) close() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.queue = nil
+	close(c.done)
+}
+
+func (c *logClient) run() {
+	defer close(c.messages)
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+
+		for {
+			c.mux.Lock()
+			if len(c.queue) == 0 || c.closed {
+				c.mux.Unlock()
+				break
+			}
+			msg := c.queue[0]
+			c.queue[0] = types.LogMessage{}
+			c.queue = c.queue[1:]
+			c.mux.Unlock()
+
+			select {
+			case c.messages <- msg:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// clientsFor returns a snapshot of a deployment's clients, so broadcasts
+// never iterate the shared map.
+func (dm *DeploymentManager) clientsFor(deploymentID string) []*logClient {
+	dm.clientsMux.RLock()
+	defer dm.clientsMux.RUnlock()
+
+	clients := make([]*logClient, 0, len(dm.clients[deploymentID]))
+	for client := range dm.clients[deploymentID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// AddClient registers a new SSE client for a deployment. The caller must
+// pass it to RemoveClient when the connection ends.
+func (dm *DeploymentManager) AddClient(deploymentID string) *logClient {
+	client := newLogClient(clientQueueSize())
+
+	dm.clientsMux.Lock()
+	defer dm.clientsMux.Unlock()
+
+	if dm.clients[deploymentID] == nil {
+		dm.clients[deploymentID] = make(map[*logClient]bool)
+	}
+	dm.clients[deploymentID][client] = true
+
+	log.Printf("Client added for deployment %s. Total clients: %d", deploymentID, len(dm.clients[deploymentID]))
+	return client
+}
+
+// RemoveClient unregisters and closes a client. Removing a client that was
+// already kicked only closes it again, which is a no-op.
+func (dm *DeploymentManager) RemoveClient(deploymentID string, client *logClient) {
+	dm.clientsMux.Lock()
+	if clients, exists := dm.clients[deploymentID]; exists && clients[client] {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(dm.clients, deploymentID)
+		}
+		log.Printf("Client removed for deployment %s. Remaining clients: %d", deploymentID, len(clients))
+	}
+	dm.clientsMux.Unlock()
+
+	client.close()
+}
+
+// KickClients disconnects every SSE client of a deployment and returns how
+// many there were.
+func (dm *DeploymentManager) KickClients(deploymentID string) int {
+	dm.clientsMux.Lock()
+	clients := dm.clients[deploymentID]
+	delete(dm.clients, deploymentID)
+	dm.clientsMux.Unlock()
+
+	for client := range clients {
+		client.close()
+	}
+	return len(clients)
+}
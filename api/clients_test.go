@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// newTestManager returns a DeploymentManager backed by stores in a
+// temporary directory, with the broadcast logging silenced.
+func newTestManager(t *testing.T) *DeploymentManager {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	st, err := store.NewFileStore(filepath.Join(dir, "deployments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := store.NewLogStore(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := store.NewRequestStore(filepath.Join(dir, "requests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDeploymentManager(st, logs, requests)
+}
+
+func testMessage(i int) types.LogMessage {
+	return types.LogMessage{Level: "info", Message: fmt.Sprintf("message %d", i), Step: "test"}
+}
+
+// drain reads a client's messages until Messages is closed.
+func drain(client *logClient, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for range client.Messages() {
+	}
+}
+
+// TestBroadcastDuringRemoveAndKick broadcasts while clients come and go,
+// for the race detector: a client removed or kicked mid-broadcast must
+// neither panic on a closed channel nor leave its reader hanging.
+func TestBroadcastDuringRemoveAndKick(t *testing.T) {
+	dm := newTestManager(t)
+	const deploymentID = "race-test"
+
+	var broadcasters, readers sync.WaitGroup
+	stop := make(chan struct{})
+	for b := 0; b < 4; b++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				dm.BroadcastLog(deploymentID, testMessage(i))
+			}
+		}()
+	}
+
+	for round := 0; round < 50; round++ {
+		clients := make([]*logClient, 8)
+		for i := range clients {
+			clients[i] = dm.AddClient(deploymentID)
+			readers.Add(1)
+			go drain(clients[i], &readers)
+		}
+		for _, client := range clients[:4] {
+			go dm.RemoveClient(deploymentID, client)
+		}
+		dm.KickClients(deploymentID)
+		// Removing kicked clients again only closes them again.
+		for _, client := range clients[4:] {
+			dm.RemoveClient(deploymentID, client)
+		}
+	}
+	close(stop)
+	broadcasters.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("readers of removed or kicked clients never saw Messages closed")
+	}
+}
+
+// TestSlowClientDropsOldest checks that a client that does not read keeps
+// only the newest messages, in order, without holding up broadcasts.
+func TestSlowClientDropsOldest(t *testing.T) {
+	t.Setenv("LOG_CLIENT_QUEUE", "3")
+	dm := newTestManager(t)
+	const deploymentID = "slow-client"
+
+	client := dm.AddClient(deploymentID)
+	defer dm.RemoveClient(deploymentID, client)
+
+	const total = 20
+	broadcast := make(chan struct{})
+	go func() {
+		for i := 1; i <= total; i++ {
+			dm.BroadcastLog(deploymentID, testMessage(i))
+		}
+		close(broadcast)
+	}()
+	select {
+	case <-broadcast:
+	case <-time.After(10 * time.Second):
+		t.Fatal("broadcasting to a client that does not read blocked")
+	}
+
+	// The delivery goroutine may hold one message it took before the
+	// queue filled, besides the 3 queued.
+	var received []int64
+	timeout := time.After(5 * time.Second)
+	for len(received) == 0 || received[len(received)-1] != total {
+		select {
+		case msg := <-client.Messages():
+			received = append(received, msg.Sequence)
+		case <-timeout:
+			t.Fatalf("did not receive the last message, got sequences %v", received)
+		}
+	}
+
+	if len(received) > 4 {
+		t.Fatalf("received %d messages from a queue of 3, want at most 4: %v", len(received), received)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("messages out of order: %v", received)
+		}
+	}
+	want := []int64{total - 2, total - 1, total}
+	tail := received[len(received)-3:]
+	for i := range want {
+		if tail[i] != want[i] {
+			t.Fatalf("newest messages are %v, want %v", tail, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// cloneOverrides are the request fields a clone may change. Unset fields
+// keep the source deployment's values; env_variables are merged over the
+// source's.
+type cloneOverrides struct {
+	Environment  *string           `json:"environment"`
+	VMSize       *string           `json:"vm_size"`
+	Location     *string           `json:"location"`
+	EnvVariables map[string]string `json:"env_variables"`
+	AutoDeploy   *bool             `json:"auto_deploy"`
+}
+
+// handleCloneDeployment replays a deployment's original request, with
+// overrides, into a fresh deployment. The request carries the source's
+// GitHub token and secrets, so only its owner or an admin may clone it, and
+// the clone is admitted like any new deployment.
+func handleCloneDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if caller, ok := deploymentCaller(c, status); !ok || (caller != status.Username && !isAdminRequest(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner of a deployment can clone it"})
+		return
+	}
+
+	req, err := deploymentManager.Request(deploymentID)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no recorded request to clone"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// A clone gets a resource group of its own, which cannot be one the
+	// source was deployed into rather than created.
+	if req.ExistingResourceGroup != "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment was deployed into the existing resource group " + req.ExistingResourceGroup + ", which a clone cannot share; deploy into another one with POST /deploy"})
+		return
+	}
+	sourceResourceGroup, _ := services.ResourceGroupName(req)
+
+	var overrides cloneOverrides
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&overrides); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	if overrides.Environment != nil {
+		req.Environment = *overrides.Environment
+	}
+	if overrides.VMSize != nil {
+		req.VMSize = *overrides.VMSize
+	}
+	if overrides.Location != nil {
+		req.Location = *overrides.Location
+	}
+	if overrides.AutoDeploy != nil {
+		req.AutoDeploy = *overrides.AutoDeploy
+	}
+	if len(overrides.EnvVariables) > 0 {
+		merged := make(map[string]string, len(req.EnvVariables)+len(overrides.EnvVariables))
+		for key, value := range req.EnvVariables {
+			merged[key] = value
+		}
+		for key, value := range overrides.EnvVariables {
+			merged[key] = value
+		}
+		req.EnvVariables = merged
+	}
+	req.RestoreSnapshotID = ""
+	ownInfrastructure(req)
+
+	if resourceGroup, _ := services.ResourceGroupName(req); resourceGroup == sourceResourceGroup {
+		c.JSON(http.StatusConflict, gin.H{"error": "Clone would deploy into the source's resource group; set a different environment or location"})
+		return
+	}
+
+	// A restored source keeps the subscription it was restored into, which
+	// new requests cannot set.
+	subscriptionID := req.SubscriptionID
+	req.SubscriptionID = ""
+	if !admitDeployment(c, req) {
+		return
+	}
+	req.SubscriptionID = subscriptionID
+
+	cloneID := startDeployment(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"message":       "Clone queued",
+		"source_id":     deploymentID,
+		"deployment_id": cloneID,
+		"timestamp":     time.Now().Format(time.RFC3339),
+	})
+}
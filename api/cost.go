@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+)
+
+// costCache keeps one cost report per deployment per UTC day; Cost
+// Management data only refreshes a few times a day anyway.
+type costCache struct {
+	mux     sync.Mutex
+	reports map[string]*providers.CostReport
+}
+
+var deploymentCosts = &costCache{reports: make(map[string]*providers.CostReport)}
+
+// costRefreshInterval is how soon refresh=true may query Cost Management
+// again for a deployment, since its rate limits are low. Admins may
+// refresh at any time.
+const costRefreshInterval = time.Hour
+
+func sameUTCDay(a, b time.Time) bool {
+	return a.UTC().Format("2006-01-02") == b.UTC().Format("2006-01-02")
+}
+
+func (cc *costCache) get(deploymentID string) *providers.CostReport {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	report, exists := cc.reports[deploymentID]
+	if !exists || !sameUTCDay(report.FetchedAt, time.Now()) {
+		return nil
+	}
+	return report
+}
+
+func (cc *costCache) put(deploymentID string, report *providers.CostReport) {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	cc.reports[deploymentID] = report
+}
+
+// handleDeploymentCost reports the cost the deployment's resource group has
+// accrued, cached for the UTC day. refresh=true queries it again if the
+// cached report is older than costRefreshInterval. It is queried with the
+// deployment's credentials, so only callers who may see it can ask.
+func handleDeploymentCost(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentView(c, status) {
+		return
+	}
+	if status.ResourceGroup == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no recorded resource group"})
+		return
+	}
+
+	if report := deploymentCosts.get(deploymentID); report != nil {
+		refresh := c.Query("refresh") == "true" && (isAdminRequest(c) || time.Since(report.FetchedAt) >= costRefreshInterval)
+		if !refresh {
+			c.JSON(http.StatusOK, gin.H{"deployment_id": deploymentID, "cost": report, "cached": true})
+			return
+		}
+	}
+
+	req, err := deploymentManager.Request(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	azure := providers.AzureProvider{
+		ResourceGroup:  status.ResourceGroup,
+		SubscriptionID: req.SubscriptionID,
+		Credentials:    req.AzureCredentials,
+	}
+	report, err := azure.GetResourceGroupCost(status.StartTime, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	deploymentCosts.put(deploymentID, report)
+
+	c.JSON(http.StatusOK, gin.H{"deployment_id": deploymentID, "cost": report, "cached": false})
+}
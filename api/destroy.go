@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// BeginDestroy marks a finished deployment as being destroyed, so no other
+// operation or destroy starts on it meanwhile.
+func (dm *DeploymentManager) BeginDestroy(deploymentID string) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return errDeploymentNotFound
+	}
+	if isActive(deployment.Status) || deployment.Status == "destroyed" {
+		return fmt.Errorf("deployment is %s", deployment.Status)
+	}
+	deployment.Status = "destroying"
+	deployment.Error = nil
+	dm.persist(deployment)
+	return nil
+}
+
+// appDependents returns the app-only deployments, not yet destroyed, that
+// run on an infra-only deployment's VM.
+func (dm *DeploymentManager) appDependents(infraID string) []string {
+	dm.deployMux.RLock()
+	var appOnly []string
+	for id, deployment := range dm.deployments {
+		if deployment.Mode == services.ModeAppOnly && deployment.Status != "destroyed" {
+			appOnly = append(appOnly, id)
+		}
+	}
+	dm.deployMux.RUnlock()
+
+	var dependents []string
+	for _, id := range appOnly {
+		if req, err := dm.Request(id); err == nil && req.InfraDeploymentID == infraID {
+			dependents = append(dependents, id)
+		}
+	}
+	return dependents
+}
+
+// handleDestroyDeployment tears down a deployment's infrastructure from the
+// Terraform directory kept after its run. The destroy waits in the
+// deployment queue at its request's priority, then streams its progress on
+// the deployment's log stream; the
+// deployment is "destroyed" when it succeeds. keep_data_disk=true keeps the
+// VM's data disk and keep_github=true the auto-deploy workflow and secrets
+// in the repository. An infra-only deployment cannot be destroyed while an
+// app-only deployment on its VM is active, and takes the others with it.
+// Only its team, or for a personal deployment its owner or an admin, can
+// destroy it.
+func handleDestroyDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	req, err := deploymentManager.Request(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	if !authorizeRequestOwner(c, status, req) {
+		return
+	}
+	if req.Pooled || services.Cloud(req) == services.CloudBYOS {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no infrastructure of its own to destroy"})
+		return
+	}
+	if services.Mode(req) == services.ModeAppOnly {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Deployment runs on the infrastructure of %s; destroy that deployment instead", req.InfraDeploymentID)})
+		return
+	}
+	if services.Mode(req) == services.ModeInfraOnly {
+		for _, id := range deploymentManager.appDependents(deploymentID) {
+			if dependent := deploymentManager.GetDeploymentStatus(id); dependent != nil && isActive(dependent.Status) {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("App-only deployment %s on this deployment's VM is %s; wait for it or cancel it first", id, dependent.Status)})
+				return
+			}
+		}
+	}
+	if status.TerraformDir == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no kept Terraform state; it was not provisioned or its run predates kept state"})
+		return
+	}
+	opts := services.DestroyOptions{
+		PublicIP:     status.PublicIP,
+		KeepDataDisk: c.Query("keep_data_disk") == "true",
+		KeepGitHub:   c.Query("keep_github") == "true",
+	}
+
+	if err := deploymentManager.BeginDestroy(deploymentID); err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	enqueueOperation(deploymentID, req, func() {
+		runDestroy(deploymentID, req, status.TerraformDir, opts)
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment_id": deploymentID,
+		"status":        "destroying",
+		"logs_url":      fmt.Sprintf("/deploy/%s/logs", deploymentID),
+	})
+}
+
+func runDestroy(deploymentID string, req *services.DeploymentRequest, terraformDir string, opts services.DestroyOptions) {
+	logEvent := func(level, code, message, step string, data map[string]interface{}) {
+		log.Printf("[%s] %s: %s", level, step, message)
+		deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     level,
+			Message:   message,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      step,
+			Code:      code,
+			Data:      data,
+		})
+	}
+
+	logEvent("info", services.EventDestroyStarted, "Destroying deployment infrastructure...", "destroy", nil)
+	err := services.NewDeploymentService().Destroy(req, terraformDir, opts, deploymentManager, deploymentID)
+	if status := deploymentManager.GetDeploymentStatus(deploymentID); status == nil || status.Status != "destroying" {
+		log.Printf("Deployment %s was finalized while destroying, discarding result", deploymentID)
+		return
+	}
+	if err != nil {
+		logEvent("error", services.EventDestroyFailed, fmt.Sprintf("Destroy failed: %v", err), "error", map[string]interface{}{"error": err.Error()})
+		deploymentManager.SetDeploymentStatus(deploymentID, "failed", err)
+	} else {
+		logEvent("success", services.EventDestroySucceeded, "Deployment infrastructure destroyed", "destroyed", nil)
+		deploymentManager.SetDeploymentStatus(deploymentID, "destroyed", nil)
+		if services.Mode(req) == services.ModeInfraOnly {
+			for _, id := range deploymentManager.appDependents(deploymentID) {
+				logEvent("info", services.EventDestroySucceeded, fmt.Sprintf("App-only deployment %s was destroyed with the VM it ran on", id), "destroyed", map[string]interface{}{"deployment_id": id})
+				deploymentManager.SetDeploymentStatus(id, "destroyed", nil)
+			}
+		}
+	}
+	logEvent("system", services.EventDeploymentComplete, "DEPLOYMENT_COMPLETE", "system", nil)
+
+	time.Sleep(2 * time.Second)
+	deploymentManager.KickClients(deploymentID)
+}
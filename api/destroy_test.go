@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// TestDestroyInfraWithActiveAppDeployment checks that an infra-only
+// deployment is not destroyed under an app-only one still running on it.
+func TestDestroyInfraWithActiveAppDeployment(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	deploymentManager = newTestManager(t)
+	deploymentManager.CreateDeployment("infra", &services.DeploymentRequest{Username: "alice", Mode: services.ModeInfraOnly})
+	deploymentManager.SetDeploymentStatus("infra", "completed", nil)
+	deploymentManager.CreateDeployment("app", &services.DeploymentRequest{Username: "alice", Mode: services.ModeAppOnly, InfraDeploymentID: "infra"})
+	deploymentManager.CreateDeployment("other", &services.DeploymentRequest{Username: "alice", Mode: services.ModeAppOnly, InfraDeploymentID: "elsewhere"})
+
+	if dependents := deploymentManager.appDependents("infra"); len(dependents) != 1 || dependents[0] != "app" {
+		t.Fatalf("appDependents = %v, want [app]", dependents)
+	}
+	w := testRequest(handleDestroyDeployment, http.MethodDelete, "infra", "admin-secret")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("destroy under a queued app-only deployment = %d, want 409: %s", w.Code, w.Body)
+	}
+	if status := deploymentManager.GetDeploymentStatus("infra").Status; status != "completed" {
+		t.Fatalf("infra-only deployment is %s after a refused destroy", status)
+	}
+}
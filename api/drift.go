@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// stateStore keeps the Terraform state and variables of each deployment's
+// last apply. Both hold the deployment's SSH private key, so the store is
+// encrypted with REQUEST_STORE_KEY.
+var stateStore *store.RequestStore
+
+// stateRecord is what stateStore keeps per deployment.
+type stateRecord struct {
+	State      string    `json:"state"`
+	TFVars     string    `json:"tfvars"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// RecordState stores the state and variables of a deployment's last apply.
+func (dm *DeploymentManager) RecordState(deploymentID string, state, tfvars []byte) error {
+	return stateStore.Save(deploymentID, stateRecord{State: string(state), TFVars: string(tfvars), RecordedAt: time.Now()})
+}
+
+// handleDeploymentDrift plans a deployment's recorded main.tf against the
+// state of its last apply and reports whether its VM, network security
+// group or public IP changed outside Terraform, with the attributes that
+// differ. It only plans, so it changes nothing in Azure or in the recorded
+// state, but it runs with the deployment's credentials, so only its team,
+// or for a personal deployment its owner or an admin, can ask.
+func handleDeploymentDrift(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentOwner(c, status) {
+		return
+	}
+
+	var state stateRecord
+	if err := stateStore.Get(status.ID, &state); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No Terraform state was recorded for this deployment; redeploy it to enable drift detection"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recorded, ok := recordedConfig(c, status.ID)
+	if !ok {
+		return
+	}
+	if recorded.MainTF == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No Terraform configuration was recorded for this deployment"})
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "drift-"+status.ID+"-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"main.tf":           recorded.MainTF,
+		"terraform.tfvars":  state.TFVars,
+		"terraform.tfstate": state.State,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	drift, err := azure.PlanDrift(c.Request.Context(), dir)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to plan against the recorded state: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id":     status.ID,
+		"drifted":           drift.Drifted,
+		"components":        drift.Components,
+		"drift":             drift.Drift,
+		"changes":           drift.Changes,
+		"state_recorded_at": state.RecordedAt.Format(time.RFC3339),
+		"checked_at":        time.Now().Format(time.RFC3339),
+	})
+}
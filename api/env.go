@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// handleUpdateEnv changes some of a deployment's env variables in place: it
+// rewrites them on the VM, restarts the app, saves them in the stored
+// request and, for auto-deploy repositories, updates the workflow's
+// secrets. A null value removes a variable. Only its team, or for a
+// personal deployment its owner or an admin, can change them, and the
+// result is held to the same limits as a deployment request.
+func handleUpdateEnv(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil {
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	if !authorizeRequestOwner(c, status, req) {
+		return
+	}
+	if req.Pooled || req.ScaleSet {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Env variables can only be updated on a single dedicated VM"})
+		return
+	}
+
+	var body struct {
+		Env map[string]*string `json:"env" binding:"required"`
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, requestBodyLimit())
+	if err := c.ShouldBindJSON(&body); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	// Hold the lock from reading the stored request until the update is
+	// saved, so concurrent updates apply one after the other rather than
+	// each saving over the other's variables.
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "env-"+status.ID, nil)
+	defer unlock()
+	if req, err = deploymentManager.Request(status.ID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+
+	env := make(map[string]string, len(req.EnvVariables)+len(body.Env))
+	for key, value := range req.EnvVariables {
+		env[key] = value
+	}
+	var set, removed []string
+	for key, value := range body.Env {
+		if !services.ValidEnvKey(key) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid variable name %q", key)})
+			return
+		}
+		if _, secret := req.Secrets[key]; secret {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is a secret and cannot be set as an env variable", key)})
+			return
+		}
+		if value == nil {
+			if _, exists := env[key]; exists {
+				delete(env, key)
+				removed = append(removed, key)
+			}
+			continue
+		}
+		if strings.ContainsRune(*value, 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s contains a NUL byte", key)})
+			return
+		}
+		if current, exists := env[key]; !exists || current != *value {
+			env[key] = *value
+			set = append(set, key)
+		}
+	}
+	slices.Sort(set)
+	slices.Sort(removed)
+	if len(set) == 0 && len(removed) == 0 {
+		c.JSON(http.StatusOK, gin.H{"updated": []string{}, "removed": []string{}, "message": "No changes"})
+		return
+	}
+
+	updated := *req
+	updated.EnvVariables = env
+	if missing := services.MissingEnv(&updated, updated.RequiredEnv); len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.MissingEnvError(missing).Error(), "missing_env": missing})
+		return
+	}
+	if err := validateLimits(&updated); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	output, err := azure.RunShellScript(services.UpdateEnvScript(env))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update env variables: " + err.Error()})
+		return
+	}
+	if !services.EnvUpdated(output) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update env variables on the VM", "output": output})
+		return
+	}
+	if err := deploymentManager.requests.Save(status.ID, &updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Env variables were updated on the VM but could not be saved: " + err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"updated": set,
+		"removed": removed,
+		"output":  output,
+	}
+	if updated.AutoDeploy && updated.GithubToken != "" {
+		if err := services.UpdateWorkflowSecrets(&updated, set, removed); err != nil {
+			response["github_error"] = err.Error()
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
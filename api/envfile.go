@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+const maxEnvFileSize = 256 << 10
+
+// bindDeploymentRequest reads a deployment request from either a JSON body
+// or a multipart form with the JSON in a "request" field and an optional
+// "env_file" upload, then folds the env file into env_variables.
+func bindDeploymentRequest(c *gin.Context, req *services.DeploymentRequest) error {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, requestBodyLimit())
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		// PostForm hides a body over the limit.
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(c.PostForm("request")), req); err != nil {
+			return fmt.Errorf("invalid request field: %v", err)
+		}
+		if header, err := c.FormFile("env_file"); err == nil {
+			if header.Size > maxEnvFileSize {
+				return fmt.Errorf("env_file must be at most %d bytes", maxEnvFileSize)
+			}
+			file, err := header.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read env_file: %v", err)
+			}
+			defer file.Close()
+			data, err := io.ReadAll(io.LimitReader(file, maxEnvFileSize))
+			if err != nil {
+				return fmt.Errorf("failed to read env_file: %v", err)
+			}
+			req.EnvFile = string(data)
+		}
+	} else if err := c.ShouldBindJSON(req); err != nil {
+		return err
+	}
+
+	return applyEnvFile(req)
+}
+
+// applyEnvFile parses req.EnvFile into req.EnvVariables. Variables given
+// explicitly in env_variables take precedence over the file.
+func applyEnvFile(req *services.DeploymentRequest) error {
+	if req.EnvFile == "" {
+		return nil
+	}
+	if len(req.EnvFile) > maxEnvFileSize {
+		return fmt.Errorf("env_file must be at most %d bytes", maxEnvFileSize)
+	}
+
+	vars, err := services.ParseEnvFile(req.EnvFile)
+	if err != nil {
+		return err
+	}
+	for key, value := range req.EnvVariables {
+		vars[key] = value
+	}
+	req.EnvVariables = vars
+	req.EnvFile = ""
+	return nil
+}
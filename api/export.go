@@ -0,0 +1,199 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// tfvarsLine matches a variable assignment in terraform.tfvars.
+var tfvarsLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=`)
+
+// statePointer tells where a deployment's Terraform state is kept, since
+// the state holds its SSH private key and is not exported.
+type statePointer struct {
+	DeploymentID  string `json:"deployment_id"`
+	ResourceGroup string `json:"resource_group,omitempty"`
+	// TerraformDir is the directory on the deployer host holding the
+	// state, if the deployment's run was kept.
+	TerraformDir string `json:"terraform_dir,omitempty"`
+	// RecordedAt is when the state of the last apply was recorded for
+	// drift checks, if it was.
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+}
+
+// tfvarsExample turns the recorded terraform.tfvars into an example with
+// the variable names and no values, since they include the SSH private key
+// and Azure credentials.
+func tfvarsExample(tfvars string) string {
+	var example strings.Builder
+	example.WriteString("# Fill in and rename to terraform.tfvars. Keep it out of version control.\n")
+	for _, line := range strings.Split(tfvars, "\n") {
+		if match := tfvarsLine.FindStringSubmatch(line); match != nil {
+			example.WriteString(fmt.Sprintf("%s = \"\"\n", match[1]))
+		}
+	}
+	return example.String()
+}
+
+// exportReadme describes the bundle and the steps that are not in it.
+func exportReadme(status *DeploymentStatus, req *services.DeploymentRequest, pointer statePointer, files []string) string {
+	var readme strings.Builder
+	readme.WriteString(fmt.Sprintf("# %s\n\n", status.ID))
+	readme.WriteString(fmt.Sprintf("Exported from Django VPC on %s. This bundle describes the infrastructure and\n", time.Now().UTC().Format(time.RFC3339)))
+	readme.WriteString("configuration of the deployment so it can be managed from your own repository.\n\n")
+	readme.WriteString(fmt.Sprintf("- Repository: %s\n", status.RepoURL))
+	if status.PublicIP != "" {
+		readme.WriteString(fmt.Sprintf("- Server: %s\n", status.PublicIP))
+	}
+	if status.ResourceGroup != "" {
+		readme.WriteString(fmt.Sprintf("- Resource group: %s\n", status.ResourceGroup))
+	}
+	if status.Terraform != nil {
+		readme.WriteString(fmt.Sprintf("- Terraform template: %s\n", status.Terraform.Template))
+	}
+	readme.WriteString("\n## Contents\n\n")
+	for _, name := range files {
+		readme.WriteString(fmt.Sprintf("- `%s`\n", name))
+	}
+
+	readme.WriteString("\n## Manual steps\n\n")
+	step := 1
+	if pointer.TerraformDir != "" || pointer.RecordedAt != nil {
+		readme.WriteString(fmt.Sprintf("%d. Take over the Terraform state. It holds the server's SSH private key, so it is\n", step))
+		readme.WriteString("   not exported; `terraform/state-pointer.json` says where the deployer keeps it. Ask its\n")
+		readme.WriteString("   operator for `terraform.tfstate`, or import the resources into a new state with\n")
+		readme.WriteString("   `terraform import`. Then configure a remote backend of your own.\n")
+		step++
+	}
+	if slices.Contains(files, "terraform/terraform.tfvars.example") {
+		readme.WriteString(fmt.Sprintf("%d. Copy `terraform/terraform.tfvars.example` to `terraform/terraform.tfvars` and\n", step))
+		readme.WriteString("   fill it in, then run `terraform init` and `terraform plan` in `terraform/`. The plan\n")
+		readme.WriteString("   should show no changes.\n")
+		step++
+	}
+	readme.WriteString(fmt.Sprintf("%d. The playbook in `ansible/` has its environment values and GitHub token redacted as\n", step))
+	readme.WriteString("   `[REDACTED]`. Fill them in, from a vault rather than in the file, put the server's SSH\n")
+	readme.WriteString(fmt.Sprintf("   private key at `~/.ssh/%s` and run\n", status.ID))
+	readme.WriteString("   `ansible-playbook -i ansible/inventory.ini ansible/playbook.yml`.\n")
+	step++
+	if req.AutoDeploy {
+		readme.WriteString(fmt.Sprintf("%d. `.github/workflows/deploy.yml` deploys every push to main or master. It needs these\n", step))
+		readme.WriteString("   repository secrets, which the deployer already set unless you removed them:\n")
+		readme.WriteString("   - `SSH_PRIVATE_KEY`: the server's SSH private key\n")
+		var names []string
+		for key := range req.EnvVariables {
+			names = append(names, key)
+		}
+		for key := range req.Secrets {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		for _, key := range names {
+			readme.WriteString(fmt.Sprintf("   - `ENV_%s`: the value of %s\n", strings.ToUpper(key), key))
+		}
+		step++
+	}
+	readme.WriteString(fmt.Sprintf("%d. Once the repository manages the deployment, ask the deployer's operator to purge it\n", step))
+	readme.WriteString(fmt.Sprintf("   with `DELETE /admin/deployments/%s?released=true` so nothing else changes it.\n", status.ID))
+	return readme.String()
+}
+
+// handleExportDeployment serves a zip of what a deployment was provisioned
+// and configured with, laid out for committing to an infrastructure
+// repository: the Terraform configuration with an example tfvars and a
+// pointer to the state, the redacted playbook with an inventory, the
+// auto-deploy workflow and a README of the steps the bundle cannot do.
+// Nothing in it is secret, but it describes the deployment's whole setup,
+// so only its team, or for a personal deployment its owner or an admin, can
+// export it.
+func handleExportDeployment(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	if status.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed deployments can be exported"})
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	recorded, ok := recordedConfig(c, status.ID)
+	if !ok {
+		return
+	}
+
+	files := make(map[string][]byte)
+	pointer := statePointer{DeploymentID: status.ID, ResourceGroup: status.ResourceGroup, TerraformDir: status.TerraformDir}
+	if recorded.MainTF != "" {
+		files["terraform/main.tf"] = []byte(recorded.MainTF)
+		var state stateRecord
+		if err := stateStore.Get(status.ID, &state); err == nil {
+			pointer.RecordedAt = &state.RecordedAt
+			files["terraform/terraform.tfvars.example"] = []byte(tfvarsExample(state.TFVars))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := json.MarshalIndent(pointer, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		files["terraform/state-pointer.json"] = append(data, '\n')
+	}
+	playbook := recorded.Playbook
+	if playbook == "" {
+		playbook = string(services.RenderPlaybook(req, status.PublicIP))
+	}
+	files["ansible/playbook.yml"] = []byte(playbook)
+	files["ansible/inventory.ini"] = []byte(fmt.Sprintf("[django_servers]\n%s ansible_user=azureuser ansible_ssh_private_key_file=~/.ssh/%s\n", status.PublicIP, status.ID))
+	if req.AutoDeploy {
+		files[".github/workflows/deploy.yml"] = services.RenderWorkflow(req, status.PublicIP)
+	}
+
+	names := make([]string, 0, len(files)+1)
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files["README.md"] = []byte(exportReadme(status, req, pointer, names))
+	names = append([]string{"README.md"}, names...)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", status.ID+".zip"))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	archive := zip.NewWriter(c.Writer)
+	for _, name := range names {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: status.ID + "/" + name, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = w.Write(files[name])
+		}
+		if err != nil {
+			log.Printf("Failed to write export of %s: %v", status.ID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export of %s: %v", status.ID, err)
+	}
+}
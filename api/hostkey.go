@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// ForgetHostKey removes the host keys pinned for a deployment's servers, so
+// the next connection pins the key it is presented. It fails while an
+// operation may be connecting to them.
+func (dm *DeploymentManager) ForgetHostKey(deploymentID string) (string, error) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return "", errDeploymentNotFound
+	}
+	if isActive(deployment.Status) {
+		return "", fmt.Errorf("deployment is %s", deployment.Status)
+	}
+	if deployment.TerraformDir == "" {
+		return "", fmt.Errorf("deployment has no kept state")
+	}
+	path := services.KnownHostsPath(filepath.Join(deployment.TerraformDir, "azure_vm_key"))
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	forgotten := deployment.HostKey
+	deployment.HostKey = ""
+	dm.persist(deployment)
+	return forgotten, nil
+}
+
+// handleForgetHostKey unpins a deployment's host key. Management operations
+// refuse to connect to a server presenting a key other than the one pinned
+// on first contact; once the change is known to be legitimate, e.g. the VM
+// was rebuilt, this lets them trust the new one. Only those who may change
+// the deployment can unpin its key, as otherwise anyone could have the next
+// connection trust a server in the middle.
+func handleForgetHostKey(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	forgotten, err := deploymentManager.ForgetHostKey(deploymentID)
+	if err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id":      deploymentID,
+		"forgotten_host_key": forgotten,
+	})
+}
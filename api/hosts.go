@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// reconcileHosts points the app's allowed hosts at its VM's current public
+// IP and the request's domains, and records the IP if it changed. It runs
+// through the Azure VM agent, so it works after the deployment's SSH key is
+// gone.
+func reconcileHosts(status *DeploymentStatus, azure *providers.AzureProvider, req *services.DeploymentRequest) (string, []string, string, error) {
+	publicIP, err := azure.PublicIPAddress()
+	if err != nil {
+		return "", nil, "", fmt.Errorf("failed to read the VM's public IP: %v", err)
+	}
+	hosts := services.AllowedHosts(publicIP, req)
+
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "hosts-"+status.ID, nil)
+	output, err := azure.RunShellScript(services.ReconcileHostsScript(hosts))
+	unlock()
+	if err != nil {
+		return "", nil, "", fmt.Errorf("failed to update allowed hosts: %v", err)
+	}
+
+	if publicIP != status.PublicIP {
+		deploymentManager.SetPublicIP(status.ID, publicIP)
+	}
+	return publicIP, hosts, output, nil
+}
+
+// handleReconcileHosts updates a deployment's ALLOWED_HOSTS and CSRF and
+// CORS origins in place, after its VM got a new address or to change its
+// domains. A domains field replaces the stored request's domains.
+func handleReconcileHosts(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	if req.Pooled || req.ScaleSet {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Allowed hosts can only be updated on a single dedicated VM"})
+		return
+	}
+
+	var body struct {
+		Domains *[]string `json:"domains"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+	if body.Domains != nil {
+		if err := validateDomains(*body.Domains); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Domains = *body.Domains
+	}
+
+	publicIP, hosts, output, err := reconcileHosts(status, azure, req)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Domains != nil {
+		if err := deploymentManager.requests.Save(status.ID, req); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save domains: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public_ip": publicIP,
+		"hosts":     hosts,
+		"output":    output,
+	})
+}
+
+// handleDomainStatus checks a deployment's domains again, for those still
+// propagating when the deployment finished.
+func handleDomainStatus(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentView(c, status) {
+		return
+	}
+	if status.PublicIP == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deployment has no public IP yet"})
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+
+	checks := services.CheckDomains(req, status.PublicIP)
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Ready
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"public_ip": status.PublicIP,
+		"domains":   checks,
+		"ready":     ready,
+	})
+}
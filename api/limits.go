@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// Limits on what a deployment request may carry, since its env variables
+// and commands are written into the generated playbook and workflow.
+// Lengths are in characters, so values in any language get the same room.
+const (
+	defaultMaxRequestBodySize = 1 << 20
+	maxEnvVariables           = 200
+	maxEnvKeyLength           = 128
+	maxEnvValueLength         = 8192
+	maxAdditionalCommands     = 50
+)
+
+// requestBodyLimit is the largest /deploy body accepted, in bytes, 1 MiB
+// unless MAX_REQUEST_BODY_SIZE says otherwise. It includes an uploaded
+// env_file.
+func requestBodyLimit() int64 {
+	if value := os.Getenv("MAX_REQUEST_BODY_SIZE"); value != "" {
+		if limit, err := strconv.ParseInt(value, 10, 64); err == nil && limit >= maxEnvFileSize {
+			return limit
+		}
+		log.Printf("Invalid MAX_REQUEST_BODY_SIZE value %q, using default", value)
+	}
+	return defaultMaxRequestBodySize
+}
+
+// limitError is a request field over one of the limits. Handlers answer it
+// with 422 and its details.
+type limitError struct {
+	Field  string
+	Limit  int
+	Actual int
+	Unit   string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s has %d %s, more than the limit of %d", e.Field, e.Actual, e.Unit, e.Limit)
+}
+
+// limitDetails returns the response for a request rejected by its size:
+// 413 for a body over requestBodyLimit, 422 for a field over its limit.
+// It returns false for other errors.
+func limitDetails(err error) (int, gin.H, bool) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, gin.H{
+			"error":       fmt.Sprintf("Request body is larger than the limit of %d bytes", tooLarge.Limit),
+			"limit_bytes": tooLarge.Limit,
+		}, true
+	}
+	var limit *limitError
+	if errors.As(err, &limit) {
+		return http.StatusUnprocessableEntity, gin.H{
+			"error":  limit.Error(),
+			"field":  limit.Field,
+			"limit":  limit.Limit,
+			"actual": limit.Actual,
+			"unit":   limit.Unit,
+		}, true
+	}
+	return 0, nil, false
+}
+
+// validateLimits checks the request's env variables, secrets and additional
+// commands against their limits. Env values must be valid UTF-8, which an
+// uploaded env_file might not be.
+func validateLimits(req *services.DeploymentRequest) error {
+	if err := validateEnvLimits("env_variables", req.EnvVariables); err != nil {
+		return err
+	}
+	if err := validateEnvLimits("secrets", req.Secrets); err != nil {
+		return err
+	}
+	for i, spec := range req.Services {
+		if err := validateEnvLimits(fmt.Sprintf("services[%d].env_variables", i), spec.EnvVariables); err != nil {
+			return err
+		}
+	}
+	if len(req.AdditionalCommands) > maxAdditionalCommands {
+		return &limitError{Field: "additional_commands", Limit: maxAdditionalCommands, Actual: len(req.AdditionalCommands), Unit: "commands"}
+	}
+	for i, command := range req.AdditionalCommands {
+		if err := validateCommandLine(fmt.Sprintf("additional_commands[%d]", i), command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateEnvLimits(field string, env map[string]string) error {
+	if len(env) > maxEnvVariables {
+		return &limitError{Field: field, Limit: maxEnvVariables, Actual: len(env), Unit: "variables"}
+	}
+	for key, value := range env {
+		if length := utf8.RuneCountInString(key); length > maxEnvKeyLength {
+			return &limitError{Field: field + " name", Limit: maxEnvKeyLength, Actual: length, Unit: "characters"}
+		}
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("%s.%s is not valid UTF-8", field, key)
+		}
+		if length := utf8.RuneCountInString(value); length > maxEnvValueLength {
+			return &limitError{Field: fmt.Sprintf("%s.%s", field, key), Limit: maxEnvValueLength, Actual: length, Unit: "characters"}
+		}
+	}
+	return nil
+}
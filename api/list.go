@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// deploymentFilter selects deployments for GET /deployments.
+type deploymentFilter struct {
+	statuses        map[string]bool
+	username        string
+	repo            string
+	since, until    time.Time
+	includeArchived bool
+}
+
+// parseDeploymentFilter reads the filter from the query: status, a comma
+// separated list; username; repo, part of the repository URL; since and
+// until, RFC 3339 times bounding the start time; and include_archived.
+func parseDeploymentFilter(c *gin.Context) (*deploymentFilter, error) {
+	filter := &deploymentFilter{
+		username:        c.Query("username"),
+		repo:            strings.ToLower(c.Query("repo")),
+		includeArchived: c.Query("include_archived") == "true",
+	}
+	if value := c.Query("status"); value != "" {
+		filter.statuses = make(map[string]bool)
+		for _, status := range strings.Split(value, ",") {
+			filter.statuses[strings.TrimSpace(status)] = true
+		}
+	}
+	for _, bound := range []struct {
+		name string
+		time *time.Time
+	}{{"since", &filter.since}, {"until", &filter.until}} {
+		value := c.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time like 2006-01-02T15:04:05Z", bound.name)
+		}
+		*bound.time = parsed
+	}
+	return filter, nil
+}
+
+func (f *deploymentFilter) matches(deployment DeploymentStatus) bool {
+	switch {
+	case deployment.ArchivedAt != nil && !f.includeArchived:
+		return false
+	case f.statuses != nil && !f.statuses[deployment.Status]:
+		return false
+	case f.username != "" && deployment.Username != f.username:
+		return false
+	case f.repo != "" && !strings.Contains(strings.ToLower(deployment.RepoURL), f.repo):
+		return false
+	case !f.since.IsZero() && deployment.StartTime.Before(f.since):
+		return false
+	case !f.until.IsZero() && deployment.StartTime.After(f.until):
+		return false
+	}
+	return true
+}
+
+// parsePage reads limit, by default defaultListLimit and at most
+// maxListLimit, and offset from the query.
+func parsePage(c *gin.Context) (limit, offset int, err error) {
+	limit = defaultListLimit
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxListLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// deploymentVisibility decides which deployments a caller of GET
+// /deployments sees: those deploymentCaller lets them see, i.e. an admin
+// all of them, a session its own personal deployments and a team member
+// the team's, as for the team's own listing. It remembers the answer per
+// organization, team and owner, so each organization is only loaded a few
+// times.
+type deploymentVisibility struct {
+	c       *gin.Context
+	admin   bool
+	visible map[string]bool
+}
+
+func newDeploymentVisibility(c *gin.Context) *deploymentVisibility {
+	return &deploymentVisibility{c: c, admin: isAdminRequest(c), visible: make(map[string]bool)}
+}
+
+func (v *deploymentVisibility) sees(deployment *DeploymentStatus) bool {
+	if v.admin {
+		return true
+	}
+	key := deployment.Organization + "/" + deployment.Team + "/" + deployment.Username
+	visible, known := v.visible[key]
+	if !known {
+		_, visible = deploymentCaller(v.c, deployment)
+		v.visible[key] = visible
+	}
+	return visible
+}
+
+// handleListDeployments lists the known deployments, newest first, for
+// dashboards. Archived deployments are left out unless include_archived is
+// set, as are deployments the caller may not see: personal ones of other
+// users and team ones of teams they are not on, unless they are an admin.
+// total counts the deployments that match before limit and offset apply.
+func handleListDeployments(c *gin.Context) {
+	filter, err := parseDeploymentFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, offset, err := parsePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deployments := deploymentManager.ListDeployments()
+	visibility := newDeploymentVisibility(c)
+	var matched []DeploymentStatus
+	for i := len(deployments) - 1; i >= 0; i-- {
+		if filter.matches(deployments[i]) && visibility.sees(&deployments[i]) {
+			matched = append(matched, deployments[i])
+		}
+	}
+
+	response := []gin.H{}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		response = append(response, deploymentSummary(matched[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deployments": response,
+		"total":       len(matched),
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
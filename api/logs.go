@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// handleLogDownload serves the persisted log of a deployment, as plain text
+// by default or as the raw JSON lines with ?format=jsonl. It is authorized
+// like the log stream.
+func handleLogDownload(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeLogs(c, status) {
+		return
+	}
+
+	path, err := deploymentManager.logs.Path(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No logs recorded for this deployment"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	if c.Query("format") == "jsonl" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", deploymentID+".jsonl"))
+		c.DataFromReader(http.StatusOK, -1, "application/x-ndjson", file, nil)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", deploymentID+".log"))
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var logMsg types.LogMessage
+		if err := json.Unmarshal(scanner.Bytes(), &logMsg); err != nil {
+			continue
+		}
+		fmt.Fprintf(c.Writer, "%s [%s] [%s] %s\n", logMsg.Timestamp, strings.ToUpper(logMsg.Level), logMsg.Step, logMsg.Message)
+	}
+}
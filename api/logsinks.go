@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// StartShipping copies the deployment's log messages to sinks until
+// StopShipping.
+func (dm *DeploymentManager) StartShipping(deploymentID string, sinks []services.LogSink) {
+	dm.shipMux.Lock()
+	defer dm.shipMux.Unlock()
+
+	if _, exists := dm.shippers[deploymentID]; !exists {
+		dm.shippers[deploymentID] = services.NewLogShipper(deploymentID, sinks)
+	}
+}
+
+// StopShipping delivers the deployment's remaining messages to its sinks
+// and stops copying them.
+func (dm *DeploymentManager) StopShipping(deploymentID string) {
+	dm.shipMux.Lock()
+	shipper, exists := dm.shippers[deploymentID]
+	delete(dm.shippers, deploymentID)
+	dm.shipMux.Unlock()
+
+	if exists {
+		shipper.Close()
+	}
+}
+
+func (dm *DeploymentManager) ship(deploymentID string, logMsg types.LogMessage) {
+	dm.shipMux.Lock()
+	defer dm.shipMux.Unlock()
+
+	if shipper, exists := dm.shippers[deploymentID]; exists && !shipper.Ship(logMsg) {
+		log.Printf("Log sink queue full for deployment %s, dropped message %d", deploymentID, logMsg.Sequence)
+	}
+}
+
+var lokiLabelPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedSinkHeaders are set by the shipper itself.
+var reservedSinkHeaders = map[string]bool{"Content-Type": true, "Content-Length": true, "Host": true}
+
+func validateLogSinks(sinks []services.LogSink) error {
+	if len(sinks) > services.MaxLogSinks {
+		return fmt.Errorf("at most %d log_sinks are allowed", services.MaxLogSinks)
+	}
+	for i, sink := range sinks {
+		parsed, err := url.Parse(sink.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("log_sinks[%d]: url must be an http or https URL", i)
+		}
+		switch sink.Type {
+		case services.LogSinkLoki:
+			for key := range sink.Labels {
+				if !lokiLabelPattern.MatchString(key) || strings.HasPrefix(key, "__") {
+					return fmt.Errorf("log_sinks[%d]: invalid label name %q", i, key)
+				}
+			}
+		case services.LogSinkHTTP:
+		case services.LogSinkEventHub:
+			if parsed.Scheme != "https" || !strings.HasSuffix(parsed.Host, ".servicebus.windows.net") || strings.Trim(parsed.Path, "/") == "" {
+				return fmt.Errorf("log_sinks[%d]: url must be https://<namespace>.servicebus.windows.net/<event hub>", i)
+			}
+			if sink.SASKeyName == "" || sink.SASKey == "" {
+				return fmt.Errorf("log_sinks[%d]: eventhub sinks require sas_key_name and sas_key", i)
+			}
+		default:
+			return fmt.Errorf("log_sinks[%d]: type must be loki, http or eventhub", i)
+		}
+		if sink.Type != services.LogSinkLoki && len(sink.Labels) > 0 {
+			return fmt.Errorf("log_sinks[%d]: labels are only used by loki sinks", i)
+		}
+		if sink.Type != services.LogSinkEventHub && (sink.SASKeyName != "" || sink.SASKey != "") {
+			return fmt.Errorf("log_sinks[%d]: sas_key_name and sas_key are only used by eventhub sinks", i)
+		}
+		for name, value := range sink.Headers {
+			if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("log_sinks[%d]: invalid header %q", i, name)
+			}
+			if reservedSinkHeaders[http.CanonicalHeaderKey(name)] {
+				return fmt.Errorf("log_sinks[%d]: header %s cannot be set", i, name)
+			}
+		}
+	}
+	return nil
+}
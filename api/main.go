@@ -1,15 +1,29 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
 	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Tools"
+	"sathwikshetty33/Django-vpc/Types"
 )
 
 type DeploymentResponse struct {
@@ -21,80 +35,399 @@ type DeploymentResponse struct {
 }
 
 type DeploymentManager struct {
-	clients    map[string]map[chan services.LogMessage]bool
+	clients    map[string]map[*logClient]bool
 	clientsMux sync.RWMutex
 	deployments map[string]*DeploymentStatus
 	deployMux   sync.RWMutex
+	store       *store.FileStore
+	logs        *store.LogStore
+	requests    *store.RequestStore
+	approvals   map[string]*pendingApproval
+	approvalMux sync.Mutex
+	// sequences holds the last log sequence number of each deployment.
+	sequences map[string]int64
+	seqMux    sync.Mutex
+	// shippers copy the messages of running deployments to their log sinks.
+	shippers map[string]*services.LogShipper
+	shipMux  sync.Mutex
+	// running holds the pipelines that can be cancelled, guarded by
+	// deployMux.
+	running map[string]*runningDeployment
 }
 
+// DeploymentStatus is the live view of a deployment. The embedded record is
+// what gets persisted; Error keeps the original error value for the run.
 type DeploymentStatus struct {
-	ID        string
-	Status    string 
-	StartTime time.Time
-	EndTime   *time.Time
-	Error     error
+	store.Deployment
+	Error error
 }
 
-func NewDeploymentManager() *DeploymentManager {
-	return &DeploymentManager{
-		clients:     make(map[string]map[chan services.LogMessage]bool),
+func NewDeploymentManager(st *store.FileStore, logs *store.LogStore, requests *store.RequestStore) *DeploymentManager {
+	dm := &DeploymentManager{
+		clients:     make(map[string]map[*logClient]bool),
 		deployments: make(map[string]*DeploymentStatus),
+		store:       st,
+		logs:        logs,
+		requests:    requests,
+		approvals:   make(map[string]*pendingApproval),
+		sequences:   make(map[string]int64),
+		shippers:    make(map[string]*services.LogShipper),
+		running:     make(map[string]*runningDeployment),
 	}
+	dm.load()
+	return dm
 }
 
-func (dm *DeploymentManager) AddClient(deploymentID string, client chan services.LogMessage) {
-	dm.clientsMux.Lock()
-	defer dm.clientsMux.Unlock()
-	
-	if dm.clients[deploymentID] == nil {
-		dm.clients[deploymentID] = make(map[chan services.LogMessage]bool)
+// load restores deployment records from the store. Runs that were still in
+// flight when the server stopped can never finish, so they are marked failed.
+func (dm *DeploymentManager) load() {
+	records, err := dm.store.List()
+	if err != nil {
+		log.Printf("Failed to load deployment records: %v", err)
+		return
 	}
-	dm.clients[deploymentID][client] = true
-	
-	log.Printf("Client added for deployment %s. Total clients: %d", deploymentID, len(dm.clients[deploymentID]))
+
+	for _, record := range records {
+		status := &DeploymentStatus{Deployment: *record}
+		if record.Error != "" {
+			status.Error = &types.DeploymentError{
+				Stage:     record.ErrorStage,
+				Code:      record.ErrorCode,
+				Message:   record.Error,
+				Retryable: record.Retryable,
+			}
+		}
+
+		if status.PriorStatus != "" && (status.Status == "redeploying" || status.Status == "rolling_back") {
+			// Only the app update was cut short; the deployment keeps the
+			// outcome of its run.
+			event := store.TimelineRedeployFailed
+			if status.Status == "rolling_back" {
+				event = store.TimelineRollbackFailed
+			}
+			status.Status = status.PriorStatus
+			status.PriorStatus = ""
+			appendTimeline(status, event, "interrupted by server restart")
+			dm.persist(status)
+		} else if isActive(status.Status) {
+			now := time.Now()
+			status.Status = "failed"
+			status.Error = fmt.Errorf("deployment interrupted by server restart")
+			status.ErrorCode = "INTERRUPTED"
+			status.Retryable = true
+			status.EndTime = &now
+			appendTimeline(status, store.TimelineFailed, "interrupted by server restart")
+			dm.persist(status)
+		}
+
+		dm.deployments[status.ID] = status
+	}
+
+	log.Printf("Loaded %d deployment records from store", len(records))
 }
 
-func (dm *DeploymentManager) RemoveClient(deploymentID string, client chan services.LogMessage) {
-	dm.clientsMux.Lock()
-	defer dm.clientsMux.Unlock()
-	
-	if clients, exists := dm.clients[deploymentID]; exists {
-		delete(clients, client)
-		log.Printf("Client removed for deployment %s. Remaining clients: %d", deploymentID, len(clients))
-		
-		
+// persist writes the status to the store. Callers must hold deployMux.
+func (dm *DeploymentManager) persist(status *DeploymentStatus) {
+	record := status.Deployment
+	record.Error = ""
+	if status.Error != nil {
+		record.Error = status.Error.Error()
+	}
+
+	if err := dm.store.Save(&record); err != nil {
+		log.Printf("Failed to persist deployment %s: %v", status.ID, err)
 	}
-	close(client)
 }
 
-func (dm *DeploymentManager) BroadcastLog(deploymentID string, logMsg services.LogMessage) {
-	dm.clientsMux.RLock()
-	clients := dm.clients[deploymentID]
-	clientCount := 0
-	if clients != nil {
-		clientCount = len(clients)
+// trackServices records the service status a deployment reports at the end
+// of its run.
+func (dm *DeploymentManager) trackServices(deploymentID string, logMsg types.LogMessage) {
+	if logMsg.Code != services.EventServicesStatus {
+		return
 	}
-	dm.clientsMux.RUnlock()
-	
-	log.Printf("Broadcasting log for deployment %s: %s - %s (clients: %d)", deploymentID, logMsg.Level, logMsg.Message, clientCount)
-	
-	if clients != nil && len(clients) > 0 {
-		dm.clientsMux.RLock()
-		for client := range clients {
-			select {
-			case client <- logMsg:
-			default:
-				log.Printf("Failed to send log to client for deployment %s", deploymentID)
+	statuses, ok := logMsg.Data["services"].([]store.ServiceStatus)
+	if !ok {
+		return
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.Services = statuses
+		dm.persist(deployment)
+	}
+}
+
+// trackRegion records the region a deployment fell back to when its own
+// had no capacity.
+func (dm *DeploymentManager) trackRegion(deploymentID string, logMsg types.LogMessage) {
+	if logMsg.Code != services.EventRegionFallback {
+		return
+	}
+	location, ok := logMsg.Data["location"].(string)
+	if !ok || location == "" {
+		return
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.Location = location
+		dm.persist(deployment)
+	}
+}
+
+// maxRevisions caps the revisions kept on a deployment; the oldest are
+// dropped.
+const maxRevisions = 50
+
+// trackRevision records the commit a deploy, redeploy or rollback put the
+// deployment's app at.
+func (dm *DeploymentManager) trackRevision(deploymentID string, logMsg types.LogMessage) {
+	if logMsg.Code != services.EventAppRevision {
+		return
+	}
+	commit, ok := logMsg.Data["commit"].(string)
+	if !ok || commit == "" {
+		return
+	}
+	action, _ := logMsg.Data["action"].(string)
+	image, _ := logMsg.Data["image"].(string)
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		if image != "" {
+			deployment.Image = image
+		}
+		deployment.Revisions = append(deployment.Revisions, store.Revision{Commit: commit, Action: action, Image: image, Time: time.Now()})
+		if excess := len(deployment.Revisions) - maxRevisions; excess > 0 {
+			deployment.Revisions = deployment.Revisions[excess:]
+		}
+		dm.persist(deployment)
+	}
+}
+
+// trackHostKey records the host key pinned for a deployment's server.
+func (dm *DeploymentManager) trackHostKey(deploymentID string, logMsg types.LogMessage) {
+	if logMsg.Code != services.EventHostKeyPinned {
+		return
+	}
+	fingerprint, ok := logMsg.Data["fingerprint"].(string)
+	if !ok || fingerprint == "" {
+		return
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.HostKey = fingerprint
+		dm.persist(deployment)
+	}
+}
+
+// trackImage records the container image a Kubernetes deployment built.
+func (dm *DeploymentManager) trackImage(deploymentID string, logMsg types.LogMessage) {
+	if logMsg.Code != services.EventImageBuilt {
+		return
+	}
+	image, ok := logMsg.Data["image"].(string)
+	if !ok || image == "" {
+		return
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.Image = image
+		dm.persist(deployment)
+	}
+}
+
+// trackStep closes the current step timing and opens a new one whenever the
+// pipeline moves on to a different step.
+func (dm *DeploymentManager) trackStep(deploymentID, step string) {
+	switch step {
+	case "", "system", "heartbeat", "connection", "error", "completed":
+		return
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists || deployment.Status != "running" {
+		return
+	}
+
+	now := time.Now()
+	if n := len(deployment.Steps); n > 0 {
+		current := &deployment.Steps[n-1]
+		if current.Name == step && current.End == nil {
+			return
+		}
+		if current.End == nil {
+			current.End = &now
+		}
+	}
+	deployment.Steps = append(deployment.Steps, store.StepTiming{Name: step, Start: now})
+	dm.persist(deployment)
+}
+
+// ListDeployments returns a copy of every known deployment status.
+func (dm *DeploymentManager) ListDeployments() []DeploymentStatus {
+	dm.deployMux.RLock()
+	defer dm.deployMux.RUnlock()
+
+	deployments := make([]DeploymentStatus, 0, len(dm.deployments))
+	for _, deployment := range dm.deployments {
+		deployments = append(deployments, *deployment)
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].StartTime.Before(deployments[j].StartTime)
+	})
+	return deployments
+}
+
+// ForceFail marks a queued or running deployment as failed regardless of
+// what its pipeline is doing, and cancels the pipeline, so its Terraform
+// or Ansible command is interrupted and its worker freed. runDeployment
+// discards the result of a pipeline that was force-failed.
+func (dm *DeploymentManager) ForceFail(deploymentID, reason string) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return errDeploymentNotFound
+	}
+	if !isActive(deployment.Status) {
+		return fmt.Errorf("deployment is already %s", deployment.Status)
+	}
+
+	now := time.Now()
+	deployment.Status = "failed"
+	deployment.Error = fmt.Errorf("%s", reason)
+	deployment.ErrorCode = "FORCE_FAILED"
+	deployment.EndTime = &now
+	if n := len(deployment.Steps); n > 0 && deployment.Steps[n-1].End == nil {
+		deployment.Steps[n-1].End = &now
+	}
+	appendTimeline(deployment, store.TimelineFailed, reason)
+	dm.persist(deployment)
+	if run, running := dm.running[deploymentID]; running {
+		run.cancel()
+	}
+	return nil
+}
+
+// Purge removes a finished deployment from memory and the store, along with
+// its Terraform directory and state, so its infrastructure must have been
+// destroyed first, unless released says it was handed over to be managed
+// elsewhere.
+func (dm *DeploymentManager) Purge(deploymentID string, released bool) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return errDeploymentNotFound
+	}
+	if isActive(deployment.Status) {
+		return fmt.Errorf("deployment is still %s, force-fail it first", deployment.Status)
+	}
+	if holdsInfrastructure(deployment) && !released {
+		return errHoldsInfrastructure
+	}
+
+	if err := dm.store.Delete(deploymentID); err != nil {
+		return err
+	}
+	if err := dm.logs.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete logs for deployment %s: %v", deploymentID, err)
+	}
+	dm.forgetSequence(deploymentID)
+	if err := dm.requests.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete request for deployment %s: %v", deploymentID, err)
+	}
+	if err := healthStore.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete health checks for deployment %s: %v", deploymentID, err)
+	}
+	if err := stateStore.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete Terraform state for deployment %s: %v", deploymentID, err)
+	}
+	if err := vulnStore.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete vulnerability scan for deployment %s: %v", deploymentID, err)
+	}
+	if err := metricsStore.Delete(deploymentID); err != nil {
+		log.Printf("Failed to delete metrics for deployment %s: %v", deploymentID, err)
+	}
+	advisor.forget(deploymentID)
+	if err := removeWorkspace(deployment); err != nil {
+		log.Printf("Failed to delete Terraform directory for deployment %s: %v", deploymentID, err)
+	}
+	delete(dm.deployments, deploymentID)
+	return nil
+}
+
+func (dm *DeploymentManager) BroadcastLog(deploymentID string, logMsg types.LogMessage) {
+	dm.trackStep(deploymentID, logMsg.Step)
+	dm.trackServices(deploymentID, logMsg)
+	dm.trackImage(deploymentID, logMsg)
+	dm.trackRegion(deploymentID, logMsg)
+	dm.trackRevision(deploymentID, logMsg)
+	dm.trackHostKey(deploymentID, logMsg)
+	dm.trackTimeline(deploymentID, logMsg)
+
+	// Numbering and persisting under one lock keeps the log file in
+	// sequence order. Numbering resumes from the persisted log after a
+	// restart.
+	dm.seqMux.Lock()
+	sequence, known := dm.sequences[deploymentID]
+	if !known {
+		count, err := dm.logs.Count(deploymentID)
+		if err != nil {
+			log.Printf("Failed to count logs for deployment %s: %v", deploymentID, err)
+		}
+		sequence = count
+	}
+	sequence++
+	dm.sequences[deploymentID] = sequence
+	logMsg.DeploymentID = deploymentID
+	logMsg.Sequence = sequence
+	if err := dm.logs.Append(deploymentID, logMsg); err != nil {
+		log.Printf("Failed to persist log for deployment %s: %v", deploymentID, err)
+	}
+	dm.seqMux.Unlock()
+	dm.ship(deploymentID, logMsg)
+
+	clients := dm.clientsFor(deploymentID)
+
+	log.Printf("Broadcasting log for deployment %s: %s - %s (clients: %d)", deploymentID, logMsg.Level, logMsg.Message, len(clients))
+
+	if len(clients) > 0 {
+		for _, client := range clients {
+			if client.enqueue(logMsg) {
+				log.Printf("Client queue full for deployment %s, dropped oldest log", deploymentID)
 			}
 		}
-		dm.clientsMux.RUnlock()
 		log.Printf("Log broadcasted to %d clients for deployment %s", len(clients), deploymentID)
 	} else {
 		log.Printf("No active clients for deployment %s - storing log for potential reconnection", deploymentID)
-		
 	}
 }
 
+func (dm *DeploymentManager) forgetSequence(deploymentID string) {
+	dm.seqMux.Lock()
+	delete(dm.sequences, deploymentID)
+	dm.seqMux.Unlock()
+}
+
 func (dm *DeploymentManager) SetDeploymentStatus(deploymentID, status string, err error) {
 	dm.deployMux.Lock()
 	defer dm.deployMux.Unlock()
@@ -102,11 +435,72 @@ func (dm *DeploymentManager) SetDeploymentStatus(deploymentID, status string, er
 	if deployment, exists := dm.deployments[deploymentID]; exists {
 		deployment.Status = status
 		deployment.Error = err
-		if status == "completed" || status == "failed" {
+		if status == "completed" || status == "failed" || status == "cancelled" {
 			now := time.Now()
 			deployment.EndTime = &now
+			if n := len(deployment.Steps); n > 0 {
+				current := &deployment.Steps[n-1]
+				if current.End == nil {
+					current.End = &now
+				}
+			}
+			switch status {
+			case "failed":
+				var derr *types.DeploymentError
+				if errors.As(err, &derr) {
+					deployment.ErrorCode = derr.Code
+					deployment.ErrorStage = derr.Stage
+					deployment.Retryable = derr.Retryable
+				} else {
+					deployment.ErrorCode = failureCode(deployment.Steps)
+				}
+				appendTimeline(deployment, store.TimelineFailed, deployment.ErrorCode)
+			case "cancelled":
+				detail := ""
+				if err != nil {
+					detail = "destroy failed"
+				}
+				appendTimeline(deployment, store.TimelineCancelled, detail)
+			default:
+				detail := ""
+				if deployment.PublicIP != "" {
+					detail = "serving at " + deployment.PublicIP
+				}
+				appendTimeline(deployment, store.TimelineCompleted, detail)
+			}
+		}
+		if status == "destroyed" {
+			appendTimeline(deployment, store.TimelineDestroyed, "")
+		}
+		dm.persist(deployment)
+	}
+}
+
+// SetPublicIP records the address a finished deployment is served on.
+func (dm *DeploymentManager) SetPublicIP(deploymentID, publicIP string) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.PublicIP = publicIP
+		dm.persist(deployment)
+	}
+}
+
+// isActive reports whether a deployment's pipeline may still be running.
+func isActive(status string) bool {
+	return status == "queued" || status == "running" || status == "pending_approval" || status == "destroying" || status == "redeploying" || status == "rolling_back"
+}
+
+// failureCode derives an error code from the step that was running when the
+// deployment failed, ignoring the deferred cleanup step.
+func failureCode(steps []store.StepTiming) string {
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].Name != "cleanup" {
+			return strings.ToUpper(steps[i].Name) + "_FAILED"
 		}
 	}
+	return "UNKNOWN_FAILED"
 }
 
 func (dm *DeploymentManager) GetDeploymentStatus(deploymentID string) *DeploymentStatus {
@@ -116,25 +510,251 @@ func (dm *DeploymentManager) GetDeploymentStatus(deploymentID string) *Deploymen
 	return dm.deployments[deploymentID]
 }
 
-func (dm *DeploymentManager) CreateDeployment(deploymentID string) {
+func (dm *DeploymentManager) CreateDeployment(deploymentID string, req *services.DeploymentRequest) {
 	dm.deployMux.Lock()
 	defer dm.deployMux.Unlock()
 	
-	dm.deployments[deploymentID] = &DeploymentStatus{
-		ID:        deploymentID,
-		Status:    "running",
-		StartTime: time.Now(),
+	// Pooled deployments share the pool VM's resource group, which must not
+	// be snapshotted or destroyed on behalf of a single app. Other clouds
+	// have no resource groups.
+	resourceGroup, autoShutdown, location := "", "", ""
+	if !req.Pooled && services.Cloud(req) == services.CloudAzure {
+		resourceGroup, _ = services.ResourceGroupName(req)
+		autoShutdown = services.AutoShutdownSummary(req)
+		location = services.Location(req)
+	}
+	// An app-only deployment runs in its infra-only deployment's resources.
+	mode := ""
+	if services.Mode(req) != services.ModeFull {
+		mode = services.Mode(req)
+	}
+	if infra, exists := dm.deployments[req.InfraDeploymentID]; exists && mode == services.ModeAppOnly {
+		resourceGroup, autoShutdown, location = infra.ResourceGroup, infra.AutoShutdown, infra.Location
+	}
+
+	deployment := &DeploymentStatus{Deployment: store.Deployment{
+		ID:            deploymentID,
+		Username:      req.Username,
+		Organization:  req.Organization,
+		Team:          req.Team,
+		RepoURL:       req.RepoURL,
+		ResourceGroup: resourceGroup,
+		Location:      location,
+		AutoShutdown:  autoShutdown,
+		Mode:          mode,
+		Status:        "queued",
+		StartTime:     time.Now(),
+	}}
+	appendTimeline(deployment, store.TimelineCreated, "")
+	dm.deployments[deploymentID] = deployment
+	dm.persist(deployment)
+
+	if err := dm.requests.Save(deploymentID, req); err != nil {
+		log.Printf("Failed to persist request for deployment %s: %v", deploymentID, err)
+	}
+}
+
+// Request returns the request a deployment was created from.
+func (dm *DeploymentManager) Request(deploymentID string) (*services.DeploymentRequest, error) {
+	var req services.DeploymentRequest
+	if err := dm.requests.Get(deploymentID, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+var errDeploymentNotFound = errors.New("deployment not found")
+
+var (
+	deploymentManager *DeploymentManager
+	deploymentQueue   *DeploymentQueue
+	vmPool            *services.VMPool
+)
+
+func dataDir() string {
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+func toolsDir() string {
+	if dir := os.Getenv("TOOLS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(dataDir(), "tools")
+}
+
+// bootstrapTools installs the pinned Terraform and Ansible versions unless
+// TOOLS_BOOTSTRAP=false. Failures fall back to whatever is on PATH.
+func bootstrapTools() {
+	if os.Getenv("TOOLS_BOOTSTRAP") == "false" {
+		return
+	}
+
+	cfg := tools.ConfigFromEnv(toolsDir())
+	cfg.SkipAnsible = services.AnsibleRunsInContainer()
+	manifest, err := tools.Bootstrap(cfg)
+	if err != nil {
+		log.Printf("Warning: tool bootstrap failed, using host installation: %v", err)
+	}
+	toolManifest = manifest
+}
+
+// setupPluginCache shares one Terraform provider plugin cache between
+// deployments, in TF_PLUGIN_CACHE_DIR or under the tools directory, unless
+// TERRAFORM_PLUGIN_CACHE=false. TERRAFORM_PROVIDER_MIRROR names a
+// pre-populated provider mirror to install from first.
+func setupPluginCache() {
+	if os.Getenv("TERRAFORM_PLUGIN_CACHE") == "false" {
+		return
+	}
+
+	dir := os.Getenv("TF_PLUGIN_CACHE_DIR")
+	if dir == "" {
+		dir = filepath.Join(toolsDir(), "terraform-plugins")
+	}
+	if err := providers.ConfigurePluginCache(dir, os.Getenv("TERRAFORM_PROVIDER_MIRROR")); err != nil {
+		log.Printf("Warning: Terraform plugin cache disabled: %v", err)
+		return
 	}
+	log.Printf("Caching Terraform providers in %s", dir)
 }
 
-var deploymentManager = NewDeploymentManager()
+func storeDir() string {
+	if dir := os.Getenv("DEPLOYMENT_STORE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(dataDir(), "deployments")
+}
 
 func main() {
+	loadServerSettings()
+	deploymentStore, err := store.NewFileStore(storeDir())
+	if err != nil {
+		log.Fatalf("Failed to open deployment store: %v", err)
+	}
+	logStore, err := store.NewLogStore(filepath.Join(dataDir(), "logs"))
+	if err != nil {
+		log.Fatalf("Failed to open log store: %v", err)
+	}
+	userStore, err = store.NewUserStore(filepath.Join(dataDir(), "users"))
+	if err != nil {
+		log.Fatalf("Failed to open user store: %v", err)
+	}
+	sessionStore, err = store.NewSessionStore(filepath.Join(dataDir(), "sessions"))
+	if err != nil {
+		log.Fatalf("Failed to open session store: %v", err)
+	}
+	if err := setupIdentityProviders(); err != nil {
+		log.Fatalf("Invalid single sign-on configuration: %v", err)
+	}
+	if err := setupStreamTokens(); err != nil {
+		log.Fatalf("Failed to set up stream tokens: %v", err)
+	}
+	bootstrapTools()
+	setupPluginCache()
+	isolation, err := providers.WorkspaceIsolation()
+	if err != nil {
+		log.Fatalf("Invalid workspace isolation: %v", err)
+	}
+	if isolation != "" {
+		log.Printf("Running Terraform, Ansible and hook commands in %s containers", isolation)
+	}
+	if backend := strings.ToLower(os.Getenv("AZURE_BACKEND")); backend != "" && !providers.ValidAzureBackend(backend) {
+		log.Fatalf("Invalid AZURE_BACKEND %q (expected %s, %s or %s)", backend, providers.AzureBackendTerraform, providers.AzureBackendSDK, providers.AzureBackendPulumi)
+	}
+	log.Printf("Provisioning Azure VMs with the %s backend", providers.AzureBackendFromEnv())
+	if providers.AzureBackendFromEnv() == providers.AzureBackendPulumi {
+		if !providers.PulumiBackendBuilt {
+			log.Fatalf("AZURE_BACKEND=pulumi but this server was built without the pulumi backend; rebuild it with -tags pulumi")
+		}
+		if isolation != "" {
+			log.Fatalf("AZURE_BACKEND=pulumi runs its program in the server's process and cannot be combined with WORKSPACE_ISOLATION")
+		}
+		if _, err := exec.LookPath("pulumi"); err != nil {
+			log.Printf("Warning: AZURE_BACKEND=pulumi but the pulumi CLI is not on PATH; Azure deployments will fail")
+		}
+	}
+	for _, location := range strings.Split(os.Getenv("AZURE_FALLBACK_LOCATIONS"), ",") {
+		if location = strings.TrimSpace(location); location != "" && !providers.IsKnownLocation(location) {
+			log.Printf("Warning: ignoring unknown location %q in AZURE_FALLBACK_LOCATIONS", location)
+		}
+	}
+	requestStore, err := store.NewRequestStore(filepath.Join(dataDir(), "requests"))
+	if err != nil {
+		log.Fatalf("Failed to open request store: %v", err)
+	}
+	orgStore, err = store.NewRequestStore(filepath.Join(dataDir(), "orgs"))
+	if err != nil {
+		log.Fatalf("Failed to open organization store: %v", err)
+	}
+	stateStore, err = store.NewRequestStore(filepath.Join(dataDir(), "states"))
+	if err != nil {
+		log.Fatalf("Failed to open state store: %v", err)
+	}
+	if encoded := os.Getenv("REQUEST_STORE_KEY"); encoded != "" {
+		// Requests carry tokens and env values, organizations cloud
+		// credentials and Terraform states SSH keys; a base64 32-byte key
+		// encrypts them at rest.
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil {
+			err = requestStore.SetKey(key)
+		}
+		if err == nil {
+			err = orgStore.SetKey(key)
+		}
+		if err == nil {
+			err = stateStore.SetKey(key)
+		}
+		if err != nil {
+			log.Fatalf("Invalid REQUEST_STORE_KEY: %v", err)
+		}
+	} else {
+		log.Printf("Warning: REQUEST_STORE_KEY is not set; stored requests are not encrypted")
+	}
+	if err := providers.AzureCredentialsFromEnv().Validate(); err != nil {
+		log.Fatalf("Invalid Azure credentials in AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET and AZURE_USE_MSI: %v", err)
+	}
+	log.Printf("Authenticating to Azure with %s credentials", providers.AzureCredentialsFromEnv().Mode())
+	backupStore, err = store.NewBackupStore(filepath.Join(dataDir(), "backups"))
+	if err != nil {
+		log.Fatalf("Failed to open backup store: %v", err)
+	}
+	healthStore, err = store.NewHealthStore(filepath.Join(dataDir(), "health"))
+	if err != nil {
+		log.Fatalf("Failed to open health store: %v", err)
+	}
+	vulnStore, err = store.NewVulnerabilityStore(filepath.Join(dataDir(), "vulnerabilities"))
+	if err != nil {
+		log.Fatalf("Failed to open vulnerability store: %v", err)
+	}
+	metricsStore, err = store.NewMetricsStore(filepath.Join(dataDir(), "metrics"))
+	if err != nil {
+		log.Fatalf("Failed to open metrics store: %v", err)
+	}
+	advisor = newCapacityAdvisor(capacityAlertWindow())
+	configStore, err = store.NewRequestStore(filepath.Join(dataDir(), "configs"))
+	if err != nil {
+		log.Fatalf("Failed to open config store: %v", err)
+	}
+	artifactStore, err = newArtifactStore()
+	if err != nil {
+		log.Fatalf("Failed to open artifact store: %v", err)
+	}
+	poolStore, err := store.NewPoolStore(filepath.Join(dataDir(), "pool"))
+	if err != nil {
+		log.Fatalf("Failed to open pool store: %v", err)
+	}
+	vmPool = services.NewVMPool(poolStore, filepath.Join(dataDir(), "pool"))
+	deploymentManager = NewDeploymentManager(deploymentStore, logStore, requestStore)
+	deploymentQueue = NewDeploymentQueue(queueWorkers(), queueAging(), runDeployment)
+
 	r := gin.Default()
 
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		
 		if c.Request.Method == "OPTIONS" {
@@ -146,8 +766,98 @@ func main() {
 	})
 
 	r.POST("/deploy", handleDeployment)
+	r.POST("/deploy/preflight", handlePreflight)
 	r.GET("/deploy/:deploymentId/logs", handleLogStream)
+	r.POST("/deploy/:deploymentId/stream-token", handleStreamToken)
+	r.GET("/deploy/:deploymentId/logs/download", handleLogDownload)
 	r.GET("/deploy/:deploymentId/status", handleDeploymentStatus)
+	r.DELETE("/deploy/:deploymentId", handleDestroyDeployment)
+	r.POST("/deploy/:deploymentId/cancel", handleCancelDeployment)
+	r.POST("/deploy/:deploymentId/redeploy", handleRedeployDeployment)
+	r.POST("/deploy/:deploymentId/rollback", handleRollbackDeployment)
+	r.DELETE("/deploy/:deploymentId/host-key", handleForgetHostKey)
+	r.GET("/deploy/:deploymentId/cost", handleDeploymentCost)
+	r.GET("/deploy/:deploymentId/plan", handleDeploymentPlan)
+	r.POST("/deploy/:deploymentId/approve", handleApproveDeployment)
+	r.POST("/deploy/:deploymentId/reject", handleRejectDeployment)
+	r.POST("/deploy/:deploymentId/snapshot", handleCreateSnapshot)
+	r.GET("/deploy/:deploymentId/snapshots", handleListSnapshots)
+	r.POST("/deploy/:deploymentId/snapshots/:snapshot/restore", handleRestoreSnapshot)
+	r.POST("/deploy/:deploymentId/backup", handleCreateBackup)
+	r.POST("/deploy/:deploymentId/backups/:backupId/verify", handleVerifyBackup)
+	r.POST("/deploy/:deploymentId/clone", handleCloneDeployment)
+	r.GET("/deploy/:deploymentId/uptime", handleDeploymentUptime)
+	r.GET("/deploy/:deploymentId/metrics", handleDeploymentMetrics)
+	r.POST("/deploy/:deploymentId/hosts", handleReconcileHosts)
+	r.PATCH("/deploy/:deploymentId/env", handleUpdateEnv)
+	r.POST("/deploy/:deploymentId/resize", handleResizeVM)
+	r.GET("/deploy/:deploymentId/domains", handleDomainStatus)
+	r.GET("/deploy/:deploymentId/upgrade-plan", handleUpgradePlan)
+	r.GET("/deploy/:deploymentId/config", handleDeploymentConfig)
+	r.GET("/deploy/:deploymentId/config/diff", handleConfigDiff)
+	r.GET("/deploy/:deploymentId/drift", handleDeploymentDrift)
+	r.GET("/deploy/:deploymentId/export", handleExportDeployment)
+	r.GET("/deploy/:deploymentId/vulnerabilities", handleGetVulnerabilities)
+	r.POST("/deploy/:deploymentId/vulnerabilities/scan", handleScanVulnerabilities)
+	r.GET("/backups", handleListBackups)
+	r.GET("/backups/:backupId", handleGetBackup)
+	r.POST("/backups/:backupId/restore", handleRestoreBackup)
+	r.GET("/stats", handleStats)
+
+	admin := r.Group("/admin", requireAdmin())
+	admin.GET("/deployments", handleAdminListDeployments)
+	admin.POST("/deployments/:deploymentId/fail", handleAdminForceFail)
+	admin.DELETE("/deployments/:deploymentId/clients", handleAdminKickClients)
+	admin.DELETE("/deployments/:deploymentId", handleAdminPurgeDeployment)
+	admin.GET("/queue", handleAdminQueue)
+	admin.GET("/locks", handleAdminLocks)
+	admin.GET("/pool", handleAdminPool)
+	admin.GET("/workspace", handleAdminWorkspace)
+	admin.POST("/workspace/cleanup", handleAdminWorkspaceCleanup)
+	admin.GET("/archive/logs/:deploymentId", handleAdminArchivedLog)
+	admin.GET("/config/export", handleExportServerConfig)
+	admin.POST("/config/import", handleImportServerConfig)
+
+	r.GET("/deployments", handleListDeployments)
+	r.DELETE("/deployments/:deploymentId", handleArchiveDeployment)
+
+	r.POST("/users/register", handleRegisterUser)
+	r.GET("/users/verify", handleVerifyEmail)
+
+	r.GET("/auth/providers", handleListIdentityProviders)
+	r.GET("/auth/:provider/login", handleSSOLogin)
+	r.GET("/auth/:provider/callback", handleSSOCallback)
+	r.GET("/auth/session", handleGetSession)
+	r.POST("/auth/logout", handleLogout)
+
+	r.POST("/orgs", handleCreateOrganization)
+	org := r.Group("/orgs/:org")
+	org.GET("", requireOrgMember(false), handleGetOrganization)
+	org.GET("/teams/:team/deployments", requireOrgMember(false), handleTeamDeployments)
+	orgAdmin := org.Group("", requireOrgMember(true))
+	orgAdmin.PUT("/members/:username", handleSetOrgMember)
+	orgAdmin.DELETE("/members/:username", handleRemoveOrgMember)
+	orgAdmin.PUT("/credentials", handleSetOrgCredentials)
+	orgAdmin.DELETE("/credentials", handleDeleteOrgCredentials)
+	orgAdmin.PUT("/on-failure", handleSetOrgFailureHooks)
+	orgAdmin.PUT("/teams/:team", handleSetTeam)
+	orgAdmin.DELETE("/teams/:team", handleDeleteTeam)
+	orgAdmin.PUT("/teams/:team/members/:username", handleAddTeamMember)
+	orgAdmin.DELETE("/teams/:team/members/:username", handleRemoveTeamMember)
+
+	registerUI(r)
+
+	go runArchivePurger(archiveRetention(), time.Hour)
+	go runWorkspaceJanitor(time.Hour)
+	go runSessionPurger(time.Hour)
+	go runHealthMonitor(newHealthMonitor(), healthCheckInterval())
+	if interval := vulnScanInterval(); interval > 0 {
+		go runVulnerabilityScanner(interval)
+	}
+	if interval := metricsInterval(); interval > 0 {
+		go runMetricsCollector(interval, metricsRetention())
+	}
+	r.GET("/about", handleAbout)
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "healthy", "timestamp": time.Now().Format(time.RFC3339)})
 	})
@@ -159,7 +869,11 @@ func main() {
 func handleDeployment(c *gin.Context) {
 	var req services.DeploymentRequest
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindDeploymentRequest(c, &req); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return
+		}
 		c.JSON(http.StatusBadRequest, DeploymentResponse{
 			Success:   false,
 			Error:     fmt.Sprintf("Invalid request body: %v", err),
@@ -168,7 +882,7 @@ func handleDeployment(c *gin.Context) {
 		return
 	}
 
-	if err := validateRequest(&req); err != nil {
+	if _, err := applyProjectSpec(&req); err != nil {
 		c.JSON(http.StatusBadRequest, DeploymentResponse{
 			Success:   false,
 			Error:     err.Error(),
@@ -177,63 +891,246 @@ func handleDeployment(c *gin.Context) {
 		return
 	}
 
-	deploymentID := fmt.Sprintf("%s-%s-%d", req.Username, time.Now().Format("20060102-150405"), time.Now().Unix())
-	
-	log.Printf("Starting deployment with ID: %s", deploymentID)
-	
-	deploymentManager.CreateDeployment(deploymentID)
-	
-	go func() {
-		logFunc := func(level, message, step string) {
-			logMsg := services.LogMessage{
-				Level:     level,
-				Message:   message,
-				Timestamp: time.Now().Format(time.RFC3339),
-				Step:      step,
-			}
-			
-			log.Printf("[%s] %s: %s", level, step, message)
-			
-			deploymentManager.BroadcastLog(deploymentID, logMsg)
-		}
-		
-		logFunc("info", "Starting deployment...", "initialization")
-		
-		deploymentService := services.NewDeploymentService()
-		
-		deploymentManager.SetDeploymentStatus(deploymentID, "running", nil)
-		
-		publicIP, err := deploymentService.Deploy(&req, deploymentID, deploymentManager)
-		
-		if err != nil {
-			logFunc("error", fmt.Sprintf("Deployment failed: %v", err), "error")
-			deploymentManager.SetDeploymentStatus(deploymentID, "failed", err)
-		} else {
-			logFunc("success", fmt.Sprintf("Deployment completed successfully! Public IP: %s", publicIP), "completed")
-			deploymentManager.SetDeploymentStatus(deploymentID, "completed", nil)
+	if !admitDeployment(c, &req) {
+		return
+	}
+
+	deploymentID := startDeployment(&req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"message":       "Deployment queued",
+		"deployment_id": deploymentID,
+		"timestamp":     time.Now().Format(time.RFC3339),
+	})
+}
+
+// admitDeployment runs a new deployment request through validation, team
+// authorization, quotas and the budget, responding and returning false if
+// it is turned down. New deployments and clones go through it.
+func admitDeployment(c *gin.Context, req *services.DeploymentRequest) bool {
+	if err := validateRequest(req); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return false
 		}
-		
-		deploymentManager.BroadcastLog(deploymentID, services.LogMessage{
-			Level:     "system",
-			Message:   "DEPLOYMENT_COMPLETE",
+		c.JSON(http.StatusBadRequest, DeploymentResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+
+	if status, err := authorizeTeamDeployment(c, req); err != nil {
+		c.JSON(status, DeploymentResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+
+	if !admitPriority(c, req) {
+		c.JSON(http.StatusForbidden, DeploymentResponse{
+			Success:   false,
+			Error:     "Only an admin or a team may deploy with high priority",
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+
+	defaultSSHAllowlist(c, req)
+
+	if missing := services.MissingEnv(req, req.RequiredEnv); len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":     false,
+			"error":       services.MissingEnvError(missing).Error(),
+			"missing_env": missing,
+			"timestamp":   time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+
+	// An app-only deployment runs on another deployment's VM with its SSH
+	// key, so the caller must be one who may change that deployment, not
+	// just name its owner.
+	if services.Mode(req) == services.ModeAppOnly {
+		if infra := deploymentManager.GetDeploymentStatus(req.InfraDeploymentID); infra != nil && !authorizeDeploymentOwner(c, infra) {
+			return false
+		}
+	}
+
+	if err := checkInfrastructure(req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, DeploymentResponse{
+			Success:   false,
+			Error:     err.Error(),
 			Timestamp: time.Now().Format(time.RFC3339),
-			Step:      "system",
 		})
+		return false
+	}
+
+	if err := checkTeamQuota(req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, DeploymentResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+
+	if estimate, err := checkBudget(c, req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success":   false,
+			"error":     err.Error(),
+			"estimate":  estimate,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+		return false
+	}
+	return true
+}
+
+// admitPriority reports whether the caller may have the request run at its
+// priority. High priority is kept for admins and team deployments, whose
+// membership authorizeTeamDeployment has checked; anyone else asking for it
+// is refused, and a production environment alone runs them at normal.
+func admitPriority(c *gin.Context, req *services.DeploymentRequest) bool {
+	if services.Priority(req) != services.PriorityHigh || isAdminRequest(c) || req.Organization != "" {
+		return true
+	}
+	if req.Priority == services.PriorityHigh {
+		return false
+	}
+	req.Priority = services.PriorityNormal
+	return true
+}
+
+// defaultSSHAllowlist limits SSH to a new Azure VM to the caller's address
+// unless the request sets ssh_allowed_cidrs. Callers on this server's own
+// network, like the bundled UI, have no public address to allow, so only
+// this server is.
+func defaultSSHAllowlist(c *gin.Context, req *services.DeploymentRequest) {
+	if services.Cloud(req) != services.CloudAzure || req.Pooled || len(req.SSHAllowedCIDRs) > 0 {
+		return
+	}
+	if ip := net.ParseIP(c.ClientIP()); ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+		req.SSHAllowedCIDRs = []string{services.HostCIDR(ip.String())}
+		return
+	}
+	if address, err := services.ControllerAddress(); err == nil {
+		req.SSHAllowedCIDRs = []string{services.HostCIDR(address)}
+	}
+}
+
+// startDeployment records a new deployment for req and queues it. An
+// Azure deployment, clones and restores included, is provisioned with the
+// backend chosen now, which is recorded in its request.
+func startDeployment(req *services.DeploymentRequest) string {
+	req.AzureBackend = ""
+	if services.Cloud(req) == services.CloudAzure && !req.Pooled {
+		req.AzureBackend = providers.AzureBackendFromEnv()
+	}
+	now := time.Now()
+	deploymentID := fmt.Sprintf("%s-%s-%d", req.Username, now.Format("20060102-150405"), now.UnixNano())
+
+	log.Printf("Starting deployment with ID: %s", deploymentID)
+
+	deploymentManager.CreateDeployment(deploymentID, req)
+	deploymentQueue.Enqueue(&deploymentJob{
+		ID:       deploymentID,
+		Request:  req,
+		Username: req.Username,
+		RepoURL:  req.RepoURL,
+		Priority: services.Priority(req),
+	})
+	deploymentManager.RecordTimeline(deploymentID, store.TimelineQueued, "")
+	return deploymentID
+}
+
+func runDeployment(job *deploymentJob) {
+	deploymentID := job.ID
+
+	logFunc := func(level, code, message, step string, data map[string]interface{}) {
+		logMsg := types.LogMessage{
+			Level:     level,
+			Message:   message,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      step,
+			Code:      code,
+			Data:      data,
+		}
 		
-		log.Printf("Deployment %s completed", deploymentID)
+		log.Printf("[%s] %s: %s", level, step, message)
 		
-		time.Sleep(2 * time.Second)
-		deploymentManager.clientsMux.Lock()
-		delete(deploymentManager.clients, deploymentID)
-		deploymentManager.clientsMux.Unlock()
-	}()
+		deploymentManager.BroadcastLog(deploymentID, logMsg)
+	}
+	
+	ctx, ok := deploymentManager.BeginRun(deploymentID)
+	if !ok {
+		log.Printf("Deployment %s is no longer queued, skipping", deploymentID)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":       true,
-		"message":       "Deployment started",
-		"deployment_id": deploymentID,
-		"timestamp":     time.Now().Format(time.RFC3339),
+	deploymentService := services.NewDeploymentService()
+	deploymentService.SetApprovalGate(deploymentManager)
+	deploymentService.SetPool(vmPool)
+	deploymentService.SetConfigRecorder(deploymentManager)
+	deploymentService.SetInfrastructureSource(deploymentManager)
+
+	if len(job.Request.LogSinks) > 0 {
+		deploymentManager.StartShipping(deploymentID, job.Request.LogSinks)
+		defer deploymentManager.StopShipping(deploymentID)
+	}
+
+	logFunc("info", services.EventDeploymentStarted, "Starting deployment...", "initialization", nil)
+	
+	publicIP, err := deploymentService.Deploy(ctx, withOrgFailureHooks(job.Request), deploymentID, deploymentManager)
+	run := deploymentManager.EndRun(deploymentID)
+
+	if status := deploymentManager.GetDeploymentStatus(deploymentID); status == nil || status.Status != "running" {
+		log.Printf("Deployment %s was finalized while running, discarding result", deploymentID)
+		return
+	}
+
+	if services.Cancelled(err) {
+		logFunc("warn", services.EventDeploymentCancelled, "Deployment cancelled", "cancelled", nil)
+		var destroyErr error
+		if run != nil && run.destroy {
+			destroyErr = destroyCancelled(deploymentID, job.Request)
+		}
+		deploymentManager.SetDeploymentStatus(deploymentID, "cancelled", destroyErr)
+	} else if err != nil {
+		derr := types.AsDeploymentError(err, "deploy")
+		logFunc("error", services.EventDeploymentFailed, fmt.Sprintf("Deployment failed: %v", err), "error",
+			map[string]interface{}{
+				"error":     err.Error(),
+				"stage":     derr.Stage,
+				"code":      derr.Code,
+				"retryable": derr.Retryable,
+			})
+		deploymentManager.SetDeploymentStatus(deploymentID, "failed", err)
+	} else {
+		logFunc("success", services.EventDeploymentSucceeded, fmt.Sprintf("Deployment completed successfully! Public IP: %s", publicIP), "completed",
+			map[string]interface{}{"public_ip": publicIP})
+		deploymentManager.SetPublicIP(deploymentID, publicIP)
+		deploymentManager.SetDeploymentStatus(deploymentID, "completed", nil)
+	}
+
+	go notifyDeploymentFinished(deploymentID, publicIP)
+	
+	deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     "system",
+		Message:   "DEPLOYMENT_COMPLETE",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "system",
+		Code:      services.EventDeploymentComplete,
 	})
+	
+	log.Printf("Deployment %s completed", deploymentID)
+	
+	time.Sleep(2 * time.Second)
+	deploymentManager.KickClients(deploymentID)
 }
 
 func handleLogStream(c *gin.Context) {
@@ -246,6 +1143,12 @@ func handleLogStream(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
 		return
 	}
+
+	// EventSource cannot send an Authorization header, so the stream is
+	// authorized by a token from POST /deploy/:id/stream-token.
+	if !authorizeLogs(c, status) {
+		return
+	}
 	
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -253,12 +1156,10 @@ func handleLogStream(c *gin.Context) {
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Access-Control-Allow-Headers", "Cache-Control")
 
-	clientChan := make(chan services.LogMessage, 100)
-	
-	deploymentManager.AddClient(deploymentID, clientChan)
-	defer deploymentManager.RemoveClient(deploymentID, clientChan)
+	client := deploymentManager.AddClient(deploymentID)
+	defer deploymentManager.RemoveClient(deploymentID, client)
 
-	initialMsg := services.LogMessage{
+	initialMsg := types.LogMessage{
 		Level:     "system",
 		Message:   fmt.Sprintf("Connected to log stream for deployment %s (status: %s)", deploymentID, status.Status),
 		Timestamp: time.Now().Format(time.RFC3339),
@@ -271,12 +1172,13 @@ func handleLogStream(c *gin.Context) {
 	
 	log.Printf("SSE connection established for deployment: %s", deploymentID)
 
-	if status.Status == "completed" || status.Status == "failed" {
-		completionMsg := services.LogMessage{
+	if status.Status == "completed" || status.Status == "failed" || status.Status == "cancelled" || status.Status == "destroyed" {
+		completionMsg := types.LogMessage{
 			Level:     "system",
 			Message:   "DEPLOYMENT_COMPLETE",
 			Timestamp: time.Now().Format(time.RFC3339),
 			Step:      "system",
+			Code:      services.EventDeploymentComplete,
 		}
 		data, _ := json.Marshal(completionMsg)
 		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
@@ -292,7 +1194,7 @@ func handleLogStream(c *gin.Context) {
 
 	for {
 		select {
-		case logMsg, ok := <-clientChan:
+		case logMsg, ok := <-client.Messages():
 			if !ok {
 				log.Printf("Client channel closed for deployment: %s", deploymentID)
 				return
@@ -311,14 +1213,14 @@ func handleLogStream(c *gin.Context) {
 			
 			log.Printf("Sent log to client for deployment %s: %s", deploymentID, logMsg.Message)
 			
-			if logMsg.Level == "system" && logMsg.Message == "DEPLOYMENT_COMPLETE" {
+			if logMsg.Code == services.EventDeploymentComplete {
 				log.Printf("Deployment complete, closing SSE connection: %s", deploymentID)
 				time.Sleep(1 * time.Second) 
 				return
 			}
 			
 		case <-heartbeatTicker.C:
-			heartbeat := services.LogMessage{
+			heartbeat := types.LogMessage{
 				Level:     "system",
 				Message:   "heartbeat",
 				Timestamp: time.Now().Format(time.RFC3339),
@@ -339,6 +1241,9 @@ func handleLogStream(c *gin.Context) {
 	}
 }
 
+// handleDeploymentStatus reports where a deployment is. Anyone with its ID
+// can poll its status and times; its errors, services, timeline and
+// schedule are only shown to callers who may see the deployment.
 func handleDeploymentStatus(c *gin.Context) {
 	deploymentID := c.Param("deploymentId")
 	
@@ -358,9 +1263,33 @@ func handleDeploymentStatus(c *gin.Context) {
 		response["end_time"] = status.EndTime.Format(time.RFC3339)
 		response["duration"] = status.EndTime.Sub(status.StartTime).String()
 	}
+
+	if _, ok := deploymentCaller(c, status); !ok {
+		c.JSON(http.StatusOK, response)
+		return
+	}
 	
 	if status.Error != nil {
 		response["error"] = status.Error.Error()
+		response["error_code"] = status.ErrorCode
+		response["error_stage"] = status.ErrorStage
+		response["retryable"] = status.Retryable
+	}
+
+	if status.ArchivedAt != nil {
+		response["archived_at"] = status.ArchivedAt.Format(time.RFC3339)
+	}
+
+	if len(status.Services) > 0 {
+		response["services"] = status.Services
+	}
+
+	if status.AutoShutdown != "" {
+		response["auto_shutdown"] = status.AutoShutdown
+	}
+
+	if len(status.Timeline) > 0 {
+		response["timeline"] = status.Timeline
 	}
 	
 	c.JSON(http.StatusOK, response)
@@ -370,12 +1299,772 @@ func validateRequest(req *services.DeploymentRequest) error {
 	if req.Username == "" {
 		return fmt.Errorf("username is required")
 	}
+	if !store.ValidName(req.Username) {
+		return fmt.Errorf("username must not contain / or \\ or be . or ..")
+	}
 	if req.RepoURL == "" {
 		return fmt.Errorf("repo_url is required")
 	}
 	if req.GithubToken == "" {
 		return fmt.Errorf("github_token is required")
 	}
+	if err := validateLimits(req); err != nil {
+		return err
+	}
+	if err := validatePlacement(req); err != nil {
+		return err
+	}
+	if err := validateMode(req); err != nil {
+		return err
+	}
+	if req.SubscriptionID != "" || req.RestoreSnapshotID != "" {
+		return fmt.Errorf("subscription_id and restore_snapshot_id can only be set by a backup restore")
+	}
+	if (req.Organization == "") != (req.Team == "") {
+		return fmt.Errorf("organization and team must be set together")
+	}
+	if err := validateStartCommand(req.StartCommand); err != nil {
+		return err
+	}
+	if req.PythonVersion != "" && !services.ValidPythonVersion(req.PythonVersion) {
+		return fmt.Errorf("python_version must be a version like 3.11")
+	}
+	if req.Priority != "" && !services.ValidPriority(req.Priority) {
+		return fmt.Errorf("priority must be %s, %s or %s", services.PriorityHigh, services.PriorityNormal, services.PriorityLow)
+	}
+	if req.URLPrefix != "" && (len(req.URLPrefix) > 100 || !urlPrefixPattern.MatchString(req.URLPrefix)) {
+		return fmt.Errorf("url_prefix must be a path like /api, without a trailing slash")
+	}
+	if err := validateSecrets(req); err != nil {
+		return err
+	}
+	for _, name := range req.RequiredEnv {
+		if !services.ValidEnvKey(name) {
+			return fmt.Errorf("invalid required_env name %q", name)
+		}
+	}
+	if err := validateDomains(req.Domains); err != nil {
+		return err
+	}
+	if err := validateHooks(req.Hooks); err != nil {
+		return err
+	}
+	if err := validateLogSinks(req.LogSinks); err != nil {
+		return err
+	}
+	if err := services.ValidateAnsibleIncludes(req.AnsibleIncludes); err != nil {
+		return err
+	}
+	if err := validateServices(req.Services); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateSecrets checks secret names and that each value fits on the
+// supervisor environment line it is injected into.
+func validateSecrets(req *services.DeploymentRequest) error {
+	for key, value := range req.Secrets {
+		if !services.ValidEnvKey(key) {
+			return fmt.Errorf("invalid secret name %q", key)
+		}
+		if _, exists := req.EnvVariables[key]; exists {
+			return fmt.Errorf("%s is set in both env_variables and secrets", key)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("secret %s must be a single line", key)
+		}
+	}
+	return nil
+}
+
+const maxStartCommandLength = 1000
+
+// validateStartCommand checks a custom start command can be embedded as a
+// single line of start_server.sh.
+func validateStartCommand(command string) error {
+	if command == "" {
+		return nil
+	}
+	if err := validateCommandLine("start_command", command); err != nil {
+		return err
+	}
+	if command == "exec" || strings.HasPrefix(command, "exec ") {
+		return fmt.Errorf("start_command must not start with exec; it is exec'd already")
+	}
+	return nil
+}
+
+// validateCommandLine checks a command can be embedded as a single line of
+// a generated script. Ansible templates the scripts, so Jinja delimiters
+// are rejected too.
+func validateCommandLine(field, command string) error {
+	if len(command) > maxStartCommandLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxStartCommandLength)
+	}
+	if strings.TrimSpace(command) != command {
+		return fmt.Errorf("%s must not have leading or trailing whitespace", field)
+	}
+	if strings.ContainsAny(command, "\r\n\x00") {
+		return fmt.Errorf("%s must be a single line", field)
+	}
+	for _, delimiter := range []string{"{{", "}}", "{%", "%}", "{#", "#}"} {
+		if strings.Contains(command, delimiter) {
+			return fmt.Errorf("%s must not contain %q", field, delimiter)
+		}
+	}
+	return nil
+}
+
+const maxServices = 10
+
+var serviceNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,29}$`)
+
+// validateServices checks the extra services of a composite deployment.
+// Names become directory and supervisor program names, so they are kept to
+// lowercase letters, digits and dashes.
+func validateServices(specs []services.ServiceSpec) error {
+	if len(specs) > maxServices {
+		return fmt.Errorf("at most %d services are allowed", maxServices)
+	}
+	names := make(map[string]bool)
+	for i, spec := range specs {
+		if !serviceNamePattern.MatchString(spec.Name) {
+			return fmt.Errorf("services[%d]: name must start with a letter and be up to 30 lowercase letters, digits or dashes", i)
+		}
+		if names[spec.Name] {
+			return fmt.Errorf("services[%d]: duplicate name %q", i, spec.Name)
+		}
+		names[spec.Name] = true
+
+		if !strings.HasPrefix(spec.RepoURL, "https://") || strings.ContainsAny(spec.RepoURL, "\"'\r\n ") {
+			return fmt.Errorf("services[%d]: repo_url must be an https URL", i)
+		}
+		if spec.Command == "" {
+			return fmt.Errorf("services[%d]: command is required", i)
+		}
+		if err := validateCommandLine(fmt.Sprintf("services[%d].command", i), spec.Command); err != nil {
+			return err
+		}
+		if spec.Command == "exec" || strings.HasPrefix(spec.Command, "exec ") {
+			return fmt.Errorf("services[%d]: command must not start with exec; it is exec'd already", i)
+		}
+		for j, command := range spec.SetupCommands {
+			if err := validateCommandLine(fmt.Sprintf("services[%d].setup_commands[%d]", i, j), command); err != nil {
+				return err
+			}
+		}
+		for key := range spec.EnvVariables {
+			if !services.ValidEnvKey(key) {
+				return fmt.Errorf("services[%d]: invalid env variable name %q", i, key)
+			}
+		}
+	}
+	return nil
+}
+
+const maxHooks = 20
+
+const maxHookPlaybookSize = 64 << 10
+
+func validateHooks(hooks []services.LifecycleHook) error {
+	if len(hooks) > maxHooks {
+		return fmt.Errorf("at most %d hooks are allowed", maxHooks)
+	}
+	for i, hook := range hooks {
+		switch hook.Stage {
+		case services.HookPreProvision, services.HookPostProvision, services.HookPostDeploy, services.HookOnFailure:
+		default:
+			return fmt.Errorf("hooks[%d]: stage must be pre_provision, post_provision, post_deploy or on_failure", i)
+		}
+		set := 0
+		for _, action := range []string{hook.Command, hook.URL, hook.Playbook} {
+			if action != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("hooks[%d]: set exactly one of command, url and playbook", i)
+		}
+		if hook.Command != "" {
+			if !services.HookCommandsEnabled() {
+				return fmt.Errorf("hooks[%d]: command hooks are disabled on this server; use a url", i)
+			}
+			if len(hook.Command) > maxStartCommandLength {
+				return fmt.Errorf("hooks[%d]: command must be at most %d characters", i, maxStartCommandLength)
+			}
+		}
+		if hook.Playbook != "" {
+			if hook.Stage != services.HookOnFailure {
+				return fmt.Errorf("hooks[%d]: playbook is only allowed for on_failure hooks", i)
+			}
+			if !services.HookCommandsEnabled() {
+				return fmt.Errorf("hooks[%d]: playbook hooks are disabled on this server; use a url", i)
+			}
+			if len(hook.Playbook) > maxHookPlaybookSize {
+				return fmt.Errorf("hooks[%d]: playbook must be at most %d bytes", i, maxHookPlaybookSize)
+			}
+		}
+		if hook.URL != "" {
+			parsed, err := url.Parse(hook.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("hooks[%d]: url must be an http or https URL", i)
+			}
+		}
+		if hook.TimeoutSeconds < 0 || time.Duration(hook.TimeoutSeconds)*time.Second > services.MaxHookTimeout {
+			return fmt.Errorf("hooks[%d]: timeout_seconds must be between 0 and %d", i, int(services.MaxHookTimeout.Seconds()))
+		}
+		switch hook.OnFailure {
+		case "", "abort", "continue":
+		default:
+			return fmt.Errorf("hooks[%d]: on_failure must be abort or continue", i)
+		}
+	}
+	return nil
+}
+
+var environmentPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,19}$`)
+
+var serverUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+var hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// validateDomains checks the hostnames a deployment adds to its allowed
+// hosts.
+func validateDomains(domains []string) error {
+	if len(domains) > services.MaxDomains {
+		return fmt.Errorf("at most %d domains are allowed", services.MaxDomains)
+	}
+	for _, domain := range domains {
+		if len(domain) > 253 || !hostnamePattern.MatchString(domain) {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// customImagePattern matches the resource ID of a managed image or of a
+// Compute Gallery image, optionally at a version.
+var customImagePattern = regexp.MustCompile(`^/subscriptions/[0-9a-fA-F-]{36}/resourceGroups/[\w().-]+/providers/Microsoft\.Compute/(images/[\w.-]+|galleries/[\w.]+/images/[\w.-]+(/versions/[\d.]+)?)$`)
+
+var subnetIDPattern = regexp.MustCompile(`^/subscriptions/[0-9a-fA-F-]{36}/resourceGroups/[\w().-]+/providers/Microsoft\.Network/virtualNetworks/[\w.-]+/subnets/[\w.-]+$`)
+
+var resourceGroupPattern = regexp.MustCompile(`^[\w().-]{0,89}[\w()-]$`)
+
+var acrLoginServerPattern = regexp.MustCompile(`^[a-z0-9]{5,50}\.azurecr\.io$`)
+
+const maxKubeconfigSize = 64 << 10
+
+var urlPrefixPattern = regexp.MustCompile(`^(/[A-Za-z0-9_-]+)+$`)
+
+// placementValidators check the placement fields of each provider, where
+// location and vm_size mean different things. Registered providers without
+// one accept any placement.
+var placementValidators = map[string]func(*services.DeploymentRequest) error{
+	services.CloudAzure:        validateAzurePlacement,
+	services.CloudAWS:          validateAWSPlacement,
+	services.CloudDigitalOcean: validateDigitalOceanPlacement,
+	services.CloudHetzner:      validateHetznerPlacement,
+	services.CloudLinode:       validateLinodePlacement,
+	services.CloudOCI:          validateOCIPlacement,
+	services.CloudBYOS:         validateBYOSPlacement,
+	services.CloudAKS:          validateAKSPlacement,
+}
+
+// validatePlacement checks the fields that decide what is provisioned and
+// where; clones re-check them after applying overrides.
+func validatePlacement(req *services.DeploymentRequest) error {
+	if req.Provider != "" && req.Cloud != "" && req.Provider != req.Cloud {
+		return fmt.Errorf("provider and cloud disagree; set only provider")
+	}
+	provider := services.Cloud(req)
+	if !services.IsSupportedProvider(provider) {
+		return fmt.Errorf("unsupported provider %q; supported providers are %s", provider, strings.Join(services.SupportedProviders(), ", "))
+	}
+	if provider != services.CloudAzure && (req.ScaleSet || req.Instances != 0) {
+		return fmt.Errorf("scale_set and instances are only available on azure")
+	}
+	if req.ScaleSet && providers.AzureBackendFromEnv() != providers.AzureBackendTerraform {
+		return fmt.Errorf("scale_set needs AZURE_BACKEND=terraform")
+	}
+	if provider != services.CloudAzure && (req.UseSpot || req.SpotMaxPrice != 0 || req.SpotEvictionPolicy != "") {
+		return fmt.Errorf("use_spot, spot_max_price and spot_eviction_policy are only available on azure")
+	}
+	if provider != services.CloudAzure && (req.Image != "" || req.StaticIP) {
+		return fmt.Errorf("image and static_ip are only available on azure")
+	}
+	if provider != services.CloudAzure && (req.ExistingResourceGroup != "" || req.SubnetID != "" || req.PrivateNetworking) {
+		return fmt.Errorf("existing_resource_group, subnet_id and private_networking are only available on azure")
+	}
+	if provider != services.CloudAzure && len(req.FallbackLocations) > 0 {
+		return fmt.Errorf("fallback_locations is only available on azure")
+	}
+	if provider != services.CloudAzure && len(req.SSHAllowedCIDRs) > 0 {
+		return fmt.Errorf("ssh_allowed_cidrs is only available on azure")
+	}
+	if provider != services.CloudAzure && len(req.OpenPorts) > 0 {
+		return fmt.Errorf("open_ports is only available on azure")
+	}
+	if provider != services.CloudAzure && (req.AutoShutdown != "" || req.AutoShutdownTimezone != "") {
+		return fmt.Errorf("auto_shutdown and auto_shutdown_timezone are only available on azure")
+	}
+	if provider != services.CloudAzure && req.DataDiskSizeGB != 0 {
+		return fmt.Errorf("data_disk_size_gb is only available on azure")
+	}
+	if provider != services.CloudBYOS && services.BYOSFields(req) {
+		return fmt.Errorf("server_host, server_user and server_ssh_key are only used with provider byos")
+	}
+	if req.AzureCredentials != nil {
+		if provider != services.CloudAzure && provider != services.CloudAKS {
+			return fmt.Errorf("azure_credentials are only used on azure and aks")
+		}
+		if err := req.AzureCredentials.Validate(); err != nil {
+			return fmt.Errorf("azure_credentials: %v", err)
+		}
+		if req.AzureCredentials.Mode() == providers.AzureAuthCLI {
+			return fmt.Errorf("azure_credentials must set client_secret or managed_identity")
+		}
+	}
+	if provider != services.CloudAKS && (req.Kubeconfig != "" || req.ContainerRegistry != "") {
+		return fmt.Errorf("kubeconfig and container_registry are only used with provider aks")
+	}
+	if req.DiskSizeGB != 0 {
+		minSize, maxSize, ok := services.DiskSizeRange(provider)
+		if !ok {
+			return fmt.Errorf("disk_size_gb is not available on %s, where the disk size follows vm_size", provider)
+		}
+		if req.DiskSizeGB < minSize || req.DiskSizeGB > maxSize {
+			return fmt.Errorf("disk_size_gb must be between %d and %d on %s", minSize, maxSize, provider)
+		}
+	}
+	if req.DataDiskSizeGB != 0 {
+		if req.DataDiskSizeGB < services.MinDataDiskSizeGB || req.DataDiskSizeGB > services.MaxDiskSizeGB {
+			return fmt.Errorf("data_disk_size_gb must be between %d and %d", services.MinDataDiskSizeGB, services.MaxDiskSizeGB)
+		}
+		if req.ScaleSet {
+			return fmt.Errorf("data_disk_size_gb is not available with scale_set, whose instances would each need their own disk")
+		}
+	}
+	if validate, ok := placementValidators[provider]; ok {
+		return validate(req)
+	}
+	return nil
+}
+
+// validateAzurePlacement is validatePlacement for Azure, where location is
+// an Azure location and vm_size a VM size. Only Azure has the shared pool.
+func validateAzurePlacement(req *services.DeploymentRequest) error {
+	switch req.Architecture {
+	case "", "x64", "arm64":
+	default:
+		return fmt.Errorf("architecture must be x64 or arm64")
+	}
+	if req.GPU && req.Architecture == "arm64" {
+		return fmt.Errorf("gpu is not available with arm64")
+	}
+	if req.InstallCUDA && !req.GPU {
+		return fmt.Errorf("install_cuda requires gpu")
+	}
+	if req.Location != "" && !providers.IsKnownLocation(req.Location) {
+		return fmt.Errorf("unsupported location %q", req.Location)
+	}
+	for _, location := range req.FallbackLocations {
+		if !providers.IsKnownLocation(location) {
+			return fmt.Errorf("unsupported fallback location %q", location)
+		}
+	}
+	if len(req.FallbackLocations) > 0 && (req.ExistingResourceGroup != "" || req.SubnetID != "" || req.StaticIP || req.DataDiskSizeGB != 0) {
+		return fmt.Errorf("fallback_locations cannot be combined with existing_resource_group, subnet_id, static_ip or data_disk_size_gb, which tie the VM to its location")
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" {
+		if !providers.IsKnownVMSize(req.VMSize) {
+			return fmt.Errorf("unsupported vm_size %q", req.VMSize)
+		}
+		if req.Architecture == "arm64" && !providers.IsARM64VMSize(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not an arm64 size", req.VMSize)
+		}
+		if req.GPU && !providers.IsGPUVMSize(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not a GPU size", req.VMSize)
+		}
+	}
+	if req.Instances != 0 && !req.ScaleSet {
+		return fmt.Errorf("instances requires scale_set")
+	}
+	if !req.UseSpot && (req.SpotMaxPrice != 0 || req.SpotEvictionPolicy != "") {
+		return fmt.Errorf("spot_max_price and spot_eviction_policy require use_spot")
+	}
+	if req.SpotMaxPrice < 0 {
+		return fmt.Errorf("spot_max_price must be positive; leave it unset to pay up to the on-demand price")
+	}
+	switch req.SpotEvictionPolicy {
+	case "", "Deallocate", "Delete":
+	default:
+		return fmt.Errorf("spot_eviction_policy must be Deallocate or Delete")
+	}
+	if err := validateNetworking(req); err != nil {
+		return err
+	}
+	if err := validateOpenPorts(req); err != nil {
+		return err
+	}
+	if req.AutoShutdown != "" && !services.ValidAutoShutdown(req.AutoShutdown) {
+		return fmt.Errorf("auto_shutdown must be a time like 19:00 or %q", services.AutoShutdownOff)
+	}
+	if req.AutoShutdownTimezone != "" {
+		if !services.ValidAutoShutdownTimezone(req.AutoShutdownTimezone) {
+			return fmt.Errorf("auto_shutdown_timezone must be a Windows time zone ID, like UTC or W. Europe Standard Time")
+		}
+		if req.AutoShutdown == services.AutoShutdownOff {
+			return fmt.Errorf("auto_shutdown_timezone cannot be set when auto_shutdown is off")
+		}
+	}
+	if req.ScaleSet && (req.AutoShutdown != "" || req.AutoShutdownTimezone != "") {
+		return fmt.Errorf("scale_set deployments have no auto-shutdown schedule")
+	}
+	switch req.Image {
+	case "", providers.ImageUbuntu2204, providers.ImageUbuntu2404:
+	default:
+		// Custom images must be Ubuntu based for the playbook to run.
+		if !customImagePattern.MatchString(req.Image) {
+			return fmt.Errorf("image must be %s, %s or the resource ID of a custom image", providers.ImageUbuntu2204, providers.ImageUbuntu2404)
+		}
+	}
+	if req.ScaleSet {
+		if req.Instances < 0 || req.Instances > services.MaxScaleSetInstances {
+			return fmt.Errorf("instances must be between 1 and %d", services.MaxScaleSetInstances)
+		}
+		// Auto-deploy and data restores reach a single VM over SSH.
+		if req.Pooled || req.AutoDeploy || req.SnapshotBeforeDeploy {
+			return fmt.Errorf("scale_set deployments do not support pooled, auto_deploy or snapshot_before_deploy")
+		}
+	}
+	if req.Pooled {
+		switch {
+		case req.GPU, req.Architecture == "arm64", req.VMSize != "", req.Location != "", req.DiskSizeGB != 0, req.DataDiskSizeGB != 0, req.UseSpot, req.Image != "", req.StaticIP,
+			req.ExistingResourceGroup != "", req.SubnetID != "", req.PrivateNetworking, len(req.SSHAllowedCIDRs) > 0, len(req.OpenPorts) > 0,
+			req.AutoShutdown != "", req.AutoShutdownTimezone != "", req.AzureCredentials != nil, len(req.FallbackLocations) > 0:
+			return fmt.Errorf("pooled deployments run on the shared pool VMs and cannot choose gpu, architecture, vm_size, location, fallback_locations, disk_size_gb, data_disk_size_gb, use_spot, image, static_ip, networking, open_ports, auto_shutdown or azure_credentials")
+		case req.AutoDeploy, req.SnapshotBeforeDeploy, req.ApprovalRequired, req.StartCommand != "", len(req.AnsibleIncludes) > 0, len(req.Services) > 0, req.URLPrefix != "":
+			return fmt.Errorf("pooled deployments do not support auto_deploy, snapshot_before_deploy, approval_required, start_command, ansible_includes, services or url_prefix")
+		case req.PythonVersion != "", req.Redis, len(req.Domains) > 0:
+			// The pool VMs are shared, so they keep their system Python
+			// and hosts.
+			return fmt.Errorf("pooled deployments do not support python_version, redis or domains")
+		}
+	}
+	return nil
+}
+
+// validateNetworking checks the fields that place an Azure VM in an existing
+// resource group and subnet, and its SSH allowlist. The subnet must be in
+// the resource group's location, which the VM is created in.
+func validateNetworking(req *services.DeploymentRequest) error {
+	if req.ExistingResourceGroup != "" {
+		if !resourceGroupPattern.MatchString(req.ExistingResourceGroup) {
+			return fmt.Errorf("invalid existing_resource_group %q", req.ExistingResourceGroup)
+		}
+		if req.Location != "" {
+			return fmt.Errorf("location cannot be set with existing_resource_group, whose location is used")
+		}
+	}
+	if req.SubnetID != "" && !subnetIDPattern.MatchString(req.SubnetID) {
+		return fmt.Errorf("subnet_id must be the resource ID of a virtual network subnet")
+	}
+	for _, cidr := range req.SSHAllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("ssh_allowed_cidrs entry %q is not a CIDR or IP address", cidr)
+		}
+	}
+	if req.PrivateNetworking {
+		// Without a public IP the VM is only reachable through the network
+		// it is attached to, which this server must be able to reach.
+		if req.SubnetID == "" {
+			return fmt.Errorf("private_networking requires subnet_id")
+		}
+		if req.StaticIP || req.ScaleSet {
+			return fmt.Errorf("private_networking cannot be combined with static_ip or scale_set")
+		}
+	}
+	return nil
+}
+
+// reservedPorts are open on every VM already, or used by the playbook.
+var reservedPorts = map[int]bool{22: true, 80: true, 443: true, 8000: true, 6379: true}
+
+var openPortPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._~-]+(/[A-Za-z0-9._~-]+)*/$`)
+
+// validateOpenPorts checks the request's open_ports. Proxied paths must not
+// shadow the locations the nginx configuration already has.
+func validateOpenPorts(req *services.DeploymentRequest) error {
+	if len(req.OpenPorts) == 0 {
+		return nil
+	}
+	if len(req.OpenPorts) > services.MaxOpenPorts {
+		return fmt.Errorf("open_ports allows at most %d ports", services.MaxOpenPorts)
+	}
+	if req.ScaleSet {
+		// The load balancer only forwards the app's ports.
+		return fmt.Errorf("open_ports is not available with scale_set")
+	}
+	seenPorts := make(map[int]bool)
+	seenPaths := make(map[string]bool)
+	for _, open := range req.OpenPorts {
+		if open.Port < 1024 || open.Port > 65535 {
+			return fmt.Errorf("open_ports port %d must be between 1024 and 65535", open.Port)
+		}
+		if reservedPorts[open.Port] {
+			return fmt.Errorf("open_ports port %d is reserved", open.Port)
+		}
+		if seenPorts[open.Port] {
+			return fmt.Errorf("open_ports lists port %d twice", open.Port)
+		}
+		seenPorts[open.Port] = true
+
+		if open.Path == "" {
+			continue
+		}
+		if !openPortPathPattern.MatchString(open.Path) {
+			return fmt.Errorf("open_ports path %q must start and end with /, like /flower/", open.Path)
+		}
+		switch open.Path {
+		case "/static/", "/media/", "/health/", "/ws/", "/api/":
+			return fmt.Errorf("open_ports path %s is used by the app", open.Path)
+		}
+		if seenPaths[open.Path] {
+			return fmt.Errorf("open_ports lists path %s twice", open.Path)
+		}
+		seenPaths[open.Path] = true
+	}
+	return nil
+}
+
+// validateAWSPlacement is validatePlacement for AWS, where location is a
+// region and vm_size an EC2 instance type.
+func validateAWSPlacement(req *services.DeploymentRequest) error {
+	switch req.Architecture {
+	case "", "x64", "arm64":
+	default:
+		return fmt.Errorf("architecture must be x64 or arm64")
+	}
+	if req.GPU && req.Architecture == "arm64" {
+		return fmt.Errorf("gpu is not available with arm64")
+	}
+	if req.InstallCUDA && !req.GPU {
+		return fmt.Errorf("install_cuda requires gpu")
+	}
+	if req.Location != "" && !providers.IsKnownAWSRegion(req.Location) {
+		return fmt.Errorf("unsupported AWS region %q", req.Location)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" {
+		if !providers.IsKnownAWSInstanceType(req.VMSize) {
+			return fmt.Errorf("unsupported AWS instance type %q", req.VMSize)
+		}
+		if req.Architecture == "arm64" && !providers.IsARM64InstanceType(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not an arm64 instance type", req.VMSize)
+		}
+		if req.GPU && !providers.IsGPUInstanceType(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not a GPU instance type", req.VMSize)
+		}
+	}
+	if req.Pooled || req.SnapshotBeforeDeploy {
+		return fmt.Errorf("pooled and snapshot_before_deploy are only available on azure")
+	}
+	return nil
+}
+
+// validateDigitalOceanPlacement is validatePlacement for DigitalOcean, where
+// location is a region slug and vm_size a droplet size. Droplets are x64
+// only and have no GPU option.
+func validateDigitalOceanPlacement(req *services.DeploymentRequest) error {
+	if req.Architecture != "" && req.Architecture != "x64" {
+		return fmt.Errorf("digitalocean droplets are x64 only")
+	}
+	if req.GPU || req.InstallCUDA {
+		return fmt.Errorf("gpu is not available on digitalocean")
+	}
+	if req.Location != "" && !providers.IsKnownDigitalOceanRegion(req.Location) {
+		return fmt.Errorf("unsupported DigitalOcean region %q", req.Location)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" && !providers.IsKnownDigitalOceanSize(req.VMSize) {
+		return fmt.Errorf("unsupported droplet size %q", req.VMSize)
+	}
+	if req.Pooled || req.SnapshotBeforeDeploy {
+		return fmt.Errorf("pooled and snapshot_before_deploy are only available on azure")
+	}
+	return nil
+}
 
+// validateHetznerPlacement is validatePlacement for Hetzner Cloud, where
+// location is a location code and vm_size a server type. Hetzner has no GPU
+// servers.
+func validateHetznerPlacement(req *services.DeploymentRequest) error {
+	switch req.Architecture {
+	case "", "x64", "arm64":
+	default:
+		return fmt.Errorf("architecture must be x64 or arm64")
+	}
+	if req.GPU || req.InstallCUDA {
+		return fmt.Errorf("gpu is not available on hetzner")
+	}
+	if req.Location != "" && !providers.IsKnownHetznerLocation(req.Location) {
+		return fmt.Errorf("unsupported Hetzner location %q", req.Location)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" {
+		if !providers.IsKnownHetznerServerType(req.VMSize) {
+			return fmt.Errorf("unsupported Hetzner server type %q", req.VMSize)
+		}
+		if req.Architecture == "arm64" && !providers.IsARM64ServerType(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not an arm64 server type", req.VMSize)
+		}
+	}
+	if req.Pooled || req.SnapshotBeforeDeploy {
+		return fmt.Errorf("pooled and snapshot_before_deploy are only available on azure")
+	}
+	return nil
+}
+
+// validateLinodePlacement is validatePlacement for Linode, where location is
+// a region and vm_size an instance type. Linodes are x64 only and GPU plans
+// are not offered.
+func validateLinodePlacement(req *services.DeploymentRequest) error {
+	if req.Architecture != "" && req.Architecture != "x64" {
+		return fmt.Errorf("linode instances are x64 only")
+	}
+	if req.GPU || req.InstallCUDA {
+		return fmt.Errorf("gpu is not available on linode")
+	}
+	if req.Location != "" && !providers.IsKnownLinodeRegion(req.Location) {
+		return fmt.Errorf("unsupported Linode region %q", req.Location)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" && !providers.IsKnownLinodeType(req.VMSize) {
+		return fmt.Errorf("unsupported Linode type %q", req.VMSize)
+	}
+	if req.Pooled || req.SnapshotBeforeDeploy {
+		return fmt.Errorf("pooled and snapshot_before_deploy are only available on azure")
+	}
+	return nil
+}
+
+// validateOCIPlacement is validatePlacement for Oracle Cloud, where location
+// is a region and vm_size a shape. Only the Always Free shapes are offered,
+// and none has a GPU.
+func validateOCIPlacement(req *services.DeploymentRequest) error {
+	switch req.Architecture {
+	case "", "x64", "arm64":
+	default:
+		return fmt.Errorf("architecture must be x64 or arm64")
+	}
+	if req.GPU || req.InstallCUDA {
+		return fmt.Errorf("gpu is not available on oci")
+	}
+	if req.Location != "" && !providers.IsKnownOCIRegion(req.Location) {
+		return fmt.Errorf("unsupported OCI region %q", req.Location)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	if req.VMSize != "" {
+		if !providers.IsKnownOCIShape(req.VMSize) {
+			return fmt.Errorf("unsupported OCI shape %q", req.VMSize)
+		}
+		if req.Architecture != "" && (req.Architecture == "arm64") != providers.IsARM64OCIShape(req.VMSize) {
+			return fmt.Errorf("vm_size %s is not an %s shape", req.VMSize, req.Architecture)
+		}
+	}
+	if req.Pooled || req.SnapshotBeforeDeploy {
+		return fmt.Errorf("pooled and snapshot_before_deploy are only available on azure")
+	}
+	return nil
+}
+
+// validateBYOSPlacement is validatePlacement for an existing server, which
+// is reached over SSH on port 22 as server_user with server_ssh_key. There
+// is nothing to place, so location, vm_size and the VM options are refused.
+func validateBYOSPlacement(req *services.DeploymentRequest) error {
+	if req.ServerHost == "" || req.ServerUser == "" || req.ServerSSHKey == "" {
+		return fmt.Errorf("provider byos requires server_host, server_user and server_ssh_key")
+	}
+	if net.ParseIP(req.ServerHost) == nil && (len(req.ServerHost) > 253 || !hostnamePattern.MatchString(req.ServerHost)) {
+		return fmt.Errorf("server_host must be an IP address or hostname")
+	}
+	if !serverUserPattern.MatchString(req.ServerUser) {
+		return fmt.Errorf("server_user must be a valid Unix user name")
+	}
+	if err := services.ParseServerKey(req.ServerSSHKey); err != nil {
+		return fmt.Errorf("server_ssh_key is not a usable private key: %v", err)
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	switch {
+	case req.Location != "", req.VMSize != "", req.Architecture != "", req.GPU, req.InstallCUDA:
+		return fmt.Errorf("provider byos deploys to an existing server and cannot choose location, vm_size, architecture or gpu")
+	case req.Pooled, req.SnapshotBeforeDeploy, req.ApprovalRequired:
+		return fmt.Errorf("provider byos does not support pooled, snapshot_before_deploy or approval_required")
+	}
+	return nil
+}
+
+// validateAKSPlacement is validatePlacement for AKS, where location is an
+// Azure location and vm_size the node size. With kubeconfig the app goes to
+// that existing cluster and is built into container_registry, an Azure
+// Container Registry, so there is nothing to place. The app runs from one
+// image without the playbook, so the options that need a VM are refused.
+func validateAKSPlacement(req *services.DeploymentRequest) error {
+	if (req.Kubeconfig == "") != (req.ContainerRegistry == "") {
+		return fmt.Errorf("kubeconfig and container_registry must be set together")
+	}
+	if req.Kubeconfig != "" {
+		if len(req.Kubeconfig) > maxKubeconfigSize {
+			return fmt.Errorf("kubeconfig must be at most %d bytes", maxKubeconfigSize)
+		}
+		if !acrLoginServerPattern.MatchString(req.ContainerRegistry) {
+			return fmt.Errorf("container_registry must be an Azure Container Registry login server like myregistry.azurecr.io")
+		}
+		if req.Location != "" || req.VMSize != "" || req.DiskSizeGB != 0 {
+			return fmt.Errorf("an existing cluster cannot choose location, vm_size or disk_size_gb")
+		}
+	}
+	if req.Location != "" && !providers.IsKnownLocation(req.Location) {
+		return fmt.Errorf("unsupported location %q", req.Location)
+	}
+	if req.VMSize != "" {
+		// Images are built for amd64.
+		if !providers.IsKnownVMSize(req.VMSize) || providers.IsARM64VMSize(req.VMSize) || providers.IsGPUVMSize(req.VMSize) {
+			return fmt.Errorf("unsupported vm_size %q", req.VMSize)
+		}
+	}
+	if req.Environment != "" && !environmentPattern.MatchString(req.Environment) {
+		return fmt.Errorf("environment must be up to 20 lowercase letters, digits or dashes")
+	}
+	switch {
+	case req.Architecture != "" && req.Architecture != "x64", req.GPU, req.InstallCUDA:
+		return fmt.Errorf("provider aks runs x64 nodes and cannot choose architecture or gpu")
+	case req.Pooled, req.SnapshotBeforeDeploy, req.AutoDeploy, req.Redis:
+		return fmt.Errorf("provider aks does not support pooled, snapshot_before_deploy, auto_deploy or redis")
+	case len(req.AdditionalCommands) > 0, len(req.AnsibleIncludes) > 0, req.URLPrefix != "", len(req.Domains) > 0:
+		return fmt.Errorf("provider aks does not run the playbook and does not support additional_commands, ansible_includes, url_prefix or domains")
+	}
+	for _, service := range req.Services {
+		if service.RepoURL != req.RepoURL {
+			return fmt.Errorf("on provider aks, service %s must run from repo_url", service.Name)
+		}
+	}
 	return nil
 }
\ No newline at end of file
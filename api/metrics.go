@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// metricsConcurrency caps the VMs sampled at once; each sample is an az
+// run-command invocation that takes half a minute or so.
+const metricsConcurrency = 4
+
+// Thresholds of the capacity advice, over the p95 of the window.
+const (
+	scaleUpCPUPercent      = 80
+	scaleUpMemoryPercent   = 85
+	scaleDownCPUPercent    = 20
+	scaleDownMemoryPercent = 50
+	diskFullPercent        = 85
+	// minAdviceSamples is how many samples the advice needs; fewer say
+	// too little about the VM's normal load.
+	minAdviceSamples = 12
+)
+
+var metricsStore *store.MetricsStore
+
+// metricsInterval is how often every dedicated Azure VM is sampled, every
+// 15 minutes unless METRICS_INTERVAL says otherwise. Zero disables
+// collection.
+func metricsInterval() time.Duration {
+	if value := os.Getenv("METRICS_INTERVAL"); value != "" {
+		if value == "0" {
+			return 0
+		}
+		if interval, err := time.ParseDuration(value); err == nil && interval >= time.Minute {
+			return interval
+		}
+		log.Printf("Invalid METRICS_INTERVAL value %q, using default", value)
+	}
+	return 15 * time.Minute
+}
+
+// metricsRetention is how long samples are kept, METRICS_RETENTION (like
+// "72h" or "30d") or a week.
+func metricsRetention() time.Duration {
+	if value := os.Getenv("METRICS_RETENTION"); value != "" {
+		if retention, err := parseUptimeWindow(value); err == nil && retention >= time.Hour {
+			return retention
+		}
+		log.Printf("Invalid METRICS_RETENTION value %q, using default", value)
+	}
+	return 7 * 24 * time.Hour
+}
+
+// metricsSupported reports whether a deployment runs on a VM of its own
+// that the collector can sample.
+func metricsSupported(req *services.DeploymentRequest) bool {
+	return services.Cloud(req) == services.CloudAzure && !req.Pooled && !req.ScaleSet
+}
+
+func runMetricsCollector(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, metricsConcurrency)
+		for _, deployment := range deploymentManager.ListDeployments() {
+			if deployment.Status != "completed" || deployment.ArchivedAt != nil {
+				continue
+			}
+			req, err := deploymentManager.Request(deployment.ID)
+			if err != nil || !metricsSupported(req) {
+				continue
+			}
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(deployment DeploymentStatus) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if err := collectMetrics(&deployment, services.VMSize(req), retention); err != nil {
+					log.Printf("Failed to collect metrics for %s: %v", deployment.ID, err)
+				}
+			}(deployment)
+		}
+		wg.Wait()
+	}
+}
+
+// collectMetrics samples the deployment's VM of vmSize through
+// run-command, which needs no SSH key, drops samples older than retention
+// and checks the VM for sustained pressure.
+func collectMetrics(status *DeploymentStatus, vmSize string, retention time.Duration) error {
+	azure, err := vmProvider(status)
+	if err != nil {
+		return err
+	}
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "metrics-"+status.ID, nil)
+	output, err := azure.RunShellScript(services.MetricsScript)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sample, err := services.ParseMetrics(output, now)
+	if err != nil {
+		return err
+	}
+	if err := metricsStore.Append(status.ID, sample); err != nil {
+		return err
+	}
+	if err := metricsStore.Prune(status.ID, now.Add(-retention)); err != nil {
+		return err
+	}
+	return advisor.evaluate(status, vmSize, now)
+}
+
+// MetricStats summarises one metric over a window.
+type MetricStats struct {
+	Avg float64 `json:"avg"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// CapacityAdvice suggests whether the VM's size suits its load: Action is
+// "scale_up", "scale_down" or "keep", with the reasons. VMSize is the size
+// to resize to with POST /deploy/:id/resize; ScaleSet is set instead when
+// no size of the series is big enough.
+type CapacityAdvice struct {
+	Action   string   `json:"action"`
+	VMSize   string   `json:"vm_size,omitempty"`
+	ScaleSet bool     `json:"scale_set,omitempty"`
+	Reasons  []string `json:"reasons"`
+}
+
+type MetricsReport struct {
+	DeploymentID        string               `json:"deployment_id"`
+	VMSize              string               `json:"vm_size"`
+	WindowStart         time.Time            `json:"window_start"`
+	WindowEnd           time.Time            `json:"window_end"`
+	CPUPercent          *MetricStats         `json:"cpu_percent,omitempty"`
+	MemoryPercent       *MetricStats         `json:"memory_percent,omitempty"`
+	DiskPercent         *MetricStats         `json:"disk_percent,omitempty"`
+	GunicornMemoryBytes *MetricStats         `json:"gunicorn_memory_bytes,omitempty"`
+	Advice              *CapacityAdvice      `json:"advice,omitempty"`
+	Alerts              []CapacityAlert      `json:"alerts"`
+	Samples             []store.MetricSample `json:"samples"`
+}
+
+func metricStats(samples []store.MetricSample, value func(store.MetricSample) float64) *MetricStats {
+	if len(samples) == 0 {
+		return nil
+	}
+	values := make([]float64, len(samples))
+	stats := &MetricStats{}
+	for i, sample := range samples {
+		values[i] = value(sample)
+		stats.Avg += values[i]
+		if values[i] > stats.Max {
+			stats.Max = values[i]
+		}
+	}
+	stats.Avg /= float64(len(values))
+	stats.P95 = percentile(values, 95)
+	return stats
+}
+
+// computeMetrics summarises samples taken by a VM of vmSize and advises
+// on its size. The advice looks at the p95 so a short spike does not call
+// for a bigger VM.
+func computeMetrics(samples []store.MetricSample, vmSize string, end time.Time) MetricsReport {
+	report := MetricsReport{
+		VMSize:              vmSize,
+		WindowEnd:           end,
+		CPUPercent:          metricStats(samples, func(s store.MetricSample) float64 { return s.CPUPercent }),
+		MemoryPercent:       metricStats(samples, store.MetricSample.MemoryPercent),
+		DiskPercent:         metricStats(samples, store.MetricSample.DiskPercent),
+		GunicornMemoryBytes: metricStats(samples, func(s store.MetricSample) float64 { return float64(s.GunicornMemoryBytes) }),
+		Samples:             samples,
+	}
+	if report.Samples == nil {
+		report.Samples = []store.MetricSample{}
+	}
+	if len(samples) < minAdviceSamples {
+		return report
+	}
+
+	advice := &CapacityAdvice{Action: "keep", Reasons: []string{}}
+	cpu, memory := report.CPUPercent.P95, report.MemoryPercent.P95
+	if cpu >= scaleUpCPUPercent {
+		advice.Action = "scale_up"
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("CPU p95 is %.0f%%, at or above %d%%", cpu, scaleUpCPUPercent))
+	}
+	if memory >= scaleUpMemoryPercent {
+		advice.Action = "scale_up"
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("memory p95 is %.0f%%, at or above %d%%", memory, scaleUpMemoryPercent))
+	}
+	if advice.Action == "keep" && cpu < scaleDownCPUPercent && report.MemoryPercent.Max < scaleDownMemoryPercent {
+		advice.Action = "scale_down"
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("CPU p95 is %.0f%% and memory never exceeded %.0f%%; a smaller size would do", cpu, report.MemoryPercent.Max))
+	}
+	adviseSize(advice, vmSize, cpu, report.MemoryPercent.Max)
+	if report.DiskPercent.Max >= diskFullPercent {
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("the OS disk is %.0f%% full; raise disk_size_gb to %d", report.DiskPercent.Max, recommendDiskSizeGB(samples[len(samples)-1].DiskUsedBytes)))
+	}
+	report.Advice = advice
+	return report
+}
+
+// handleDeploymentMetrics reports the CPU, memory, disk and gunicorn
+// memory samples of a deployment's VM over ?window= (default 24h), with
+// their average, p95 and maximum, advice on the VM's size and the active
+// capacity alerts.
+func handleDeploymentMetrics(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentView(c, status) {
+		return
+	}
+	req, err := deploymentManager.Request(deploymentID)
+	if err != nil || !metricsSupported(req) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Metrics are only collected from dedicated Azure VMs"})
+		return
+	}
+
+	window, err := parseUptimeWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+	samples, err := metricsStore.List(deploymentID, start)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	report := computeMetrics(samples, services.VMSize(req), end)
+	report.DeploymentID = deploymentID
+	report.WindowStart = start
+	report.Alerts = advisor.Alerts(deploymentID)
+	c.JSON(http.StatusOK, report)
+}
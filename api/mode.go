@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// validateMode checks the request's mode and the infra-only deployment an
+// app-only one deploys to.
+func validateMode(req *services.DeploymentRequest) error {
+	if req.Mode != "" && !services.ValidMode(req.Mode) {
+		return fmt.Errorf("mode must be %s, %s or %s", services.ModeFull, services.ModeInfraOnly, services.ModeAppOnly)
+	}
+	mode := services.Mode(req)
+	if mode == services.ModeAppOnly && req.InfraDeploymentID == "" {
+		return fmt.Errorf("infra_deployment_id is required with mode %s", services.ModeAppOnly)
+	}
+	if mode != services.ModeAppOnly && req.InfraDeploymentID != "" {
+		return fmt.Errorf("infra_deployment_id is only used with mode %s", services.ModeAppOnly)
+	}
+	if mode != services.ModeFull && (req.Pooled || req.ScaleSet || services.Cloud(req) == services.CloudAKS || services.Cloud(req) == services.CloudBYOS) {
+		return fmt.Errorf("mode %s is only available for deployments on a single VM of their own", mode)
+	}
+	return nil
+}
+
+// Infrastructure returns the VM of a completed infra-only deployment.
+func (dm *DeploymentManager) Infrastructure(deploymentID string) (*services.Infrastructure, error) {
+	dm.deployMux.RLock()
+	defer dm.deployMux.RUnlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return nil, errDeploymentNotFound
+	}
+	if deployment.Mode != services.ModeInfraOnly {
+		return nil, fmt.Errorf("deployment %s is not an infra-only deployment", deploymentID)
+	}
+	if deployment.Status != "completed" {
+		return nil, fmt.Errorf("deployment %s is %s", deploymentID, deployment.Status)
+	}
+	if deployment.TerraformDir == "" || deployment.PublicIP == "" {
+		return nil, fmt.Errorf("deployment %s has no kept SSH key or address", deploymentID)
+	}
+	return &services.Infrastructure{PublicIP: deployment.PublicIP, TerraformDir: deployment.TerraformDir}, nil
+}
+
+// checkInfrastructure checks that an app-only request deploys to an
+// infra-only deployment of the same user or team that is ready for it.
+func checkInfrastructure(req *services.DeploymentRequest) error {
+	if services.Mode(req) != services.ModeAppOnly {
+		return nil
+	}
+	status := deploymentManager.GetDeploymentStatus(req.InfraDeploymentID)
+	if status == nil || status.Username != req.Username || status.Organization != req.Organization || status.Team != req.Team {
+		return fmt.Errorf("infra deployment %s not found", req.InfraDeploymentID)
+	}
+	_, err := deploymentManager.Infrastructure(req.InfraDeploymentID)
+	return err
+}
+
+// ownInfrastructure turns an app-only request into a full one, for clones
+// and restores, which provision infrastructure of their own.
+func ownInfrastructure(req *services.DeploymentRequest) {
+	if services.Mode(req) == services.ModeAppOnly {
+		req.Mode = ""
+		req.InfraDeploymentID = ""
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Notifications"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+const (
+	healthCheckTimeout = 10 * time.Second
+
+	// healRecoveryWait is how long a remediation gets before the app is
+	// probed again.
+	healRecoveryWait   = 30 * time.Second
+	rebootRecoveryWait = 2 * time.Minute
+
+	restartServerScript = "supervisorctl restart django-server && supervisorctl status django-server"
+)
+
+var healthStore *store.HealthStore
+
+func healthCheckInterval() time.Duration {
+	if value := os.Getenv("HEALTH_CHECK_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval >= 10*time.Second {
+			return interval
+		}
+		log.Printf("Invalid HEALTH_CHECK_INTERVAL value %q, using default", value)
+	}
+	return time.Minute
+}
+
+// autoHealThreshold is the number of consecutive failed checks that trigger
+// remediation. Zero disables auto-heal; checks are still recorded.
+func autoHealThreshold() int {
+	if value := os.Getenv("AUTO_HEAL_THRESHOLD"); value != "" {
+		if threshold, err := strconv.Atoi(value); err == nil && threshold >= 0 {
+			return threshold
+		}
+		log.Printf("Invalid AUTO_HEAL_THRESHOLD value %q, using default", value)
+	}
+	return 3
+}
+
+func autoHealReboot() bool {
+	reboot, _ := strconv.ParseBool(os.Getenv("AUTO_HEAL_REBOOT"))
+	return reboot
+}
+
+// healthMonitor probes every completed deployment and remediates apps that
+// keep failing.
+type healthMonitor struct {
+	client    *http.Client
+	threshold int
+	reboot    bool
+
+	mux      sync.Mutex
+	failures map[string]int
+	// healing holds deployments with a remediation in progress, or that
+	// did not recover, so they are not remediated again until healthy.
+	healing map[string]bool
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{
+		client: &http.Client{
+			Timeout: healthCheckTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		threshold: autoHealThreshold(),
+		reboot:    autoHealReboot(),
+		failures:  make(map[string]int),
+		healing:   make(map[string]bool),
+	}
+}
+
+func runHealthMonitor(monitor *healthMonitor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var wg sync.WaitGroup
+		for _, deployment := range deploymentManager.ListDeployments() {
+			// Infra-only deployments serve no app to check.
+			if deployment.Status != "completed" || deployment.ArchivedAt != nil || deployment.PublicIP == "" || deployment.Mode == services.ModeInfraOnly {
+				continue
+			}
+			wg.Add(1)
+			go func(deployment DeploymentStatus) {
+				defer wg.Done()
+				monitor.check(&deployment)
+			}(deployment)
+		}
+		wg.Wait()
+	}
+}
+
+// probe requests the app's root page. Anything below 500 counts as up,
+// since apps commonly answer / with a redirect or a 404.
+func (m *healthMonitor) probe(publicIP string) store.HealthCheck {
+	check := store.HealthCheck{Time: time.Now()}
+
+	resp, err := m.client.Get("http://" + publicIP + "/")
+	check.LatencyMS = time.Since(check.Time).Milliseconds()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	check.OK = resp.StatusCode < http.StatusInternalServerError
+	if !check.OK {
+		check.Error = resp.Status
+	}
+	return check
+}
+
+func (m *healthMonitor) check(deployment *DeploymentStatus) {
+	check := m.probe(deployment.PublicIP)
+	if err := healthStore.Append(deployment.ID, check); err != nil {
+		log.Printf("Failed to record health check for %s: %v", deployment.ID, err)
+	}
+	deploymentManager.trackHealth(deployment.ID, check)
+
+	m.mux.Lock()
+	if check.OK {
+		previousFailures := m.failures[deployment.ID]
+		healing := m.healing[deployment.ID]
+		delete(m.failures, deployment.ID)
+		delete(m.healing, deployment.ID)
+		m.mux.Unlock()
+		if previousFailures > 0 {
+			message := fmt.Sprintf("Health check passed again after %d failed checks", previousFailures)
+			if healing {
+				message = "Application is healthy again"
+			}
+			healEvent(deployment.ID, "success", services.EventHealthcheckPassed, message,
+				map[string]interface{}{"status_code": check.StatusCode, "latency_ms": check.LatencyMS})
+		}
+		return
+	}
+	m.failures[deployment.ID]++
+	failures := m.failures[deployment.ID]
+	startHeal := m.threshold > 0 && failures >= m.threshold && !m.healing[deployment.ID]
+	if startHeal {
+		m.healing[deployment.ID] = true
+	}
+	m.mux.Unlock()
+
+	if failures == 1 {
+		healEvent(deployment.ID, "warn", services.EventHealthcheckFailed, fmt.Sprintf("Health check failed: %s", check.Error),
+			map[string]interface{}{"status_code": check.StatusCode, "error": check.Error})
+	}
+
+	if startHeal {
+		go m.heal(deployment, failures, check.Error)
+	}
+}
+
+func healLog(deploymentID, level, message string) {
+	healEvent(deploymentID, level, "", message, nil)
+}
+
+func healEvent(deploymentID, level, code, message string, data map[string]interface{}) {
+	deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+		Level:     level,
+		Message:   message,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "autoheal",
+		Code:      code,
+		Data:      data,
+	})
+}
+
+// heal restarts the app's server program and, if that is not enough and
+// AUTO_HEAL_REBOOT is set, reboots the VM. If the app still does not
+// respond the owner is alerted and the deployment is left alone until it
+// recovers.
+func (m *healthMonitor) heal(deployment *DeploymentStatus, failures int, lastError string) {
+	healEvent(deployment.ID, "warn", services.EventAutoHealStarted, fmt.Sprintf("Health check failed %d times in a row (%s), restarting the server program", failures, lastError),
+		map[string]interface{}{"failures": failures})
+
+	req, err := deploymentManager.Request(deployment.ID)
+	if err != nil {
+		req = &services.DeploymentRequest{Username: deployment.Username, RepoURL: deployment.RepoURL}
+	}
+
+	address := deployment.PublicIP
+	var azure *providers.AzureProvider
+	if req.Pooled {
+		output, err := vmPool.RestartApp(req)
+		if err != nil {
+			healLog(deployment.ID, "error", fmt.Sprintf("Failed to restart the server program: %v", err))
+		} else {
+			healLog(deployment.ID, "info", fmt.Sprintf("Server program restarted: %s", strings.TrimSpace(output)))
+		}
+	} else if azure, err = vmProvider(deployment); err != nil {
+		healLog(deployment.ID, "error", fmt.Sprintf("Cannot remediate: %v", err))
+	} else {
+		// A recreated VM can come back on a new address the app's
+		// ALLOWED_HOSTS does not know yet.
+		if current, err := azure.PublicIPAddress(); err == nil && current != address && !req.ScaleSet {
+			healLog(deployment.ID, "warn", fmt.Sprintf("VM address changed from %s to %s, updating allowed hosts", address, current))
+			if _, hosts, _, err := reconcileHosts(deployment, azure, req); err != nil {
+				healLog(deployment.ID, "error", err.Error())
+			} else {
+				address = current
+				healLog(deployment.ID, "info", fmt.Sprintf("Allowed hosts are now %s", strings.Join(hosts, ", ")))
+			}
+		}
+
+		unlock := providers.LockResourceGroup(deployment.ResourceGroup, "autoheal-"+deployment.ID, nil)
+		output, err := azure.RunShellScript(restartServerScript)
+		unlock()
+		if err != nil {
+			healLog(deployment.ID, "error", fmt.Sprintf("Failed to restart the server program: %v", err))
+		} else {
+			healLog(deployment.ID, "info", fmt.Sprintf("Server program restarted: %s", output))
+		}
+	}
+
+	time.Sleep(healRecoveryWait)
+	if m.probe(address).OK {
+		m.recovered(deployment.ID)
+		return
+	}
+
+	if m.reboot && azure != nil {
+		healLog(deployment.ID, "warn", "Application still unhealthy, rebooting the VM")
+		unlock := providers.LockResourceGroup(deployment.ResourceGroup, "autoheal-"+deployment.ID, nil)
+		err := azure.RestartVM()
+		unlock()
+		if err != nil {
+			healLog(deployment.ID, "error", fmt.Sprintf("Failed to reboot the VM: %v", err))
+		} else {
+			time.Sleep(rebootRecoveryWait)
+			if m.probe(address).OK {
+				m.recovered(deployment.ID)
+				return
+			}
+		}
+	}
+
+	healEvent(deployment.ID, "error", services.EventAutoHealFailed, "Application did not recover after automatic remediation", nil)
+	alertUnrecovered(deployment, lastError)
+}
+
+func (m *healthMonitor) recovered(deploymentID string) {
+	m.mux.Lock()
+	delete(m.failures, deploymentID)
+	delete(m.healing, deploymentID)
+	m.mux.Unlock()
+
+	healLog(deploymentID, "success", "Application recovered after automatic remediation")
+}
+
+// alertUnrecovered emails the deploying user that auto-heal gave up, if
+// they have a verified address.
+func alertUnrecovered(deployment *DeploymentStatus, lastError string) {
+	smtpConfig := notifications.SMTPConfigFromEnv()
+	if !smtpConfig.Enabled() {
+		return
+	}
+
+	user, err := userStore.Get(deployment.Username)
+	if err != nil || user == nil || !user.EmailVerified {
+		return
+	}
+
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("Deployment: %s\n", deployment.ID))
+	body.WriteString(fmt.Sprintf("Repository: %s\n", deployment.RepoURL))
+	body.WriteString(fmt.Sprintf("Application URL: http://%s\n", deployment.PublicIP))
+	body.WriteString(fmt.Sprintf("Last error: %s\n", lastError))
+	body.WriteString("\nThe application kept failing health checks and did not recover after an automatic restart.\n")
+	body.WriteString(fmt.Sprintf("Logs: %s/deploy/%s/logs/download\n", publicBaseURL(), deployment.ID))
+
+	subject := fmt.Sprintf("Deployment unhealthy: %s", deployment.ID)
+	if err := smtpConfig.SendEmail(user.Email, subject, body.String()); err != nil {
+		log.Printf("Failed to send health alert for %s: %v", deployment.ID, err)
+	}
+}
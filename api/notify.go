@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Notifications"
+)
+
+func publicBaseURL() string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// notifyDeploymentFinished emails the deploying user a summary once the
+// run has completed or failed, if they have a verified address.
+func notifyDeploymentFinished(deploymentID, publicIP string) {
+	smtpConfig := notifications.SMTPConfigFromEnv()
+	if !smtpConfig.Enabled() {
+		return
+	}
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		return
+	}
+
+	user, err := userStore.Get(status.Username)
+	if err != nil || user == nil || !user.EmailVerified {
+		return
+	}
+
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("Deployment: %s\n", status.ID))
+	body.WriteString(fmt.Sprintf("Repository: %s\n", status.RepoURL))
+	body.WriteString(fmt.Sprintf("Status: %s\n", status.Status))
+	if status.EndTime != nil {
+		body.WriteString(fmt.Sprintf("Duration: %s\n", status.EndTime.Sub(status.StartTime).Round(time.Second)))
+	}
+	if publicIP != "" {
+		body.WriteString(fmt.Sprintf("Application URL: http://%s\n", publicIP))
+	}
+	if status.Error != nil {
+		body.WriteString(fmt.Sprintf("Error: %v\n", status.Error))
+	}
+	body.WriteString(fmt.Sprintf("\nFull logs: %s/deploy/%s/logs/download\n", publicBaseURL(), status.ID))
+
+	subject := fmt.Sprintf("Deployment %s: %s", status.Status, status.ID)
+	if err := smtpConfig.SendEmail(user.Email, subject, body.String()); err != nil {
+		log.Printf("Failed to send deployment email for %s: %v", deploymentID, err)
+	}
+}
+
+// postWebhook posts payload as JSON to an operator's webhook, logging
+// rather than returning failures; kind names the notification in the log.
+func postWebhook(webhookURL, kind, deploymentID string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to marshal %s notification: %v", kind, err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Failed to post %s notification for %s: %v", kind, deploymentID, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,841 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// orgStore keeps organizations. They hold shared cloud credentials, so it
+// is a RequestStore sealed with REQUEST_STORE_KEY like the requests.
+var orgStore *store.RequestStore
+
+// orgMux serializes the read-modify-write of organization records.
+var orgMux sync.Mutex
+
+// Organization roles. Admins manage members, teams, quotas and credentials;
+// members deploy for and view the teams they belong to.
+const (
+	orgRoleAdmin  = "admin"
+	orgRoleMember = "member"
+)
+
+var orgNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,38}$`)
+
+// Organization groups users into teams that share cloud credentials and
+// deployments.
+type Organization struct {
+	Name    string                `json:"name"`
+	Members map[string]*OrgMember `json:"members"`
+	Teams   map[string]*Team      `json:"teams"`
+	// AzureCredentials are used by the organization's Azure and AKS
+	// deployments that set no azure_credentials of their own.
+	AzureCredentials *providers.AzureCredentials `json:"azure_credentials,omitempty"`
+	// OnFailure hooks run after every failed deployment of the
+	// organization, after the deployment's own.
+	OnFailure []services.LifecycleHook `json:"on_failure,omitempty"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+// OrgMember is a user's membership. TokenHash is the SHA-256 of the
+// member's API token; the token itself is only returned when issued.
+type OrgMember struct {
+	Role      string    `json:"role"`
+	TokenHash string    `json:"token_hash"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// Team is a group of members whose deployments are visible to each other
+// and limited by a shared quota.
+type Team struct {
+	Members   []string  `json:"members"`
+	Quota     TeamQuota `json:"quota"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamQuota limits a team's deployments. Zero means no limit.
+type TeamQuota struct {
+	// MaxDeployments caps the team's deployments that are queued, running
+	// or completed and not archived.
+	MaxDeployments int `json:"max_deployments,omitempty"`
+	// MaxMonthlyBudget caps the estimated monthly cost of each of the
+	// team's deployments, like USER_MONTHLY_BUDGETS does for a user.
+	MaxMonthlyBudget float64 `json:"max_monthly_budget,omitempty"`
+}
+
+func (t *Team) hasMember(username string) bool {
+	for _, member := range t.Members {
+		if member == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Team) removeMember(username string) {
+	members := t.Members[:0]
+	for _, member := range t.Members {
+		if member != username {
+			members = append(members, member)
+		}
+	}
+	t.Members = members
+}
+
+// getOrganization returns the organization, or nil if there is none.
+func getOrganization(name string) (*Organization, error) {
+	if !orgNamePattern.MatchString(name) {
+		return nil, nil
+	}
+	var org Organization
+	if err := orgStore.Get(name, &org); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// memberByToken returns the member the bearer token was issued to.
+func (org *Organization) memberByToken(token string) (string, *OrgMember) {
+	if token == "" {
+		return "", nil
+	}
+	hash := hashToken(token)
+	for username, member := range org.Members {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(member.TokenHash)) == 1 {
+			return username, member
+		}
+	}
+	return "", nil
+}
+
+// bearerToken returns the token of a "Bearer" Authorization header, or ""
+// if the request has none.
+func bearerToken(c *gin.Context) string {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return ""
+	}
+	return token
+}
+
+// orgCaller is who a request to an organization comes from.
+type orgCaller struct {
+	Username string
+	Role     string
+	// Teams are the teams the caller's SSO groups put them on, besides
+	// those the organization lists them on.
+	Teams map[string]bool
+}
+
+func (o *orgCaller) onTeam(org *Organization, teamName string) bool {
+	team, exists := org.Teams[teamName]
+	return exists && (o.Teams[teamName] || team.hasMember(o.Username))
+}
+
+// orgCallerFor authenticates the request by a member token or an SSO
+// session, returning nil if it is neither.
+func orgCallerFor(c *gin.Context, org *Organization) *orgCaller {
+	if username, member := org.memberByToken(bearerToken(c)); member != nil {
+		return &orgCaller{Username: username, Role: member.Role}
+	}
+	if session := requestSession(c); session != nil {
+		return sessionOrgCaller(session, org)
+	}
+	return nil
+}
+
+// requireOrgMember loads the organization named in the path and
+// authenticates the caller by their member token or SSO session. With
+// admin set, only admins are let through. Handlers read the organization
+// and the caller from the "org" and "org_caller" keys.
+func requireOrgMember(admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, err := getOrganization(c.Param("org"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if org == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		caller := orgCallerFor(c, org)
+		if caller == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid organization token"})
+			return
+		}
+		if admin && caller.Role != orgRoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Organization admin role required"})
+			return
+		}
+		c.Set("org", org)
+		c.Set("org_caller", caller)
+		c.Next()
+	}
+}
+
+// updateOrganization reloads the organization under orgMux, applies update
+// and saves it, so concurrent changes are not lost.
+func updateOrganization(name string, update func(org *Organization) error) (*Organization, error) {
+	orgMux.Lock()
+	defer orgMux.Unlock()
+
+	org, err := getOrganization(name)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errOrganizationNotFound
+	}
+	if err := update(org); err != nil {
+		return nil, err
+	}
+	if err := orgStore.Save(org.Name, org); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+var errOrganizationNotFound = errors.New("organization not found")
+
+// orgError is an update that failed for a reason the caller can fix.
+type orgError struct {
+	status  int
+	message string
+}
+
+func (e *orgError) Error() string { return e.message }
+
+func respondOrgError(c *gin.Context, err error) {
+	var oerr *orgError
+	switch {
+	case errors.As(err, &oerr):
+		c.JSON(oerr.status, gin.H{"error": oerr.message})
+	case errors.Is(err, errOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// teamUsage counts the team's deployments that hold resources: those not
+// failed and not archived.
+func teamUsage(orgName, teamName string) int {
+	count := 0
+	for _, deployment := range deploymentManager.ListDeployments() {
+		if deployment.Organization == orgName && deployment.Team == teamName &&
+			deployment.Status != "failed" && deployment.ArchivedAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func orgSummary(org *Organization) gin.H {
+	members := make([]gin.H, 0, len(org.Members))
+	for username, member := range org.Members {
+		var teams []string
+		for teamName, team := range org.Teams {
+			if team.hasMember(username) {
+				teams = append(teams, teamName)
+			}
+		}
+		sort.Strings(teams)
+		members = append(members, gin.H{
+			"username":  username,
+			"role":      member.Role,
+			"teams":     teams,
+			"joined_at": member.JoinedAt.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i]["username"].(string) < members[j]["username"].(string)
+	})
+
+	teams := make([]gin.H, 0, len(org.Teams))
+	for teamName, team := range org.Teams {
+		teams = append(teams, gin.H{
+			"name":        teamName,
+			"members":     team.Members,
+			"quota":       team.Quota,
+			"deployments": teamUsage(org.Name, teamName),
+		})
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i]["name"].(string) < teams[j]["name"].(string)
+	})
+
+	credentials := providers.AzureAuthCLI
+	if org.AzureCredentials != nil {
+		credentials = org.AzureCredentials.Mode()
+	}
+	return gin.H{
+		"name":              org.Name,
+		"members":           members,
+		"teams":             teams,
+		"azure_credentials": credentials,
+		"on_failure":        org.OnFailure,
+		"created_at":        org.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// handleCreateOrganization creates an organization with the signed-in
+// caller as its first admin and returns their member token. Server admins
+// may name another registered user as the admin instead.
+func handleCreateOrganization(c *gin.Context) {
+	var body struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	if !orgNamePattern.MatchString(body.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-39 lowercase letters, digits or hyphens"})
+		return
+	}
+	session, admin := requestSession(c), isAdminRequest(c)
+	if session == nil && !admin {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in to create an organization"})
+		return
+	}
+	if body.Username == "" && session != nil {
+		body.Username = session.Username
+	}
+	if !admin && body.Username != session.Username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only make yourself the admin of a new organization"})
+		return
+	}
+	user, err := userStore.Get(body.Username)
+	if err != nil || user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must be a registered user"})
+		return
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgMux.Lock()
+	defer orgMux.Unlock()
+
+	existing, err := getOrganization(body.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Organization already exists"})
+		return
+	}
+
+	now := time.Now()
+	org := &Organization{
+		Name: body.Name,
+		Members: map[string]*OrgMember{
+			user.Username: {Role: orgRoleAdmin, TokenHash: hashToken(token), JoinedAt: now},
+		},
+		Teams:     map[string]*Team{},
+		CreatedAt: now,
+	}
+	if err := orgStore.Save(org.Name, org); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Organization %s created by %s", org.Name, user.Username)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"organization": orgSummary(org),
+		"username":     user.Username,
+		"token":        token,
+	})
+}
+
+func handleGetOrganization(c *gin.Context) {
+	c.JSON(http.StatusOK, orgSummary(c.MustGet("org").(*Organization)))
+}
+
+// handleSetOrgMember adds a registered user to the organization or changes
+// their role. New members, and existing ones with rotate_token set, get a
+// token, which is only shown in this response.
+func handleSetOrgMember(c *gin.Context) {
+	orgName, username := c.Param("org"), c.Param("username")
+
+	var body struct {
+		Role        string `json:"role"`
+		RotateToken bool   `json:"rotate_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+			return
+		}
+	}
+	if body.Role != "" && body.Role != orgRoleAdmin && body.Role != orgRoleMember {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be admin or member"})
+		return
+	}
+	user, err := userStore.Get(username)
+	if err != nil || user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must be a registered user"})
+		return
+	}
+
+	token := ""
+	org, err := updateOrganization(orgName, func(org *Organization) error {
+		member, exists := org.Members[username]
+		if !exists {
+			member = &OrgMember{Role: orgRoleMember, JoinedAt: time.Now()}
+			org.Members[username] = member
+		}
+		if body.Role != "" {
+			if member.Role == orgRoleAdmin && body.Role != orgRoleAdmin && org.admins() == 1 {
+				return &orgError{http.StatusConflict, "An organization needs at least one admin"}
+			}
+			member.Role = body.Role
+		}
+		if !exists || body.RotateToken {
+			var err error
+			if token, err = newVerificationToken(); err != nil {
+				return err
+			}
+			member.TokenHash = hashToken(token)
+		}
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	response := gin.H{
+		"success":  true,
+		"username": username,
+		"role":     org.Members[username].Role,
+	}
+	if token != "" {
+		response["token"] = token
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (org *Organization) admins() int {
+	count := 0
+	for _, member := range org.Members {
+		if member.Role == orgRoleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+// handleRemoveOrgMember removes a member from the organization and its
+// teams. Their deployments stay with the team.
+func handleRemoveOrgMember(c *gin.Context) {
+	orgName, username := c.Param("org"), c.Param("username")
+
+	_, err := updateOrganization(orgName, func(org *Organization) error {
+		member, exists := org.Members[username]
+		if !exists {
+			return &orgError{http.StatusNotFound, "Member not found"}
+		}
+		if member.Role == orgRoleAdmin && org.admins() == 1 {
+			return &orgError{http.StatusConflict, "An organization needs at least one admin"}
+		}
+		delete(org.Members, username)
+		for _, team := range org.Teams {
+			team.removeMember(username)
+		}
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "username": username})
+}
+
+// handleSetOrgCredentials sets the Azure credentials the organization's
+// deployments use by default.
+func handleSetOrgCredentials(c *gin.Context) {
+	var credentials providers.AzureCredentials
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	if err := credentials.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if credentials.Mode() == providers.AzureAuthCLI {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credentials must set client_secret or managed_identity"})
+		return
+	}
+
+	_, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		org.AzureCredentials = &credentials
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "azure_credentials": credentials.Mode()})
+}
+
+// handleDeleteOrgCredentials returns the organization to the server's own
+// Azure credentials.
+func handleDeleteOrgCredentials(c *gin.Context) {
+	_, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		org.AzureCredentials = nil
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "azure_credentials": providers.AzureAuthCLI})
+}
+
+// handleSetOrgFailureHooks replaces the organization's on_failure hooks,
+// like a ticket webhook or a cost alert script.
+func handleSetOrgFailureHooks(c *gin.Context) {
+	var body struct {
+		Hooks []services.LifecycleHook `json:"hooks"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	for i := range body.Hooks {
+		if body.Hooks[i].Stage == "" {
+			body.Hooks[i].Stage = services.HookOnFailure
+		}
+		if body.Hooks[i].Stage != services.HookOnFailure {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("hooks[%d]: organization hooks must be on_failure hooks", i)})
+			return
+		}
+	}
+	if err := validateHooks(body.Hooks); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		org.OnFailure = body.Hooks
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "on_failure": body.Hooks})
+}
+
+// withOrgFailureHooks returns req with its organization's on_failure hooks
+// after its own. req is left alone, so they are not stored with it and
+// changes to them apply to retries.
+func withOrgFailureHooks(req *services.DeploymentRequest) *services.DeploymentRequest {
+	if req.Organization == "" {
+		return req
+	}
+	org, err := getOrganization(req.Organization)
+	if err != nil {
+		log.Printf("Failed to load organization %s: %v", req.Organization, err)
+		return req
+	}
+	if org == nil || len(org.OnFailure) == 0 {
+		return req
+	}
+	merged := *req
+	merged.Hooks = append(append([]services.LifecycleHook(nil), req.Hooks...), org.OnFailure...)
+	return &merged
+}
+
+// handleSetTeam creates a team or sets its quota.
+func handleSetTeam(c *gin.Context) {
+	teamName := c.Param("team")
+	if !orgNamePattern.MatchString(teamName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "team name must be 1-39 lowercase letters, digits or hyphens"})
+		return
+	}
+
+	var body struct {
+		Quota *TeamQuota `json:"quota"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+			return
+		}
+	}
+	if body.Quota != nil && (body.Quota.MaxDeployments < 0 || body.Quota.MaxMonthlyBudget < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quota limits cannot be negative"})
+		return
+	}
+
+	org, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		team, exists := org.Teams[teamName]
+		if !exists {
+			team = &Team{Members: []string{}, CreatedAt: time.Now()}
+			org.Teams[teamName] = team
+		}
+		if body.Quota != nil {
+			team.Quota = *body.Quota
+		}
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"team":    teamName,
+		"members": org.Teams[teamName].Members,
+		"quota":   org.Teams[teamName].Quota,
+	})
+}
+
+// handleDeleteTeam deletes a team. Its deployments keep running but no
+// longer count against a quota.
+func handleDeleteTeam(c *gin.Context) {
+	teamName := c.Param("team")
+
+	_, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		if _, exists := org.Teams[teamName]; !exists {
+			return &orgError{http.StatusNotFound, "Team not found"}
+		}
+		delete(org.Teams, teamName)
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "team": teamName})
+}
+
+func handleAddTeamMember(c *gin.Context) {
+	teamName, username := c.Param("team"), c.Param("username")
+
+	org, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		team, exists := org.Teams[teamName]
+		if !exists {
+			return &orgError{http.StatusNotFound, "Team not found"}
+		}
+		if _, member := org.Members[username]; !member {
+			return &orgError{http.StatusBadRequest, "username must be a member of the organization"}
+		}
+		if !team.hasMember(username) {
+			team.Members = append(team.Members, username)
+			sort.Strings(team.Members)
+		}
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "team": teamName, "members": org.Teams[teamName].Members})
+}
+
+func handleRemoveTeamMember(c *gin.Context) {
+	teamName, username := c.Param("team"), c.Param("username")
+
+	org, err := updateOrganization(c.Param("org"), func(org *Organization) error {
+		team, exists := org.Teams[teamName]
+		if !exists {
+			return &orgError{http.StatusNotFound, "Team not found"}
+		}
+		if !team.hasMember(username) {
+			return &orgError{http.StatusNotFound, "Member not found"}
+		}
+		team.removeMember(username)
+		return nil
+	})
+	if err != nil {
+		respondOrgError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "team": teamName, "members": org.Teams[teamName].Members})
+}
+
+// handleTeamDeployments lists a team's deployments to its members and the
+// organization's admins.
+func handleTeamDeployments(c *gin.Context) {
+	org := c.MustGet("org").(*Organization)
+	caller := c.MustGet("org_caller").(*orgCaller)
+	teamName := c.Param("team")
+
+	team, exists := org.Teams[teamName]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+	if !caller.onTeam(org, teamName) && caller.Role != orgRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this team"})
+		return
+	}
+	includeArchived := c.Query("include_archived") == "true"
+
+	response := []gin.H{}
+	for _, deployment := range deploymentManager.ListDeployments() {
+		if deployment.Organization != org.Name || deployment.Team != teamName {
+			continue
+		}
+		if deployment.ArchivedAt != nil && !includeArchived {
+			continue
+		}
+		response = append(response, deploymentSummary(deployment))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org.Name,
+		"team":         teamName,
+		"quota":        team.Quota,
+		"usage":        teamUsage(org.Name, teamName),
+		"deployments":  response,
+		"total":        len(response),
+	})
+}
+
+// authorizeTeamDeployment checks that the caller of a deployment for a team
+// is an admin, or req.Username, by their member token or SSO session, on
+// the team, and fills in the organization's shared credentials. It returns
+// the status to respond with on failure.
+func authorizeTeamDeployment(c *gin.Context, req *services.DeploymentRequest) (int, error) {
+	if req.Organization == "" {
+		return 0, nil
+	}
+	org, err := getOrganization(req.Organization)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if org == nil {
+		return http.StatusNotFound, fmt.Errorf("organization %s not found", req.Organization)
+	}
+	admin := isAdminRequest(c)
+	caller := orgCallerFor(c, org)
+	if !admin && (caller == nil || caller.Username != req.Username) {
+		return http.StatusUnauthorized, fmt.Errorf("deploying for an organization requires an admin, the organization token or a session of %s", req.Username)
+	}
+	if _, exists := org.Teams[req.Team]; !exists {
+		return http.StatusNotFound, fmt.Errorf("team %s not found in organization %s", req.Team, org.Name)
+	}
+	if !admin && !caller.onTeam(org, req.Team) {
+		return http.StatusForbidden, fmt.Errorf("%s is not a member of team %s", caller.Username, req.Team)
+	}
+
+	cloud := services.Cloud(req)
+	if req.AzureCredentials == nil && org.AzureCredentials != nil && !req.Pooled &&
+		(cloud == services.CloudAzure || cloud == services.CloudAKS) {
+		credentials := *org.AzureCredentials
+		req.AzureCredentials = &credentials
+	}
+	return 0, nil
+}
+
+// authorizeTeamChange runs authorizeTeamDeployment for a change to an
+// existing deployment, responding with the error if the caller could not
+// have deployed it.
+func authorizeTeamChange(c *gin.Context, req *services.DeploymentRequest) bool {
+	if status, err := authorizeTeamDeployment(c, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// authorizeDeploymentChange runs authorizeTeamChange for a change to a
+// deployment that does not need its stored request.
+func authorizeDeploymentChange(c *gin.Context, status *DeploymentStatus) bool {
+	return authorizeTeamChange(c, &services.DeploymentRequest{
+		Username:     status.Username,
+		Organization: status.Organization,
+		Team:         status.Team,
+	})
+}
+
+// authorizeDeploymentOwner is authorizeDeploymentChange, but a deployment
+// outside an organization also needs an admin or its owner's session.
+func authorizeDeploymentOwner(c *gin.Context, status *DeploymentStatus) bool {
+	if status.Organization != "" {
+		return authorizeDeploymentChange(c, status)
+	}
+	if _, ok := deploymentCaller(c, status); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Requires an admin or a session of " + status.Username})
+		return false
+	}
+	return true
+}
+
+// authorizeRequestOwner is authorizeDeploymentOwner for a change that needs
+// the deployment's stored request, whose team's credentials it fills in as
+// authorizeTeamChange does.
+func authorizeRequestOwner(c *gin.Context, status *DeploymentStatus, req *services.DeploymentRequest) bool {
+	if req.Organization != "" {
+		return authorizeTeamChange(c, req)
+	}
+	return authorizeDeploymentOwner(c, status)
+}
+
+// teamQuota returns the quota of the request's team, or nil if it has none.
+func teamQuota(req *services.DeploymentRequest) *TeamQuota {
+	if req.Organization == "" {
+		return nil
+	}
+	org, err := getOrganization(req.Organization)
+	if err != nil {
+		log.Printf("Failed to load organization %s: %v", req.Organization, err)
+		return nil
+	}
+	if org == nil {
+		return nil
+	}
+	if team, exists := org.Teams[req.Team]; exists {
+		return &team.Quota
+	}
+	return nil
+}
+
+// checkTeamQuota rejects a deployment that would take its team over the
+// team's max_deployments.
+func checkTeamQuota(req *services.DeploymentRequest) error {
+	quota := teamQuota(req)
+	if quota == nil || quota.MaxDeployments == 0 {
+		return nil
+	}
+	if usage := teamUsage(req.Organization, req.Team); usage >= quota.MaxDeployments {
+		return fmt.Errorf("team %s already has %d of its %d deployments; archive one to deploy another", req.Team, usage, quota.MaxDeployments)
+	}
+	return nil
+}
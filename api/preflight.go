@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// handlePreflight checks a deployment request without deploying anything:
+// it merges in the repository's djangovpc.yaml, validates the request,
+// estimates its cost against the budget and statically analyzes the
+// repository for deployment blockers.
+func handlePreflight(c *gin.Context) {
+	var req services.DeploymentRequest
+	if err := bindDeploymentRequest(c, &req); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	spec, err := applyProjectSpec(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRequest(&req); err != nil {
+		if code, details, ok := limitDetails(err); ok {
+			c.JSON(code, details)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"timestamp": time.Now().Format(time.RFC3339)}
+	if spec != nil {
+		response["spec"] = spec
+	}
+	ready := true
+
+	estimate, err := checkBudget(c, &req)
+	if err != nil {
+		ready = false
+		response["budget_error"] = err.Error()
+	}
+	if estimate == nil {
+		estimate, _ = services.EstimateMonthlyCost(&req)
+	}
+	response["estimate"] = estimate
+
+	analysis, err := services.NewDeploymentService().AnalyzeRepository(&req)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	response["analysis"] = analysis
+
+	// One list of what djangovpc.yaml and the code require, as the
+	// deployment itself will check.
+	required := append(append([]string(nil), req.RequiredEnv...), analysis.MissingEnv...)
+	if missing := services.MissingEnv(&req, required); len(missing) > 0 {
+		ready = false
+		response["missing_env"] = missing
+	}
+	response["ready"] = ready && analysis.Ready()
+
+	c.JSON(http.StatusOK, response)
+}
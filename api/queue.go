@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+type deploymentJob struct {
+	ID         string                      `json:"deployment_id"`
+	Request    *services.DeploymentRequest `json:"-"`
+	Username   string                      `json:"username"`
+	RepoURL    string                      `json:"repo_url"`
+	Priority   string                      `json:"priority"`
+	EnqueuedAt time.Time                   `json:"enqueued_at"`
+	StartedAt  *time.Time                  `json:"started_at,omitempty"`
+	// Parked is set while the job waits for approval without a worker.
+	Parked bool `json:"parked,omitempty"`
+	// Run, if set, is what the job does instead of a deployment's
+	// pipeline: a redeploy, rollback or destroy of a finished deployment.
+	Run func() `json:"-"`
+}
+
+// enqueueOperation queues an operation on a finished deployment with the
+// priority of its request, so it waits its turn for a worker like a
+// deployment does.
+func enqueueOperation(deploymentID string, req *services.DeploymentRequest, run func()) {
+	deploymentQueue.Enqueue(&deploymentJob{
+		ID:       deploymentID,
+		Request:  req,
+		Username: req.Username,
+		RepoURL:  req.RepoURL,
+		Priority: services.Priority(req),
+		Run:      run,
+	})
+}
+
+// DeploymentQueue runs deployments on a fixed number of workers so a burst
+// of requests can't start an unbounded number of terraform/ansible processes.
+// Free workers take the highest priority class first and, within a class,
+// the deployment of the user with the fewest running, so one user's burst
+// of previews does not hold every worker. A deployment waiting for approval
+// of its plan parks, giving its worker up until it is approved.
+type DeploymentQueue struct {
+	mux     sync.Mutex
+	cond    *sync.Cond
+	pending []*deploymentJob
+	active  map[string]*deploymentJob
+	workers int
+	// busy counts the active jobs holding a worker, which parked ones do
+	// not. resuming counts the parked jobs waiting to get one back, which
+	// they do before pending jobs start.
+	busy     int
+	resuming int
+	// aging is how long a deployment waits to be ranked a class higher.
+	aging time.Duration
+	run   func(*deploymentJob)
+}
+
+func NewDeploymentQueue(workers int, aging time.Duration, run func(*deploymentJob)) *DeploymentQueue {
+	q := &DeploymentQueue{
+		active:  make(map[string]*deploymentJob),
+		workers: workers,
+		aging:   aging,
+		run:     run,
+	}
+	q.cond = sync.NewCond(&q.mux)
+
+	go q.dispatch()
+	return q
+}
+
+func queueWorkers() int {
+	if value := os.Getenv("DEPLOY_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			return workers
+		}
+		log.Printf("Invalid DEPLOY_WORKERS value %q, using default", value)
+	}
+	return 4
+}
+
+// queueAging is how long a queued deployment waits before it is ranked one
+// priority class higher, so low priority deployments still run under a
+// steady stream of others, 10 minutes unless QUEUE_PRIORITY_AGING says
+// otherwise. Zero disables aging.
+func queueAging() time.Duration {
+	if value := os.Getenv("QUEUE_PRIORITY_AGING"); value != "" {
+		if value == "0" {
+			return 0
+		}
+		if aging, err := time.ParseDuration(value); err == nil && aging >= time.Minute {
+			return aging
+		}
+		log.Printf("Invalid QUEUE_PRIORITY_AGING value %q, using default", value)
+	}
+	return 10 * time.Minute
+}
+
+func priorityRank(priority string) int {
+	switch priority {
+	case services.PriorityHigh:
+		return 2
+	case services.PriorityLow:
+		return 0
+	}
+	return 1
+}
+
+// rank returns the job's priority class at now, raised for the time it has
+// waited.
+func (q *DeploymentQueue) rank(job *deploymentJob, now time.Time) int {
+	rank := priorityRank(job.Priority)
+	if q.aging > 0 {
+		rank += int(now.Sub(job.EnqueuedAt) / q.aging)
+	}
+	return min(rank, priorityRank(services.PriorityHigh))
+}
+
+// next returns the index of the job in pending to run first: the highest
+// ranked, then the one whose user has the fewest deployments running, then
+// the longest waiting. running counts the running deployments by user.
+func (q *DeploymentQueue) next(pending []*deploymentJob, running map[string]int, now time.Time) int {
+	best := 0
+	for i, job := range pending[1:] {
+		bestRank, rank := q.rank(pending[best], now), q.rank(job, now)
+		if rank > bestRank || rank == bestRank && running[job.Username] < running[pending[best].Username] {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+// running counts the active deployments by user. Callers must hold mux.
+func (q *DeploymentQueue) running() map[string]int {
+	running := make(map[string]int)
+	for _, job := range q.active {
+		running[job.Username]++
+	}
+	return running
+}
+
+func (q *DeploymentQueue) Enqueue(job *deploymentJob) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	job.EnqueuedAt = time.Now()
+	q.pending = append(q.pending, job)
+	q.cond.Broadcast()
+
+	log.Printf("Deployment %s queued with %s priority (pending: %d, active: %d)", job.ID, job.Priority, len(q.pending), len(q.active))
+}
+
+// Remove drops a job that has not started yet. It reports whether the job
+// was found in the pending list.
+func (q *DeploymentQueue) Remove(deploymentID string) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	for i, job := range q.pending {
+		if job.ID == deploymentID {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns copies of the pending jobs, in the order they would
+// start if nothing else were queued, and of the active jobs.
+func (q *DeploymentQueue) Snapshot() ([]deploymentJob, []deploymentJob) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	now := time.Now()
+	running := q.running()
+	remaining := append([]*deploymentJob(nil), q.pending...)
+	pending := make([]deploymentJob, 0, len(q.pending))
+	for len(remaining) > 0 {
+		i := q.next(remaining, running, now)
+		pending = append(pending, *remaining[i])
+		running[remaining[i].Username]++
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+
+	active := make([]deploymentJob, 0, len(q.active))
+	for _, job := range q.active {
+		active = append(active, *job)
+	}
+	return pending, active
+}
+
+// Park gives up the worker of a running deployment while it waits for
+// approval, so a deployment waiting on a human does not hold up others.
+func (q *DeploymentQueue) Park(deploymentID string) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	job, exists := q.active[deploymentID]
+	if !exists || job.Parked {
+		return
+	}
+	job.Parked = true
+	q.busy--
+	q.cond.Broadcast()
+}
+
+// Resume waits for a worker for a parked deployment, ahead of the pending
+// ones.
+func (q *DeploymentQueue) Resume(deploymentID string) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	job, exists := q.active[deploymentID]
+	if !exists || !job.Parked {
+		return
+	}
+	q.resuming++
+	for q.busy >= q.workers {
+		q.cond.Wait()
+	}
+	q.resuming--
+	job.Parked = false
+	q.busy++
+	q.cond.Broadcast()
+}
+
+// dispatch starts the next pending job whenever a worker is free.
+func (q *DeploymentQueue) dispatch() {
+	for {
+		q.mux.Lock()
+		for len(q.pending) == 0 || q.busy >= q.workers || q.resuming > 0 {
+			q.cond.Wait()
+		}
+		i := q.next(q.pending, q.running(), time.Now())
+		job := q.pending[i]
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		now := time.Now()
+		job.StartedAt = &now
+		q.active[job.ID] = job
+		q.busy++
+		q.mux.Unlock()
+
+		go q.execute(job)
+	}
+}
+
+func (q *DeploymentQueue) execute(job *deploymentJob) {
+	if job.Run != nil {
+		job.Run()
+	} else {
+		q.run(job)
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	delete(q.active, job.ID)
+	if !job.Parked {
+		q.busy--
+	}
+	q.cond.Broadcast()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+func TestDeploymentQueueNext(t *testing.T) {
+	type queued struct {
+		user     string
+		priority string
+		waited   time.Duration
+	}
+	tests := []struct {
+		name    string
+		aging   time.Duration
+		pending []queued
+		running map[string]int
+		want    int
+	}{
+		{
+			name:    "higher class first",
+			pending: []queued{{"alice", services.PriorityLow, 0}, {"bob", services.PriorityNormal, 0}, {"carol", services.PriorityHigh, 0}},
+			want:    2,
+		},
+		{
+			name:    "longest waiting within a class",
+			pending: []queued{{"alice", services.PriorityNormal, 2 * time.Minute}, {"bob", services.PriorityNormal, time.Minute}},
+			want:    0,
+		},
+		{
+			name:    "aged low outranks fresh normal",
+			aging:   10 * time.Minute,
+			pending: []queued{{"alice", services.PriorityNormal, 0}, {"bob", services.PriorityLow, 25 * time.Minute}},
+			want:    1,
+		},
+		{
+			name:    "aging below one period changes nothing",
+			aging:   10 * time.Minute,
+			pending: []queued{{"alice", services.PriorityLow, 9 * time.Minute}, {"bob", services.PriorityNormal, 0}},
+			want:    1,
+		},
+		{
+			name:    "aging is capped at high",
+			aging:   10 * time.Minute,
+			pending: []queued{{"alice", services.PriorityLow, time.Hour}, {"bob", services.PriorityHigh, 0}},
+			want:    0,
+		},
+		{
+			name:    "no aging when disabled",
+			pending: []queued{{"alice", services.PriorityLow, time.Hour}, {"bob", services.PriorityNormal, 0}},
+			want:    1,
+		},
+		{
+			name:    "user with fewer running first",
+			pending: []queued{{"alice", services.PriorityNormal, time.Minute}, {"bob", services.PriorityNormal, 0}},
+			running: map[string]int{"alice": 2},
+			want:    1,
+		},
+		{
+			name:    "fairness does not override class",
+			pending: []queued{{"alice", services.PriorityHigh, 0}, {"bob", services.PriorityNormal, time.Minute}},
+			running: map[string]int{"alice": 3},
+			want:    0,
+		},
+	}
+
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &DeploymentQueue{aging: tt.aging}
+			pending := make([]*deploymentJob, len(tt.pending))
+			for i, job := range tt.pending {
+				pending[i] = &deploymentJob{ID: job.user, Username: job.user, Priority: job.priority, EnqueuedAt: now.Add(-job.waited)}
+			}
+			running := tt.running
+			if running == nil {
+				running = map[string]int{}
+			}
+			if got := q.next(pending, running, now); got != tt.want {
+				t.Fatalf("next = %d (%s), want %d (%s)", got, pending[got].ID, tt.want, pending[tt.want].ID)
+			}
+		})
+	}
+}
+
+// TestDeploymentQueueParkResume checks that a parked deployment gives its
+// worker to a pending one and, when resumed, gets it back before the
+// deployments still pending.
+func TestDeploymentQueueParkResume(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	q := NewDeploymentQueue(1, 0, func(*deploymentJob) {})
+	started := make(chan string, 3)
+	release := map[string]chan struct{}{}
+	enqueue := func(id string) {
+		done := make(chan struct{})
+		release[id] = done
+		q.Enqueue(&deploymentJob{ID: id, Username: id, Priority: services.PriorityNormal, Run: func() {
+			started <- id
+			<-done
+		}})
+	}
+	expectStart := func(want string) {
+		t.Helper()
+		select {
+		case id := <-started:
+			if id != want {
+				t.Fatalf("%s started, want %s", id, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not start", want)
+		}
+	}
+	expectNoStart := func() {
+		t.Helper()
+		select {
+		case id := <-started:
+			t.Fatalf("%s started without a free worker", id)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	enqueue("a")
+	expectStart("a")
+	enqueue("b")
+	expectNoStart()
+
+	q.Park("a")
+	expectStart("b")
+	enqueue("c")
+
+	resumed := make(chan struct{})
+	go func() {
+		q.Resume("a")
+		close(resumed)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("a resumed while b held the only worker")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release["b"])
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a did not resume after b finished")
+	}
+	expectNoStart()
+
+	close(release["a"])
+	expectStart("c")
+	close(release["c"])
+}
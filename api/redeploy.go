@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// BeginAppUpdate marks a finished deployment with status, "redeploying" or
+// "rolling_back", while its app is updated in place, so no other operation
+// starts on it meanwhile. A failed one can be updated too, e.g. to fix what
+// failed it. Its end time and error are those of its deployment run and
+// are kept.
+func (dm *DeploymentManager) BeginAppUpdate(deploymentID, status string) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists {
+		return errDeploymentNotFound
+	}
+	if deployment.Status != "completed" && deployment.Status != "failed" {
+		return fmt.Errorf("deployment is %s", deployment.Status)
+	}
+	deployment.PriorStatus = deployment.Status
+	deployment.Status = status
+	dm.persist(deployment)
+	return nil
+}
+
+// EndAppUpdate returns a deployment marked with status by BeginAppUpdate to
+// the status it had before. It reports false if the deployment was
+// finalized meanwhile.
+func (dm *DeploymentManager) EndAppUpdate(deploymentID, status string) bool {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists || deployment.Status != status {
+		return false
+	}
+	deployment.Status = deployment.PriorStatus
+	deployment.PriorStatus = ""
+	dm.persist(deployment)
+	return true
+}
+
+// handleRedeployDeployment updates a deployment's app to the latest commit
+// of its repository on the VM it already runs on, without Terraform: it
+// pulls the code, installs requirements, migrates, collects static files
+// and restarts the app. A Kubernetes deployment's app is built into a new
+// image instead and rolled out by its digest. The redeploy waits in the
+// deployment queue at its request's priority, then streams its progress on
+// the deployment's log stream like a deployment does.
+func handleRedeployDeployment(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+	_, req, infra, ok := appUpdateTarget(c, deploymentID, "redeployed")
+	if !ok {
+		return
+	}
+	if !beginAppUpdate(c, deploymentID, "redeploying") {
+		return
+	}
+
+	enqueueOperation(deploymentID, req, func() {
+		runAppUpdate(deploymentID, "redeploying", appUpdate{
+			step:      "redeploy",
+			name:      "Redeploy",
+			started:   services.EventRedeployStarted,
+			succeeded: services.EventRedeploySucceeded,
+			failed:    services.EventRedeployFailed,
+			message:   "Redeploying the app...",
+			done:      "App redeployed",
+		}, infra.PublicIP, func() (string, error) {
+			if services.Cloud(req) == services.CloudAKS {
+				return services.NewDeploymentService().RedeployKubernetes(req, infra.TerraformDir, deploymentManager, deploymentID)
+			}
+			return services.NewDeploymentService().Redeploy(req, infra.PublicIP, infra.TerraformDir, deploymentManager, deploymentID)
+		})
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment_id": deploymentID,
+		"status":        "redeploying",
+		"logs_url":      fmt.Sprintf("/deploy/%s/logs", deploymentID),
+	})
+}
+
+// appUpdateTarget looks up a deployment whose app can be updated in place,
+// its request and the VM the app runs on, responding with the error if
+// there is none or the caller is not its team, or for a personal deployment
+// its owner or an admin. action names the update in the error, e.g.
+// "redeployed".
+func appUpdateTarget(c *gin.Context, deploymentID, action string) (*DeploymentStatus, *services.DeploymentRequest, *services.Infrastructure, bool) {
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return nil, nil, nil, false
+	}
+	req, err := deploymentManager.Request(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return nil, nil, nil, false
+	}
+	if !authorizeRequestOwner(c, status, req) {
+		return nil, nil, nil, false
+	}
+	if req.Pooled || req.ScaleSet || services.Cloud(req) == services.CloudBYOS {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Only deployments on a single VM of their own or on Kubernetes can be %s", action)})
+		return nil, nil, nil, false
+	}
+	switch services.Mode(req) {
+	case services.ModeInfraOnly:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Deployment is infra-only and has no app to be %s; deploy one with mode %s", action, services.ModeAppOnly)})
+		return nil, nil, nil, false
+	case services.ModeAppOnly:
+		// The app's VM, and its SSH key, belong to the infra-only
+		// deployment.
+		infra, err := deploymentManager.Infrastructure(req.InfraDeploymentID)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Deployment's infrastructure is not available: %v", err)})
+			return nil, nil, nil, false
+		}
+		return status, req, infra, true
+	}
+	if status.TerraformDir == "" || status.PublicIP == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no kept SSH key or address; it predates kept state"})
+		return nil, nil, nil, false
+	}
+	return status, req, &services.Infrastructure{PublicIP: status.PublicIP, TerraformDir: status.TerraformDir}, true
+}
+
+// beginAppUpdate calls BeginAppUpdate, responding with the error if the
+// update cannot start.
+func beginAppUpdate(c *gin.Context, deploymentID, status string) bool {
+	if err := deploymentManager.BeginAppUpdate(deploymentID, status); err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return false
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// appUpdate describes an in-place update of a deployment's app for
+// runAppUpdate: the step its log messages are under, their event codes and
+// messages.
+type appUpdate struct {
+	step      string
+	name      string
+	started   string
+	succeeded string
+	failed    string
+	message   string
+	done      string
+}
+
+// runAppUpdate runs an update of the app at publicIP, which returns the
+// commit the app is now at, and returns the deployment marked with status to
+// the status it had before. The outcome is an event on its log stream and
+// timeline; its status, end time and error stay those of its deployment
+// run.
+func runAppUpdate(deploymentID, status string, update appUpdate, publicIP string, run func() (string, error)) {
+	logEvent := func(level, code, message, step string, data map[string]interface{}) {
+		log.Printf("[%s] %s: %s", level, step, message)
+		deploymentManager.BroadcastLog(deploymentID, types.LogMessage{
+			Level:     level,
+			Message:   message,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Step:      step,
+			Code:      code,
+			Data:      data,
+		})
+	}
+
+	logEvent("info", update.started, update.message, update.step, nil)
+	commit, err := run()
+	if current := deploymentManager.GetDeploymentStatus(deploymentID); current == nil || current.Status != status {
+		log.Printf("Deployment %s was finalized while %s, discarding result", deploymentID, status)
+		return
+	}
+	if err != nil {
+		logEvent("error", update.failed, fmt.Sprintf("%s failed: %v", update.name, err), "error",
+			map[string]interface{}{"error": err.Error(), "error_code": types.AsDeploymentError(err, update.step).Code})
+	} else {
+		logEvent("success", update.succeeded, fmt.Sprintf("%s at http://%s", update.done, publicIP), "completed", map[string]interface{}{"commit": commit})
+	}
+	if !deploymentManager.EndAppUpdate(deploymentID, status) {
+		log.Printf("Deployment %s was finalized while %s", deploymentID, status)
+	}
+	logEvent("system", services.EventDeploymentComplete, "DEPLOYMENT_COMPLETE", "system", nil)
+
+	time.Sleep(2 * time.Second)
+	deploymentManager.KickClients(deploymentID)
+}
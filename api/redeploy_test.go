@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// TestAppUpdateKeepsRunOutcome checks that a failed redeploy returns the
+// deployment to its status with the end time and error of its run, and
+// records the failure on its timeline instead.
+func TestAppUpdateKeepsRunOutcome(t *testing.T) {
+	deploymentManager = newTestManager(t)
+	const deploymentID = "redeployed"
+	deploymentManager.CreateDeployment(deploymentID, &services.DeploymentRequest{Username: "alice"})
+	deploymentManager.SetDeploymentStatus(deploymentID, "failed", errors.New("ansible failed"))
+	before := *deploymentManager.GetDeploymentStatus(deploymentID)
+
+	if err := deploymentManager.BeginAppUpdate(deploymentID, "redeploying"); err != nil {
+		t.Fatalf("BeginAppUpdate: %v", err)
+	}
+	if status := deploymentManager.GetDeploymentStatus(deploymentID); status.Status != "redeploying" || status.Error == nil {
+		t.Fatalf("during the redeploy the deployment is %s with error %v, want redeploying with its run's error", status.Status, status.Error)
+	}
+	runAppUpdate(deploymentID, "redeploying", appUpdate{
+		step:      "redeploy",
+		name:      "Redeploy",
+		started:   services.EventRedeployStarted,
+		succeeded: services.EventRedeploySucceeded,
+		failed:    services.EventRedeployFailed,
+	}, "203.0.113.10", func() (string, error) {
+		return "", errors.New("migrate failed")
+	})
+
+	after := deploymentManager.GetDeploymentStatus(deploymentID)
+	if after.Status != "failed" || after.PriorStatus != "" {
+		t.Fatalf("after the redeploy the deployment is %s (prior %q), want failed", after.Status, after.PriorStatus)
+	}
+	if after.EndTime == nil || !after.EndTime.Equal(*before.EndTime) {
+		t.Fatalf("end time changed from %v to %v", before.EndTime, after.EndTime)
+	}
+	if after.Error == nil || after.Error.Error() != before.Error.Error() {
+		t.Fatalf("error changed from %v to %v", before.Error, after.Error)
+	}
+	last := after.Timeline[len(after.Timeline)-1]
+	if last.Event != store.TimelineRedeployFailed || last.Detail == "" {
+		t.Fatalf("last timeline event is %+v, want %s with the error code", last, store.TimelineRedeployFailed)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// handleResizeVM changes the size of a deployment's VM: it deallocates the
+// VM, resizes and starts it, then sets gunicorn's worker count for the new
+// core count. The deploy's Terraform state is not kept, so the resize goes
+// through the Azure CLI and the new size is saved in the stored request,
+// which later deploys render into their configuration. A request with
+// snapshot_before_deploy has the VM snapshotted first, as before a deploy.
+// The app is down while the VM is deallocated, typically for services.ResizeDowntime, so
+// only its team, or for a personal deployment its owner or an admin, can
+// resize it.
+func handleResizeVM(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil {
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	if !authorizeRequestOwner(c, status, req) {
+		return
+	}
+	if services.Cloud(req) != services.CloudAzure || req.Pooled || req.ScaleSet {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Only a single dedicated Azure VM can be resized"})
+		return
+	}
+
+	var body struct {
+		VMSize string `json:"vm_size" binding:"required"`
+		DryRun bool   `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	current := services.VMSize(req)
+	if body.VMSize == current {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("VM is already %s", current)})
+		return
+	}
+
+	updated := *req
+	updated.VMSize = body.VMSize
+	if err := validatePlacement(&updated); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if providers.IsARM64VMSize(current) != providers.IsARM64VMSize(body.VMSize) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "vm_size must have the same architecture as the VM's image"})
+		return
+	}
+	estimate, err := checkBudget(c, &updated)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "estimate": estimate})
+		return
+	}
+
+	workers := services.GunicornWorkers(&updated)
+	response := gin.H{
+		"old_vm_size":       current,
+		"new_vm_size":       body.VMSize,
+		"gunicorn_workers":  workers,
+		"expected_downtime": services.ResizeDowntime.String(),
+	}
+	if estimate != nil {
+		response["estimate"] = estimate
+	}
+	if body.DryRun {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	azure.VMSize = current
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "resize-"+status.ID, nil)
+	defer unlock()
+	if req.SnapshotBeforeDeploy {
+		name := fmt.Sprintf("pre-resize-%s-%s", status.ID, time.Now().Format("20060102-150405"))
+		if snapshot, err := azure.CreateSnapshot(name, status.ID); err != nil {
+			response["snapshot_error"] = "Failed to snapshot the VM before resizing it, continuing: " + err.Error()
+		} else {
+			response["snapshot"] = snapshot.Name
+		}
+	}
+	started := time.Now()
+	if err := azure.ResizeVM(body.VMSize); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resize VM: " + err.Error()})
+		return
+	}
+	if err := deploymentManager.requests.Save(status.ID, &updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "VM was resized but the new size could not be saved: " + err.Error()})
+		return
+	}
+
+	output, err := azure.RunShellScript(services.ResizeWorkersScript(workers))
+	response["downtime"] = time.Since(started).Round(time.Second).String()
+	if err != nil || !services.WorkersResized(output) {
+		response["workers_error"] = "VM was resized but gunicorn's workers were not updated; redeploy to update them"
+		response["output"] = output
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// rollbackRequest is the optional body of a rollback.
+type rollbackRequest struct {
+	// Commit is the SHA to roll back to. Without one, the app goes back to
+	// the commit it was at before its current one.
+	Commit string `json:"commit"`
+	// UnapplyMigrations reverses the migrations the current code has and
+	// Commit does not before checking it out. Without it, such a rollback
+	// is refused rather than leave the database ahead of the code.
+	UnapplyMigrations bool `json:"unapply_migrations"`
+}
+
+// previousRevision returns the last commit in a deployment's revisions that
+// differs from its current one, or "" if the revisions don't go back that
+// far.
+func previousRevision(status *DeploymentStatus) string {
+	n := len(status.Revisions)
+	if n == 0 {
+		return ""
+	}
+	current := status.Revisions[n-1].Commit
+	for i := n - 2; i >= 0; i-- {
+		if commit := status.Revisions[i].Commit; commit != current {
+			return commit
+		}
+	}
+	return ""
+}
+
+// imageRevision returns the revision of a Kubernetes deployment to roll back
+// to: the latest one at commit with a recorded image, or without a commit
+// the last one whose image differs from the current one.
+func imageRevision(status *DeploymentStatus, commit string) (store.Revision, bool) {
+	current := ""
+	if n := len(status.Revisions); n > 0 {
+		current = status.Revisions[n-1].Image
+	}
+	for i := len(status.Revisions) - 1; i >= 0; i-- {
+		revision := status.Revisions[i]
+		if !services.PinnedImage(revision.Image) {
+			continue
+		}
+		if commit != "" && strings.HasPrefix(revision.Commit, commit) || commit == "" && revision.Image != current {
+			return revision, true
+		}
+	}
+	return store.Revision{}, false
+}
+
+// handleRollbackDeployment returns a deployment's app to an earlier commit
+// on the VM it runs on: the one given, or the one before the current one.
+// It snapshots the VM, reinstalls the requirements if they differ, migrates
+// unless that would leave the database ahead of the code, and restarts the
+// app. A
+// Kubernetes deployment is rolled out again to the image, by digest, it ran
+// the commit from. Like a redeploy, it runs in the background on the
+// deployment's log stream, and only its team, or for a personal deployment
+// its owner or an admin, can start it.
+func handleRollbackDeployment(c *gin.Context) {
+	var body rollbackRequest
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Commit != "" && !services.ValidCommit(body.Commit) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "commit must be a commit SHA of 7 to 40 lowercase hex characters"})
+		return
+	}
+
+	deploymentID := c.Param("deploymentId")
+	status, req, infra, ok := appUpdateTarget(c, deploymentID, "rolled back")
+	if !ok {
+		return
+	}
+	commit := body.Commit
+	if commit == "" {
+		// Fall back to the VM's reflog when the revisions don't go back far
+		// enough, e.g. for deployments from before they were recorded.
+		commit = previousRevision(status)
+	}
+	var image string
+	run := func() (string, error) {
+		return services.NewDeploymentService().Rollback(req, infra.PublicIP, infra.TerraformDir, commit, body.UnapplyMigrations, deploymentManager, deploymentID)
+	}
+	if services.Cloud(req) == services.CloudAKS {
+		if body.UnapplyMigrations {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "unapply_migrations is not supported on Kubernetes"})
+			return
+		}
+		revision, ok := imageRevision(status, body.Commit)
+		if !ok {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No image to roll back to is recorded for this deployment"})
+			return
+		}
+		commit, image = revision.Commit, revision.Image
+		run = func() (string, error) {
+			return services.NewDeploymentService().RollbackKubernetes(req, infra.TerraformDir, commit, image, deploymentManager, deploymentID)
+		}
+	}
+	if !beginAppUpdate(c, deploymentID, "rolling_back") {
+		return
+	}
+
+	enqueueOperation(deploymentID, req, func() {
+		runAppUpdate(deploymentID, "rolling_back", appUpdate{
+			step:      "rollback",
+			name:      "Rollback",
+			started:   services.EventRollbackStarted,
+			succeeded: services.EventRollbackSucceeded,
+			failed:    services.EventRollbackFailed,
+			message:   "Rolling back the app...",
+			done:      "App rolled back",
+		}, infra.PublicIP, run)
+	})
+
+	response := gin.H{
+		"deployment_id": deploymentID,
+		"status":        "rolling_back",
+		"logs_url":      fmt.Sprintf("/deploy/%s/logs", deploymentID),
+	}
+	if commit != "" {
+		response["commit"] = commit
+	}
+	if image != "" {
+		response["image"] = image
+	}
+	c.JSON(http.StatusAccepted, response)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// serverConfigVersion is the version of the serverConfig format.
+const serverConfigVersion = 1
+
+// maxServerConfigSize caps an imported configuration.
+const maxServerConfigSize = 1 << 20
+
+// portableSettings are the environment variables that configure the
+// deployer itself the same way on any host: provider and tool defaults,
+// notifications and the background jobs. Paths on the host and secrets are
+// not among them.
+var portableSettings = map[string]bool{
+	// Providers and tools.
+	"AZURE_BACKEND": true, "AZURE_FALLBACK_LOCATIONS": true, "AZURE_SUBSCRIPTION_ID": true, "AZURE_TENANT_ID": true,
+	"AZURE_CLIENT_ID": true, "AZURE_USE_MSI": true, "AWS_PROFILE": true, "OCI_COMPARTMENT_ID": true,
+	"TERRAFORM_VERSION": true, "TERRAFORM_SHA256": true, "TERRAFORM_PROVIDER_MIRROR": true, "TERRAFORM_PLUGIN_CACHE": true,
+	"ANSIBLE_RUNNER": true, "ANSIBLE_IMAGE": true, "ANSIBLE_CORE_VERSION": true, "ANSIBLE_REQUIREMENTS": true, "ANSIBLE_ALLOW_UNHASHED": true, "TOOLS_BOOTSTRAP": true,
+	"WORKSPACE_ISOLATION": true, "TERRAFORM_IMAGE": true,
+	"POOL_VM_SIZE": true, "POOL_VM_CAPACITY": true, "AUTO_SHUTDOWN": true, "AUTO_SHUTDOWN_TIMEZONE": true, "CONTROLLER_ADDRESS": true,
+	// Notifications and approvals.
+	"SMTP_HOST": true, "SMTP_PORT": true, "SMTP_USERNAME": true, "SMTP_FROM": true,
+	"APPROVAL_WEBHOOK_URL": true, "CAPACITY_WEBHOOK_URL": true, "VULN_WEBHOOK_URL": true, "VULN_NOTIFY_SEVERITY": true,
+	"APPROVERS": true, "APPROVAL_TIMEOUT": true,
+	// Queue, background jobs and limits.
+	"DEPLOY_WORKERS": true, "QUEUE_PRIORITY_AGING": true, "HEALTH_CHECK_INTERVAL": true, "AUTO_HEAL_THRESHOLD": true,
+	"AUTO_HEAL_REBOOT": true, "VULN_SCAN_INTERVAL": true, "VULN_SCAN_TRIVY": true, "METRICS_INTERVAL": true,
+	"METRICS_RETENTION": true, "CAPACITY_ALERT_WINDOW": true, "ARCHIVE_RETENTION_DAYS": true, "WORKSPACE_QUOTA_MB": true,
+	"USER_MONTHLY_BUDGETS": true, "SESSION_TTL": true, "STREAM_TOKENS_REQUIRED": true, "LOG_CLIENT_QUEUE": true,
+	"LIFECYCLE_HOOK_COMMANDS": true, "OUTBOUND_ALLOWED_CIDRS": true,
+	// Artifact store.
+	"ARTIFACT_STORE": true, "ARTIFACT_S3_BUCKET": true, "ARTIFACT_S3_PREFIX": true, "ARTIFACT_AZURE_ACCOUNT": true, "ARTIFACT_AZURE_CONTAINER": true,
+	// Sign-on.
+	"PUBLIC_BASE_URL": true, "OIDC_PROVIDERS": true, "OIDC_ROLE_MAPPINGS": true,
+}
+
+// secretSettings are set per host and never exported. An export lists the
+// ones that are set so they can be set on the new host.
+var secretSettings = map[string]bool{
+	"ADMIN_TOKEN": true, "AZURE_CLIENT_SECRET": true, "SMTP_PASSWORD": true, "REQUEST_STORE_KEY": true,
+	"STREAM_TOKEN_KEY": true, "DIGITALOCEAN_TOKEN": true, "HCLOUD_TOKEN": true, "LINODE_TOKEN": true,
+}
+
+// oidcSetting matches the variables of an identity provider in
+// OIDC_PROVIDERS; see auth.OIDCConfigsFromEnv.
+var oidcSetting = regexp.MustCompile(`^OIDC_[A-Z0-9_]+_(ISSUER|CLIENT_ID|CLIENT_SECRET|SCOPES|GROUPS_CLAIM|USERNAME_CLAIM|TENANTS)$`)
+
+func isPortableSetting(name string) bool {
+	return portableSettings[name] || (oidcSetting.MatchString(name) && !strings.HasSuffix(name, "_CLIENT_SECRET"))
+}
+
+func isSecretSetting(name string) bool {
+	return secretSettings[name] || (oidcSetting.MatchString(name) && strings.HasSuffix(name, "_CLIENT_SECRET"))
+}
+
+// serverSettingsFile holds the settings imported from another instance.
+func serverSettingsFile() string {
+	return filepath.Join(dataDir(), "settings.env")
+}
+
+// loadServerSettings sets the imported settings that the environment does
+// not set itself, so the host's own configuration always wins.
+func loadServerSettings() {
+	path := serverSettingsFile()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	if err := godotenv.Load(path); err != nil {
+		log.Printf("Failed to load imported settings from %s: %v", path, err)
+		return
+	}
+	log.Printf("Loaded imported settings from %s", path)
+}
+
+// writeServerSettings replaces the imported settings with settings.
+func writeServerSettings(settings map[string]string) error {
+	if err := os.MkdirAll(dataDir(), 0755); err != nil {
+		return err
+	}
+	if err := godotenv.Write(settings, serverSettingsFile()); err != nil {
+		return err
+	}
+	return os.Chmod(serverSettingsFile(), 0600)
+}
+
+// serverConfig is the deployer's own configuration as exported and
+// imported: its portable settings and its organizations. Secrets, such as
+// credentials and member tokens, are not part of it.
+type serverConfig struct {
+	Version    int       `yaml:"version"`
+	ExportedAt time.Time `yaml:"exported_at,omitempty"`
+	// Settings are portable environment variables by name.
+	Settings map[string]string `yaml:"settings,omitempty"`
+	// Omitted names the secret settings that were set on the exporting
+	// host, for setting by hand.
+	Omitted       []string    `yaml:"omitted,omitempty"`
+	Organizations []orgConfig `yaml:"organizations,omitempty"`
+}
+
+type orgConfig struct {
+	Name string `yaml:"name"`
+	// Members maps usernames to their role.
+	Members   map[string]string        `yaml:"members,omitempty"`
+	Teams     map[string]teamConfig    `yaml:"teams,omitempty"`
+	OnFailure []services.LifecycleHook `yaml:"on_failure,omitempty"`
+}
+
+type teamConfig struct {
+	Members          []string `yaml:"members,omitempty"`
+	MaxDeployments   int      `yaml:"max_deployments,omitempty"`
+	MaxMonthlyBudget float64  `yaml:"max_monthly_budget,omitempty"`
+}
+
+// currentServerConfig collects the settings in effect and every
+// organization.
+func currentServerConfig() (*serverConfig, error) {
+	config := &serverConfig{Version: serverConfigVersion, ExportedAt: time.Now().UTC(), Settings: map[string]string{}}
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		switch {
+		case isPortableSetting(name):
+			config.Settings[name] = value
+		case isSecretSetting(name) && value != "":
+			config.Omitted = append(config.Omitted, name)
+		}
+	}
+	sort.Strings(config.Omitted)
+
+	names, err := orgStore.IDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		org, err := getOrganization(name)
+		if err != nil {
+			return nil, err
+		}
+		if org == nil {
+			continue
+		}
+		exported := orgConfig{Name: org.Name, Members: map[string]string{}, Teams: map[string]teamConfig{}, OnFailure: org.OnFailure}
+		for username, member := range org.Members {
+			exported.Members[username] = member.Role
+		}
+		for teamName, team := range org.Teams {
+			exported.Teams[teamName] = teamConfig{
+				Members:          team.Members,
+				MaxDeployments:   team.Quota.MaxDeployments,
+				MaxMonthlyBudget: team.Quota.MaxMonthlyBudget,
+			}
+		}
+		config.Organizations = append(config.Organizations, exported)
+	}
+	return config, nil
+}
+
+// handleExportServerConfig serves the deployer's configuration as YAML for
+// POST /admin/config/import on another instance.
+func handleExportServerConfig(c *gin.Context) {
+	config, err := currentServerConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="django-vpc-config.yaml"`)
+	c.Data(http.StatusOK, "application/yaml", data)
+}
+
+// validateServerConfig checks an imported configuration before any of it
+// is applied.
+func validateServerConfig(config *serverConfig) error {
+	if config.Version != serverConfigVersion {
+		return fmt.Errorf("unsupported version %d, expected %d", config.Version, serverConfigVersion)
+	}
+	for name := range config.Settings {
+		if isSecretSetting(name) {
+			return fmt.Errorf("settings: %s is a secret and must be set on the host", name)
+		}
+		if !isPortableSetting(name) {
+			return fmt.Errorf("settings: unknown setting %s", name)
+		}
+	}
+	if driver, ok := config.Settings["ARTIFACT_STORE"]; ok && !validArtifactDriver(driver) {
+		return fmt.Errorf("settings: ARTIFACT_STORE must be local, azure or s3")
+	}
+	seen := make(map[string]bool)
+	for _, org := range config.Organizations {
+		if !orgNamePattern.MatchString(org.Name) {
+			return fmt.Errorf("organizations: invalid name %q", org.Name)
+		}
+		if seen[org.Name] {
+			return fmt.Errorf("organizations: %s is listed twice", org.Name)
+		}
+		seen[org.Name] = true
+		for username, role := range org.Members {
+			if role != orgRoleAdmin && role != orgRoleMember {
+				return fmt.Errorf("organizations: %s: role of %s must be admin or member", org.Name, username)
+			}
+		}
+		for teamName, team := range org.Teams {
+			if !orgNamePattern.MatchString(teamName) {
+				return fmt.Errorf("organizations: %s: invalid team name %q", org.Name, teamName)
+			}
+			if team.MaxDeployments < 0 || team.MaxMonthlyBudget < 0 {
+				return fmt.Errorf("organizations: %s: quota limits of team %s cannot be negative", org.Name, teamName)
+			}
+		}
+		for i := range org.OnFailure {
+			if org.OnFailure[i].Stage == "" {
+				org.OnFailure[i].Stage = services.HookOnFailure
+			}
+			if org.OnFailure[i].Stage != services.HookOnFailure {
+				return fmt.Errorf("organizations: %s: on_failure[%d] must be an on_failure hook", org.Name, i)
+			}
+		}
+		if err := validateHooks(org.OnFailure); err != nil {
+			return fmt.Errorf("organizations: %s: %v", org.Name, err)
+		}
+	}
+	return nil
+}
+
+// importOrganization creates or updates an organization from imported. It
+// adds and updates members, teams and hooks but removes nothing. Members
+// must be registered users of this instance; the others are returned as
+// skipped. Imported members have no token until an admin issues one with
+// rotate_token or they sign in with SSO.
+func importOrganization(imported orgConfig) (created bool, skipped []string, err error) {
+	orgMux.Lock()
+	defer orgMux.Unlock()
+
+	org, err := getOrganization(imported.Name)
+	if err != nil {
+		return false, nil, err
+	}
+	now := time.Now()
+	if org == nil {
+		created = true
+		org = &Organization{Name: imported.Name, Members: map[string]*OrgMember{}, Teams: map[string]*Team{}, CreatedAt: now}
+	}
+
+	for username, role := range imported.Members {
+		if user, err := userStore.Get(username); err != nil || user == nil {
+			skipped = append(skipped, username)
+			continue
+		}
+		if member, exists := org.Members[username]; exists {
+			member.Role = role
+		} else {
+			org.Members[username] = &OrgMember{Role: role, JoinedAt: now}
+		}
+	}
+	if org.admins() == 0 {
+		return false, skipped, fmt.Errorf("%s would have no admin who is a registered user", imported.Name)
+	}
+
+	for teamName, team := range imported.Teams {
+		existing, exists := org.Teams[teamName]
+		if !exists {
+			existing = &Team{Members: []string{}, CreatedAt: now}
+			org.Teams[teamName] = existing
+		}
+		existing.Quota = TeamQuota{MaxDeployments: team.MaxDeployments, MaxMonthlyBudget: team.MaxMonthlyBudget}
+		for _, username := range team.Members {
+			if _, member := org.Members[username]; member && !existing.hasMember(username) {
+				existing.Members = append(existing.Members, username)
+			}
+		}
+	}
+	if len(imported.OnFailure) > 0 {
+		org.OnFailure = imported.OnFailure
+	}
+
+	sort.Strings(skipped)
+	return created, skipped, orgStore.Save(org.Name, org)
+}
+
+// handleImportServerConfig applies a configuration exported by another
+// instance. Settings are written to serverSettingsFile, replacing earlier
+// imported ones, and take effect on restart for the variables the host's
+// environment does not set. Organizations take effect at once.
+func handleImportServerConfig(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxServerConfigSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Configuration must be at most %d bytes", maxServerConfigSize)})
+		return
+	}
+	var config serverConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid configuration: %v", err)})
+		return
+	}
+	if err := validateServerConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"settings": len(config.Settings), "restart_required": len(config.Settings) > 0}
+	if len(config.Settings) > 0 {
+		if err := writeServerSettings(config.Settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write settings: " + err.Error()})
+			return
+		}
+	}
+
+	created, updated := []string{}, []string{}
+	skippedMembers := map[string][]string{}
+	failed := map[string]string{}
+	for _, org := range config.Organizations {
+		isNew, skipped, err := importOrganization(org)
+		if len(skipped) > 0 {
+			skippedMembers[org.Name] = skipped
+		}
+		switch {
+		case err != nil:
+			failed[org.Name] = err.Error()
+		case isNew:
+			created = append(created, org.Name)
+		default:
+			updated = append(updated, org.Name)
+		}
+	}
+	log.Printf("Imported server configuration: %d settings, %d organizations created, %d updated", len(config.Settings), len(created), len(updated))
+
+	response["organizations_created"] = created
+	response["organizations_updated"] = updated
+	if len(skippedMembers) > 0 {
+		response["skipped_members"] = skippedMembers
+	}
+	if len(failed) > 0 {
+		response["organizations_failed"] = failed
+	}
+	if len(config.Omitted) > 0 {
+		response["set_on_host"] = config.Omitted
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// snapshotProvider returns an Azure provider addressing the VM of a
+// finished deployment, or writes an error response and returns nil.
+func snapshotProvider(c *gin.Context) (*DeploymentStatus, *providers.AzureProvider) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return nil, nil
+	}
+	if isActive(status.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment is %s", status.Status)})
+		return nil, nil
+	}
+
+	azure, err := vmProvider(status)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return nil, nil
+	}
+	return status, azure
+}
+
+// vmProvider returns an Azure provider addressing a deployment's dedicated
+// VM, preferring the stored request for its name, location and subscription.
+func vmProvider(status *DeploymentStatus) (*providers.AzureProvider, error) {
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		req = &services.DeploymentRequest{Username: status.Username, RepoURL: status.RepoURL}
+	}
+	vmName, err := services.VMName(req)
+	if err != nil || status.ResourceGroup == "" {
+		return nil, errors.New("Deployment has no recorded VM")
+	}
+
+	return &providers.AzureProvider{
+		ResourceGroup:  status.ResourceGroup,
+		Location:       services.Location(req),
+		SubscriptionID: req.SubscriptionID,
+		Credentials:    req.AzureCredentials,
+		VMName:         vmName,
+	}, nil
+}
+
+func handleCreateSnapshot(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentOwner(c, status) {
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+	if body.Name == "" {
+		body.Name = fmt.Sprintf("%s-%s", azure.VMName, time.Now().Format("20060102-150405"))
+	}
+
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "snapshot-"+status.ID, nil)
+	defer unlock()
+
+	snapshot, err := azure.CreateSnapshot(body.Name, status.ID)
+	if errors.Is(err, providers.ErrVMNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment VM no longer exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"deployment_id": status.ID, "snapshot": snapshot})
+}
+
+func handleListSnapshots(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentView(c, status) {
+		return
+	}
+
+	snapshots, err := azure.ListSnapshots(status.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deployment_id": status.ID, "snapshots": snapshots})
+}
+
+func handleRestoreSnapshot(c *gin.Context) {
+	status, azure := snapshotProvider(c)
+	if azure == nil || !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	name := c.Param("snapshot")
+
+	snapshots, err := azure.ListSnapshots(status.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	found := false
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found for this deployment"})
+		return
+	}
+
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "restore-"+status.ID, nil)
+	defer unlock()
+
+	if err := azure.RestoreSnapshot(name); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deployment_id": status.ID, "message": fmt.Sprintf("VM restored from snapshot %s", name)})
+}
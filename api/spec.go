@@ -0,0 +1,20 @@
+package main
+
+import (
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// applyProjectSpec merges the repository's djangovpc.yaml into the request,
+// returning the spec it applied or nil if the repository has none. Requests
+// without a repo_url or github_token are left for validateRequest to reject.
+func applyProjectSpec(req *services.DeploymentRequest) (*services.ProjectSpec, error) {
+	if req.RepoURL == "" || req.GithubToken == "" {
+		return nil, nil
+	}
+	spec, err := services.NewDeploymentService().LoadProjectSpec(req)
+	if err != nil || spec == nil {
+		return nil, err
+	}
+	services.ApplyProjectSpec(req, spec)
+	return spec, nil
+}
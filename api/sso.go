@@ -0,0 +1,423 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Auth"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+var (
+	sessionStore      *store.SessionStore
+	identityProviders = map[string]auth.IdentityProvider{}
+	roleMappings      []roleMapping
+)
+
+// sessionCookie carries the session token for browsers; API clients send
+// it as a bearer token instead.
+const sessionCookie = "djvpc_session"
+
+// pendingLoginTTL is how long a user has to complete a login at the
+// identity provider.
+const pendingLoginTTL = 10 * time.Minute
+
+// roleAdmin grants the admin API, like ADMIN_TOKEN does.
+const roleAdmin = "admin"
+
+// sessionTTL reads SESSION_TTL, defaulting to 12 hours.
+func sessionTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 12 * time.Hour
+}
+
+// roleMapping grants Role to users in Group. A group starting with "@"
+// matches users whose verified email is in that domain instead, for
+// providers like Google that assert no groups.
+type roleMapping struct {
+	Group string
+	Role  string
+}
+
+// parseRoleMappings reads OIDC_ROLE_MAPPINGS, a comma separated list of
+// group=role pairs. Roles are "admin" for the admin API, "<org>:admin" or
+// "<org>:member" for an organization role, and "<org>/<team>" for
+// membership of a team.
+func parseRoleMappings(value string) ([]roleMapping, error) {
+	var mappings []roleMapping
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		separator := strings.LastIndex(entry, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("invalid role mapping %q: want group=role", entry)
+		}
+		mapping := roleMapping{Group: entry[:separator], Role: entry[separator+1:]}
+		if _, _, _, err := parseRole(mapping.Role); err != nil {
+			return nil, fmt.Errorf("invalid role mapping %q: %v", entry, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// parseRole splits a mapped role into the organization it applies to, the
+// organization role and the team. admin is "admin" for the admin API.
+func parseRole(role string) (org, orgRole, team string, err error) {
+	if role == roleAdmin {
+		return "", roleAdmin, "", nil
+	}
+	if name, teamName, found := strings.Cut(role, "/"); found {
+		if !orgNamePattern.MatchString(name) || !orgNamePattern.MatchString(teamName) {
+			return "", "", "", fmt.Errorf("invalid team %q", role)
+		}
+		return name, orgRoleMember, teamName, nil
+	}
+	if name, grant, found := strings.Cut(role, ":"); found && orgNamePattern.MatchString(name) &&
+		(grant == orgRoleAdmin || grant == orgRoleMember) {
+		return name, grant, "", nil
+	}
+	return "", "", "", fmt.Errorf("role must be admin, <org>:admin, <org>:member or <org>/<team>")
+}
+
+// mapRoles returns the roles the identity's groups and email domain map to.
+func mapRoles(identity *auth.Identity) []string {
+	groups := make(map[string]bool, len(identity.Groups))
+	for _, group := range identity.Groups {
+		groups[group] = true
+	}
+	domain := ""
+	if _, host, found := strings.Cut(identity.Email, "@"); found && identity.EmailVerified {
+		domain = "@" + strings.ToLower(host)
+	}
+
+	granted := make(map[string]bool)
+	for _, mapping := range roleMappings {
+		if groups[mapping.Group] || (domain != "" && strings.ToLower(mapping.Group) == domain) {
+			granted[mapping.Role] = true
+		}
+	}
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// setupIdentityProviders configures the providers in OIDC_PROVIDERS and the
+// group mappings in OIDC_ROLE_MAPPINGS.
+func setupIdentityProviders() error {
+	configs, err := auth.OIDCConfigsFromEnv(publicBaseURL())
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		identityProviders[config.Name] = auth.NewOIDCProvider(config)
+		log.Printf("Single sign-on enabled with %s (%s)", config.Name, config.Issuer)
+	}
+	roleMappings, err = parseRoleMappings(os.Getenv("OIDC_ROLE_MAPPINGS"))
+	return err
+}
+
+type pendingLogin struct {
+	provider string
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// pendingLogins holds the nonce and PKCE verifier of logins started on this
+// server, keyed by their state parameter.
+var pendingLogins = struct {
+	sync.Mutex
+	logins map[string]pendingLogin
+}{logins: make(map[string]pendingLogin)}
+
+func takePendingLogin(state string) (pendingLogin, bool) {
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+
+	now := time.Now()
+	for key, login := range pendingLogins.logins {
+		if now.After(login.expires) {
+			delete(pendingLogins.logins, key)
+		}
+	}
+	login, ok := pendingLogins.logins[state]
+	delete(pendingLogins.logins, state)
+	return login, ok
+}
+
+func handleListIdentityProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(identityProviders))
+	for name := range identityProviders {
+		providers = append(providers, gin.H{
+			"name":      name,
+			"login_url": publicBaseURL() + "/auth/" + name + "/login",
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i]["name"].(string) < providers[j]["name"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// handleSSOLogin redirects to the identity provider's login page.
+func handleSSOLogin(c *gin.Context) {
+	provider, exists := identityProviders[c.Param("provider")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+		return
+	}
+
+	state, err := auth.RandomString(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nonce, err := auth.RandomString(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	verifier, challenge, err := auth.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	loginURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	pendingLogins.Lock()
+	pendingLogins.logins[state] = pendingLogin{
+		provider: provider.Name(),
+		nonce:    nonce,
+		verifier: verifier,
+		expires:  time.Now().Add(pendingLoginTTL),
+	}
+	pendingLogins.Unlock()
+
+	c.Redirect(http.StatusFound, loginURL)
+}
+
+// handleSSOCallback completes a login: it verifies the identity, records
+// the user, maps their groups to roles and starts a session.
+func handleSSOCallback(c *gin.Context) {
+	provider, exists := identityProviders[c.Param("provider")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Login failed: %s %s", reason, c.Query("error_description"))})
+		return
+	}
+	login, ok := takePendingLogin(c.Query("state"))
+	if !ok || login.provider != provider.Name() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or expired login; start again"})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.verifier, login.nonce)
+	if err != nil {
+		log.Printf("Failed %s login: %v", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed: " + err.Error()})
+		return
+	}
+
+	username, err := recordSSOUser(identity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	session := &store.Session{
+		Username:  username,
+		Email:     identity.Email,
+		Provider:  identity.Provider,
+		Issuer:    identity.Issuer,
+		Subject:   identity.Subject,
+		Groups:    identity.Groups,
+		Roles:     mapRoles(identity),
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL()),
+	}
+	if err := sessionStore.Save(hashToken(token), session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("%s signed in with %s, roles %v", session.Username, session.Provider, session.Roles)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, int(sessionTTL().Seconds()), "/", "", strings.HasPrefix(publicBaseURL(), "https://"), true)
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"token":      token,
+		"username":   session.Username,
+		"roles":      session.Roles,
+		"expires_at": session.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// recordSSOUser returns the local username of the identity, registering it
+// the first time they sign in and taking the email address the identity
+// provider verified. The username claim is the user's to choose, so the
+// account is bound to the issuer and subject: a claimed username that is
+// taken by a local account or another identity gets a suffix derived from
+// them instead.
+func recordSSOUser(identity *auth.Identity) (string, error) {
+	sum := sha256.Sum256([]byte(identity.Key()))
+	var user *store.User
+	created := false
+	for _, username := range []string{identity.Username, identity.Username + "-" + hex.EncodeToString(sum[:4])} {
+		existing, err := userStore.Get(username)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			user, created = &store.User{Username: username, CreatedAt: time.Now(), SSOIdentity: identity.Key()}, true
+			break
+		}
+		if existing.SSOIdentity == identity.Key() {
+			user = existing
+			break
+		}
+	}
+	if user == nil {
+		return "", fmt.Errorf("username %s and its fallback are taken by other accounts", identity.Username)
+	}
+	if !created && (!identity.EmailVerified || (user.EmailVerified && user.Email == identity.Email)) {
+		return user.Username, nil
+	}
+	if identity.EmailVerified {
+		now := time.Now()
+		user.Email = identity.Email
+		user.EmailVerified = true
+		user.VerifiedAt = &now
+		user.VerificationHash = ""
+	}
+	return user.Username, userStore.Save(user)
+}
+
+// requestSession returns the SSO session of the request's bearer token or
+// cookie, or nil.
+func requestSession(c *gin.Context) *store.Session {
+	if sessionStore == nil {
+		return nil
+	}
+	token := bearerToken(c)
+	if token == "" {
+		token, _ = c.Cookie(sessionCookie)
+	}
+	if token == "" {
+		return nil
+	}
+	session, err := sessionStore.Get(hashToken(token))
+	// Sessions started before usernames were bound to the issuer have no
+	// issuer and must sign in again.
+	if err != nil || session == nil || session.Issuer == "" {
+		return nil
+	}
+	return session
+}
+
+func sessionHasRole(session *store.Session, role string) bool {
+	for _, granted := range session.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionOrgCaller returns the caller an SSO session makes of an
+// organization's endpoints: the strongest of the roles mapped from their
+// groups and the membership the organization lists, or nil for neither.
+// recordSSOUser binds the session's username to its issuer and subject, so
+// it can be matched against the members.
+func sessionOrgCaller(session *store.Session, org *Organization) *orgCaller {
+	caller := &orgCaller{Username: session.Username, Teams: make(map[string]bool)}
+	if member, exists := org.Members[session.Username]; exists {
+		caller.Role = member.Role
+	}
+	for _, role := range session.Roles {
+		name, orgRole, team, err := parseRole(role)
+		if err != nil || name != org.Name {
+			continue
+		}
+		if caller.Role != orgRoleAdmin {
+			caller.Role = orgRole
+		}
+		if team != "" {
+			caller.Teams[team] = true
+		}
+	}
+	if caller.Role == "" {
+		return nil
+	}
+	return caller
+}
+
+func handleGetSession(c *gin.Context) {
+	session := requestSession(c)
+	if session == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+func handleLogout(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" {
+		token, _ = c.Cookie(sessionCookie)
+	}
+	if token != "" {
+		if err := sessionStore.Delete(hashToken(token)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.SetCookie(sessionCookie, "", -1, "/", "", strings.HasPrefix(publicBaseURL(), "https://"), true)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func runSessionPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := sessionStore.PurgeExpired(time.Now())
+		if err != nil {
+			log.Printf("Failed to purge expired sessions: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired sessions", purged)
+		}
+		<-ticker.C
+	}
+}
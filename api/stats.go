@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+type StepDurationStats struct {
+	Count      int     `json:"count"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+}
+
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type DeploymentStats struct {
+	Total                 int                          `json:"total"`
+	Completed             int                          `json:"completed"`
+	Failed                int                          `json:"failed"`
+	Cancelled             int                          `json:"cancelled"`
+	Running               int                          `json:"running"`
+	Queued                int                          `json:"queued"`
+	SuccessRate           float64                      `json:"success_rate"`
+	MedianDurationSeconds float64                      `json:"median_duration_seconds"`
+	StepDurations         map[string]StepDurationStats `json:"step_durations"`
+	FailuresByErrorCode   map[string]int               `json:"failures_by_error_code"`
+	DeploymentsPerDay     []DailyCount                 `json:"deployments_per_day"`
+	Timestamp             string                       `json:"timestamp"`
+}
+
+func handleStats(c *gin.Context) {
+	records, err := deploymentManager.store.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, computeStats(records))
+}
+
+func computeStats(records []*store.Deployment) DeploymentStats {
+	stats := DeploymentStats{
+		StepDurations:       make(map[string]StepDurationStats),
+		FailuresByErrorCode: make(map[string]int),
+		DeploymentsPerDay:   []DailyCount{},
+		Timestamp:           time.Now().Format(time.RFC3339),
+	}
+
+	var durations []float64
+	stepSamples := make(map[string][]float64)
+	perDay := make(map[string]int)
+
+	for _, record := range records {
+		stats.Total++
+		perDay[record.StartTime.UTC().Format("2006-01-02")]++
+
+		switch record.Status {
+		case "completed":
+			stats.Completed++
+		case "failed":
+			stats.Failed++
+			code := record.ErrorCode
+			if code == "" {
+				code = "UNKNOWN_FAILED"
+			}
+			stats.FailuresByErrorCode[code]++
+		case "cancelled":
+			stats.Cancelled++
+		case "running":
+			stats.Running++
+		case "queued":
+			stats.Queued++
+		}
+
+		if record.EndTime != nil && (record.Status == "completed" || record.Status == "failed") {
+			durations = append(durations, record.EndTime.Sub(record.StartTime).Seconds())
+		}
+
+		// A step can be entered several times in one run (ssh is revisited
+		// after terraform), so sum its stretches per deployment first.
+		perStep := make(map[string]float64)
+		for _, step := range record.Steps {
+			if step.End == nil {
+				continue
+			}
+			perStep[step.Name] += step.End.Sub(step.Start).Seconds()
+		}
+		for name, seconds := range perStep {
+			stepSamples[name] = append(stepSamples[name], seconds)
+		}
+	}
+
+	if finished := stats.Completed + stats.Failed; finished > 0 {
+		stats.SuccessRate = float64(stats.Completed) / float64(finished)
+	}
+	stats.MedianDurationSeconds = percentile(durations, 50)
+
+	for name, samples := range stepSamples {
+		stats.StepDurations[name] = StepDurationStats{
+			Count:      len(samples),
+			P50Seconds: percentile(samples, 50),
+			P95Seconds: percentile(samples, 95),
+		}
+	}
+
+	for date, count := range perDay {
+		stats.DeploymentsPerDay = append(stats.DeploymentsPerDay, DailyCount{Date: date, Count: count})
+	}
+	sort.Slice(stats.DeploymentsPerDay, func(i, j int) bool {
+		return stats.DeploymentsPerDay[i].Date < stats.DeploymentsPerDay[j].Date
+	})
+
+	return stats
+}
+
+// percentile uses the nearest-rank method on a copy of samples.
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+func TestPercentileNearestRank(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []float64
+		p       float64
+		want    float64
+	}{
+		{"no samples", nil, 50, 0},
+		{"one sample p50", []float64{7}, 50, 7},
+		{"one sample p95", []float64{7}, 95, 7},
+		{"two samples p50", []float64{20, 10}, 50, 10},
+		{"two samples p95", []float64{20, 10}, 95, 20},
+		{"three samples p50", []float64{30, 10, 20}, 50, 20},
+		{"four samples p50", []float64{40, 10, 30, 20}, 50, 20},
+		{"four samples p95", []float64{40, 10, 30, 20}, 95, 40},
+		{"ten samples p50", []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 50, 5},
+		{"ten samples p95", []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 95, 10},
+		{"twenty samples p95", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}, 95, 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := append([]float64(nil), tt.samples...)
+			if got := percentile(tt.samples, tt.p); got != tt.want {
+				t.Fatalf("percentile(%v, %v) = %v, want %v", tt.samples, tt.p, got, tt.want)
+			}
+			for i := range samples {
+				if samples[i] != tt.samples[i] {
+					t.Fatalf("percentile reordered its samples to %v", tt.samples)
+				}
+			}
+		})
+	}
+}
+
+// TestComputeStats checks the counts and durations of a small set of
+// deployments, including a step entered twice in one run.
+func TestComputeStats(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) *time.Time {
+		end := start.Add(time.Duration(seconds) * time.Second)
+		return &end
+	}
+	records := []*store.Deployment{
+		{Status: "completed", StartTime: start, EndTime: at(100), Steps: []store.StepTiming{
+			{Name: "ssh", Start: start, End: at(10)},
+			{Name: "terraform", Start: *at(10), End: at(60)},
+			{Name: "ssh", Start: *at(60), End: at(70)},
+		}},
+		{Status: "completed", StartTime: start, EndTime: at(300), Steps: []store.StepTiming{
+			{Name: "ssh", Start: start, End: at(40)},
+		}},
+		{Status: "failed", ErrorCode: "TERRAFORM_APPLY", StartTime: start.Add(24 * time.Hour), EndTime: at(86400 + 200)},
+		{Status: "failed", StartTime: start.Add(24 * time.Hour)},
+		{Status: "cancelled", StartTime: start, EndTime: at(50)},
+		{Status: "running", StartTime: start, Steps: []store.StepTiming{{Name: "ssh", Start: start}}},
+	}
+
+	stats := computeStats(records)
+	if stats.Total != 6 || stats.Completed != 2 || stats.Failed != 2 || stats.Cancelled != 1 || stats.Running != 1 {
+		t.Fatalf("counts = %+v", stats)
+	}
+	if stats.SuccessRate != 0.5 {
+		t.Fatalf("SuccessRate = %v, want 0.5", stats.SuccessRate)
+	}
+	// Completed and failed runs that ended took 100, 300 and 200 seconds.
+	if stats.MedianDurationSeconds != 200 {
+		t.Fatalf("MedianDurationSeconds = %v, want 200", stats.MedianDurationSeconds)
+	}
+	if ssh := stats.StepDurations["ssh"]; ssh.Count != 2 || ssh.P50Seconds != 20 || ssh.P95Seconds != 40 {
+		t.Fatalf("ssh step = %+v, want 2 samples with p50 20 and p95 40", ssh)
+	}
+	if got := stats.FailuresByErrorCode; got["TERRAFORM_APPLY"] != 1 || got["UNKNOWN_FAILED"] != 1 {
+		t.Fatalf("FailuresByErrorCode = %v", got)
+	}
+	if days := stats.DeploymentsPerDay; len(days) != 2 || days[0] != (DailyCount{"2026-03-01", 4}) || days[1] != (DailyCount{"2026-03-02", 2}) {
+		t.Fatalf("DeploymentsPerDay = %v", days)
+	}
+}
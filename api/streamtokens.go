@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamTokenTTL bounds how long after minting a stream token can open a
+// stream. A stream that is already open stays open.
+const streamTokenTTL = 2 * time.Minute
+
+// streamTokenKey signs stream tokens. Without STREAM_TOKEN_KEY it is
+// random, so tokens do not survive a restart, which their lifetime makes
+// harmless unless several servers share the streams.
+var streamTokenKey []byte
+
+// setupStreamTokens reads STREAM_TOKEN_KEY, a base64 key of at least 32
+// bytes.
+func setupStreamTokens() error {
+	if encoded := os.Getenv("STREAM_TOKEN_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) < 32 {
+			return fmt.Errorf("STREAM_TOKEN_KEY must be a base64 key of at least 32 bytes")
+		}
+		streamTokenKey = key
+		return nil
+	}
+	streamTokenKey = make([]byte, 32)
+	_, err := rand.Read(streamTokenKey)
+	return err
+}
+
+// streamTokensRequired reports whether STREAM_TOKENS_REQUIRED makes the log
+// stream and download refuse requests without a stream token, unless the
+// caller authenticates as one allowed to see the deployment.
+func streamTokensRequired() bool {
+	required, _ := strconv.ParseBool(os.Getenv("STREAM_TOKENS_REQUIRED"))
+	return required
+}
+
+// streamClaims are what a stream token grants: the log stream of one
+// deployment, to one user, until Expires.
+type streamClaims struct {
+	DeploymentID string `json:"d"`
+	Username     string `json:"u"`
+	Expires      int64  `json:"e"`
+}
+
+func signStreamPayload(payload string) string {
+	mac := hmac.New(sha256.New, streamTokenKey)
+	mac.Write([]byte("stream-token\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mintStreamToken returns a token for the query string: the claims and
+// their HMAC, base64url encoded.
+func mintStreamToken(claims streamClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signStreamPayload(payload), nil
+}
+
+var errInvalidStreamToken = errors.New("invalid or expired stream token")
+
+// verifyStreamToken checks the token's signature and expiry and that it was
+// minted for deploymentID.
+func verifyStreamToken(token, deploymentID string) (*streamClaims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signStreamPayload(payload))) {
+		return nil, errInvalidStreamToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidStreamToken
+	}
+	var claims streamClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errInvalidStreamToken
+	}
+	if claims.DeploymentID != deploymentID || time.Now().Unix() > claims.Expires {
+		return nil, errInvalidStreamToken
+	}
+	return &claims, nil
+}
+
+// deploymentCaller authenticates a caller allowed to see a deployment and
+// returns their name: an admin, the SSO user or organization member who
+// owns it, or a member of its team.
+func deploymentCaller(c *gin.Context, status *DeploymentStatus) (string, bool) {
+	if isAdminRequest(c) {
+		if session := requestSession(c); session != nil {
+			return session.Username, true
+		}
+		return "admin", true
+	}
+	if session := requestSession(c); session != nil && session.Username == status.Username {
+		return session.Username, true
+	}
+	if status.Organization == "" {
+		return "", false
+	}
+	org, err := getOrganization(status.Organization)
+	if err != nil || org == nil {
+		return "", false
+	}
+	caller := orgCallerFor(c, org)
+	if caller == nil {
+		return "", false
+	}
+	if caller.Username == status.Username || caller.Role == orgRoleAdmin || caller.onTeam(org, status.Team) {
+		return caller.Username, true
+	}
+	return "", false
+}
+
+// authorizeDeploymentView responds with the error and returns false
+// unless deploymentCaller lets the caller see the deployment.
+func authorizeDeploymentView(c *gin.Context, status *DeploymentStatus) bool {
+	if _, ok := deploymentCaller(c, status); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Requires an admin, a session of " + status.Username + " or a member of its team"})
+		return false
+	}
+	return true
+}
+
+// authorizeLogs checks a request for a deployment's log stream or download:
+// a stream token for the deployment in ?token, or, without one, a caller
+// deploymentCaller lets see it when STREAM_TOKENS_REQUIRED is set. It
+// responds with the error if neither.
+func authorizeLogs(c *gin.Context, status *DeploymentStatus) bool {
+	if token := c.Query("token"); token != "" {
+		claims, err := verifyStreamToken(token, status.ID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return false
+		}
+		log.Printf("Logs of deployment %s opened by %s", status.ID, claims.Username)
+		return true
+	}
+	if !streamTokensRequired() {
+		return true
+	}
+	if _, ok := deploymentCaller(c, status); ok {
+		return true
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "A stream token is required; request one from POST /deploy/" + status.ID + "/stream-token"})
+	return false
+}
+
+// handleStreamToken mints a short-lived token that opens the deployment's
+// log stream, for clients like EventSource that cannot send an
+// Authorization header.
+func handleStreamToken(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	username, ok := deploymentCaller(c, status)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authorized for this deployment"})
+		return
+	}
+
+	expires := time.Now().Add(streamTokenTTL)
+	token, err := mintStreamToken(streamClaims{DeploymentID: deploymentID, Username: username, Expires: expires.Unix()})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": expires.Format(time.RFC3339),
+		"stream_url": fmt.Sprintf("/deploy/%s/logs?token=%s", deploymentID, token),
+	})
+}
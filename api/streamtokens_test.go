@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyStreamToken(t *testing.T) {
+	streamTokenKey = []byte(strings.Repeat("k", 32))
+	mint := func(claims streamClaims) string {
+		token, err := mintStreamToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := mint(streamClaims{DeploymentID: "dep-1", Username: "alice", Expires: time.Now().Add(streamTokenTTL).Unix()})
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// A payload granting more, under the valid token's signature.
+	forged, _ := json.Marshal(streamClaims{DeploymentID: "dep-1", Username: "mallory", Expires: time.Now().Add(time.Hour).Unix()})
+	forgedPayload := base64.RawURLEncoding.EncodeToString(forged)
+
+	otherKey := func() string {
+		saved := streamTokenKey
+		streamTokenKey = []byte(strings.Repeat("o", 32))
+		defer func() { streamTokenKey = saved }()
+		return mint(streamClaims{DeploymentID: "dep-1", Username: "alice", Expires: time.Now().Add(streamTokenTTL).Unix()})
+	}()
+
+	tests := []struct {
+		name         string
+		token        string
+		deploymentID string
+		wantUser     string
+	}{
+		{"valid", valid, "dep-1", "alice"},
+		{"other deployment", valid, "dep-2", ""},
+		{"expired", mint(streamClaims{DeploymentID: "dep-1", Username: "alice", Expires: time.Now().Add(-time.Second).Unix()}), "dep-1", ""},
+		{"tampered payload", forgedPayload + "." + signature, "dep-1", ""},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), "dep-1", ""},
+		{"signed with another key", otherKey, "dep-1", ""},
+		{"no signature", payload, "dep-1", ""},
+		{"empty", "", "dep-1", ""},
+		{"signed garbage", "bm90LWpzb24." + signStreamPayload("bm90LWpzb24"), "dep-1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyStreamToken(tt.token, tt.deploymentID)
+			if tt.wantUser == "" {
+				if err == nil {
+					t.Fatalf("verifyStreamToken accepted the token for %s", claims.Username)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyStreamToken: %v", err)
+			}
+			if claims.Username != tt.wantUser {
+				t.Fatalf("token is for %s, want %s", claims.Username, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// terraformWorkspacesDir keeps the Terraform directory of every
+// provisioned deployment, by deployment ID. It is outside the work
+// directories, which quota cleanup removes.
+func terraformWorkspacesDir() string {
+	return filepath.Join(dataDir(), "terraform")
+}
+
+// RecordWorkspace moves a finished run's Terraform directory, with its
+// configuration, state, variables and SSH key, into terraformWorkspacesDir
+// and notes the new path on the deployment. Provider plugins are left
+// behind; terraform init reinstalls them from the plugin cache.
+func (dm *DeploymentManager) RecordWorkspace(deploymentID, terraformDir string) error {
+	if !store.ValidName(deploymentID) {
+		return fmt.Errorf("invalid deployment ID %q", deploymentID)
+	}
+	if err := os.MkdirAll(terraformWorkspacesDir(), 0700); err != nil {
+		return fmt.Errorf("failed to create Terraform workspaces directory: %v", err)
+	}
+	dir, err := filepath.Abs(filepath.Join(terraformWorkspacesDir(), deploymentID))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(terraformDir, ".terraform")); err != nil {
+		return fmt.Errorf("failed to remove provider plugins: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(terraformDir, dir); err != nil {
+		// The data directory can be on another filesystem.
+		if err := copyDir(terraformDir, dir); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("failed to copy Terraform directory: %v", err)
+		}
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.TerraformDir = dir
+		dm.persist(deployment)
+	}
+	return nil
+}
+
+// copyDir copies the directories and regular files under src to dst,
+// keeping their permissions, since the SSH key must stay private.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// holdsInfrastructure reports whether the deployment's kept Terraform
+// directory still manages cloud resources. Archiving or purging such a
+// deployment would orphan them, since its state is needed to destroy them.
+func holdsInfrastructure(status *DeploymentStatus) bool {
+	return status.TerraformDir != "" && status.Status != "destroyed"
+}
+
+// removeWorkspace deletes the deployment's kept Terraform directory.
+func removeWorkspace(status *DeploymentStatus) error {
+	if status.TerraformDir == "" {
+		return nil
+	}
+	return os.RemoveAll(status.TerraformDir)
+}
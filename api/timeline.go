@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+// maxTimelineEvents caps a deployment's timeline, which the health checks of
+// a long-lived deployment keep adding to. The oldest events after the first
+// are dropped.
+const maxTimelineEvents = 200
+
+// timelineEvents maps the event codes of log messages that mark a milestone
+// to the timeline event they record.
+var timelineEvents = map[string]string{
+	services.EventDeploymentStarted: store.TimelineStarted,
+	services.EventApprovalRequired:  store.TimelineApproval,
+	services.EventApprovalGranted:   store.TimelineApproved,
+	services.EventPublicIPAssigned:  store.TimelineInfraReady,
+	services.EventPoolPlaced:        store.TimelineInfraReady,
+	services.EventAnsibleSucceeded:  store.TimelineAppStarted,
+	services.EventRedeploySucceeded: store.TimelineRedeployed,
+	services.EventRollbackSucceeded: store.TimelineRolledBack,
+	services.EventRedeployFailed:    store.TimelineRedeployFailed,
+	services.EventRollbackFailed:    store.TimelineRollbackFailed,
+}
+
+// appendTimeline adds an event to a deployment's timeline. Callers must hold
+// deployMux and persist the deployment.
+func appendTimeline(deployment *DeploymentStatus, event, detail string) {
+	deployment.Timeline = append(deployment.Timeline, store.TimelineEvent{
+		Event:  event,
+		Time:   time.Now(),
+		Detail: detail,
+	})
+	if excess := len(deployment.Timeline) - maxTimelineEvents; excess > 0 {
+		deployment.Timeline = append(deployment.Timeline[:1], deployment.Timeline[1+excess:]...)
+	}
+}
+
+// RecordTimeline adds an event to a deployment's timeline.
+func (dm *DeploymentManager) RecordTimeline(deploymentID, event, detail string) {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		appendTimeline(deployment, event, detail)
+		dm.persist(deployment)
+	}
+}
+
+// trackTimeline records the milestone a log message marks, if any.
+func (dm *DeploymentManager) trackTimeline(deploymentID string, logMsg types.LogMessage) {
+	event, ok := timelineEvents[logMsg.Code]
+	if !ok {
+		return
+	}
+	dm.RecordTimeline(deploymentID, event, timelineDetail(logMsg))
+}
+
+// timelineDetail summarizes the data of a milestone's log message, like the
+// address infrastructure became ready at.
+func timelineDetail(logMsg types.LogMessage) string {
+	if ip, ok := logMsg.Data["public_ip"].(string); ok && ip != "" {
+		return "at " + ip
+	}
+	if vm, ok := logMsg.Data["vm"].(string); ok && vm != "" {
+		return "on pool VM " + vm
+	}
+	if approver, ok := logMsg.Data["approver"].(string); ok && approver != "" {
+		return "by " + approver
+	}
+	if commit, ok := logMsg.Data["commit"].(string); ok && commit != "" {
+		return "at commit " + shortCommit(commit)
+	}
+	if code, ok := logMsg.Data["error_code"].(string); ok && code != "" {
+		return code
+	}
+	if summary, ok := logMsg.Data["plan_summary"].(string); ok {
+		return summary
+	}
+	return ""
+}
+
+// shortCommit abbreviates a commit SHA the way git log --oneline does.
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// trackHealth records a health check on a deployment's timeline when its
+// outcome differs from the last one recorded, so the timeline shows when
+// the app first passed and each time it went down or recovered.
+func (dm *DeploymentManager) trackHealth(deploymentID string, check store.HealthCheck) {
+	event, detail := store.TimelineHealthPassed, fmt.Sprintf("HTTP %d", check.StatusCode)
+	if !check.OK {
+		event, detail = store.TimelineHealthFailed, check.Error
+	}
+
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	deployment, exists := dm.deployments[deploymentID]
+	if !exists || lastHealthEvent(deployment.Timeline) == event {
+		return
+	}
+	appendTimeline(deployment, event, detail)
+	dm.persist(deployment)
+}
+
+func lastHealthEvent(timeline []store.TimelineEvent) string {
+	for i := len(timeline) - 1; i >= 0; i-- {
+		if event := timeline[i].Event; event == store.TimelineHealthPassed || event == store.TimelineHealthFailed {
+			return event
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiFiles is the built-in dashboard. It only talks to the public API, so the
+// service is usable from a browser without the separate frontend project.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// registerUI serves the dashboard at / and its assets under /ui.
+func registerUI(r *gin.Engine) {
+	assets, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		log.Printf("Dashboard disabled: %v", err)
+		return
+	}
+	files := http.FS(assets)
+
+	r.StaticFS("/ui", files)
+	r.GET("/", func(c *gin.Context) {
+		c.FileFromFS("/", files)
+	})
+}
@@ -0,0 +1,298 @@
+// Built-in dashboard. It uses the same endpoints as any other client: the
+// admin listing (with the token saved in this browser), the SSE log stream
+// and the deploy, destroy and archive endpoints.
+(function () {
+    'use strict';
+
+    var tokenKey = 'djangovpc.adminToken';
+    var activeStatuses = ['queued', 'pending_approval', 'running', 'destroying', 'redeploying', 'rolling_back'];
+    var eventSource = null;
+    var currentID = null;
+
+    function $(id) {
+        return document.getElementById(id);
+    }
+
+    function adminToken() {
+        return localStorage.getItem(tokenKey) || '';
+    }
+
+    function request(method, path, body, admin) {
+        var options = {method: method, headers: {}};
+        if (body !== undefined) {
+            options.headers['Content-Type'] = 'application/json';
+            options.body = JSON.stringify(body);
+        }
+        if (admin) {
+            options.headers['Authorization'] = 'Bearer ' + adminToken();
+        }
+        return fetch(path, options).then(function (response) {
+            return response.json().catch(function () {
+                return {};
+            }).then(function (data) {
+                if (!response.ok) {
+                    throw new Error(data.error || response.statusText);
+                }
+                return data;
+            });
+        });
+    }
+
+    function cell(row, text) {
+        var td = document.createElement('td');
+        td.textContent = text || '';
+        row.appendChild(td);
+        return td;
+    }
+
+    function loadDeployments() {
+        var note = $('deployments-note');
+        var tbody = $('deployments');
+        tbody.textContent = '';
+
+        if (!adminToken()) {
+            note.textContent = 'Listing deployments needs the admin token. You can still open a deployment by ID.';
+            return;
+        }
+        note.textContent = 'Loading...';
+
+        request('GET', '/admin/deployments', undefined, true).then(function (data) {
+            note.textContent = data.total + ' deployment(s)';
+            data.deployments.sort(function (a, b) {
+                return a.start_time < b.start_time ? 1 : -1;
+            });
+            data.deployments.forEach(function (deployment) {
+                var row = document.createElement('tr');
+                cell(row, deployment.deployment_id);
+                cell(row, deployment.username);
+                cell(row, deployment.repo_url);
+                cell(row, deployment.status).className = 'status-' + deployment.status;
+                cell(row, new Date(deployment.start_time).toLocaleString());
+
+                var open = document.createElement('button');
+                open.type = 'button';
+                open.textContent = 'Logs';
+                open.addEventListener('click', function () {
+                    openDeployment(deployment.deployment_id);
+                });
+                cell(row).appendChild(open);
+                tbody.appendChild(row);
+            });
+        }).catch(function (err) {
+            note.textContent = 'Failed to list deployments: ' + err.message;
+        });
+    }
+
+    function appendLog(entry) {
+        var line = document.createElement('div');
+        line.className = 'log-' + entry.level;
+        line.textContent = (entry.timestamp || '') + ' [' + (entry.step || '') + '] ' + entry.message;
+        var logs = $('logs');
+        logs.appendChild(line);
+        logs.scrollTop = logs.scrollHeight;
+    }
+
+    function closeStream() {
+        if (eventSource) {
+            eventSource.close();
+            eventSource = null;
+        }
+    }
+
+    function showStatus(id) {
+        return request('GET', '/deploy/' + encodeURIComponent(id) + '/status', undefined, !!adminToken()).then(function (status) {
+            var text = 'Status: ' + status.status;
+            if (status.duration) {
+                text += ' (' + status.duration + ')';
+            }
+            if (status.error) {
+                text += ' - ' + status.error;
+            }
+            $('detail-status').textContent = text;
+            return status;
+        });
+    }
+
+    function openDeployment(id) {
+        closeStream();
+        currentID = id;
+        $('detail-panel').hidden = false;
+        $('detail-id').textContent = id;
+        $('logs').textContent = '';
+
+        showStatus(id).then(function (status) {
+            return streamToken(id).then(function (token) {
+                if (activeStatuses.indexOf(status.status) === -1) {
+                    // The stream only carries new messages, so a finished
+                    // deployment shows its recorded log instead.
+                    return fetch(logsURL(id, '/download?format=jsonl', token)).then(function (response) {
+                        return response.ok ? response.text() : '';
+                    }).then(function (text) {
+                        text.split('\n').forEach(function (line) {
+                            if (line) {
+                                appendLog(JSON.parse(line));
+                            }
+                        });
+                    });
+                }
+                openStream(id, logsURL(id, '', token));
+            });
+        }).catch(function (err) {
+            $('detail-status').textContent = err.message;
+        });
+    }
+
+    // streamToken asks for a stream token with the admin token or SSO
+    // cookie, since EventSource and links cannot send an Authorization
+    // header. Without one the logs are requested as they are, which works
+    // unless the server requires stream tokens. Tokens are short-lived, so
+    // each use asks for a new one.
+    function streamToken(id) {
+        return request('POST', '/deploy/' + encodeURIComponent(id) + '/stream-token', undefined, !!adminToken()).then(function (data) {
+            return data.token;
+        }).catch(function () {
+            return '';
+        });
+    }
+
+    // logsURL returns the URL of a deployment's log endpoint under
+    // /deploy/:id/logs, with token if there is one.
+    function logsURL(id, path, token) {
+        var url = '/deploy/' + encodeURIComponent(id) + '/logs' + path;
+        if (token) {
+            url += (url.indexOf('?') === -1 ? '?' : '&') + 'token=' + encodeURIComponent(token);
+        }
+        return url;
+    }
+
+    function openStream(id, url) {
+        if (currentID !== id) {
+            return;
+        }
+        eventSource = new EventSource(url);
+        eventSource.onmessage = function (event) {
+            var entry = JSON.parse(event.data);
+            if (entry.message === 'DEPLOYMENT_COMPLETE') {
+                closeStream();
+                showStatus(id);
+                loadDeployments();
+                return;
+            }
+            if (entry.step !== 'heartbeat') {
+                appendLog(entry);
+            }
+        };
+        eventSource.onerror = function () {
+            closeStream();
+            showStatus(id);
+        };
+    }
+
+    function lines(value) {
+        return value.split('\n').map(function (line) {
+            return line.trim();
+        }).filter(function (line) {
+            return line !== '';
+        });
+    }
+
+    function deployRequest(form) {
+        var body = {
+            username: form.username.value.trim(),
+            repo_url: form.repo_url.value.trim(),
+            github_token: form.github_token.value,
+            provider: form.provider.value,
+            additional_commands: lines(form.additional_commands.value),
+            env_variables: {},
+            asgi: form.asgi.checked,
+            auto_deploy: form.auto_deploy.checked
+        };
+        if (form.location.value.trim()) {
+            body.location = form.location.value.trim();
+        }
+        if (form.vm_size.value.trim()) {
+            body.vm_size = form.vm_size.value.trim();
+        }
+        if (form.disk_size_gb.value) {
+            body.disk_size_gb = parseInt(form.disk_size_gb.value, 10);
+        }
+        lines(form.env_variables.value).forEach(function (line) {
+            var eq = line.indexOf('=');
+            if (eq > 0) {
+                body.env_variables[line.slice(0, eq).trim()] = line.slice(eq + 1);
+            }
+        });
+        return body;
+    }
+
+    $('token-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        localStorage.setItem(tokenKey, $('admin-token').value);
+        $('admin-token').value = '';
+        loadDeployments();
+    });
+
+    $('refresh').addEventListener('click', loadDeployments);
+
+    $('open-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        var id = $('open-id').value.trim();
+        if (id) {
+            openDeployment(id);
+        }
+    });
+
+    $('detail-download').addEventListener('click', function (e) {
+        e.preventDefault();
+        var id = currentID;
+        if (!id) {
+            return;
+        }
+        streamToken(id).then(function (token) {
+            window.location.href = logsURL(id, '/download', token);
+        });
+    });
+
+    $('destroy-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        var id = currentID;
+        if (!id || !confirm('Destroy the infrastructure of deployment ' + id + '?')) {
+            return;
+        }
+        var query = '?keep_data_disk=' + e.target.keep_data_disk.checked + '&keep_github=' + e.target.keep_github.checked;
+        request('DELETE', '/deploy/' + encodeURIComponent(id) + query, undefined, !!adminToken()).then(function () {
+            openDeployment(id);
+            loadDeployments();
+        }).catch(function (err) {
+            alert('Failed to destroy deployment: ' + err.message);
+        });
+    });
+
+    $('archive').addEventListener('click', function () {
+        if (!currentID || !confirm('Archive deployment ' + currentID + '?')) {
+            return;
+        }
+        request('DELETE', '/deployments/' + encodeURIComponent(currentID), undefined, !!adminToken()).then(function () {
+            showStatus(currentID);
+            loadDeployments();
+        }).catch(function (err) {
+            alert('Failed to archive deployment: ' + err.message);
+        });
+    });
+
+    $('deploy-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        var result = $('deploy-result');
+        result.textContent = 'Submitting...';
+        request('POST', '/deploy', deployRequest(e.target)).then(function (data) {
+            result.textContent = data.message + ': ' + data.deployment_id;
+            e.target.github_token.value = '';
+            openDeployment(data.deployment_id);
+            loadDeployments();
+        }).catch(function (err) {
+            result.textContent = 'Deployment failed to start: ' + err.message;
+        });
+    });
+
+    loadDeployments();
+})();
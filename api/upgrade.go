@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+// configStore keeps the main.tf and playbook each deployment was
+// provisioned with.
+var configStore *store.RequestStore
+
+// configRecord is what configStore keeps per deployment. Deployments onto
+// existing servers have no main.tf; the playbook is redacted.
+type configRecord struct {
+	Versions     *providers.TerraformVersions `json:"versions,omitempty"`
+	MainTF       string                       `json:"main_tf,omitempty"`
+	MainTFHash   string                       `json:"main_tf_sha256,omitempty"`
+	Playbook     string                       `json:"playbook,omitempty"`
+	PlaybookHash string                       `json:"playbook_sha256,omitempty"`
+}
+
+// RecordTerraform stores the configuration a deployment was provisioned
+// with and notes its versions on the deployment. It starts a new record, so
+// a redeploy does not keep the previous run's playbook.
+func (dm *DeploymentManager) RecordTerraform(deploymentID string, versions providers.TerraformVersions, mainTF []byte) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	record := configRecord{Versions: &versions, MainTF: string(mainTF), MainTFHash: services.ConfigHash(mainTF)}
+	if err := configStore.Save(deploymentID, record); err != nil {
+		return err
+	}
+	if deployment, exists := dm.deployments[deploymentID]; exists {
+		deployment.Terraform = &store.TerraformVersions{Template: versions.Template, Providers: versions.Providers}
+		dm.persist(deployment)
+	}
+	return nil
+}
+
+// RecordPlaybook adds the playbook a deployment was configured with to its
+// record.
+func (dm *DeploymentManager) RecordPlaybook(deploymentID string, playbook []byte) error {
+	dm.deployMux.Lock()
+	defer dm.deployMux.Unlock()
+
+	var record configRecord
+	if err := configStore.Get(deploymentID, &record); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	record.Playbook = string(playbook)
+	record.PlaybookHash = services.ConfigHash(playbook)
+	return configStore.Save(deploymentID, record)
+}
+
+// recordedConfig returns the configuration recorded for a deployment,
+// writing the error response if there is none.
+func recordedConfig(c *gin.Context, deploymentID string) (*configRecord, bool) {
+	var record configRecord
+	if err := configStore.Get(deploymentID, &record); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No configuration was recorded for deployment " + deploymentID})
+		return nil, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &record, true
+}
+
+// handleUpgradePlan shows how the configuration this release generates for
+// a deployment differs from the one it was provisioned with. The diff is of
+// the generated main.tf; redeploying applies it.
+func handleUpgradePlan(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+
+	recorded, ok := recordedConfig(c, status.ID)
+	if !ok {
+		return
+	}
+	if recorded.Versions == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No Terraform configuration was recorded for this deployment"})
+		return
+	}
+
+	current, mainTF, ok, err := services.RenderTerraformConfig(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render current configuration: " + err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "This deployment no longer generates a Terraform configuration"})
+		return
+	}
+
+	diff := services.DiffLines(recorded.MainTF, string(mainTF), 3)
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": status.ID,
+		"recorded":      recorded.Versions,
+		"current":       current,
+		"up_to_date":    current.Template == recorded.Versions.Template && diff == "",
+		"diff":          diff,
+	})
+}
+
+// handleDeploymentConfig returns the main.tf and playbook a deployment was
+// provisioned with, with their hashes.
+func handleDeploymentConfig(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	recorded, ok := recordedConfig(c, status.ID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deployment_id": status.ID, "config": recorded})
+}
+
+// handleConfigDiff diffs the configuration a deployment was provisioned
+// with against the one this release renders from its request, or with
+// ?against=<deploymentId>, against another deployment's recorded one. It
+// shows what changed in the tool between two deployments of the same app.
+func handleConfigDiff(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentOwner(c, status) {
+		return
+	}
+	recorded, ok := recordedConfig(c, status.ID)
+	if !ok {
+		return
+	}
+
+	against := c.Query("against")
+	var other *configRecord
+	if against != "" {
+		otherStatus := deploymentManager.GetDeploymentStatus(against)
+		if otherStatus == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment " + against + " not found"})
+			return
+		}
+		if !authorizeDeploymentOwner(c, otherStatus) {
+			return
+		}
+		if other, ok = recordedConfig(c, against); !ok {
+			return
+		}
+	} else {
+		against = "current"
+		req, err := deploymentManager.Request(status.ID)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+			return
+		}
+		other = &configRecord{}
+		versions, mainTF, ok, err := services.RenderTerraformConfig(req)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render current configuration: " + err.Error()})
+			return
+		}
+		if ok {
+			other.Versions = &versions
+			other.MainTF = string(mainTF)
+			other.MainTFHash = services.ConfigHash(mainTF)
+		}
+		if recorded.Playbook != "" {
+			playbook := services.RenderPlaybook(req, status.PublicIP)
+			other.Playbook = string(playbook)
+			other.PlaybookHash = services.ConfigHash(playbook)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": status.ID,
+		"against":       against,
+		"identical":     recorded.MainTFHash == other.MainTFHash && recorded.PlaybookHash == other.PlaybookHash,
+		"main_tf": gin.H{
+			"from_sha256": recorded.MainTFHash,
+			"to_sha256":   other.MainTFHash,
+			"diff":        services.DiffLines(recorded.MainTF, other.MainTF, 3),
+		},
+		"playbook": gin.H{
+			"from_sha256": recorded.PlaybookHash,
+			"to_sha256":   other.PlaybookHash,
+			"diff":        services.DiffLines(recorded.Playbook, other.Playbook, 3),
+		},
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+const maxUptimeWindow = 90 * 24 * time.Hour
+
+// DowntimeIncident is a run of consecutive failed health checks. End is the
+// first successful check after it, or nil while the app is still down.
+type DowntimeIncident struct {
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	FailedChecks    int        `json:"failed_checks"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+type ResponseTimeStats struct {
+	P50MS float64 `json:"p50_ms"`
+	P90MS float64 `json:"p90_ms"`
+	P95MS float64 `json:"p95_ms"`
+	P99MS float64 `json:"p99_ms"`
+}
+
+type UptimeReport struct {
+	DeploymentID        string             `json:"deployment_id"`
+	WindowStart         time.Time          `json:"window_start"`
+	WindowEnd           time.Time          `json:"window_end"`
+	Checks              int                `json:"checks"`
+	FailedChecks        int                `json:"failed_checks"`
+	AvailabilityPercent *float64           `json:"availability_percent"`
+	DowntimeSeconds     float64            `json:"downtime_seconds"`
+	Incidents           []DowntimeIncident `json:"incidents"`
+	ResponseTime        *ResponseTimeStats `json:"response_time,omitempty"`
+	LastCheck           *store.HealthCheck `json:"last_check,omitempty"`
+}
+
+// parseUptimeWindow accepts Go durations plus a "d" suffix for days, e.g.
+// "24h" or "30d".
+func parseUptimeWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return 24 * time.Hour, nil
+	}
+
+	var window time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+	}
+
+	if window <= 0 || window > maxUptimeWindow {
+		return 0, fmt.Errorf("window must be between 1s and 90d")
+	}
+	return window, nil
+}
+
+// handleDeploymentUptime reports availability, downtime incidents and
+// response times from the health monitor's checks over ?window= (default
+// 24h).
+func handleDeploymentUptime(c *gin.Context) {
+	deploymentID := c.Param("deploymentId")
+
+	status := deploymentManager.GetDeploymentStatus(deploymentID)
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentView(c, status) {
+		return
+	}
+
+	window, err := parseUptimeWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+	checks, err := healthStore.List(deploymentID, start)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	report := computeUptime(checks, end)
+	report.DeploymentID = deploymentID
+	report.WindowStart = start
+	c.JSON(http.StatusOK, report)
+}
+
+func computeUptime(checks []store.HealthCheck, end time.Time) UptimeReport {
+	report := UptimeReport{
+		WindowEnd: end,
+		Checks:    len(checks),
+		Incidents: []DowntimeIncident{},
+	}
+	if len(checks) == 0 {
+		return report
+	}
+
+	var latencies []float64
+	var incident *DowntimeIncident
+	for i := range checks {
+		check := checks[i]
+		if check.OK {
+			latencies = append(latencies, float64(check.LatencyMS))
+			if incident != nil {
+				incident.End = &check.Time
+				incident.DurationSeconds = check.Time.Sub(incident.Start).Seconds()
+				report.Incidents = append(report.Incidents, *incident)
+				incident = nil
+			}
+			continue
+		}
+
+		report.FailedChecks++
+		if incident == nil {
+			incident = &DowntimeIncident{Start: check.Time}
+		}
+		incident.FailedChecks++
+		incident.LastError = check.Error
+	}
+	if incident != nil {
+		incident.DurationSeconds = end.Sub(incident.Start).Seconds()
+		report.Incidents = append(report.Incidents, *incident)
+	}
+
+	for _, incident := range report.Incidents {
+		report.DowntimeSeconds += incident.DurationSeconds
+	}
+
+	availability := 100 * float64(report.Checks-report.FailedChecks) / float64(report.Checks)
+	report.AvailabilityPercent = &availability
+
+	if len(latencies) > 0 {
+		report.ResponseTime = &ResponseTimeStats{
+			P50MS: percentile(latencies, 50),
+			P90MS: percentile(latencies, 90),
+			P95MS: percentile(latencies, 95),
+			P99MS: percentile(latencies, 99),
+		}
+	}
+
+	last := checks[len(checks)-1]
+	report.LastCheck = &last
+	return report
+}
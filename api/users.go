@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Notifications"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+var userStore *store.UserStore
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// verificationTTL is how long a verification link works.
+const verificationTTL = 24 * time.Hour
+
+func newVerificationToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate verification token: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleRegisterUser records the address a user's deployment emails go to
+// and emails it a verification link. Only the user, with a session of
+// theirs, or an admin can register an address for them.
+func handleRegisterUser(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	if req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid email is required"})
+		return
+	}
+	if session := requestSession(c); !isAdminRequest(c) && (session == nil || session.Username != req.Username) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Requires an admin or a session of " + req.Username})
+		return
+	}
+
+	existing, err := userStore.Get(req.Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil && (existing.EmailVerified || existing.SSOIdentity != "") {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already registered"})
+		return
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(verificationTTL)
+	user := &store.User{
+		Username:            req.Username,
+		Email:               address.Address,
+		VerificationHash:    hashToken(token),
+		VerificationExpires: &expires,
+		CreatedAt:           now,
+	}
+	if err := userStore.Save(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	smtpConfig := notifications.SMTPConfigFromEnv()
+	if !smtpConfig.Enabled() {
+		c.JSON(http.StatusOK, gin.H{
+			"success":            true,
+			"username":           user.Username,
+			"email_verification": "disabled",
+		})
+		return
+	}
+
+	verifyURL := fmt.Sprintf("%s/users/verify?username=%s&token=%s",
+		publicBaseURL(), url.QueryEscape(user.Username), token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your email address to receive deployment notifications:\n\n%s\n\nThe link expires in %d hours.\n",
+		user.Username, verifyURL, int(verificationTTL.Hours()))
+	if err := smtpConfig.SendEmail(user.Email, "Verify your email address", body); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Username, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":            true,
+		"username":           user.Username,
+		"email_verification": "sent",
+	})
+}
+
+func handleVerifyEmail(c *gin.Context) {
+	username := c.Query("username")
+	token := c.Query("token")
+
+	user, err := userStore.Get(username)
+	if err != nil || user == nil || user.VerificationHash == "" ||
+		user.VerificationExpires == nil || time.Now().After(*user.VerificationExpires) ||
+		subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(user.VerificationHash)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	now := time.Now()
+	user.EmailVerified = true
+	user.VerifiedAt = &now
+	user.VerificationHash = ""
+	user.VerificationExpires = nil
+	if err := userStore.Save(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"username": user.Username,
+		"email":    user.Email,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Store"
+)
+
+func newTestUserStore(t *testing.T) {
+	t.Helper()
+	users, err := store.NewUserStore(filepath.Join(t.TempDir(), "users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userStore = users
+}
+
+func register(body, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/users/register", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	handleRegisterUser(c)
+	return w
+}
+
+// TestRegisterRequiresUserOrAdmin checks that nobody can attach their
+// address to another user's deployment emails.
+func TestRegisterRequiresUserOrAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("SMTP_HOST", "")
+	newTestUserStore(t)
+	body := `{"username":"alice","email":"mallory@example.com"}`
+
+	if w := register(body, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous registration = %d, want 401: %s", w.Code, w.Body)
+	}
+	if user, _ := userStore.Get("alice"); user != nil {
+		t.Fatalf("anonymous registration stored %+v", user)
+	}
+	if w := register(body, "admin-secret"); w.Code != http.StatusOK {
+		t.Fatalf("admin registration = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// TestVerifyRejectsExpiredLink checks that a verification link stops
+// working once it expires.
+func TestVerifyRejectsExpiredLink(t *testing.T) {
+	newTestUserStore(t)
+	const token = "verification-token"
+	expired := time.Now().Add(-time.Minute)
+	if err := userStore.Save(&store.User{
+		Username:            "alice",
+		Email:               "alice@example.com",
+		VerificationHash:    hashToken(token),
+		VerificationExpires: &expired,
+		CreatedAt:           expired.Add(-verificationTTL),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/users/verify?username=alice&token="+token, nil)
+	handleVerifyEmail(c)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("verify with an expired link = %d, want 400: %s", w.Code, w.Body)
+	}
+	if user, _ := userStore.Get("alice"); user == nil || user.EmailVerified {
+		t.Fatalf("expired link verified %+v", user)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Notifications"
+	providers "sathwikshetty33/Django-vpc/Providers"
+	"sathwikshetty33/Django-vpc/Services"
+	"sathwikshetty33/Django-vpc/Store"
+	"sathwikshetty33/Django-vpc/Types"
+)
+
+var vulnStore *store.VulnerabilityStore
+
+// vulnScanInterval is how often every live deployment is scanned, daily
+// unless VULN_SCAN_INTERVAL says otherwise. Zero disables scheduled scans;
+// scans can still be started with POST /deploy/:id/vulnerabilities/scan.
+func vulnScanInterval() time.Duration {
+	if value := os.Getenv("VULN_SCAN_INTERVAL"); value != "" {
+		if value == "0" {
+			return 0
+		}
+		if interval, err := time.ParseDuration(value); err == nil && interval >= time.Hour {
+			return interval
+		}
+		log.Printf("Invalid VULN_SCAN_INTERVAL value %q, using default", value)
+	}
+	return 24 * time.Hour
+}
+
+// vulnScanTrivy reports whether VULN_SCAN_TRIVY enables scanning the
+// container images of Kubernetes deployments, which needs trivy on the
+// API server.
+func vulnScanTrivy() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("VULN_SCAN_TRIVY"))
+	return enabled
+}
+
+// vulnNotifySeverity is the lowest severity whose new findings are
+// notified, VULN_NOTIFY_SEVERITY or high.
+func vulnNotifySeverity() string {
+	if value := strings.ToLower(os.Getenv("VULN_NOTIFY_SEVERITY")); value != "" {
+		if services.SeverityRank(value) >= 0 {
+			return value
+		}
+		log.Printf("Invalid VULN_NOTIFY_SEVERITY value %q, using default", value)
+	}
+	return store.SeverityHigh
+}
+
+// vulnScans holds the deployments being scanned, so a requested scan and a
+// scheduled one never run at once.
+var vulnScans = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: make(map[string]bool)}
+
+var (
+	errScanRunning      = errors.New("a vulnerability scan is already running for this deployment")
+	errScanNotSupported = errors.New("vulnerability scans need a dedicated Azure VM, or with VULN_SCAN_TRIVY and trivy installed, a Kubernetes deployment")
+)
+
+// vulnScanners returns the scanners that apply to a deployment: pip-audit
+// inside a dedicated Azure VM, trivy on a Kubernetes deployment's image.
+func vulnScanners(status *DeploymentStatus, req *services.DeploymentRequest) []string {
+	switch services.Cloud(req) {
+	case services.CloudAzure:
+		if !req.Pooled && !req.ScaleSet {
+			return []string{services.ScannerPipAudit}
+		}
+	case services.CloudAKS:
+		if status.Image != "" && vulnScanTrivy() && services.TrivyAvailable() {
+			return []string{services.ScannerTrivy}
+		}
+	}
+	return nil
+}
+
+func runVulnerabilityScanner(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Scans run one at a time; each holds the deployment's resource
+		// group lock for a few minutes.
+		for _, deployment := range deploymentManager.ListDeployments() {
+			if deployment.Status != "completed" || deployment.ArchivedAt != nil {
+				continue
+			}
+			req, err := deploymentManager.Request(deployment.ID)
+			if err != nil || len(vulnScanners(&deployment, req)) == 0 {
+				continue
+			}
+			if err := scanVulnerabilities(&deployment, req); err != nil && !errors.Is(err, errScanRunning) {
+				log.Printf("Vulnerability scan of %s failed: %v", deployment.ID, err)
+			}
+		}
+	}
+}
+
+// scanVulnerabilities runs the deployment's scanners, stores the scan and
+// notifies findings at or above vulnNotifySeverity that the previous scan
+// did not report.
+func scanVulnerabilities(status *DeploymentStatus, req *services.DeploymentRequest) error {
+	scanners := vulnScanners(status, req)
+	if len(scanners) == 0 {
+		return errScanNotSupported
+	}
+
+	vulnScans.Lock()
+	if vulnScans.running[status.ID] {
+		vulnScans.Unlock()
+		return errScanRunning
+	}
+	vulnScans.running[status.ID] = true
+	vulnScans.Unlock()
+	defer func() {
+		vulnScans.Lock()
+		delete(vulnScans.running, status.ID)
+		vulnScans.Unlock()
+	}()
+
+	previous, err := vulnStore.Get(status.ID)
+	if err != nil {
+		log.Printf("Failed to read previous vulnerability scan of %s: %v", status.ID, err)
+	}
+	scan := &store.VulnerabilityScan{
+		DeploymentID: status.ID,
+		Status:       "running",
+		Scanners:     scanners,
+		Findings:     []store.Vulnerability{},
+		StartedAt:    time.Now(),
+	}
+	if err := vulnStore.Save(scan); err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, scanner := range scanners {
+		var (
+			findings  []store.Vulnerability
+			truncated bool
+			err       error
+		)
+		switch scanner {
+		case services.ScannerPipAudit:
+			findings, truncated, err = runPipAudit(status)
+		case services.ScannerTrivy:
+			findings, err = runTrivy(status, req)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", scanner, err))
+			continue
+		}
+		scan.Findings = append(scan.Findings, findings...)
+		scan.Truncated = scan.Truncated || truncated
+	}
+	services.ResolveSeverities(scan.Findings)
+	sortFindings(scan.Findings)
+
+	completed := time.Now()
+	scan.CompletedAt = &completed
+	scan.Status = "completed"
+	if len(failures) > 0 {
+		scan.Error = strings.Join(failures, "; ")
+		if len(failures) == len(scanners) {
+			scan.Status = "failed"
+		}
+	}
+	if err := vulnStore.Save(scan); err != nil {
+		return err
+	}
+
+	if scan.Status == "completed" {
+		if fresh := newFindings(previous, scan.Findings, vulnNotifySeverity()); len(fresh) > 0 {
+			notifyVulnerabilities(status, fresh)
+		}
+	}
+	if scan.Status == "failed" {
+		return errors.New(scan.Error)
+	}
+	return nil
+}
+
+func runPipAudit(status *DeploymentStatus) ([]store.Vulnerability, bool, error) {
+	azure, err := vmProvider(status)
+	if err != nil {
+		return nil, false, err
+	}
+	unlock := providers.LockResourceGroup(status.ResourceGroup, "vulnscan-"+status.ID, nil)
+	output, err := azure.RunShellScript(services.PipAuditScript)
+	unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	return services.ParsePipAudit(output)
+}
+
+// runTrivy scans the deployment's image, pulling it from an Azure
+// container registry with a registry token. Images in other registries
+// are pulled with the API server's own docker login.
+func runTrivy(status *DeploymentStatus, req *services.DeploymentRequest) ([]store.Vulnerability, error) {
+	var env []string
+	registry, _, _ := strings.Cut(status.Image, "/")
+	if strings.HasSuffix(registry, ".azurecr.io") {
+		cluster := &providers.AKSProvider{SubscriptionID: req.SubscriptionID, Credentials: req.AzureCredentials}
+		token, err := cluster.RegistryToken(registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get a registry token: %v", err)
+		}
+		// ACR accepts its access tokens with this null username.
+		env = append(os.Environ(), "TRIVY_USERNAME=00000000-0000-0000-0000-000000000000", "TRIVY_PASSWORD="+token)
+	}
+	return services.ScanImage(status.Image, env)
+}
+
+// sortFindings puts the most severe findings first.
+func sortFindings(findings []store.Vulnerability) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		ri, rj := services.SeverityRank(findings[i].Severity), services.SeverityRank(findings[j].Severity)
+		if ri != rj {
+			return ri > rj
+		}
+		if findings[i].Package != findings[j].Package {
+			return findings[i].Package < findings[j].Package
+		}
+		return findings[i].ID < findings[j].ID
+	})
+}
+
+func findingKey(finding store.Vulnerability) string {
+	return strings.Join([]string{finding.Source, finding.Package, finding.Version, finding.ID}, "\x00")
+}
+
+// newFindings returns the findings at or above minSeverity that the
+// previous completed scan did not report.
+func newFindings(previous *store.VulnerabilityScan, findings []store.Vulnerability, minSeverity string) []store.Vulnerability {
+	known := make(map[string]bool)
+	if previous != nil && previous.Status == "completed" {
+		for _, finding := range previous.Findings {
+			known[findingKey(finding)] = true
+		}
+	}
+	var fresh []store.Vulnerability
+	for _, finding := range findings {
+		if services.SeverityRank(finding.Severity) >= services.SeverityRank(minSeverity) && !known[findingKey(finding)] {
+			fresh = append(fresh, finding)
+		}
+	}
+	return fresh
+}
+
+func severityCounts(findings []store.Vulnerability) map[string]int {
+	counts := map[string]int{
+		store.SeverityCritical: 0,
+		store.SeverityHigh:     0,
+		store.SeverityMedium:   0,
+		store.SeverityLow:      0,
+		store.SeverityUnknown:  0,
+	}
+	for _, finding := range findings {
+		counts[finding.Severity]++
+	}
+	return counts
+}
+
+// notifyVulnerabilities reports new findings in the deployment's log, to
+// the deploying user by email if they have a verified address, and to
+// VULN_WEBHOOK_URL if set.
+func notifyVulnerabilities(status *DeploymentStatus, findings []store.Vulnerability) {
+	counts := severityCounts(findings)
+	deploymentManager.BroadcastLog(status.ID, types.LogMessage{
+		Level:     "warn",
+		Message:   fmt.Sprintf("Vulnerability scan found %d new advisories (%d critical, %d high)", len(findings), counts[store.SeverityCritical], counts[store.SeverityHigh]),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Step:      "security",
+		Code:      services.EventVulnerabilitiesFound,
+		Data:      map[string]interface{}{"findings": len(findings), "severities": counts},
+	})
+
+	reportURL := fmt.Sprintf("%s/deploy/%s/vulnerabilities", publicBaseURL(), status.ID)
+	if smtpConfig := notifications.SMTPConfigFromEnv(); smtpConfig.Enabled() {
+		if user, err := userStore.Get(status.Username); err == nil && user != nil && user.EmailVerified {
+			var body strings.Builder
+			body.WriteString(fmt.Sprintf("Deployment: %s\n", status.ID))
+			body.WriteString(fmt.Sprintf("Repository: %s\n\n", status.RepoURL))
+			body.WriteString("New vulnerabilities in the deployment's dependencies:\n")
+			for _, finding := range findings {
+				fix := "no fix yet"
+				if len(finding.FixVersions) > 0 {
+					fix = "fixed in " + strings.Join(finding.FixVersions, ", ")
+				}
+				body.WriteString(fmt.Sprintf("  [%s] %s %s: %s (%s)\n", finding.Severity, finding.Package, finding.Version, finding.ID, fix))
+			}
+			body.WriteString(fmt.Sprintf("\nFull report: %s\n", reportURL))
+
+			subject := fmt.Sprintf("Vulnerabilities found: %s", status.ID)
+			if err := smtpConfig.SendEmail(user.Email, subject, body.String()); err != nil {
+				log.Printf("Failed to send vulnerability email for %s: %v", status.ID, err)
+			}
+		}
+	}
+
+	webhookURL := os.Getenv("VULN_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	postWebhook(webhookURL, "vulnerability", status.ID, gin.H{
+		"deployment_id": status.ID,
+		"username":      status.Username,
+		"repo_url":      status.RepoURL,
+		"severities":    counts,
+		"findings":      findings,
+		"report_url":    reportURL,
+	})
+}
+
+// parseSeverityFilter reads ?severity=high,critical, the severities to
+// list, and ?min_severity=medium, the lowest one to list.
+func parseSeverityFilter(c *gin.Context) (func(string) bool, error) {
+	wanted := make(map[string]bool)
+	if value := c.Query("severity"); value != "" {
+		for _, severity := range strings.Split(value, ",") {
+			severity = strings.ToLower(strings.TrimSpace(severity))
+			if services.SeverityRank(severity) < 0 {
+				return nil, fmt.Errorf("unknown severity %q", severity)
+			}
+			wanted[severity] = true
+		}
+	}
+	minRank := 0
+	if value := c.Query("min_severity"); value != "" {
+		minRank = services.SeverityRank(strings.ToLower(value))
+		if minRank < 0 {
+			return nil, fmt.Errorf("unknown min_severity %q", value)
+		}
+	}
+	return func(severity string) bool {
+		return (len(wanted) == 0 || wanted[severity]) && services.SeverityRank(severity) >= minRank
+	}, nil
+}
+
+// handleGetVulnerabilities returns a deployment's latest scan, its findings
+// filtered by severity. The counts are of every finding. They show where
+// the deployment can be attacked, so only callers who may see it get them.
+func handleGetVulnerabilities(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if !authorizeDeploymentView(c, status) {
+		return
+	}
+	include, err := parseSeverityFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scan, err := vulnStore.Get(status.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if scan == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment has not been scanned yet"})
+		return
+	}
+
+	findings := []store.Vulnerability{}
+	for _, finding := range scan.Findings {
+		if include(finding.Severity) {
+			findings = append(findings, finding)
+		}
+	}
+	response := gin.H{
+		"deployment_id": scan.DeploymentID,
+		"status":        scan.Status,
+		"scanners":      scan.Scanners,
+		"started_at":    scan.StartedAt.Format(time.RFC3339),
+		"severities":    severityCounts(scan.Findings),
+		"findings":      findings,
+		"truncated":     scan.Truncated,
+	}
+	if scan.CompletedAt != nil {
+		response["completed_at"] = scan.CompletedAt.Format(time.RFC3339)
+	}
+	if scan.Error != "" {
+		response["error"] = scan.Error
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleScanVulnerabilities starts a scan of a deployment now; poll GET
+// /deploy/:id/vulnerabilities for the result. The scan runs over SSH on the
+// deployment's VM, so only its team, or for a personal deployment its
+// owner or an admin, can start one.
+func handleScanVulnerabilities(c *gin.Context) {
+	status := deploymentManager.GetDeploymentStatus(c.Param("deploymentId"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if status.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment is %s", status.Status)})
+		return
+	}
+	req, err := deploymentManager.Request(status.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Deployment has no stored request"})
+		return
+	}
+	if !authorizeRequestOwner(c, status, req) {
+		return
+	}
+	if len(vulnScanners(status, req)) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errScanNotSupported.Error()})
+		return
+	}
+	vulnScans.Lock()
+	running := vulnScans.running[status.ID]
+	vulnScans.Unlock()
+	if running {
+		c.JSON(http.StatusConflict, gin.H{"error": errScanRunning.Error()})
+		return
+	}
+
+	go func() {
+		if err := scanVulnerabilities(status, req); err != nil && !errors.Is(err, errScanRunning) {
+			log.Printf("Vulnerability scan of %s failed: %v", status.ID, err)
+		}
+	}()
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment_id": status.ID,
+		"status":        "running",
+		"scanners":      vulnScanners(status, req),
+	})
+}
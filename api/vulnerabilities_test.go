@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// testRequest runs handler for a request to a deployment's endpoint and
+// returns the recorded response.
+func testRequest(handler gin.HandlerFunc, method, deploymentID, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/deploy/"+deploymentID, nil)
+	if token != "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	c.Params = gin.Params{{Key: "deploymentId", Value: deploymentID}}
+	handler(c)
+	return w
+}
+
+// TestScanVulnerabilitiesRequiresOwner checks that only the owner or an
+// admin can have the server scan a personal deployment's VM.
+func TestScanVulnerabilitiesRequiresOwner(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	deploymentManager = newTestManager(t)
+	const deploymentID = "personal"
+	deploymentManager.CreateDeployment(deploymentID, &services.DeploymentRequest{Username: "alice"})
+	deploymentManager.SetDeploymentStatus(deploymentID, "completed", nil)
+
+	for _, token := range []string{"", "not-the-admin-token"} {
+		w := testRequest(handleScanVulnerabilities, http.MethodPost, deploymentID, token)
+		if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+			t.Errorf("scan with token %q = %d, want 401 or 403: %s", token, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sathwikshetty33/Django-vpc/Services"
+)
+
+// runWorkspaceJanitor keeps the work directory tree within its quota by
+// removing idle work directories, least recently used first.
+func runWorkspaceJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if quota := services.WorkspaceQuota(); quota > 0 {
+			removed, err := services.CleanupWorkspace(quota)
+			if err != nil {
+				log.Printf("Workspace cleanup failed: %v", err)
+			} else if len(removed) > 0 {
+				log.Printf("Removed %d idle work directories to stay within the %d MB workspace quota", len(removed), quota>>20)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func handleAdminWorkspace(c *gin.Context) {
+	usage, err := services.GetWorkspaceUsage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// handleAdminWorkspaceCleanup removes idle work directories, least recently
+// used first, until at most max_mb remain. Without a body every idle work
+// directory is removed.
+func handleAdminWorkspaceCleanup(c *gin.Context) {
+	var body struct {
+		MaxMB int64 `json:"max_mb"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil || body.MaxMB < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_mb must be a non-negative number"})
+			return
+		}
+	}
+
+	removed, err := services.CleanupWorkspace(body.MaxMB << 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "removed": removed})
+		return
+	}
+
+	var freed int64
+	for _, entry := range removed {
+		freed += entry.Bytes
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"removed":     removed,
+		"freed_bytes": freed,
+	})
+}
@@ -143,7 +143,7 @@ const DeploymentDashboard = () => {
         const logMessage = JSON.parse(event.data);
         
         if (logMessage.level === 'system') {
-          if (logMessage.message === 'DEPLOYMENT_COMPLETE') {
+          if (logMessage.code === 'DEPLOYMENT_COMPLETE') {
             setDeploymentStatus('completed');
             setIsConnected(false);
             eventSource.close();